@@ -1,12 +1,15 @@
 package rpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
 	"github.com/majestrate/XD/lib/config"
+	"github.com/majestrate/XD/lib/hashing"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/rpc"
 	t "github.com/majestrate/XD/lib/translate"
+	"github.com/majestrate/XD/lib/unpack"
 	"github.com/majestrate/XD/lib/util"
 	"github.com/majestrate/XD/lib/version"
 	"net/url"
@@ -14,6 +17,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func formatRate(r float64) string {
@@ -24,6 +28,10 @@ func formatRate(r float64) string {
 	return str
 }
 
+// EnvRPCUsername and EnvRPCPassword are the environmental variables to log in to the rpc as someone other than the auth user
+const EnvRPCUsername = "XD_RPC_USERNAME"
+const EnvRPCPassword = "XD_RPC_PASSWORD"
+
 // Run runs xd-cli main function
 func Run() {
 	var args []string
@@ -49,62 +57,260 @@ func Run() {
 			Host:   cfg.RPC.Bind,
 			Path:   rpc.RPCPath,
 		}
+		if username := os.Getenv(EnvRPCUsername); username != "" {
+			u.User = url.UserPassword(username, os.Getenv(EnvRPCPassword))
+		} else if cfg.RPC.Auth && cfg.RPC.Username != "" {
+			u.User = url.UserPassword(cfg.RPC.Username, cfg.RPC.Password)
+		}
 		rpcURL = u.String()
 	}
 	swarms := cfg.Bittorrent.Swarms
 	count := 0
 	switch strings.ToLower(cmd) {
 	case "list":
+		sortBy := ""
+		if len(args) > 0 {
+			sortBy = args[0]
+		}
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			listTorrents(c)
+			listTorrents(c, sortBy)
 			count++
 		}
-	case "add":
+	case "summary":
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			addTorrents(c, args...)
+			showSummary(c)
 			count++
 		}
+	case "add":
+		addTorrents(rpc.NewAutoClient(rpcURL), "", args...)
+	case "add-to":
+		if len(args) > 1 {
+			addTorrents(rpc.NewAutoClient(rpcURL), args[0], args[1:]...)
+		}
+	case "add-pinned":
+		if len(args) > 1 {
+			network := args[0]
+			if network == "any" {
+				network = ""
+			}
+			addPinnedTorrents(rpc.NewAutoClient(rpcURL), network, args[1:]...)
+		}
 	case "start":
+		startTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "stop":
+		stopTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "remove":
+		removeTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "delete":
+		deleteTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "unpack":
+		unpackTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "sequential":
+		setSequential(rpc.NewAutoClient(rpcURL), true, args...)
+	case "rarest-first":
+		setSequential(rpc.NewAutoClient(rpcURL), false, args...)
+	case "superseed":
+		setSuperseed(rpc.NewAutoClient(rpcURL), true, args...)
+	case "normal-seed":
+		setSuperseed(rpc.NewAutoClient(rpcURL), false, args...)
+	case "queue":
+		if len(args) < 2 {
+			printHelp(os.Args[0])
+			return
+		}
+		moveInQueue(rpc.NewAutoClient(rpcURL), args[0], args[1:]...)
+	case "force-start":
+		setForced(rpc.NewAutoClient(rpcURL), true, args...)
+	case "unforce":
+		setForced(rpc.NewAutoClient(rpcURL), false, args...)
+	case "priority":
+		if len(args) < 2 {
+			printHelp(os.Args[0])
+			return
+		}
+		setPriority(rpc.NewAutoClient(rpcURL), args[0], args[1:]...)
+	case "find":
+		findTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "recheck":
+		recheckTorrents(rpc.NewAutoClient(rpcURL), args...)
+	case "integrity":
+		showIntegrity(rpc.NewAutoClient(rpcURL), args...)
+	case "mask":
+		if len(args) == 1 {
+			showPieceMask(rpc.NewAutoClient(rpcURL), args[0])
+		} else if len(args) == 2 {
+			setPieceMask(rpc.NewAutoClient(rpcURL), args[0], args[1])
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "skip":
+		if len(args) == 1 {
+			showSkippedFiles(rpc.NewAutoClient(rpcURL), args[0])
+		} else if len(args) == 2 {
+			skipFiles(rpc.NewAutoClient(rpcURL), args[0], args[1])
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "file-priority":
+		if len(args) == 1 {
+			showFilePriorities(rpc.NewAutoClient(rpcURL), args[0])
+		} else if len(args) == 3 {
+			setFilePriority(rpc.NewAutoClient(rpcURL), args[0], args[1], args[2])
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "ratio":
+		if len(args) == 1 {
+			showRatioLimit(rpc.NewAutoClient(rpcURL), args[0])
+		} else if len(args) == 2 || len(args) == 3 {
+			setRatioLimit(rpc.NewAutoClient(rpcURL), args[0], args[1:]...)
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "deadline":
+		if len(args) == 2 && args[1] == "none" {
+			setPieceDeadline(rpc.NewAutoClient(rpcURL), args[0], "", "none")
+		} else if len(args) == 3 {
+			setPieceDeadline(rpc.NewAutoClient(rpcURL), args[0], args[1], args[2])
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "restore":
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			startTorrents(c, args...)
+			restoreTorrents(c, args...)
 			count++
 		}
-	case "stop":
+	case "set-piece-window":
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			stopTorrents(c, args...)
+			setPieceWindow(c, args[0])
 			count++
 		}
-	case "remove":
+	case "bandwidth":
+		if c, ok := swarmClient(rpcURL, swarms, args, 0); ok {
+			showBandwidth(c)
+		}
+	case "set-bandwidth", "set-alt-bandwidth":
+		if len(args) == 2 {
+			setBandwidth(rpc.NewClient(rpcURL, 0), cmd == "set-alt-bandwidth", args[0], args[1])
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "bandwidth-schedule":
+		setBandwidthSchedule(rpc.NewClient(rpcURL, 0), strings.Join(args, " "))
+	case "alt-bandwidth":
+		if len(args) == 1 {
+			setAltBandwidth(rpc.NewClient(rpcURL, 0), args[0])
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "identity":
+		showIdentity(rpc.NewAutoClient(rpcURL))
+	case "conns":
+		if len(args) > 0 {
+			dumpConns([]*rpc.Client{rpc.NewAutoClient(rpcURL)}, args[0])
+		} else {
+			var clients []*rpc.Client
+			for count < swarms {
+				clients = append(clients, rpc.NewClient(rpcURL, count))
+				count++
+			}
+			dumpConns(clients, "")
+		}
+	case "debug":
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			removeTorrents(c, args...)
+			showDebug(c)
 			count++
 		}
-	case "delete":
+	case "hashing":
+		if c, ok := swarmClient(rpcURL, swarms, args, 0); ok {
+			showHashingStats(c)
+		}
+	case "bench-hashing":
+		benchHashing()
+	case "history":
+		if c, ok := swarmClient(rpcURL, swarms, args, 1); ok {
+			showStatsHistory(c, args...)
+		}
+	case "logs":
+		if c, ok := swarmClient(rpcURL, swarms, args, 1); ok {
+			showLogs(c, args...)
+		}
+	case "du":
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			deleteTorrents(c, args...)
+			showDiskUsage(c)
 			count++
 		}
-	case "set-piece-window":
+	case "audit":
 		for count < swarms {
 			c := rpc.NewClient(rpcURL, count)
-			setPieceWindow(c, args[0])
+			showAuditLog(c, args...)
+			count++
+		}
+	case "dht":
+		for count < swarms {
+			c := rpc.NewClient(rpcURL, count)
+			showDHT(c)
 			count++
 		}
+	case "dht-ping":
+		for _, addr := range args {
+			pingDHTNode(rpc.NewAutoClient(rpcURL), addr)
+		}
+	case "nettest":
+		if len(args) == 1 || len(args) == 2 {
+			seconds := ""
+			if len(args) == 2 {
+				seconds = args[1]
+			}
+			runNetTest(rpc.NewAutoClient(rpcURL), args[0], seconds)
+		} else {
+			printHelp(os.Args[0])
+		}
+	case "dht-get-peers":
+		for _, ih := range args {
+			getDHTPeers(rpc.NewAutoClient(rpcURL), ih)
+		}
+	case "log-level":
+		if len(args) == 2 {
+			boostLogLevel(rpc.NewClient(rpcURL, 0), args[0], args[1])
+		} else {
+			printHelp(os.Args[0])
+		}
 	case "version":
 		fmt.Println(version.Version())
+	case "daemon-version":
+		showDaemonVersion(rpc.NewAutoClient(rpcURL))
 	case "help":
 		printHelp(os.Args[0])
 	}
 }
 
 func printHelp(cmd string) {
-	fmt.Println(t.T("usage: %s [help|version|list|add http://somesite.i2p/some.torrent|set-piece-window n|remove infohash|delete infohash|stop infohash|start infohash]", cmd))
+	fmt.Println(t.T("usage: %s [help|version|daemon-version|list [name|added|completed|active]|summary|du|debug|identity|conns [infohash]|hashing [swarm]|bench-hashing|history [hours [swarm]]|logs [n [swarm]]|audit [n]|dht|dht-ping address|dht-get-peers infohash|nettest address [seconds]|log-level debug seconds|add http://somesite.i2p/some.torrent|add-to /some/dir http://somesite.i2p/some.torrent|add-pinned [i2p|lokinet|loopback|any] http://somesite.i2p/some.torrent|set-piece-window n|bandwidth [swarm]|set-bandwidth up-KiB down-KiB|set-alt-bandwidth up-KiB down-KiB|bandwidth-schedule mon-fri 08:00-18:00, ...|alt-bandwidth [on|off|auto]|remove infohash|delete infohash|restore infohash|find infohash|recheck infohash|integrity infohash|mask infohash [pieces|none]|skip infohash [files|none]|file-priority infohash [high|normal|low files]|ratio infohash [limit [stop|remove]|default]|deadline infohash [pieces seconds|pieces none|none]|stop infohash|start infohash|sequential infohash|rarest-first infohash|superseed infohash|normal-seed infohash|priority [high|normal|low] infohash|queue [up|down|top|bottom] infohash|force-start infohash|unforce infohash|unpack infohash]", cmd))
+}
+
+// swarmClient makes a client for the swarm numbered by args[idx], swarm 0 if there is no such argument
+func swarmClient(rpcURL string, swarms int, args []string, idx int) (*rpc.Client, bool) {
+	n := 0
+	if len(args) > idx {
+		var err error
+		n, err = strconv.Atoi(args[idx])
+		if err != nil {
+			fmt.Println(t.E(err))
+			return nil, false
+		}
+		if n < 0 || n >= swarms {
+			fmt.Println(t.T("no swarm %d", n))
+			return nil, false
+		}
+	}
+	return rpc.NewClient(rpcURL, n), true
 }
 
 func setPieceWindow(c *rpc.Client, str string) {
@@ -115,10 +321,23 @@ func setPieceWindow(c *rpc.Client, str string) {
 	c.SetPieceWindow(n)
 }
 
-func addTorrents(c *rpc.Client, urls ...string) {
+func addTorrents(c *rpc.Client, dir string, urls ...string) {
+	for idx := range urls {
+		fmt.Println(t.T("fetch %s ... ", urls[idx]))
+		err := c.AddTorrentTo(urls[idx], dir)
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+// add torrents that only ever run on network, any if empty, and in the swarm they are added to
+func addPinnedTorrents(c *rpc.Client, network string, urls ...string) {
 	for idx := range urls {
 		fmt.Println(t.T("fetch %s ... ", urls[idx]))
-		err := c.AddTorrent(urls[idx])
+		err := c.AddPinnedTorrent(urls[idx], "", network, true)
 		if err == nil {
 			fmt.Println(t.T("OK"))
 		} else {
@@ -175,7 +394,669 @@ func deleteTorrents(c *rpc.Client, ih ...string) {
 	}
 }
 
-func listTorrents(c *rpc.Client) {
+func unpackTorrents(c *rpc.Client, ih ...string) {
+	for idx := range ih {
+		fmt.Println(t.T("unpack %s ... ", ih[idx]))
+		err := c.Unpack(ih[idx])
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func setSequential(c *rpc.Client, on bool, ih ...string) {
+	for idx := range ih {
+		if on {
+			fmt.Println(t.T("download %s sequentially ... ", ih[idx]))
+		} else {
+			fmt.Println(t.T("download %s rarest first ... ", ih[idx]))
+		}
+		err := c.SetSequential(ih[idx], on)
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func moveInQueue(c *rpc.Client, where string, ih ...string) {
+	for idx := range ih {
+		fmt.Println(t.T("move %s %s in the queue ... ", ih[idx], where))
+		err := c.MoveInQueue(ih[idx], "queue-"+where)
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func setForced(c *rpc.Client, on bool, ih ...string) {
+	for idx := range ih {
+		if on {
+			fmt.Println(t.T("force start %s ... ", ih[idx]))
+		} else {
+			fmt.Println(t.T("queue %s with the others ... ", ih[idx]))
+		}
+		err := c.SetForced(ih[idx], on)
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func setSuperseed(c *rpc.Client, on bool, ih ...string) {
+	for idx := range ih {
+		if on {
+			fmt.Println(t.T("super seed %s ... ", ih[idx]))
+		} else {
+			fmt.Println(t.T("seed %s normally ... ", ih[idx]))
+		}
+		err := c.SetSuperseed(ih[idx], on)
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func setPriority(c *rpc.Client, priority string, ih ...string) {
+	p, err := swarm.ParsePriority(priority)
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	for idx := range ih {
+		fmt.Println(t.T("set priority of %s to %s ... ", ih[idx], p))
+		err = c.SetPriority(ih[idx], p)
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func findTorrents(c *rpc.Client, ih ...string) {
+	for idx := range ih {
+		swarms, err := c.FindTorrent(ih[idx])
+		if err != nil {
+			fmt.Println(t.E(err))
+		} else if len(swarms) == 0 {
+			fmt.Println(t.T("%s not found", ih[idx]))
+		} else {
+			fmt.Println(t.T("%s in swarms %v", ih[idx], swarms))
+		}
+	}
+}
+
+func recheckTorrents(c *rpc.Client, ih ...string) {
+	for idx := range ih {
+		err := c.RecheckTorrent(ih[idx])
+		if err != nil {
+			fmt.Println(t.E(err))
+		} else {
+			fmt.Println(t.T("rechecking %s, bad pieces it finds are downloaded again", ih[idx]))
+		}
+	}
+}
+
+func showIntegrity(c *rpc.Client, ih ...string) {
+	for idx := range ih {
+		fmt.Println(t.T("checking %s ... ", ih[idx]))
+		report, err := c.IntegrityReport(ih[idx])
+		if err != nil {
+			fmt.Println(t.E(err))
+			continue
+		}
+		fmt.Println(t.T("%s: %d of %d pieces verified, %d failed", report.Name, report.Verified, report.Pieces, report.Failed))
+		if len(report.Regressed) > 0 {
+			fmt.Println(t.T("pieces we had that no longer verify: %v", report.Regressed))
+		}
+		for _, fname := range report.MissingFiles {
+			fmt.Println(t.T("missing file %s", fname))
+		}
+		for _, f := range report.SizeMismatch {
+			fmt.Println(t.T("%s is %d bytes, should be %d", f.Path, f.Size, f.Expected))
+		}
+		if report.Ok() {
+			fmt.Println(t.T("OK"))
+		}
+	}
+}
+
+func showPieceMask(c *rpc.Client, ih string) {
+	pieces, err := c.PieceMask(ih)
+	if err != nil {
+		fmt.Println(t.E(err))
+	} else if pieces == "" {
+		fmt.Println(t.T("%s has no masked pieces", ih))
+	} else {
+		fmt.Println(t.T("%s masked pieces: %s", ih, pieces))
+	}
+}
+
+func setPieceMask(c *rpc.Client, ih, pieces string) {
+	if pieces == "none" {
+		pieces = ""
+	}
+	fmt.Println(t.T("mask pieces of %s ... ", ih))
+	_, err := c.SetPieceMask(ih, pieces)
+	if err == nil {
+		fmt.Println(t.T("OK"))
+	} else {
+		fmt.Println(t.E(err))
+	}
+}
+
+func showSkippedFiles(c *rpc.Client, ih string) {
+	files, err := c.SkippedFiles(ih)
+	if err != nil {
+		fmt.Println(t.E(err))
+	} else if files == "" {
+		fmt.Println(t.T("%s downloads every file", ih))
+	} else {
+		fmt.Println(t.T("%s skipped files: %s", ih, files))
+	}
+}
+
+func skipFiles(c *rpc.Client, ih, files string) {
+	if files == "none" {
+		files = ""
+	}
+	fmt.Println(t.T("skip files of %s ... ", ih))
+	_, err := c.SkipFiles(ih, files)
+	if err == nil {
+		fmt.Println(t.T("OK"))
+	} else {
+		fmt.Println(t.E(err))
+	}
+}
+
+func printFilePriorities(ih, high, low string) {
+	if high == "" && low == "" {
+		fmt.Println(t.T("every file of %s is normal priority", ih))
+		return
+	}
+	if high != "" {
+		fmt.Println(t.T("%s high priority files: %s", ih, high))
+	}
+	if low != "" {
+		fmt.Println(t.T("%s low priority files: %s", ih, low))
+	}
+}
+
+func showFilePriorities(c *rpc.Client, ih string) {
+	high, low, err := c.FilePriorities(ih)
+	if err != nil {
+		fmt.Println(t.E(err))
+	} else {
+		printFilePriorities(ih, high, low)
+	}
+}
+
+func setFilePriority(c *rpc.Client, ih, priority, files string) {
+	p, err := swarm.ParsePriority(priority)
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	fmt.Println(t.T("set priority of files %s of %s to %s ... ", files, ih, p))
+	high, low, err := c.SetFilePriority(ih, files, p)
+	if err == nil {
+		fmt.Println(t.T("OK"))
+		printFilePriorities(ih, high, low)
+	} else {
+		fmt.Println(t.E(err))
+	}
+}
+
+func printRatioLimit(ih string, l swarm.RatioLimit, isDefault bool) {
+	if isDefault {
+		fmt.Println(t.T("%s share ratio limit: %s (default)", ih, l))
+	} else {
+		fmt.Println(t.T("%s share ratio limit: %s", ih, l))
+	}
+}
+
+func showRatioLimit(c *rpc.Client, ih string) {
+	l, isDefault, err := c.RatioLimit(ih)
+	if err != nil {
+		fmt.Println(t.E(err))
+	} else {
+		printRatioLimit(ih, l, isDefault)
+	}
+}
+
+func setRatioLimit(c *rpc.Client, ih string, args ...string) {
+	var l *swarm.RatioLimit
+	if args[0] != "default" {
+		ratio, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			fmt.Println(t.E(err))
+			return
+		}
+		l = &swarm.RatioLimit{Ratio: ratio}
+		if len(args) > 1 {
+			l.Action = swarm.RatioAction(args[1])
+		}
+		if !l.Valid() {
+			fmt.Println(t.E(swarm.ErrBadRatioLimit))
+			return
+		}
+	}
+	fmt.Println(t.T("set share ratio limit of %s ... ", ih))
+	err := c.SetRatioLimit(ih, l)
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	fmt.Println(t.T("OK"))
+	showRatioLimit(c, ih)
+}
+
+func setPieceDeadline(c *rpc.Client, ih, pieces, seconds string) {
+	var d time.Duration
+	if seconds != "none" {
+		f, err := strconv.ParseFloat(seconds, 64)
+		if err != nil {
+			fmt.Println(t.E(err))
+			return
+		}
+		d = time.Duration(f * float64(time.Second))
+	}
+	fmt.Println(t.T("set piece deadlines of %s ... ", ih))
+	pending, err := c.SetPieceDeadline(ih, pieces, d)
+	if err == nil {
+		fmt.Println(t.TN("OK, %d piece has a deadline", "OK, %d pieces have a deadline", pending, pending))
+	} else {
+		fmt.Println(t.E(err))
+	}
+}
+
+func restoreTorrents(c *rpc.Client, ih ...string) {
+	for idx := range ih {
+		fmt.Println(t.T("restore %s ... ", ih[idx]))
+		err := c.RestoreTorrent(ih[idx])
+		if err == nil {
+			fmt.Println(t.T("OK"))
+		} else {
+			fmt.Println(t.E(err))
+		}
+	}
+}
+
+func showDebug(c *rpc.Client) {
+	st, err := c.SwarmDebug()
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	fmt.Printf("%s: %d\n", t.T("goroutines"), st.Goroutines)
+	fmt.Printf("%s: %d/%d %s, %d %s\n", t.T("dials"), st.DialsActive, st.DialLimit, t.T("active"), st.DialsWaiting, t.T("waiting"))
+	fmt.Printf("%s: %d %s, %d %s\n", t.T("pieces"), st.PiecesPending, t.T("pending"), st.PiecesHeld, t.T("held"))
+	fmt.Printf("%s: %d %s, %d %s (%s %d)\n", t.T("send queues"), st.SendQueued, t.T("messages"), st.Peers, t.T("peers"), t.T("max"), st.SendQueuedMax)
+	fmt.Printf("%s: %d %s, %d %s\n", t.T("announces"), st.Announcing, t.T("running"), st.AnnouncesOverdue, t.T("overdue"))
+	fmt.Printf("%s: %d\n", t.T("failed piece verifications"), st.VerifyFailures)
+	fmt.Printf("%s: %d\n", t.T("torrents waiting to verify"), st.VerifyWaiting)
+	fmt.Printf("%s: %d\n", t.T("disk reads and writes queued"), st.DiskQueued)
+}
+
+func showIdentity(c *rpc.Client) {
+	id, err := c.SwarmIdentity()
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	if id.Network == "" {
+		fmt.Println(t.T("no network"))
+	} else {
+		fmt.Printf("%s: %s\n", t.T("network"), id.Network)
+		fmt.Printf("%s: %s\n", t.T("peer id"), id.PeerID)
+		fmt.Printf("%s: %s\n", t.T("address"), id.Addr)
+		if id.Dest != "" {
+			fmt.Printf("%s: %s\n", t.T("destination"), id.Dest)
+		}
+	}
+	listening := t.T("no")
+	if id.Listening {
+		listening = t.T("yes")
+	}
+	fmt.Printf("%s: %s\n", t.T("listening"), listening)
+	fmt.Printf("%s: %d %s, %d %s\n", t.T("peers"), id.InboundPeers, t.T("inbound"), id.OutboundPeers, t.T("outbound"))
+	fmt.Printf("%s: %s\n", t.T("extensions"), strings.Join(id.Extensions, ", "))
+}
+
+func showBandwidth(c *rpc.Client) {
+	s, err := c.Bandwidth()
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	fmt.Printf("%s: %s\n", t.T("normal limits"), s.Normal)
+	fmt.Printf("%s: %s\n", t.T("alternative limits"), s.Alt)
+	schedule := s.Schedule
+	if schedule == "" {
+		schedule = t.T("never")
+	}
+	fmt.Printf("%s: %s (%s)\n", t.T("alternative schedule"), schedule, s.AltMode)
+	active := t.T("normal")
+	if s.AltActive {
+		active = t.T("alternative")
+	}
+	fmt.Printf("%s: %s\n", t.T("limits in effect"), active)
+}
+
+// change the bandwidth limits with f and show what applies then
+func changeBandwidth(c *rpc.Client, f func(*swarm.BandwidthSettings) error) {
+	s, err := c.Bandwidth()
+	if err == nil {
+		err = f(&s)
+	}
+	if err == nil {
+		_, err = c.SetBandwidth(s)
+	}
+	if err != nil {
+		log.Errorf("error: %s", err)
+		return
+	}
+	showBandwidth(c)
+}
+
+// set the normal or alternative limits in KiB a second
+func setBandwidth(c *rpc.Client, alt bool, up, down string) {
+	changeBandwidth(c, func(s *swarm.BandwidthSettings) error {
+		var limits swarm.BandwidthLimits
+		n, err := strconv.ParseInt(up, 10, 64)
+		if err != nil {
+			return err
+		}
+		limits.Upload = n * 1024
+		n, err = strconv.ParseInt(down, 10, 64)
+		if err != nil {
+			return err
+		}
+		limits.Download = n * 1024
+		if alt {
+			s.Alt = limits
+		} else {
+			s.Normal = limits
+		}
+		return nil
+	})
+}
+
+func setBandwidthSchedule(c *rpc.Client, schedule string) {
+	changeBandwidth(c, func(s *swarm.BandwidthSettings) error {
+		s.Schedule = schedule
+		return nil
+	})
+}
+
+func setAltBandwidth(c *rpc.Client, mode string) {
+	changeBandwidth(c, func(s *swarm.BandwidthSettings) error {
+		s.AltMode = swarm.AltMode(mode)
+		return nil
+	})
+}
+
+func showDaemonVersion(c *rpc.Client) {
+	info, st, err := c.Version()
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	fmt.Printf("%s: %s\n", t.T("version"), info.Version)
+	if info.Git != "" {
+		fmt.Printf("%s: %s\n", t.T("git"), info.Git)
+	}
+	fmt.Printf("%s: %s %s/%s\n", t.T("built with"), info.GoVersion, info.OS, info.Arch)
+	if !st.Enabled {
+		fmt.Println(t.T("update check disabled"))
+	} else if st.Checked == 0 {
+		fmt.Println(t.T("not checked for updates yet"))
+	} else if st.Error != "" {
+		fmt.Printf("%s: %s\n", t.T("update check failed"), st.Error)
+	} else if st.Available {
+		fmt.Printf("%s: %s %s\n", t.T("update available"), st.Latest.Version, st.Latest.URL)
+		if st.Latest.Notes != "" {
+			fmt.Println(st.Latest.Notes)
+		}
+	} else {
+		fmt.Println(t.T("up to date"))
+	}
+}
+
+// print the scheduling state of connections as json for scripts
+func dumpConns(clients []*rpc.Client, ih string) {
+	states := []swarm.ConnState{}
+	for _, c := range clients {
+		st, err := c.ConnStats(ih)
+		if err != nil {
+			log.Errorf("rpc error: %s", err)
+			return
+		}
+		states = append(states, st...)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(states)
+}
+
+func showHashingStats(c *rpc.Client) {
+	st, err := c.HashingStats()
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	fmt.Printf("%s: %s\n", t.T("acceleration"), st.Acceleration)
+	fmt.Printf("sha1: %s %s\n", formatRate(st.SHA1Throughput()), t.TN("%d byte", "%d bytes", int(st.SHA1Bytes), st.SHA1Bytes))
+	fmt.Printf("sha256: %s %s\n", formatRate(st.SHA256Throughput()), t.TN("%d byte", "%d bytes", int(st.SHA256Bytes), st.SHA256Bytes))
+}
+
+func showStatsHistory(c *rpc.Client, args ...string) {
+	hours := 24
+	if len(args) > 0 {
+		var err error
+		hours, err = strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println(t.E(err))
+			return
+		}
+	}
+	now := time.Now()
+	snaps, err := c.StatsHistory(now.Add(-time.Duration(hours)*time.Hour), now)
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	for _, s := range snaps {
+		fmt.Printf("%s %s: %s %s: %s %s %s\n", s.At().Format(time.RFC3339), t.T("up"), formatRate(float64(s.TX)), t.T("down"), formatRate(float64(s.RX)), t.TN("%d peer", "%d peers", int(s.Peers), s.Peers), t.TN("%d torrent", "%d torrents", int(s.Torrents), s.Torrents))
+	}
+}
+
+func showLogs(c *rpc.Client, args ...string) {
+	n := 0
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println(t.E(err))
+			return
+		}
+	}
+	logs, err := c.GetLogs(n)
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	for _, line := range logs.Lines {
+		fmt.Println(line)
+	}
+}
+
+func showDiskUsage(c *rpc.Client) {
+	torrents, dirs, err := c.DiskUsage()
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	fmt.Println(t.T("directories:"))
+	for _, d := range dirs {
+		fmt.Printf("\t%s %s (%s %s) %s\n", util.FormatBytes(d.Used), d.Dir, t.T("size:"), util.FormatBytes(d.Size), t.TN("%d torrent", "%d torrents", d.Torrents, d.Torrents))
+	}
+	fmt.Println(t.T("torrents:"))
+	for _, u := range torrents {
+		fmt.Printf("\t%s %s [%s] (%s %s)\n", util.FormatBytes(u.Used), u.Name, u.Infohash, t.T("size:"), util.FormatBytes(u.Size))
+	}
+}
+
+func showAuditLog(c *rpc.Client, args ...string) {
+	n := 0
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println(t.E(err))
+			return
+		}
+	}
+	events, err := c.AuditLog(n)
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	for _, e := range events {
+		fmt.Printf("%s %s %s %s %s\n", e.At().Format(time.RFC3339), e.Principal, e.Action, e.Infohash, e.Detail)
+	}
+}
+
+func showDHT(c *rpc.Client) {
+	st, err := c.DHTStatus()
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	if !st.Running {
+		fmt.Println(t.T("dht is not running"))
+	}
+	if st.ID != "" {
+		fmt.Printf("%s %s %s %s\n", t.T("node:"), st.ID, t.T("address:"), st.Addr)
+	}
+	fmt.Printf("%s %s\n", t.TN("%d torrent", "%d torrents", st.Torrents, st.Torrents), t.TN("%d peer", "%d peers", st.Peers, st.Peers))
+	fmt.Println(t.T("nodes:"))
+	for _, n := range st.Nodes {
+		fmt.Printf("\t%s %s %s %d %s %s %s %d\n", n.ID, n.Addr, t.T("bucket:"), n.Bucket, t.T("seen:"), formatTime(time.Unix(n.LastSeen, 0)), t.T("fails:"), n.Fails)
+	}
+}
+
+func pingDHTNode(c *rpc.Client, addr string) {
+	id, rtt, err := c.DHTPing(addr)
+	if err != nil {
+		fmt.Printf("%s: %s\n", addr, t.E(err))
+		return
+	}
+	fmt.Printf("%s: %s %s %s\n", addr, id, t.T("rtt:"), rtt)
+}
+
+func runNetTest(c *rpc.Client, addr, seconds string) {
+	var d time.Duration
+	if seconds != "" {
+		n, err := strconv.Atoi(seconds)
+		if err != nil || n <= 0 {
+			fmt.Println(t.T("invalid number of seconds: %s", seconds))
+			return
+		}
+		d = time.Duration(n) * time.Second
+	}
+	fmt.Println(t.T("testing the network to %s ... ", addr))
+	r, err := c.NetTest(addr, d)
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
+	fmt.Printf("%s %s / %s / %s\n", t.T("rtt min/avg/max:"), r.RTTMin, r.RTTAvg, r.RTTMax)
+	fmt.Printf("%s %s (%s %s)\n", t.T("upload:"), util.FormatRate(r.UploadRate()), util.FormatBytes(r.Uploaded), r.UploadTime)
+	fmt.Printf("%s %s (%s %s)\n", t.T("download:"), util.FormatRate(r.DownloadRate()), util.FormatBytes(r.Downloaded), r.DownloadTime)
+}
+
+func getDHTPeers(c *rpc.Client, ih string) {
+	peers, nodes, err := c.DHTGetPeers(ih)
+	if err != nil {
+		fmt.Printf("%s: %s\n", ih, t.E(err))
+		return
+	}
+	fmt.Printf("%s: %s %s\n", ih, t.TN("%d peer", "%d peers", len(peers), len(peers)), t.TN("from %d node", "from %d nodes", nodes, nodes))
+	for _, p := range peers {
+		fmt.Printf("\t%s\n", p)
+	}
+}
+
+func boostLogLevel(c *rpc.Client, level, seconds string) {
+	d, err := strconv.Atoi(seconds)
+	if err == nil {
+		err = c.BoostLogLevel(level, d)
+	}
+	if err == nil {
+		fmt.Println(t.T("OK"))
+	} else {
+		fmt.Println(t.E(err))
+	}
+}
+
+func benchHashing() {
+	fmt.Println(t.T("hashing 256KB blocks ..."))
+	sha1Rate, sha256Rate := hashing.Benchmark(256*1024, time.Second*2)
+	fmt.Printf("%s: %s\n", t.T("acceleration"), hashing.Acceleration())
+	fmt.Printf("sha1: %s\n", formatRate(sha1Rate))
+	fmt.Printf("sha256: %s\n", formatRate(sha256Rate))
+}
+
+func showSummary(c *rpc.Client) {
+	torrents, err := c.GetSwarmSummary()
+	if err != nil {
+		log.Errorf("rpc error: %s", err)
+		return
+	}
+	for _, s := range torrents {
+		fmt.Printf("%s [%s] %s %.2f%% tx=%s rx=%s %s\n", s.Name, s.Infohash, formatState(s.State, s.Dormant, s.Unavailable), s.Progress*100, formatRate(s.TX), formatRate(s.RX), t.TN("%d peer", "%d peers", s.Peers, s.Peers))
+	}
+}
+
+// format when something happened, dash if it never did
+func formatTime(tm time.Time) string {
+	if tm.IsZero() {
+		return "-"
+	}
+	return tm.Format("2006-01-02 15:04")
+}
+
+// format a torrent's state, dormant and unavailable torrents say so
+func formatState(state swarm.TorrentState, dormant, unavailable bool) string {
+	if dormant {
+		return fmt.Sprintf("%s (%s)", state, t.T("dormant"))
+	}
+	if unavailable {
+		return fmt.Sprintf("%s (%s)", state, t.T("stalled, unavailable"))
+	}
+	return state.String()
+}
+
+// format a count, dash if it is negative because we don't know it
+func formatCount(n int) string {
+	if n < 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+func listTorrents(c *rpc.Client, sortBy string) {
 	var err error
 	var st swarm.SwarmStatus
 	st, err = c.GetSwarmStatus()
@@ -188,7 +1069,11 @@ func listTorrents(c *rpc.Client) {
 	for _, status := range st {
 		torrents = append(torrents, status)
 	}
-	sort.Stable(&torrents)
+	err = torrents.SortBy(sortBy)
+	if err != nil {
+		fmt.Println(t.E(err))
+		return
+	}
 	for _, status := range torrents {
 		fmt.Printf("%s [%s] %s %.2f\n", status.Name, status.Infohash, t.T("progress:"), status.Progress*100)
 		fmt.Println(t.T("peers:"))
@@ -199,12 +1084,51 @@ func listTorrents(c *rpc.Client) {
 			for len(pad) < 65 {
 				pad += " "
 			}
-			fmt.Printf("\t%stx=%s rx=%s\n", pad, formatRate(peer.TX), formatRate(peer.RX))
+			client := peer.Client
+			for len(client) < 24 {
+				client += " "
+			}
+			fmt.Printf("\t%s%s tx=%s rx=%s\n", pad, client, formatRate(peer.TX), formatRate(peer.RX))
+		}
+		fmt.Printf("%s tx=%s rx=%s (%s: %.2f)\n", formatState(status.State, status.Dormant, status.Unavailable), formatRate(status.Peers.TX()), formatRate(status.Peers.RX()), t.T("ratio"), status.Ratio())
+		fmt.Printf("%s %s\n", t.T("priority:"), status.Priority)
+		fmt.Printf("%s %s %s %s %s %.2f %s %s\n", t.T("uploaded:"), util.FormatBytes(status.Uploaded), t.T("downloaded:"), util.FormatBytes(status.Downloaded), t.T("share ratio:"), status.ShareRatio, t.T("limit:"), status.RatioLimit)
+		if status.Forced {
+			fmt.Println(t.T("force started"))
+		} else if status.QueuePosition > 0 {
+			fmt.Printf("%s %d\n", t.T("queue position:"), status.QueuePosition)
+		}
+		fmt.Printf("%s %s %s %s %s %s\n", t.T("added:"), formatTime(status.AddedAt), t.T("completed:"), formatTime(status.CompletedAt), t.T("active:"), formatTime(status.LastActive))
+		if len(status.Trackers) > 0 {
+			fmt.Println(t.T("trackers:"))
+			for _, tr := range status.Trackers {
+				fmt.Printf("\t%s %s=%s %s=%s %s=%s\n", tr.Name, t.T("seeders"), formatCount(tr.Seeders), t.T("leechers"), formatCount(tr.Leechers), t.T("completed"), formatCount(tr.Completed))
+			}
+		}
+		if len(status.WebSeeds) > 0 {
+			fmt.Println(t.T("web seeds:"))
+			for _, ws := range status.WebSeeds {
+				state := ""
+				if ws.Blacklisted {
+					state = t.T("blacklisted")
+				} else if ws.RetryAt > 0 {
+					state = t.T("retry at %s", formatTime(time.Unix(ws.RetryAt, 0)))
+				}
+				fmt.Printf("\t%s rx=%s %s=%d %s\n", ws.URL, formatRate(ws.Rate), t.T("failures"), ws.Failures, state)
+			}
+		}
+		if status.Unpack.State != unpack.None {
+			fmt.Printf("%s %s %s\n", t.T("unpack:"), status.Unpack.State, status.Unpack.Error)
 		}
-		fmt.Printf("%s tx=%s rx=%s (%s: %.2f)\n", status.State, formatRate(status.Peers.TX()), formatRate(status.Peers.RX()), t.T("ratio"), status.Ratio())
 		fmt.Println(t.T("files:"))
 		for idx, f := range status.Files {
-			fmt.Printf("\t[%d] %s (%s: %.2f)\n", idx, f.FileInfo.Path.FilePath(""), t.T("progress:"), f.Progress)
+			skipped := ""
+			if f.Skipped {
+				skipped = " " + t.T("skipped")
+			} else if f.Priority != "" && f.Priority != swarm.PriorityNormal.String() {
+				skipped = " " + t.T("%s priority", f.Priority)
+			}
+			fmt.Printf("\t[%d] %s (%s: %.2f)%s\n", idx, f.FileInfo.Path.FilePath(""), t.T("progress:"), f.Progress, skipped)
 		}
 		fmt.Println()
 	}
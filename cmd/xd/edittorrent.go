@@ -0,0 +1,191 @@
+package xd
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/majestrate/XD/lib/fs"
+	"github.com/majestrate/XD/lib/mktorrent"
+	t "github.com/majestrate/XD/lib/translate"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tracker tiers given as one flag per tier of comma separated urls
+type trackerTiers [][]string
+
+func (tt *trackerTiers) String() string {
+	var tiers []string
+	for _, tier := range *tt {
+		tiers = append(tiers, strings.Join(tier, ","))
+	}
+	return strings.Join(tiers, " ")
+}
+
+func (tt *trackerTiers) Set(v string) error {
+	var tier []string
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			tier = append(tier, u)
+		}
+	}
+	*tt = append(*tt, tier)
+	return nil
+}
+
+// parse a creation date as unix seconds or RFC 3339, "none" removes it
+func parseCreationDate(v string) (time.Time, error) {
+	if v == "none" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// retag or retracker a torrent file, run as xd edit-torrent [options] file.torrent
+func runEditTorrent(args []string) (err error) {
+	flags := flag.NewFlagSet("edit-torrent", flag.ContinueOnError)
+	var trackers trackerTiers
+	flags.Var(&trackers, "tracker", "a tier of comma separated tracker urls, repeat for more tiers, replaces the trackers")
+	noTrackers := flags.Bool("no-trackers", false, "remove all trackers")
+	comment := flags.String("comment", "", "comment")
+	createdBy := flags.String("created-by", "", "what made the torrent")
+	creationDate := flags.String("creation-date", "", "creation date as unix seconds or RFC 3339, none to remove it")
+	source := flags.String("source", "", "source tag, changes the infohash")
+	private := flags.Bool("private", false, "make the torrent private or public, changes the infohash")
+	out := flags.String("o", "", "where to write the edited torrent, the torrent file itself if empty")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New(t.T("expected one torrent file"))
+	}
+	fname := flags.Arg(0)
+	var edit mktorrent.Edit
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tracker":
+			edit.Trackers = trackers
+		case "no-trackers":
+			if *noTrackers {
+				edit.Trackers = [][]string{}
+			}
+		case "comment":
+			edit.Comment = comment
+		case "created-by":
+			edit.CreatedBy = createdBy
+		case "creation-date":
+			var date time.Time
+			date, err = parseCreationDate(*creationDate)
+			edit.CreationDate = &date
+		case "source":
+			edit.Source = source
+		case "private":
+			edit.Private = private
+		}
+	})
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = ioutil.ReadFile(fname)
+	if err != nil {
+		return
+	}
+	data, ih, rehashed, err := edit.Apply(data)
+	if err != nil {
+		return
+	}
+	if *out == "" {
+		*out = fname
+	}
+	err = writeTorrent(*out, data)
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s: %s\n", t.T("wrote"), *out)
+	if rehashed {
+		fmt.Printf("%s: %s\n", t.T("new infohash"), ih.Hex())
+	}
+	return
+}
+
+// write a torrent file next to where it goes first so a failed write leaves the old file alone
+func writeTorrent(fname string, data []byte) (err error) {
+	tmp := filepath.Join(filepath.Dir(fname), "."+filepath.Base(fname)+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, fname)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// make a torrent of a file, run as xd make-torrent [options] file
+func runMakeTorrent(args []string) (err error) {
+	flags := flag.NewFlagSet("make-torrent", flag.ContinueOnError)
+	var opts mktorrent.Options
+	var trackers trackerTiers
+	flags.Var(&trackers, "tracker", "a tier of comma separated tracker urls, repeat for more tiers")
+	pieceLength := flags.Uint("piece-length", 256, "piece length in KiB")
+	flags.StringVar(&opts.Comment, "comment", "", "comment")
+	flags.StringVar(&opts.CreatedBy, "created-by", "", "what made the torrent, our version if empty")
+	creationDate := flags.String("creation-date", "", "creation date as unix seconds or RFC 3339, none to leave it out, now if empty")
+	flags.StringVar(&opts.Source, "source", "", "source tag")
+	flags.BoolVar(&opts.Private, "private", false, "make the torrent private")
+	out := flags.String("o", "", "where to write the torrent, the file name with .torrent if empty")
+	err = flags.Parse(args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New(t.T("expected one file"))
+	}
+	fname := flags.Arg(0)
+	opts.Trackers = trackers
+	opts.PieceLength = uint32(*pieceLength) * 1024
+	if opts.PieceLength == 0 {
+		return errors.New(t.T("piece length must not be 0"))
+	}
+	if *creationDate != "" {
+		opts.CreationDate, err = parseCreationDate(*creationDate)
+		if err != nil {
+			return
+		}
+		opts.NoCreationDate = opts.CreationDate.IsZero()
+	}
+	tf, err := mktorrent.MakeTorrentWith(fs.STD, fname, opts)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = tf.BEncode(&buf); err != nil {
+		return
+	}
+	if *out == "" {
+		*out = filepath.Base(fname) + ".torrent"
+	}
+	err = writeTorrent(*out, buf.Bytes())
+	if err == nil {
+		fmt.Printf("%s: %s %s\n", t.T("wrote"), *out, tf.Infohash().Hex())
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package xd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/config"
+	"github.com/majestrate/XD/lib/network/i2p"
+	t "github.com/majestrate/XD/lib/translate"
+	"github.com/majestrate/XD/lib/util"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long we wait for the i2p router when checking the SAM address
+const setupPingTimeout = time.Second * 5
+
+// i2cp options for tunnels asked about during setup
+var setupTunnelOptions = []string{
+	"inbound.length",
+	"outbound.length",
+	"inbound.quantity",
+	"outbound.quantity",
+}
+
+// errSetupInput is returned when stdin closes before setup asked everything
+var errSetupInput = errors.New("input ended before setup was done")
+
+type setupPrompt struct {
+	r *bufio.Reader
+	// set once reading an answer failed, every question after it gets its default
+	err error
+}
+
+// ask a question with a default answer, returns the default on empty input
+func (p *setupPrompt) ask(question, def string) string {
+	if p.err != nil {
+		return def
+	}
+	if def == "" {
+		fmt.Printf("%s: ", question)
+	} else {
+		fmt.Printf("%s [%s]: ", question, def)
+	}
+	line, err := p.r.ReadString(10)
+	line = strings.TrimSpace(line)
+	if err != nil && line == "" {
+		// stdin closed, we would ask the same questions forever
+		fmt.Println()
+		p.err = errSetupInput
+		return def
+	}
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+// ask a yes or no question
+func (p *setupPrompt) yes(question string, def bool) bool {
+	d := "n"
+	if def {
+		d = "y"
+	}
+	for p.err == nil {
+		a := strings.ToLower(p.ask(question+" (y/n)", d))
+		if a == "y" || a == "yes" {
+			return true
+		}
+		if a == "n" || a == "no" {
+			return false
+		}
+	}
+	return def
+}
+
+// ask for a non-negative number, empty is allowed and returned as empty
+func (p *setupPrompt) number(question, def string) string {
+	for p.err == nil {
+		a := p.ask(question, def)
+		if a == "" {
+			return a
+		}
+		if i, err := strconv.Atoi(a); err == nil && i >= 0 {
+			return a
+		}
+		fmt.Println(t.T("please enter a number"))
+	}
+	return def
+}
+
+// runSetup interactively builds a config and writes it to fname
+func runSetup(fname string) (err error) {
+	p := &setupPrompt{r: bufio.NewReader(os.Stdin)}
+	conf := new(config.Config)
+	exists := util.CheckFile(fname)
+	if exists {
+		if !p.yes(t.T("%s already exists, use it for the defaults and overwrite it?", fname), true) {
+			return
+		}
+	}
+	// loads defaults if the file does not exist
+	err = conf.Load(fname)
+	if err != nil {
+		return
+	}
+
+	fmt.Println(t.T("-- i2p --"))
+	conf.I2P.Disabled = !p.yes(t.T("use i2p"), !conf.I2P.Disabled)
+	if !conf.I2P.Disabled {
+		for {
+			conf.I2P.Addr = p.ask(t.T("i2p router SAM address"), conf.I2P.Addr)
+			fmt.Println(t.T("checking i2p router at %s ...", conf.I2P.Addr))
+			ver, e := i2p.Ping(conf.I2P.Addr, setupPingTimeout)
+			if e == nil {
+				fmt.Println(t.T("i2p router is reachable, SAM version %s", ver))
+				break
+			}
+			fmt.Println(t.T("cannot reach i2p router: %s", e.Error()))
+			if !p.yes(t.T("try another address"), true) || p.err != nil {
+				break
+			}
+		}
+		if conf.I2P.I2CPOptions == nil {
+			conf.I2P.I2CPOptions = make(map[string]string)
+		}
+		fmt.Println(t.T("tunnel settings, leave empty to use the router defaults"))
+		for _, opt := range setupTunnelOptions {
+			val := p.number(opt, conf.I2P.I2CPOptions[opt])
+			if val == "" {
+				delete(conf.I2P.I2CPOptions, opt)
+			} else {
+				conf.I2P.I2CPOptions[opt] = val
+			}
+		}
+	}
+
+	fmt.Println(t.T("-- storage --"))
+	root := p.ask(t.T("data directory"), conf.Storage.Root)
+	if root != conf.Storage.Root {
+		conf.Storage.SetRoot(root)
+	}
+
+	fmt.Println(t.T("-- rpc --"))
+	conf.RPC.Enabled = p.yes(t.T("enable rpc"), conf.RPC.Enabled)
+	if conf.RPC.Enabled {
+		for {
+			conf.RPC.Bind = p.ask(t.T("rpc bind address (host:port or unix:/path)"), conf.RPC.Bind)
+			if strings.HasPrefix(conf.RPC.Bind, "unix:") || p.err != nil {
+				break
+			}
+			host, _, e := net.SplitHostPort(conf.RPC.Bind)
+			if e == nil {
+				conf.RPC.ExpectedHost = host
+				break
+			}
+			fmt.Println(t.T("invalid address: %s", e.Error()))
+		}
+		conf.RPC.Auth = p.yes(t.T("require rpc authentication"), conf.RPC.Auth)
+		if conf.RPC.Auth {
+			for {
+				conf.RPC.Username = p.ask(t.T("rpc username"), conf.RPC.Username)
+				conf.RPC.Password = p.ask(t.T("rpc password"), conf.RPC.Password)
+				if (conf.RPC.Username != "" && conf.RPC.Password != "") || p.err != nil {
+					break
+				}
+				fmt.Println(t.T("username and password must not be empty"))
+			}
+		}
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+	err = conf.Save(fname)
+	if err == nil {
+		errs := config.Check(fname)
+		if len(errs) > 0 {
+			err = errs[0]
+		}
+	}
+	if err == nil {
+		fmt.Println(t.T("wrote config to %s, start XD with: %s %s", fname, os.Args[0], fname))
+	}
+	return
+}
@@ -2,10 +2,13 @@ package xd
 
 import (
 	"bufio"
+	cli "github.com/majestrate/XD/cmd/rpc"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
 	"github.com/majestrate/XD/lib/config"
 	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network/loopback"
 	"github.com/majestrate/XD/lib/rpc"
+	"github.com/majestrate/XD/lib/stats"
 	"github.com/majestrate/XD/lib/sync"
 	t "github.com/majestrate/XD/lib/translate"
 	"github.com/majestrate/XD/lib/util"
@@ -26,7 +29,8 @@ type httpRPC struct {
 }
 
 func printHelp(cmd string) {
-	log.Infof("usage: %s [config.ini] | --genconf config.ini\n", cmd)
+	log.Infof("usage: %s [config.ini] | --genconf config.ini | config check [config.ini] | setup [config.ini] | make-torrent [options] file | edit-torrent [options] file.torrent | nettest address [seconds]\n", cmd)
+	log.Info("config files ending in .toml, .yaml or .yml are read as toml or yaml")
 }
 
 func NewContext() *Context {
@@ -36,6 +40,7 @@ func NewContext() *Context {
 		pw:      pw,
 		sigchnl: make(chan os.Signal),
 		netlost: true,
+		index:   swarm.NewIndex(),
 	}
 }
 
@@ -46,6 +51,7 @@ type Context struct {
 	numClosers int
 	quit       bool
 	swarms     []*swarm.Swarm
+	index      *swarm.Index
 	sigchnl    chan os.Signal
 	netlost    bool
 }
@@ -108,6 +114,7 @@ func (c *Context) ReplaceCloser(id int, cl io.Closer) {
 }
 
 func (c *Context) AddSwarm(sw *swarm.Swarm) {
+	c.index.Add(sw)
 	c.swarms = append(c.swarms, sw)
 }
 
@@ -155,6 +162,59 @@ func Run() {
 		return
 	}
 
+	if fname == "setup" {
+		fname = "torrents.ini"
+		if len(os.Args) > 2 {
+			fname = os.Args[2]
+		}
+		err = runSetup(fname)
+		if err != nil {
+			log.Errorf("setup failed: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if fname == "make-torrent" {
+		err = runMakeTorrent(os.Args[2:])
+		if err != nil {
+			log.Errorf("make-torrent failed: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if fname == "edit-torrent" {
+		err = runEditTorrent(os.Args[2:])
+		if err != nil {
+			log.Errorf("edit-torrent failed: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if fname == "nettest" {
+		// asks the running XD, the test goes over its tunnels
+		cli.Run()
+		return
+	}
+	if fname == "config" {
+		if len(os.Args) > 2 && os.Args[2] == "check" {
+			fname = "torrents.ini"
+			if len(os.Args) > 3 {
+				fname = os.Args[3]
+			}
+			errs := config.Check(fname)
+			for _, e := range errs {
+				log.Error(e.Error())
+			}
+			if len(errs) > 0 {
+				os.Exit(1)
+			}
+			log.Infof("%s is valid", fname)
+		} else {
+			printHelp(os.Args[0])
+		}
+		return
+	}
+
 	log.Info(t.T("starting %s", v))
 	if !util.CheckFile(fname) {
 		conf.Load(fname)
@@ -212,7 +272,7 @@ func Run() {
 	for _, t := range ts {
 		for _, sw := range ctx.swarms {
 			err = sw.AddTorrent(t)
-			if err != nil {
+			if err != nil && err != swarm.ErrPinned {
 				log.Errorf("error adding torrent: %s", err)
 			}
 		}
@@ -236,6 +296,36 @@ func Run() {
 		}
 	}()
 
+	// swarm statistics snapshots for graphs
+	go func() {
+		for ctx.Running() {
+			time.Sleep(stats.DefaultSnapshotInterval)
+			var snap stats.Snapshot
+			for _, sw := range ctx.swarms {
+				s := sw.Snapshot()
+				snap.Time = s.Time
+				snap.TX += s.TX
+				snap.RX += s.RX
+				snap.Peers += s.Peers
+				// every swarm has all the torrents
+				if s.Torrents > snap.Torrents {
+					snap.Torrents = s.Torrents
+				}
+			}
+			e := st.PutSnapshot(snap)
+			if e != nil {
+				log.Warnf("failed to save stats snapshot: %s", e.Error())
+			}
+		}
+	}()
+
+	// release feed checker
+	updates := conf.Update.CreateChecker(ctx.swarms[0].Network)
+	if updates != nil {
+		log.Infof("checking %s for updates", updates.Feed)
+		go updates.Run(ctx.Running)
+	}
+
 	// start rpc server
 	if conf.RPC.Enabled {
 		log.Infof("RPC enabled")
@@ -260,8 +350,33 @@ func Run() {
 		}
 		if e == nil {
 			ctx.AddCloser(l)
+			server := rpc.NewServer(ctx.index, host)
+			var users []rpc.User
+			for _, u := range conf.RPC.Accounts() {
+				users = append(users, rpc.User{
+					Name:     u.Name,
+					Password: u.Password,
+					Admin:    u.Admin,
+				})
+			}
+			if len(users) > 0 {
+				log.Infof("rpc needs a login, %d users", len(users))
+				if strings.HasPrefix(conf.RPC.Bind, "unix:") {
+					log.Warn("rpc clients can't log in over a unix socket, bind the rpc to a tcp address")
+				}
+				server.EnableUsers(users)
+			}
+			server.SetUpdateChecker(updates)
+			if conf.RPC.Pprof {
+				if conf.RPC.Auth && conf.RPC.Username != "" && conf.RPC.Password != "" {
+					log.Infof("serving pprof at %s", rpc.DebugPath)
+					server.EnablePprof(conf.RPC.Username, conf.RPC.Password)
+				} else {
+					log.Warn("not serving pprof on rpc, it needs rpc auth with a username and password")
+				}
+			}
 			s := &http.Server{
-				Handler: rpc.NewServer(ctx.swarms, host),
+				Handler: server,
 			}
 			go func(serv *http.Server) {
 				log.Errorf("rpc died: %s", serv.Serve(l))
@@ -273,6 +388,13 @@ func Run() {
 	}
 
 	runLokiNetFunc := func(netConf config.LokiNetConfig, sw *swarm.Swarm) {
+		tr, err := netConf.PeerTransport()
+		if err != nil {
+			// don't fall back to plain connections if we were asked to hide them
+			log.Errorf("bad lokinet peer transport: %s", err.Error())
+			return
+		}
+		sw.SetPeerTransport(tr)
 		for sw.Running() {
 			n, err := netConf.CreateSession()
 			if err != nil {
@@ -304,6 +426,13 @@ func Run() {
 	}
 
 	runI2PFunc := func(netConf config.I2PConfig, sw *swarm.Swarm) {
+		tr, err := netConf.PeerTransport()
+		if err != nil {
+			// don't fall back to plain connections if we were asked to hide them
+			log.Errorf("bad i2p peer transport: %s", err.Error())
+			return
+		}
+		sw.SetPeerTransport(tr)
 		n := netConf.CreateSession()
 		id := ctx.AddCloser(n)
 		for sw.Running() {
@@ -331,8 +460,39 @@ func Run() {
 		}
 	}
 
+	hub := loopback.NewHub()
+	runLoopbackFunc := func(netConf config.LoopbackConfig, idx int, sw *swarm.Swarm) {
+		n := netConf.CreateSession(hub, idx)
+		ctx.AddCloser(n)
+		for sw.Running() {
+			log.Info("opening loopback session")
+			err := n.Open()
+			if err == nil {
+				log.Infof("loopback session %s made, we are %s", n.Name(), n.Addr())
+				sw.ObtainedNetwork(n)
+				if idx > 0 {
+					go joinLoopbackDHT(sw, ctx.swarms[0])
+				}
+				ctx.netlost = false
+				err = sw.Run()
+				if err != nil {
+					ctx.netlost = true
+					log.Errorf("lost loopback session: %s", err)
+					sw.LostNetwork()
+					n.Close()
+				}
+			} else {
+				ctx.netlost = true
+				log.Errorf("failed to open loopback session: %s", err)
+				time.Sleep(time.Second)
+			}
+		}
+	}
+
 	for idx := range ctx.swarms {
-		if conf.I2P.Disabled {
+		if conf.Loopback.Enabled {
+			go runLoopbackFunc(conf.Loopback, idx, ctx.swarms[idx])
+		} else if conf.I2P.Disabled {
 			if !conf.LokiNet.Disabled {
 				go runLokiNetFunc(conf.LokiNet, ctx.swarms[idx])
 			}
@@ -344,3 +504,22 @@ func Run() {
 	go ctx.RunSignals()
 	ctx.Run()
 }
+
+// put the dht of sw in touch with the dht of the first swarm on the loopback network
+func joinLoopbackDHT(sw, first *swarm.Swarm) {
+	if !sw.DHT().Enabled() {
+		return
+	}
+	for sw.Running() {
+		time.Sleep(time.Second)
+		st := first.DHT().Status()
+		if !st.Running || !sw.DHT().Status().Running {
+			continue
+		}
+		_, _, err := sw.DHT().Ping(st.Addr)
+		if err == nil {
+			return
+		}
+		log.Warnf("failed to join the loopback dht: %s", err.Error())
+	}
+}
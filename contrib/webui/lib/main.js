@@ -28,6 +28,15 @@ var Torrent = function(data) {
     this.State = data.State;
     this.Infohash = data.Infohash;
     this.Peers = function() { return data.Peers ? data.Peers.length : 0; };
+    // what software the peers run, most common first
+    this.Clients = function() {
+        var counts = {};
+        if (data.Peers)
+            data.Peers.forEach(function(p){ counts[p.Client] = (counts[p.Client] || 0) + 1; });
+        return Object.keys(counts).sort(function(a, b) { return counts[b] - counts[a]; }).map(function(c) {
+            return counts[c] + " " + c;
+        }).join(", ");
+    };
     this.Speed = function() {
         var tx = 0, rx = 0;
         if (data.Peers)
@@ -149,6 +158,18 @@ var viewModel = {
             function(data){ console.log(data); });
     },
     torrentStates: ['all', 'downloading', 'seeding'],
+    // newest release when there is one newer than the daemon, null otherwise
+    update: ko.observable(null),
+    checkUpdate: function()
+    {
+        var _this = this;
+        this._apicall({method: "XD.Version"}, function(data){
+            if (data.update && data.update.Available)
+                _this.update(data.update.Latest);
+            else
+                _this.update(null);
+        });
+    },
     globalInfo: function()
     {
         var rx = 0;
@@ -196,4 +217,5 @@ window.onload = function()
 {
     ko.applyBindings(viewModel);
     main(); setInterval(main, 1000);
+    viewModel.checkUpdate(); setInterval(function() { viewModel.checkUpdate(); }, 600000);
 }
@@ -10,6 +10,6 @@ require (
 	github.com/pkg/sftp v1.12.0
 	github.com/zeebo/bencode v1.0.0
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
-	golang.org/x/sys v0.0.0-20201017003518-b09fb700fbb7 // indirect
+	golang.org/x/sys v0.0.0-20201017003518-b09fb700fbb7
 	gopkg.in/leonelquinteros/gotext.v1 v1.3.1
 )
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultLogSize is how many events we keep by default
+const DefaultLogSize = 1000
+
+// what was done
+const (
+	ActionAdded    = "added"
+	ActionRemoved  = "removed"
+	ActionDeleted  = "deleted"
+	ActionRestored = "restored"
+	// a torrent was started, stopped or had its settings changed
+	ActionChanged = "changed"
+	// a setting of the whole swarm was changed
+	ActionConfig = "config"
+	ActionBan    = "ban"
+	// no peer had the pieces a torrent needs for a long time
+	ActionUnavailable = "unavailable"
+)
+
+// PrincipalLocal is who did things we did ourselves or that came over a local socket without a username
+const PrincipalLocal = "local"
+
+// Event is one significant action in the audit log
+type Event struct {
+	// unix seconds
+	Time int64 `json:"time"`
+	// rpc username or remote address of who did it
+	Principal string `json:"principal"`
+	Action    string `json:"action"`
+	// torrent it was done to, empty if it was not about a torrent
+	Infohash string `json:"infohash,omitempty"`
+	// what exactly was done, like the url of an added torrent
+	Detail string `json:"detail,omitempty"`
+}
+
+// At gets when the event happened
+func (e Event) At() time.Time {
+	return time.Unix(e.Time, 0)
+}
+
+// Principal gets who made an rpc request, the basic auth username if there is one otherwise where it came from
+func Principal(req *http.Request) string {
+	if u, _, ok := req.BasicAuth(); ok && u != "" {
+		return u
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		// unix socket
+		return PrincipalLocal
+	}
+	return host
+}
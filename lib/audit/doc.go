@@ -0,0 +1,2 @@
+// audit log of significant actions taken on a swarm and who took them
+package audit
@@ -0,0 +1,82 @@
+package bittorrent
+
+import (
+	"github.com/majestrate/XD/lib/sync"
+)
+
+// Availability counts how many peers have each piece of a torrent
+// it is updated as peers send us bitfields and haves so finding the rarest piece doesn't need to look at every peer
+type Availability struct {
+	access sync.Mutex
+	counts []uint32
+}
+
+// NewAvailability creates an Availability for a torrent with a number of pieces
+func NewAvailability(pieces uint32) *Availability {
+	return &Availability{
+		counts: make([]uint32, pieces),
+	}
+}
+
+// Add counts all pieces in a peer's bitfield
+func (a *Availability) Add(bf *Bitfield) {
+	a.access.Lock()
+	bf.ForEachSet(func(idx uint32) {
+		if idx < uint32(len(a.counts)) {
+			a.counts[idx]++
+		}
+	})
+	a.access.Unlock()
+}
+
+// Remove uncounts all pieces in a peer's bitfield, used when the peer goes away
+func (a *Availability) Remove(bf *Bitfield) {
+	a.access.Lock()
+	bf.ForEachSet(func(idx uint32) {
+		if idx < uint32(len(a.counts)) && a.counts[idx] > 0 {
+			a.counts[idx]--
+		}
+	})
+	a.access.Unlock()
+}
+
+// Have counts one more peer having a piece
+func (a *Availability) Have(idx uint32) {
+	a.access.Lock()
+	if idx < uint32(len(a.counts)) {
+		a.counts[idx]++
+	}
+	a.access.Unlock()
+}
+
+// DontHave counts one less peer having a piece
+func (a *Availability) DontHave(idx uint32) {
+	a.access.Lock()
+	if idx < uint32(len(a.counts)) && a.counts[idx] > 0 {
+		a.counts[idx]--
+	}
+	a.access.Unlock()
+}
+
+// Count returns how many peers have a piece
+func (a *Availability) Count(idx uint32) (n uint32) {
+	a.access.Lock()
+	n = a.count(idx)
+	a.access.Unlock()
+	return
+}
+
+func (a *Availability) count(idx uint32) uint32 {
+	if idx < uint32(len(a.counts)) {
+		return a.counts[idx]
+	}
+	return 0
+}
+
+// snapshot copies the counts so they can be read without holding the lock
+func (a *Availability) snapshot() (counts []uint32) {
+	a.access.Lock()
+	counts = append(counts, a.counts...)
+	a.access.Unlock()
+	return
+}
@@ -2,10 +2,13 @@ package bittorrent
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"github.com/majestrate/XD/lib/common"
 	"io"
+	"math/bits"
+	"math/rand"
 
 	"github.com/zeebo/bencode"
 )
@@ -83,16 +86,33 @@ func (bf *Bitfield) Zero() {
 // Inverted gets copy of current Bitfield with all bits inverted
 func (bf *Bitfield) Inverted() (i *Bitfield) {
 	i = NewBitfield(bf.Length, nil)
-	bit := uint32(0)
-	for bit < bf.Length {
-		if !bf.Has(bit) {
-			i.Set(bit)
-		}
-		bit++
+	full, tail := bf.split()
+	for idx := 0; idx < full; idx++ {
+		i.Data[idx] = ^bf.byteAt(idx)
+	}
+	if tail != 0 {
+		i.Data[full] = ^bf.byteAt(full) & tail
+	}
+	return
+}
+
+// split returns how many whole bytes hold bits and the mask of bits used in the last partial byte
+func (bf *Bitfield) split() (full int, tail byte) {
+	full = int(bf.Length >> 3)
+	if rem := bf.Length & 7; rem != 0 {
+		tail = 0xff << (8 - rem)
 	}
 	return
 }
 
+// byteAt gets the byte at idx, zero if the data is short
+func (bf *Bitfield) byteAt(idx int) byte {
+	if idx < len(bf.Data) {
+		return bf.Data[idx]
+	}
+	return 0
+}
+
 // AND returns copy of Bitfield with bitwise AND applied from other Bitfield
 func (bf *Bitfield) AND(other *Bitfield) *Bitfield {
 	if bf.Length == other.Length {
@@ -208,13 +228,20 @@ func (bf *Bitfield) Set(index uint32) {
 
 // CountSet counts how many bits are set
 func (bf *Bitfield) CountSet() (sum int) {
-	l := bf.Length
-	for l > 0 {
-		l--
-		// TODO: make this less horrible
-		if bf.Has(l) {
-			sum++
-		}
+	full, tail := bf.split()
+	data := bf.Data
+	if full < len(data) {
+		data = data[:full]
+	}
+	for len(data) >= 8 {
+		sum += bits.OnesCount64(binary.BigEndian.Uint64(data))
+		data = data[8:]
+	}
+	for _, b := range data {
+		sum += bits.OnesCount8(b)
+	}
+	if tail != 0 {
+		sum += bits.OnesCount8(bf.byteAt(full) & tail)
 	}
 	return
 }
@@ -233,45 +260,102 @@ func (bf *Bitfield) Has(index uint32) bool {
 
 // Completed returns true if this Bitfield is 100% set
 func (bf *Bitfield) Completed() bool {
-	l := bf.Length
-	for l > 0 {
-		l--
-		// TODO: make this less horrible
-		if !bf.Has(l) {
+	full, tail := bf.split()
+	if len(bf.Data) < full {
+		return false
+	}
+	data := bf.Data[:full]
+	for len(data) >= 8 {
+		if binary.BigEndian.Uint64(data) != ^uint64(0) {
+			return false
+		}
+		data = data[8:]
+	}
+	for _, b := range data {
+		if b != 0xff {
 			return false
 		}
 	}
-	return true
+	return bf.byteAt(full)&tail == tail
 }
 
-type rareSet map[uint32]uint32
+// ForEachSet calls visit with the index of every set bit in order, skipping empty words
+func (bf *Bitfield) ForEachSet(visit func(uint32)) {
+	full, tail := bf.split()
+	n := full
+	if tail != 0 {
+		n++
+	}
+	if n > len(bf.Data) {
+		n = len(bf.Data)
+	}
+	for idx := 0; idx < n; idx += 8 {
+		if idx+8 <= n && binary.BigEndian.Uint64(bf.Data[idx:]) == 0 {
+			continue
+		}
+		end := idx + 8
+		if end > n {
+			end = n
+		}
+		for b := idx; b < end; b++ {
+			v := bf.Data[b]
+			if b == full {
+				v &= tail
+			}
+			for v != 0 {
+				lz := bits.LeadingZeros8(v)
+				visit(uint32(b*8 + lz))
+				v &= ^(0x80 >> uint(lz))
+			}
+		}
+	}
+}
 
 // FindRarest finds the set bit we have that is rarest in others
 func (bf *Bitfield) FindRarest(others []*Bitfield, exclude func(uint32) bool) (idx uint32, has bool) {
-	bits := make(rareSet)
-	i := bf.Length
-
-	for i > 0 {
-		i--
-		bits[i] = 0
-		for _, other := range others {
-			if other.Has(i) {
-				bits[i]++
-			}
-		}
+	avail := NewAvailability(bf.Length)
+	for _, other := range others {
+		avail.Add(other)
 	}
+	return bf.FindRarestIn(avail, exclude)
+}
 
+// FindRarestIn finds the set bit we have that the fewest peers have according to avail
+// ties are broken at random so peers don't all go for the same piece
+func (bf *Bitfield) FindRarestIn(avail *Availability, exclude func(uint32) bool) (idx uint32, has bool) {
 	min := ^uint32(0)
-	for index, count := range bits {
-		if exclude(index) {
-			continue
+	ties := 0
+	// copy the counts first so exclude never runs under avail's lock
+	counts := avail.snapshot()
+	bf.ForEachSet(func(index uint32) {
+		var count uint32
+		if index < uint32(len(counts)) {
+			count = counts[index]
 		}
-		if count < min && bf.Has(index) {
+		if count > min || exclude(index) {
+			return
+		}
+		if count < min {
 			min = count
+			ties = 0
+		}
+		ties++
+		if rand.Intn(ties) == 0 {
 			idx = index
 			has = true
 		}
-	}
+	})
 	return
+}
 
+// FindFirst finds the lowest set bit that is not excluded
+func (bf *Bitfield) FindFirst(exclude func(uint32) bool) (idx uint32, has bool) {
+	for idx < bf.Length {
+		if bf.Has(idx) && !exclude(idx) {
+			has = true
+			return
+		}
+		idx++
+	}
+	return
 }
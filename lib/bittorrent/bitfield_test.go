@@ -0,0 +1,103 @@
+package bittorrent
+
+import (
+	"testing"
+)
+
+func slowCount(bf *Bitfield) (n int) {
+	for idx := uint32(0); idx < bf.Length; idx++ {
+		if bf.Has(idx) {
+			n++
+		}
+	}
+	return
+}
+
+func TestBitfieldCountSet(t *testing.T) {
+	for _, l := range []uint32{0, 1, 7, 8, 9, 63, 64, 65, 1000} {
+		bf := NewBitfield(l, nil)
+		for idx := uint32(0); idx < l; idx += 3 {
+			bf.Set(idx)
+		}
+		// junk past the end must not be counted
+		bf.Data[len(bf.Data)-1] |= 0x01
+		if bf.CountSet() != slowCount(bf) {
+			t.Fatalf("len %d: CountSet %d != %d", l, bf.CountSet(), slowCount(bf))
+		}
+		inv := bf.Inverted()
+		if inv.CountSet() != int(l)-slowCount(bf) {
+			t.Fatalf("len %d: inverted count %d", l, inv.CountSet())
+		}
+		if !bf.OR(inv).Completed() {
+			t.Fatalf("len %d: bitfield or its inverse not completed", l)
+		}
+		if l > 0 && inv.Completed() {
+			t.Fatalf("len %d: inverted bitfield completed", l)
+		}
+		var set []uint32
+		bf.ForEachSet(func(idx uint32) {
+			set = append(set, idx)
+		})
+		if len(set) != slowCount(bf) {
+			t.Fatalf("len %d: ForEachSet visited %d", l, len(set))
+		}
+		for _, idx := range set {
+			if idx%3 != 0 {
+				t.Fatalf("len %d: visited unset bit %d", l, idx)
+			}
+		}
+	}
+}
+
+func TestBitfieldFindRarestIn(t *testing.T) {
+	ours := NewBitfield(100, nil)
+	for idx := uint32(0); idx < 100; idx++ {
+		ours.Set(idx)
+	}
+	avail := NewAvailability(100)
+	for n := 0; n < 3; n++ {
+		other := NewBitfield(100, nil)
+		for idx := uint32(0); idx < 100; idx++ {
+			if idx != 42 && idx != 17 {
+				other.Set(idx)
+			}
+		}
+		avail.Add(other)
+	}
+	avail.Have(17)
+	idx, has := ours.FindRarestIn(avail, func(uint32) bool { return false })
+	if !has || idx != 42 {
+		t.Fatalf("rarest is %d %v, wanted 42", idx, has)
+	}
+	idx, has = ours.FindRarestIn(avail, func(i uint32) bool { return i == 42 })
+	if !has || idx != 17 {
+		t.Fatalf("rarest is %d %v, wanted 17", idx, has)
+	}
+	// exclude may touch avail without deadlocking
+	idx, has = ours.FindRarestIn(avail, func(i uint32) bool { return avail.Count(i) == 0 })
+	if !has || idx != 17 {
+		t.Fatalf("rarest is %d %v, wanted 17", idx, has)
+	}
+}
+
+func TestPieceRanges(t *testing.T) {
+	bf, err := ParsePieceRanges("0-2, 5,7-7,9", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf.CountSet() != 6 || !bf.Has(1) || bf.Has(3) || !bf.Has(9) {
+		t.Fatalf("bad pieces set: %s", bf.Ranges())
+	}
+	if r := bf.Ranges(); r != "0-2,5,7,9" {
+		t.Fatalf("ranges %q", r)
+	}
+	empty, err := ParsePieceRanges("", 10)
+	if err != nil || empty.CountSet() != 0 || empty.Ranges() != "" {
+		t.Fatal("empty ranges set pieces")
+	}
+	for _, bad := range []string{"10", "3-1", "a", "1-", "-1", "0-10"} {
+		if _, err := ParsePieceRanges(bad, 10); err == nil {
+			t.Fatalf("%q parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package extensions
+
+import (
+	"github.com/majestrate/XD/lib/sync"
+	"sort"
+)
+
+// Capabilities is the table of extended message ids a peer told us to use when sending to it
+// peers may send more handshakes at any time to remap or disable extensions, id 0 disables
+type Capabilities struct {
+	access sync.Mutex
+	ids    map[string]uint8
+}
+
+// Update applies an extended handshake from the peer to the table
+// extensions not mentioned in the handshake keep their current id
+func (c *Capabilities) Update(opts Message) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[string]uint8)
+	}
+	for name, id := range opts.Extensions {
+		if id == 0 || id > 255 {
+			delete(c.ids, name)
+		} else {
+			c.ids[name] = uint8(id)
+		}
+	}
+}
+
+// Reset forgets everything the peer told us
+func (c *Capabilities) Reset() {
+	c.access.Lock()
+	c.ids = nil
+	c.access.Unlock()
+}
+
+// ID returns the id the peer wants us to use for an extension, false if it does not support it
+func (c *Capabilities) ID(ext Extension) (id uint8, ok bool) {
+	c.access.Lock()
+	id, ok = c.ids[ext.String()]
+	c.access.Unlock()
+	return
+}
+
+// Supports returns true if the peer currently supports an extension
+func (c *Capabilities) Supports(ext Extension) bool {
+	_, ok := c.ID(ext)
+	return ok
+}
+
+// Names returns the names of all extensions the peer currently supports, sorted
+func (c *Capabilities) Names() (names []string) {
+	c.access.Lock()
+	for name := range c.ids {
+		names = append(names, name)
+	}
+	c.access.Unlock()
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,57 @@
+package extensions
+
+import (
+	"testing"
+)
+
+func TestSetSupportedUniqueIDs(t *testing.T) {
+	opts := New()
+	opts.SetSupported(I2PPeerExchange)
+	opts.SetSupported(UTMetaData)
+	opts.SetSupported(I2PPeerExchange)
+	if opts.Extensions[I2PPeerExchange.String()] == opts.Extensions[UTMetaData.String()] {
+		t.Fatalf("extensions share an id: %v", opts.Extensions)
+	}
+	ext, ok := opts.Lookup(uint8(opts.Extensions[UTMetaData.String()]))
+	if !ok || ext != UTMetaData.String() {
+		t.Fatalf("lookup gave %q", ext)
+	}
+}
+
+func TestCapabilitiesRemap(t *testing.T) {
+	var c Capabilities
+	c.Update(Message{Extensions: map[string]uint32{"ut_metadata": 3, "i2p_pex": 4}})
+	if id, ok := c.ID(UTMetaData); !ok || id != 3 {
+		t.Fatalf("ut_metadata id %d %v", id, ok)
+	}
+	// remap one, disable the other
+	c.Update(Message{Extensions: map[string]uint32{"ut_metadata": 7, "i2p_pex": 0}})
+	if id, ok := c.ID(UTMetaData); !ok || id != 7 {
+		t.Fatalf("ut_metadata not remapped %d %v", id, ok)
+	}
+	if c.Supports(I2PPeerExchange) {
+		t.Fatal("i2p_pex not disabled")
+	}
+	// extensions not mentioned keep their id
+	c.Update(Message{Extensions: map[string]uint32{"xdht": 2}})
+	if id, _ := c.ID(UTMetaData); id != 7 {
+		t.Fatalf("ut_metadata changed to %d", id)
+	}
+	names := c.Names()
+	if len(names) != 2 || names[0] != "ut_metadata" || names[1] != "xdht" {
+		t.Fatalf("names %v", names)
+	}
+}
+
+func TestSetUnsupported(t *testing.T) {
+	opts := New()
+	opts.SetSupported(I2PPeerExchange)
+	opts.SetSupported(UTMetaData)
+	opts.SetUnsupported(I2PPeerExchange)
+	if opts.I2PPEX() || !opts.MetaData() {
+		t.Fatalf("wrong extensions after disabling pex: %v", opts.Extensions)
+	}
+	if _, ok := opts.Lookup(uint8(opts.Extensions[UTMetaData.String()])); !ok {
+		t.Fatal("lost ut_metadata")
+	}
+}
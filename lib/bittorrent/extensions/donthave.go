@@ -0,0 +1,28 @@
+package extensions
+
+import (
+	"encoding/binary"
+)
+
+// LTDontHave is the bittorrent extension for retracting a have
+const LTDontHave = Extension("lt_donthave")
+
+// NewDontHave creates a new lt_donthave message saying we no longer have a piece
+func NewDontHave(id uint8, idx uint32) Message {
+	var body [4]byte
+	binary.BigEndian.PutUint32(body[:], idx)
+	return Message{
+		ID:         id,
+		PayloadRaw: body[:],
+	}
+}
+
+// ParseDontHave gets the piece index from an lt_donthave payload
+func ParseDontHave(payload []byte) (idx uint32, err error) {
+	if len(payload) == 4 {
+		idx = binary.BigEndian.Uint32(payload)
+	} else {
+		err = ErrInvalidSize
+	}
+	return
+}
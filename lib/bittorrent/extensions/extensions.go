@@ -24,6 +24,16 @@ type Message struct {
 	Payload      interface{}       `bencode:"-"`
 	PayloadRaw   []byte            `bencode:"-"`
 	MetainfoSize *uint32           `bencode:"metadata_size,omitempty"`
+	// biggest piece request we serve, peers that don't send this only take 16KiB requests
+	MaxRequest *uint32 `bencode:"xd_max_request,omitempty"`
+	// how many requests the sender queues for us without dropping any
+	ReqQ *uint32 `bencode:"reqq,omitempty"`
+	// port the sender takes connections on
+	Port *uint16 `bencode:"p,omitempty"`
+	// compact ip address the sender sees us at
+	YourIP []byte `bencode:"yourip,omitempty"`
+	// i2p destination hash the sender sees us at
+	YourDest []byte `bencode:"yourdest,omitempty"`
 }
 
 // I2PPEX returns true if i2p PEX is supported
@@ -46,10 +56,10 @@ func (opts Message) MetaData() bool {
 	return opts.IsSupported(UTMetaData.String())
 }
 
-// SetSupported sets a bittorrent extension as supported
+// SetSupported sets a bittorrent extension as supported using the next free id
 func (opts *Message) SetSupported(ext Extension) {
 	// get max id
-	max := uint32(1)
+	max := uint32(0)
 	for k := range opts.Extensions {
 		if opts.Extensions[k] > max {
 			max = opts.Extensions[k]
@@ -60,19 +70,27 @@ func (opts *Message) SetSupported(ext Extension) {
 		}
 	}
 	// set supported
-	opts.Extensions[ext.String()] = max
+	opts.Extensions[ext.String()] = max + 1
 }
 
-// IsSupported returns true if an extension by its name is supported
+// SetUnsupported stops advertising a bittorrent extension
+func (opts *Message) SetUnsupported(ext Extension) {
+	delete(opts.Extensions, ext.String())
+}
+
+// IsSupported returns true if an extension by its name is supported, id 0 means disabled
 func (opts Message) IsSupported(ext string) (has bool) {
 	if opts.Extensions != nil {
-		_, has = opts.Extensions[ext]
+		has = opts.Extensions[ext] != 0
 	}
 	return
 }
 
 // Lookup finds the extension name of the extension by id
 func (opts Message) Lookup(id uint8) (string, bool) {
+	if id == 0 {
+		return "", false
+	}
 	for k, v := range opts.Extensions {
 		if v == uint32(id) {
 			return k, true
@@ -93,6 +111,11 @@ func (opts Message) Copy() Message {
 		Extensions:   ext,
 		Payload:      opts.Payload,
 		MetainfoSize: opts.MetainfoSize,
+		MaxRequest:   opts.MaxRequest,
+		ReqQ:         opts.ReqQ,
+		Port:         opts.Port,
+		YourIP:       opts.YourIP,
+		YourDest:     opts.YourDest,
 	}
 	if opts.PayloadRaw != nil {
 		m.PayloadRaw = make([]byte, len(opts.PayloadRaw))
@@ -141,10 +164,11 @@ func NewOur(sz uint32) Message {
 	return m
 }
 
-// NewI2PPEX creates a new PEX message for i2p peers
-func NewI2PPEX(id uint8, connected, disconnected []byte) Message {
+// NewI2PPEX creates a new PEX message for i2p peers, flags has one byte for each connected peer
+func NewI2PPEX(id uint8, connected, flags, disconnected []byte) Message {
 	payload := map[string]interface{}{
 		"added":   connected,
+		"added.f": flags,
 		"dropped": disconnected,
 	}
 	msg := New()
@@ -0,0 +1,38 @@
+package extensions
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHandshakeFields(t *testing.T) {
+	m := NewOur(1234)
+	reqq := uint32(128)
+	port := uint16(6881)
+	m.ReqQ = &reqq
+	m.Port = &port
+	m.YourIP = []byte{10, 0, 0, 1}
+	m.SetSupported(UTMetaData)
+	got, err := FromWireMessage(m.ToWireMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version == "" || got.Version != m.Version {
+		t.Fatalf("version %q", got.Version)
+	}
+	if got.ReqQ == nil || *got.ReqQ != reqq {
+		t.Fatalf("reqq %v", got.ReqQ)
+	}
+	if got.Port == nil || *got.Port != port {
+		t.Fatalf("port %v", got.Port)
+	}
+	if !bytes.Equal(got.YourIP, m.YourIP) {
+		t.Fatalf("yourip %v", got.YourIP)
+	}
+	if got.YourDest != nil {
+		t.Fatalf("yourdest %v", got.YourDest)
+	}
+	if !got.MetaData() {
+		t.Fatal("lost ut_metadata")
+	}
+}
@@ -0,0 +1,115 @@
+package extensions
+
+import (
+	"encoding/binary"
+)
+
+// UTHolepunch is the bittorrent extension for meeting a peer through one we are both connected to
+const UTHolepunch = Extension("ut_holepunch")
+
+// HolepunchType is the kind of ut_holepunch message
+type HolepunchType uint8
+
+// HolepunchRendezvous asks the relay to connect us with the peer
+const HolepunchRendezvous = HolepunchType(0)
+
+// HolepunchConnect tells us to connect to the peer
+const HolepunchConnect = HolepunchType(1)
+
+// HolepunchError tells us the relay could not do a rendezvous
+const HolepunchError = HolepunchType(2)
+
+// HolepunchAddrType is the kind of address in a ut_holepunch message
+type HolepunchAddrType uint8
+
+// HolepunchIPv4 is a 4 byte ip address
+const HolepunchIPv4 = HolepunchAddrType(0)
+
+// HolepunchIPv6 is a 16 byte ip address
+const HolepunchIPv6 = HolepunchAddrType(1)
+
+// HolepunchI2P is a 32 byte i2p destination hash, the port is always 0
+const HolepunchI2P = HolepunchAddrType(2)
+
+// HolepunchErrNoSuchPeer means the address was invalid
+const HolepunchErrNoSuchPeer = uint32(1)
+
+// HolepunchErrNotConnected means the relay is not connected to the peer
+const HolepunchErrNotConnected = uint32(2)
+
+// HolepunchErrNoSupport means the peer does not support ut_holepunch
+const HolepunchErrNoSupport = uint32(3)
+
+// HolepunchErrNoSelf means we asked the relay to connect us to ourself
+const HolepunchErrNoSelf = uint32(4)
+
+// Holepunch is a ut_holepunch message
+type Holepunch struct {
+	Type     HolepunchType
+	AddrType HolepunchAddrType
+	Addr     []byte
+	Port     uint16
+	// only sent in errors
+	Err uint32
+}
+
+func holepunchAddrLen(t HolepunchAddrType) int {
+	switch t {
+	case HolepunchIPv4:
+		return 4
+	case HolepunchIPv6:
+		return 16
+	case HolepunchI2P:
+		return 32
+	}
+	return -1
+}
+
+// Bytes serializes the message payload
+func (h Holepunch) Bytes() []byte {
+	b := []byte{byte(h.Type), byte(h.AddrType)}
+	b = append(b, h.Addr...)
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], h.Port)
+	b = append(b, port[:]...)
+	if h.Type == HolepunchError {
+		var e [4]byte
+		binary.BigEndian.PutUint32(e[:], h.Err)
+		b = append(b, e[:]...)
+	}
+	return b
+}
+
+// ParseHolepunch reads a ut_holepunch payload
+func ParseHolepunch(payload []byte) (h Holepunch, err error) {
+	if len(payload) < 2 {
+		err = ErrInvalidSize
+		return
+	}
+	h.Type = HolepunchType(payload[0])
+	h.AddrType = HolepunchAddrType(payload[1])
+	l := holepunchAddrLen(h.AddrType)
+	if l < 0 || len(payload) < 2+l+2 {
+		err = ErrInvalidSize
+		return
+	}
+	h.Addr = make([]byte, l)
+	copy(h.Addr, payload[2:2+l])
+	h.Port = binary.BigEndian.Uint16(payload[2+l:])
+	if h.Type == HolepunchError {
+		if len(payload) < 2+l+2+4 {
+			err = ErrInvalidSize
+			return
+		}
+		h.Err = binary.BigEndian.Uint32(payload[2+l+2:])
+	}
+	return
+}
+
+// NewHolepunch creates a new ut_holepunch message
+func NewHolepunch(id uint8, h Holepunch) Message {
+	return Message{
+		ID:         id,
+		PayloadRaw: h.Bytes(),
+	}
+}
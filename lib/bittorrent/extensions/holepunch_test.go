@@ -0,0 +1,41 @@
+package extensions
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHolepunchRoundTrip(t *testing.T) {
+	h := Holepunch{
+		Type:     HolepunchError,
+		AddrType: HolepunchIPv4,
+		Addr:     []byte{10, 0, 0, 1},
+		Port:     6881,
+		Err:      HolepunchErrNotConnected,
+	}
+	got, err := ParseHolepunch(h.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != h.Type || got.AddrType != h.AddrType || !bytes.Equal(got.Addr, h.Addr) || got.Port != h.Port || got.Err != h.Err {
+		t.Fatalf("got %v not %v", got, h)
+	}
+	var dest [32]byte
+	dest[0] = 1
+	h = Holepunch{
+		Type:     HolepunchConnect,
+		AddrType: HolepunchI2P,
+		Addr:     dest[:],
+	}
+	b := h.Bytes()
+	if len(b) != 2+32+2 {
+		t.Fatalf("connect message is %d bytes", len(b))
+	}
+	if _, err = ParseHolepunch(b[:20]); err != ErrInvalidSize {
+		t.Fatalf("short message gave %v", err)
+	}
+	b[1] = 9
+	if _, err = ParseHolepunch(b); err != ErrInvalidSize {
+		t.Fatalf("unknown address type gave %v", err)
+	}
+}
@@ -1,7 +1,46 @@
 package extensions
 
+import "time"
+
 // I2PPeerExchange is a BitTorrent Extension indicating we support PEX variant for i2p
 const I2PPeerExchange = Extension("i2p_pex")
 
 // LokinetPeerExchange is a Bittorrent Extension indication we support Lokinet PEX
 const LokinetPeerExchange = Extension("ln_pex")
+
+// MaxPEXPeers is the most peers we put in or take from the added or dropped list of one PEX message
+const MaxPEXPeers = 50
+
+// MaxPEXMessageSize is the largest PEX payload we accept, bigger ones are dropped without decoding
+const MaxPEXMessageSize = 16 * 1024
+
+// MinPEXInterval is how often a peer may send us PEX messages, extra ones are ignored
+const MinPEXInterval = time.Second * 45
+
+// IsPEX returns true if ext is one of the PEX extensions
+func IsPEX(ext string) bool {
+	return ext == I2PPeerExchange.String() || ext == LokinetPeerExchange.String()
+}
+
+// PEXFlags are the per peer flags sent in added.f, one byte for each added peer
+type PEXFlags uint8
+
+// PEXEncryption means the peer prefers encrypted connections
+const PEXEncryption = PEXFlags(0x01)
+
+// PEXSeed means the peer is a seed
+const PEXSeed = PEXFlags(0x02)
+
+// PEXUTP means the peer supports utp
+const PEXUTP = PEXFlags(0x04)
+
+// PEXHolepunch means the peer supports ut_holepunch
+const PEXHolepunch = PEXFlags(0x08)
+
+// PEXOutgoing means the sender connected out to the peer, so it accepts connections
+const PEXOutgoing = PEXFlags(0x10)
+
+// Has returns true if all flags in f are set
+func (flags PEXFlags) Has(f PEXFlags) bool {
+	return flags&f == f
+}
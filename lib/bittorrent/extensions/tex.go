@@ -0,0 +1,37 @@
+package extensions
+
+// LTTrackerExchange is the bittorrent extension for telling peers about trackers we use
+const LTTrackerExchange = Extension("lt_tex")
+
+// MaxTEXTrackers is the most tracker urls we put in or take from one lt_tex message
+const MaxTEXTrackers = 10
+
+// NewTEX creates a new lt_tex message with tracker announce urls
+func NewTEX(id uint8, urls []string) Message {
+	payload := map[string]interface{}{
+		"added": urls,
+	}
+	msg := New()
+	msg.ID = id
+	msg.Payload = payload
+	return msg
+}
+
+// ParseTEX gets the added tracker urls from a decoded lt_tex payload
+func ParseTEX(payload interface{}) (urls []string) {
+	tex, ok := payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	added, _ := tex["added"].([]interface{})
+	for _, a := range added {
+		if len(urls) >= MaxTEXTrackers {
+			return
+		}
+		u, ok := a.(string)
+		if ok && u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package extensions
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTEXRoundTrip(t *testing.T) {
+	var urls []string
+	for idx := 0; idx < MaxTEXTrackers+5; idx++ {
+		urls = append(urls, fmt.Sprintf("http://tracker%d.i2p/a", idx))
+	}
+	m := NewTEX(3, urls)
+	got, err := FromWireMessage(m.ToWireMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 3 {
+		t.Fatalf("id %d", got.ID)
+	}
+	parsed := ParseTEX(got.Payload)
+	if len(parsed) != MaxTEXTrackers {
+		t.Fatalf("got %d trackers", len(parsed))
+	}
+	for idx := range parsed {
+		if parsed[idx] != urls[idx] {
+			t.Fatalf("tracker %d is %q not %q", idx, parsed[idx], urls[idx])
+		}
+	}
+	if ParseTEX("garbage") != nil {
+		t.Fatal("parsed a non dict payload")
+	}
+}
@@ -10,6 +10,11 @@ import (
 
 const handshakeV1 = "BitTorrent protocol"
 
+// IsHandshakePrefix returns true if the first 20 bytes of a connection start a plaintext bittorrent handshake
+func IsHandshakePrefix(first []byte) bool {
+	return len(first) >= 20 && first[0] == 19 && bytes.Equal(first[1:20], []byte(handshakeV1))
+}
+
 // Reserved is reserved data in handshake
 type Reserved struct {
 	data [8]uint8
@@ -39,11 +44,19 @@ func (r *Reserved) Set(bit ReservedBit) {
 // Extension is ReservedBit for bittorrent extensions
 const Extension = ReservedBit(44)
 
+// FastExtension is ReservedBit for the BEP 6 fast extension
+const FastExtension = ReservedBit(62)
+
 // DHT is ReservedBit for BT DHT
 const DHT = ReservedBit(64)
 
-// ErrInvalidHandshake is returned when a handshake contained invalid format
-var ErrInvalidHandshake = errors.New("invalid bittorrent handshake")
+// ErrBadHandshake is returned when a handshake contained invalid format
+var ErrBadHandshake = errors.New("bad bittorrent handshake")
+
+// ErrInvalidHandshake is the old name of ErrBadHandshake
+//
+// Deprecated: use ErrBadHandshake
+var ErrInvalidHandshake = ErrBadHandshake
 
 // Handshake is a bittorrent protocol handshake info
 type Handshake struct {
@@ -55,15 +68,15 @@ type Handshake struct {
 // FromBytes parses bittorrent handshake from byteslice
 func (h *Handshake) FromBytes(data []byte) (err error) {
 	if len(data) < 68 {
-		err = ErrInvalidHandshake
+		err = ErrBadHandshake
 	} else {
 		buff := data[:68]
-		if buff[0] == 19 && bytes.Equal(buff[1:20], []byte(handshakeV1)) {
+		if IsHandshakePrefix(buff) {
 			copy(h.Reserved.data[:], buff[20:28])
 			copy(h.Infohash[:], buff[28:48])
 			copy(h.PeerID[:], buff[48:68])
 		} else {
-			err = ErrInvalidHandshake
+			err = ErrBadHandshake
 		}
 	}
 	return
@@ -0,0 +1,36 @@
+package bittorrent
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReservedBits(t *testing.T) {
+	var r Reserved
+	r.Set(Extension)
+	r.Set(FastExtension)
+	r.Set(DHT)
+	if r.data[5] != 0x10 || r.data[7] != 0x05 {
+		t.Fatalf("reserved bytes %x", r.data)
+	}
+	if !r.Has(FastExtension) {
+		t.Fatal("fast extension bit not set")
+	}
+}
+
+func TestIsHandshakePrefix(t *testing.T) {
+	var h Handshake
+	var buf bytes.Buffer
+	h.Send(&buf)
+	if !IsHandshakePrefix(buf.Bytes()) {
+		t.Fatal("our own handshake is not a handshake")
+	}
+	// an mse handshake may start with the same length byte
+	first := append([]byte{19}, []byte("BitTorrent protocoX")...)
+	if IsHandshakePrefix(first) {
+		t.Fatal("only the length byte matched")
+	}
+	if IsHandshakePrefix([]byte{19}) {
+		t.Fatal("too short to be a handshake")
+	}
+}
@@ -0,0 +1,3 @@
+// Package mse implements the bittorrent message stream encryption handshake (MSE/PE)
+// so we can talk to clearnet peers that want obfuscated connections
+package mse
@@ -0,0 +1,337 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"io"
+	"math/big"
+	"net"
+)
+
+// size of diffie hellman public keys and the shared secret on the wire
+const keySize = 96
+
+// the most random padding either side may put after its public key or in the encrypted handshake
+const maxPadding = 512
+
+// crypto methods a side offers or picks
+const cryptoPlaintext = 0x01
+const cryptoRC4 = 0x02
+
+// verification constant both sides send encrypted to prove they have the keys
+var vc [8]byte
+
+// the 768 bit prime the handshake does diffie hellman in, the generator is 2
+var prime, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+var generator = big.NewInt(2)
+
+// ErrNoSync is returned when the other side did not send what the handshake expects where it should be
+var ErrNoSync = errors.New("mse handshake out of sync")
+
+// ErrUnknownInfohash is returned when an inbound peer wants a torrent we don't have
+var ErrUnknownInfohash = errors.New("mse handshake is for a torrent we don't have")
+
+// ErrNoCryptoMethod is returned when the other side offers or picks no crypto method we allow
+var ErrNoCryptoMethod = errors.New("mse peer has no crypto method we allow")
+
+type keypair struct {
+	priv *big.Int
+	pub  *big.Int
+}
+
+func newKeypair() (k keypair, err error) {
+	var priv [20]byte
+	_, err = io.ReadFull(rand.Reader, priv[:])
+	if err == nil {
+		k.priv = new(big.Int).SetBytes(priv[:])
+		k.pub = new(big.Int).Exp(generator, k.priv, prime)
+	}
+	return
+}
+
+// left pad x to the size of a key
+func keyBytes(x *big.Int) []byte {
+	b := make([]byte, keySize)
+	x.FillBytes(b)
+	return b
+}
+
+// the shared secret with the side that has public key theirs
+func (k keypair) secret(theirs []byte) []byte {
+	return keyBytes(new(big.Int).Exp(new(big.Int).SetBytes(theirs), k.priv, prime))
+}
+
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func xor(a, b []byte) []byte {
+	x := make([]byte, len(a))
+	for idx := range a {
+		x[idx] = a[idx] ^ b[idx]
+	}
+	return x
+}
+
+// rc4 keyed for one direction of the connection, the first 1KiB of its keystream is thrown away
+func newRC4(key string, s, skey []byte) cipher.Stream {
+	c, _ := rc4.NewCipher(hash([]byte(key), s, skey))
+	var discard [1024]byte
+	c.XORKeyStream(discard[:], discard[:])
+	return c
+}
+
+// our public key followed by up to maxPadding random bytes
+func keyWithPadding(k keypair) ([]byte, error) {
+	var n [2]byte
+	_, err := io.ReadFull(rand.Reader, n[:])
+	if err != nil {
+		return nil, err
+	}
+	pad := make([]byte, int(binary.BigEndian.Uint16(n[:]))%(maxPadding+1))
+	_, err = io.ReadFull(rand.Reader, pad)
+	return append(keyBytes(k.pub), pad...), err
+}
+
+// read from r until the last bytes read are pattern, fails if that takes more than max bytes
+func syncTo(r io.ByteReader, pattern []byte, max int) error {
+	window := make([]byte, 0, max)
+	for len(window) < max {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, pattern) {
+			return nil
+		}
+	}
+	return ErrNoSync
+}
+
+// read n bytes from r and decrypt them
+func readDecrypted(r io.Reader, dec cipher.Stream, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	if err == nil {
+		dec.XORKeyStream(b, b)
+	}
+	return b, err
+}
+
+// a connection after the handshake, encrypted or not
+type conn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+func (c *conn) Read(d []byte) (int, error) {
+	return c.r.Read(d)
+}
+
+func (c *conn) Write(d []byte) (int, error) {
+	return c.w.Write(d)
+}
+
+// Client does the handshake on a connection we dialed to a peer of the torrent ih
+// we always offer rc4 and also plaintext if allowPlain is true, the peer picks
+func Client(c net.Conn, ih common.Infohash, allowPlain bool) (net.Conn, error) {
+	nc, err := client(c, ih, allowPlain)
+	if err != nil {
+		c.Close()
+	}
+	return nc, err
+}
+
+func client(c net.Conn, ih common.Infohash, allowPlain bool) (net.Conn, error) {
+	r := bufio.NewReader(c)
+	k, err := newKeypair()
+	if err != nil {
+		return nil, err
+	}
+	ya, err := keyWithPadding(k)
+	if err == nil {
+		_, err = c.Write(ya)
+	}
+	yb := make([]byte, keySize)
+	if err == nil {
+		_, err = io.ReadFull(r, yb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := k.secret(yb)
+	enc := newRC4("keyA", s, ih[:])
+	dec := newRC4("keyB", s, ih[:])
+	provide := uint32(cryptoRC4)
+	if allowPlain {
+		provide |= cryptoPlaintext
+	}
+	// vc, crypto_provide, no padding and no initial payload
+	var offer [8 + 4 + 2 + 2]byte
+	binary.BigEndian.PutUint32(offer[8:], provide)
+	enc.XORKeyStream(offer[:], offer[:])
+	msg := append(hash([]byte("req1"), s), xor(hash([]byte("req2"), ih[:]), hash([]byte("req3"), s))...)
+	_, err = c.Write(append(msg, offer[:]...))
+	if err != nil {
+		return nil, err
+	}
+	// their encrypted vc comes after their padding
+	want := make([]byte, len(vc))
+	dec.XORKeyStream(want, vc[:])
+	err = syncTo(r, want, maxPadding+len(want))
+	var reply []byte
+	if err == nil {
+		reply, err = readDecrypted(r, dec, 4+2)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pad := int(binary.BigEndian.Uint16(reply[4:]))
+	if pad > maxPadding {
+		return nil, ErrNoSync
+	}
+	if _, err = readDecrypted(r, dec, pad); err != nil {
+		return nil, err
+	}
+	switch binary.BigEndian.Uint32(reply) {
+	case cryptoRC4:
+		return &conn{
+			Conn: c,
+			r:    &cipher.StreamReader{S: dec, R: r},
+			w:    &cipher.StreamWriter{S: enc, W: c},
+		}, nil
+	case cryptoPlaintext:
+		if allowPlain {
+			return &conn{Conn: c, r: r, w: c}, nil
+		}
+	}
+	return nil, ErrNoCryptoMethod
+}
+
+// Server does the handshake on a connection a peer dialed us with, prefix is what we already read from it
+// infohashes are the torrents the peer may want, we pick rc4 if it offers it and plaintext only if allowPlain is true
+// returns the connection and the infohash the peer wants
+func Server(c net.Conn, prefix []byte, infohashes []common.Infohash, allowPlain bool) (net.Conn, common.Infohash, error) {
+	nc, ih, err := server(c, prefix, infohashes, allowPlain)
+	if err != nil {
+		c.Close()
+	}
+	return nc, ih, err
+}
+
+func server(c net.Conn, prefix []byte, infohashes []common.Infohash, allowPlain bool) (nc net.Conn, ih common.Infohash, err error) {
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(prefix), c))
+	ya := make([]byte, keySize)
+	_, err = io.ReadFull(r, ya)
+	if err != nil {
+		return
+	}
+	var k keypair
+	k, err = newKeypair()
+	var yb []byte
+	if err == nil {
+		yb, err = keyWithPadding(k)
+	}
+	if err == nil {
+		_, err = c.Write(yb)
+	}
+	if err != nil {
+		return
+	}
+	s := k.secret(ya)
+	// their padding goes until the hash of the secret
+	err = syncTo(r, hash([]byte("req1"), s), maxPadding+sha1.Size)
+	skey := make([]byte, sha1.Size)
+	if err == nil {
+		_, err = io.ReadFull(r, skey)
+	}
+	if err != nil {
+		return
+	}
+	want := xor(skey, hash([]byte("req3"), s))
+	found := false
+	for _, h := range infohashes {
+		if bytes.Equal(hash([]byte("req2"), h[:]), want) {
+			ih = h
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = ErrUnknownInfohash
+		return
+	}
+	dec := newRC4("keyA", s, ih[:])
+	enc := newRC4("keyB", s, ih[:])
+	var offer []byte
+	offer, err = readDecrypted(r, dec, 8+4+2)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(offer[:8], vc[:]) {
+		err = ErrNoSync
+		return
+	}
+	pad := int(binary.BigEndian.Uint16(offer[12:]))
+	if pad > maxPadding {
+		err = ErrNoSync
+		return
+	}
+	var ia []byte
+	_, err = readDecrypted(r, dec, pad)
+	if err == nil {
+		ia, err = readDecrypted(r, dec, 2)
+	}
+	if err == nil {
+		ia, err = readDecrypted(r, dec, int(binary.BigEndian.Uint16(ia)))
+	}
+	if err != nil {
+		return
+	}
+	provide := binary.BigEndian.Uint32(offer[8:])
+	var pick uint32
+	if provide&cryptoRC4 != 0 {
+		pick = cryptoRC4
+	} else if provide&cryptoPlaintext != 0 && allowPlain {
+		pick = cryptoPlaintext
+	} else {
+		err = ErrNoCryptoMethod
+		return
+	}
+	// vc, crypto_select and no padding
+	var reply [8 + 4 + 2]byte
+	binary.BigEndian.PutUint32(reply[8:], pick)
+	enc.XORKeyStream(reply[:], reply[:])
+	_, err = c.Write(reply[:])
+	if err != nil {
+		return
+	}
+	// the initial payload is encrypted whatever they picked, what comes after it is only with rc4
+	if pick == cryptoRC4 {
+		nc = &conn{
+			Conn: c,
+			r:    io.MultiReader(bytes.NewReader(ia), &cipher.StreamReader{S: dec, R: r}),
+			w:    &cipher.StreamWriter{S: enc, W: c},
+		}
+	} else {
+		nc = &conn{
+			Conn: c,
+			r:    io.MultiReader(bytes.NewReader(ia), r),
+			w:    c,
+		}
+	}
+	return
+}
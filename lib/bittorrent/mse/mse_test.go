@@ -0,0 +1,84 @@
+package mse
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"io"
+	"net"
+	"testing"
+)
+
+// handshake over a pipe with the first 20 bytes read before the server starts, like the swarm does,
+// then send a message each way and return what the client got back
+func exchange(t *testing.T, ih common.Infohash, have []common.Infohash, clientPlain, serverPlain bool) (string, error) {
+	a, b := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		prefix := make([]byte, 20)
+		_, err := io.ReadFull(b, prefix)
+		if err == nil {
+			var c net.Conn
+			var got common.Infohash
+			c, got, err = Server(b, prefix, have, serverPlain)
+			if err == nil {
+				if got != ih {
+					t.Errorf("server got infohash %s not %s", got.Hex(), ih.Hex())
+				}
+				buf := make([]byte, 5)
+				_, err = io.ReadFull(c, buf)
+				if err == nil {
+					_, err = c.Write(buf)
+				}
+			}
+		}
+		b.Close()
+		done <- err
+	}()
+	c, err := Client(a, ih, clientPlain)
+	var got string
+	if err == nil {
+		_, err = c.Write([]byte("hello"))
+		if err == nil {
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(c, buf)
+			got = string(buf)
+		}
+		c.Close()
+	}
+	a.Close()
+	if e := <-done; err == nil {
+		err = e
+	}
+	return got, err
+}
+
+func TestHandshake(t *testing.T) {
+	var ih, other common.Infohash
+	ih[0] = 1
+	other[0] = 2
+	for _, plain := range []bool{false, true} {
+		got, err := exchange(t, ih, []common.Infohash{other, ih}, plain, plain)
+		if err != nil {
+			t.Fatalf("plaintext allowed=%v: %s", plain, err)
+		}
+		if got != "hello" {
+			t.Fatalf("plaintext allowed=%v: got %q back", plain, got)
+		}
+	}
+	if _, err := exchange(t, ih, []common.Infohash{other}, false, false); err == nil {
+		t.Fatal("handshake for a torrent the server doesn't have worked")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, name := range []string{"", "plaintext", "prefer", "require"} {
+		if _, err := ParsePolicy(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ParsePolicy("always"); err == nil {
+		t.Fatal("parsed a bad policy")
+	}
+	if Plaintext.Encrypts() || !Prefer.AllowsPlaintext() || Require.AllowsPlaintext() {
+		t.Fatal("policies say the wrong things")
+	}
+}
@@ -0,0 +1,38 @@
+package mse
+
+import (
+	"fmt"
+)
+
+// Policy says when we encrypt peer connections
+type Policy string
+
+// Plaintext never encrypts, peers that want encryption can't talk to us
+const Plaintext = Policy("plaintext")
+
+// Prefer encrypts when we dial and falls back to plaintext for peers that can't, inbound peers may do either
+const Prefer = Policy("prefer")
+
+// Require only talks to peers that encrypt
+const Require = Policy("require")
+
+// ParsePolicy gets a policy by name, an empty name is plaintext
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "", Plaintext:
+		return Plaintext, nil
+	case Prefer, Require:
+		return Policy(name), nil
+	}
+	return Plaintext, fmt.Errorf("no such encryption policy %q, use %s, %s or %s", name, Plaintext, Prefer, Require)
+}
+
+// Encrypts returns true if we try encrypting connections we dial
+func (p Policy) Encrypts() bool {
+	return p == Prefer || p == Require
+}
+
+// AllowsPlaintext returns true if peers may talk to us without encryption
+func (p Policy) AllowsPlaintext() bool {
+	return p != Require
+}
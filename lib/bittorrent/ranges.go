@@ -0,0 +1,68 @@
+package bittorrent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrBadPieceRange is returned when a piece range can't be parsed or is past the last piece
+var ErrBadPieceRange = errors.New("bad piece range")
+
+// ParsePieceRanges parses piece ranges like "0-9,20,30-31" into a bitfield of the given number of pieces,
+// ranges include both ends, an empty string sets nothing
+func ParsePieceRanges(s string, pieces uint32) (bf *Bitfield, err error) {
+	bf = NewBitfield(pieces, nil)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var first, last uint64
+		if idx := strings.Index(part, "-"); idx > 0 {
+			first, err = strconv.ParseUint(part[:idx], 10, 32)
+			if err == nil {
+				last, err = strconv.ParseUint(part[idx+1:], 10, 32)
+			}
+		} else {
+			first, err = strconv.ParseUint(part, 10, 32)
+			last = first
+		}
+		if err != nil || first > last || last >= uint64(pieces) {
+			return nil, fmt.Errorf("%s: %q", ErrBadPieceRange, part)
+		}
+		for idx := first; idx <= last; idx++ {
+			bf.Set(uint32(idx))
+		}
+	}
+	return
+}
+
+// Ranges formats the set bits as piece ranges that ParsePieceRanges reads back
+func (bf *Bitfield) Ranges() string {
+	var parts []string
+	var first, last uint32
+	open := false
+	flush := func() {
+		if first == last {
+			parts = append(parts, fmt.Sprintf("%d", first))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", first, last))
+		}
+	}
+	bf.ForEachSet(func(idx uint32) {
+		if open && idx == last+1 {
+			last = idx
+			return
+		}
+		if open {
+			flush()
+		}
+		first, last, open = idx, idx, true
+	})
+	if open {
+		flush()
+	}
+	return strings.Join(parts, ",")
+}
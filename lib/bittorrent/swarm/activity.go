@@ -0,0 +1,53 @@
+package swarm
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ActiveSaveInterval is how often we write down when a busy torrent was last active
+const ActiveSaveInterval = time.Minute
+
+// AddedAt gets when we first added the torrent
+func (t *Torrent) AddedAt() time.Time {
+	return t.st.AddedAt()
+}
+
+// CompletedAt gets when we first completed the torrent, zero if we never did
+func (t *Torrent) CompletedAt() time.Time {
+	return t.st.CompletedAt()
+}
+
+// LastActive gets when we last uploaded or downloaded anything for the torrent, zero if we never did
+func (t *Torrent) LastActive() time.Time {
+	if ns := atomic.LoadInt64(&t.lastActive); ns > 0 {
+		return time.Unix(0, ns)
+	}
+	return t.st.LastActive()
+}
+
+// called every second with how much we moved in that second
+func (t *Torrent) tickActivity(now time.Time, tx, rx uint64) {
+	if tx == 0 && rx == 0 {
+		return
+	}
+	atomic.StoreInt64(&t.lastActive, now.UnixNano())
+	if now.Sub(t.activeSaved) >= ActiveSaveInterval {
+		t.saveActivity()
+	}
+}
+
+// write down when we were last active and how much we moved if we have not yet
+func (t *Torrent) saveActivity() {
+	ns := atomic.LoadInt64(&t.lastActive)
+	if ns == 0 {
+		return
+	}
+	tm := time.Unix(0, ns)
+	if !tm.After(t.activeSaved) {
+		return
+	}
+	t.activeSaved = tm
+	t.st.SetLastActive(tm)
+	t.saveTotals()
+}
@@ -0,0 +1,111 @@
+package swarm
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"net"
+)
+
+// AllowedFastSetSize is how many pieces a peer may download from us while choked
+const AllowedFastSetSize = 10
+
+// generate the allowed fast set for a peer as in BEP 6
+// ipv4 peers are keyed on their /24, peers on overlay networks are keyed on their whole address
+func generateAllowedFast(k int, numPieces uint32, addr net.Addr, ih common.Infohash) (set []uint32) {
+	if numPieces == 0 {
+		return
+	}
+	if uint32(k) > numPieces {
+		k = int(numPieces)
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	var x []byte
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		x = append(x, ip[0], ip[1], ip[2], 0)
+	} else {
+		x = append(x, []byte(host)...)
+	}
+	x = append(x, ih[:]...)
+	seen := make(map[uint32]bool)
+	for len(set) < k {
+		h := sha1.Sum(x)
+		x = h[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			idx := binary.BigEndian.Uint32(x[i*4:]) % numPieces
+			if !seen[idx] {
+				seen[idx] = true
+				set = append(set, idx)
+			}
+		}
+	}
+	return
+}
+
+// pick which pieces a fast extension peer may get from us while choked, call before the peer starts
+func (c *PeerConn) pickAllowedFast() {
+	if !c.fast || !c.t.Ready() {
+		return
+	}
+	set := generateAllowedFast(AllowedFastSetSize, c.t.MetaInfo().Info.NumPieces(), c.c.RemoteAddr(), c.t.Infohash())
+	c.allowedFast = make(map[uint32]bool)
+	for _, idx := range set {
+		c.allowedFast[idx] = true
+	}
+}
+
+// tell the peer which pieces we have that it may get while choked, send after our bitfield
+func (c *PeerConn) sendAllowedFast() {
+	bf := c.t.Bitfield()
+	if bf == nil {
+		return
+	}
+	for idx := range c.allowedFast {
+		if bf.Has(idx) {
+			c.Send(common.NewAllowedFast(idx))
+		}
+	}
+}
+
+// returns true if we serve a piece to this peer while choking it
+func (c *PeerConn) servesWhileChoked(idx uint32) bool {
+	return c.allowedFast[idx]
+}
+
+// the peer told us we may get a piece while choked
+func (c *PeerConn) gotAllowedFast(idx uint32) {
+	if !c.t.Ready() || idx >= c.t.MetaInfo().Info.NumPieces() {
+		return
+	}
+	c.access.Lock()
+	if c.theirAllowedFast == nil {
+		c.theirAllowedFast = bittorrent.NewBitfield(c.t.MetaInfo().Info.NumPieces(), nil)
+	}
+	c.theirAllowedFast.Set(idx)
+	c.access.Unlock()
+}
+
+// returns true if the peer lets us get a piece while it chokes us
+func (c *PeerConn) allowedWhileChoked(idx uint32) (allowed bool) {
+	c.access.Lock()
+	allowed = c.theirAllowedFast != nil && c.theirAllowedFast.Has(idx)
+	c.access.Unlock()
+	return
+}
+
+// the pieces we can download from the peer while it chokes us, nil if there are none
+func (c *PeerConn) chokedDownloadable() (bf *bittorrent.Bitfield) {
+	c.access.Lock()
+	if c.theirAllowedFast != nil && c.bf != nil {
+		bf = c.bf.AND(c.theirAllowedFast)
+	}
+	c.access.Unlock()
+	if bf != nil && bf.CountSet() == 0 {
+		bf = nil
+	}
+	return
+}
@@ -0,0 +1,28 @@
+package swarm
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGenerateAllowedFast(t *testing.T) {
+	// test vector from BEP 6
+	var ih [20]byte
+	for idx := range ih {
+		ih[idx] = 0xaa
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("80.4.4.200"), Port: 6881}
+	expected := []uint32{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}
+	set := generateAllowedFast(9, 1313, addr, ih)
+	if len(set) != len(expected) {
+		t.Fatalf("got %d pieces, wanted %d", len(set), len(expected))
+	}
+	for idx := range expected {
+		if set[idx] != expected[idx] {
+			t.Fatalf("allowed fast set %v, wanted %v", set, expected)
+		}
+	}
+	if n := len(generateAllowedFast(AllowedFastSetSize, 3, addr, ih)); n != 3 {
+		t.Fatalf("got %d pieces for a 3 piece torrent", n)
+	}
+}
@@ -1,11 +1,13 @@
 package swarm
 
 import (
+	"errors"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/sync"
 	"github.com/majestrate/XD/lib/tracker"
 	"net"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,10 +20,88 @@ type torrentAnnounce struct {
 	fails    time.Duration
 	announce tracker.Announcer
 	t        *Torrent
+	// guards last and lastErr, access is held for the whole announce
+	statusMtx sync.Mutex
+	// last response from the tracker, nil if we never got one
+	last    *tracker.Response
+	lastErr error
+	// next announce time for status
+	lastNext time.Time
+	// the soonest the tracker lets us announce again, guarded by access
+	earliest time.Time
+}
+
+// TrackerStatus is what we know about a tracker for a torrent
+type TrackerStatus struct {
+	Name string
+	// seeders and leechers, -1 if unknown
+	Seeders  int
+	Leechers int
+	// times the torrent was downloaded, -1 if unknown
+	Completed int
+	Warning   string
+	Error     string
+	// true if the last announce failed because the tracker never replied
+	TimedOut bool
+	// unix timestamp of next announce, 0 if we don't announce to it
+	NextAnnounce int64
+	// unix timestamp of the last scrape, 0 if we never scraped it
+	LastScrape int64
+}
+
+// fill in what a scrape told us, the last announce has the same counts so it wins for seeders and leechers
+func (st *TrackerStatus) addScrape(sc scrapeResult) {
+	st.Completed = sc.stats.Downloaded
+	st.LastScrape = sc.at.Unix()
+	if st.Seeders < 0 {
+		st.Seeders = sc.stats.Complete
+	}
+	if st.Leechers < 0 {
+		st.Leechers = sc.stats.Incomplete
+	}
+}
+
+func (a *torrentAnnounce) status() (st TrackerStatus) {
+	a.statusMtx.Lock()
+	defer a.statusMtx.Unlock()
+	st.Name = a.announce.Name()
+	st.Seeders = -1
+	st.Leechers = -1
+	st.Completed = -1
+	st.NextAnnounce = a.lastNext.Unix()
+	if a.last != nil {
+		st.Seeders = a.last.Complete
+		st.Leechers = a.last.Incomplete
+		st.Warning = a.last.Warning
+	}
+	if a.lastErr != nil {
+		st.Error = a.lastErr.Error()
+		st.TimedOut = errors.Is(a.lastErr, tracker.ErrTrackerTimeout)
+	}
+	return
+}
+
+// returns true if our last announce to this tracker worked
+func (a *torrentAnnounce) working() bool {
+	a.statusMtx.Lock()
+	defer a.statusMtx.Unlock()
+	return a.last != nil && a.lastErr == nil
 }
 
 func (a *torrentAnnounce) tryAnnounce(ev tracker.Event) (err error) {
+	atomic.AddInt32(&a.t.announcing, 1)
+	defer atomic.AddInt32(&a.t.announcing, -1)
+	var v2req *tracker.Request
 	a.access.Lock()
+	if ev != tracker.Stopped && time.Now().After(a.next) && a.t.announceDelay != nil {
+		if d := a.t.announceDelay(); d > 0 {
+			// network is struggling, try again later
+			a.next = time.Now().Add(d)
+			a.statusMtx.Lock()
+			a.lastNext = a.next
+			a.statusMtx.Unlock()
+		}
+	}
 	if time.Now().After(a.next) {
 		la := a.t.Network().Addr()
 		if la.Network() == "i2p" {
@@ -43,6 +123,7 @@ func (a *torrentAnnounce) tryAnnounce(ev tracker.Event) (err error) {
 			_, port, err = net.SplitHostPort(la.String())
 			req.Port, err = strconv.Atoi(port)
 			if err != nil {
+				a.access.Unlock()
 				return
 			}
 		}
@@ -53,11 +134,46 @@ func (a *torrentAnnounce) tryAnnounce(ev tracker.Event) (err error) {
 		log.Infof("announcing to %s", a.announce.Name())
 		resp, err = a.announce.Announce(req)
 		backoff := a.fails * time.Minute
-		a.next = resp.NextAnnounce.Add(backoff)
+		next := resp.NextAnnounce
+		if err == nil {
+			now := time.Now()
+			interval, floor := announceIntervals(resp.Interval, resp.MinInterval, a.t.AnnounceInterval)
+			next = now.Add(interval)
+			a.earliest = now.Add(floor)
+		}
+		a.next = a.t.tierAnnounceTime(a.notBefore(next.Add(backoff)))
+		a.statusMtx.Lock()
+		a.lastNext = a.next
+		a.lastErr = err
+		if err == nil {
+			a.last = resp
+		}
+		a.statusMtx.Unlock()
 		if err == nil && ev != tracker.Stopped {
-			a.t.addPeers(resp.Peers)
+			a.t.addTrackerPeers(resp.Peers)
+		}
+		if ih, ok := a.t.hybridInfohash(); ok && err == nil {
+			v2 := *req
+			v2.Infohash = ih
+			v2req = &v2
 		}
 	}
 	a.access.Unlock()
+	if v2req != nil {
+		a.announceV2(v2req)
+	}
 	return
 }
+
+// be in the v2 swarm of a hybrid torrent too, the v1 announce is the one we show
+func (a *torrentAnnounce) announceV2(req *tracker.Request) {
+	log.Debugf("announcing v2 infohash to %s", a.announce.Name())
+	resp, err := a.announce.Announce(req)
+	if err != nil {
+		log.Warnf("announcing v2 infohash to %s failed: %s", a.announce.Name(), err)
+		return
+	}
+	if req.Event != tracker.Stopped {
+		a.t.addTrackerPeers(resp.Peers)
+	}
+}
@@ -0,0 +1,27 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/audit"
+	"github.com/majestrate/XD/lib/log"
+	"time"
+)
+
+// Audit writes down a significant action in the audit log, events without a time or principal are stamped now and as local
+func (sw *Swarm) Audit(e audit.Event) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	if e.Principal == "" {
+		e.Principal = audit.PrincipalLocal
+	}
+	log.Infof("audit: %s %s %s %s", e.Principal, e.Action, e.Infohash, e.Detail)
+	err := sw.Torrents.st.PutAuditEvent(e)
+	if err != nil {
+		log.Warnf("failed to write audit log: %s", err.Error())
+	}
+}
+
+// AuditLog gets the last n events of the audit log oldest first, all of them if n is 0
+func (sw *Swarm) AuditLog(n int) ([]audit.Event, error) {
+	return sw.Torrents.st.AuditEvents(n)
+}
@@ -0,0 +1,234 @@
+package swarm
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/sync"
+	"github.com/majestrate/XD/lib/util"
+	"time"
+)
+
+// BandwidthLimits caps how fast we move piece data in bytes a second, 0 is unlimited
+type BandwidthLimits struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+func (l BandwidthLimits) String() string {
+	format := func(n int64) string {
+		if n <= 0 {
+			return "unlimited"
+		}
+		return util.FormatRate(float64(n))
+	}
+	return fmt.Sprintf("up %s down %s", format(l.Upload), format(l.Download))
+}
+
+// AltMode is when the alternative bandwidth limits apply
+type AltMode string
+
+// AltAuto applies the alternative limits when the schedule says so
+const AltAuto = AltMode("auto")
+
+// AltOn always applies the alternative limits
+const AltOn = AltMode("on")
+
+// AltOff never applies the alternative limits
+const AltOff = AltMode("off")
+
+// BandwidthSettings are the limits we apply and when
+type BandwidthSettings struct {
+	Normal BandwidthLimits `json:"normal"`
+	Alt    BandwidthLimits `json:"alt"`
+	// when the alternative limits apply, see ParseSchedule
+	Schedule string  `json:"schedule"`
+	AltMode  AltMode `json:"alt_mode"`
+	// true if the alternative limits apply right now, ignored when configuring
+	AltActive bool `json:"alt_active"`
+}
+
+// Bandwidth limits the data rate of the torrents of one or more swarms
+// torrents of each priority share a limit by the weight of their priority with the other priorities that are moving data
+type Bandwidth struct {
+	mtx      sync.Mutex
+	settings BandwidthSettings
+	schedule BandwidthSchedule
+	up       [numPriorities]tokenBucket
+	down     [numPriorities]tokenBucket
+}
+
+// NewBandwidth makes a limiter that doesn't limit anything
+func NewBandwidth() *Bandwidth {
+	return &Bandwidth{
+		settings: BandwidthSettings{AltMode: AltAuto},
+	}
+}
+
+// Configure sets the limits and when the alternative ones apply
+func (b *Bandwidth) Configure(s BandwidthSettings) error {
+	sched, err := ParseSchedule(s.Schedule)
+	if err != nil {
+		return err
+	}
+	if s.AltMode == "" {
+		s.AltMode = AltAuto
+	}
+	if s.AltMode != AltAuto && s.AltMode != AltOn && s.AltMode != AltOff {
+		return fmt.Errorf("alternative limits must be %s, %s or %s not %q", AltAuto, AltOn, AltOff, s.AltMode)
+	}
+	if s.Normal.Upload < 0 || s.Normal.Download < 0 || s.Alt.Upload < 0 || s.Alt.Download < 0 {
+		return fmt.Errorf("negative bandwidth limit")
+	}
+	s.Schedule = sched.String()
+	b.mtx.Lock()
+	b.settings = s
+	b.schedule = sched
+	b.apply(time.Now())
+	b.mtx.Unlock()
+	return nil
+}
+
+// Settings gets the limits we apply and when
+func (b *Bandwidth) Settings() (s BandwidthSettings) {
+	b.mtx.Lock()
+	s = b.settings
+	b.mtx.Unlock()
+	return
+}
+
+// put the limits that apply at now in effect, returns true if switched between the normal and alternative limits
+// b.mtx must be held
+func (b *Bandwidth) apply(now time.Time) (switched bool) {
+	alt := b.settings.AltMode == AltOn || (b.settings.AltMode == AltAuto && b.schedule.Active(now))
+	switched = alt != b.settings.AltActive
+	b.settings.AltActive = alt
+	limits := b.settings.Normal
+	if alt {
+		limits = b.settings.Alt
+	}
+	share(&b.up, limits.Upload, now)
+	share(&b.down, limits.Download, now)
+	return
+}
+
+// split a limit between the priorities by their weight, a priority that moved no data lately gives its share to the others
+// a priority gets its share right away when it starts moving data, the others get less on the next tick
+func share(buckets *[numPriorities]tokenBucket, limit int64, now time.Time) {
+	var busy [numPriorities]bool
+	for class := range buckets {
+		busy[class] = buckets[class].busy(now)
+	}
+	for class := range buckets {
+		rate := limit
+		if limit > 0 {
+			weights := priorityWeight(class)
+			for other := range buckets {
+				if other != class && busy[other] {
+					weights += priorityWeight(other)
+				}
+			}
+			rate = limit * priorityWeight(class) / weights
+			if rate < 1 {
+				rate = 1
+			}
+		}
+		buckets[class].setRate(rate, now)
+	}
+}
+
+// switch between the normal and alternative limits when the schedule says so, called by the swarm ticker
+func (b *Bandwidth) tick(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	switched := b.apply(now)
+	s := b.settings
+	b.mtx.Unlock()
+	if switched {
+		if s.AltActive {
+			log.Infof("alternative bandwidth limits apply: %s", s.Alt)
+		} else {
+			log.Infof("normal bandwidth limits apply: %s", s.Normal)
+		}
+	}
+}
+
+// wait until a torrent of priority p may send n bytes of piece data
+func (b *Bandwidth) waitUpload(p TorrentPriority, n int) {
+	if b != nil && p.Valid() {
+		b.up[p.class()].wait(n)
+	}
+}
+
+// wait until a torrent of priority p may take in n more bytes of piece data
+func (b *Bandwidth) waitDownload(p TorrentPriority, n int) {
+	if b != nil && p.Valid() {
+		b.down[p.class()].wait(n)
+	}
+}
+
+// lets bytes through at a rate, spending what it saved up for a second at most
+type tokenBucket struct {
+	mtx sync.Mutex
+	// bytes a second, 0 for unlimited
+	rate int64
+	// bytes we may move right away, negative if waiters reserved more than we had
+	tokens float64
+	last   time.Time
+	// when we last let any bytes through
+	used time.Time
+}
+
+// returns true if the bucket let bytes through within the last second
+func (tb *tokenBucket) busy(now time.Time) (busy bool) {
+	tb.mtx.Lock()
+	busy = now.Sub(tb.used) < time.Second
+	tb.mtx.Unlock()
+	return
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	if !tb.last.IsZero() {
+		tb.tokens += now.Sub(tb.last).Seconds() * float64(tb.rate)
+	}
+	if burst := float64(tb.rate); tb.tokens > burst {
+		tb.tokens = burst
+	}
+	tb.last = now
+}
+
+func (tb *tokenBucket) setRate(rate int64, now time.Time) {
+	tb.mtx.Lock()
+	if rate != tb.rate {
+		tb.refill(now)
+		tb.rate = rate
+		if tb.tokens < 0 || rate == 0 {
+			// don't make waiters pay off a debt from an old rate
+			tb.tokens = 0
+		}
+	}
+	tb.mtx.Unlock()
+}
+
+// reserve n bytes and get how long to wait before moving them
+func (tb *tokenBucket) take(n int, now time.Time) time.Duration {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+	tb.used = now
+	if tb.rate <= 0 {
+		return 0
+	}
+	tb.refill(now)
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / float64(tb.rate) * float64(time.Second))
+}
+
+func (tb *tokenBucket) wait(n int) {
+	if d := tb.take(n, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,216 @@
+package swarm
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/majestrate/XD/lib/audit"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"time"
+)
+
+// BanAfterBadPieces is how many pieces that failed verification we pin on a destination before we ban it for the rest of the session
+const BanAfterBadPieces = 2
+
+// who sent us a block of a piece and what it sent
+type blockRecord struct {
+	from string
+	// false if the block came in parts so we can't pin it on one sender
+	whole bool
+	// set once the piece failed verification
+	sum [sha1.Size]byte
+}
+
+// record who sent the blocks in a slice of piece data at offset
+// p.mtx must be held
+func (p *cachedPiece) record(offset uint32, data []byte, from string) {
+	if len(data) == 0 {
+		return
+	}
+	if p.blocks == nil {
+		p.blocks = make([]blockRecord, p.obtained.Length)
+	}
+	end := offset + uint32(len(data))
+	p.forEachBlock(offset, uint32(len(data)), func(idx uint32) {
+		start, stop := p.blockSpan(idx)
+		p.blocks[idx] = blockRecord{from: from, whole: offset <= start && end >= stop}
+	})
+}
+
+// where block idx of the piece starts and ends
+func (p *cachedPiece) blockSpan(idx uint32) (start, stop uint32) {
+	start = idx * BlockSize
+	stop = start + BlockSize
+	if stop > p.length {
+		stop = p.length
+	}
+	return
+}
+
+// addresses of the peers that sent us blocks of this piece
+func (p *cachedPiece) senders() (from []string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	seen := make(map[string]bool)
+	for _, b := range p.blocks {
+		if b.from != "" && !seen[b.from] {
+			seen[b.from] = true
+			from = append(from, b.from)
+		}
+	}
+	return
+}
+
+// get who sent each block of the piece with the sums of the blocks of data that came whole
+// we only hash blocks once we need to tell who sent a bad one
+func (p *cachedPiece) hashBlocks(data []byte) (blocks []blockRecord) {
+	p.mtx.Lock()
+	blocks = append(blocks, p.blocks...)
+	p.mtx.Unlock()
+	for idx := range blocks {
+		start, stop := p.blockSpan(uint32(idx))
+		if !blocks[idx].whole || int(stop) > len(data) {
+			blocks[idx].whole = false
+			continue
+		}
+		blocks[idx].sum = sha1.Sum(data[start:stop])
+	}
+	return
+}
+
+// get who sent blocks of a bad copy of a piece that differ from the good copy
+func culprits(bad, good []blockRecord) (from []string) {
+	seen := make(map[string]bool)
+	for idx := range bad {
+		if idx >= len(good) || !bad[idx].whole || !good[idx].whole || bad[idx].from == "" {
+			continue
+		}
+		if bad[idx].sum != good[idx].sum && !seen[bad[idx].from] {
+			seen[bad[idx].from] = true
+			from = append(from, bad[idx].from)
+		}
+	}
+	return
+}
+
+// get the data we have of a downloaded piece, from memory or from storage if we wrote it through
+func (pt *pieceTracker) pieceData(pc *cachedPiece) []byte {
+	if pc.data != nil {
+		return pc.data
+	}
+	d := common.PieceData{Index: pc.index, Data: make([]byte, pc.length)}
+	if err := pt.st.GetPiece(common.PieceRequest{Index: pc.index, Length: pc.length}, &d); err != nil {
+		log.Warnf("can't read piece %d to check its blocks: %s", pc.index, err.Error())
+		return nil
+	}
+	return d.Data
+}
+
+// a piece failed verification, tell who sent it to us and pin it on them once we know who sent the bad blocks
+// with a single sender we know right away, with several we keep the sums of the blocks until a good copy comes in
+func (pt *pieceTracker) badPiece(pc *cachedPiece) {
+	from := pc.senders()
+	if pt.bad != nil {
+		pt.bad(pc.index, from)
+	}
+	if len(from) == 1 {
+		if pt.blame != nil {
+			pt.blame(pc.index, from)
+		}
+		return
+	}
+	data := pt.pieceData(pc)
+	if data == nil {
+		return
+	}
+	blocks := pc.hashBlocks(data)
+	pt.mtx.Lock()
+	pt.suspects[pc.index] = blocks
+	pt.mtx.Unlock()
+}
+
+// a piece passed verification, pin an earlier bad copy of it on who sent the blocks that differ
+func (pt *pieceTracker) goodPiece(pc *cachedPiece) {
+	pt.mtx.Lock()
+	bad, ok := pt.suspects[pc.index]
+	delete(pt.suspects, pc.index)
+	pt.mtx.Unlock()
+	if !ok {
+		return
+	}
+	data := pt.pieceData(pc)
+	if data == nil {
+		return
+	}
+	from := culprits(bad, pc.hashBlocks(data))
+	if len(from) > 0 && pt.blame != nil {
+		pt.blame(pc.index, from)
+	}
+}
+
+// the destinations in from sent blocks that made piece idx fail verification, ban them once they did it often enough
+func (t *Torrent) onBlame(idx uint32, from []string) {
+	for _, addr := range from {
+		n := t.remotes.blame(addr)
+		log.Warnf("%s sent bad data for piece %d of %s, %d bad pieces pinned on it", addr, idx, t.Name(), n)
+		if n < BanAfterBadPieces {
+			continue
+		}
+		log.Warnf("banning %s for sending %d bad pieces", addr, n)
+		for _, c := range t.remotes.ban(addr) {
+			c.Close()
+		}
+		if t.audit != nil {
+			t.audit(audit.Event{
+				Action:   audit.ActionBan,
+				Infohash: t.Infohash().Hex(),
+				Detail:   fmt.Sprintf("%s sent %d pieces that failed verification", addr, n),
+			})
+		}
+	}
+}
+
+// pin a bad piece on a destination, returns how many are pinned on it
+func (r *remotePeers) blame(addr string) (n int) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	h := r.historyFor(addr, time.Now())
+	if h != nil {
+		h.blamed++
+		n = h.blamed
+	}
+	r.access.Unlock()
+	return
+}
+
+// ban a destination for the rest of the session, returns its connections to close
+func (r *remotePeers) ban(addr string) (conns []*PeerConn) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	if r.bans == nil {
+		r.bans = make(map[string]bool)
+	}
+	r.bans[addr] = true
+	if p, ok := r.peers[addr]; ok {
+		for _, c := range p.conns {
+			conns = append(conns, c)
+		}
+	}
+	r.access.Unlock()
+	return
+}
+
+// returns true if we banned a destination
+func (r *remotePeers) banned(addr string) (banned bool) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	banned = r.bans[addr]
+	r.access.Unlock()
+	return
+}
@@ -0,0 +1,79 @@
+package swarm
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"testing"
+)
+
+func newBlamePiece() *cachedPiece {
+	return &cachedPiece{
+		pending:  bittorrent.NewBitfield(3, nil),
+		obtained: bittorrent.NewBitfield(3, nil),
+		length:   BlockSize*2 + 100,
+		index:    7,
+	}
+}
+
+func TestBlameBadBlocks(t *testing.T) {
+	good := bytes.Repeat([]byte{1}, BlockSize*2+100)
+	bad := append([]byte{}, good...)
+	bad[BlockSize+5] = 0
+	var blamed []string
+	pt := &pieceTracker{
+		suspects: make(map[uint32][]blockRecord),
+		blame: func(_ uint32, from []string) {
+			blamed = append(blamed, from...)
+		},
+	}
+	// the first two blocks came in one request
+	pc := newBlamePiece()
+	pc.data = bad
+	pc.record(0, bad[:BlockSize*2], "honest")
+	pc.record(BlockSize*2, bad[BlockSize*2:], "liar")
+	pc.record(BlockSize, bad[BlockSize:BlockSize*2], "liar")
+	for idx := range pc.blocks {
+		if pc.blocks[idx].sum != ([sha1.Size]byte{}) {
+			t.Fatal("hashed a block before the piece failed")
+		}
+	}
+	pt.badPiece(pc)
+	if len(blamed) != 0 {
+		t.Fatalf("blamed %v before we know who sent the bad block", blamed)
+	}
+	pc = newBlamePiece()
+	pc.data = good
+	pc.record(0, good, "webseed")
+	pt.goodPiece(pc)
+	if len(blamed) != 1 || blamed[0] != "liar" {
+		t.Fatalf("blamed %v for a bad block liar sent", blamed)
+	}
+	if len(pt.suspects) != 0 {
+		t.Fatal("kept a bad piece after a good copy came in")
+	}
+	blamed = nil
+	pc = newBlamePiece()
+	pc.data = bad
+	pc.record(0, bad, "liar")
+	pt.badPiece(pc)
+	if len(blamed) != 1 || blamed[0] != "liar" {
+		t.Fatalf("blamed %v for a bad piece only liar sent", blamed)
+	}
+}
+
+func TestBanHistory(t *testing.T) {
+	var r remotePeers
+	for n := 1; n <= BanAfterBadPieces; n++ {
+		if r.blame("liar") != n {
+			t.Fatalf("%d bad pieces not counted", n)
+		}
+	}
+	if r.banned("liar") {
+		t.Fatal("banned before we asked to")
+	}
+	r.ban("liar")
+	if !r.banned("liar") || r.banned("honest") {
+		t.Fatal("banned the wrong destination")
+	}
+}
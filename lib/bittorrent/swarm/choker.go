@@ -0,0 +1,218 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/log"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUploadSlots is how many peers with the best rates a torrent unchokes besides the optimistic unchoke
+const DefaultUploadSlots = 4
+
+// ChokeInterval is how often a torrent picks which peers to unchoke
+const ChokeInterval = time.Second * 10
+
+// OptimisticUnchokeInterval is how often the optimistic unchoke moves to another peer
+const OptimisticUnchokeInterval = time.Second * 30
+
+// DefaultSeedSlotTurn is how long a peer keeps an upload slot of a torrent we seed while others wait for one
+const DefaultSeedSlotTurn = time.Minute
+
+// the rate a peer earns its upload slot with, what it gives us while we download and what it takes while we seed
+func (c *PeerConn) chokeRate(seeding bool) float64 {
+	if seeding {
+		return c.tx.Mean()
+	}
+	return c.rx.Mean()
+}
+
+// pick the indexes of the slots best rates, ties keep their order
+func bestRates(rates []float64, slots int) []int {
+	idxs := make([]int, len(rates))
+	for idx := range idxs {
+		idxs[idx] = idx
+	}
+	sort.SliceStable(idxs, func(i, j int) bool {
+		return rates[idxs[i]] > rates[idxs[j]]
+	})
+	if slots < 0 {
+		slots = 0
+	}
+	if len(idxs) > slots {
+		idxs = idxs[:slots]
+	}
+	return idxs
+}
+
+// a peer wanting an upload slot of a torrent we seed
+type seedCandidate struct {
+	// true if it has a slot now
+	unchoked bool
+	// when it got its slot if it has one, when it lost its last one if it doesn't
+	since time.Time
+	// how fast we upload to it
+	rate float64
+}
+
+// pick the indexes of the peers that get the upload slots of a torrent we seed, slots of them at most
+// peers keep their slot for turn, fastest first, then the peers that waited longest take over the slots of the ones whose turn is over
+func pickSeedSlots(peers []seedCandidate, slots int, now time.Time, turn time.Duration) []int {
+	// 0 for peers in their turn, 1 for waiting peers and 2 for peers whose turn is over
+	class := func(p seedCandidate) int {
+		if !p.unchoked {
+			return 1
+		}
+		if turn > 0 && now.Sub(p.since) >= turn {
+			return 2
+		}
+		return 0
+	}
+	idxs := make([]int, len(peers))
+	for idx := range idxs {
+		idxs[idx] = idx
+	}
+	sort.SliceStable(idxs, func(i, j int) bool {
+		a, b := peers[idxs[i]], peers[idxs[j]]
+		ca, cb := class(a), class(b)
+		if ca != cb {
+			return ca < cb
+		}
+		if ca == 1 {
+			return a.since.Before(b.since)
+		}
+		return a.rate > b.rate
+	})
+	if slots < 0 {
+		slots = 0
+	}
+	if len(idxs) > slots {
+		idxs = idxs[:slots]
+	}
+	return idxs
+}
+
+// returns true if c may get an upload slot, it has to want something and not have sat on the slot it had
+func (t *Torrent) wantsSlot(c *PeerConn, now time.Time) bool {
+	if !c.peerInterested || c.idleChoked {
+		return false
+	}
+	if !c.usChoke && t.IdleUploadTimeout > 0 && c.idleSince(now) > t.IdleUploadTimeout {
+		log.Debugf("%s has been idle for %s, choking", c.id.String(), t.IdleUploadTimeout)
+		c.idleChoked = true
+		return false
+	}
+	return true
+}
+
+// tit for tat, every ChokeInterval unchoke the interested peers with the best recent rates and one optimistic unchoke that moves every OptimisticUnchokeInterval
+// while seeding interested peers take turns on the upload slots instead, so we don't upload to everyone at once and swamp our tunnels
+// choke everyone else, only called by tick so all choking happens on one goroutine
+func (t *Torrent) rechoke(now time.Time) {
+	if now.Sub(t.lastChoke) < ChokeInterval {
+		return
+	}
+	t.lastChoke = now
+	seeding := t.Done()
+	var peers, wanting []*PeerConn
+	var rates []float64
+	var seeds []seedCandidate
+	t.VisitPeers(func(c *PeerConn) {
+		peers = append(peers, c)
+		if t.wantsSlot(c, now) {
+			wanting = append(wanting, c)
+			rate := c.chokeRate(seeding)
+			rates = append(rates, rate)
+			since := c.chokedAt
+			if !c.usChoke {
+				since = c.unchokedAt
+			}
+			seeds = append(seeds, seedCandidate{unchoked: !c.usChoke, since: since, rate: rate})
+		}
+	})
+	var best []int
+	if seeding {
+		best = pickSeedSlots(seeds, t.UploadSlots, now, t.SeedSlotTurn)
+	} else {
+		best = bestRates(rates, t.UploadSlots)
+	}
+	unchoke := make(map[*PeerConn]bool)
+	for _, idx := range best {
+		unchoke[wanting[idx]] = true
+	}
+	t.chokeMtx.Lock()
+	optimistic := t.optimistic
+	stillWants := false
+	for _, c := range wanting {
+		if c == optimistic {
+			stillWants = true
+		}
+	}
+	if !stillWants || unchoke[optimistic] || now.Sub(t.optimisticAt) >= OptimisticUnchokeInterval {
+		// give a peer that didn't earn a slot a chance to show what it has
+		optimistic = nil
+		var others []*PeerConn
+		for _, c := range wanting {
+			if !unchoke[c] {
+				others = append(others, c)
+			}
+		}
+		if len(others) > 0 {
+			optimistic = others[rand.Intn(len(others))]
+			t.optimisticAt = now
+			log.Debugf("%s: optimistically unchoking %s", t.Name(), optimistic.id.String())
+		}
+		t.optimistic = optimistic
+	}
+	t.chokeMtx.Unlock()
+	if optimistic != nil {
+		unchoke[optimistic] = true
+	}
+	for _, c := range peers {
+		if unchoke[c] {
+			c.Unchoke()
+		} else if !c.usChoke {
+			c.Choke()
+		}
+	}
+}
+
+// returns true if c has the optimistic unchoke
+func (t *Torrent) isOptimistic(c *PeerConn) bool {
+	t.chokeMtx.Lock()
+	defer t.chokeMtx.Unlock()
+	return t.optimistic == c
+}
+
+// give up the optimistic unchoke of a peer that closed so the next rechoke picks another one
+func (t *Torrent) dropOptimistic(c *PeerConn) {
+	t.chokeMtx.Lock()
+	if t.optimistic == c {
+		t.optimistic = nil
+	}
+	t.chokeMtx.Unlock()
+}
+
+// unchoke the peers that got interested since the last tick if upload slots are free, only called by tick
+func (t *Torrent) unchokeAsking() {
+	t.VisitPeers(func(c *PeerConn) {
+		if atomic.CompareAndSwapInt32(&c.askedUnchoke, 1, 0) {
+			t.unchokeIfFree(c)
+		}
+	})
+}
+
+// unchoke a peer that just got interested right away if not every upload slot is taken
+func (t *Torrent) unchokeIfFree(c *PeerConn) {
+	unchoked := 0
+	t.VisitPeers(func(other *PeerConn) {
+		if other != c && !other.usChoke && other.peerInterested {
+			unchoked++
+		}
+	})
+	// the optimistic unchoke gets a slot too
+	if unchoked < t.UploadSlots+1 {
+		c.Unchoke()
+	}
+}
@@ -0,0 +1,199 @@
+package swarm
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/storage"
+	"github.com/majestrate/XD/lib/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// storage that only knows which pieces we have
+type bitfieldStorage struct {
+	storage.Torrent
+	bf *bittorrent.Bitfield
+}
+
+func (s *bitfieldStorage) Bitfield() *bittorrent.Bitfield {
+	return s.bf
+}
+
+func (s *bitfieldStorage) MetaInfo() *metainfo.TorrentFile {
+	return nil
+}
+
+func (s *bitfieldStorage) Infohash() (ih common.Infohash) {
+	return
+}
+
+// a torrent with n interested choked peers that send us and take from us rates[i] bytes, seeding if done
+func chokeTestTorrent(done bool, rates ...uint64) (*Torrent, []*PeerConn) {
+	bf := bittorrent.NewBitfield(8, nil)
+	if done {
+		for idx := uint32(0); idx < 8; idx++ {
+			bf.Set(idx)
+		}
+	}
+	t := &Torrent{
+		st:          &bitfieldStorage{bf: bf},
+		ibconns:     make(map[string]*PeerConn),
+		obconns:     make(map[string]*PeerConn),
+		UploadSlots: 2,
+	}
+	var peers []*PeerConn
+	for idx, rate := range rates {
+		c := &PeerConn{
+			t:              t,
+			peerInterested: true,
+			usChoke:        true,
+			rx:             util.NewRate(10),
+			tx:             util.NewRate(10),
+		}
+		c.rx.AddSample(rate)
+		c.tx.AddSample(rate)
+		t.ibconns[fmt.Sprintf("peer%d", idx)] = c
+		peers = append(peers, c)
+	}
+	return t, peers
+}
+
+// the indexes of the peers we unchoked
+func unchokedPeers(peers []*PeerConn) (unchoked []int) {
+	for idx, c := range peers {
+		if !c.usChoke {
+			unchoked = append(unchoked, idx)
+		}
+	}
+	return
+}
+
+func TestBestRates(t *testing.T) {
+	rates := []float64{10, 50, 0, 50, 30}
+	if best := bestRates(rates, 3); !reflect.DeepEqual(best, []int{1, 3, 4}) {
+		t.Fatalf("picked %v", best)
+	}
+	if best := bestRates(rates, 10); len(best) != len(rates) {
+		t.Fatalf("picked %v out of %d peers", best, len(rates))
+	}
+	if best := bestRates(rates, 0); len(best) != 0 {
+		t.Fatalf("picked %v with no slots", best)
+	}
+}
+
+func TestPickSeedSlots(t *testing.T) {
+	now := time.Now()
+	peers := []seedCandidate{
+		// fast peer whose turn is over
+		{unchoked: true, since: now.Add(-time.Minute * 2), rate: 100},
+		// slow peer still in its turn
+		{unchoked: true, since: now.Add(-time.Second * 10), rate: 1},
+		// waited a little and a long time
+		{since: now.Add(-time.Second * 5)},
+		{since: now.Add(-time.Minute)},
+	}
+	if slots := pickSeedSlots(peers, 2, now, time.Minute); !reflect.DeepEqual(slots, []int{1, 3}) {
+		t.Fatalf("picked %v", slots)
+	}
+	if slots := pickSeedSlots(peers, 4, now, time.Minute); !reflect.DeepEqual(slots, []int{1, 3, 2, 0}) {
+		t.Fatalf("picked %v with a slot for everyone", slots)
+	}
+	if slots := pickSeedSlots(peers, 2, now, 0); !reflect.DeepEqual(slots, []int{0, 1}) {
+		t.Fatalf("picked %v without turns", slots)
+	}
+}
+
+func TestRechoke(t *testing.T) {
+	tr, peers := chokeTestTorrent(false, 10, 50, 0, 40, 5)
+	now := time.Now()
+	tr.rechoke(now)
+	unchoked := unchokedPeers(peers)
+	if len(unchoked) != 3 || peers[1].usChoke || peers[3].usChoke {
+		t.Fatalf("unchoked %v", unchoked)
+	}
+	opt := tr.optimistic
+	if opt == nil || opt.usChoke || opt == peers[1] || opt == peers[3] {
+		t.Fatal("no optimistic unchoke besides the fastest peers")
+	}
+	// the optimistic unchoke goes away
+	tr.dropOptimistic(opt)
+	if tr.isOptimistic(opt) {
+		t.Fatal("closed peer kept the optimistic unchoke")
+	}
+	for k, c := range tr.ibconns {
+		if c == opt {
+			delete(tr.ibconns, k)
+		}
+	}
+	tr.rechoke(now.Add(ChokeInterval))
+	if tr.optimistic == nil || tr.optimistic == opt || tr.optimistic.usChoke {
+		t.Fatal("no new optimistic unchoke after the old one closed")
+	}
+	// a peer that gets interested waits for the next tick
+	late := &PeerConn{t: tr, usChoke: true, peerInterested: true, rx: util.NewRate(10), tx: util.NewRate(10)}
+	late.askedUnchoke = 1
+	tr.ibconns["late"] = late
+	tr.UploadSlots = 10
+	tr.unchokeAsking()
+	if late.usChoke || late.askedUnchoke != 0 {
+		t.Fatal("interested peer not unchoked with free slots")
+	}
+}
+
+func TestRechokeSeeding(t *testing.T) {
+	tr, peers := chokeTestTorrent(true, 100, 1, 50, 50)
+	tr.SeedSlotTurn = time.Minute
+	now := time.Now()
+	for idx, c := range peers {
+		// peer 0 waited longest
+		c.chokedAt = now.Add(-time.Minute * time.Duration(4-idx))
+	}
+	tr.rechoke(now)
+	if peers[0].usChoke || peers[1].usChoke || len(unchokedPeers(peers)) != 3 {
+		t.Fatalf("unchoked %v, not the peers that waited longest and an optimistic unchoke", unchokedPeers(peers))
+	}
+	var waiting *PeerConn
+	for _, c := range peers {
+		if c.usChoke {
+			waiting = c
+		}
+	}
+	// every turn is over, the peer left out takes a slot
+	tr.rechoke(now.Add(time.Minute * 2))
+	if waiting.usChoke || len(unchokedPeers(peers)) > 3 {
+		t.Fatalf("unchoked %v after the turns were over", unchokedPeers(peers))
+	}
+}
+
+func TestIdleChoke(t *testing.T) {
+	tr, peers := chokeTestTorrent(true, 10)
+	tr.IdleUploadTimeout = time.Minute
+	c := peers[0]
+	now := time.Now()
+	c.usChoke = false
+	c.unchokedAt = now.Add(-time.Minute * 2)
+	if tr.wantsSlot(c, now) || !c.idleChoked {
+		t.Fatal("peer that sat on its slot kept it")
+	}
+	c.usChoke = true
+	if tr.wantsSlot(c, now) {
+		t.Fatal("idle choked peer wants a slot before asking for anything")
+	}
+	req := common.PieceRequest{Index: 0, Begin: 0, Length: BlockSize}
+	if err := c.inboundMessage(req.ToWireMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if !tr.wantsSlot(c, now) {
+		t.Fatal("idle choked peer that asked for a piece gets no slot")
+	}
+	c.idleChoked = true
+	if err := c.inboundMessage(common.NewWireMessage(common.Interested, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !tr.wantsSlot(c, now) {
+		t.Fatal("idle choked peer that got interested again gets no slot")
+	}
+}
@@ -0,0 +1,97 @@
+package swarm
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConnState is the scheduling state of one connection, for controllers outside of XD that make their own choking decisions
+// field names follow the peer state names of BEP 3
+type ConnState struct {
+	InfoHash string `json:"info_hash"`
+	PeerID   string `json:"peer_id"`
+	Addr     string `json:"addr"`
+	Client   string `json:"client"`
+	Inbound  bool   `json:"inbound"`
+	// supports the fast extension, choking it rejects its requests instead of dropping them silently
+	Fast           bool `json:"fast"`
+	AmChoking      bool `json:"am_choking"`
+	AmInterested   bool `json:"am_interested"`
+	PeerChoking    bool `json:"peer_choking"`
+	PeerInterested bool `json:"peer_interested"`
+	// it has the optimistic unchoke
+	Optimistic bool `json:"optimistic"`
+	// it sat on our requests for longer than the snub timeout
+	Snubbed bool `json:"snubbed"`
+	// we stopped counting its pieces after it broke too many claims to have them
+	Distrusted bool `json:"distrusted"`
+	// bytes per second
+	UploadRate   float64 `json:"upload_rate"`
+	DownloadRate float64 `json:"download_rate"`
+	// requests we have outstanding with it and the most we keep
+	RequestQueue    int `json:"request_queue"`
+	MaxRequestQueue int `json:"max_request_queue"`
+	// requests it made that wait for their turn, and blocks we queued for it that are not written yet
+	UploadQueue    int `json:"upload_queue"`
+	BlocksInFlight int `json:"blocks_in_flight"`
+	// messages waiting to be written to it
+	SendQueue int `json:"send_queue"`
+	// pieces it has
+	Pieces int `json:"pieces"`
+	// milliseconds it takes to answer our requests, 0 until it answered one
+	RTT int64 `json:"rtt"`
+	// seconds since it sent us anything
+	Idle int64 `json:"idle"`
+	// seconds we have had it unchoked, 0 while we choke it
+	UnchokedFor int64 `json:"unchoked_for"`
+}
+
+// ConnState gets the scheduling state of this connection
+func (c *PeerConn) ConnState(now time.Time) (st ConnState) {
+	st.InfoHash = c.t.Infohash().Hex()
+	st.PeerID = c.id.String()
+	st.Addr = c.c.RemoteAddr().String()
+	st.Client = c.t.remotes.ClientName(c.c.RemoteAddr(), c.id)
+	st.Inbound = c.inbound
+	st.Fast = c.fast
+	st.AmChoking = c.usChoke
+	st.AmInterested = c.usInterested
+	st.PeerChoking = c.peerChoke
+	st.PeerInterested = c.peerInterested
+	st.Optimistic = c.t.isOptimistic(c)
+	st.Snubbed = c.Snubbed()
+	st.Distrusted = c.Distrusted()
+	st.UploadRate = c.tx.Mean()
+	st.DownloadRate = c.rx.Mean()
+	st.RequestQueue = c.numDownloading()
+	st.MaxRequestQueue = c.maxRequests()
+	st.UploadQueue = c.numUploads()
+	st.BlocksInFlight = int(atomic.LoadInt32(&c.blocksInFlight))
+	st.SendQueue = c.queued()
+	if bf := c.Bitfield(); bf != nil {
+		st.Pieces = bf.CountSet()
+	}
+	st.RTT = int64(c.RTT() / time.Millisecond)
+	st.Idle = int64(c.silentFor(now) / time.Second)
+	if !c.usChoke && !c.unchokedAt.IsZero() {
+		st.UnchokedFor = int64(now.Sub(c.unchokedAt) / time.Second)
+	}
+	return
+}
+
+// ConnStates gets the scheduling state of every connection of this torrent
+func (t *Torrent) ConnStates() (states []ConnState) {
+	now := time.Now()
+	t.VisitPeers(func(c *PeerConn) {
+		states = append(states, c.ConnState(now))
+	})
+	return
+}
+
+// ConnStates gets the scheduling state of every connection of every torrent
+func (sw *Swarm) ConnStates() (states []ConnState) {
+	sw.Torrents.ForEachTorrent(func(t *Torrent) {
+		states = append(states, t.ConnStates()...)
+	})
+	return
+}
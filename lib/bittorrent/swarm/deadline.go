@@ -0,0 +1,74 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"time"
+)
+
+// SetPieceDeadline asks for piece idx within d, pieces with a deadline are downloaded before any others, soonest first
+// a player streaming the torrent moves the deadlines along with its playback position, d <= 0 clears the deadline of the piece
+func (t *Torrent) SetPieceDeadline(idx uint32, d time.Duration) error {
+	if !t.Ready() {
+		return ErrNoMetaInfo
+	}
+	if idx >= t.MetaInfo().Info.NumPieces() {
+		return bittorrent.ErrBadPieceRange
+	}
+	t.connMtx.Lock()
+	if d <= 0 {
+		delete(t.deadlines, idx)
+	} else {
+		if t.deadlines == nil {
+			t.deadlines = make(map[uint32]time.Time)
+		}
+		t.deadlines[idx] = time.Now().Add(d)
+	}
+	t.connMtx.Unlock()
+	return nil
+}
+
+// ClearPieceDeadlines drops every piece deadline, pieces go back to being picked rarest first
+func (t *Torrent) ClearPieceDeadlines() {
+	t.connMtx.Lock()
+	t.deadlines = nil
+	t.connMtx.Unlock()
+}
+
+// PieceDeadlines gets the pieces with a deadline that we don't have yet and when we want them by
+func (t *Torrent) PieceDeadlines() map[uint32]time.Time {
+	deadlines := make(map[uint32]time.Time)
+	bf := t.Bitfield()
+	t.connMtx.Lock()
+	for idx, at := range t.deadlines {
+		if bf != nil && bf.Has(idx) {
+			delete(t.deadlines, idx)
+			continue
+		}
+		deadlines[idx] = at
+	}
+	t.connMtx.Unlock()
+	return deadlines
+}
+
+// pick the piece remote has with the soonest deadline, late pieces come first
+func pickDeadline(remote *bittorrent.Bitfield, deadlines map[uint32]time.Time, excluded func(uint32) bool) (idx uint32, has bool) {
+	var soonest time.Time
+	for i, at := range deadlines {
+		if !remote.Has(i) || excluded(i) {
+			continue
+		}
+		if !has || at.Before(soonest) || (at.Equal(soonest) && i < idx) {
+			idx, soonest, has = i, at, true
+		}
+	}
+	return
+}
+
+// pick a piece with a deadline to get from remote, only called by getRarestPiece
+func (t *Torrent) pickDeadlinePiece(remote *bittorrent.Bitfield, excluded func(uint32) bool) (idx uint32, has bool) {
+	deadlines := t.PieceDeadlines()
+	if len(deadlines) == 0 {
+		return
+	}
+	return pickDeadline(remote, deadlines, excluded)
+}
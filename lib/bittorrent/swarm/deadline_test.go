@@ -0,0 +1,36 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"testing"
+	"time"
+)
+
+func TestPickDeadline(t *testing.T) {
+	now := time.Now()
+	deadlines := map[uint32]time.Time{
+		1: now.Add(time.Second * 5),
+		2: now.Add(time.Second),
+		3: now.Add(-time.Second),
+	}
+	remote := bittorrent.NewBitfield(4, nil)
+	remote.Set(1)
+	remote.Set(2)
+	none := func(uint32) bool { return false }
+	idx, has := pickDeadline(remote, deadlines, none)
+	if !has || idx != 2 {
+		t.Fatalf("picked %d %v, wanted 2", idx, has)
+	}
+	remote.Set(3)
+	idx, has = pickDeadline(remote, deadlines, none)
+	if !has || idx != 3 {
+		t.Fatalf("picked %d %v, wanted the late piece 3", idx, has)
+	}
+	idx, has = pickDeadline(remote, deadlines, func(idx uint32) bool { return idx != 1 })
+	if !has || idx != 1 {
+		t.Fatalf("picked %d %v, wanted 1 since the others are excluded", idx, has)
+	}
+	if _, has = pickDeadline(bittorrent.NewBitfield(4, nil), deadlines, none); has {
+		t.Fatal("picked a piece the peer doesn't have")
+	}
+}
@@ -0,0 +1,90 @@
+package swarm
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// DebugStats are internal counters for finding out why a swarm stalls
+type DebugStats struct {
+	Goroutines int
+	// outbound dials running and waiting for a free slot
+	DialsActive  int
+	DialsWaiting int
+	DialLimit    int
+	// pieces we are downloading into memory and verified pieces waiting to be written in order
+	PiecesPending int
+	PiecesHeld    int
+	// messages queued to peers, summed over all peers and the most queued for one peer
+	SendQueued    int
+	SendQueuedMax int
+	Peers         int
+	// announces running or waiting for the one before them, and trackers we should have announced to by now
+	Announcing       int
+	AnnouncesOverdue int
+	Torrents         int
+	// pieces that failed verification since we started
+	VerifyFailures uint64
+	// torrents waiting for their turn to verify
+	VerifyWaiting int
+	// disk reads and writes waiting for an io worker
+	DiskQueued int
+}
+
+// DebugStats collects internal counters for this swarm
+func (sw *Swarm) DebugStats() (st DebugStats) {
+	st.Goroutines = runtime.NumGoroutine()
+	st.DialsActive, st.DialsWaiting, st.DialLimit = sw.dials.stats()
+	st.VerifyWaiting = sw.Torrents.verifyQueue().numWaiting()
+	st.DiskQueued = sw.Torrents.st.QueuedIO()
+	now := time.Now()
+	sw.Torrents.ForEachTorrent(func(t *Torrent) {
+		st.Torrents++
+		st.VerifyFailures += t.VerifyFailures()
+		pending, held := t.pt.queueDepth()
+		st.PiecesPending += pending
+		st.PiecesHeld += held
+		t.VisitPeers(func(c *PeerConn) {
+			st.Peers++
+			q := c.queued()
+			st.SendQueued += q
+			if q > st.SendQueuedMax {
+				st.SendQueuedMax = q
+			}
+		})
+		st.Announcing += int(atomic.LoadInt32(&t.announcing))
+		t.announceMtx.Lock()
+		for _, a := range t.announcers {
+			a.statusMtx.Lock()
+			if !a.lastNext.IsZero() && now.After(a.lastNext) {
+				st.AnnouncesOverdue++
+			}
+			a.statusMtx.Unlock()
+		}
+		t.announceMtx.Unlock()
+	})
+	return
+}
+
+// get running and waiting dials and the current limit
+func (l *dialLimiter) stats() (active, waiting, limit int) {
+	l.access.Lock()
+	limit = cap(l.tokens) - l.hold
+	active = cap(l.tokens) - l.held - len(l.tokens)
+	l.access.Unlock()
+	if active < 0 {
+		active = 0
+	}
+	waiting = int(atomic.LoadInt32(&l.waiting))
+	return
+}
+
+// get how many pieces are being downloaded and how many verified pieces wait to be written
+func (pt *pieceTracker) queueDepth() (pending, held int) {
+	pt.mtx.Lock()
+	pending = len(pt.requests)
+	held = len(pt.held)
+	pt.mtx.Unlock()
+	return
+}
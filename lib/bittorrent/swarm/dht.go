@@ -0,0 +1,101 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/dht"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"sync/atomic"
+	"time"
+)
+
+// DHTAnnounceInterval is how often we look up peers of a torrent on the dht and announce it there
+const DHTAnnounceInterval = time.Minute * 15
+
+// how long we wait before trying the dht again when it had no nodes for us
+const dhtRetryInterval = time.Minute
+
+// EnableDHT makes torrents that are not private find peers on the dht, it runs once we have a network
+func (sw *Swarm) EnableDHT() {
+	sw.xdht.Enable()
+}
+
+// run the dht over n until we lose it
+func (sw *Swarm) runDHT(n network.Network) {
+	err := sw.xdht.Run(n)
+	if err == network.ErrNoDatagrams {
+		log.Warn("network cannot exchange datagrams, not using the dht")
+	} else {
+		log.Infof("dht stopped: %s", err.Error())
+	}
+}
+
+// returns true if it is time to look up peers on the dht, the next one is due an interval later
+func (t *Torrent) dhtAnnounceDue() bool {
+	if t.xdht == nil || !t.xdht.Enabled() || t.Private() || atomic.LoadInt32(&t.dhtAnnouncing) != 0 || !t.onPinnedNetwork() {
+		return false
+	}
+	t.announceMtx.Lock()
+	defer t.announceMtx.Unlock()
+	if time.Now().Before(t.nextDHTAnnounce) {
+		return false
+	}
+	t.nextDHTAnnounce = t.tierAnnounceTime(time.Now().Add(DHTAnnounceInterval))
+	return true
+}
+
+// look up peers on the dht, connect to them and announce that we are one too
+func (t *Torrent) announceDHT() {
+	if t.Private() || !atomic.CompareAndSwapInt32(&t.dhtAnnouncing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&t.dhtAnnouncing, 0)
+	us, ok := t.Network().Addr().(i2p.Addr)
+	if !ok {
+		return
+	}
+	ihs := []common.Infohash{t.Infohash()}
+	if ih, ok := t.hybridInfohash(); ok {
+		// be in the v2 swarm too
+		ihs = append(ihs, ih)
+	}
+	for _, ih := range ihs {
+		dests, err := t.xdht.Announce(ih, us.Base32Addr())
+		if err != nil {
+			log.Warnf("dht announce of %s failed: %s", ih.Hex(), err.Error())
+			t.announceMtx.Lock()
+			t.nextDHTAnnounce = time.Now().Add(dhtRetryInterval)
+			t.announceMtx.Unlock()
+			return
+		}
+		log.Infof("dht got %d peers for %s", len(dests), ih.Hex())
+		peers := make([]common.Peer, len(dests))
+		for idx := range dests {
+			peers[idx].Compact = dests[idx]
+		}
+		t.addPeers(peers)
+	}
+}
+
+// tell the peer where our dht node is so its dht can find ours
+func (c *PeerConn) sendXDHT() {
+	id, ok := c.theirExt.ID(extensions.XDHT)
+	if !ok || c.t.xdht == nil {
+		return
+	}
+	msg := c.t.xdht.PingMessage()
+	if msg == nil {
+		return
+	}
+	m := extensions.New()
+	m.ID = id
+	m.Payload = msg
+	c.Send(m.ToWireMessage())
+}
+
+// DHT gets the dht this swarm finds peers on
+func (sw *Swarm) DHT() *dht.XDHT {
+	return &sw.xdht
+}
@@ -0,0 +1,57 @@
+package swarm
+
+import (
+	"sort"
+)
+
+// TorrentDiskUsage is how much disk a torrent's files take
+type TorrentDiskUsage struct {
+	Infohash string
+	Name     string
+	Dir      string
+	// bytes our files say they are and bytes they really take on disk, sparse files take less than they say
+	Size uint64
+	Used uint64
+}
+
+// DirDiskUsage is how much disk the torrents in one download directory take
+type DirDiskUsage struct {
+	Dir      string
+	Torrents int
+	Size     uint64
+	Used     uint64
+}
+
+// DiskUsage gets how much disk this torrent's files take
+func (t *Torrent) DiskUsage() (u TorrentDiskUsage) {
+	u.Infohash = t.Infohash().Hex()
+	u.Name = t.Name()
+	u.Dir = t.DownloadDir()
+	u.Size, u.Used = t.st.DiskUsage()
+	return
+}
+
+// SumDiskUsage adds up the disk usage of torrents by download directory, the directories using the most come first
+func SumDiskUsage(torrents []TorrentDiskUsage) (dirs []DirDiskUsage) {
+	byDir := make(map[string]*DirDiskUsage)
+	for _, u := range torrents {
+		d, ok := byDir[u.Dir]
+		if !ok {
+			d = &DirDiskUsage{Dir: u.Dir}
+			byDir[u.Dir] = d
+		}
+		d.Torrents++
+		d.Size += u.Size
+		d.Used += u.Used
+	}
+	for _, d := range byDir {
+		dirs = append(dirs, *d)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Used == dirs[j].Used {
+			return dirs[i].Dir < dirs[j].Dir
+		}
+		return dirs[i].Used > dirs[j].Used
+	})
+	return
+}
@@ -0,0 +1,132 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxDials is how many outbound peer connections we try to open at once
+const DefaultMaxDials = 32
+
+// StrugglingMaxDials is how many outbound peer connections we try to open at once while the router is struggling
+const StrugglingMaxDials = 4
+
+// StrugglingAnnounceDelay is how long announces are put off while the router is struggling
+const StrugglingAnnounceDelay = time.Minute * 5
+
+// how often we look at the network's health
+const healthCheckInterval = time.Second * 10
+
+// limits how many dials run at once, the limit can be lowered by holding back tokens
+type dialLimiter struct {
+	tokens chan struct{}
+	access sync.Mutex
+	// tokens we hold back and how many we want to, running dials give theirs up when they finish until we hold enough
+	held int
+	hold int
+	// dials blocked in acquire
+	waiting int32
+}
+
+func newDialLimiter(n int) *dialLimiter {
+	l := &dialLimiter{
+		tokens: make(chan struct{}, n),
+	}
+	for n > 0 {
+		l.tokens <- struct{}{}
+		n--
+	}
+	return l
+}
+
+func (l *dialLimiter) acquire() {
+	atomic.AddInt32(&l.waiting, 1)
+	<-l.tokens
+	atomic.AddInt32(&l.waiting, -1)
+}
+
+func (l *dialLimiter) release() {
+	l.access.Lock()
+	if l.held < l.hold {
+		l.held++
+	} else {
+		l.tokens <- struct{}{}
+	}
+	l.access.Unlock()
+}
+
+// allow at most n dials at once, dials running past the limit finish first
+func (l *dialLimiter) setLimit(n int) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.hold = cap(l.tokens) - n
+	if l.hold < 0 {
+		l.hold = 0
+	}
+	for l.held < l.hold {
+		select {
+		case <-l.tokens:
+			l.held++
+		default:
+			// the rest are taken by running dials, we keep them when they are released
+			return
+		}
+	}
+	for l.held > l.hold {
+		l.tokens <- struct{}{}
+		l.held--
+	}
+}
+
+// NetworkStats returns the health of the current network session, false if the network can't tell us
+func (sw *Swarm) NetworkStats() (st network.SessionStats, ok bool) {
+	var hr network.HealthReporter
+	hr, ok = sw.Network().(network.HealthReporter)
+	if ok {
+		st = hr.Stats()
+	}
+	return
+}
+
+// Struggling returns true if we are backing off because the network is struggling
+func (sw *Swarm) Struggling() bool {
+	return atomic.LoadInt32(&sw.struggling) == 1
+}
+
+// how long to put off announces right now
+func (sw *Swarm) announceDelay() time.Duration {
+	if sw.Struggling() {
+		return StrugglingAnnounceDelay
+	}
+	return 0
+}
+
+// back off dialing and announcing while the network is struggling
+func (sw *Swarm) runHealthCheck() {
+	for sw.Running() {
+		st, _ := sw.NetworkStats()
+		sw.checkHealth(st)
+		time.Sleep(healthCheckInterval)
+	}
+}
+
+// back off or stop backing off if the health of the network changed
+func (sw *Swarm) checkHealth(st network.SessionStats) {
+	var struggling int32
+	if st.Struggling {
+		struggling = 1
+	}
+	if atomic.SwapInt32(&sw.struggling, struggling) == struggling {
+		return
+	}
+	if st.Struggling {
+		log.Warnf("network is struggling, dialing at most %d peers at once and delaying announces", StrugglingMaxDials)
+		sw.dials.setLimit(StrugglingMaxDials)
+	} else {
+		log.Info("network is healthy again")
+		sw.dials.setLimit(DefaultMaxDials)
+	}
+}
@@ -0,0 +1,72 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/network"
+	"testing"
+	"time"
+)
+
+func TestDialLimiter(t *testing.T) {
+	l := newDialLimiter(4)
+	for idx := 0; idx < 3; idx++ {
+		l.acquire()
+	}
+	// lowering the limit under what runs doesn't wait for the running dials
+	lowered := make(chan struct{})
+	go func() {
+		l.setLimit(1)
+		close(lowered)
+	}()
+	select {
+	case <-lowered:
+	case <-time.After(time.Second):
+		t.Fatal("setting the limit waited for running dials")
+	}
+	if active, _, limit := l.stats(); active != 3 || limit != 1 {
+		t.Fatalf("%d running with a limit of %d", active, limit)
+	}
+	got := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(got)
+	}()
+	l.release()
+	l.release()
+	select {
+	case <-got:
+		t.Fatal("dialed past the limit")
+	case <-time.After(time.Millisecond * 50):
+	}
+	l.release()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("no dial once the running ones finished")
+	}
+	if active, waiting, limit := l.stats(); active != 1 || waiting != 0 || limit != 1 {
+		t.Fatalf("%d running and %d waiting with a limit of %d", active, waiting, limit)
+	}
+	// raising it lets the others through right away
+	l.setLimit(4)
+	for idx := 0; idx < 3; idx++ {
+		l.acquire()
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	sw := &Swarm{dials: newDialLimiter(DefaultMaxDials)}
+	sw.checkHealth(network.SessionStats{Struggling: true})
+	if !sw.Struggling() || sw.announceDelay() != StrugglingAnnounceDelay {
+		t.Fatal("not backing off while the network struggles")
+	}
+	if _, _, limit := sw.dials.stats(); limit != StrugglingMaxDials {
+		t.Fatalf("dialing %d at once while the network struggles", limit)
+	}
+	sw.checkHealth(network.SessionStats{})
+	if sw.Struggling() || sw.announceDelay() != 0 {
+		t.Fatal("still backing off after the network got healthy")
+	}
+	if _, _, limit := sw.dials.stats(); limit != DefaultMaxDials {
+		t.Fatalf("dialing %d at once once healthy", limit)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/majestrate/XD/lib/network"
 	"github.com/majestrate/XD/lib/storage"
 	"github.com/majestrate/XD/lib/sync"
+	"github.com/majestrate/XD/lib/unpack"
+	"time"
 )
 
 // torrent swarm container
@@ -13,8 +15,57 @@ type Holder struct {
 	st           storage.Storage
 	torrents     sync.Map
 	torrentsByID sync.Map
-	MaxReq       int
-	QueueSize    int
+	// hybrid torrents by their truncated v2 infohash
+	hybrids   sync.Map
+	MaxReq    int
+	QueueSize int
+	// how long an unchoked peer may go without requesting before we choke it, 0 disables
+	IdleUploadTimeout time.Duration
+	// how many peers with the best rates torrents unchoke besides the optimistic unchoke, DefaultUploadSlots if 0
+	UploadSlots int
+	// how long a peer keeps an upload slot of a torrent we seed while others wait for one, DefaultSeedSlotTurn if 0, negative keeps it as long as it is fastest
+	SeedSlotTurn time.Duration
+	// how long a peer may sit on our requests before torrents snub it and ask other peers, DefaultSnubTimeout if 0, negative never snubs
+	SnubTimeout time.Duration
+	// how often torrents announce to trackers, 0 for as often as each tracker asks
+	AnnounceInterval time.Duration
+	// flush pieces front to back on disk for sequential torrents
+	SequentialFlush bool
+	// how hard torrents try to connect to peers, the default policy is used if Tries is 0
+	Retry RetryPolicy
+	// how long torrents wait on peers when connecting and talking to them
+	Timeouts Timeouts
+	// torrents super seed when they are seeding unless it was turned on or off for them
+	Superseed bool
+	// how many bytes torrents ask peers for at once, BlockSize if 0
+	RequestBlockSize int
+	// add trackers torrents learn from peers to their stored metainfo
+	PersistLearnedTrackers bool
+	// what torrents do with their data once it is complete
+	Unpack *unpack.Config
+	// when seeding torrents go dormant
+	Tiering TierPolicy
+	// how many torrents verify their data at once and which go first, DefaultVerifyWorkers if 0
+	VerifyWorkers int
+	VerifyOrder   VerifyOrder
+	// torrents check pieces against their hashes before they serve them if they didn't for this long, 0 never does
+	VerifyUploads time.Duration
+	// share ratio seeding torrents without a limit of their own stop at
+	RatioLimit RatioLimit
+	// limits how fast torrents move data, may be shared with other swarms
+	Bandwidth   *Bandwidth
+	verifier    *verifyQueue
+	verifierMtx sync.Mutex
+}
+
+// get the queue torrents wait in to verify their data
+func (h *Holder) verifyQueue() *verifyQueue {
+	h.verifierMtx.Lock()
+	defer h.verifierMtx.Unlock()
+	if h.verifier == nil {
+		h.verifier = newVerifyQueue(h.VerifyWorkers, h.VerifyOrder)
+	}
+	return h.verifier
 }
 
 func (h *Holder) TorrentIDs() (ids map[int64]string) {
@@ -33,14 +84,47 @@ func (h *Holder) GetTorrentByID(id int64) (t *Torrent) {
 	return
 }
 
+// set up a new torrent with the settings of this holder
+func (h *Holder) configure(tr *Torrent) {
+	tr.MaxRequests = h.MaxReq
+	tr.IdleUploadTimeout = h.IdleUploadTimeout
+	if h.UploadSlots > 0 {
+		tr.UploadSlots = h.UploadSlots
+	}
+	if h.SeedSlotTurn != 0 {
+		tr.SeedSlotTurn = h.SeedSlotTurn
+	}
+	if h.SnubTimeout != 0 {
+		tr.SnubTimeout = h.SnubTimeout
+	}
+	tr.AnnounceInterval = h.AnnounceInterval
+	tr.SequentialFlush = h.SequentialFlush
+	tr.PersistLearnedTrackers = h.PersistLearnedTrackers
+	tr.Unpack = h.Unpack
+	tr.Tiering = h.Tiering
+	tr.Timeouts = h.Timeouts
+	tr.defaultSuperseed(h.Superseed)
+	tr.verifier = h.verifyQueue()
+	tr.bw = h.Bandwidth
+	tr.VerifyUploads = h.VerifyUploads
+	tr.DefaultRatioLimit = h.RatioLimit
+	if h.Retry.Tries > 0 {
+		tr.Retry = h.Retry
+	}
+	if h.RequestBlockSize > 0 {
+		tr.RequestBlockSize = int(clampBlockSize(h.RequestBlockSize))
+	}
+}
+
 func (h *Holder) addTorrent(t storage.Torrent, getNet func() network.Network) {
 	if h.closing {
 		return
 	}
 	tr := newTorrent(t, getNet)
-	tr.MaxRequests = h.MaxReq
+	h.configure(tr)
 	h.torrents.Store(t.Infohash().Hex(), tr)
 	h.torrentsByID.Store(tr.TID, tr)
+	h.addHybrid(tr)
 }
 
 func (h *Holder) addMagnet(ih common.Infohash, getNet func() network.Network) {
@@ -48,7 +132,7 @@ func (h *Holder) addMagnet(ih common.Infohash, getNet func() network.Network) {
 		return
 	}
 	tr := newTorrent(h.st.EmptyTorrent(ih), getNet)
-	tr.MaxRequests = h.MaxReq
+	h.configure(tr)
 	h.torrents.Store(ih.Hex(), tr)
 	h.torrentsByID.Store(tr.TID, tr)
 }
@@ -61,6 +145,7 @@ func (h *Holder) removeTorrent(ih common.Infohash) {
 	if ok {
 		h.torrents.Delete(ih.Hex())
 		h.torrentsByID.Delete(tr.(*Torrent).TID)
+		h.removeHybrid(tr.(*Torrent))
 	}
 }
 
@@ -91,6 +176,9 @@ func (h *Holder) ForEachTorrentParallel(visit func(*Torrent)) {
 // returns nil if we don't have a torrent with this infohash
 func (h *Holder) GetTorrent(ih common.Infohash) (t *Torrent) {
 	v, ok := h.torrents.Load(ih.Hex())
+	if !ok {
+		v, ok = h.hybrids.Load(ih.Hex())
+	}
 	if ok {
 		t = v.(*Torrent)
 	}
@@ -115,8 +203,10 @@ func (h *Holder) Close(announce bool) {
 		t := v.(*Torrent)
 		wg.Add(1)
 		go func() {
+			t.saveResumePeers()
+			t.saveDialHistory()
 			if announce {
-				t.Stop()
+				t.stop()
 			} else {
 				t.StopAnnouncing(false)
 				t.Close()
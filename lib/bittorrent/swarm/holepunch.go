@@ -0,0 +1,221 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"net"
+	"strconv"
+	"time"
+)
+
+// how long we remember which peer told us about a peer over pex
+const holepunchRelayTTL = time.Minute * 30
+
+// a peer that told us about a peer over pex and when
+type holepunchRelay struct {
+	addr string
+	at   time.Time
+}
+
+// ErrHolepunchNetwork is returned when a ut_holepunch address is not for the network we are on
+var ErrHolepunchNetwork = errors.New("holepunch address is for another network")
+
+// get the ut_holepunch address of a peer, ok is false if it does not fit in one
+func holepunchAddr(a net.Addr) (h extensions.Holepunch, ok bool) {
+	if a.Network() == "i2p" {
+		b32 := i2p.I2PAddr(a.String()).Base32Addr()
+		h.AddrType = extensions.HolepunchI2P
+		h.Addr = b32[:]
+		ok = true
+		return
+	}
+	host, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return
+	}
+	// names like lokinet ones don't fit
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return
+	}
+	p, _ := strconv.Atoi(port)
+	h.Port = uint16(p)
+	if ip4 := ip.To4(); ip4 != nil {
+		h.AddrType = extensions.HolepunchIPv4
+		h.Addr = ip4
+	} else {
+		h.AddrType = extensions.HolepunchIPv6
+		h.Addr = ip.To16()
+	}
+	ok = true
+	return
+}
+
+// key for comparing ut_holepunch addresses
+func holepunchKey(h extensions.Holepunch) string {
+	return fmt.Sprintf("%d:%x:%d", h.AddrType, h.Addr, h.Port)
+}
+
+// get the network address of a ut_holepunch address
+func (t *Torrent) resolveHolepunch(h extensions.Holepunch) (a net.Addr, err error) {
+	n := t.Network()
+	if (n.Addr().Network() == "i2p") != (h.AddrType == extensions.HolepunchI2P) {
+		err = ErrHolepunchNetwork
+		return
+	}
+	var p common.Peer
+	if h.AddrType == extensions.HolepunchI2P {
+		copy(p.Compact[:], h.Addr)
+	} else {
+		p.IP = net.IP(h.Addr).String()
+		p.Port = int(h.Port)
+	}
+	return p.Resolve(n)
+}
+
+// a peer asked us to connect it with a peer we are connected to
+func (t *Torrent) relayHolepunch(from *PeerConn, h extensions.Holepunch) {
+	fail := func(code uint32) {
+		h.Type = extensions.HolepunchError
+		h.Err = code
+		from.sendHolepunch(h)
+	}
+	us, ok := holepunchAddr(from.c.RemoteAddr())
+	if !ok {
+		// we can't tell the other side who wants them
+		fail(extensions.HolepunchErrNoSuchPeer)
+		return
+	}
+	key := holepunchKey(h)
+	if key == holepunchKey(us) {
+		fail(extensions.HolepunchErrNoSelf)
+		return
+	}
+	var target *PeerConn
+	t.VisitPeers(func(c *PeerConn) {
+		if target != nil {
+			return
+		}
+		a, ok := holepunchAddr(c.c.RemoteAddr())
+		if ok && holepunchKey(a) == key {
+			target = c
+		}
+	})
+	if target == nil {
+		fail(extensions.HolepunchErrNotConnected)
+		return
+	}
+	if !target.SupportsHolepunch() {
+		fail(extensions.HolepunchErrNoSupport)
+		return
+	}
+	log.Debugf("relaying holepunch from %s to %s", from.id.String(), target.id.String())
+	us.Type = extensions.HolepunchConnect
+	target.sendHolepunch(us)
+	h.Type = extensions.HolepunchConnect
+	from.sendHolepunch(h)
+}
+
+// a relay told us to connect to a peer that wants to connect to us
+func (t *Torrent) holepunchConnect(h extensions.Holepunch) {
+	a, err := t.resolveHolepunch(h)
+	if err != nil {
+		log.Debugf("cannot holepunch to %x: %s", h.Addr, err.Error())
+		return
+	}
+	if a.String() == t.Network().Addr().String() || t.HasOBConn(a) || t.HasIBConn(a) {
+		return
+	}
+	go func() {
+		err := t.DialPeer(a, common.PeerID{})
+		t.remotes.dialed(a, t.Infohash(), err)
+	}()
+}
+
+// remember the peer that told us about a peer over pex so it can relay a holepunch to it
+func (t *Torrent) holepunchVia(p common.Peer, relay net.Addr) {
+	h := extensions.Holepunch{
+		AddrType: extensions.HolepunchI2P,
+		Addr:     p.Compact[:],
+	}
+	t.holepunchRelays.Store(holepunchKey(h), holepunchRelay{addr: relay.String(), at: time.Now()})
+}
+
+// forget the peers a relay told us about once it disconnects and relays older than holepunchRelayTTL
+func (t *Torrent) forgetHolepunchRelay(addr string, now time.Time) {
+	t.holepunchRelays.Range(func(k, v interface{}) bool {
+		r := v.(holepunchRelay)
+		if r.addr == addr || now.Sub(r.at) >= holepunchRelayTTL {
+			t.holepunchRelays.Delete(k)
+		}
+		return true
+	})
+}
+
+// ask the peer that told us about a to connect us, returns true if we asked
+func (t *Torrent) rendezvous(a net.Addr) bool {
+	h, ok := holepunchAddr(a)
+	if !ok {
+		return false
+	}
+	key := holepunchKey(h)
+	v, ok := t.holepunchRelays.Load(key)
+	if !ok {
+		return false
+	}
+	t.holepunchRelays.Delete(key)
+	r := v.(holepunchRelay)
+	if time.Since(r.at) >= holepunchRelayTTL {
+		return false
+	}
+	var relay *PeerConn
+	t.VisitPeers(func(c *PeerConn) {
+		if relay == nil && c.c.RemoteAddr().String() == r.addr {
+			relay = c
+		}
+	})
+	if relay == nil || !relay.SupportsHolepunch() {
+		return false
+	}
+	log.Debugf("asking %s to relay a holepunch to %s", relay.id.String(), a)
+	h.Type = extensions.HolepunchRendezvous
+	relay.sendHolepunch(h)
+	return true
+}
+
+// SupportsHolepunch returns true if the peer can relay or take ut_holepunch messages
+func (c *PeerConn) SupportsHolepunch() bool {
+	return c.theirExt.Supports(extensions.UTHolepunch)
+}
+
+func (c *PeerConn) sendHolepunch(h extensions.Holepunch) {
+	id, ok := c.theirExt.ID(extensions.UTHolepunch)
+	if ok {
+		m := extensions.NewHolepunch(id, h)
+		c.Send(m.ToWireMessage())
+	}
+}
+
+func (c *PeerConn) handleHolepunch(m extensions.Message) {
+	if c.t.Private() {
+		// we neither relay for nor dial peers of a private torrent we didn't get from its trackers
+		return
+	}
+	h, err := extensions.ParseHolepunch(m.PayloadRaw)
+	if err != nil {
+		log.Warnf("invalid ut_holepunch from %s: %s", c.id.String(), err.Error())
+		return
+	}
+	switch h.Type {
+	case extensions.HolepunchRendezvous:
+		c.t.relayHolepunch(c, h)
+	case extensions.HolepunchConnect:
+		c.t.holepunchConnect(h)
+	case extensions.HolepunchError:
+		log.Debugf("%s could not relay our holepunch to %x: error %d", c.id.String(), h.Addr, h.Err)
+	}
+}
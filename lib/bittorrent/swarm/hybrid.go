@@ -0,0 +1,28 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+)
+
+// get the truncated v2 infohash of a hybrid torrent, ok is false for other torrents
+// hybrid torrents are known by their v1 infohash and are in the v2 swarm under this one too
+func (t *Torrent) hybridInfohash() (ih common.Infohash, ok bool) {
+	info := t.MetaInfo()
+	if info == nil || !info.Info.IsHybrid() {
+		return
+	}
+	return info.InfohashV2().Truncated(), true
+}
+
+// let peers find a hybrid torrent by its v2 infohash
+func (h *Holder) addHybrid(t *Torrent) {
+	if ih, ok := t.hybridInfohash(); ok {
+		h.hybrids.Store(ih.Hex(), t)
+	}
+}
+
+func (h *Holder) removeHybrid(t *Torrent) {
+	if ih, ok := t.hybridInfohash(); ok {
+		h.hybrids.Delete(ih.Hex())
+	}
+}
@@ -0,0 +1,76 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"sort"
+	"time"
+)
+
+// Identity is who a swarm is to other peers, for sharing our address for manual peering and for debugging reachability
+type Identity struct {
+	// peer id we send in handshakes, a new one is made each time we get a network
+	PeerID string
+	// kind of network we are on like i2p, empty if we have none right now
+	Network string
+	// address peers reach us at, the .b32.i2p of our destination on i2p
+	Addr string
+	// our full base64 destination on i2p, empty on other networks
+	Dest string
+	// true while we have a network session that takes inbound peers
+	Listening bool
+	// connected peers that dialed us, none for a while means peers can't reach us
+	InboundPeers  int
+	OutboundPeers int
+	// extensions we advertise in the extended handshake, every handshake also sets the fast extension bit
+	Extensions []string
+}
+
+// the network we have right now without waiting for one, nil if we have none
+func (sw *Swarm) currentNetwork() network.Network {
+	if sw.netDead {
+		return nil
+	}
+	select {
+	case n := <-sw.getNet:
+		return n
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+// Identity gets who we are to other peers
+func (sw *Swarm) Identity() (id Identity) {
+	opts := ourExtensions(0)
+	if sw.xdht.Enabled() {
+		opts.SetSupported(extensions.XDHT)
+	}
+	for name := range opts.Extensions {
+		id.Extensions = append(id.Extensions, name)
+	}
+	sort.Strings(id.Extensions)
+	sw.Torrents.ForEachTorrent(func(t *Torrent) {
+		t.VisitPeers(func(c *PeerConn) {
+			if c.inbound {
+				id.InboundPeers++
+			} else {
+				id.OutboundPeers++
+			}
+		})
+	})
+	n := sw.currentNetwork()
+	if n == nil {
+		return
+	}
+	id.PeerID = sw.id.String()
+	id.Listening = sw.Running()
+	addr := n.Addr()
+	id.Network = addr.Network()
+	id.Addr = addr.String()
+	if a, ok := addr.(i2p.Addr); ok {
+		id.Addr = a.Base32Addr().String()
+		id.Dest = a.Dest()
+	}
+	return
+}
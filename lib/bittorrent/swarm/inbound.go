@@ -0,0 +1,31 @@
+package swarm
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+// inbound connections we refuse are held open for a random time in this range before closing
+// so a peer probing for infohashes cannot tell why it was refused
+const inboundRejectMinDelay = time.Second * 2
+const inboundRejectMaxDelay = time.Second * 5
+
+// refuse an inbound connection the same way no matter the reason, never writes anything
+func rejectInbound(c net.Conn) {
+	delay := inboundRejectMinDelay + time.Duration(rand.Int63n(int64(inboundRejectMaxDelay-inboundRejectMinDelay)))
+	time.Sleep(delay)
+	c.Close()
+}
+
+// returns true if inbound peers may connect to us for this torrent
+func (sw *Swarm) servesInbound(t *Torrent) bool {
+	if t == nil || t.closing || !t.started || !t.onPinnedNetwork() {
+		return false
+	}
+	if sw.StrictInbound && !t.Ready() {
+		// we only know the infohash, don't tell anyone we want it
+		return false
+	}
+	return true
+}
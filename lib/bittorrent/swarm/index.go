@@ -0,0 +1,134 @@
+package swarm
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/sync"
+)
+
+// Index holds every swarm in the daemon so we can find which swarm has a torrent
+type Index struct {
+	access sync.Mutex
+	swarms []*Swarm
+	// torrents a swarm is adding right now by the number of that swarm
+	adding map[common.Infohash]int
+}
+
+// NewIndex creates an empty swarm index
+func NewIndex() *Index {
+	return &Index{}
+}
+
+// Add puts a swarm in the index and returns its number
+func (idx *Index) Add(sw *Swarm) int {
+	idx.access.Lock()
+	defer idx.access.Unlock()
+	sw.index = idx
+	sw.number = len(idx.swarms)
+	idx.swarms = append(idx.swarms, sw)
+	return sw.number
+}
+
+// Swarms returns all swarms ordered by number
+func (idx *Index) Swarms() (swarms []*Swarm) {
+	idx.access.Lock()
+	swarms = append(swarms, idx.swarms...)
+	idx.access.Unlock()
+	return
+}
+
+// Lookup returns the numbers of every swarm that has a torrent
+func (idx *Index) Lookup(ih common.Infohash) (nums []int) {
+	for _, sw := range idx.Swarms() {
+		if sw.Torrents.GetTorrent(ih) != nil {
+			nums = append(nums, sw.number)
+		}
+	}
+	return
+}
+
+// Find returns the first swarm that has a torrent and its number, nil and -1 if no swarm has it
+func (idx *Index) Find(ih common.Infohash) (*Swarm, int) {
+	for _, sw := range idx.Swarms() {
+		if sw.Torrents.GetTorrent(ih) != nil {
+			return sw, sw.number
+		}
+	}
+	return nil, -1
+}
+
+// claim a torrent swarm num is about to add, fails if a swarm has it or is adding it too
+func (idx *Index) claim(ih common.Infohash, num int) error {
+	idx.access.Lock()
+	defer idx.access.Unlock()
+	other, adding := idx.adding[ih]
+	if !adding {
+		other = -1
+		for _, sw := range idx.swarms {
+			if sw.Torrents.GetTorrent(ih) != nil {
+				other = sw.number
+				break
+			}
+		}
+	}
+	if other >= 0 {
+		return &DuplicateTorrentError{
+			Infohash: ih,
+			Swarm:    other,
+		}
+	}
+	if idx.adding == nil {
+		idx.adding = make(map[common.Infohash]int)
+	}
+	idx.adding[ih] = num
+	return nil
+}
+
+// let go of a torrent we claimed once it is added or we gave up on it
+func (idx *Index) release(ih common.Infohash) {
+	idx.access.Lock()
+	delete(idx.adding, ih)
+	idx.access.Unlock()
+}
+
+// DuplicateTorrentError is returned when adding a torrent some swarm already has
+type DuplicateTorrentError struct {
+	Infohash common.Infohash
+	Swarm    int
+}
+
+func (e *DuplicateTorrentError) Error() string {
+	return fmt.Sprintf("torrent %s already added to swarm %d", e.Infohash.Hex(), e.Swarm)
+}
+
+// Number returns this swarm's number in its index
+func (sw *Swarm) Number() int {
+	return sw.number
+}
+
+// Index returns the index this swarm is in, nil if it is not in one
+func (sw *Swarm) Index() *Index {
+	return sw.index
+}
+
+// claim a torrent we are about to add so no swarm adds it meanwhile, fails if some swarm has it
+// call releaseTorrent once it is added or we gave up on it
+func (sw *Swarm) claimTorrent(ih common.Infohash) error {
+	if sw.index != nil {
+		return sw.index.claim(ih, sw.number)
+	}
+	if sw.Torrents.GetTorrent(ih) != nil {
+		return &DuplicateTorrentError{
+			Infohash: ih,
+			Swarm:    sw.number,
+		}
+	}
+	return nil
+}
+
+// let go of a torrent we claimed
+func (sw *Swarm) releaseTorrent(ih common.Infohash) {
+	if sw.index != nil {
+		sw.index.release(ih)
+	}
+}
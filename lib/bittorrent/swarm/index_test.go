@@ -0,0 +1,78 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	idx := NewIndex()
+	var swarms []*Swarm
+	for n := 0; n < 2; n++ {
+		sw := new(Swarm)
+		if num := idx.Add(sw); num != n || sw.Number() != n || sw.Index() != idx {
+			t.Fatalf("swarm %d got number %d", n, num)
+		}
+		swarms = append(swarms, sw)
+	}
+	var ih common.Infohash
+	ih[0] = 1
+	swarms[1].Torrents.torrents.Store(ih.Hex(), &Torrent{})
+	if nums := idx.Lookup(ih); !reflect.DeepEqual(nums, []int{1}) {
+		t.Fatalf("found torrent in swarms %v", nums)
+	}
+	if sw, num := idx.Find(ih); sw != swarms[1] || num != 1 {
+		t.Fatalf("found torrent in swarm %d", num)
+	}
+	err := swarms[0].claimTorrent(ih)
+	if dup, ok := err.(*DuplicateTorrentError); !ok || dup.Swarm != 1 || dup.Infohash != ih {
+		t.Fatalf("adding a torrent swarm 1 has gave %v", err)
+	}
+	var other common.Infohash
+	other[0] = 2
+	if sw, num := idx.Find(other); sw != nil || num != -1 {
+		t.Fatalf("found a torrent we don't have in swarm %d", num)
+	}
+	if err = swarms[0].claimTorrent(other); err != nil {
+		t.Fatal(err)
+	}
+	err = swarms[1].claimTorrent(other)
+	if dup, ok := err.(*DuplicateTorrentError); !ok || dup.Swarm != 0 {
+		t.Fatalf("adding a torrent swarm 0 is adding gave %v", err)
+	}
+	swarms[0].releaseTorrent(other)
+	if err = swarms[1].claimTorrent(other); err != nil {
+		t.Fatal(err)
+	}
+	swarms[1].releaseTorrent(other)
+}
+
+func TestIndexClaimRace(t *testing.T) {
+	idx := NewIndex()
+	for n := 0; n < 4; n++ {
+		idx.Add(new(Swarm))
+	}
+	var ih common.Infohash
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	claimed := 0
+	for _, sw := range idx.Swarms() {
+		for n := 0; n < 4; n++ {
+			wg.Add(1)
+			go func(sw *Swarm) {
+				defer wg.Done()
+				if sw.claimTorrent(ih) == nil {
+					mtx.Lock()
+					claimed++
+					mtx.Unlock()
+				}
+			}(sw)
+		}
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("%d adds of the same torrent went through", claimed)
+	}
+}
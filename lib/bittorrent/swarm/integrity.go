@@ -0,0 +1,18 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/storage"
+)
+
+// IntegrityReport checks all of the torrent's data on disk without changing what we think we have or downloading anything again
+func (t *Torrent) IntegrityReport() (r storage.IntegrityReport, err error) {
+	if !t.Ready() {
+		return r, ErrNoMetaInfo
+	}
+	release, ok := t.waitVerify(t.LastActive())
+	if !ok {
+		return r, ErrAlreadyStopped
+	}
+	defer release()
+	return t.st.IntegrityReport()
+}
@@ -0,0 +1,41 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/tracker"
+	"time"
+)
+
+// the soonest we announce to a tracker again that gave no min interval
+const DefaultMinAnnounceInterval = time.Minute
+
+// how long to wait before announcing to a tracker again after it answered with interval and min interval in seconds, and the soonest it lets us
+// we go by the tracker's interval unless configured is set, but never sooner than its min interval, or DefaultMinAnnounceInterval if it gave none
+// trackers like opentracker ban clients that announce more often than they allow
+func announceIntervals(interval, minInterval int, configured time.Duration) (next, floor time.Duration) {
+	next = tracker.DefaultInterval
+	if interval > 0 {
+		next = time.Duration(interval) * time.Second
+	}
+	floor = DefaultMinAnnounceInterval
+	if minInterval > 0 {
+		floor = time.Duration(minInterval) * time.Second
+	}
+	if floor > next {
+		floor = next
+	}
+	if configured > 0 {
+		next = configured
+	}
+	if next < floor {
+		next = floor
+	}
+	return
+}
+
+// the soonest we may announce to a tracker at, an announce asked for any sooner waits until then
+func (a *torrentAnnounce) notBefore(at time.Time) time.Time {
+	if at.Before(a.earliest) {
+		return a.earliest
+	}
+	return at
+}
@@ -0,0 +1,35 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/tracker"
+	"testing"
+	"time"
+)
+
+func TestAnnounceIntervals(t *testing.T) {
+	cases := []struct {
+		interval, minInterval int
+		configured            time.Duration
+		next, floor           time.Duration
+	}{
+		// nothing said, nothing configured
+		{0, 0, 0, tracker.DefaultInterval, DefaultMinAnnounceInterval},
+		// the tracker's interval
+		{1800, 0, 0, time.Minute * 30, DefaultMinAnnounceInterval},
+		{30, 0, 0, time.Second * 30, time.Second * 30},
+		{1800, 900, 0, time.Minute * 30, time.Minute * 15},
+		// configured sooner than the tracker asks but as soon as it allows
+		{1800, 900, time.Minute * 20, time.Minute * 20, time.Minute * 15},
+		// configured sooner than the tracker allows
+		{1800, 900, time.Minute, time.Minute * 15, time.Minute * 15},
+		{1800, 0, time.Second * 10, DefaultMinAnnounceInterval, DefaultMinAnnounceInterval},
+		// configured later than the tracker asks
+		{1800, 900, time.Hour, time.Hour, time.Minute * 15},
+	}
+	for _, c := range cases {
+		next, floor := announceIntervals(c.interval, c.minInterval, c.configured)
+		if next != c.next || floor != c.floor {
+			t.Errorf("interval %d min %d configured %s: next %s floor %s, wanted %s and %s", c.interval, c.minInterval, c.configured, next, floor, c.next, c.floor)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"sync/atomic"
+	"time"
+)
+
+// KeepAliveInterval is how long we send a peer nothing before we send it a keep-alive
+const KeepAliveInterval = time.Minute * 2
+
+// note that the peer sent us something at now, called by the reader
+func (c *PeerConn) heard(now time.Time) {
+	atomic.StoreInt64(&c.lastRecv, now.UnixNano())
+}
+
+// how long the peer has sent us nothing, not even a keep-alive
+func (c *PeerConn) silentFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastRecv)))
+}
+
+// keep the connection alive on our side and tell if it is still alive on theirs, only called by run
+// returns false if the peer has sent nothing for the idle timeout, a hung stream never errors so we have to give up on it ourselves
+func (c *PeerConn) supervise(now time.Time) bool {
+	if idle := c.t.Timeouts.Idle; idle > 0 && c.silentFor(now) > idle {
+		return false
+	}
+	if now.Sub(c.lastSend) >= KeepAliveInterval {
+		c.appendSend(common.KeepAlive)
+	}
+	return true
+}
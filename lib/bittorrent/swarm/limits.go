@@ -0,0 +1,74 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/metainfo"
+)
+
+// ErrBadMessage is returned when a peer sends a message that can't be right for the torrent, the peer is dropped
+var ErrBadMessage = errors.New("bad wire message")
+
+// bytes in a bitfield of n pieces
+func bitfieldSize(n uint32) uint32 {
+	return (n + 7) / 8
+}
+
+// the biggest block of a torrent we ask peers for
+func (t *Torrent) maxBlockSize() uint32 {
+	sz := clampBlockSize(t.RequestBlockSize)
+	if t.Ready() {
+		if pl := t.MetaInfo().Info.PieceLength; pl > 0 && pl < sz {
+			sz = pl
+		}
+	}
+	return sz
+}
+
+// maxMessageSize is the biggest wire message a peer of the torrent may send us
+// big enough for a bitfield of every piece and the blocks we ask for, before we have the metainfo bitfields may be as big as the biggest torrent we take
+func (t *Torrent) maxMessageSize() uint32 {
+	pieces := metainfo.DefaultLimits.MaxPieces
+	if t.Ready() {
+		pieces = t.MetaInfo().Info.NumPieces()
+	}
+	sz := uint32(common.MaxWireMessageSize)
+	if bf := 1 + bitfieldSize(pieces); bf > sz {
+		sz = bf
+	}
+	// id, index and begin come before the block
+	if piece := 9 + t.maxBlockSize(); piece > sz {
+		sz = piece
+	}
+	return sz
+}
+
+// check a bitfield a peer sent us is the size of the torrent's
+func (t *Torrent) checkBitfield(payload []byte) error {
+	n := t.MetaInfo().Info.NumPieces()
+	if uint32(len(payload)) != bitfieldSize(n) {
+		return fmt.Errorf("%w: bitfield of %d bytes for %d pieces", ErrBadMessage, len(payload), n)
+	}
+	return checkSpareBits(payload, n)
+}
+
+// check a piece index a peer sent us is in the torrent
+func (t *Torrent) checkPieceIndex(idx uint32) error {
+	if n := t.MetaInfo().Info.NumPieces(); idx >= n {
+		return fmt.Errorf("%w: piece %d of %d", ErrBadMessage, idx, n)
+	}
+	return nil
+}
+
+// check a request a peer sent us is for bytes inside of a piece of the torrent
+func (t *Torrent) checkRequest(r *common.PieceRequest) error {
+	if err := t.checkPieceIndex(r.Index); err != nil {
+		return err
+	}
+	l := uint64(t.MetaInfo().LengthOfPiece(r.Index))
+	if uint64(r.Begin)+uint64(r.Length) > l {
+		return fmt.Errorf("%w: request for %d-%d of piece %d which is %d bytes", ErrBadMessage, r.Begin, uint64(r.Begin)+uint64(r.Length), r.Index, l)
+	}
+	return nil
+}
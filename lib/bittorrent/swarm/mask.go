@@ -0,0 +1,113 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/bittorrent"
+)
+
+// ErrNoMetaInfo is returned when something needs the torrent's pieces before we have its metainfo
+var ErrNoMetaInfo = errors.New("torrent has no metainfo yet")
+
+// PieceMask gets the pieces we were told not to download, nil if there are none
+func (t *Torrent) PieceMask() (mask *bittorrent.Bitfield) {
+	return t.st.PieceMask()
+}
+
+// get the pieces we don't download, masked ones and ones only in skipped files, nil if we want every piece
+func (t *Torrent) unwantedPieces() (mask *bittorrent.Bitfield) {
+	t.connMtx.Lock()
+	mask = t.mask
+	t.connMtx.Unlock()
+	return
+}
+
+// get the pieces of high priority files and the pieces of only low priority files, nil if there are none
+func (t *Torrent) piecePriorities() (high, low *bittorrent.Bitfield) {
+	t.connMtx.Lock()
+	high, low = t.highPieces, t.lowPieces
+	t.connMtx.Unlock()
+	return
+}
+
+// pick up a change to the pieces we want from storage
+func (t *Torrent) updateWanted() {
+	mask := t.st.UnwantedPieces()
+	high, low := t.st.PiecePriorities()
+	t.connMtx.Lock()
+	t.mask = mask
+	t.highPieces, t.lowPieces = high, low
+	t.connMtx.Unlock()
+	if t.pt != nil {
+		// held pieces may have waited for a piece we no longer want
+		t.pt.flushHeld(false)
+	}
+	if !t.Done() {
+		// we want pieces again
+		t.seeding = false
+	}
+	t.VisitPeers(func(c *PeerConn) {
+		c.checkInterested()
+	})
+}
+
+// SetPieceMask sets the pieces we should not download no matter what files they are in, nil or an empty mask clears it.
+// pieces we already have are kept and still seeded.
+func (t *Torrent) SetPieceMask(mask *bittorrent.Bitfield) error {
+	if !t.Ready() {
+		return ErrNoMetaInfo
+	}
+	if mask != nil && mask.CountSet() == 0 {
+		mask = nil
+	}
+	if mask != nil && mask.Length != t.MetaInfo().Info.NumPieces() {
+		return bittorrent.ErrBadPieceRange
+	}
+	err := t.st.SetPieceMask(mask)
+	if err == nil {
+		t.updateWanted()
+	}
+	return err
+}
+
+// SkippedFiles gets the indexes of the files we don't download
+func (t *Torrent) SkippedFiles() []int {
+	return t.st.SkippedFiles()
+}
+
+// SetSkippedFiles sets the files we don't download by their index, nil downloads every file.
+// pieces a skipped file shares with a file we want are still downloaded, pieces we already have are kept and still seeded.
+func (t *Torrent) SetSkippedFiles(files []int) error {
+	if !t.Ready() {
+		return ErrNoMetaInfo
+	}
+	err := t.st.SetSkippedFiles(files)
+	if err == nil {
+		t.updateWanted()
+	}
+	return err
+}
+
+// FilePriorities gets the priority of the files by their index, files that aren't in it are normal priority
+func (t *Torrent) FilePriorities() map[int]TorrentPriority {
+	prios := make(map[int]TorrentPriority)
+	for idx, p := range t.st.FilePriorities() {
+		prios[idx] = TorrentPriority(p)
+	}
+	return prios
+}
+
+// SetFilePriority sets the priority of files by their index, kept across restarts.
+// pieces of high priority files are downloaded before other pieces and pieces of only low priority files after them.
+func (t *Torrent) SetFilePriority(files []int, p TorrentPriority) error {
+	if !t.Ready() {
+		return ErrNoMetaInfo
+	}
+	if !p.Valid() {
+		return ErrBadPriority
+	}
+	err := t.st.SetFilePriority(files, int(p))
+	if err == nil {
+		t.updateWanted()
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"testing"
+)
+
+func TestPickByPriority(t *testing.T) {
+	bits := func(set ...uint32) *bittorrent.Bitfield {
+		bf := bittorrent.NewBitfield(6, nil)
+		for _, idx := range set {
+			bf.Set(idx)
+		}
+		return bf
+	}
+	tr := &Torrent{
+		sequential: true,
+		highPieces: bits(4, 5),
+		lowPieces:  bits(0, 1),
+	}
+	have := map[uint32]bool{}
+	excluded := func(idx uint32) bool {
+		return have[idx]
+	}
+	remote := bits(0, 1, 2, 3, 4, 5)
+	for _, expected := range []uint32{4, 5, 2, 3, 0, 1} {
+		idx, has := tr.pickByPriority(remote, excluded)
+		if !has || idx != expected {
+			t.Fatalf("picked %d %v, expected %d", idx, has, expected)
+		}
+		have[idx] = true
+	}
+	if _, has := tr.pickByPriority(remote, excluded); has {
+		t.Fatal("picked a piece we have")
+	}
+	// a peer without high priority pieces still gets asked for normal ones
+	have = map[uint32]bool{}
+	idx, has := tr.pickByPriority(bits(1, 3), excluded)
+	if !has || idx != 3 {
+		t.Fatalf("picked %d %v, expected 3", idx, has)
+	}
+}
@@ -0,0 +1,42 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent/mse"
+	"github.com/majestrate/XD/lib/common"
+	"net"
+)
+
+// an mse handshake that failed, the peer may still talk plaintext
+type encryptionError struct {
+	error
+}
+
+// returns true if the encryption policy is for peers at a, i2p is encrypted already and i2p clients don't do mse
+func mseApplies(a net.Addr) bool {
+	return a.Network() != "i2p"
+}
+
+// get the infohashes of every torrent inbound mse peers may want
+func (sw *Swarm) infohashes() (ihs []common.Infohash) {
+	sw.Torrents.ForEachTorrent(func(t *Torrent) {
+		ihs = append(ihs, t.Infohash())
+	})
+	return
+}
+
+// connect to a, wrap the connection in our transport and in mse if encrypt is true
+func (t *Torrent) dialConn(a net.Addr, encrypt bool) (net.Conn, error) {
+	c, err := dialTimeout(t.Network(), a, t.Timeouts.Dial)
+	if err == nil && t.peerTransport != nil {
+		setDeadline(c, t.Timeouts.Handshake)
+		c, err = t.peerTransport.Client(c)
+	}
+	if err == nil && encrypt {
+		setDeadline(c, t.Timeouts.Handshake)
+		c, err = mse.Client(c, t.Infohash(), t.encryption.AllowsPlaintext())
+		if err != nil {
+			err = encryptionError{err}
+		}
+	}
+	return c, err
+}
@@ -0,0 +1,305 @@
+package swarm
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/majestrate/XD/lib/log"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// first bytes of a connection from another XD that wants to test the network between us, as long as a bittorrent handshake's first bytes
+const netTestHandshake = "XD NETTEST PROTO v1\n"
+
+// DefaultNetTestDuration is how long each way of a network test moves data when no time is given
+const DefaultNetTestDuration = time.Second * 10
+
+// the longest we move data one way for a network test, for us testing and for others testing us
+const maxNetTestDuration = time.Minute
+
+// how many round trips we time
+const netTestPings = 5
+
+// how much data goes in each frame of a network test
+const netTestChunk = 16 * 1024
+
+// network test requests, one byte each
+const netTestPing = byte('p')
+const netTestUpload = byte('u')
+const netTestDownload = byte('d')
+const netTestDone = byte('q')
+
+// ErrNetTestRefused is returned when the other side didn't agree to a network test or is busy with another
+var ErrNetTestRefused = errors.New("other side refused the network test")
+
+// ErrNetTestBusy is returned when we are already running a network test
+var ErrNetTestBusy = errors.New("a network test is already running")
+
+// NetTestResult is how fast and how slow the network between us and another XD is
+type NetTestResult struct {
+	Dest string `json:"dest"`
+	// round trip times over the connection
+	RTTMin time.Duration `json:"rtt_min"`
+	RTTAvg time.Duration `json:"rtt_avg"`
+	RTTMax time.Duration `json:"rtt_max"`
+	// bytes we sent and the other side got and how long it took
+	Uploaded     uint64        `json:"uploaded"`
+	UploadTime   time.Duration `json:"upload_time"`
+	Downloaded   uint64        `json:"downloaded"`
+	DownloadTime time.Duration `json:"download_time"`
+}
+
+// UploadRate is how many bytes a second we sent
+func (r NetTestResult) UploadRate() float64 {
+	if r.UploadTime <= 0 {
+		return 0
+	}
+	return float64(r.Uploaded) / r.UploadTime.Seconds()
+}
+
+// DownloadRate is how many bytes a second we got
+func (r NetTestResult) DownloadRate() float64 {
+	if r.DownloadTime <= 0 {
+		return 0
+	}
+	return float64(r.Downloaded) / r.DownloadTime.Seconds()
+}
+
+// the length of a network test, d <= 0 is the default one and nothing goes past maxNetTestDuration
+func netTestDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultNetTestDuration
+	}
+	if d > maxNetTestDuration {
+		return maxNetTestDuration
+	}
+	return d
+}
+
+// data we send in a network test, random so nothing along the way can compress it
+func netTestData() []byte {
+	data := make([]byte, netTestChunk)
+	rand.Read(data)
+	return data
+}
+
+// send frames of data to w for d then an empty frame, returns how many bytes we sent
+func sendNetTestData(w io.Writer, d time.Duration) (n uint64, err error) {
+	data := netTestData()
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	frame := append(hdr[:], data...)
+	end := time.Now().Add(d)
+	for time.Now().Before(end) {
+		if _, err = w.Write(frame); err != nil {
+			return
+		}
+		n += uint64(len(data))
+	}
+	_, err = w.Write(make([]byte, 4))
+	return
+}
+
+// read frames of data from r until an empty frame, returns how many bytes we got
+func recvNetTestData(r io.Reader) (n uint64, err error) {
+	buf := make([]byte, netTestChunk)
+	var hdr [4]byte
+	for {
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		l := binary.BigEndian.Uint32(hdr[:])
+		if l == 0 {
+			return
+		}
+		if l > netTestChunk {
+			err = errors.New("network test frame too big")
+			return
+		}
+		if _, err = io.ReadFull(r, buf[:l]); err != nil {
+			return
+		}
+		n += uint64(l)
+	}
+}
+
+// write a request with how many milliseconds it runs for
+func writeNetTestRequest(w io.Writer, req byte, d time.Duration) error {
+	var buf [5]byte
+	buf[0] = req
+	binary.BigEndian.PutUint32(buf[1:], uint32(d/time.Millisecond))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// read how many milliseconds a request runs for, never more than maxNetTestDuration
+func readNetTestDuration(r io.Reader) (time.Duration, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return netTestDuration(time.Duration(binary.BigEndian.Uint32(buf[:])) * time.Millisecond), nil
+}
+
+// give a network test moving data for d time to finish, no deadline if we have no handshake timeout
+func (sw *Swarm) netTestDeadline(c net.Conn, d time.Duration) {
+	if sw.Torrents.Timeouts.Handshake > 0 {
+		setDeadline(c, d+sw.Torrents.Timeouts.Handshake)
+	} else {
+		setDeadline(c, 0)
+	}
+}
+
+// NetTest times round trips to the XD at dest and moves data both ways for d each, over the tunnels we have now.
+// the other XD must have agreed to network tests, we don't apply bandwidth limits so the result is what the network can do.
+func (sw *Swarm) NetTest(dest string, d time.Duration) (r NetTestResult, err error) {
+	if !atomic.CompareAndSwapInt32(&sw.netTesting, 0, 1) {
+		err = ErrNetTestBusy
+		return
+	}
+	defer atomic.StoreInt32(&sw.netTesting, 0)
+	d = netTestDuration(d)
+	r.Dest = dest
+	n := sw.Network()
+	var a net.Addr
+	a, err = n.Lookup(dest, "")
+	if err != nil {
+		return
+	}
+	var c net.Conn
+	c, err = dialTimeout(n, a, sw.Torrents.Timeouts.Dial)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	if sw.peerTransport != nil {
+		setDeadline(c, sw.Torrents.Timeouts.Handshake)
+		c, err = sw.peerTransport.Client(c)
+		if err != nil {
+			return
+		}
+	}
+	err = sw.runNetTest(c, d, &r)
+	return
+}
+
+// run a network test on a connection to the other side for d each way
+func (sw *Swarm) runNetTest(c net.Conn, d time.Duration, r *NetTestResult) (err error) {
+	setDeadline(c, sw.Torrents.Timeouts.Handshake)
+	if _, err = io.WriteString(c, netTestHandshake); err != nil {
+		return
+	}
+	var reply [len(netTestHandshake)]byte
+	if _, err = io.ReadFull(c, reply[:]); err != nil || string(reply[:]) != netTestHandshake {
+		// they close without a word when they don't want to
+		err = ErrNetTestRefused
+		return
+	}
+	log.Infof("network test with %s", r.Dest)
+	var total time.Duration
+	for idx := 0; idx < netTestPings; idx++ {
+		started := time.Now()
+		var pong [1]byte
+		if _, err = c.Write([]byte{netTestPing}); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(c, pong[:]); err != nil {
+			return
+		}
+		rtt := time.Since(started)
+		if r.RTTMin == 0 || rtt < r.RTTMin {
+			r.RTTMin = rtt
+		}
+		if rtt > r.RTTMax {
+			r.RTTMax = rtt
+		}
+		total += rtt
+	}
+	r.RTTAvg = total / netTestPings
+	// the other side tells us what it got, what we wrote may still sit in buffers
+	sw.netTestDeadline(c, d)
+	started := time.Now()
+	if err = writeNetTestRequest(c, netTestUpload, d); err != nil {
+		return
+	}
+	if _, err = sendNetTestData(c, d); err != nil {
+		return
+	}
+	var got [8]byte
+	if _, err = io.ReadFull(c, got[:]); err != nil {
+		return
+	}
+	r.UploadTime = time.Since(started)
+	r.Uploaded = binary.BigEndian.Uint64(got[:])
+	sw.netTestDeadline(c, d)
+	started = time.Now()
+	if err = writeNetTestRequest(c, netTestDownload, d); err != nil {
+		return
+	}
+	if r.Downloaded, err = recvNetTestData(c); err != nil {
+		return
+	}
+	r.DownloadTime = time.Since(started)
+	c.Write([]byte{netTestDone})
+	log.Infof("network test with %s done: rtt %s, up %d bytes in %s, down %d bytes in %s", r.Dest, r.RTTAvg, r.Uploaded, r.UploadTime, r.Downloaded, r.DownloadTime)
+	return
+}
+
+// answer a network test from another XD on an inbound connection that sent netTestHandshake
+func (sw *Swarm) serveNetTest(c net.Conn) {
+	if !sw.AnswerNetTests || !atomic.CompareAndSwapInt32(&sw.netTesting, 0, 1) {
+		log.Debugf("refusing network test from %s", c.RemoteAddr())
+		rejectInbound(c)
+		return
+	}
+	defer atomic.StoreInt32(&sw.netTesting, 0)
+	defer c.Close()
+	log.Infof("network test from %s", c.RemoteAddr())
+	if _, err := io.WriteString(c, netTestHandshake); err != nil {
+		return
+	}
+	for {
+		setDeadline(c, sw.Torrents.Timeouts.Handshake)
+		var req [1]byte
+		if _, err := io.ReadFull(c, req[:]); err != nil {
+			return
+		}
+		switch req[0] {
+		case netTestPing:
+			if _, err := c.Write(req[:]); err != nil {
+				return
+			}
+		case netTestUpload:
+			d, err := readNetTestDuration(c)
+			if err != nil {
+				return
+			}
+			// they stop sending when they are done, give their last frames time to get here
+			sw.netTestDeadline(c, d)
+			n, err := recvNetTestData(c)
+			if err != nil {
+				return
+			}
+			var got [8]byte
+			binary.BigEndian.PutUint64(got[:], n)
+			if _, err = c.Write(got[:]); err != nil {
+				return
+			}
+		case netTestDownload:
+			d, err := readNetTestDuration(c)
+			if err != nil {
+				return
+			}
+			sw.netTestDeadline(c, d)
+			if _, err = sendNetTestData(c, d); err != nil {
+				return
+			}
+		default:
+			log.Infof("network test from %s done", c.RemoteAddr())
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package swarm
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// read the first bytes like inboundConn does and answer the network test
+func answerNetTest(sw *Swarm, c net.Conn) {
+	var first [len(netTestHandshake)]byte
+	if _, err := io.ReadFull(c, first[:]); err == nil && string(first[:]) == netTestHandshake {
+		sw.serveNetTest(c)
+	} else {
+		c.Close()
+	}
+}
+
+func TestNetTest(t *testing.T) {
+	client, server := net.Pipe()
+	answering := &Swarm{AnswerNetTests: true}
+	go answerNetTest(answering, server)
+	tester := new(Swarm)
+	var r NetTestResult
+	err := tester.runNetTest(client, time.Millisecond*100, &r)
+	client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RTTMin <= 0 || r.RTTMin > r.RTTAvg || r.RTTAvg > r.RTTMax {
+		t.Fatalf("bad round trip times %s %s %s", r.RTTMin, r.RTTAvg, r.RTTMax)
+	}
+	if r.Uploaded == 0 || r.UploadRate() <= 0 {
+		t.Fatal("uploaded nothing")
+	}
+	if r.Downloaded == 0 || r.DownloadRate() <= 0 {
+		t.Fatal("downloaded nothing")
+	}
+}
+
+func TestNetTestRefused(t *testing.T) {
+	client, server := net.Pipe()
+	go answerNetTest(new(Swarm), server)
+	var r NetTestResult
+	if err := new(Swarm).runNetTest(client, time.Millisecond*100, &r); err != ErrNetTestRefused {
+		t.Fatalf("got %v, expected %s", err, ErrNetTestRefused)
+	}
+}
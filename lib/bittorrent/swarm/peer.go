@@ -1,6 +1,7 @@
 package swarm
 
 import (
+	"errors"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/bittorrent/extensions"
 	"github.com/majestrate/XD/lib/common"
@@ -10,19 +11,20 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 // a peer connection
 type PeerConn struct {
 	writeBuff           util.Buffer
-	readBuff            [common.MaxWireMessageSize + 4]byte
+	readBuff            []byte
 	sendPieceBuff       [BlockSize]byte
 	inbound             bool
 	c                   net.Conn
 	id                  common.PeerID
 	t                   *Torrent
-	send                chan common.WireMessage
+	sendq               [numTrafficClasses]chan common.WireMessage
 	bf                  *bittorrent.Bitfield
 	peerChoke           bool
 	peerInterested      bool
@@ -32,12 +34,12 @@ type PeerConn struct {
 	Done                func()
 	lastSend            time.Time
 	tx                  *util.Rate
-	lastRecv            time.Time
 	rx                  *util.Rate
 	downloading         []*common.PieceRequest
 	lastRequest         *common.PieceRequest
 	ourOpts             extensions.Message
 	theirOpts           extensions.Message
+	theirExt            extensions.Capabilities
 	MaxParalellRequests int
 	access              sync.Mutex
 	close               chan bool
@@ -47,9 +49,57 @@ type PeerConn struct {
 	uploading           bool
 	runDownload         bool
 	nextPieceRequest    time.Time
+	unchokedAt          time.Time
+	lastPeerRequest     time.Time
+	idleChoked          bool
+	lastPEXRecv         time.Time
+	fast                bool
+	allowedFast         map[uint32]bool
+	theirAllowedFast    *bittorrent.Bitfield
+	// when we last choked it or when it connected, for taking turns on upload slots while seeding
+	chokedAt time.Time
+	// set when it got interested so the torrent unchokes it if a slot is free
+	askedUnchoke int32
+	// pieces this peer sent blocks of that failed verification
+	badPieces uint32
+	// tracker urls we told this peer about over lt_tex
+	texSent map[string]bool
+	// 1 if we hid what we have from this peer to super seed it, accessed atomically
+	superseeded int32
+	// one more than the piece we last revealed to it while super seeding, 0 for none, accessed atomically
+	superseedPiece uint32
+	// when it last sent a block we asked for or when we asked it for something while it had no requests, guarded by access
+	waitingSince time.Time
+	// 1 while it sits on our requests, accessed atomically
+	snubbed int32
+	// when we wrote the requests it has not answered yet, guarded by access
+	requestSent map[common.PieceRequest]requestTiming
+	// when the last block we asked for came in, guarded by access
+	lastAnswer time.Time
+	// when the message we are handling came in before we waited on bandwidth limits, only used by the reading goroutine
+	arrived time.Time
+	// how long it takes to answer our requests in nanoseconds, accessed atomically
+	rtt int64
+	// when it last sent us anything in unix nanoseconds, accessed atomically
+	lastRecv int64
+	// times it claimed pieces and didn't deliver them, accessed atomically
+	brokenClaims uint32
+	// 1 once we stopped counting its pieces in the torrent's availability, accessed atomically
+	distrusted int32
+	// true once it closed and its pieces no longer count in the torrent's availability, guarded by bfMtx
+	uncounted bool
+	// guards changing bf and counting it in the torrent's availability
+	bfMtx sync.Mutex
+	// requests it made that wait for their turn to be served
+	uploadQueue []common.PieceRequest
+	uploadMtx   sync.Mutex
+	// blocks we queued to send it that are not written yet, accessed atomically
+	blocksInFlight int32
 }
 
 func (c *PeerConn) Bitfield() *bittorrent.Bitfield {
+	c.bfMtx.Lock()
+	defer c.bfMtx.Unlock()
 	if c.bf != nil {
 		return c.bf.Copy()
 	}
@@ -67,37 +117,90 @@ func (c *PeerConn) Stats() (st *PeerConnStats) {
 	st.ThemInterested = c.peerInterested
 	st.UsChoking = c.usChoke
 	st.ThemChoking = c.peerChoke
-	st.Client = util.ClientNameFromID(c.id[:])
-	st.Downloading = c.numDownloading() > 0
+	st.Client = c.t.remotes.ClientName(c.c.RemoteAddr(), c.id)
+	st.Requests = c.numDownloading()
+	st.MaxRequests = c.maxRequests()
+	st.Snubbed = c.Snubbed()
+	st.Uploads = c.numUploads()
+	st.BrokenClaims = atomic.LoadUint32(&c.brokenClaims)
+	st.Distrusted = c.Distrusted()
+	st.RTT = int64(c.RTT() / time.Millisecond)
+	st.Downloading = st.Requests > 0
 	st.Inbound = c.inbound
 	st.Uploading = c.uploading
+	st.Extensions = c.theirExt.Names()
+	st.BadPieces = atomic.LoadUint32(&c.badPieces)
+	st.BadPiecesTotal = c.t.remotes.badPieces(c.c.RemoteAddr())
 	if c.bf != nil {
 		st.Bitfield.CopyFrom(c.bf)
 	}
 	return
 }
 
+// PeerSendQueueSize is how many messages we queue to send to a peer
+// we tell peers we take that many requests at once
+const PeerSendQueueSize = 128
+
 func makePeerConn(c net.Conn, t *Torrent, id common.PeerID, ourOpts extensions.Message) *PeerConn {
 	p := t.getNextPeer()
-	p.c = c
+	p.c = withTimeouts(c, t.Timeouts)
 	p.t = t
 	p.tx = util.NewRate(10)
 	p.rx = util.NewRate(10)
 	p.ticker = time.NewTicker(time.Millisecond * 500)
 	p.ourOpts = ourOpts
+	if ourOpts.Extensions != nil {
+		p.fillHandshake()
+	}
+	p.theirOpts = extensions.Message{}
+	p.theirExt.Reset()
+	p.fast = false
+	p.allowedFast = nil
+	p.theirAllowedFast = nil
+	p.badPieces = 0
+	p.texSent = make(map[string]bool)
 	p.peerChoke = true
 	p.usChoke = true
+	p.chokedAt = time.Now()
+	p.snubbed = 0
+	p.requestSent = nil
+	p.lastAnswer = time.Time{}
+	p.rtt = 0
+	p.lastSend = time.Now()
+	p.heard(p.lastSend)
+	p.brokenClaims = 0
+	p.distrusted = 0
+	p.uncounted = false
+	p.uploadQueue = nil
+	p.blocksInFlight = 0
+	if t.remotes.distrusts(c.RemoteAddr().String()) {
+		// it spoofed what it has before
+		p.distrusted = 1
+	}
 	p.usInterested = true
 	copy(p.id[:], id[:])
 	p.MaxParalellRequests = t.MaxRequests
+	// big enough for any message of the torrent, grows for big bitfields if we don't know how big it is yet
+	readSize := common.MaxWireMessageSize
+	if t.Ready() {
+		readSize = int(t.maxMessageSize())
+	} else if sz := int(t.maxBlockSize()) + 9; sz > readSize {
+		readSize = sz
+	}
+	if len(p.readBuff) < readSize+4 {
+		p.readBuff = make([]byte, readSize+4)
+	}
 	p.downloading = []*common.PieceRequest{}
-	p.send = make(chan common.WireMessage, 128)
+	for idx := range p.sendq {
+		p.sendq[idx] = make(chan common.WireMessage, PeerSendQueueSize)
+	}
 	return p
 }
 
 func (c *PeerConn) appendSend(msg common.WireMessage) {
 	if c.writeBuff.Len() > 1000 {
 		if c.flushSend() != nil {
+			c.dropped(msg)
 			c.closing = true
 			c.doClose()
 			return
@@ -109,12 +212,18 @@ func (c *PeerConn) appendSend(msg common.WireMessage) {
 func (c *PeerConn) run() {
 	for {
 		select {
-		case <-c.ticker.C:
+		case now := <-c.ticker.C:
 			if c.flushSend() != nil {
 				c.closing = true
 				c.doClose()
 				continue
 			}
+			if !c.supervise(now) {
+				log.Infof("%s sent nothing for %s, dropping it", c.id.String(), c.t.Timeouts.Idle)
+				c.closing = true
+				c.doClose()
+				return
+			}
 			if c.tickstats {
 				c.tx.Tick()
 				c.rx.Tick()
@@ -123,30 +232,47 @@ func (c *PeerConn) run() {
 		case <-c.close:
 			c.doClose()
 			return
-		case msg := <-c.send:
-			if msg == nil {
-				continue
+		case msg := <-c.sendq[trafficControl]:
+			c.write(msg)
+		case msg := <-c.sendq[trafficGossip]:
+			if c.sendUrgent(trafficGossip) {
+				c.write(msg)
 			}
-			if msg.Len() > 1000 {
-				if c.flushSend() == nil {
-					// write big messages right away
-					if c.processWrite(c.c, msg) != nil {
-						c.closing = true
-						c.doClose()
-						continue
-					}
-				} else {
-					c.closing = true
-					c.doClose()
-					continue
-				}
+		case msg := <-c.sendq[trafficData]:
+			// blocks wait for our control traffic so a busy upload never makes a keep-alive or a choke late
+			if c.sendUrgent(trafficData) {
+				c.write(msg)
 			} else {
-				c.appendSend(msg)
+				c.dropped(msg)
 			}
 		}
 	}
 }
 
+// write a message we took from our send queues, returns false if the connection broke and was closed
+func (c *PeerConn) write(msg common.WireMessage) bool {
+	if msg == nil {
+		return true
+	}
+	if msg.Len() > 1000 {
+		// write big messages right away
+		err := c.flushSend()
+		if err != nil {
+			c.dropped(msg)
+		} else {
+			err = c.processWrite(c.c, msg)
+		}
+		if err != nil {
+			c.closing = true
+			c.doClose()
+			return false
+		}
+	} else {
+		c.appendSend(msg)
+	}
+	return !c.closing
+}
+
 func (c *PeerConn) start() {
 	go c.run()
 	go c.runReader()
@@ -158,11 +284,34 @@ func (c *PeerConn) flushSend() error {
 	return err
 }
 
+// put what only this peer gets in our extension handshake
+func (c *PeerConn) fillHandshake() {
+	if h, ok := holepunchAddr(c.c.RemoteAddr()); ok {
+		if h.AddrType == extensions.HolepunchI2P {
+			c.ourOpts.YourDest = h.Addr
+		} else {
+			c.ourOpts.YourIP = h.Addr
+		}
+	}
+	// i2p has no ports
+	_, prt, err := net.SplitHostPort(c.t.Network().Addr().String())
+	if err == nil {
+		if port, _ := strconv.Atoi(prt); port > 0 && port <= 0xffff {
+			p := uint16(port)
+			c.ourOpts.Port = &p
+		}
+	}
+}
+
 func (c *PeerConn) btPeer() (p common.Peer) {
 	h, prt, _ := net.SplitHostPort(c.c.RemoteAddr().String())
 	copy(p.ID[:], c.id[:])
 	p.IP = h
 	p.Port, _ = strconv.Atoi(prt)
+	if c.inbound && c.theirOpts.Port != nil {
+		// they connected from some other port than the one they take connections on
+		p.Port = int(*c.theirOpts.Port)
+	}
 	return
 }
 
@@ -170,38 +319,49 @@ func (c *PeerConn) processWrite(w io.Writer, msg common.WireMessage) (err error)
 	if msg != nil {
 		now := time.Now()
 		c.lastSend = now
-		if c.RemoteChoking() && msg.MessageID() == common.Request {
+		if c.RemoteChoking() && msg.MessageID() == common.Request && !c.allowedWhileChoked(msg.GetPieceRequest().Index) {
 			// drop
 			log.Debugf("cancel request because choke")
 			c.cancelDownload(msg.GetPieceRequest())
 			return
 		}
+		if msg.MessageID() == common.Piece {
+			c.t.bw.waitUpload(c.t.Priority(), int(msg.Len()))
+		}
 		log.Debugf("writing %d bytes", msg.Len())
 		err = util.WriteFull(w, msg)
-		if err == nil {
-			if msg.MessageID() == common.Piece {
+		if err == nil && msg.MessageID() == common.Request {
+			c.wroteRequest(msg.GetPieceRequest(), time.Now())
+		}
+		if msg.MessageID() == common.Piece {
+			if err == nil {
 				n := uint64(msg.Len())
 				c.tx.AddSample(n)
 				c.t.statsTracker.AddSample(RateUpload, n)
 			}
+			c.sentBlock()
 		}
 	}
 	return
 }
 
-// queue a send of a bittorrent wire message to this peer
+// queue a send of a bittorrent wire message to this peer, behind the messages of its kind
 func (c *PeerConn) Send(msg common.WireMessage) {
-	if c.send != nil {
-		c.send <- msg
+	if q := c.sendq[classifyTraffic(msg)]; q != nil {
+		q <- msg
 	}
 }
 
 func (c *PeerConn) recv(msg common.WireMessage) (err error) {
-	c.lastRecv = time.Now()
+	c.arrived = time.Now()
+	c.heard(c.arrived)
+	c.checkClaims()
 	if (!msg.KeepAlive()) && msg.MessageID() == common.Piece {
 		n := uint64(msg.Len())
 		c.rx.AddSample(n)
 		c.t.statsTracker.AddSample(RateDownload, n)
+		// we read no more from the peer until we may take this much in
+		c.t.bw.waitDownload(c.t.Priority(), int(n))
 	}
 	log.Debugf("got %d bytes from %s", msg.Len(), c.id)
 	err = c.inboundMessage(msg)
@@ -216,6 +376,8 @@ func (c *PeerConn) Choke() {
 		log.Debugf("choke peer %s", c.id.String())
 		c.Send(common.NewWireMessage(common.Choke, nil))
 		c.usChoke = true
+		c.chokedAt = time.Now()
+		c.dropChokedUploads()
 	}
 }
 
@@ -225,6 +387,7 @@ func (c *PeerConn) Unchoke() {
 		log.Debugf("unchoke peer %s", c.id.String())
 		c.Send(common.NewWireMessage(common.UnChoke, nil))
 		c.usChoke = false
+		c.unchokedAt = time.Now()
 	}
 }
 
@@ -233,7 +396,10 @@ func (c *PeerConn) gotDownload(p *common.PieceData) {
 	var downloading []*common.PieceRequest
 	for idx := range c.downloading {
 		if c.downloading[idx].Matches(p) {
-			c.t.pt.handlePieceData(p)
+			now := time.Now()
+			c.answeredRequest(c.downloading[idx], c.arrived)
+			c.gotBlock(now)
+			c.t.pt.handlePieceData(p, c.c.RemoteAddr().String())
 		} else {
 			downloading = append(downloading, c.downloading[idx])
 		}
@@ -247,6 +413,7 @@ func (c *PeerConn) cancelDownload(req *common.PieceRequest) {
 	var downloading []*common.PieceRequest
 	for _, r := range c.downloading {
 		if r.Equals(req) {
+			delete(c.requestSent, *r)
 			c.t.pt.canceledRequest(r)
 		} else {
 			downloading = append(downloading, r)
@@ -263,13 +430,35 @@ func (c *PeerConn) numDownloading() int {
 	return i
 }
 
-func (c *PeerConn) queueDownload(req *common.PieceRequest) {
-	c.lastRequest = req
+// we wrote a request to the peer, time it if we still want the block
+func (c *PeerConn) wroteRequest(req *common.PieceRequest, now time.Time) {
 	c.access.Lock()
+	for _, r := range c.downloading {
+		if r.Equals(req) {
+			c.sentRequest(r, now)
+			break
+		}
+	}
+	c.access.Unlock()
+}
+
+// ask the peer for a block, returns false if it already has as many requests as it queues
+func (c *PeerConn) queueDownload(req *common.PieceRequest) bool {
+	c.access.Lock()
+	if len(c.downloading) >= c.maxRequests() {
+		c.access.Unlock()
+		return false
+	}
+	now := time.Now()
+	if len(c.downloading) == 0 {
+		c.waitingSince = now
+	}
 	c.downloading = append(c.downloading, req)
 	c.access.Unlock()
+	c.lastRequest = req
 	log.Debugf("ask %s for %d %d %d", c.id.String(), req.Index, req.Begin, req.Length)
 	c.Send(req.ToWireMessage())
+	return true
 }
 
 func (c *PeerConn) clearDownloading() {
@@ -301,6 +490,15 @@ func (c *PeerConn) Chocking() bool {
 	return c.usChoke
 }
 
+// how long it has been since this peer asked us for anything while unchoked
+func (c *PeerConn) idleSince(now time.Time) time.Duration {
+	last := c.unchokedAt
+	if c.lastPeerRequest.After(last) {
+		last = c.lastPeerRequest
+	}
+	return now.Sub(last)
+}
+
 func (c *PeerConn) remoteUnchoke() {
 	if !c.peerChoke {
 		log.Warnf("remote peer %s sent multiple unchokes", c.id.String())
@@ -327,9 +525,48 @@ func (c *PeerConn) cancelPendingDownloads() {
 	c.access.Unlock()
 }
 
+// how many bytes we ask this peer for at once
+// peers get requests bigger than BlockSize only if they told us they take them
+func (c *PeerConn) blockSize() uint32 {
+	if c.theirOpts.MaxRequest == nil {
+		return BlockSize
+	}
+	sz := clampBlockSize(c.t.RequestBlockSize)
+	if max := clampBlockSize(int(*c.theirOpts.MaxRequest)); max < sz {
+		sz = max
+	}
+	return sz
+}
+
+// how many requests we keep outstanding with this peer, never more than it says it queues
+// MaxParalellRequests until it answered one, then enough to fill its bandwidth delay product
+func (c *PeerConn) maxRequests() int {
+	max := c.MaxParalellRequests
+	if rtt := c.RTT(); rtt > 0 {
+		max = pipelineDepth(c.rx.Mean(), rtt, c.blockSize())
+	}
+	if c.theirOpts.ReqQ != nil && int(*c.theirOpts.ReqQ) < max {
+		max = int(*c.theirOpts.ReqQ)
+	}
+	if max < 1 || c.Snubbed() {
+		max = 1
+	}
+	return max
+}
+
+// tell the peer we won't serve a request, peers without the fast extension can't be told so we disconnect them
+func (c *PeerConn) rejectRequest(r *common.PieceRequest) {
+	if c.fast {
+		c.Send(r.Reject())
+	} else {
+		c.Close()
+	}
+}
+
 func (c *PeerConn) markInterested() {
 	c.peerInterested = true
 	log.Debugf("%s is interested", c.id.String())
+	c.t.wake("has an interested peer")
 }
 
 func (c *PeerConn) markNotInterested() {
@@ -346,11 +583,20 @@ func (c *PeerConn) Close() {
 }
 
 func (c *PeerConn) doClose() {
-	c.send = nil
+	c.dropBlocks()
+	for idx := range c.sendq {
+		c.sendq[idx] = nil
+	}
 	for _, r := range c.downloading {
 		c.t.pt.canceledRequest(r)
 	}
 	c.downloading = nil
+	c.uploadMtx.Lock()
+	c.uploadQueue = nil
+	c.uploadMtx.Unlock()
+	c.uncount()
+	c.t.dropOptimistic(c)
+	c.t.forgetHolepunchRelay(c.c.RemoteAddr().String(), time.Now())
 	log.Debugf("%s closing connection", c.id.String())
 	if c.inbound {
 		c.t.removeIBConn(c)
@@ -363,8 +609,10 @@ func (c *PeerConn) doClose() {
 
 // run read loop
 func (c *PeerConn) runReader() {
-	err := common.ReadWireMessages(c.c, c.recv, c.readBuff[:])
-	if err != nil {
+	err := common.ReadWireMessages(c.c, c.recv, c.readBuff[:], c.t.maxMessageSize)
+	if errors.Is(err, common.ErrToBig) || errors.Is(err, ErrBadMessage) {
+		log.Warnf("dropping %s: %s", c.id.String(), err.Error())
+	} else if err != nil {
 		log.Debugf("PeerConn() reader failed: %s", err.Error())
 	}
 	c.Close()
@@ -400,7 +648,7 @@ func (c *PeerConn) checkInterested() {
 }
 
 func (c *PeerConn) metaInfoDownload() {
-	if !c.t.Ready() && c.theirOpts.MetaData() {
+	if !c.t.Ready() && c.theirExt.Supports(extensions.UTMetaData) {
 		if c.theirOpts.MetainfoSize != nil {
 			l := *c.theirOpts.MetainfoSize
 			if c.t.metaInfo == nil || len(c.t.metaInfo) == 0 {
@@ -414,14 +662,14 @@ func (c *PeerConn) metaInfoDownload() {
 				log.Debugf("metainfo len=%d", len(c.t.metaInfo))
 			}
 		}
-		id, ok := c.theirOpts.Extensions[extensions.UTMetaData.String()]
+		id, ok := c.theirExt.ID(extensions.UTMetaData)
 		if ok {
 			var md extensions.MetaData
 			md.Type = extensions.UTRequest
 			r := c.t.nextMetaInfoReq()
 			if r != nil {
 				md.Piece = *r
-				m := &extensions.Message{ID: uint8(id), PayloadRaw: md.Bytes()}
+				m := &extensions.Message{ID: id, PayloadRaw: md.Bytes()}
 				log.Debugf("asking for info piece %d", md.Piece)
 				c.Send(m.ToWireMessage())
 			} else {
@@ -441,19 +689,52 @@ func (c *PeerConn) inboundMessage(msg common.WireMessage) (err error) {
 	}
 	msgid := msg.MessageID()
 	log.Debugf("%s from %s", msgid.String(), c.id.String())
-	if msgid == common.BitField {
+	if msgid == common.BitField || msgid == common.HaveAll || msgid == common.HaveNone {
+		if msgid != common.BitField && !c.fast {
+			log.Warnf("%s sent %s without the fast extension", c.id.String(), msgid.String())
+			c.Close()
+			return
+		}
 		isnew := false
 		if c.bf == nil {
 			isnew = true
 		}
 		if c.t.Ready() {
-			c.bf = bittorrent.NewBitfield(c.t.MetaInfo().Info.NumPieces(), msg.Payload())
+			var bf *bittorrent.Bitfield
+			switch msgid {
+			case common.HaveAll:
+				bf = bittorrent.NewBitfield(c.t.MetaInfo().Info.NumPieces(), nil).Inverted()
+			case common.HaveNone:
+				bf = bittorrent.NewBitfield(c.t.MetaInfo().Info.NumPieces(), nil)
+			default:
+				if err = c.t.checkBitfield(msg.Payload()); err != nil {
+					return
+				}
+				bf = bittorrent.NewBitfield(c.t.MetaInfo().Info.NumPieces(), msg.Payload())
+			}
+			if c.bf != nil && c.bf.AND(bf.Inverted()).CountSet() > 0 {
+				// only lt_donthave takes pieces back
+				c.brokeClaim("new bitfield lacks pieces it had")
+			}
+			c.bfMtx.Lock()
+			if avail := c.availability(); avail != nil {
+				if c.bf != nil {
+					avail.Remove(c.bf)
+				}
+				avail.Add(bf)
+			}
+			c.bf = bf
+			c.bfMtx.Unlock()
 			log.Debugf("got bitfield from %s", c.id.String())
 			c.checkInterested()
+			c.t.checkPeerAvailable(bf)
 			if isnew {
-				c.Unchoke()
 				c.Send(c.ourOpts.ToWireMessage())
 			}
+		} else if c.fast {
+			c.Send(common.NewWireMessage(common.HaveNone, nil))
+			c.Send(c.ourOpts.ToWireMessage())
+			c.metaInfoDownload()
 		} else {
 			// empty bitfield
 			bits := make([]byte, len(msg.Payload()))
@@ -476,11 +757,12 @@ func (c *PeerConn) inboundMessage(msg common.WireMessage) (err error) {
 		c.remoteUnchoke()
 	}
 	if msgid == common.Interested {
+		c.idleChoked = false
 		c.markInterested()
 		if !c.sentInterested {
 			c.checkInterested()
-			c.Unchoke()
 		}
+		atomic.StoreInt32(&c.askedUnchoke, 1)
 	}
 	if msgid == common.NotInterested {
 		c.markNotInterested()
@@ -489,8 +771,16 @@ func (c *PeerConn) inboundMessage(msg common.WireMessage) (err error) {
 		}
 	}
 	if msgid == common.Request {
+		// asking for pieces is using the slot
+		c.idleChoked = false
 		c.uploading = true
+		c.lastPeerRequest = time.Now()
 		ev := msg.GetPieceRequest()
+		if ev != nil && c.t.Ready() {
+			if err = c.t.checkRequest(ev); err != nil {
+				return
+			}
+		}
 		if ev != nil {
 			c.t.handlePieceRequest(c, ev)
 		}
@@ -498,23 +788,55 @@ func (c *PeerConn) inboundMessage(msg common.WireMessage) (err error) {
 	if msgid == common.Piece {
 		msg.VisitPieceData(c.gotDownload)
 	}
+	if msgid == common.AllowedFast && c.fast {
+		c.gotAllowedFast(msg.GetAllowedFast())
+	}
+	if msgid == common.RejectRequest {
+		r := msg.GetRejectRequest()
+		if r != nil && c.fast {
+			log.Debugf("%s rejected our request for %d %d-%d", c.id.String(), r.Index, r.Begin, r.Begin+r.Length)
+			if !c.peerChoke && c.bf != nil && c.bf.Has(r.Index) && c.underReqQ() {
+				// peers reject what they have while choking us or when we asked for more than they queue, not otherwise
+				c.brokeClaim("rejected a request")
+			}
+			c.cancelDownload(r)
+		}
+	}
 
 	if msgid == common.Have {
 		// update bitfield
 		idx := msg.GetHave()
+		if c.t.Ready() {
+			if err = c.t.checkPieceIndex(idx); err != nil {
+				return
+			}
+		}
 		if c.bf != nil {
+			c.bfMtx.Lock()
+			if !c.bf.Has(idx) {
+				if avail := c.availability(); avail != nil {
+					avail.Have(idx)
+				}
+			}
 			c.bf.Set(idx)
+			c.bfMtx.Unlock()
 			c.checkInterested()
+			c.t.checkPeerAvailable(c.bf)
+			c.superseedGotHave(idx)
 		} else {
 			// default to interested if we have no bitfield yet
 			c.Send(common.NewNotInterested())
 		}
 	}
 	if msgid == common.Cancel {
-		// TODO: check validity
-		//c.t.pt.canceledRequest(msg.GetPieceRequest())
+		if r := msg.GetPieceRequest(); r != nil {
+			c.cancelUpload(*r)
+		}
 	}
 	if msgid == common.Extended {
+		if !c.acceptExtended(msg.Payload()) {
+			return
+		}
 		// handle extended options
 		opts, err := extensions.FromWireMessage(msg)
 		if err == nil {
@@ -526,7 +848,33 @@ func (c *PeerConn) inboundMessage(msg common.WireMessage) (err error) {
 	return
 }
 
+// check an extended message before we decode it, drops oversized or too frequent pex messages
+func (c *PeerConn) acceptExtended(payload []byte) bool {
+	if len(payload) < 1 {
+		return true
+	}
+	ext, ok := c.ourOpts.Lookup(payload[0])
+	if !ok || !extensions.IsPEX(ext) {
+		return true
+	}
+	if len(payload)-1 > extensions.MaxPEXMessageSize {
+		log.Warnf("dropping oversized pex message from %s, %d bytes", c.id.String(), len(payload)-1)
+		return false
+	}
+	now := time.Now()
+	if now.Sub(c.lastPEXRecv) < extensions.MinPEXInterval {
+		log.Debugf("ignoring pex message from %s, too soon after the last one", c.id.String())
+		return false
+	}
+	c.lastPEXRecv = now
+	return true
+}
+
 func (c *PeerConn) handleLNPEX(m interface{}) {
+	if c.t.Private() {
+		// private torrents only use peers from their trackers
+		return
+	}
 	var peers []common.Peer
 	pex, ok := m.(map[string]interface{})
 	if ok {
@@ -534,6 +882,9 @@ func (c *PeerConn) handleLNPEX(m interface{}) {
 		if ok {
 			l, ok := added.([]interface{})
 			if ok {
+				if len(l) > extensions.MaxPEXPeers {
+					l = l[:extensions.MaxPEXPeers]
+				}
 				for idx := range l {
 					p, ok := l[idx].(map[string]interface{})
 					if ok {
@@ -578,66 +929,110 @@ func (c *PeerConn) handleLNPEX(m interface{}) {
 
 // handles an inbound pex message
 func (c *PeerConn) handleI2PPEX(m interface{}) {
+	if c.t.Private() {
+		// private torrents only use peers from their trackers
+		return
+	}
 
 	pex, ok := m.(map[string]interface{})
 	if ok {
 		var added interface{}
 		added, ok = pex["added"]
 		if ok {
-			c.handlePEXAdded(added)
-		}
-		added, ok = pex["added.f"]
-		if ok {
-			c.handlePEXAddedf(added)
+			flags, _ := pex["added.f"].(string)
+			c.handlePEXAdded(added, flags)
 		}
 	} else {
 		log.Errorf("invalid pex message: %q", m)
 	}
 }
 
-// handle inbound PEX message payload
-func (c *PeerConn) handlePEXAdded(m interface{}) {
+// handle inbound PEX message payload, flags is the added.f list with one byte for each added peer
+func (c *PeerConn) handlePEXAdded(m interface{}, flags string) {
 	var peers []common.Peer
-	msg := m.(string)
+	msg, ok := m.(string)
+	if !ok {
+		return
+	}
 	l := len(msg) / 32
-	for l > 0 {
+	if l > extensions.MaxPEXPeers {
+		l = extensions.MaxPEXPeers
+	}
+	for idx := 0; idx < l; idx++ {
 		var p common.Peer
-		// TODO: bounds check
-		copy(p.Compact[:], msg[(l-1)*32:l*32])
-		l--
+		copy(p.Compact[:], msg[idx*32:(idx+1)*32])
 		peers = append(peers, p)
+		if idx < len(flags) && extensions.PEXFlags(flags[idx]).Has(extensions.PEXHolepunch) {
+			c.t.holepunchVia(p, c.c.RemoteAddr())
+		}
 	}
-	c.t.addPeers(peers)
-}
-
-func (c *PeerConn) handlePEXAddedf(m interface{}) {
-	// TODO: implement this
+	c.t.addPeers(orderPEXPeers(peers, []byte(flags), c.t.Done()))
 }
 
 func (c *PeerConn) SupportsI2PPEX() bool {
-	return c.theirOpts.I2PPEX()
+	return c.theirExt.Supports(extensions.I2PPeerExchange)
 }
 
 func (c *PeerConn) SupportsLNPEX() bool {
-	return c.theirOpts.LNPEX()
+	return c.theirExt.Supports(extensions.LokinetPeerExchange)
 }
 
-func (c *PeerConn) sendI2PPEX(connected, disconnected []byte) {
-	id := c.theirOpts.Extensions[extensions.I2PPeerExchange.String()]
-	msg := extensions.NewI2PPEX(uint8(id), connected, disconnected)
+func (c *PeerConn) sendI2PPEX(connected, flags, disconnected []byte) {
+	id, ok := c.theirExt.ID(extensions.I2PPeerExchange)
+	if !ok {
+		// disabled since we checked
+		return
+	}
+	msg := extensions.NewI2PPEX(id, connected, flags, disconnected)
 	c.Send(msg.ToWireMessage())
 }
 
 func (c *PeerConn) sendLNPEX(connected, disconnected []common.Peer) {
-	id := c.theirOpts.Extensions[extensions.LokinetPeerExchange.String()]
-	msg := extensions.NewLNPEX(uint8(id), connected, disconnected)
+	id, ok := c.theirExt.ID(extensions.LokinetPeerExchange)
+	if !ok {
+		return
+	}
+	msg := extensions.NewLNPEX(id, connected, disconnected)
 	c.Send(msg.ToWireMessage())
 }
 
 func (c *PeerConn) handleExtendedOpts(opts extensions.Message) {
 	if opts.ID == 0 {
-		// handshake
-		c.theirOpts = opts.Copy()
+		// handshake, peers may send more later to remap or disable extensions
+		c.theirExt.Update(opts)
+		if opts.Version != "" {
+			c.theirOpts.Version = opts.Version
+			c.t.remotes.setClient(c.c.RemoteAddr(), opts.Version)
+		}
+		if opts.MetainfoSize != nil {
+			sz := *opts.MetainfoSize
+			c.theirOpts.MetainfoSize = &sz
+		}
+		if opts.MaxRequest != nil {
+			sz := *opts.MaxRequest
+			c.theirOpts.MaxRequest = &sz
+		}
+		if opts.ReqQ != nil {
+			n := *opts.ReqQ
+			c.theirOpts.ReqQ = &n
+		}
+		if opts.Port != nil {
+			p := *opts.Port
+			c.theirOpts.Port = &p
+		}
+		if opts.YourIP != nil {
+			c.theirOpts.YourIP = opts.YourIP
+			log.Debugf("%s sees us at %s", c.id.String(), net.IP(opts.YourIP))
+		}
+		if opts.YourDest != nil {
+			c.theirOpts.YourDest = opts.YourDest
+			log.Debugf("%s sees us at %x", c.id.String(), opts.YourDest)
+		}
+		log.Debugf("%s supports extensions %v", c.id.String(), c.theirExt.Names())
+		if !c.t.Private() {
+			c.sendTEX(c.t.texTrackers())
+			c.sendXDHT()
+		}
 	} else {
 		// lookup the extension number
 		ext, ok := c.ourOpts.Lookup(opts.ID)
@@ -654,6 +1049,12 @@ func (c *PeerConn) handleExtendedOpts(opts extensions.Message) {
 				}
 			} else if ext == extensions.UTMetaData.String() {
 				c.handleMetadata(opts)
+			} else if ext == extensions.LTDontHave.String() {
+				c.handleDontHave(opts)
+			} else if ext == extensions.LTTrackerExchange.String() {
+				c.handleTEX(opts)
+			} else if ext == extensions.UTHolepunch.String() {
+				c.handleHolepunch(opts)
 			}
 		} else {
 			log.Warnf("peer %s gave us extension for message we do not have id=%d", c.id.String(), opts.ID)
@@ -662,6 +1063,95 @@ func (c *PeerConn) handleExtendedOpts(opts extensions.Message) {
 	}
 }
 
+// the peer no longer has a piece
+func (c *PeerConn) handleDontHave(m extensions.Message) {
+	idx, err := extensions.ParseDontHave(m.PayloadRaw)
+	if err != nil {
+		log.Warnf("invalid lt_donthave from %s: %s", c.id.String(), err.Error())
+		return
+	}
+	c.bfMtx.Lock()
+	if c.bf == nil || !c.bf.Has(idx) {
+		c.bfMtx.Unlock()
+		return
+	}
+	log.Debugf("%s no longer has piece %d", c.id.String(), idx)
+	c.bf.Unset(idx)
+	if avail := c.availability(); avail != nil {
+		avail.DontHave(idx)
+	}
+	c.bfMtx.Unlock()
+	// it won't send what we asked for
+	c.access.Lock()
+	var downloading []*common.PieceRequest
+	for _, r := range c.downloading {
+		if r.Index == idx {
+			c.Send(r.Cancel())
+			c.t.pt.canceledRequest(r)
+		} else {
+			downloading = append(downloading, r)
+		}
+	}
+	c.downloading = downloading
+	c.access.Unlock()
+	c.checkInterested()
+}
+
+// tell the peer we no longer have a piece if it supports lt_donthave
+func (c *PeerConn) sendDontHave(idx uint32) {
+	id, ok := c.theirExt.ID(extensions.LTDontHave)
+	if ok {
+		m := extensions.NewDontHave(id, idx)
+		c.Send(m.ToWireMessage())
+	}
+}
+
+// the peer told us about trackers
+func (c *PeerConn) handleTEX(m extensions.Message) {
+	if c.t.Private() {
+		// private torrents only use the trackers in their metainfo
+		return
+	}
+	urls := extensions.ParseTEX(m.Payload)
+	c.access.Lock()
+	for _, u := range urls {
+		// don't echo them back
+		c.texSent[u] = true
+	}
+	c.access.Unlock()
+	var learned []string
+	for _, u := range urls {
+		if c.t.addTracker(u) {
+			learned = append(learned, u)
+		}
+	}
+	if len(learned) > 0 {
+		log.Debugf("%s told us about trackers %v", c.id.String(), learned)
+		c.t.persistTrackers(learned)
+	}
+}
+
+// tell the peer about trackers we did not already tell it about if it supports lt_tex
+func (c *PeerConn) sendTEX(urls []string) {
+	id, ok := c.theirExt.ID(extensions.LTTrackerExchange)
+	if !ok {
+		return
+	}
+	var added []string
+	c.access.Lock()
+	for _, u := range urls {
+		if !c.texSent[u] {
+			c.texSent[u] = true
+			added = append(added, u)
+		}
+	}
+	c.access.Unlock()
+	if len(added) > 0 {
+		m := extensions.NewTEX(id, added)
+		c.Send(m.ToWireMessage())
+	}
+}
+
 func (c *PeerConn) askNextMetadata(id uint8) {
 	r := c.t.nextMetaInfoReq()
 	if r != nil {
@@ -687,7 +1177,9 @@ func (c *PeerConn) handleMetadata(m extensions.Message) {
 			log.Debugf("got UTData: piece %d", msg.Piece)
 			if !c.t.Ready() && msg.Size > 0 {
 				c.t.putInfoSlice(msg.Piece, msg.Data)
-				c.askNextMetadata(m.ID)
+				if id, ok := c.theirExt.ID(extensions.UTMetaData); ok {
+					c.askNextMetadata(id)
+				}
 			}
 		} else if msg.Type == extensions.UTReject {
 			log.Debugf("ut_metadata rejected from %s", c.id.String())
@@ -720,6 +1212,12 @@ func (c *PeerConn) handleMetadata(m extensions.Message) {
 			} else {
 				msg.Type = extensions.UTReject
 			}
+			// reply with the id the peer gave us, not ours
+			id, ok := c.theirExt.ID(extensions.UTMetaData)
+			if !ok {
+				return
+			}
+			m.ID = id
 			m.Payload = nil
 			m.PayloadRaw = msg.Bytes()
 			c.Send(m.ToWireMessage())
@@ -729,14 +1227,6 @@ func (c *PeerConn) handleMetadata(m extensions.Message) {
 	}
 }
 
-func (c *PeerConn) sendKeepAlive() {
-	tm := time.Now().Add(0 - (time.Minute * 2))
-	if c.lastSend.Before(tm) {
-		log.Debugf("send keepalive to %s", c.id.String())
-		c.Send(common.KeepAlive)
-	}
-}
-
 // tick download stuff
 func (c *PeerConn) tickDownload() {
 	if !c.runDownload {
@@ -749,25 +1239,37 @@ func (c *PeerConn) tickDownload() {
 			c.Done = nil
 		}
 	} else if (c.usInterested || c.peerInterested) && !c.closing {
+		c.checkSnubbed(time.Now())
+		remote := c.bf
+		lastRequest := c.lastRequest
 		if c.RemoteChoking() {
-			//log.Debugf("will not download this tick, %s is choking", c.id.String())
-			return
+			// only pieces the peer allows while choked
+			remote = c.chokedDownloadable()
+			if remote == nil {
+				//log.Debugf("will not download this tick, %s is choking", c.id.String())
+				return
+			}
+			if lastRequest != nil && !remote.Has(lastRequest.Index) {
+				lastRequest = nil
+			}
 		}
-		// pending request
-		p := c.numDownloading()
-		if p >= c.MaxParalellRequests {
-			//log.Debugf("max parallel reached for %s", c.id.String())
+		now := time.Now()
+		if now.Before(c.nextPieceRequest) {
 			return
 		}
-		now := time.Now()
-		if now.After(c.nextPieceRequest) {
-			r := c.t.pt.NextRequest(c.bf, c.lastRequest)
-			if r != nil {
-				c.queueDownload(r)
-			} else {
+		// fill the pipeline, peers drop requests past the reqq they told us
+		for c.numDownloading() < c.maxRequests() {
+			r := c.t.pt.NextRequest(remote, lastRequest, c.blockSize())
+			if r == nil {
 				c.nextPieceRequest = now.Add(time.Second / 4)
 				log.Debugf("no next piece to download for %s", c.id.String())
+				return
+			}
+			if !c.queueDownload(r) {
+				c.t.pt.canceledRequest(r)
+				return
 			}
+			lastRequest = r
 		}
 	}
 }
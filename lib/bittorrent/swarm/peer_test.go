@@ -0,0 +1,192 @@
+package swarm
+
+import (
+	"bytes"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/util"
+	"testing"
+	"time"
+)
+
+func TestQueueDownloadHonorsReqQ(t *testing.T) {
+	c := &PeerConn{
+		MaxParalellRequests: 8,
+		sendq:               testSendQueues(16),
+	}
+	if c.maxRequests() != 8 {
+		t.Fatalf("max requests %d without reqq", c.maxRequests())
+	}
+	reqq := uint32(2)
+	c.theirOpts.ReqQ = &reqq
+	for idx := uint32(0); idx < 2; idx++ {
+		if !c.queueDownload(&common.PieceRequest{Index: idx, Length: BlockSize}) {
+			t.Fatalf("request %d refused", idx)
+		}
+	}
+	if c.queueDownload(&common.PieceRequest{Index: 2, Length: BlockSize}) {
+		t.Fatal("queued more requests than the peer takes")
+	}
+	if c.queued() != 2 {
+		t.Fatalf("sent %d requests", c.queued())
+	}
+	reqq = 0
+	if c.maxRequests() != 1 {
+		t.Fatalf("max requests %d with zero reqq", c.maxRequests())
+	}
+}
+
+func TestSnubbing(t *testing.T) {
+	c := &PeerConn{
+		MaxParalellRequests: 8,
+		sendq:               testSendQueues(16),
+		t: &Torrent{
+			SnubTimeout: time.Minute,
+			pt: &pieceTracker{requests: map[uint32]*cachedPiece{
+				1: {index: 1, length: BlockSize, pending: bittorrent.NewBitfield(1, nil), obtained: bittorrent.NewBitfield(1, nil)},
+			}},
+		},
+	}
+	req := &common.PieceRequest{Index: 1, Length: BlockSize}
+	c.queueDownload(req)
+	c.checkSnubbed(time.Now())
+	if c.Snubbed() {
+		t.Fatal("snubbed a peer right after asking it for something")
+	}
+	c.checkSnubbed(time.Now().Add(time.Minute * 2))
+	if !c.Snubbed() || c.numDownloading() != 0 {
+		t.Fatalf("peer sitting on %d requests is not snubbed", c.numDownloading())
+	}
+	if c.maxRequests() != 1 {
+		t.Fatalf("snubbed peer gets %d requests", c.maxRequests())
+	}
+	c.gotBlock(time.Now())
+	if c.Snubbed() {
+		t.Fatal("peer that sent a block is still snubbed")
+	}
+}
+
+func TestPipelineDepth(t *testing.T) {
+	// 64KiB/s over 2 seconds is 8 blocks in flight, we keep twice that
+	if depth := pipelineDepth(64*1024, time.Second*2, BlockSize); depth != 16 {
+		t.Fatalf("depth %d for a fast peer", depth)
+	}
+	if depth := pipelineDepth(100, time.Second*2, BlockSize); depth != MinPipelineDepth {
+		t.Fatalf("depth %d for a slow peer", depth)
+	}
+	if depth := pipelineDepth(1<<30, time.Second*2, BlockSize); depth != MaxPipelineDepth {
+		t.Fatalf("depth %d for a very fast peer", depth)
+	}
+	c := &PeerConn{
+		MaxParalellRequests: 8,
+		sendq:               testSendQueues(16),
+		rx:                  util.NewRate(10),
+	}
+	req := &common.PieceRequest{Index: 1, Length: BlockSize}
+	c.queueDownload(req)
+	c.wroteRequest(req, time.Now())
+	c.access.Lock()
+	c.answeredRequest(req, time.Now().Add(time.Second))
+	c.access.Unlock()
+	if rtt := c.RTT(); rtt < time.Second {
+		t.Fatalf("rtt %s", rtt)
+	}
+	if max := c.maxRequests(); max != MinPipelineDepth {
+		t.Fatalf("%d requests for a peer that sent nothing", max)
+	}
+}
+
+func TestRTTConverges(t *testing.T) {
+	// a peer that answers our requests one at a time in the order we sent them
+	const rtt = time.Millisecond * 200
+	const service = time.Millisecond * 50
+	const depth = 8
+	c := &PeerConn{rx: util.NewRate(10)}
+	for idx := range c.rx.Samples {
+		c.rx.Samples[idx].Set(uint64(float64(BlockSize) / service.Seconds()))
+	}
+	// what timing from when we queue a request gets with a full pipeline
+	c.rtt = int64(rtt + depth*service)
+	now := time.Now()
+	var peerFree time.Time
+	var answers []time.Time
+	var next uint32
+	write := func(at time.Time) {
+		req := &common.PieceRequest{Index: next, Length: BlockSize}
+		next++
+		c.downloading = append(c.downloading, req)
+		c.sentRequest(req, at)
+		start := at.Add(rtt / 2)
+		if start.Before(peerFree) {
+			start = peerFree
+		}
+		peerFree = start.Add(service)
+		answers = append(answers, peerFree.Add(rtt/2))
+	}
+	for round := 0; round < 40; round++ {
+		for len(c.downloading) < depth {
+			write(now)
+		}
+		for blocks := 0; len(c.downloading) > 0; blocks++ {
+			now = answers[0]
+			answers = answers[1:]
+			c.answeredRequest(c.downloading[0], now)
+			c.downloading = c.downloading[1:]
+			if blocks < 40 {
+				write(now)
+			}
+		}
+		// the peer sits idle until we want more
+		now = now.Add(time.Second)
+	}
+	if got := c.RTT(); got < rtt+service-service/10 || got > rtt+service+service/10 {
+		t.Fatalf("rtt %s not near %s", got, rtt+service)
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	c := &PeerConn{
+		t: &Torrent{Timeouts: Timeouts{Idle: time.Minute * 5}},
+	}
+	now := time.Now()
+	c.lastSend = now
+	c.heard(now)
+	if !c.supervise(now.Add(time.Minute)) || c.writeBuff.Len() != 0 {
+		t.Fatal("kept alive a connection we just sent something on")
+	}
+	if !c.supervise(now.Add(KeepAliveInterval)) || c.writeBuff.Len() != len(common.KeepAlive) {
+		t.Fatalf("queued %d bytes instead of a keep-alive", c.writeBuff.Len())
+	}
+	if c.supervise(now.Add(time.Minute * 6)) {
+		t.Fatal("kept a peer that sent nothing for longer than the idle timeout")
+	}
+	c.t.Timeouts.Idle = 0
+	if !c.supervise(now.Add(time.Hour)) {
+		t.Fatal("dropped an idle peer without an idle timeout")
+	}
+}
+
+func TestRecheckSendsDontHave(t *testing.T) {
+	tr := recheckTestTorrent(2)
+	supports := &PeerConn{t: tr, sendq: testSendQueues(16)}
+	supports.theirExt.Update(extensions.Message{Extensions: map[string]uint32{extensions.LTDontHave.String(): 7}})
+	other := &PeerConn{t: tr, sendq: testSendQueues(16)}
+	tr.obconns["supports"] = supports
+	tr.obconns["other"] = other
+	if _, err := tr.Recheck(); err != nil {
+		t.Fatal(err)
+	}
+	want := extensions.NewDontHave(7, 2).ToWireMessage()
+	select {
+	case msg := <-supports.sendq[classifyTraffic(want)]:
+		if !bytes.Equal(msg, want) {
+			t.Fatalf("sent %q not lt_donthave for piece 2", msg)
+		}
+	default:
+		t.Fatal("peer that supports lt_donthave wasn't told we lost the piece")
+	}
+	if len(other.sendq[trafficGossip]) != 0 {
+		t.Fatal("sent lt_donthave to a peer that doesn't support it")
+	}
+}
@@ -1,37 +1,114 @@
 package swarm
 
 import (
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/network/i2p"
 	"github.com/majestrate/XD/lib/sync"
 	"net"
+	"sort"
 )
 
+// what we tell other peers about one of our peers
+type pexPeer struct {
+	active bool
+	flags  extensions.PEXFlags
+}
+
 // PEXSwarmState manages PeerExchange state on a bittorrent swarm
 type PEXSwarmState struct {
 	m sync.Map
 }
 
-func (p *PEXSwarmState) onNewPeer(addr net.Addr) {
-	p.m.Store(addr.String(), true)
+func (p *PEXSwarmState) onNewPeer(addr net.Addr, flags extensions.PEXFlags) {
+	p.m.Store(addr.String(), pexPeer{active: true, flags: flags})
 }
 
 func (p *PEXSwarmState) onPeerDisconnected(addr net.Addr) {
-	p.m.Store(addr.String(), false)
+	p.m.Store(addr.String(), pexPeer{})
+}
+
+// update the flags of a connected peer
+func (p *PEXSwarmState) setFlags(addr net.Addr, flags extensions.PEXFlags) {
+	v, ok := p.m.Load(addr.String())
+	if ok && v.(pexPeer).active {
+		p.m.Store(addr.String(), pexPeer{active: true, flags: flags})
+	}
 }
 
 // PopDestHashList gets list of i2p destination hashes of currently active and disconnected peers
-func (p *PEXSwarmState) PopDestHashLists() (connected, disconnected []byte) {
+// and the added.f flags of the active ones, one byte for each
+// at most max of each are returned, disconnected peers not returned are kept for the next call
+func (p *PEXSwarmState) PopDestHashLists(max int) (connected, flags, disconnected []byte) {
+	var numConnected, numDisconnected int
 	p.m.Range(func(k, v interface{}) bool {
 		addr := k.(string)
-		active := v.(bool)
+		peer := v.(pexPeer)
 		h := i2p.I2PAddr(addr).Base32Addr()
-		if active {
-			connected = append(connected, h[:]...)
-		} else {
+		if peer.active {
+			if numConnected < max {
+				connected = append(connected, h[:]...)
+				flags = append(flags, byte(peer.flags))
+				numConnected++
+			}
+		} else if numDisconnected < max {
 			disconnected = append(disconnected, h[:]...)
+			numDisconnected++
 			p.m.Delete(k)
 		}
-		return false
+		return numConnected < max || numDisconnected < max
 	})
 	return
 }
+
+// get the added.f flags we send for a peer
+func (c *PeerConn) pexFlags() (flags extensions.PEXFlags) {
+	if c.bf != nil && c.bf.Completed() {
+		flags |= extensions.PEXSeed
+	}
+	if !c.inbound {
+		flags |= extensions.PEXOutgoing
+	}
+	if c.SupportsHolepunch() {
+		flags |= extensions.PEXHolepunch
+	}
+	return
+}
+
+// order peers learned from pex so the ones worth dialing first come first
+// seeds go first unless we are seeding, then they are dropped as they don't need anything from us
+// flags has one byte for each peer, missing flags count as none
+func orderPEXPeers(peers []common.Peer, flags []byte, seeding bool) []common.Peer {
+	type flagged struct {
+		peer  common.Peer
+		flags extensions.PEXFlags
+	}
+	var list []flagged
+	for idx, peer := range peers {
+		var f extensions.PEXFlags
+		if idx < len(flags) {
+			f = extensions.PEXFlags(flags[idx])
+		}
+		if seeding && f.Has(extensions.PEXSeed) {
+			continue
+		}
+		list = append(list, flagged{peer, f})
+	}
+	rank := func(f extensions.PEXFlags) (r int) {
+		if f.Has(extensions.PEXSeed) {
+			r += 2
+		}
+		if f.Has(extensions.PEXOutgoing) {
+			r++
+		}
+		return
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return rank(list[i].flags) > rank(list[j].flags)
+	})
+	ordered := make([]common.Peer, 0, len(list))
+	for _, f := range list {
+		ordered = append(ordered, f.peer)
+	}
+	return ordered
+}
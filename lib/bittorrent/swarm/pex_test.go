@@ -0,0 +1,49 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOrderPEXPeers(t *testing.T) {
+	peers := []common.Peer{{IP: "a"}, {IP: "b"}, {IP: "c"}, {IP: "d"}}
+	flags := []byte{0, byte(extensions.PEXOutgoing), byte(extensions.PEXSeed)}
+	ordered := orderPEXPeers(peers, flags, false)
+	if len(ordered) != 4 || ordered[0].IP != "c" || ordered[1].IP != "b" || ordered[2].IP != "a" || ordered[3].IP != "d" {
+		t.Fatalf("leeching order %v", ordered)
+	}
+	ordered = orderPEXPeers(peers, flags, true)
+	if len(ordered) != 3 || ordered[0].IP != "b" {
+		t.Fatalf("seeding order %v", ordered)
+	}
+}
+
+func TestForgetHolepunchRelay(t *testing.T) {
+	tr := &Torrent{}
+	relay := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 6881}
+	var p, old common.Peer
+	p.Compact[0] = 1
+	old.Compact[0] = 2
+	tr.holepunchVia(p, relay)
+	tr.holepunchVia(old, relay)
+	now := time.Now()
+	key := func(p common.Peer) string {
+		return holepunchKey(extensions.Holepunch{AddrType: extensions.HolepunchI2P, Addr: p.Compact[:]})
+	}
+	tr.holepunchRelays.Store(key(old), holepunchRelay{addr: "elsewhere", at: now.Add(-holepunchRelayTTL)})
+	has := func(p common.Peer) bool {
+		_, ok := tr.holepunchRelays.Load(key(p))
+		return ok
+	}
+	tr.forgetHolepunchRelay("elsewhere:1", now)
+	if !has(p) || has(old) {
+		t.Fatal("didn't forget just the expired relay")
+	}
+	tr.forgetHolepunchRelay(relay.String(), now)
+	if has(p) {
+		t.Fatal("remembered a relay that disconnected")
+	}
+}
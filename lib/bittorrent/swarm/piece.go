@@ -1,6 +1,7 @@
 package swarm
 
 import (
+	"errors"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/log"
@@ -10,8 +11,23 @@ import (
 )
 
 // how big should we download pieces at a time (bytes)?
+// this is also the smallest unit we track pieces in, bigger requests cover several blocks
 const BlockSize = 1024 * 16
 
+// MaxBlockSize is the biggest piece request we make or serve
+const MaxBlockSize = BlockSize * 8
+
+// clamp a request block size to a multiple of BlockSize no bigger than MaxBlockSize
+func clampBlockSize(sz int) uint32 {
+	if sz < BlockSize {
+		return BlockSize
+	}
+	if sz > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return uint32(sz - (sz % BlockSize))
+}
+
 // cached downloading piece
 type cachedPiece struct {
 	pending    *bittorrent.Bitfield
@@ -20,6 +36,10 @@ type cachedPiece struct {
 	index      uint32
 	length     uint32
 	mtx        sync.Mutex
+	// piece data kept in memory until flushed in order, nil if we write through
+	data []byte
+	// who sent us each block of this piece
+	blocks []blockRecord
 }
 
 // should we accept a piece data with offset and length ?
@@ -37,30 +57,42 @@ func (p *cachedPiece) bitfieldIndex(offset uint32) uint32 {
 	return offset / BlockSize
 }
 
+// visit the bitfield index of every block in a slice of data
+func (p *cachedPiece) forEachBlock(offset, length uint32, visit func(uint32)) {
+	if length == 0 {
+		length = 1
+	}
+	for idx := p.bitfieldIndex(offset); idx <= p.bitfieldIndex(offset+length-1) && idx < p.obtained.Length; idx++ {
+		visit(idx)
+	}
+}
+
 // mark slice of data at offset as obtained
-func (p *cachedPiece) put(offset uint32) {
+func (p *cachedPiece) put(offset, length uint32) {
 	// set obtained
-	idx := p.bitfieldIndex(offset)
-	p.obtained.Set(idx)
-	p.pending.Unset(idx)
+	p.forEachBlock(offset, length, func(idx uint32) {
+		p.obtained.Set(idx)
+		p.pending.Unset(idx)
+	})
 	p.lastActive = time.Now()
-	log.Debugf("put idx=%d offset=%d bit=%d", p.index, offset, idx)
+	log.Debugf("put idx=%d offset=%d len=%d", p.index, offset, length)
 }
 
 // cancel a slice
-func (p *cachedPiece) cancel(offset uint32) {
-	idx := p.bitfieldIndex(offset)
-	p.pending.Unset(idx)
+func (p *cachedPiece) cancel(offset, length uint32) {
+	p.forEachBlock(offset, length, func(idx uint32) {
+		p.pending.Unset(idx)
+	})
 	p.lastActive = time.Now()
 }
 
-func (p *cachedPiece) nextRequest() (r *common.PieceRequest) {
+// make a request for the first free blocks of this piece, covering at most maxLen bytes
+func (p *cachedPiece) nextRequest(maxLen uint32) (r *common.PieceRequest) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 	l := p.length
 	r = new(common.PieceRequest)
 	r.Index = p.index
-	r.Length = BlockSize
 	for r.Begin < l {
 		idx := p.bitfieldIndex(r.Begin)
 		if p.pending.Has(idx) || p.obtained.Has(idx) {
@@ -69,26 +101,29 @@ func (p *cachedPiece) nextRequest() (r *common.PieceRequest) {
 			break
 		}
 	}
-
-	if r.Begin+r.Length > l {
-		// is this probably the last piece ?
-		if (r.Begin+r.Length)-l >= BlockSize {
-			// no, let's just say there are no more blocks left
-			log.Debugf("no next piece request for idx=%d", r.Index)
-			r = nil
-			return
-		} else {
-			// yes so let's correct the size
-			if p.pending.Has(p.bitfieldIndex(r.Begin)) {
-				log.Debugf("no next piece request for idx=%d", r.Index)
-				r = nil
-				return
+	if r.Begin >= l {
+		log.Debugf("no next piece request for idx=%d", r.Index)
+		r = nil
+		return
+	}
+	// grow the request over free blocks after the first
+	for r.Begin+r.Length < l && r.Length+BlockSize <= maxLen {
+		if r.Length > 0 {
+			idx := p.bitfieldIndex(r.Begin + r.Length)
+			if p.pending.Has(idx) || p.obtained.Has(idx) {
+				break
 			}
-			r.Length = l - r.Begin
 		}
+		r.Length += BlockSize
+	}
+	if r.Begin+r.Length > l {
+		// the last block is short
+		r.Length = l - r.Begin
 	}
 	log.Debugf("next piece request made: idx=%d offset=%d len=%d total=%d", r.Index, r.Begin, r.Length, l)
-	p.pending.Set(p.bitfieldIndex(r.Begin))
+	p.forEachBlock(r.Begin, r.Length, func(idx uint32) {
+		p.pending.Set(idx)
+	})
 	return
 }
 
@@ -96,14 +131,31 @@ func (p *cachedPiece) nextRequest() (r *common.PieceRequest) {
 type PiecePicker func(*bittorrent.Bitfield, []uint32) (uint32, bool)
 
 type pieceTracker struct {
-	mtx       sync.Mutex
-	requests  map[uint32]*cachedPiece
-	pending   int
-	st        storage.Torrent
-	have      func(uint32)
+	mtx      sync.Mutex
+	requests map[uint32]*cachedPiece
+	pending  int
+	st       storage.Torrent
+	have     func(uint32)
+	// called with the peers that sent blocks of a piece that failed verification
+	bad func(uint32, []string)
+	// called with the peers we know sent the bad blocks of a piece that failed verification
+	blame func(uint32, []string)
+	// blocks of pieces that failed verification with blocks from several peers, until a good copy shows who sent the bad ones
+	suspects  map[uint32][]blockRecord
 	nextPiece PiecePicker
+	// flush pieces to storage front to back, holding out of order pieces in memory
+	ordered bool
+	// verified pieces waiting for the pieces before them and how many bytes they take
+	held      map[uint32][]byte
+	heldBytes int
+	flushMtx  sync.Mutex
+	// gets the pieces we don't download, held pieces don't wait for them
+	unwanted func() *bittorrent.Bitfield
 }
 
+// most bytes of verified pieces we hold in memory waiting for the pieces before them, past it we only fetch the piece they wait for
+const maxHeldBytes = 64 * 1024 * 1024
+
 // get number of pending pieces we are requesting
 func (pt *pieceTracker) NumPending() int {
 	pt.mtx.Lock()
@@ -128,6 +180,8 @@ func (pt *pieceTracker) visitCached(idx uint32, v func(*cachedPiece)) {
 func createPieceTracker(st storage.Torrent, picker PiecePicker) (pt *pieceTracker) {
 	pt = &pieceTracker{
 		requests:  make(map[uint32]*cachedPiece),
+		held:      make(map[uint32][]byte),
+		suspects:  make(map[uint32][]blockRecord),
 		st:        st,
 		nextPiece: picker,
 	}
@@ -139,27 +193,176 @@ func (pt *pieceTracker) newPiece(piece uint32) bool {
 	info := pt.st.MetaInfo()
 
 	sz := info.LengthOfPiece(piece)
-	bits := sz / BlockSize
+	// the last block may be short
+	bits := (sz + BlockSize - 1) / BlockSize
 	if bits == 0 {
 		bits++
 	}
 	log.Debugf("new piece idx=%d len=%d bits=%d", piece, sz, bits)
-	pt.requests[piece] = &cachedPiece{
+	cp := &cachedPiece{
 		pending:    bittorrent.NewBitfield(bits, nil),
 		obtained:   bittorrent.NewBitfield(bits, nil),
 		length:     sz,
 		index:      piece,
 		lastActive: time.Now(),
 	}
+	if pt.ordered {
+		cp.data = make([]byte, sz)
+	}
+	pt.requests[piece] = cp
 	return true
 }
 
+// set if we flush pieces in order, flushes everything held when turned off
+func (pt *pieceTracker) setOrdered(ordered bool) {
+	pt.mtx.Lock()
+	pt.ordered = ordered
+	pt.mtx.Unlock()
+	pt.flushHeld(!ordered)
+}
+
+// hold a verified piece until the pieces before it are flushed
+func (pt *pieceTracker) holdPiece(idx uint32, data []byte) {
+	pt.mtx.Lock()
+	pt.heldBytes += len(data) - len(pt.held[idx])
+	pt.held[idx] = data
+	pt.mtx.Unlock()
+	pt.flushHeld(false)
+}
+
+// write held pieces to storage front to back, if all is true write every held piece
+func (pt *pieceTracker) flushHeld(all bool) {
+	pt.flushMtx.Lock()
+	defer pt.flushMtx.Unlock()
+	bf := pt.st.Bitfield()
+	unwanted := pt.unwantedPieces()
+	for {
+		var idx uint32
+		var data []byte
+		pt.mtx.Lock()
+		if all {
+			for k, v := range pt.held {
+				idx, data = k, v
+				break
+			}
+		} else {
+			// first piece we want and don't have yet
+			idx = firstMissing(bf, unwanted)
+			data = pt.held[idx]
+		}
+		pt.mtx.Unlock()
+		if data == nil {
+			return
+		}
+		pt.flushPiece(idx, data)
+		// only now so it never looks like we lost it while it is written
+		pt.mtx.Lock()
+		pt.dropHeld(idx)
+		pt.mtx.Unlock()
+	}
+}
+
+// forget a held piece, pt.mtx must be held
+func (pt *pieceTracker) dropHeld(idx uint32) {
+	pt.heldBytes -= len(pt.held[idx])
+	delete(pt.held, idx)
+}
+
+// returns true if we hold so much out of order that we should only fetch the piece it waits for
+func (pt *pieceTracker) holdingTooMuch() bool {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	return pt.heldBytes >= maxHeldBytes
+}
+
+// get the pieces we don't download, nil if we want every piece
+func (pt *pieceTracker) unwantedPieces() *bittorrent.Bitfield {
+	if pt.unwanted == nil {
+		return nil
+	}
+	return pt.unwanted()
+}
+
+// the first piece we don't have and want, have.Length if there is none
+func firstMissing(have, unwanted *bittorrent.Bitfield) (idx uint32) {
+	for idx < have.Length && (have.Has(idx) || (unwanted != nil && unwanted.Has(idx))) {
+		idx++
+	}
+	return
+}
+
+// the first piece we don't have and want, if remote has it and we aren't fetching it already
+func unblockingPiece(have, unwanted, remote *bittorrent.Bitfield, exclude []uint32) (idx uint32, has bool) {
+	idx = firstMissing(have, unwanted)
+	if idx >= have.Length || !remote.Has(idx) {
+		return
+	}
+	for _, ex := range exclude {
+		if ex == idx {
+			return
+		}
+	}
+	has = true
+	return
+}
+
+// returns true if we verified piece idx, it may still wait in memory for the pieces before it
+func (pt *pieceTracker) verified(idx uint32) bool {
+	pt.mtx.Lock()
+	_, held := pt.held[idx]
+	pt.mtx.Unlock()
+	return held || pt.st.Bitfield().Has(idx)
+}
+
+// write a whole piece we verified in memory to storage
+func (pt *pieceTracker) flushPiece(idx uint32, data []byte) {
+	err := pt.st.PutChunk(&common.PieceData{
+		Index: idx,
+		Data:  data,
+	})
+	if err == nil {
+		err = pt.st.VerifyPiece(idx)
+	}
+	if err == nil {
+		if pt.have != nil {
+			pt.have(idx)
+		}
+	} else {
+		log.Warnf("flush piece %d failed: %s", idx, err.Error())
+	}
+}
+
 func (pt *pieceTracker) removePiece(piece uint32) {
 	pt.mtx.Lock()
 	delete(pt.requests, piece)
 	pt.mtx.Unlock()
 }
 
+// start downloading a whole piece ourselves so peers are not asked for it, returns false if it is already being downloaded
+func (pt *pieceTracker) claimPiece(piece uint32) bool {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	if _, has := pt.requests[piece]; has {
+		return false
+	}
+	if !pt.newPiece(piece) {
+		return false
+	}
+	pc := pt.requests[piece]
+	pc.forEachBlock(0, pc.length, func(idx uint32) {
+		pc.pending.Set(idx)
+	})
+	return true
+}
+
+// forget everything we downloaded of a piece so it is requested again from scratch
+func (pt *pieceTracker) resetPiece(piece uint32) {
+	pt.mtx.Lock()
+	delete(pt.requests, piece)
+	pt.dropHeld(piece)
+	pt.mtx.Unlock()
+}
+
 func (pt *pieceTracker) pendingPiece(remote *bittorrent.Bitfield) (idx uint32, old bool) {
 	pt.mtx.Lock()
 	for k := range pt.requests {
@@ -187,7 +390,9 @@ func (pt *pieceTracker) iterCached(v func(*cachedPiece)) {
 
 func (cp *cachedPiece) isExpired() (expired bool) {
 	now := time.Now()
+	cp.mtx.Lock()
 	expired = now.Sub(cp.lastActive) > time.Second*30
+	cp.mtx.Unlock()
 	return
 }
 
@@ -196,14 +401,18 @@ func (pt *pieceTracker) PendingPieces() (exclude []uint32) {
 	for k := range pt.requests {
 		exclude = append(exclude, k)
 	}
+	for k := range pt.held {
+		exclude = append(exclude, k)
+	}
 	pt.mtx.Unlock()
 	return
 }
 
-func (pt *pieceTracker) NextRequest(remote *bittorrent.Bitfield, lastReq *common.PieceRequest) (r *common.PieceRequest) {
+// NextRequest makes the next request to send a peer, covering at most maxLen bytes
+func (pt *pieceTracker) NextRequest(remote *bittorrent.Bitfield, lastReq *common.PieceRequest, maxLen uint32) (r *common.PieceRequest) {
 	if lastReq != nil {
 		pt.visitCached(lastReq.Index, func(cp *cachedPiece) {
-			r = cp.nextRequest()
+			r = cp.nextRequest(maxLen)
 		})
 	}
 	if r != nil {
@@ -212,14 +421,21 @@ func (pt *pieceTracker) NextRequest(remote *bittorrent.Bitfield, lastReq *common
 	// no last request or no more requests for last request
 	// pick new piece
 	exclude := pt.PendingPieces()
-	idx, has := pt.nextPiece(remote, exclude)
+	var idx uint32
+	var has bool
+	if pt.holdingTooMuch() {
+		// anything further ahead would only wait in memory too
+		idx, has = unblockingPiece(pt.st.Bitfield(), pt.unwantedPieces(), remote, exclude)
+	} else {
+		idx, has = pt.nextPiece(remote, exclude)
+	}
 	if !has {
 		// no next piece
 		return
 	}
 	// get next requset for this newly created piece
 	pt.visitCached(idx, func(cp *cachedPiece) {
-		r = cp.nextRequest()
+		r = cp.nextRequest(maxLen)
 	})
 	return
 }
@@ -230,7 +446,7 @@ func (pt *pieceTracker) nextRequestForDownload(remote *bittorrent.Bitfield, req
 	idx, old := pt.pendingPiece(remote)
 	if old {
 		pt.visitCached(idx, func(cp *cachedPiece) {
-			r = cp.nextRequest()
+			r = cp.nextRequest(BlockSize)
 		})
 	}
 	if r == nil && requestNew {
@@ -243,7 +459,7 @@ func (pt *pieceTracker) nextRequestForDownload(remote *bittorrent.Bitfield, req
 		idx, has = pt.nextPiece(remote, exclude)
 		if has {
 			pt.visitCached(idx, func(cp *cachedPiece) {
-				r = cp.nextRequest()
+				r = cp.nextRequest(BlockSize)
 			})
 		}
 	}
@@ -261,34 +477,62 @@ func (pt *pieceTracker) canceledRequest(r *common.PieceRequest) {
 		return
 	}
 	pt.visitCached(r.Index, func(pc *cachedPiece) {
-		pc.cancel(r.Begin)
+		pc.mtx.Lock()
+		pc.cancel(r.Begin, r.Length)
+		pc.mtx.Unlock()
 	})
 }
 
-func (pt *pieceTracker) handlePieceData(d *common.PieceData) {
+// handle a block of piece data from the peer at address from
+func (pt *pieceTracker) handlePieceData(d *common.PieceData, from string) {
 	idx := d.Index
 	pt.visitCached(idx, func(pc *cachedPiece) {
+		pc.mtx.Lock()
 		if !pc.accept(d.Begin, uint32(len(d.Data))) {
+			pc.mtx.Unlock()
 			log.Errorf("invalid piece data: index=%d offset=%d length=%d", d.Index, d.Begin, len(d.Data))
 			return
 		}
-		err := pt.st.PutChunk(d)
-		if err == nil {
-			pc.put(d.Begin)
+		// a late duplicate of a block of a piece that is done must not check it again
+		wasDone := pc.done()
+		pc.record(d.Begin, d.Data, from)
+		if pc.data != nil {
+			// keep it in memory until it is flushed in order
+			copy(pc.data[d.Begin:], d.Data)
+			pc.put(d.Begin, uint32(len(d.Data)))
+		} else if err := pt.st.PutChunk(d); err == nil {
+			pc.put(d.Begin, uint32(len(d.Data)))
 		} else {
 			log.Errorf("failed to put chunk %d: %s", idx, err.Error())
 		}
-		if pc.done() {
-			err = pt.st.VerifyPiece(idx)
-			if err == nil {
-				pt.st.Flush()
-				if pt.have != nil {
-					pt.have(idx)
-				}
+		finished := !wasDone && pc.done()
+		pc.mtx.Unlock()
+		if !finished {
+			return
+		}
+		if pc.data != nil {
+			if pt.st.MetaInfo().CheckPiece(&common.PieceData{Index: idx, Data: pc.data}) {
+				pt.goodPiece(pc)
+				pt.holdPiece(idx, pc.data)
 			} else {
-				log.Warnf("put piece %d failed: %s", idx, err.Error())
+				log.Warnf("put piece %d failed: %s", idx, common.ErrPieceHashMismatch.Error())
+				pt.badPiece(pc)
 			}
 			pt.removePiece(idx)
+			return
+		}
+		err := pt.st.VerifyPiece(idx)
+		if err == nil {
+			pt.goodPiece(pc)
+			if pt.have != nil {
+				pt.have(idx)
+			}
+		} else {
+			log.Warnf("put piece %d failed: %s", idx, err.Error())
+			if errors.Is(err, common.ErrPieceHashMismatch) {
+				pt.badPiece(pc)
+			}
 		}
+		pt.removePiece(idx)
 	})
 }
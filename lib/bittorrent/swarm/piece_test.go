@@ -1,7 +1,16 @@
 package swarm
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/fs"
 	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/stats"
+	"github.com/majestrate/XD/lib/storage"
+	"path/filepath"
 	"testing"
 )
 
@@ -9,3 +18,195 @@ func TestPieceRequester(t *testing.T) {
 	log.SetLevel("debug")
 
 }
+
+func TestCachedPieceBigRequests(t *testing.T) {
+	// 4 full blocks and a short one
+	sz := uint32(BlockSize*4 + 100)
+	cp := &cachedPiece{
+		pending:  bittorrent.NewBitfield(5, nil),
+		obtained: bittorrent.NewBitfield(5, nil),
+		length:   sz,
+	}
+	r := cp.nextRequest(BlockSize * 2)
+	if r == nil || r.Begin != 0 || r.Length != BlockSize*2 {
+		t.Fatalf("first request %v", r)
+	}
+	r = cp.nextRequest(BlockSize)
+	if r == nil || r.Begin != BlockSize*2 || r.Length != BlockSize {
+		t.Fatalf("second request %v", r)
+	}
+	r = cp.nextRequest(MaxBlockSize)
+	if r == nil || r.Begin != BlockSize*3 || r.Length != BlockSize+100 {
+		t.Fatalf("last request %v", r)
+	}
+	if cp.nextRequest(MaxBlockSize) != nil {
+		t.Fatal("request after every block is pending")
+	}
+	cp.cancel(0, BlockSize*2)
+	cp.put(BlockSize*2, BlockSize)
+	cp.put(BlockSize*3, BlockSize+100)
+	r = cp.nextRequest(MaxBlockSize)
+	if r == nil || r.Begin != 0 || r.Length != BlockSize*2 {
+		t.Fatalf("request after cancel %v", r)
+	}
+	cp.put(0, BlockSize*2)
+	if !cp.done() {
+		t.Fatal("piece not done")
+	}
+}
+
+func TestHeldPieceVerified(t *testing.T) {
+	// a piece waiting in memory for the pieces before it is as good as written
+	pt := &pieceTracker{held: map[uint32][]byte{3: make([]byte, 8)}}
+	if !pt.verified(3) {
+		t.Fatal("held piece is not verified")
+	}
+}
+
+func TestHoldingTooMuch(t *testing.T) {
+	pt := &pieceTracker{held: make(map[uint32][]byte)}
+	pt.held[5] = make([]byte, 8)
+	pt.heldBytes = maxHeldBytes
+	if !pt.holdingTooMuch() {
+		t.Fatal("not holding too much at the cap")
+	}
+	pt.mtx.Lock()
+	pt.dropHeld(5)
+	pt.mtx.Unlock()
+	if pt.heldBytes != maxHeldBytes-8 || pt.holdingTooMuch() {
+		t.Fatalf("%d bytes held after dropping the piece", pt.heldBytes)
+	}
+	// we have 0 and 1, held pieces wait for 2
+	have := bittorrent.NewBitfield(8, nil)
+	have.Set(0)
+	have.Set(1)
+	remote := bittorrent.NewBitfield(8, nil)
+	remote.Set(3)
+	if _, has := unblockingPiece(have, nil, remote, nil); has {
+		t.Fatal("picked a piece the peer doesn't have")
+	}
+	remote.Set(2)
+	if idx, has := unblockingPiece(have, nil, remote, nil); !has || idx != 2 {
+		t.Fatalf("picked %d %v", idx, has)
+	}
+	if _, has := unblockingPiece(have, nil, remote, []uint32{2}); has {
+		t.Fatal("picked a piece we are fetching already")
+	}
+}
+
+func TestOrderedFlushSkipsUnwanted(t *testing.T) {
+	dir := t.TempDir()
+	st := &storage.FsStorage{
+		MetaDir:    filepath.Join(dir, "meta"),
+		DataDir:    filepath.Join(dir, "data"),
+		SeedingDir: filepath.Join(dir, "seeding"),
+		FS:         fs.STD,
+	}
+	if err := st.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// a is in pieces 0 and 1, b in 2 and 3 and c in 4 and 5
+	data := make([]byte, BlockSize*6)
+	rand.Read(data)
+	var pieces []byte
+	for idx := 0; idx < 6; idx++ {
+		h := sha1.Sum(data[idx*BlockSize : (idx+1)*BlockSize])
+		pieces = append(pieces, h[:]...)
+	}
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: BlockSize,
+			Pieces:      pieces,
+			Path:        "ordered",
+			Files: []metainfo.FileInfo{
+				{Length: BlockSize * 2, Path: metainfo.FilePath{"a"}},
+				{Length: BlockSize * 2, Path: metainfo.FilePath{"b"}},
+				{Length: BlockSize * 2, Path: metainfo.FilePath{"c"}},
+			},
+		},
+	}
+	ts, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTorrent(ts, nil)
+	tr.pt.setOrdered(true)
+	mask := bittorrent.NewBitfield(6, nil)
+	mask.Set(1)
+	if err = tr.SetPieceMask(mask); err != nil {
+		t.Fatal(err)
+	}
+	if err = tr.SetSkippedFiles([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+	piece := func(idx int) []byte {
+		return append([]byte(nil), data[idx*BlockSize:(idx+1)*BlockSize]...)
+	}
+	tr.pt.holdPiece(4, piece(4))
+	tr.pt.holdPiece(5, piece(5))
+	if tr.Bitfield().Has(4) {
+		t.Fatal("flushed a piece before the first one we want")
+	}
+	have := bittorrent.NewBitfield(6, nil)
+	remote := bittorrent.NewBitfield(6, nil).Inverted()
+	if idx, has := unblockingPiece(have, tr.unwantedPieces(), remote, nil); !has || idx != 0 {
+		t.Fatalf("held pieces wait for %d %v", idx, has)
+	}
+	have.Set(0)
+	if idx, has := unblockingPiece(have, tr.unwantedPieces(), remote, nil); !has || idx != 4 {
+		t.Fatalf("held pieces wait for %d %v not the first one we want", idx, has)
+	}
+	tr.pt.holdPiece(0, piece(0))
+	if len(tr.pt.held) != 0 {
+		t.Fatalf("still holding %d pieces behind ones we don't want", len(tr.pt.held))
+	}
+	if bf := tr.Bitfield(); !bf.Has(0) || !bf.Has(4) || !bf.Has(5) {
+		t.Fatal("held pieces were not written")
+	}
+	if !tr.Done() {
+		t.Fatal("not done with every piece we want")
+	}
+}
+
+// storage that remembers the pieces written to it
+type heldStorage struct {
+	recheckStorage
+	written []uint32
+}
+
+func (s *heldStorage) PutChunk(pc *common.PieceData) error {
+	s.written = append(s.written, pc.Index)
+	return nil
+}
+
+func (s *heldStorage) VerifyPiece(idx uint32) error {
+	s.bf.Set(idx)
+	return nil
+}
+
+func (s *heldStorage) Flush() error {
+	return nil
+}
+
+func (s *heldStorage) SaveStats(*stats.Tracker) error {
+	return nil
+}
+
+func TestCloseFlushesHeld(t *testing.T) {
+	tr := recheckTestTorrent()
+	st := &heldStorage{recheckStorage: *tr.st.(*recheckStorage)}
+	st.bf = bittorrent.NewBitfield(4, nil)
+	tr.st = st
+	tr.pt = createPieceTracker(st, nil)
+	tr.pt.setOrdered(true)
+	tr.pt.holdPiece(2, make([]byte, 16))
+	if len(st.written) != 0 {
+		t.Fatal("flushed a piece before the ones ahead of it")
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.written) != 1 || st.written[0] != 2 || len(tr.pt.held) != 0 {
+		t.Fatalf("closing wrote %v and kept %d pieces in memory", st.written, len(tr.pt.held))
+	}
+}
@@ -0,0 +1,73 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"github.com/majestrate/XD/lib/network/inet"
+	"github.com/majestrate/XD/lib/network/loopback"
+)
+
+// kinds of network a torrent may be pinned to
+const NetworkI2P = "i2p"
+const NetworkLokinet = "lokinet"
+const NetworkLoopback = "loopback"
+
+// ErrPinned is returned when a torrent may not run in a swarm or on its network
+var ErrPinned = errors.New("torrent is pinned to another swarm or network")
+
+// NetworkKind gets the kind of network n is, empty if we don't know it
+func NetworkKind(n network.Network) string {
+	switch n.(type) {
+	case *loopback.Session:
+		return NetworkLoopback
+	case *inet.Session:
+		return NetworkLokinet
+	case i2p.Session:
+		return NetworkI2P
+	}
+	return ""
+}
+
+// CheckNetworkKind returns an error if name is not a kind of network a torrent may be pinned to, empty is fine
+func CheckNetworkKind(name string) error {
+	switch name {
+	case "", NetworkI2P, NetworkLokinet, NetworkLoopback:
+		return nil
+	}
+	return fmt.Errorf("no such network %q, use %s, %s or %s", name, NetworkI2P, NetworkLokinet, NetworkLoopback)
+}
+
+// PinnedNetwork gets the kind of network this torrent may only run on, empty if any
+func (t *Torrent) PinnedNetwork() string {
+	return t.pinNetwork
+}
+
+// PinnedSwarm gets the number of the swarm this torrent may only run in, -1 if any
+func (t *Torrent) PinnedSwarm() int {
+	return t.pinSwarm
+}
+
+// returns true if our network is one the torrent may talk to peers and trackers on
+func (t *Torrent) onPinnedNetwork() bool {
+	if t.pinNetwork == "" {
+		return true
+	}
+	if kind := NetworkKind(t.Network()); kind != t.pinNetwork {
+		log.Debugf("%s is pinned to %s, not using %s", t.Name(), t.pinNetwork, kind)
+		return false
+	}
+	return true
+}
+
+// returns true if a torrent pinned to the network kind may run on the network sw has now
+func (sw *Swarm) onNetwork(kind string) bool {
+	return kind == "" || NetworkKind(sw.Network()) == kind
+}
+
+// returns true if a torrent pinned to swarm may run in sw
+func (sw *Swarm) pinnedHere(swarm int) bool {
+	return swarm < 0 || swarm == sw.number
+}
@@ -0,0 +1,109 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/loopback"
+	"github.com/majestrate/XD/lib/tracker"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNetworkPin(t *testing.T) {
+	if kind := NetworkKind(loopback.NewHub().NewSession("a")); kind != NetworkLoopback {
+		t.Fatalf("loopback session is %q", kind)
+	}
+	for _, kind := range []string{"", NetworkI2P, NetworkLokinet, NetworkLoopback} {
+		if err := CheckNetworkKind(kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if CheckNetworkKind("tor") == nil {
+		t.Fatal("we have no tor network")
+	}
+	sw := &Swarm{number: 1}
+	if !sw.pinnedHere(-1) || !sw.pinnedHere(1) || sw.pinnedHere(0) {
+		t.Fatal("swarm 1 got the wrong torrents")
+	}
+}
+
+// announcer that counts how often we announced to it
+type countingAnnouncer struct {
+	announced int32
+}
+
+func (a *countingAnnouncer) Announce(req *tracker.Request) (*tracker.Response, error) {
+	atomic.AddInt32(&a.announced, 1)
+	return &tracker.Response{}, nil
+}
+
+func (a *countingAnnouncer) Scrape(req *tracker.ScrapeRequest) (*tracker.ScrapeResponse, error) {
+	return nil, tracker.ErrScrapeNotSupported
+}
+
+func (a *countingAnnouncer) Name() string {
+	return "counting"
+}
+
+func TestNetworkPinEnforced(t *testing.T) {
+	n := loopback.NewHub().NewSession("a")
+	tr := recheckTestTorrent()
+	tr.Network = func() network.Network { return n }
+	tr.remotes = new(remotePeers)
+	tr.Retry = DefaultRetryPolicy
+	tr.started = true
+	tr.pinNetwork = NetworkI2P
+	a := &countingAnnouncer{}
+	tr.announcers = map[string]*torrentAnnounce{"counting": &torrentAnnounce{announce: a, t: tr}}
+	tr.announce("counting", tracker.Started)
+	if atomic.LoadInt32(&a.announced) != 0 {
+		t.Fatal("announced on a network the torrent isn't pinned to")
+	}
+	sw := &Swarm{}
+	if sw.servesInbound(tr) {
+		t.Fatal("took inbound peers on a network the torrent isn't pinned to")
+	}
+	peer := n.Addr()
+	if err := tr.DialPeer(peer, common.PeerID{}); err != ErrPinned {
+		t.Fatalf("dialing on a network the torrent isn't pinned to gave %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		tr.PersistPeer(peer, common.PeerID{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("kept dialing a peer on a network the torrent isn't pinned to")
+	}
+	if fails := tr.remotes.dialFailures(peer); fails != 0 {
+		t.Fatalf("pinned torrent counted %d failures dialing", fails)
+	}
+	tr.pinNetwork = NetworkLoopback
+	if !sw.servesInbound(tr) {
+		t.Fatal("refused inbound peers on the network the torrent is pinned to")
+	}
+}
+
+func TestMagnetNetworkPin(t *testing.T) {
+	sw := &Swarm{getNet: make(chan network.Network)}
+	n := loopback.NewHub().NewSession("a")
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case sw.getNet <- n:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	uri := "magnet:?xt=urn:btih:" + strings.Repeat("ab", 20)
+	if err := sw.addMagnetURI(uri, AddOptions{Network: NetworkI2P}); err != ErrPinned {
+		t.Fatalf("adding a magnet pinned to another network gave %v", err)
+	}
+}
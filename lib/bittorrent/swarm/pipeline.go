@@ -0,0 +1,89 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// MinPipelineDepth is the fewest requests we keep outstanding with a peer that is sending us blocks
+const MinPipelineDepth = 2
+
+// MaxPipelineDepth is the most requests we keep outstanding with the fastest peers, what we tell peers we take
+const MaxPipelineDepth = PeerSendQueueSize
+
+// how many requests to keep outstanding to fill the bandwidth delay product of a peer sending rate bytes a second with round trip time rtt
+// twice the product in blocks so the pipe stays full while the rtt wobbles
+func pipelineDepth(rate float64, rtt time.Duration, block uint32) int {
+	if block == 0 {
+		block = BlockSize
+	}
+	depth := int(math.Ceil(2 * rate * rtt.Seconds() / float64(block)))
+	if depth < MinPipelineDepth {
+		depth = MinPipelineDepth
+	}
+	if depth > MaxPipelineDepth {
+		depth = MaxPipelineDepth
+	}
+	return depth
+}
+
+// when we wrote a request to the peer and how many of ours it had not answered then
+type requestTiming struct {
+	at    time.Time
+	ahead int
+}
+
+// remember when we wrote a request to time the block that answers it, call with access held
+func (c *PeerConn) sentRequest(r *common.PieceRequest, now time.Time) {
+	if c.requestSent == nil || len(c.requestSent) > len(c.downloading)+MaxPipelineDepth {
+		// drop the times of requests that went away without an answer
+		sent := make(map[common.PieceRequest]requestTiming)
+		for _, pending := range c.downloading {
+			if t, ok := c.requestSent[*pending]; ok {
+				sent[*pending] = t
+			}
+		}
+		c.requestSent = sent
+	}
+	c.requestSent[*r] = requestTiming{at: now, ahead: len(c.requestSent)}
+}
+
+// a block answering r came in at now, fold its round trip into the peer's rtt, call with access held
+// a request the peer had others of ours ahead of may have waited behind them, we only time it if the peer sat idle before answering it
+func (c *PeerConn) answeredRequest(r *common.PieceRequest, now time.Time) {
+	sent, ok := c.requestSent[*r]
+	if !ok {
+		return
+	}
+	delete(c.requestSent, *r)
+	last := c.lastAnswer
+	c.lastAnswer = now
+	if sent.ahead > 0 && (last.IsZero() || !c.wasIdle(r, now.Sub(last))) {
+		return
+	}
+	rtt := c.RTT()
+	if sample := now.Sub(sent.at); rtt == 0 {
+		rtt = sample
+	} else {
+		rtt += (sample - rtt) / 8
+	}
+	atomic.StoreInt64(&c.rtt, int64(rtt))
+}
+
+// returns true if a block answering r that came gap after the one before took clearly longer than the peer needs to send it
+// a peer working through a queue of our requests sends them back to back
+func (c *PeerConn) wasIdle(r *common.PieceRequest, gap time.Duration) bool {
+	rate := c.rx.Mean()
+	if rate <= 0 {
+		return false
+	}
+	block := time.Duration(float64(r.Length) / rate * float64(time.Second))
+	return gap >= block*3/2
+}
+
+// RTT returns how long the peer takes to answer our requests, 0 until it answered one
+func (c *PeerConn) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
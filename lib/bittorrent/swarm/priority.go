@@ -0,0 +1,117 @@
+package swarm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"hash/crc32"
+	"net"
+	"sort"
+	"strconv"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// bep 40 canonical peer priority between us and a peer, both sides of a connection agree on it
+// ips are masked like bep 40 says so peers in the same network don't all rank the same against us,
+// destinations have no network to mask so their hashes are used as they are
+func peerPriority(us, them net.Addr) uint32 {
+	a, aport, aip := priorityBytes(us)
+	b, bport, bip := priorityBytes(them)
+	if aip && bip && len(a) == len(b) {
+		if bytes.Equal(a, b) {
+			// same ip, use the ports
+			a, b = aport, bport
+		} else {
+			a, b = maskIP(a, b), maskIP(b, a)
+		}
+	}
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	h := crc32.New(castagnoli)
+	h.Write(a)
+	h.Write(b)
+	return h.Sum32()
+}
+
+// get the ip or destination hash of an address and its port, isIP is false for destination hashes
+func priorityBytes(a net.Addr) (addr, port []byte, isIP bool) {
+	if a.Network() == "i2p" {
+		b32 := i2p.I2PAddr(a.String()).Base32Addr()
+		return b32[:], nil, false
+	}
+	host, p, err := net.SplitHostPort(a.String())
+	if err != nil {
+		host = a.String()
+	}
+	n, _ := strconv.Atoi(p)
+	port = make([]byte, 2)
+	binary.BigEndian.PutUint16(port, uint16(n))
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// names like lokinet ones
+		h := sha256.Sum256([]byte(host))
+		return h[:], port, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, port, true
+	}
+	return ip.To16(), port, true
+}
+
+// mask the parts of ip past the network it shares with other
+func maskIP(ip, other []byte) []byte {
+	// bytes of the prefixes bep 40 compares, /16 /24 for ipv4 and /48 /56 /64 for ipv6
+	prefixes := []int{2, 3, 4}
+	if len(ip) == net.IPv6len {
+		prefixes = []int{6, 7, 8, net.IPv6len}
+	}
+	keep := prefixes[0]
+	for _, n := range prefixes[1:] {
+		if !bytes.Equal(ip[:keep], other[:keep]) {
+			break
+		}
+		keep = n
+	}
+	masked := make([]byte, len(ip))
+	for idx := range ip {
+		if idx < keep {
+			masked[idx] = ip[idx]
+		} else {
+			masked[idx] = ip[idx] & 0x55
+		}
+	}
+	return masked
+}
+
+// sort addresses highest priority first
+func (t *Torrent) sortByPriority(addrs []net.Addr) {
+	us := t.Network().Addr()
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return peerPriority(us, addrs[i]) > peerPriority(us, addrs[j])
+	})
+}
+
+// close the lowest priority connection if it is lower priority than a, returns true if we closed one
+func (t *Torrent) evictFor(a net.Addr) bool {
+	us := t.Network().Addr()
+	prio := peerPriority(us, a)
+	var lowest *PeerConn
+	var lowestPrio uint32
+	t.VisitPeers(func(c *PeerConn) {
+		p := peerPriority(us, c.c.RemoteAddr())
+		if lowest == nil || p < lowestPrio {
+			lowest = c
+			lowestPrio = p
+		}
+	})
+	if lowest == nil || lowestPrio >= prio {
+		return false
+	}
+	log.Debugf("closing %s to make room for higher priority peer %s", lowest.c.RemoteAddr(), a)
+	lowest.Close()
+	return true
+}
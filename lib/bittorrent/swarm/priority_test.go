@@ -0,0 +1,36 @@
+package swarm
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPeerPriority(t *testing.T) {
+	addr := func(s string) net.Addr {
+		a, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	// examples from bep 40
+	for _, c := range []struct {
+		a, b string
+		prio uint32
+	}{
+		{"123.213.32.10:6881", "98.76.54.32:6881", 0xec2d7224},
+		{"123.213.32.10:6881", "123.213.32.234:6881", 0x99568189},
+	} {
+		a, b := addr(c.a), addr(c.b)
+		if p := peerPriority(a, b); p != c.prio {
+			t.Errorf("%s %s has priority %x not %x", c.a, c.b, p, c.prio)
+		}
+		if peerPriority(b, a) != peerPriority(a, b) {
+			t.Errorf("%s %s priority depends on the side", c.a, c.b)
+		}
+	}
+	a, b := addr("10.0.0.1:1000"), addr("10.0.0.1:2000")
+	if peerPriority(a, b) == peerPriority(a, addr("10.0.0.1:3000")) {
+		t.Error("ports are not used for the same ip")
+	}
+}
@@ -0,0 +1,69 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"net"
+	"time"
+)
+
+// ErrNotTrackerPeer is returned when dialing a peer of a private torrent that none of its trackers gave us
+var ErrNotTrackerPeer = errors.New("private torrents only dial peers from their trackers")
+
+// how long we may dial a peer of a private torrent after a tracker last gave it to us
+const trackerPeerTTL = time.Hour * 2
+
+// extensions that find peers or trackers outside of the trackers in the metainfo, private torrents don't advertise them
+var privateDisabled = []extensions.Extension{
+	extensions.I2PPeerExchange,
+	extensions.LokinetPeerExchange,
+	extensions.XDHT,
+	extensions.LTTrackerExchange,
+	extensions.UTHolepunch,
+}
+
+// hold the torrent to the private torrent contract if its metainfo says it is private
+// called when the torrent is made and again once we got its metainfo, a magnet doesn't tell us until then
+func (t *Torrent) enforcePrivate() {
+	if !t.Private() {
+		return
+	}
+	log.Debugf("%s is private, only using peers from its trackers", t.Name())
+	for _, ext := range privateDisabled {
+		t.defaultOpts.SetUnsupported(ext)
+	}
+}
+
+// add peers a tracker gave us, the only peers a private torrent dials
+func (t *Torrent) addTrackerPeers(peers []common.Peer) {
+	if t.Private() {
+		t.pruneTrackerPeers(time.Now())
+	}
+	t.dialPeers(peers, true)
+}
+
+// remember that a tracker gave us a peer of a private torrent
+func (t *Torrent) rememberTrackerPeer(a net.Addr, now time.Time) {
+	t.trackerPeers.Store(a.String(), now)
+}
+
+// forget peers of a private torrent no tracker gave us since trackerPeerTTL before now
+func (t *Torrent) pruneTrackerPeers(now time.Time) {
+	t.trackerPeers.Range(func(k, v interface{}) bool {
+		if now.Sub(v.(time.Time)) > trackerPeerTTL {
+			t.trackerPeers.Delete(k)
+		}
+		return true
+	})
+}
+
+// returns true if we may dial a peer, a private torrent only dials peers its trackers gave us
+func (t *Torrent) mayDial(a net.Addr) bool {
+	if !t.Private() {
+		return true
+	}
+	_, ok := t.trackerPeers.Load(a.String())
+	return ok
+}
@@ -0,0 +1,23 @@
+package swarm
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPruneTrackerPeers(t *testing.T) {
+	tr := &Torrent{}
+	now := time.Now()
+	old := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	fresh := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 6881}
+	tr.rememberTrackerPeer(old, now.Add(-trackerPeerTTL-time.Minute))
+	tr.rememberTrackerPeer(fresh, now.Add(-time.Minute))
+	tr.pruneTrackerPeers(now)
+	if _, ok := tr.trackerPeers.Load(old.String()); ok {
+		t.Fatal("kept a peer no tracker gave us in a long time")
+	}
+	if _, ok := tr.trackerPeers.Load(fresh.String()); !ok {
+		t.Fatal("pruned a peer a tracker just gave us")
+	}
+}
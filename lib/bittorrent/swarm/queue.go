@@ -0,0 +1,280 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/sync"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TorrentPriority is how soon a torrent gets a turn in the active queue and how big a share of the bandwidth limits it gets
+type TorrentPriority int
+
+// PriorityLow torrents wait for every other torrent and get the smallest share of the bandwidth limits
+const PriorityLow = TorrentPriority(-1)
+
+// PriorityNormal is the priority torrents start out with
+const PriorityNormal = TorrentPriority(0)
+
+// PriorityHigh torrents go before every other torrent and get the biggest share of the bandwidth limits
+const PriorityHigh = TorrentPriority(1)
+
+// how many priorities there are
+const numPriorities = 3
+
+// ErrBadPriority is returned when setting a priority that isn't high, normal or low
+var ErrBadPriority = errors.New("priority must be high, normal or low")
+
+var priorityNames = []string{"low", "normal", "high"}
+
+// ParsePriority reads a priority written by String
+func ParsePriority(s string) (TorrentPriority, error) {
+	for idx, name := range priorityNames {
+		if strings.ToLower(s) == name {
+			return TorrentPriority(idx) + PriorityLow, nil
+		}
+	}
+	return PriorityNormal, ErrBadPriority
+}
+
+func (p TorrentPriority) String() string {
+	if !p.Valid() {
+		return "invalid"
+	}
+	return priorityNames[p.class()]
+}
+
+// Valid returns true if the priority is high, normal or low
+func (p TorrentPriority) Valid() bool {
+	return p >= PriorityLow && p <= PriorityHigh
+}
+
+// index into things kept per priority, low first
+func (p TorrentPriority) class() int {
+	return int(p - PriorityLow)
+}
+
+// how big a share of the bandwidth limits a priority gets next to the others, each priority gets twice the one below it
+func priorityWeight(class int) int64 {
+	return 1 << uint(class)
+}
+
+// Priority gets how soon the torrent gets a turn in the active queue and how much of the bandwidth limits it gets
+func (t *Torrent) Priority() TorrentPriority {
+	return TorrentPriority(atomic.LoadInt32(&t.priority))
+}
+
+// SetPriority sets how soon the torrent gets a turn in the active queue and how much of the bandwidth limits it gets, kept across restarts
+func (t *Torrent) SetPriority(p TorrentPriority) error {
+	if !p.Valid() {
+		return ErrBadPriority
+	}
+	atomic.StoreInt32(&t.priority, int32(p))
+	if t.queue != nil {
+		t.queue.requeue(t)
+	}
+	return t.st.SetPriority(int(p))
+}
+
+// ErrNotQueued is returned when moving a torrent in the active queue that isn't waiting for a turn
+var ErrNotQueued = errors.New("torrent is not waiting for a turn")
+
+// Forced returns true if the torrent runs without waiting for a turn and doesn't count against the active torrents
+func (t *Torrent) Forced() bool {
+	return atomic.LoadInt32(&t.forced) == 1
+}
+
+// SetForced sets if the torrent runs without waiting for a turn and without counting against the active torrents, kept across restarts.
+// forcing a stopped torrent starts it.
+func (t *Torrent) SetForced(on bool) error {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&t.forced, v)
+	err := t.st.SetForced(on)
+	if err == nil && on && t.st.Paused() {
+		err = t.Start()
+	}
+	return err
+}
+
+// QueuePosition gets where the torrent waits for a turn to run, 1 goes next, 0 if it isn't waiting
+func (t *Torrent) QueuePosition() int {
+	if t.queue == nil {
+		return 0
+	}
+	return t.queue.position(t)
+}
+
+// MoveInQueue moves the torrent by places while it waits for a turn to run, negative moves it closer to the front
+func (t *Torrent) MoveInQueue(by int) error {
+	if t.queue == nil {
+		return ErrNotQueued
+	}
+	return t.queue.move(t, by)
+}
+
+// the torrents that may run at once and the ones waiting for a turn
+type activeQueue struct {
+	mtx sync.Mutex
+	// torrents that run or got a turn and are starting
+	slots map[*Torrent]bool
+	// torrents waiting for a turn in the order they get it
+	waiting []*Torrent
+}
+
+// wait for a turn behind the torrents of the same or higher priority
+// q.mtx must be held
+func (q *activeQueue) insert(t *Torrent) {
+	idx := len(q.waiting)
+	for idx > 0 && q.waiting[idx-1].Priority() < t.Priority() {
+		idx--
+	}
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[idx+1:], q.waiting[idx:])
+	q.waiting[idx] = t
+}
+
+// the waiting torrent that gets the next turn
+// q.mtx must be held
+func (q *activeQueue) next() *Torrent {
+	if len(q.waiting) == 0 {
+		return nil
+	}
+	return q.waiting[0]
+}
+
+// stop waiting for a turn, returns false if t wasn't waiting
+// q.mtx must be held
+func (q *activeQueue) leave(t *Torrent) bool {
+	for idx := range q.waiting {
+		if q.waiting[idx] == t {
+			q.waiting = append(q.waiting[:idx], q.waiting[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// how many torrents run that count against the limit, forced ones don't
+// q.mtx must be held
+func (q *activeQueue) running() (n int) {
+	for t := range q.slots {
+		if !t.Forced() {
+			n++
+		}
+	}
+	return
+}
+
+// give t a turn if it is forced or if fewer than size torrents run and no torrent waiting goes before it
+// q.mtx must be held
+func (q *activeQueue) take(t *Torrent, size int) bool {
+	if !t.Forced() && (q.running() >= size || q.next() != t) {
+		return false
+	}
+	q.leave(t)
+	q.run(t)
+	return true
+}
+
+// q.mtx must be held
+func (q *activeQueue) run(t *Torrent) {
+	if q.slots == nil {
+		q.slots = make(map[*Torrent]bool)
+	}
+	q.slots[t] = true
+}
+
+// block until t gets a turn to run with at most size torrents at once, returns false if t was closed while it waited
+// no limit if size is 0
+func (q *activeQueue) wait(t *Torrent, size int) bool {
+	if size <= 0 || t.Forced() {
+		return true
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.insert(t)
+	for !q.take(t, size) {
+		q.mtx.Unlock()
+		time.Sleep(time.Second)
+		q.mtx.Lock()
+		if t.closing {
+			q.leave(t)
+			return false
+		}
+	}
+	return true
+}
+
+// where t waits for a turn, 1 goes next, 0 if it isn't waiting
+func (q *activeQueue) position(t *Torrent) int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for idx := range q.waiting {
+		if q.waiting[idx] == t {
+			return idx + 1
+		}
+	}
+	return 0
+}
+
+// move t by places while it waits for a turn, it may go ahead of torrents of a higher priority
+func (q *activeQueue) move(t *Torrent, by int) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	idx := -1
+	for i := range q.waiting {
+		if q.waiting[i] == t {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return ErrNotQueued
+	}
+	to := idx + by
+	if by < -idx {
+		to = 0
+	} else if by > len(q.waiting)-1-idx {
+		to = len(q.waiting) - 1
+	}
+	q.leave(t)
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[to+1:], q.waiting[to:])
+	q.waiting[to] = t
+	return nil
+}
+
+// t changed priority, it waits behind the torrents of the same or higher priority again
+func (q *activeQueue) requeue(t *Torrent) {
+	q.mtx.Lock()
+	if q.leave(t) {
+		q.insert(t)
+	}
+	q.mtx.Unlock()
+}
+
+// t started running, with or without waiting for a turn
+func (q *activeQueue) started(t *Torrent) {
+	q.mtx.Lock()
+	q.run(t)
+	q.mtx.Unlock()
+}
+
+// t stopped running and frees its turn
+func (q *activeQueue) stopped(t *Torrent) {
+	q.mtx.Lock()
+	delete(q.slots, t)
+	q.mtx.Unlock()
+}
+
+// how many torrents run
+func (q *activeQueue) active() (n int) {
+	q.mtx.Lock()
+	n = len(q.slots)
+	q.mtx.Unlock()
+	return
+}
@@ -0,0 +1,142 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/storage"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []TorrentPriority{PriorityLow, PriorityNormal, PriorityHigh} {
+		parsed, err := ParsePriority(p.String())
+		if err != nil || parsed != p {
+			t.Fatalf("%s parsed as %s: %v", p, parsed, err)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err != ErrBadPriority {
+		t.Fatal("parsed a priority we don't have")
+	}
+}
+
+func TestActiveQueueOrder(t *testing.T) {
+	running := &Torrent{}
+	first := &Torrent{}
+	high := &Torrent{priority: int32(PriorityHigh)}
+	low := &Torrent{priority: int32(PriorityLow)}
+	var q activeQueue
+	q.started(running)
+	q.insert(low)
+	q.insert(first)
+	q.insert(high)
+	if q.take(high, 1) {
+		t.Fatal("got a turn while the queue is full")
+	}
+	q.stopped(running)
+	if q.take(first, 1) || q.take(low, 1) {
+		t.Fatal("went before a high priority torrent")
+	}
+	if !q.take(high, 1) {
+		t.Fatal("high priority torrent didn't get a turn")
+	}
+	q.stopped(high)
+	if q.take(low, 1) || !q.take(first, 1) {
+		t.Fatal("low priority torrent went before a normal one")
+	}
+	if q.active() != 1 || len(q.waiting) != 1 {
+		t.Fatalf("%d running and %d waiting", q.active(), len(q.waiting))
+	}
+}
+
+func TestActiveQueueMove(t *testing.T) {
+	a, b, c := &Torrent{}, &Torrent{}, &Torrent{}
+	high := &Torrent{priority: int32(PriorityHigh)}
+	var q activeQueue
+	q.insert(a)
+	q.insert(b)
+	q.insert(c)
+	q.insert(high)
+	if q.position(high) != 1 || q.position(a) != 2 || q.position(c) != 4 {
+		t.Fatal("high priority torrent isn't first")
+	}
+	if err := q.move(c, -10); err != nil {
+		t.Fatal(err)
+	}
+	if q.position(c) != 1 || q.position(high) != 2 {
+		t.Fatal("moved torrent isn't first")
+	}
+	if err := q.move(c, 2); err != nil {
+		t.Fatal(err)
+	}
+	if q.position(c) != 3 || q.position(a) != 2 || q.position(b) != 4 {
+		t.Fatal("moved torrent isn't third")
+	}
+	q.leave(a)
+	q.run(a)
+	if q.position(a) != 0 || q.move(a, 1) != ErrNotQueued {
+		t.Fatal("moved a running torrent")
+	}
+}
+
+func TestActiveQueueForced(t *testing.T) {
+	running := &Torrent{}
+	first := &Torrent{}
+	forced := &Torrent{forced: 1}
+	var q activeQueue
+	q.started(running)
+	q.insert(first)
+	q.insert(forced)
+	if q.take(first, 1) {
+		t.Fatal("got a turn while the queue is full")
+	}
+	if !q.take(forced, 1) {
+		t.Fatal("forced torrent waited for a turn")
+	}
+	q.stopped(running)
+	if !q.take(first, 1) {
+		t.Fatal("forced torrent counted against the active torrents")
+	}
+}
+
+// storage that only remembers if it is paused
+type pauseStorage struct {
+	storage.Torrent
+	paused bool
+}
+
+func (s *pauseStorage) SetPaused(paused bool) error {
+	s.paused = paused
+	return nil
+}
+
+func TestStartWaitsForTurn(t *testing.T) {
+	var q activeQueue
+	q.started(&Torrent{})
+	st := &pauseStorage{paused: true}
+	tr := &Torrent{st: st, queue: &q, closing: true}
+	tr.waitTurn = func() bool {
+		return q.wait(tr, 1)
+	}
+	if err := tr.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if st.paused {
+		t.Fatal("still paused after starting")
+	}
+	for tr.QueuePosition() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if tr.Start() != ErrAlreadyStarted {
+		t.Fatal("started twice while waiting for a turn")
+	}
+	// stopped while it waits
+	q.mtx.Lock()
+	tr.closing = true
+	q.mtx.Unlock()
+	for atomic.LoadInt32(&tr.starting) == 1 {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if tr.QueuePosition() != 0 || tr.started {
+		t.Fatal("stopped torrent kept waiting or started")
+	}
+}
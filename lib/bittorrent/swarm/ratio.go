@@ -0,0 +1,167 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/audit"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/util"
+	"sync/atomic"
+)
+
+// RatioAction is what a seeding torrent does when it reaches its share ratio limit
+type RatioAction string
+
+// RatioStop stops the torrent, starting it again keeps it going until the limit is raised
+const RatioStop = RatioAction("stop")
+
+// RatioRemove removes the torrent and keeps its data
+const RatioRemove = RatioAction("remove")
+
+// ErrBadRatioLimit is returned when setting a negative share ratio limit or an action that isn't stop or remove
+var ErrBadRatioLimit = errors.New("share ratio limit must not be negative and its action must be stop or remove")
+
+// ParseRatioAction reads a ratio action, empty is RatioStop
+func ParseRatioAction(s string) (RatioAction, error) {
+	switch RatioAction(s) {
+	case "", RatioStop:
+		return RatioStop, nil
+	case RatioRemove:
+		return RatioRemove, nil
+	}
+	return RatioStop, ErrBadRatioLimit
+}
+
+// RatioLimit is the share ratio a seeding torrent stops at and what it does then
+type RatioLimit struct {
+	// 0 never stops
+	Ratio  float64     `json:"ratio"`
+	Action RatioAction `json:"action"`
+}
+
+// Valid returns true if the limit isn't negative and the action is stop or remove
+func (l RatioLimit) Valid() bool {
+	_, err := ParseRatioAction(string(l.Action))
+	return l.Ratio >= 0 && err == nil
+}
+
+func (l RatioLimit) String() string {
+	if l.Ratio <= 0 {
+		return "none"
+	}
+	action, _ := ParseRatioAction(string(l.Action))
+	return fmt.Sprintf("%s at %.2f", action, l.Ratio)
+}
+
+// returns true if a torrent at share ratio r reached the limit
+func (l RatioLimit) reached(r float64) bool {
+	return l.Ratio > 0 && r >= l.Ratio
+}
+
+// Totals gets how many bytes of piece data we uploaded and downloaded for the torrent since we added it
+func (t *Torrent) Totals() (uploaded, downloaded uint64) {
+	return t.prevTX + t.tx, t.prevRX + t.rx
+}
+
+// write down how much we uploaded and downloaded
+func (t *Torrent) saveTotals() {
+	up, down := t.Totals()
+	t.st.SetTotals(up, down)
+}
+
+// ShareRatio gets how much we uploaded over how much we downloaded since we added the torrent
+// torrents we had the data of before we added them count what we have as downloaded
+func (t *Torrent) ShareRatio() float64 {
+	up, down := t.Totals()
+	if t.Ready() {
+		if have := t.st.DownloadedSize(); have > down {
+			down = have
+		}
+	}
+	return util.Ratio(float64(up), float64(down))
+}
+
+// RatioLimit gets the share ratio limit of the torrent, isDefault is true if it has none of its own
+func (t *Torrent) RatioLimit() (l RatioLimit, isDefault bool) {
+	t.connMtx.Lock()
+	defer t.connMtx.Unlock()
+	if t.ratioLimit != nil {
+		return *t.ratioLimit, false
+	}
+	return t.DefaultRatioLimit, true
+}
+
+// SetRatioLimit sets the share ratio limit of the torrent kept across restarts, nil goes back to the default
+func (t *Torrent) SetRatioLimit(l *RatioLimit) error {
+	var err error
+	if l == nil {
+		err = t.st.SetRatioLimit(-1, "")
+	} else {
+		if !l.Valid() {
+			return ErrBadRatioLimit
+		}
+		l.Action, _ = ParseRatioAction(string(l.Action))
+		err = t.st.SetRatioLimit(l.Ratio, string(l.Action))
+	}
+	if err != nil {
+		return err
+	}
+	t.connMtx.Lock()
+	t.ratioLimit = l
+	t.connMtx.Unlock()
+	// a new limit may let it seed on
+	atomic.StoreInt32(&t.ratioReached, 0)
+	return nil
+}
+
+// load the share ratio limit of the torrent from storage, nil if it has none of its own
+func loadRatioLimit(limit float64, action string) *RatioLimit {
+	if limit < 0 {
+		return nil
+	}
+	a, err := ParseRatioAction(action)
+	if err != nil {
+		return nil
+	}
+	return &RatioLimit{Ratio: limit, Action: a}
+}
+
+// stop or remove the torrent once it seeded to its share ratio limit, called every second by the rate ticker
+func (t *Torrent) checkRatio() {
+	if atomic.LoadInt32(&t.ratioReached) == 1 || !t.Done() {
+		return
+	}
+	l, _ := t.RatioLimit()
+	r := t.ShareRatio()
+	if !l.reached(r) || !atomic.CompareAndSwapInt32(&t.ratioReached, 0, 1) {
+		return
+	}
+	// the ticker goes away when we stop
+	go t.onRatioReached(l, r)
+}
+
+// act on the torrent reaching its share ratio limit l at share ratio r
+func (t *Torrent) onRatioReached(l RatioLimit, r float64) {
+	action, _ := ParseRatioAction(string(l.Action))
+	log.Infof("%s reached share ratio %.2f of %.2f, %s", t.Name(), r, l.Ratio, action)
+	e := audit.Event{
+		Action:   audit.ActionChanged,
+		Infohash: t.Infohash().Hex(),
+		Detail:   fmt.Sprintf("stopped at share ratio %.2f", r),
+	}
+	var err error
+	if action == RatioRemove {
+		e.Action = audit.ActionRemoved
+		e.Detail = fmt.Sprintf("share ratio %.2f reached", r)
+		err = t.Remove()
+	} else {
+		err = t.Stop()
+	}
+	if err != nil && err != ErrAlreadyStopped {
+		log.Warnf("%s: %s", t.Name(), err.Error())
+		return
+	}
+	if t.audit != nil {
+		t.audit(e)
+	}
+}
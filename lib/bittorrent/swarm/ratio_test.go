@@ -0,0 +1,55 @@
+package swarm
+
+import (
+	"testing"
+)
+
+func TestParseRatioAction(t *testing.T) {
+	for s, expected := range map[string]RatioAction{"": RatioStop, "stop": RatioStop, "remove": RatioRemove} {
+		a, err := ParseRatioAction(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != expected {
+			t.Fatalf("%q parsed as %s", s, a)
+		}
+	}
+	if _, err := ParseRatioAction("delete"); err != ErrBadRatioLimit {
+		t.Fatal("parsed a bad ratio action")
+	}
+}
+
+func TestRatioLimit(t *testing.T) {
+	if (RatioLimit{Ratio: -1}).Valid() {
+		t.Fatal("negative share ratio limit is valid")
+	}
+	if (RatioLimit{Ratio: 1, Action: "delete"}).Valid() {
+		t.Fatal("share ratio limit with a bad action is valid")
+	}
+	if (RatioLimit{}).reached(100) {
+		t.Fatal("no share ratio limit was reached")
+	}
+	l := RatioLimit{Ratio: 2}
+	if !l.Valid() {
+		t.Fatal("share ratio limit without an action is not valid")
+	}
+	if l.reached(1.99) || !l.reached(2) {
+		t.Fatal("share ratio limit reached at the wrong ratio")
+	}
+	if s := l.String(); s != "stop at 2.00" {
+		t.Fatalf("share ratio limit formatted as %q", s)
+	}
+}
+
+func TestLoadRatioLimit(t *testing.T) {
+	if loadRatioLimit(-1, "") != nil {
+		t.Fatal("loaded a share ratio limit that isn't set")
+	}
+	if loadRatioLimit(1, "delete") != nil {
+		t.Fatal("loaded a share ratio limit with a bad action")
+	}
+	l := loadRatioLimit(1.5, "remove")
+	if l == nil || l.Ratio != 1.5 || l.Action != RatioRemove {
+		t.Fatalf("loaded share ratio limit %v", l)
+	}
+}
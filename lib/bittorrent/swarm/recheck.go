@@ -0,0 +1,119 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRechecking is returned when rechecking a torrent that is being rechecked already
+var ErrRechecking = errors.New("torrent is being rechecked already")
+
+// Rechecking returns true while a recheck of the torrent runs
+func (t *Torrent) Rechecking() bool {
+	return atomic.LoadInt32(&t.rechecking) == 1
+}
+
+// StartRecheck runs Recheck in the background
+func (t *Torrent) StartRecheck() error {
+	if !t.Ready() {
+		return ErrNoMetaInfo
+	}
+	if !atomic.CompareAndSwapInt32(&t.rechecking, 0, 1) {
+		return ErrRechecking
+	}
+	go func() {
+		defer atomic.StoreInt32(&t.rechecking, 0)
+		if _, err := t.recheck(); err != nil {
+			log.Warnf("recheck of %s failed: %s", t.Name(), err.Error())
+		}
+	}()
+	return nil
+}
+
+// Recheck verifies all of the torrent's data and downloads the pieces that no longer check again before any others.
+// returns how many bytes of bad pieces we will download again
+func (t *Torrent) Recheck() (refetch uint64, err error) {
+	if !t.Ready() {
+		return 0, ErrNoMetaInfo
+	}
+	if !atomic.CompareAndSwapInt32(&t.rechecking, 0, 1) {
+		return 0, ErrRechecking
+	}
+	defer atomic.StoreInt32(&t.rechecking, 0)
+	return t.recheck()
+}
+
+func (t *Torrent) recheck() (refetch uint64, err error) {
+	release, ok := t.waitVerify(t.LastActive())
+	if !ok {
+		return 0, ErrAlreadyStopped
+	}
+	defer release()
+	before := t.st.Bitfield().Copy()
+	err = t.st.VerifyAll()
+	if err != nil {
+		return
+	}
+	t.markAllVerified(time.Now())
+	missing := t.st.Bitfield().Inverted()
+	// pieces an earlier recheck found bad that we have again are done
+	t.connMtx.Lock()
+	if t.refetch != nil {
+		t.refetch = t.refetch.AND(missing)
+		if t.refetch == nil || t.refetch.CountSet() == 0 {
+			t.refetch = nil
+		}
+	}
+	t.connMtx.Unlock()
+	bad := before.AND(missing)
+	if bad == nil || bad.CountSet() == 0 {
+		log.Infof("recheck of %s found no bad pieces", t.Name())
+		return
+	}
+	meta := t.MetaInfo()
+	bad.ForEachSet(func(idx uint32) {
+		refetch += uint64(meta.LengthOfPiece(idx))
+		t.pt.resetPiece(idx)
+		t.broadcastDontHave(idx)
+	})
+	log.Warnf("recheck of %s found %d bad pieces, downloading %d bytes again", t.Name(), bad.CountSet(), refetch)
+	t.connMtx.Lock()
+	if t.refetch != nil && t.refetch.Length == bad.Length {
+		bad.SelfOR(t.refetch)
+	}
+	t.refetch = bad
+	t.connMtx.Unlock()
+	t.seeding = false
+	t.VisitPeers(func(c *PeerConn) {
+		c.checkInterested()
+	})
+	return
+}
+
+// pick a piece the remote has that a recheck found bad, if there are any left to pick
+func (t *Torrent) pickRefetch(remote *bittorrent.Bitfield, excluded func(uint32) bool) (idx uint32, has bool) {
+	t.connMtx.Lock()
+	refetch := t.refetch
+	t.connMtx.Unlock()
+	if refetch == nil {
+		return
+	}
+	return remote.FindFirst(func(idx uint32) bool {
+		return !refetch.Has(idx) || excluded(idx)
+	})
+}
+
+// forget a piece we downloaded again after a recheck found it bad
+func (t *Torrent) refetched(idx uint32) {
+	t.connMtx.Lock()
+	if t.refetch != nil {
+		t.refetch.Unset(idx)
+		if t.refetch.CountSet() == 0 {
+			t.refetch = nil
+		}
+	}
+	t.connMtx.Unlock()
+}
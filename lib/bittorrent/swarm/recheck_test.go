@@ -0,0 +1,131 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/storage"
+	"testing"
+	"time"
+)
+
+// storage where a recheck finds the pieces in bad no longer check
+type recheckStorage struct {
+	storage.Torrent
+	bf   *bittorrent.Bitfield
+	meta *metainfo.TorrentFile
+	bad  []uint32
+}
+
+func (s *recheckStorage) Bitfield() *bittorrent.Bitfield {
+	return s.bf
+}
+
+func (s *recheckStorage) MetaInfo() *metainfo.TorrentFile {
+	return s.meta
+}
+
+func (s *recheckStorage) Infohash() (ih common.Infohash) {
+	return
+}
+
+func (s *recheckStorage) VerifyAll() error {
+	for _, idx := range s.bad {
+		s.bf.Unset(idx)
+	}
+	return nil
+}
+
+// a torrent of 4 pieces of 16 bytes that we have all of
+func recheckTestTorrent(bad ...uint32) *Torrent {
+	bf := bittorrent.NewBitfield(4, nil)
+	for idx := uint32(0); idx < 4; idx++ {
+		bf.Set(idx)
+	}
+	meta := &metainfo.TorrentFile{Info: metainfo.Info{
+		Path:        "test",
+		PieceLength: 16,
+		Length:      64,
+		Pieces:      make([]byte, 4*20),
+	}}
+	return &Torrent{
+		st:         &recheckStorage{bf: bf, meta: meta, bad: bad},
+		pt:         &pieceTracker{requests: make(map[uint32]*cachedPiece)},
+		ibconns:    make(map[string]*PeerConn),
+		obconns:    make(map[string]*PeerConn),
+		lastActive: time.Now().UnixNano(),
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	tr := recheckTestTorrent(1, 2)
+	refetch, err := tr.Recheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refetch != 32 {
+		t.Fatalf("recheck downloads %d bytes again not 32", refetch)
+	}
+	remote := bittorrent.NewBitfield(4, nil)
+	for idx := uint32(0); idx < 4; idx++ {
+		remote.Set(idx)
+	}
+	none := func(uint32) bool { return false }
+	if idx, has := tr.pickRefetch(remote, none); !has || idx != 1 {
+		t.Fatalf("picked %d, %v not the first bad piece", idx, has)
+	}
+	if idx, has := tr.pickRefetch(remote, func(idx uint32) bool { return idx == 1 }); !has || idx != 2 {
+		t.Fatalf("picked %d, %v not the bad piece that isn't excluded", idx, has)
+	}
+	tr.broadcastHave(1)
+	if idx, has := tr.pickRefetch(remote, none); !has || idx != 2 {
+		t.Fatalf("picked %d, %v after getting piece 1 again", idx, has)
+	}
+	tr.broadcastHave(2)
+	if _, has := tr.pickRefetch(remote, none); has || tr.refetch != nil {
+		t.Fatal("pieces we got again are still refetched")
+	}
+}
+
+func TestRecheckClearsRefetch(t *testing.T) {
+	tr := recheckTestTorrent()
+	tr.refetch = bittorrent.NewBitfield(4, nil)
+	tr.refetch.Set(3)
+	refetch, err := tr.Recheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refetch != 0 || tr.refetch != nil {
+		t.Fatal("recheck kept refetching a piece that checks")
+	}
+}
+
+func TestStartRecheck(t *testing.T) {
+	tr := &Torrent{st: &bitfieldStorage{bf: bittorrent.NewBitfield(4, nil)}}
+	if err := tr.StartRecheck(); err != ErrNoMetaInfo {
+		t.Fatalf("rechecking a torrent without metainfo gave %v", err)
+	}
+	tr = recheckTestTorrent()
+	tr.rechecking = 1
+	if err := tr.StartRecheck(); err != ErrRechecking {
+		t.Fatalf("second recheck gave %v", err)
+	}
+	if _, err := tr.Recheck(); err != ErrRechecking {
+		t.Fatalf("second recheck gave %v", err)
+	}
+	tr = recheckTestTorrent(0)
+	if err := tr.StartRecheck(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second * 5); tr.Rechecking(); {
+		if time.Now().After(deadline) {
+			t.Fatal("recheck never finished")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	tr.connMtx.Lock()
+	defer tr.connMtx.Unlock()
+	if tr.refetch == nil || !tr.refetch.Has(0) {
+		t.Fatal("background recheck didn't refetch the bad piece")
+	}
+}
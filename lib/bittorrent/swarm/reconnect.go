@@ -0,0 +1,81 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"net"
+	"sort"
+	"time"
+)
+
+// DefaultReconnectWindow is how long after we lost a peer we still try it again when we get the network back
+const DefaultReconnectWindow = time.Hour
+
+// a peer we dialed and were connected to before
+type pastPeer struct {
+	addr net.Addr
+	id   common.PeerID
+	// when the connection closed
+	lost time.Time
+}
+
+// remember an outbound peer that disconnected so we can dial it again after an outage
+// we keep as many as we may have peers, forgetting the ones we lost first
+func (t *Torrent) rememberPeer(addr net.Addr, id common.PeerID, now time.Time) {
+	t.connMtx.Lock()
+	defer t.connMtx.Unlock()
+	if t.pastPeers == nil {
+		t.pastPeers = make(map[string]pastPeer)
+	}
+	t.pastPeers[addr.String()] = pastPeer{addr: addr, id: id, lost: now}
+	max := int(t.MaxPeers)
+	if max <= 0 {
+		max = DefaultMaxSwarmPeers
+	}
+	for len(t.pastPeers) > max {
+		var oldest string
+		for k, p := range t.pastPeers {
+			if oldest == "" || p.lost.Before(t.pastPeers[oldest].lost) {
+				oldest = k
+			}
+		}
+		delete(t.pastPeers, oldest)
+	}
+}
+
+// get the peers we lost within the reconnect window before now, the ones we lost last first
+func (t *Torrent) recentPeers(now time.Time) (peers []pastPeer) {
+	t.connMtx.Lock()
+	for k, p := range t.pastPeers {
+		if now.Sub(p.lost) > DefaultReconnectWindow {
+			delete(t.pastPeers, k)
+			continue
+		}
+		peers = append(peers, p)
+	}
+	t.connMtx.Unlock()
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].lost.After(peers[j].lost)
+	})
+	return
+}
+
+// announce and dial the peers we had again right away after we got the network back
+func (t *Torrent) networkRecovered() {
+	if !t.started || t.closing {
+		return
+	}
+	t.addr = t.Network().Addr()
+	t.announceNow()
+	peers := t.recentPeers(time.Now())
+	log.Infof("network is back, reannouncing %s and dialing %d peers we had", t.Name(), len(peers))
+	for _, p := range peers {
+		if !t.NeedsPeers() {
+			return
+		}
+		if t.HasOBConn(p.addr) || t.HasIBConn(p.addr) {
+			continue
+		}
+		go t.PersistPeer(p.addr, p.id)
+	}
+}
@@ -0,0 +1,28 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRecentPeers(t *testing.T) {
+	tr := &Torrent{MaxPeers: 2}
+	now := time.Now()
+	for port := 1; port <= 3; port++ {
+		tr.rememberPeer(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}, common.PeerID{}, now.Add(time.Duration(port)*time.Minute))
+	}
+	peers := tr.recentPeers(now.Add(3 * time.Minute))
+	if len(peers) != 2 {
+		t.Fatalf("remembered %d peers, expected 2", len(peers))
+	}
+	if peers[0].addr.(*net.TCPAddr).Port != 3 || peers[1].addr.(*net.TCPAddr).Port != 2 {
+		t.Fatalf("peers not newest first: %v %v", peers[0].addr, peers[1].addr)
+	}
+	// peer 2 was lost too long ago to try again
+	peers = tr.recentPeers(now.Add(2*time.Minute + DefaultReconnectWindow + time.Second))
+	if len(peers) != 1 || peers[0].addr.(*net.TCPAddr).Port != 3 {
+		t.Fatalf("got %d peers past the reconnect window", len(peers))
+	}
+}
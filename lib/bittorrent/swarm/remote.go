@@ -0,0 +1,134 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/sync"
+	"github.com/majestrate/XD/lib/util"
+	"net"
+)
+
+// what we know about a remote destination across all of our torrents
+type remotePeer struct {
+	id common.PeerID
+	// client version from their extended handshake
+	client string
+	// connections to this remote by infohash
+	conns map[common.Infohash]*PeerConn
+}
+
+// tracks remote destinations that are connected to one or more of our torrents
+// so a remote connected for several torrents is routed and accounted per torrent
+type remotePeers struct {
+	access sync.Mutex
+	peers  map[string]*remotePeer
+	// dial outcomes and bad pieces by destination, kept after connections close
+	history map[string]*remoteHistory
+	// destinations banned for the rest of the session
+	bans map[string]bool
+}
+
+// returns true if we already have a connection with this remote for torrent ih
+func (r *remotePeers) Has(a net.Addr, ih common.Infohash) (has bool) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	p, ok := r.peers[a.String()]
+	if ok {
+		_, has = p.conns[ih]
+	}
+	r.access.Unlock()
+	return
+}
+
+// add a connection for its torrent, returns false if there already is one
+func (r *remotePeers) claim(c *PeerConn) bool {
+	if r == nil {
+		return true
+	}
+	addr := c.c.RemoteAddr().String()
+	ih := c.t.Infohash()
+	r.access.Lock()
+	defer r.access.Unlock()
+	if r.bans[addr] {
+		return false
+	}
+	if r.peers == nil {
+		r.peers = make(map[string]*remotePeer)
+	}
+	p, ok := r.peers[addr]
+	if !ok {
+		p = &remotePeer{
+			conns: make(map[common.Infohash]*PeerConn),
+		}
+		r.peers[addr] = p
+	}
+	if _, has := p.conns[ih]; has {
+		return false
+	}
+	p.conns[ih] = c
+	p.id = c.id
+	return true
+}
+
+// remove a connection, forgets the remote once it has no connections left
+func (r *remotePeers) release(c *PeerConn) {
+	if r == nil {
+		return
+	}
+	addr := c.c.RemoteAddr().String()
+	ih := c.t.Infohash()
+	r.access.Lock()
+	p, ok := r.peers[addr]
+	if ok && p.conns[ih] == c {
+		delete(p.conns, ih)
+		if len(p.conns) == 0 {
+			delete(r.peers, addr)
+		}
+	}
+	r.access.Unlock()
+}
+
+// remember the client version a remote gave us in its extended handshake
+func (r *remotePeers) setClient(a net.Addr, client string) {
+	client = util.CleanClientName(client)
+	if r == nil || client == "" {
+		return
+	}
+	r.access.Lock()
+	p, ok := r.peers[a.String()]
+	if ok {
+		p.client = client
+	}
+	r.access.Unlock()
+}
+
+// get the client name of a remote, prefers what they told us in their extended handshake
+func (r *remotePeers) ClientName(a net.Addr, id common.PeerID) (name string) {
+	if r != nil {
+		r.access.Lock()
+		p, ok := r.peers[a.String()]
+		if ok {
+			name = p.client
+		}
+		r.access.Unlock()
+	}
+	if name == "" {
+		name = util.ClientNameFromID(id[:])
+	}
+	return
+}
+
+// get how many of our torrents a remote is connected to
+func (r *remotePeers) NumTorrents(a net.Addr) (n int) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	p, ok := r.peers[a.String()]
+	if ok {
+		n = len(p.conns)
+	}
+	r.access.Unlock()
+	return
+}
@@ -0,0 +1,93 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"github.com/majestrate/XD/lib/network/inet"
+	"net"
+	"time"
+)
+
+// DefaultResumeGrace is how long after we start we keep peer slots free for the peers we had when we shut down
+const DefaultResumeGrace = time.Second * 30
+
+// remember the peers we dialed and are still connected to so we dial them first when we start again, called when shutting down
+func (t *Torrent) saveResumePeers() {
+	if !t.started {
+		return
+	}
+	var addrs []string
+	t.connMtx.Lock()
+	for addr := range t.obconns {
+		addrs = append(addrs, addr)
+	}
+	t.connMtx.Unlock()
+	err := t.st.SetResumePeers(addrs)
+	if err != nil {
+		log.Warnf("failed to remember peers of %s: %s", t.Name(), err)
+	}
+}
+
+// turn an address we wrote down back into one we can dial on n
+func resumeAddr(n network.Network, addr string) (net.Addr, error) {
+	if n.Addr().Network() == "i2p" {
+		return i2p.I2PAddr(addr), nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return inet.NewAddr(host, port), nil
+}
+
+// dial the peers we had when we last shut down before any others, called when we start
+// hints older than the reconnect window are ignored, the peers most likely moved on
+func (t *Torrent) resumePeers() {
+	addrs, at := t.st.ResumePeers()
+	if len(addrs) == 0 {
+		return
+	}
+	// only use them once
+	t.st.SetResumePeers(nil)
+	now := time.Now()
+	if now.Sub(at) > DefaultReconnectWindow {
+		return
+	}
+	n := t.Network()
+	var peers []net.Addr
+	for _, addr := range addrs {
+		a, err := resumeAddr(n, addr)
+		if err == nil {
+			peers = append(peers, a)
+		} else {
+			log.Debugf("bad resume peer %q: %s", addr, err)
+		}
+	}
+	t.connMtx.Lock()
+	t.resuming = peers
+	t.resumeUntil = now.Add(DefaultResumeGrace)
+	t.connMtx.Unlock()
+	log.Infof("dialing %d peers %s had before we restarted", len(peers), t.Name())
+	for _, a := range peers {
+		go t.PersistPeer(a, common.PeerID{})
+	}
+}
+
+// how many peer slots we keep free for the peers we had before we restarted that didn't connect yet
+func (t *Torrent) resumeReserved(now time.Time) (n uint) {
+	t.connMtx.Lock()
+	peers := t.resuming
+	if now.After(t.resumeUntil) {
+		peers = nil
+		t.resuming = nil
+	}
+	t.connMtx.Unlock()
+	for _, a := range peers {
+		if !t.HasOBConn(a) {
+			n++
+		}
+	}
+	return
+}
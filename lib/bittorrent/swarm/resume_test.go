@@ -0,0 +1,38 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/network/i2p"
+	"github.com/majestrate/XD/lib/network/loopback"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResumeAddr(t *testing.T) {
+	n := loopback.NewHub().NewSession("a")
+	want := i2p.RandomAddr()
+	a, err := resumeAddr(n, want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.String() != want.String() || a.Network() != "i2p" {
+		t.Fatalf("resumed %s as %s", want, a)
+	}
+}
+
+func TestResumeReserved(t *testing.T) {
+	a := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2}
+	now := time.Now()
+	tr := &Torrent{
+		obconns:     map[string]*PeerConn{a.String(): {}},
+		resuming:    []net.Addr{a, b},
+		resumeUntil: now.Add(DefaultResumeGrace),
+	}
+	if n := tr.resumeReserved(now); n != 1 {
+		t.Fatalf("%d slots reserved with one resumed peer left to connect", n)
+	}
+	if n := tr.resumeReserved(now.Add(DefaultResumeGrace + time.Second)); n != 0 {
+		t.Fatalf("%d slots still reserved after the grace window", n)
+	}
+}
@@ -0,0 +1,227 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/storage"
+	"net"
+	"time"
+)
+
+// RetryPolicy says how hard we try to connect to a peer we learned about
+type RetryPolicy struct {
+	// how many dials we make before giving up on a peer
+	Tries int
+	// how long we wait after the first failed dial, doubled for every failure the destination has had in a row
+	Backoff time.Duration
+	// the longest we wait between dials
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used when none is configured
+var DefaultRetryPolicy = RetryPolicy{
+	Tries:      10,
+	Backoff:    time.Second * 5,
+	MaxBackoff: time.Minute * 10,
+}
+
+// how long to wait before dialing a destination that failed fails times in a row
+func (p RetryPolicy) delay(fails int) time.Duration {
+	if fails <= 0 || p.Backoff <= 0 {
+		return 0
+	}
+	d := p.Backoff
+	for fails > 1 && (p.MaxBackoff <= 0 || d < p.MaxBackoff) {
+		d *= 2
+		fails--
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// forget history of destinations we haven't heard of in this long
+const remoteHistoryExpire = time.Hour
+
+// how many destinations we keep history for
+const maxRemoteHistory = 4096
+
+// how dealing with a destination has gone for us
+type remoteHistory struct {
+	// failures since the last successful dial
+	fails int
+	// all failures and successes we remember
+	totalFails int
+	successes  int
+	// pieces that failed verification that this destination sent blocks of
+	badPieces int
+	// pieces that failed verification we know it sent the bad blocks of
+	blamed int
+	// pieces it claimed to have and didn't deliver
+	brokenClaims int
+	lastSeen     time.Time
+	// torrents that dialed this destination, their settings keep its dial history across restarts
+	dialedFor map[common.Infohash]bool
+}
+
+// get the history of a destination, creating it if we have room, must hold access
+func (r *remotePeers) historyFor(addr string, now time.Time) *remoteHistory {
+	if r.history == nil {
+		r.history = make(map[string]*remoteHistory)
+	}
+	h, ok := r.history[addr]
+	if !ok {
+		if len(r.history) >= maxRemoteHistory {
+			r.expireHistory(now)
+		}
+		if len(r.history) >= maxRemoteHistory {
+			return nil
+		}
+		h = new(remoteHistory)
+		r.history[addr] = h
+	}
+	h.lastSeen = now
+	return h
+}
+
+// remember the outcome of torrent ih dialing a destination, returns how many times in a row it has failed
+func (r *remotePeers) dialed(a net.Addr, ih common.Infohash, err error) (fails int) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	defer r.access.Unlock()
+	h := r.historyFor(a.String(), time.Now())
+	if h == nil {
+		return 1
+	}
+	if h.dialedFor == nil {
+		h.dialedFor = make(map[common.Infohash]bool)
+	}
+	h.dialedFor[ih] = true
+	if err == nil {
+		h.fails = 0
+		h.successes++
+	} else {
+		h.fails++
+		h.totalFails++
+	}
+	return h.fails
+}
+
+// get how many times in a row dialing a destination has failed
+func (r *remotePeers) dialFailures(a net.Addr) (fails int) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	h, ok := r.history[a.String()]
+	if ok && time.Since(h.lastSeen) < remoteHistoryExpire {
+		fails = h.fails
+	}
+	r.access.Unlock()
+	return
+}
+
+// get the dial history of the destinations torrent ih dialed that we still remember
+func (r *remotePeers) dialHistory(ih common.Infohash, now time.Time) map[string]storage.DialHistory {
+	history := make(map[string]storage.DialHistory)
+	if r == nil {
+		return history
+	}
+	r.access.Lock()
+	for addr, h := range r.history {
+		if h.dialedFor[ih] && now.Sub(h.lastSeen) < remoteHistoryExpire {
+			history[addr] = storage.DialHistory{
+				Fails:      h.fails,
+				TotalFails: h.totalFails,
+				Successes:  h.successes,
+				LastSeen:   h.lastSeen,
+			}
+		}
+	}
+	r.access.Unlock()
+	return history
+}
+
+// put back dial history torrent ih kept across a restart, what we learned since wins
+func (r *remotePeers) restoreDialHistory(ih common.Infohash, history map[string]storage.DialHistory, now time.Time) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	for addr, kept := range history {
+		if now.Sub(kept.LastSeen) >= remoteHistoryExpire {
+			continue
+		}
+		if cur, ok := r.history[addr]; ok && !cur.lastSeen.Before(kept.LastSeen) {
+			continue
+		}
+		h := r.historyFor(addr, now)
+		if h == nil {
+			break
+		}
+		h.lastSeen = kept.LastSeen
+		h.fails = kept.Fails
+		h.totalFails = kept.TotalFails
+		h.successes = kept.Successes
+		if h.dialedFor == nil {
+			h.dialedFor = make(map[common.Infohash]bool)
+		}
+		h.dialedFor[ih] = true
+	}
+	r.access.Unlock()
+}
+
+// remember how dialing the destinations of the torrent went so a restart doesn't forget who keeps failing, called when shutting down
+func (t *Torrent) saveDialHistory() {
+	if !t.started {
+		return
+	}
+	err := t.st.SetDialHistory(t.remotes.dialHistory(t.Infohash(), time.Now()))
+	if err != nil {
+		log.Warnf("failed to remember dial history of %s: %s", t.Name(), err)
+	}
+}
+
+// load the dial history the torrent kept when we last shut down, called when we start
+func (t *Torrent) loadDialHistory() {
+	t.remotes.restoreDialHistory(t.Infohash(), t.st.DialHistory(), time.Now())
+}
+
+// count a piece that failed verification against a destination that sent blocks of it
+func (r *remotePeers) badPiece(addr string) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	h := r.historyFor(addr, time.Now())
+	if h != nil {
+		h.badPieces++
+	}
+	r.access.Unlock()
+}
+
+// get how many pieces that failed verification a destination sent us blocks of
+func (r *remotePeers) badPieces(a net.Addr) (n int) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	h, ok := r.history[a.String()]
+	if ok {
+		n = h.badPieces
+	}
+	r.access.Unlock()
+	return
+}
+
+// drop old history, must hold access
+func (r *remotePeers) expireHistory(now time.Time) {
+	for addr, h := range r.history {
+		if now.Sub(h.lastSeen) >= remoteHistoryExpire {
+			delete(r.history, addr)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Tries: 3, Backoff: time.Second, MaxBackoff: time.Second * 10}
+	for fails, d := range []time.Duration{0, time.Second, time.Second * 2, time.Second * 4, time.Second * 8, time.Second * 10, time.Second * 10} {
+		if p.delay(fails) != d {
+			t.Fatalf("delay after %d failures is %s, wanted %s", fails, p.delay(fails), d)
+		}
+	}
+}
+
+func TestDialHistory(t *testing.T) {
+	var r remotePeers
+	a := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 6881}
+	r.dialed(a, common.Infohash{}, errors.New("timeout"))
+	r.dialed(a, common.Infohash{}, errors.New("timeout"))
+	if r.dialFailures(a) != 2 {
+		t.Fatalf("%d failures", r.dialFailures(a))
+	}
+	r.dialed(a, common.Infohash{}, nil)
+	if r.dialFailures(a) != 0 {
+		t.Fatalf("%d failures after success", r.dialFailures(a))
+	}
+}
+
+func TestBadPieceHistory(t *testing.T) {
+	var r remotePeers
+	a := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 6881}
+	r.badPiece(a.String())
+	r.badPiece(a.String())
+	r.dialed(a, common.Infohash{}, nil)
+	if r.badPieces(a) != 2 {
+		t.Fatalf("%d bad pieces", r.badPieces(a))
+	}
+}
+
+func TestKeepDialHistory(t *testing.T) {
+	var r remotePeers
+	var ih, other common.Infohash
+	other[0] = 1
+	a := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 6881}
+	b := &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 6881}
+	r.dialed(a, ih, errors.New("timeout"))
+	r.dialed(a, ih, errors.New("timeout"))
+	r.dialed(b, other, errors.New("timeout"))
+	now := time.Now()
+	kept := r.dialHistory(ih, now)
+	if len(kept) != 1 || kept[a.String()].Fails != 2 || kept[a.String()].TotalFails != 2 {
+		t.Fatalf("kept %v", kept)
+	}
+	// a restart
+	var restarted remotePeers
+	restarted.restoreDialHistory(ih, kept, now)
+	if restarted.dialFailures(a) != 2 || restarted.dialFailures(b) != 0 {
+		t.Fatalf("%d failures after restart", restarted.dialFailures(a))
+	}
+	restarted.dialed(a, ih, nil)
+	restarted.restoreDialHistory(ih, kept, now)
+	if restarted.dialFailures(a) != 0 {
+		t.Fatal("old history overwrote what we learned since")
+	}
+	var late remotePeers
+	late.restoreDialHistory(ih, kept, now.Add(remoteHistoryExpire))
+	if late.dialFailures(a) != 0 {
+		t.Fatal("restored expired history")
+	}
+}
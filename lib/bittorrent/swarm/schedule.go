@@ -0,0 +1,177 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrBadSchedule is returned when a bandwidth schedule can't be parsed
+var ErrBadSchedule = errors.New("bad bandwidth schedule")
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleRule is a time of day on some days of the week
+type ScheduleRule struct {
+	// bit n set for time.Weekday(n)
+	Days uint8
+	// minutes since midnight, a rule that ends before it starts runs past midnight into the next day and one that ends when it starts runs a whole day
+	Start, End int
+}
+
+// BandwidthSchedule is when the alternative bandwidth limits apply
+type BandwidthSchedule []ScheduleRule
+
+const everyDay = uint8(0x7f)
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for idx, name := range weekdayNames {
+		if s == name {
+			return time.Weekday(idx), true
+		}
+	}
+	return 0, false
+}
+
+// parse days like "mon-fri", "sat", "fri-mon" or "daily"
+func parseDays(s string) (days uint8, err error) {
+	if s == "daily" {
+		return everyDay, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	first, ok := parseWeekday(parts[0])
+	if !ok {
+		return 0, fmt.Errorf("%w: no such day %q", ErrBadSchedule, parts[0])
+	}
+	last := first
+	if len(parts) == 2 {
+		last, ok = parseWeekday(parts[1])
+		if !ok {
+			return 0, fmt.Errorf("%w: no such day %q", ErrBadSchedule, parts[1])
+		}
+	}
+	for d := first; ; d = (d + 1) % 7 {
+		days |= 1 << uint(d)
+		if d == last {
+			break
+		}
+	}
+	return
+}
+
+// parse a time of day like "08:30" into minutes since midnight
+func parseTimeOfDay(s string) (int, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("%w: bad time %q", ErrBadSchedule, s)
+	}
+	return (h*60 + m) % (24 * 60), nil
+}
+
+// ParseSchedule parses rules like "mon-fri 08:00-18:00, sat-sun 22:00-06:00" separated by commas, a rule without days runs daily
+func ParseSchedule(str string) (sched BandwidthSchedule, err error) {
+	for _, part := range strings.Split(str, ",") {
+		fields := strings.Fields(strings.ToLower(part))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%w: %q", ErrBadSchedule, strings.TrimSpace(part))
+		}
+		r := ScheduleRule{Days: everyDay}
+		if len(fields) == 2 {
+			r.Days, err = parseDays(fields[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		span := strings.SplitN(fields[len(fields)-1], "-", 2)
+		if len(span) != 2 {
+			return nil, fmt.Errorf("%w: %q is not a time range", ErrBadSchedule, fields[len(fields)-1])
+		}
+		if r.Start, err = parseTimeOfDay(span[0]); err != nil {
+			return nil, err
+		}
+		if r.End, err = parseTimeOfDay(span[1]); err != nil {
+			return nil, err
+		}
+		sched = append(sched, r)
+	}
+	return
+}
+
+// format days as one range like "mon-fri", false if they aren't one range
+func formatDays(days uint8) (string, bool) {
+	if days == everyDay {
+		return "daily", true
+	}
+	for first := 0; first < 7; first++ {
+		if days&(1<<uint(first)) == 0 || days&(1<<uint((first+6)%7)) != 0 {
+			continue
+		}
+		// first day of a run, it's the only run if every day we have is in it
+		last := first
+		run := uint8(1 << uint(first))
+		for days&(1<<uint((last+1)%7)) != 0 {
+			last = (last + 1) % 7
+			run |= 1 << uint(last)
+		}
+		if run != days {
+			return "", false
+		}
+		if last == first {
+			return weekdayNames[first], true
+		}
+		return weekdayNames[first] + "-" + weekdayNames[last], true
+	}
+	return "", false
+}
+
+func (r ScheduleRule) String() string {
+	span := fmt.Sprintf("%02d:%02d-%02d:%02d", r.Start/60, r.Start%60, r.End/60, r.End%60)
+	if r.Days == everyDay {
+		return span
+	}
+	if days, ok := formatDays(r.Days); ok {
+		return days + " " + span
+	}
+	// days that aren't one range need a rule each
+	var rules []string
+	for d := 0; d < 7; d++ {
+		if r.Days&(1<<uint(d)) != 0 {
+			rules = append(rules, ScheduleRule{Days: 1 << uint(d), Start: r.Start, End: r.End}.String())
+		}
+	}
+	return strings.Join(rules, ", ")
+}
+
+// String formats the schedule the way ParseSchedule reads it
+func (sched BandwidthSchedule) String() string {
+	var rules []string
+	for _, r := range sched {
+		rules = append(rules, r.String())
+	}
+	return strings.Join(rules, ", ")
+}
+
+func (r ScheduleRule) on(d time.Weekday) bool {
+	return r.Days&(1<<uint(d)) != 0
+}
+
+// Active returns true if the time falls within a rule of the schedule
+func (sched BandwidthSchedule) Active(now time.Time) bool {
+	m := now.Hour()*60 + now.Minute()
+	d := now.Weekday()
+	yesterday := (d + 6) % 7
+	for _, r := range sched {
+		if r.Start < r.End {
+			if r.on(d) && m >= r.Start && m < r.End {
+				return true
+			}
+		} else if (r.on(d) && m >= r.Start) || (r.on(yesterday) && m < r.End) {
+			return true
+		}
+	}
+	return false
+}
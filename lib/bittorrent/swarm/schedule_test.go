@@ -0,0 +1,130 @@
+package swarm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	sched, err := ParseSchedule("Mon-Fri 08:00-18:30, sat-sun 22:00-06:00, 12:00-13:00, fri-mon 00:00-00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str := sched.String(); str != "mon-fri 08:00-18:30, sat-sun 22:00-06:00, 12:00-13:00, fri-mon 00:00-00:00" {
+		t.Fatalf("schedule formats as %q", str)
+	}
+	if again, err := ParseSchedule(sched.String()); err != nil || again.String() != sched.String() {
+		t.Fatal("formatted schedule doesn't parse back the same")
+	}
+	for _, bad := range []string{"mon-fri", "someday 08:00-09:00", "25:00-26:00", "mon 08:00", "mon tue 08:00-09:00"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+	if sched, err := ParseSchedule(""); err != nil || len(sched) != 0 {
+		t.Fatal("empty schedule is not empty")
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	sched, err := ParseSchedule("mon-fri 08:00-18:00, sat 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-01-01 is a monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.Local)
+	}
+	for _, c := range []struct {
+		tm     time.Time
+		active bool
+	}{
+		{at(1, 8, 0), true},
+		{at(1, 7, 59), false},
+		{at(5, 17, 59), true},
+		{at(1, 18, 0), false},
+		{at(6, 12, 0), false},
+		{at(6, 23, 0), true},
+		// sunday morning, after saturday night
+		{at(7, 5, 59), true},
+		{at(7, 6, 0), false},
+		{at(7, 23, 0), false},
+		// monday morning is not after saturday
+		{at(8, 5, 0), false},
+	} {
+		if sched.Active(c.tm) != c.active {
+			t.Errorf("%s active is not %v", c.tm, c.active)
+		}
+	}
+}
+
+func TestBandwidthSwitches(t *testing.T) {
+	bw := NewBandwidth()
+	up := &bw.up[PriorityNormal.class()]
+	if d := up.take(1<<20, time.Now()); d != 0 {
+		t.Fatal("unlimited bandwidth made us wait")
+	}
+	err := bw.Configure(BandwidthSettings{
+		Normal:  BandwidthLimits{Upload: 1000},
+		Alt:     BandwidthLimits{Upload: 100},
+		AltMode: AltOn,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bw.Settings().AltActive || up.rate != 100 {
+		t.Fatal("alternative limits don't apply when turned on")
+	}
+	// it saves up a second of bandwidth at most
+	now := time.Now().Add(time.Minute)
+	if d := up.take(50, now); d != 0 {
+		t.Fatal("waited while under the limit")
+	}
+	if d := up.take(100, now); d != time.Second/2 {
+		t.Fatalf("waiting %s for 50 bytes over a 100 byte a second limit", d)
+	}
+	if d := up.take(50, now.Add(time.Second)); d != 0 {
+		t.Fatalf("waiting %s after the limit refilled", d)
+	}
+	if err := bw.Configure(BandwidthSettings{Normal: BandwidthLimits{Upload: 1000}, Schedule: "00:00-00:00", AltMode: AltOff}); err != nil {
+		t.Fatal(err)
+	}
+	if bw.Settings().AltActive || up.rate != 1000 {
+		t.Fatal("alternative limits apply when turned off")
+	}
+	if bw.Configure(BandwidthSettings{AltMode: "sometimes"}) == nil {
+		t.Fatal("took a bad mode")
+	}
+}
+
+func TestBandwidthShare(t *testing.T) {
+	bw := NewBandwidth()
+	err := bw.Configure(BandwidthSettings{Normal: BandwidthLimits{Upload: 700}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	low, normal, high := &bw.up[PriorityLow.class()], &bw.up[PriorityNormal.class()], &bw.up[PriorityHigh.class()]
+	if low.rate != 700 || normal.rate != 700 || high.rate != 700 {
+		t.Fatalf("idle priorities don't each get the whole limit: %d %d %d", low.rate, normal.rate, high.rate)
+	}
+	low.take(1, now)
+	normal.take(1, now)
+	bw.tick(now)
+	if low.rate != 700/3 || normal.rate != 700*2/3 {
+		t.Fatalf("low and normal got %d and %d of 700", low.rate, normal.rate)
+	}
+	// high gets its share as soon as it starts
+	if high.rate != 700*4/7 {
+		t.Fatalf("high would start with %d of 700", high.rate)
+	}
+	high.take(1, now)
+	bw.tick(now)
+	if low.rate != 100 || normal.rate != 200 || high.rate != 400 {
+		t.Fatalf("shares are %d %d %d", low.rate, normal.rate, high.rate)
+	}
+	bw.tick(now.Add(2 * time.Second))
+	if low.rate != 700 {
+		t.Fatalf("idle priority still limited to %d", low.rate)
+	}
+}
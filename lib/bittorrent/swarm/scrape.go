@@ -0,0 +1,90 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/tracker"
+	"time"
+)
+
+// ScrapeInterval is how often we scrape trackers for the stats of every torrent we have
+const ScrapeInterval = time.Minute * 30
+
+// how long we wait before the first scrape so the network can come up
+const scrapeStartDelay = time.Minute
+
+// what a tracker's scrape said about a torrent
+type scrapeResult struct {
+	stats tracker.ScrapeStats
+	at    time.Time
+}
+
+// remember what a tracker's scrape said about this torrent
+func (t *Torrent) putScrape(name string, st tracker.ScrapeStats) {
+	t.announceMtx.Lock()
+	if t.scrapes == nil {
+		t.scrapes = make(map[string]scrapeResult)
+	}
+	t.scrapes[name] = scrapeResult{
+		stats: st,
+		at:    time.Now(),
+	}
+	t.announceMtx.Unlock()
+}
+
+// scrape every tracker for all of the torrents it has, even stopped ones, so we know how healthy swarms are without joining them
+func (sw *Swarm) runScraper() {
+	time.Sleep(scrapeStartDelay)
+	for sw.Running() {
+		if !sw.Struggling() {
+			sw.scrapeAll()
+		}
+		time.Sleep(ScrapeInterval)
+	}
+}
+
+// scrape each tracker once for all the torrents that use it
+func (sw *Swarm) scrapeAll() {
+	type scrapeJob struct {
+		tr         tracker.Announcer
+		infohashes []common.Infohash
+		torrents   map[common.Infohash]*Torrent
+	}
+	jobs := make(map[string]*scrapeJob)
+	sw.Torrents.ForEachTorrent(func(t *Torrent) {
+		ih := t.Infohash()
+		t.announceMtx.Lock()
+		for name, tr := range t.Trackers {
+			job, ok := jobs[name]
+			if !ok {
+				job = &scrapeJob{
+					tr:       tr,
+					torrents: make(map[common.Infohash]*Torrent),
+				}
+				jobs[name] = job
+			}
+			job.infohashes = append(job.infohashes, ih)
+			job.torrents[ih] = t
+		}
+		t.announceMtx.Unlock()
+	})
+	for name, job := range jobs {
+		resp, err := job.tr.Scrape(&tracker.ScrapeRequest{
+			Infohashes: job.infohashes,
+			GetNetwork: sw.Network,
+		})
+		if err == tracker.ErrScrapeNotSupported {
+			log.Debugf("%s does not support scrape", name)
+			continue
+		}
+		if err != nil {
+			log.Warnf("failed to scrape %s: %s", name, err.Error())
+			continue
+		}
+		for ih, st := range resp.Files {
+			if t, ok := job.torrents[ih]; ok {
+				t.putScrape(name, st)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/tracker"
+	"testing"
+)
+
+// announcer that only has a name
+type namedAnnouncer string
+
+func (a namedAnnouncer) Announce(req *tracker.Request) (*tracker.Response, error) {
+	return nil, tracker.ErrTrackerBlocked
+}
+
+func (a namedAnnouncer) Scrape(req *tracker.ScrapeRequest) (*tracker.ScrapeResponse, error) {
+	return nil, tracker.ErrScrapeNotSupported
+}
+
+func (a namedAnnouncer) Name() string {
+	return string(a)
+}
+
+func TestTrackerStatusScrape(t *testing.T) {
+	tor := &Torrent{
+		Trackers: map[string]tracker.Announcer{
+			"announced": namedAnnouncer("announced"),
+			"scraped":   namedAnnouncer("scraped"),
+			"unknown":   namedAnnouncer("unknown"),
+		},
+		announcers: make(map[string]*torrentAnnounce),
+	}
+	tor.announcers["announced"] = &torrentAnnounce{
+		announce: tor.Trackers["announced"],
+		last:     &tracker.Response{Complete: 4, Incomplete: 2},
+	}
+	tor.putScrape("announced", tracker.ScrapeStats{Complete: 5, Downloaded: 10, Incomplete: 1})
+	tor.putScrape("scraped", tracker.ScrapeStats{Complete: 3, Downloaded: 7, Incomplete: 6})
+	status := make(map[string]TrackerStatus)
+	for _, st := range tor.trackerStatus() {
+		status[st.Name] = st
+	}
+	if len(status) != 3 {
+		t.Fatalf("got status for %d trackers", len(status))
+	}
+	if st := status["announced"]; st.Seeders != 4 || st.Leechers != 2 || st.Completed != 10 {
+		t.Fatalf("announced tracker status %+v", st)
+	}
+	if st := status["scraped"]; st.Seeders != 3 || st.Leechers != 6 || st.Completed != 7 || st.LastScrape == 0 {
+		t.Fatalf("scraped tracker status %+v", st)
+	}
+	if st := status["unknown"]; st.Seeders != -1 || st.Leechers != -1 || st.Completed != -1 {
+		t.Fatalf("unknown tracker status %+v", st)
+	}
+}
@@ -0,0 +1,74 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"time"
+)
+
+// remember that we checked the data of a piece against its hash at now
+func (t *Torrent) markVerified(idx uint32, now time.Time) {
+	t.verifiedMtx.Lock()
+	if t.verifiedAt == nil {
+		t.verifiedAt = make(map[uint32]time.Time)
+	}
+	t.verifiedAt[idx] = now
+	t.verifiedMtx.Unlock()
+}
+
+// remember that we checked all of our data against its hashes at now
+func (t *Torrent) markAllVerified(now time.Time) {
+	t.verifiedMtx.Lock()
+	t.allVerifiedAt = now
+	t.verifiedAt = nil
+	t.verifiedMtx.Unlock()
+}
+
+// returns true if we checked the data of a piece against its hash within the last VerifyUploads before now
+func (t *Torrent) verifiedRecently(idx uint32, now time.Time) bool {
+	t.verifiedMtx.Lock()
+	at, ok := t.verifiedAt[idx]
+	if !ok || t.allVerifiedAt.After(at) {
+		at = t.allVerifiedAt
+	}
+	t.verifiedMtx.Unlock()
+	return now.Sub(at) < t.VerifyUploads
+}
+
+// check the data of a piece a peer asked for against its hash if we didn't lately, returns false if it is corrupt and must not be served
+// a corrupt piece is dropped and downloaded again
+func (t *Torrent) checkUpload(idx uint32) bool {
+	now := time.Now()
+	if t.VerifyUploads <= 0 || t.verifiedRecently(idx, now) {
+		return true
+	}
+	meta := t.MetaInfo()
+	pc := common.PieceData{Index: idx}
+	err := t.st.GetPiece(common.PieceRequest{Index: idx, Length: meta.LengthOfPiece(idx)}, &pc)
+	if err == nil && meta.CheckPiece(&pc) {
+		t.markVerified(idx, now)
+		return true
+	}
+	log.Errorf("piece %d of %s no longer matches its hash, not serving it", idx, t.Name())
+	t.dropPiece(idx)
+	return false
+}
+
+// forget a piece we had whose data went bad, tell our peers and download it again before any others
+func (t *Torrent) dropPiece(idx uint32) {
+	// verifying it again takes it out of our bitfield
+	t.st.VerifyPiece(idx)
+	t.pt.resetPiece(idx)
+	t.broadcastDontHave(idx)
+	t.connMtx.Lock()
+	if t.refetch == nil {
+		t.refetch = bittorrent.NewBitfield(t.MetaInfo().Info.NumPieces(), nil)
+	}
+	t.refetch.Set(idx)
+	t.connMtx.Unlock()
+	t.seeding = false
+	t.VisitPeers(func(c *PeerConn) {
+		c.checkInterested()
+	})
+}
@@ -0,0 +1,25 @@
+package swarm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifiedRecently(t *testing.T) {
+	tr := &Torrent{VerifyUploads: time.Minute}
+	now := time.Now()
+	if tr.verifiedRecently(1, now) {
+		t.Fatal("piece we never checked counts as checked")
+	}
+	tr.markVerified(1, now)
+	if !tr.verifiedRecently(1, now.Add(time.Second)) || tr.verifiedRecently(2, now) {
+		t.Fatal("checking one piece didn't count for just that piece")
+	}
+	if tr.verifiedRecently(1, now.Add(time.Minute)) {
+		t.Fatal("check didn't expire")
+	}
+	tr.markAllVerified(now.Add(time.Minute))
+	if !tr.verifiedRecently(2, now.Add(time.Minute+time.Second)) {
+		t.Fatal("checking every piece didn't count")
+	}
+}
@@ -0,0 +1,44 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSnubTimeout is how long a peer may sit on our requests without sending a block before we call it snubbed
+const DefaultSnubTimeout = time.Minute
+
+// Snubbed returns true if the peer stopped sending the blocks we ask it for
+// snubbed peers get one request at a time until they send a block again
+func (c *PeerConn) Snubbed() bool {
+	return atomic.LoadInt32(&c.snubbed) == 1
+}
+
+// snub the peer if it sat on our requests for too long and give its requests to other peers, only called by tickDownload
+func (c *PeerConn) checkSnubbed(now time.Time) {
+	timeout := c.t.SnubTimeout
+	if timeout <= 0 {
+		return
+	}
+	c.access.Lock()
+	stuck := len(c.downloading) > 0 && now.Sub(c.waitingSince) > timeout
+	c.access.Unlock()
+	if !stuck {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&c.snubbed, 0, 1) {
+		log.Infof("%s sent nothing we asked for in %s, snubbing it", c.id.String(), timeout)
+	}
+	c.cancelPendingDownloads()
+	// let other peers take the blocks before we ask it again
+	c.nextPieceRequest = now.Add(timeout / 4)
+}
+
+// the peer sent a block we asked for, call with access held
+func (c *PeerConn) gotBlock(now time.Time) {
+	c.waitingSince = now
+	if atomic.CompareAndSwapInt32(&c.snubbed, 1, 0) {
+		log.Debugf("%s is sending blocks again, no longer snubbed", c.id.String())
+	}
+}
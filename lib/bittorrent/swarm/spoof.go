@@ -0,0 +1,106 @@
+package swarm
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+	"time"
+)
+
+// MaxBrokenClaims is how many times a peer may claim pieces it then doesn't deliver before we stop counting what it says it has
+// a peer claiming everything would otherwise make every piece look common and steer rarest first away from the pieces that are really rare
+const MaxBrokenClaims = 5
+
+// check the spare bits at the end of a bitfield a peer sent us are clear, a peer setting them claims pieces past the end of the torrent
+func checkSpareBits(payload []byte, n uint32) error {
+	if spare := n % 8; spare > 0 && len(payload) > 0 {
+		if payload[len(payload)-1]&(0xff>>spare) != 0 {
+			return fmt.Errorf("%w: bitfield has pieces past the last of %d", ErrBadMessage, n)
+		}
+	}
+	return nil
+}
+
+// the availability the peer's bitfield counts in, nil once we stopped trusting what it claims or it closed, call with bfMtx held
+func (c *PeerConn) availability() *bittorrent.Availability {
+	if c.uncounted || atomic.LoadInt32(&c.distrusted) == 1 {
+		return nil
+	}
+	return c.t.availability()
+}
+
+// the peer lied about having a piece: it rejected our request for it while unchoking us with room in its queue
+// or took the piece back in a new bitfield, being slow or sending bad data is no lie
+func (c *PeerConn) brokeClaim(why string) {
+	n := atomic.AddUint32(&c.brokenClaims, 1)
+	c.t.remotes.brokeClaim(c)
+	log.Debugf("%s broke its claim to have a piece: %s, %d broken claims", c.id.String(), why, n)
+}
+
+// stop counting what the peer claims once it broke too many claims, only called by the reader
+func (c *PeerConn) checkClaims() {
+	if atomic.LoadUint32(&c.brokenClaims) < MaxBrokenClaims || atomic.LoadInt32(&c.distrusted) == 1 {
+		return
+	}
+	log.Warnf("%s claimed %d pieces it didn't deliver, no longer counting its pieces", c.id.String(), MaxBrokenClaims)
+	c.bfMtx.Lock()
+	if avail := c.availability(); avail != nil && c.bf != nil {
+		avail.Remove(c.bf)
+	}
+	atomic.StoreInt32(&c.distrusted, 1)
+	c.bfMtx.Unlock()
+}
+
+// take the peer's pieces out of the torrent's availability for good, called when it closes
+func (c *PeerConn) uncount() {
+	c.bfMtx.Lock()
+	if avail := c.availability(); avail != nil && c.bf != nil {
+		avail.Remove(c.bf)
+	}
+	c.uncounted = true
+	c.bfMtx.Unlock()
+}
+
+// returns true if we have fewer requests outstanding with the peer than it said it queues, false if it didn't say
+func (c *PeerConn) underReqQ() bool {
+	if c.theirOpts.ReqQ == nil {
+		return false
+	}
+	c.access.Lock()
+	n := len(c.downloading)
+	c.access.Unlock()
+	return n < int(*c.theirOpts.ReqQ)
+}
+
+// Distrusted returns true if the peer broke too many claims to have pieces for us to count what it has
+func (c *PeerConn) Distrusted() bool {
+	return atomic.LoadInt32(&c.distrusted) == 1
+}
+
+// count a claim the destination of c broke
+func (r *remotePeers) brokeClaim(c *PeerConn) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	h := r.historyFor(c.c.RemoteAddr().String(), time.Now())
+	if h != nil {
+		h.brokenClaims++
+	}
+	r.access.Unlock()
+}
+
+// returns true if a destination broke so many claims on earlier connections that we don't count what it claims from the start
+func (r *remotePeers) distrusts(addr string) (distrust bool) {
+	if r == nil {
+		return
+	}
+	r.access.Lock()
+	h, ok := r.history[addr]
+	if ok && time.Since(h.lastSeen) < remoteHistoryExpire {
+		distrust = h.brokenClaims >= MaxBrokenClaims
+	}
+	r.access.Unlock()
+	return
+}
@@ -0,0 +1,74 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"testing"
+)
+
+func TestCheckSpareBits(t *testing.T) {
+	if err := checkSpareBits([]byte{0xff, 0xe0}, 11); err != nil {
+		t.Fatalf("refused a bitfield of 11 pieces: %s", err)
+	}
+	if checkSpareBits([]byte{0xff, 0xf0}, 11) == nil {
+		t.Fatal("took a bitfield claiming a 12th piece of 11")
+	}
+	if err := checkSpareBits([]byte{0xff}, 8); err != nil {
+		t.Fatalf("refused a bitfield without spare bits: %s", err)
+	}
+}
+
+func TestCheckClaims(t *testing.T) {
+	avail := bittorrent.NewAvailability(8)
+	c := &PeerConn{
+		t:  &Torrent{avail: avail},
+		bf: bittorrent.NewBitfield(8, []byte{0xff}),
+	}
+	avail.Add(c.bf)
+	c.brokenClaims = MaxBrokenClaims - 1
+	c.checkClaims()
+	if c.Distrusted() || c.availability() == nil {
+		t.Fatal("distrusted a peer before it broke too many claims")
+	}
+	c.brokenClaims = MaxBrokenClaims
+	c.checkClaims()
+	if !c.Distrusted() || c.availability() != nil {
+		t.Fatal("still trusting a peer that broke too many claims")
+	}
+	if n := avail.Count(0); n != 0 {
+		t.Fatalf("pieces of a distrusted peer still count %d times", n)
+	}
+}
+
+func TestClaimsOfClosedPeer(t *testing.T) {
+	avail := bittorrent.NewAvailability(8)
+	c := &PeerConn{
+		t:  &Torrent{avail: avail},
+		bf: bittorrent.NewBitfield(8, []byte{0xff}),
+	}
+	avail.Add(c.bf)
+	c.uncount()
+	// closing and distrusting it at once takes its pieces out once
+	c.brokenClaims = MaxBrokenClaims
+	c.checkClaims()
+	if n := avail.Count(0); n != 0 {
+		t.Fatalf("pieces of a closed peer count %d times", n)
+	}
+}
+
+func TestUnderReqQ(t *testing.T) {
+	c := &PeerConn{}
+	if c.underReqQ() {
+		t.Fatal("peer that didn't say how many requests it queues has room")
+	}
+	reqq := uint32(2)
+	c.theirOpts.ReqQ = &reqq
+	c.downloading = []*common.PieceRequest{{Index: 1}}
+	if !c.underReqQ() {
+		t.Fatal("peer with one of two requests has no room")
+	}
+	c.downloading = append(c.downloading, &common.PieceRequest{Index: 2})
+	if c.underReqQ() {
+		t.Fatal("peer with a full queue rejecting a request broke a claim")
+	}
+}
@@ -1,15 +1,23 @@
 package swarm
 
 import (
+	"errors"
 	"fmt"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/unpack"
 	"github.com/majestrate/XD/lib/util"
+	"sort"
+	"time"
 )
 
 type TorrentFileInfo struct {
 	FileInfo metainfo.FileInfo
 	Progress float64
+	// we don't download the file
+	Skipped bool
+	// how soon we download the pieces of the file next to the other files
+	Priority string
 }
 
 func (i TorrentFileInfo) Length() int64 {
@@ -71,6 +79,22 @@ type PeerConnStats struct {
 	Inbound        bool
 	Uploading      bool
 	Bitfield       bittorrent.Bitfield
+	Extensions     []string
+	// pieces this peer sent us that failed verification, for this torrent and for all of our torrents
+	BadPieces      uint32
+	BadPiecesTotal int
+	// requests we have outstanding with this peer and the most we keep, sized from its rate and rtt and bounded by the reqq it sent
+	Requests    int
+	MaxRequests int
+	// milliseconds the peer takes to answer our requests, 0 until it answered one
+	RTT int64
+	// it sat on our requests for longer than the snub timeout and gets one at a time
+	Snubbed bool
+	// requests it made that wait for their turn to be served
+	Uploads int
+	// times it claimed pieces it didn't deliver, once there were too many we stopped counting its pieces for rarest first
+	BrokenClaims uint32
+	Distrusted   bool
 }
 
 func (p *PeerConnStats) Less(o *PeerConnStats) bool {
@@ -84,6 +108,9 @@ const Checking = TorrentState("checking")
 const Stopped = TorrentState("stopped")
 const Downloading = TorrentState("downloading")
 
+// Queued torrents wait for a turn to run
+const Queued = TorrentState("queued")
+
 func (t TorrentState) String() string {
 	return string(t)
 }
@@ -99,6 +126,45 @@ type TorrentStatus struct {
 	Progress float64
 	TX       uint64
 	RX       uint64
+	Trackers []TrackerStatus
+	// bytes of the files we want, how many of those we have and how many are left
+	Wanted    uint64
+	Done      uint64
+	Remaining uint64
+	// pieces that failed verification since we started
+	VerifyFailures uint64
+	// how far unpacking the completed data got
+	Unpack unpack.Status
+	// when we first added and completed the torrent and last moved any data for it
+	AddedAt     time.Time
+	CompletedAt time.Time
+	LastActive  time.Time
+	// rpc user that added the torrent
+	Owner string
+	// seeding with tiny resource usage until a peer is interested again
+	Dormant bool
+	// downloading but no peer has had the pieces we need for a long time
+	Unavailable bool
+	// reveals pieces to peers one at a time while seeding
+	Superseed bool
+	// how soon the torrent gets a turn to run and how much of the bandwidth limits it gets
+	Priority string
+	// where the torrent waits for a turn to run, 1 goes next, 0 if it isn't waiting
+	QueuePosition int
+	// runs without waiting for a turn and doesn't count against the active torrents
+	Forced bool
+	// bytes of piece data we uploaded and downloaded since we added the torrent and their share ratio
+	Uploaded   uint64
+	Downloaded uint64
+	ShareRatio float64
+	// the share ratio the torrent stops at and if it is its own instead of the default one
+	RatioLimit RatioLimit
+	OwnRatio   bool
+	// web seeds we used, nil if we didn't use any
+	WebSeeds []WebSeedStatus
+	// the kind of network and the swarm the torrent may only run on, empty and -1 for any
+	PinnedNetwork string
+	PinnedSwarm   int
 }
 
 func (t TorrentStatus) Ratio() (r float64) {
@@ -106,6 +172,20 @@ func (t TorrentStatus) Ratio() (r float64) {
 	return
 }
 
+// TorrentSummary is just enough of a torrent's status to show it in a big list
+type TorrentSummary struct {
+	Infohash string
+	Name     string
+	State    TorrentState
+	Progress float64
+	// upload and download rates in bytes per second
+	TX          float64
+	RX          float64
+	Peers       int
+	Dormant     bool
+	Unavailable bool
+}
+
 type TorrentStatusList []TorrentStatus
 
 func (l TorrentStatusList) TX() (tx float64) {
@@ -143,6 +223,31 @@ func (l *TorrentStatusList) Swap(i, j int) {
 	(*l)[i], (*l)[j] = (*l)[j], (*l)[i]
 }
 
+// ErrBadSortKey is returned when sorting torrents by something we can't sort them by
+var ErrBadSortKey = errors.New("can only sort torrents by name, added, completed or active")
+
+// SortBy sorts torrents by "name" or newest first by when they were "added", "completed" or last "active"
+func (l TorrentStatusList) SortBy(key string) error {
+	var when func(st TorrentStatus) time.Time
+	switch key {
+	case "", "name":
+		sort.Stable(&l)
+		return nil
+	case "added":
+		when = func(st TorrentStatus) time.Time { return st.AddedAt }
+	case "completed":
+		when = func(st TorrentStatus) time.Time { return st.CompletedAt }
+	case "active":
+		when = func(st TorrentStatus) time.Time { return st.LastActive }
+	default:
+		return ErrBadSortKey
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		return when(l[i]).After(when(l[j]))
+	})
+	return nil
+}
+
 // SwarmBandwidth is a string tuple for bandwith
 type SwarmBandwidth struct {
 	Upload   string
@@ -0,0 +1,149 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+)
+
+// SetSuperseed sets if we reveal pieces to peers one at a time while seeding so the first copies spread as far as they can, kept across restarts
+// only peers that connect after it is turned on are super seeded, peers we super seeded learn everything we have when it is turned off
+func (t *Torrent) SetSuperseed(on bool) error {
+	if err := t.st.SetSuperseed(on); err != nil {
+		return err
+	}
+	t.setSuperseed(on)
+	return nil
+}
+
+// super seed the torrent if on unless it was turned on or off for this torrent
+func (t *Torrent) defaultSuperseed(on bool) {
+	if _, set := t.st.Superseed(); !set {
+		t.setSuperseed(on)
+	}
+}
+
+func (t *Torrent) setSuperseed(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&t.superseed, v) == v || on {
+		return
+	}
+	t.VisitPeers(func(c *PeerConn) {
+		c.revealAll()
+	})
+}
+
+// Superseed returns true if super seeding is turned on, it only does anything while we are seeding
+func (t *Torrent) Superseed() bool {
+	return atomic.LoadInt32(&t.superseed) == 1
+}
+
+// returns true if we hide what we have from peers and reveal it a piece at a time
+func (t *Torrent) superseeding() bool {
+	return t.Superseed() && t.Done()
+}
+
+// tell a peer that just connected what pieces we have
+func (c *PeerConn) sendBitfield() {
+	if !c.t.superseeding() {
+		c.Send(c.t.Bitfield().ToWireMessage())
+		return
+	}
+	atomic.StoreInt32(&c.superseeded, 1)
+	if c.fast {
+		c.Send(common.NewWireMessage(common.HaveNone, nil))
+	} else {
+		c.Send(bittorrent.NewBitfield(c.t.MetaInfo().Info.NumPieces(), nil).ToWireMessage())
+	}
+	c.revealNext()
+}
+
+// returns the piece we last revealed to this peer
+func (c *PeerConn) revealed() (idx uint32, has bool) {
+	v := atomic.LoadUint32(&c.superseedPiece)
+	return v - 1, v > 0
+}
+
+// pick the piece of ours the fewest peers have that remote lacks, preferring pieces not in others
+func pickSuperseedPiece(ours, remote *bittorrent.Bitfield, avail *bittorrent.Availability, others map[uint32]bool) (idx uint32, has bool) {
+	peerHas := func(idx uint32) bool {
+		return remote != nil && remote.Has(idx)
+	}
+	idx, has = ours.FindRarestIn(avail, func(idx uint32) bool {
+		return peerHas(idx) || others[idx]
+	})
+	if !has {
+		// every piece it lacks went to somebody else, it can have one too
+		idx, has = ours.FindRarestIn(avail, peerHas)
+	}
+	return
+}
+
+// reveal the piece the fewest peers have and that we didn't reveal to another peer yet, if we can
+func (c *PeerConn) revealNext() {
+	bf := c.t.Bitfield()
+	avail := c.t.availability()
+	if bf == nil || avail == nil {
+		return
+	}
+	others := make(map[uint32]bool)
+	c.t.VisitPeers(func(other *PeerConn) {
+		if other != c {
+			if idx, has := other.revealed(); has {
+				others[idx] = true
+			}
+		}
+	})
+	idx, has := pickSuperseedPiece(bf, c.Bitfield(), avail, others)
+	if !has {
+		return
+	}
+	log.Debugf("%s: super seeding piece %d to %s", c.t.Name(), idx, c.id.String())
+	atomic.StoreUint32(&c.superseedPiece, idx+1)
+	c.Send(common.NewHave(idx))
+}
+
+// the peer told us it has a piece, the super seeded peers we revealed it to passed it on and get another one
+func (c *PeerConn) superseedGotHave(idx uint32) {
+	var peers []*PeerConn
+	c.t.VisitPeers(func(other *PeerConn) {
+		peers = append(peers, other)
+	})
+	for _, other := range superseedSpread(peers, c, idx) {
+		other.revealNext()
+	}
+}
+
+// the super seeded peers other than from that we revealed piece idx to
+func superseedSpread(peers []*PeerConn, from *PeerConn, idx uint32) (spread []*PeerConn) {
+	for _, c := range peers {
+		if c == from || atomic.LoadInt32(&c.superseeded) == 0 {
+			continue
+		}
+		if revealed, has := c.revealed(); has && revealed == idx {
+			spread = append(spread, c)
+		}
+	}
+	return
+}
+
+// stop super seeding this peer and tell it about every piece it lacks
+func (c *PeerConn) revealAll() {
+	if !atomic.CompareAndSwapInt32(&c.superseeded, 1, 0) {
+		return
+	}
+	bf := c.t.Bitfield()
+	if bf == nil {
+		return
+	}
+	remote := c.Bitfield()
+	bf.ForEachSet(func(idx uint32) {
+		if remote == nil || !remote.Has(idx) {
+			c.Send(common.NewHave(idx))
+		}
+	})
+}
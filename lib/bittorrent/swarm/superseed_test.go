@@ -0,0 +1,55 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPickSuperseedPiece(t *testing.T) {
+	ours := bittorrent.NewBitfield(4, nil).Inverted()
+	avail := bittorrent.NewAvailability(4)
+	avail.Have(0)
+	avail.Have(1)
+	avail.Have(1)
+	remote := bittorrent.NewBitfield(4, nil)
+	remote.Set(2)
+	idx, has := pickSuperseedPiece(ours, remote, avail, nil)
+	if !has || idx != 3 {
+		t.Fatalf("picked %d %v, wanted 3", idx, has)
+	}
+	idx, has = pickSuperseedPiece(ours, remote, avail, map[uint32]bool{3: true})
+	if !has || idx != 0 {
+		t.Fatalf("picked %d %v, wanted 0 since 3 went to another peer", idx, has)
+	}
+	idx, has = pickSuperseedPiece(ours, remote, avail, map[uint32]bool{0: true, 1: true, 3: true})
+	if !has || idx != 3 {
+		t.Fatalf("picked %d %v, wanted 3 once every piece went to another peer", idx, has)
+	}
+	if _, has = pickSuperseedPiece(ours, ours, avail, nil); has {
+		t.Fatal("picked a piece for a peer that has them all")
+	}
+}
+
+func TestSuperseedSpread(t *testing.T) {
+	seeded := func(revealed uint32) *PeerConn {
+		c := &PeerConn{}
+		atomic.StoreInt32(&c.superseeded, 1)
+		atomic.StoreUint32(&c.superseedPiece, revealed+1)
+		return c
+	}
+	a, b := seeded(1), seeded(2)
+	other := &PeerConn{}
+	peers := []*PeerConn{a, b, other}
+	// the peer we revealed a piece to saying it has it isn't the piece spreading
+	if spread := superseedSpread(peers, a, 1); len(spread) != 0 {
+		t.Fatalf("%d peers get another piece when only the peer we revealed it to has it", len(spread))
+	}
+	spread := superseedSpread(peers, other, 1)
+	if len(spread) != 1 || spread[0] != a {
+		t.Fatalf("%d peers get another piece once the piece is at another peer", len(spread))
+	}
+	if spread = superseedSpread(peers, other, 3); len(spread) != 0 {
+		t.Fatal("a piece nobody was revealed spread")
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"bytes"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/bittorrent/mse"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/dht"
 	"github.com/majestrate/XD/lib/gnutella"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/metainfo"
 	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/transport"
+	"github.com/majestrate/XD/lib/stats"
 	"github.com/majestrate/XD/lib/storage"
 	"github.com/majestrate/XD/lib/tracker"
 	"github.com/majestrate/XD/lib/util"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -27,14 +31,35 @@ type Swarm struct {
 	Torrents Holder
 	id       common.PeerID
 	trackers map[string]tracker.Announcer
+	proxy    *tracker.ProxySettings
+	// sends announces of many torrents to the same tracker together
+	batcher  *tracker.Batcher
+	remotes  remotePeers
 	xdht     dht.XDHT
 	gnutella *gnutella.Swarm
-	active   int
+	queue    activeQueue
 	getNet   chan network.Network
 	netDied  chan bool
 	newNet   chan network.Network
 	netError chan error
 	netDead  bool
+	// true from when we lost the network until we get it back, only touched by LostNetwork and ObtainedNetwork
+	netLost bool
+	// only accept inbound peers for started torrents we have metadata for
+	StrictInbound bool
+	index         *Index
+	number        int
+	dials         *dialLimiter
+	// 1 while the network is struggling, accessed atomically
+	struggling int32
+	// wraps peer connections, plain connections if nil
+	peerTransport transport.Transport
+	// when we encrypt connections to clearnet peers with mse
+	Encryption mse.Policy
+	// let other XDs test the network between us by moving data to and from us
+	AnswerNetTests bool
+	// 1 while a network test runs, we run or answer one at a time
+	netTesting int32
 }
 
 func (sw *Swarm) IsOnline() bool {
@@ -46,31 +71,51 @@ func (sw *Swarm) Running() bool {
 }
 
 func (sw *Swarm) onStopped(t *Torrent) {
-	sw.active--
+	sw.queue.stopped(t)
 }
 
 func (sw *Swarm) Network() network.Network {
 	return <-sw.getNet
 }
 
-func (sw *Swarm) waitForQueue() {
-	if sw.Torrents.QueueSize > 0 {
-		for sw.active >= sw.Torrents.QueueSize {
-			time.Sleep(time.Second)
-		}
-	}
+// wait for t to get a turn in the active queue, returns false if it was closed while it waited
+func (sw *Swarm) waitForQueue(t *Torrent) bool {
+	return sw.queue.wait(t, sw.Torrents.QueueSize)
 }
 
 func (sw *Swarm) startTorrent(t *Torrent) {
 	t.RemoveSelf = func() {
 		sw.Torrents.removeTorrent(t.st.Infohash())
 	}
+	t.Started = func() {
+		sw.queue.started(t)
+	}
 	t.Stopped = func() {
 		sw.onStopped(t)
 	}
+	t.GotMetaInfo = func() {
+		sw.Torrents.addHybrid(t)
+	}
 	// wait for network
 	sw.Network()
 	t.xdht = &sw.xdht
+	if sw.xdht.Enabled() && !t.Private() {
+		t.defaultOpts.SetSupported(extensions.XDHT)
+	}
+	t.remotes = &sw.remotes
+	t.dials = sw.dials
+	t.queue = &sw.queue
+	t.waitTurn = func() bool {
+		return sw.waitForQueue(t)
+	}
+	t.announceDelay = sw.announceDelay
+	t.trackerFromURL = sw.trackerFromURL
+	t.peerTransport = sw.peerTransport
+	t.encryption = sw.Encryption
+	t.audit = sw.Audit
+	t.webSeedTransport = func(u *url.URL) (*http.Transport, error) {
+		return sw.proxy.HTTPTransport(u, t.Network())
+	}
 	// give peerid
 	t.id = sw.id
 	// add open trackers
@@ -81,7 +126,7 @@ func (sw *Swarm) startTorrent(t *Torrent) {
 	info := t.MetaInfo()
 	if info != nil {
 		for _, u := range info.GetAllAnnounceURLS() {
-			tr := tracker.FromURL(u)
+			tr := sw.trackerFromURL(u)
 			if tr != nil {
 				name := tr.Name()
 				_, ok := t.Trackers[name]
@@ -91,26 +136,64 @@ func (sw *Swarm) startTorrent(t *Torrent) {
 			}
 		}
 	}
+	if t.st.Paused() {
+		// stopped before we last shut down, wait for someone to start it
+		t.closing = true
+		return
+	}
 	// handle messages
-	sw.waitForQueue()
-	sw.active++
-	t.Start()
+	if !t.waitTurn() {
+		return
+	}
+	t.start()
 }
 
 // got inbound connection
 func (sw *Swarm) inboundConn(c net.Conn) {
+	if sw.peerTransport != nil {
+		var err error
+		setDeadline(c, sw.Torrents.Timeouts.Handshake)
+		c, err = sw.peerTransport.Server(c)
+		if err != nil {
+			log.Debugf("inbound transport handshake failed: %s", err)
+			return
+		}
+	}
 	var firstBytes [20]byte
-	n, err := c.Read(firstBytes[:])
+	setDeadline(c, sw.Torrents.Timeouts.Handshake)
+	n, err := io.ReadFull(c, firstBytes[:])
 	if err != nil || n != 20 {
 		log.Debug("failed to read first bytes")
 		c.Close()
 		return
 	}
-	if firstBytes[0] == 19 {
+	encrypted := false
+	var mseInfohash common.Infohash
+	if !bittorrent.IsHandshakePrefix(firstBytes[:]) && !bytes.Equal(firstBytes[:], []byte(gnutella.Handshake)) && string(firstBytes[:]) != netTestHandshake && sw.Encryption.Encrypts() && mseApplies(c.LocalAddr()) {
+		// not plaintext so it could be an mse handshake
+		c, mseInfohash, err = mse.Server(c, firstBytes[:], sw.infohashes(), sw.Encryption.AllowsPlaintext())
+		if err != nil {
+			log.Debugf("inbound mse handshake failed: %s", err)
+			return
+		}
+		encrypted = true
+		n, err = io.ReadFull(c, firstBytes[:])
+		if err != nil || n != 20 {
+			log.Debug("failed to read first bytes after mse handshake")
+			c.Close()
+			return
+		}
+	}
+	if bittorrent.IsHandshakePrefix(firstBytes[:]) {
+		if !encrypted && !sw.Encryption.AllowsPlaintext() && mseApplies(c.LocalAddr()) {
+			log.Debug("refusing plaintext inbound connection, we require encryption")
+			c.Close()
+			return
+		}
 		// bittorrent
 		var buff [68]byte
 		copy(buff[:], firstBytes[:])
-		n, err = c.Read(buff[20:])
+		n, err = io.ReadFull(c, buff[20:])
 		if err != nil || n != 48 {
 			log.Debugf("failed to read bittorrent handshake: %d bytes", n)
 			c.Close()
@@ -123,26 +206,45 @@ func (sw *Swarm) inboundConn(c net.Conn) {
 			c.Close()
 			return
 		}
-		t := sw.Torrents.GetTorrent(h.Infohash)
-		if t == nil {
-			log.Warnf("we don't have torrent with infohash %s, closing connection", h.Infohash.Hex())
-			// no such torrent
+		if encrypted && h.Infohash != mseInfohash {
+			log.Debugf("inbound peer asked for %s in mse but %s in its handshake", mseInfohash.Hex(), h.Infohash.Hex())
 			c.Close()
 			return
 		}
+		t := sw.Torrents.GetTorrent(h.Infohash)
+		// refuse the same way whatever the reason so probing peers learn nothing
+		if !sw.servesInbound(t) {
+			log.Debugf("refusing inbound connection for %s, not serving it", h.Infohash.Hex())
+			rejectInbound(c)
+			return
+		}
 		// check if we should accept this new peer or not
 		if !t.ShouldAcceptNewPeer() {
-			c.Close()
+			log.Debugf("refusing inbound connection for %s, no free peer slots", h.Infohash.Hex())
+			rejectInbound(c)
+			return
+		}
+		if sw.remotes.banned(c.RemoteAddr().String()) {
+			log.Debugf("refusing inbound connection for %s, %s is banned", h.Infohash.Hex(), c.RemoteAddr())
+			rejectInbound(c)
 			return
 		}
+		// a remote may connect to us once per torrent
+		if sw.remotes.Has(c.RemoteAddr(), h.Infohash) {
+			log.Debugf("refusing inbound connection for %s, %s already connected", h.Infohash.Hex(), c.RemoteAddr())
+			rejectInbound(c)
+			return
+		}
+		if t.Dormant() {
+			// someone wants it after all, serve them and find the rest of the swarm again
+			t.wake("got an inbound peer")
+		}
 		var opts extensions.Message
 		if h.Reserved.Has(bittorrent.Extension) {
-			if t.Ready() {
-				opts = extensions.NewOur(uint32(len(t.metaInfo)))
-			} else {
-				opts = extensions.NewOur(0)
-			}
+			opts = t.defaultOpts.Copy()
 		}
+		fast := h.Reserved.Has(bittorrent.FastExtension)
+		h.Reserved.Set(bittorrent.FastExtension)
 		// reply to handshake
 		var id common.PeerID
 		copy(id[:], h.PeerID[:])
@@ -154,9 +256,11 @@ func (sw *Swarm) inboundConn(c net.Conn) {
 			c.Close()
 			return
 		}
+		setDeadline(c, 0)
 		// make peer conn
 		p := makePeerConn(c, t, id, opts)
 		p.inbound = true
+		p.fast = fast
 		t.onNewPeer(p)
 
 	} else if bytes.Equal(firstBytes[:], []byte(gnutella.Handshake)) {
@@ -167,12 +271,16 @@ func (sw *Swarm) inboundConn(c net.Conn) {
 		// do the rest of the handshake
 		conn := gnutella.NewConn(c)
 		err = conn.Handshake(sw.gnutella == nil)
+		setDeadline(c, 0)
 		if err == nil && sw.gnutella != nil {
 			log.Debug("got GNUTella Peer")
 			sw.gnutella.AddInboundPeer(conn)
 		} else {
 			conn.Close()
 		}
+	} else if string(firstBytes[:]) == netTestHandshake {
+		// another XD testing the network
+		sw.serveNetTest(c)
 	} else {
 		// unknown
 		log.Debug("bad protocol handshake")
@@ -182,7 +290,11 @@ func (sw *Swarm) inboundConn(c net.Conn) {
 }
 
 // add a torrent to this swarm
+// returns ErrPinned if the torrent is pinned to another swarm
 func (sw *Swarm) AddTorrent(t storage.Torrent) (err error) {
+	if _, num := t.NetworkPin(); !sw.pinnedHere(num) {
+		return ErrPinned
+	}
 	sw.Torrents.addTorrent(t, sw.Network)
 	tr := sw.Torrents.GetTorrent(t.Infohash())
 	go sw.startTorrent(tr)
@@ -204,6 +316,23 @@ func (sw *Swarm) getCurrentBW() (bw SwarmBandwidth) {
 	return
 }
 
+// Snapshot returns aggregate statistics for all torrents in this swarm right now
+func (sw *Swarm) Snapshot() (s stats.Snapshot) {
+	s.Time = time.Now().Unix()
+	sw.Torrents.ForEachTorrent(func(t *Torrent) {
+		s.TX += uint64(t.TX())
+		s.RX += uint64(t.RX())
+		s.Peers += uint32(t.NumPeers())
+		s.Torrents++
+	})
+	return
+}
+
+// StatsHistory returns the persisted statistics snapshots taken between from and to
+func (sw *Swarm) StatsHistory(from, to time.Time) ([]stats.Snapshot, error) {
+	return sw.Torrents.st.Snapshots(from, to)
+}
+
 func (sw *Swarm) netLoop() {
 	log.Info("Swarm netLoop starting")
 	var n network.Network
@@ -245,6 +374,7 @@ func (sw *Swarm) Run() error {
 }
 
 func (sw *Swarm) tick() {
+	sw.Torrents.Bandwidth.tick(time.Now())
 	sw.Torrents.ForEachTorrent(func(t *Torrent) {
 		t.tick()
 	})
@@ -267,6 +397,7 @@ func (sw *Swarm) acceptLoop() {
 
 // inform that we lost the network context
 func (sw *Swarm) LostNetwork() {
+	sw.netLost = true
 	sw.netDied <- true
 }
 
@@ -274,9 +405,19 @@ func (sw *Swarm) LostNetwork() {
 func (sw *Swarm) ObtainedNetwork(n network.Network) {
 	sw.id = common.GeneratePeerID()
 	log.Infof("Generated new peer id: %s", sw.id.String())
+	if sw.xdht.Enabled() {
+		go sw.runDHT(n)
+	}
 	// give network to netLoop
 	sw.newNet <- n
 	log.Info("Swarm got network context")
+	if sw.netLost {
+		// don't wait out the announce timers for peers to find us again
+		sw.netLost = false
+		sw.Torrents.ForEachTorrentParallel(func(t *Torrent) {
+			t.networkRecovered()
+		})
+	}
 	return
 }
 
@@ -284,23 +425,50 @@ func (sw *Swarm) ObtainedNetwork(n network.Network) {
 func NewSwarm(storage storage.Storage, gnutella *gnutella.Swarm) *Swarm {
 	sw := &Swarm{
 		Torrents: Holder{
-			st: storage,
+			st:        storage,
+			Timeouts:  DefaultTimeouts,
+			Bandwidth: NewBandwidth(),
 		},
 		trackers: map[string]tracker.Announcer{},
+		batcher:  tracker.NewBatcher(tracker.DefaultBatchWindow),
 		gnutella: gnutella,
 		getNet:   make(chan network.Network),
 		newNet:   make(chan network.Network),
 		netDied:  make(chan bool),
 		netError: make(chan error),
+		dials:    newDialLimiter(DefaultMaxDials),
 	}
 	go sw.acceptLoop()
 	go sw.netLoop()
+	go sw.runHealthCheck()
+	go sw.runScraper()
+	go sw.runTiering()
 	return sw
 }
 
+// SetTrackerProxy sets how we announce to trackers that are not on our network
+func (sw *Swarm) SetTrackerProxy(proxy *tracker.ProxySettings) {
+	sw.proxy = proxy
+}
+
+// SetAnnounceBatchWindow sets how long announces wait for announces of other torrents to the same tracker, 0 announces each torrent on its own
+func (sw *Swarm) SetAnnounceBatchWindow(window time.Duration) {
+	sw.batcher.SetWindow(window)
+}
+
+// make an announcer for a tracker url that batches announces where it can, nil if we can't use the url
+func (sw *Swarm) trackerFromURL(u string) tracker.Announcer {
+	return sw.batcher.Wrap(tracker.FromURL(u, sw.proxy))
+}
+
+// SetPeerTransport sets the transport that wraps every peer connection, nil for plain connections
+func (sw *Swarm) SetPeerTransport(tr transport.Transport) {
+	sw.peerTransport = tr
+}
+
 // AddOpenTracker adds an opentracker by url to be used by this swarm
 func (sw *Swarm) AddOpenTracker(url string) {
-	tr := tracker.FromURL(url)
+	tr := sw.trackerFromURL(url)
 	if tr != nil {
 		name := tr.Name()
 		_, ok := sw.trackers[name]
@@ -322,51 +490,113 @@ func (sw *Swarm) Close() (err error) {
 }
 
 func (sw *Swarm) AddRemoteTorrent(remote string) (err error) {
+	return sw.AddRemoteTorrentTo(remote, "")
+}
+
+// AddRemoteTorrentTo adds a torrent by url that downloads into dir
+// uses the default download directory if dir is empty
+func (sw *Swarm) AddRemoteTorrentTo(remote, dir string) (err error) {
+	return sw.AddRemoteTorrentAs(remote, dir, "")
+}
+
+// AddRemoteTorrentAs adds a torrent by url that downloads into dir and belongs to the rpc user owner
+func (sw *Swarm) AddRemoteTorrentAs(remote, dir, owner string) (err error) {
+	return sw.AddRemoteTorrentWith(remote, AddOptions{
+		Dir:   dir,
+		Owner: owner,
+	})
+}
+
+// AddOptions says how to set up a torrent we add
+type AddOptions struct {
+	// download directory, the default if empty
+	Dir string
+	// rpc user the torrent belongs to, nobody if empty
+	Owner string
+	// kind of network the torrent may only run on, any if empty
+	Network string
+	// only ever run the torrent in this swarm
+	PinSwarm bool
+}
+
+// AddRemoteTorrentWith adds a torrent by url set up with opts
+func (sw *Swarm) AddRemoteTorrentWith(remote string, opts AddOptions) (err error) {
+	err = CheckNetworkKind(opts.Network)
+	if err != nil {
+		return
+	}
 	var u *url.URL
 	u, err = url.Parse(remote)
 	if err == nil {
 		scheme := strings.ToLower(u.Scheme)
 		if scheme == "magnet" {
-			err = sw.AddMagnet(remote)
+			err = sw.addMagnetURI(remote, opts)
 		} else if scheme == "file" || scheme == "" {
-			err = sw.addFileTorrent(u.Path)
+			err = sw.addFileTorrent(u.Path, opts)
 		} else {
-			err = sw.addHTTPTorrent(u.String())
+			err = sw.addHTTPTorrent(u.String(), opts)
 		}
 	}
 	return
 }
 
+// RestoreTorrent moves a deleted torrent back out of the trash and starts it
+func (sw *Swarm) RestoreTorrent(ih common.Infohash) (err error) {
+	var t storage.Torrent
+	t, err = sw.Torrents.st.RestoreTorrent(ih)
+	if err == nil {
+		err = sw.AddTorrent(t)
+	}
+	return
+}
+
 func (sw *Swarm) AddMagnet(uri string) (err error) {
-	var u *url.URL
-	u, err = url.Parse(uri)
+	return sw.addMagnetURI(uri, AddOptions{})
+}
+
+func (sw *Swarm) addMagnetURI(uri string, opts AddOptions) (err error) {
+	var m metainfo.Magnet
+	m, err = metainfo.ParseMagnet(uri)
+	if err == nil && m.IsV2Only() {
+		// without piece layers we could never check the pieces of a v2 only torrent
+		// and a hybrid one we'd find this way is known by its v1 infohash
+		err = metainfo.ErrV2OnlyMagnet
+	}
 	if err == nil {
-		q := u.Query()
-		xt := q.Get("xt")
-		if len(xt) > 0 {
-			xt = strings.ToLower(xt)
-			if strings.HasPrefix(xt, "urn:btih:") && len(xt) == 49 {
-				var ih common.Infohash
-				ih, err = common.DecodeInfohash(xt[9:])
-				if err == nil {
-					err = sw.addMagnet(ih)
-				}
-			} else {
-				err = common.ErrBadMagnetURI
-			}
-		} else {
-			err = common.ErrBadMagnetURI
-		}
+		err = sw.addMagnet(m.Infohash(), opts)
 	}
 	return
 }
 
-func (sw *Swarm) addMagnet(ih common.Infohash) (err error) {
-	sw.AddTorrent(sw.Torrents.st.EmptyTorrent(ih))
+func (sw *Swarm) addMagnet(ih common.Infohash, opts AddOptions) (err error) {
+	if !sw.onNetwork(opts.Network) {
+		// we'd only ever find its peers on another network
+		return ErrPinned
+	}
+	err = sw.claimTorrent(ih)
+	if err == nil {
+		defer sw.releaseTorrent(ih)
+		err = sw.addTorrentWith(sw.Torrents.st.EmptyTorrentIn(ih, opts.Dir), opts)
+	}
 	return
 }
 
-func (sw *Swarm) addFileTorrent(path string) (err error) {
+// add a torrent with the owner and pins in opts
+func (sw *Swarm) addTorrentWith(t storage.Torrent, opts AddOptions) error {
+	if opts.Owner != "" {
+		t.SetOwner(opts.Owner)
+	}
+	if opts.Network != "" || opts.PinSwarm {
+		num := -1
+		if opts.PinSwarm {
+			num = sw.number
+		}
+		t.SetNetworkPin(opts.Network, num)
+	}
+	return sw.AddTorrent(t)
+}
+
+func (sw *Swarm) addFileTorrent(path string, opts AddOptions) (err error) {
 	var info metainfo.TorrentFile
 	var f *os.File
 	f, err = os.Open(path)
@@ -374,12 +604,16 @@ func (sw *Swarm) addFileTorrent(path string) (err error) {
 		err = info.BDecode(f)
 		f.Close()
 		if err == nil {
+			err = sw.claimTorrent(info.Infohash())
+		}
+		if err == nil {
+			defer sw.releaseTorrent(info.Infohash())
 			var t storage.Torrent
-			t, err = sw.Torrents.st.OpenTorrent(&info)
+			t, err = sw.Torrents.st.OpenTorrentIn(&info, opts.Dir)
 			if err == nil {
 				err = t.VerifyAll()
 				if err == nil {
-					sw.AddTorrent(t)
+					err = sw.addTorrentWith(t, opts)
 				}
 			}
 		}
@@ -390,7 +624,11 @@ func (sw *Swarm) addFileTorrent(path string) (err error) {
 	return
 }
 
-func (sw *Swarm) addHTTPTorrent(remote string) (err error) {
+func (sw *Swarm) addHTTPTorrent(remote string, opts AddOptions) (err error) {
+	if !sw.onNetwork(opts.Network) {
+		// don't fetch it over a network it may not use
+		return ErrPinned
+	}
 	n := sw.Network()
 	cl := &http.Client{
 		Transport: &http.Transport{
@@ -406,12 +644,16 @@ func (sw *Swarm) addHTTPTorrent(remote string) (err error) {
 			defer r.Body.Close()
 			err = info.BDecode(r.Body)
 			if err == nil {
+				err = sw.claimTorrent(info.Infohash())
+			}
+			if err == nil {
+				defer sw.releaseTorrent(info.Infohash())
 				var t storage.Torrent
-				t, err = sw.Torrents.st.OpenTorrent(&info)
+				t, err = sw.Torrents.st.OpenTorrentIn(&info, opts.Dir)
 				if err == nil {
 					err = t.VerifyAll()
 					if err == nil {
-						sw.AddTorrent(t)
+						err = sw.addTorrentWith(t, opts)
 					}
 				}
 			}
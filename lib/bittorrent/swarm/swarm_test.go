@@ -1,9 +1,44 @@
 package swarm
 
 import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/fs"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/storage"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestSwarm(t *testing.T) {
 
 }
+
+func TestRefuseV2OnlyMagnet(t *testing.T) {
+	sw := new(Swarm)
+	err := sw.AddMagnet("magnet:?xt=urn:btmh:1220" + strings.Repeat("ab", 32))
+	if err != metainfo.ErrV2OnlyMagnet {
+		t.Fatalf("got %v adding a v2 only magnet", err)
+	}
+}
+
+func TestAddMagnetError(t *testing.T) {
+	dir := t.TempDir()
+	st := &storage.FsStorage{
+		MetaDir:    filepath.Join(dir, "meta"),
+		DataDir:    filepath.Join(dir, "data"),
+		SeedingDir: filepath.Join(dir, "seeding"),
+		FS:         fs.STD,
+	}
+	if err := st.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var ih common.Infohash
+	ih[0] = 1
+	// pinned to a swarm we don't have from an earlier run
+	st.EmptyTorrent(ih).SetNetworkPin("", 5)
+	sw := &Swarm{Torrents: Holder{st: st}}
+	if err := sw.addMagnet(ih, AddOptions{}); err != ErrPinned {
+		t.Fatalf("adding a magnet pinned to another swarm gave %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/tracker"
+)
+
+// MaxLearnedTrackers is the most trackers one torrent adds from lt_tex
+const MaxLearnedTrackers = 20
+
+// get trackers we can tell peers about over lt_tex, only ones that answered our last announce
+func (t *Torrent) texTrackers() (urls []string) {
+	if t.Private() {
+		return
+	}
+	var announcers []*torrentAnnounce
+	t.announceMtx.Lock()
+	for _, a := range t.announcers {
+		announcers = append(announcers, a)
+	}
+	t.announceMtx.Unlock()
+	for _, a := range announcers {
+		if len(urls) >= extensions.MaxTEXTrackers {
+			return
+		}
+		if a.announce != nil && a.working() {
+			urls = append(urls, a.announce.Name())
+		}
+	}
+	return
+}
+
+// add a tracker a peer told us about, returns true if we did not have it
+func (t *Torrent) addTracker(u string) bool {
+	if t.trackerFromURL == nil || t.Private() {
+		return false
+	}
+	tr := t.trackerFromURL(u)
+	if tr == nil {
+		return false
+	}
+	name := tr.Name()
+	t.announceMtx.Lock()
+	_, has := t.Trackers[name]
+	full := t.learnedTrackers >= MaxLearnedTrackers
+	if !has && !full {
+		t.Trackers[name] = tr
+		t.learnedTrackers++
+	}
+	t.announceMtx.Unlock()
+	if has || full {
+		return false
+	}
+	log.Infof("learned tracker %s for %s", name, t.Name())
+	if t.announceTicker != nil {
+		t.nextAnnounceFor(name)
+		go t.announce(name, tracker.Started)
+	}
+	return true
+}
+
+// add learned trackers to the stored metainfo if we are told to
+func (t *Torrent) persistTrackers(urls []string) {
+	if !t.PersistLearnedTrackers {
+		return
+	}
+	err := t.st.AddTrackers(urls)
+	if err != nil {
+		log.Warnf("failed to save trackers for %s: %s", t.Name(), err.Error())
+	}
+}
@@ -0,0 +1,135 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+	"time"
+)
+
+// TierPolicy says when seeding torrents go dormant
+// dormant torrents announce rarely and dial nobody until a peer is interested in them again
+type TierPolicy struct {
+	// seeding torrents that moved no data for this long go dormant, never if 0
+	DormantAfter time.Duration
+	// torrents completed less than this long ago stay active
+	MinAge time.Duration
+	// how often dormant and unavailable torrents announce to trackers and the dht
+	AnnounceInterval time.Duration
+	// downloading torrents no peer had a needed piece for this long are unavailable, never if 0
+	UnavailableAfter time.Duration
+}
+
+// DefaultTierPolicy is the tiering policy used when none is configured, torrents never go dormant
+var DefaultTierPolicy = TierPolicy{
+	MinAge:           time.Hour * 24 * 7,
+	AnnounceInterval: time.Hour * 6,
+	UnavailableAfter: time.Hour * 24,
+}
+
+// how often we look for torrents that should go dormant
+const tierCheckInterval = time.Minute
+
+// returns true if t should go dormant under p at now
+func (p TierPolicy) dormant(t *Torrent, now time.Time) bool {
+	if p.DormantAfter <= 0 || !t.started || t.closing || !t.Done() {
+		return false
+	}
+	since := t.LastActive()
+	if since.IsZero() {
+		since = t.CompletedAt()
+	}
+	if since.IsZero() {
+		since = t.AddedAt()
+	}
+	if now.Sub(since) < p.DormantAfter {
+		return false
+	}
+	completed := t.CompletedAt()
+	if completed.IsZero() {
+		completed = t.AddedAt()
+	}
+	return now.Sub(completed) >= p.MinAge
+}
+
+// Dormant returns true if the torrent is seeding with tiny resource usage until someone wants it again
+func (t *Torrent) Dormant() bool {
+	return atomic.LoadInt32(&t.dormant) == 1
+}
+
+// put the torrent in the dormant tier if it is old and nobody asked for it in a while
+func (t *Torrent) checkTier(now time.Time) {
+	if t.Dormant() || !t.Tiering.dormant(t, now) {
+		return
+	}
+	interested := false
+	t.VisitPeers(func(c *PeerConn) {
+		if c.peerInterested {
+			interested = true
+		}
+	})
+	if interested || !atomic.CompareAndSwapInt32(&t.dormant, 0, 1) {
+		return
+	}
+	log.Infof("%s has been idle since %s, going dormant", t.Name(), t.LastActive())
+	// peers that don't want anything from us only cost us
+	t.VisitPeers(func(c *PeerConn) {
+		c.Close()
+	})
+}
+
+// bring a dormant torrent back to the active tier and look for fresh peers right away, why says what woke it
+func (t *Torrent) wake(why string) {
+	if !atomic.CompareAndSwapInt32(&t.dormant, 1, 0) {
+		return
+	}
+	// don't go right back to sleep
+	atomic.StoreInt64(&t.lastActive, time.Now().UnixNano())
+	log.Infof("%s %s, no longer dormant", t.Name(), why)
+	// announces can be running, don't hold up the peer that woke us
+	go t.announceNow()
+}
+
+// announce to every tracker and the dht on the next announce tick
+func (t *Torrent) announceNow() {
+	now := time.Now()
+	var announcers []*torrentAnnounce
+	t.announceMtx.Lock()
+	for _, a := range t.announcers {
+		announcers = append(announcers, a)
+	}
+	t.nextDHTAnnounce = now
+	t.announceMtx.Unlock()
+	for _, a := range announcers {
+		a.access.Lock()
+		if a.next.After(now) {
+			// still not sooner than the tracker lets us
+			a.next = a.notBefore(now)
+			a.statusMtx.Lock()
+			a.lastNext = a.next
+			a.statusMtx.Unlock()
+		}
+		a.access.Unlock()
+	}
+}
+
+// push back when to announce next so dormant and unavailable torrents announce rarely
+func (t *Torrent) tierAnnounceTime(next time.Time) time.Time {
+	if (t.Dormant() || t.Unavailable()) && t.Tiering.AnnounceInterval > 0 {
+		if earliest := time.Now().Add(t.Tiering.AnnounceInterval); next.Before(earliest) {
+			return earliest
+		}
+	}
+	return next
+}
+
+// put torrents that went idle in the dormant tier and find torrents nobody has the pieces of
+func (sw *Swarm) runTiering() {
+	for sw.Running() {
+		time.Sleep(tierCheckInterval)
+		now := time.Now()
+		sw.Torrents.ForEachTorrent(func(t *Torrent) {
+			t.checkTier(now)
+			t.checkAvailable(now)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"testing"
+	"time"
+)
+
+func TestTierAnnounceTime(t *testing.T) {
+	tor := &Torrent{
+		Tiering: TierPolicy{
+			DormantAfter:     time.Hour,
+			AnnounceInterval: time.Hour,
+		},
+	}
+	next := time.Now().Add(time.Minute)
+	if got := tor.tierAnnounceTime(next); !got.Equal(next) {
+		t.Fatalf("active torrent announce moved to %s", got)
+	}
+	tor.dormant = 1
+	if got := tor.tierAnnounceTime(next); got.Before(time.Now().Add(time.Minute * 59)) {
+		t.Fatalf("dormant torrent announces at %s", got)
+	}
+	later := time.Now().Add(time.Hour * 2)
+	if got := tor.tierAnnounceTime(later); !got.Equal(later) {
+		t.Fatalf("dormant torrent announce moved up to %s", got)
+	}
+	// dormant torrents don't dial peers they hear about, this would need a network otherwise
+	tor.addPeers([]common.Peer{{IP: "127.0.0.1", Port: 6881}})
+	tor.dormant = 0
+	tor.unavailable = 1
+	if got := tor.tierAnnounceTime(next); got.Before(time.Now().Add(time.Minute * 59)) {
+		t.Fatalf("unavailable torrent announces at %s", got)
+	}
+}
+
+func TestAnnounceNow(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+	tor := &Torrent{
+		announcers: map[string]*torrentAnnounce{
+			"tracker": {next: later},
+		},
+		nextDHTAnnounce: later,
+	}
+	tor.announceNow()
+	if tor.announcers["tracker"].next.After(time.Now()) {
+		t.Fatal("tracker announce still waits")
+	}
+	if tor.nextDHTAnnounce.After(time.Now()) {
+		t.Fatal("dht announce still waits")
+	}
+}
@@ -0,0 +1,106 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/network"
+	"net"
+	"time"
+)
+
+// Timeouts says how long we wait on peers at each step of talking to them, 0 waits forever
+type Timeouts struct {
+	// connecting to a peer, including looking up its address
+	Dial time.Duration
+	// the transport and bittorrent handshakes once connected, both ways
+	Handshake time.Duration
+	// a peer sending us nothing at all
+	Read time.Duration
+	// a peer not taking what we send it
+	Write time.Duration
+	// a peer sending us nothing, not even keep-alives, checked by the peer's own ticker so it also catches streams that ignore deadlines
+	Idle time.Duration
+}
+
+// DefaultTimeouts are the timeouts used when none are configured, made for i2p latencies
+// we don't read with a deadline by default, idle peers are dropped after missing a couple of keep-alives instead
+var DefaultTimeouts = Timeouts{
+	Dial:      time.Minute * 3,
+	Handshake: time.Minute,
+	Write:     time.Minute * 2,
+	Idle:      KeepAliveInterval*2 + time.Minute,
+}
+
+// ErrDialTimeout is returned by dials to peers that took longer than the dial timeout
+var ErrDialTimeout = errors.New("timed out connecting to peer")
+
+// set the deadline of reads and writes on c to timeout from now, clears it if timeout is 0
+func setDeadline(c net.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		c.SetDeadline(time.Now().Add(timeout))
+	} else {
+		c.SetDeadline(time.Time{})
+	}
+}
+
+type dialResult struct {
+	c   net.Conn
+	err error
+}
+
+// dial a on n, giving up after timeout, a connection made after we gave up is closed
+func dialTimeout(n network.Network, a net.Addr, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return n.Dial(a.Network(), a.String())
+	}
+	result := make(chan dialResult, 1)
+	go func() {
+		c, err := n.Dial(a.Network(), a.String())
+		result <- dialResult{c, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r.c, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-result; r.c != nil {
+				r.c.Close()
+			}
+		}()
+		return nil, ErrDialTimeout
+	}
+}
+
+// a peer connection that fails reads and writes when the peer stalls too long
+type timeoutConn struct {
+	net.Conn
+	read  time.Duration
+	write time.Duration
+}
+
+// put the read and write timeouts on c, returns c if there are none
+func withTimeouts(c net.Conn, t Timeouts) net.Conn {
+	if t.Read <= 0 && t.Write <= 0 {
+		return c
+	}
+	return &timeoutConn{
+		Conn:  c,
+		read:  t.Read,
+		write: t.Write,
+	}
+}
+
+func (c *timeoutConn) Read(d []byte) (int, error) {
+	if c.read > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.read))
+	}
+	return c.Conn.Read(d)
+}
+
+func (c *timeoutConn) Write(d []byte) (int, error) {
+	if c.write > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.write))
+	}
+	return c.Conn.Write(d)
+}
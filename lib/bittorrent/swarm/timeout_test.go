@@ -0,0 +1,47 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/network/loopback"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	hub := loopback.NewHub()
+	a := hub.NewSession("a")
+	b := hub.NewSession("b")
+	a.Open()
+	defer a.Close()
+	b.Open()
+	defer b.Close()
+	// b never accepts so the dial hangs
+	_, err := dialTimeout(a, b.Addr(), time.Millisecond*50)
+	if err != ErrDialTimeout {
+		t.Fatalf("dial gave %v not a timeout", err)
+	}
+	go func() {
+		// the dial that timed out is still waiting to be accepted, never send anything on either
+		for {
+			c, err := b.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	c, err := dialTimeout(a, b.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c = withTimeouts(c, Timeouts{Read: time.Millisecond * 50})
+	started := time.Now()
+	_, err = c.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("read gave %v not a timeout", err)
+	}
+	if time.Since(started) > time.Millisecond*500 {
+		t.Fatalf("read took %s to time out", time.Since(started))
+	}
+}
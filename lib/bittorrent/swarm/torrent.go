@@ -3,26 +3,37 @@ package swarm
 import (
 	"bytes"
 	"errors"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/bittorrent/mse"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/dht"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/metainfo"
 	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/transport"
 	"github.com/majestrate/XD/lib/stats"
 	"github.com/majestrate/XD/lib/storage"
 	"github.com/majestrate/XD/lib/sync"
 	"github.com/majestrate/XD/lib/tracker"
+	"github.com/majestrate/XD/lib/unpack"
 	"github.com/majestrate/XD/lib/util"
 	"github.com/zeebo/bencode"
+	"math"
 	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
 	"time"
 )
 
 // max peers peer swarm default
 const DefaultMaxSwarmPeers = 50
 
+// DefaultIdleUploadTimeout is how long an unchoked peer may go without requesting anything before we choke it
+const DefaultIdleUploadTimeout = time.Minute * 2
+
 // rate name for upload
 const RateUpload = "upload"
 
@@ -33,32 +44,103 @@ var defaultRates = []string{RateDownload, RateUpload}
 
 // single torrent tracked in a swarm
 type Torrent struct {
-	TID              int64
-	addr             net.Addr
-	Completed        func()
-	Started          func()
-	Stopped          func()
-	RemoveSelf       func()
-	netacces         sync.Mutex
-	suspended        bool
-	Network          func() network.Network
-	Trackers         map[string]tracker.Announcer
-	announcers       map[string]*torrentAnnounce
-	announceMtx      sync.Mutex
-	announceTicker   *time.Ticker
-	id               common.PeerID
-	st               storage.Torrent
-	obconns          map[string]*PeerConn
-	ibconns          map[string]*PeerConn
-	connMtx          sync.Mutex
-	pt               *pieceTracker
-	defaultOpts      extensions.Message
-	closing          bool
-	started          bool
-	MaxRequests      int
-	MaxPeers         uint
+	TID        int64
+	addr       net.Addr
+	Completed  func()
+	Started    func()
+	Stopped    func()
+	RemoveSelf func()
+	// called once we got the metainfo for a magnet
+	GotMetaInfo func()
+	netacces    sync.Mutex
+	suspended   bool
+	Network     func() network.Network
+	Trackers    map[string]tracker.Announcer
+	announcers  map[string]*torrentAnnounce
+	// what trackers said when we last scraped them, guarded by announceMtx
+	scrapes        map[string]scrapeResult
+	announceMtx    sync.Mutex
+	announceTicker *time.Ticker
+	id             common.PeerID
+	st             storage.Torrent
+	obconns        map[string]*PeerConn
+	ibconns        map[string]*PeerConn
+	connMtx        sync.Mutex
+	pt             *pieceTracker
+	avail          *bittorrent.Availability
+	// check pieces against their hashes before we serve them if we didn't for this long, 0 never does
+	VerifyUploads time.Duration
+	// when we last checked pieces against their hashes and when we last checked all of them, guarded by verifiedMtx
+	verifiedAt    map[uint32]time.Time
+	allVerifiedAt time.Time
+	verifiedMtx   sync.Mutex
+	// how soon we get a turn in the active queue and how much of the bandwidth limits we get, accessed atomically
+	priority int32
+	// 1 if we run without waiting for a turn in the active queue, accessed atomically
+	forced int32
+	// blocks until we get a turn in the active queue, false if we were stopped while we waited, nil runs right away
+	waitTurn func() bool
+	// 1 while Start waits for a turn, accessed atomically
+	starting int32
+	// the active queue of our swarm, nil until the swarm starts us
+	queue *activeQueue
+	// outbound peers we lost that we dial again when the network comes back, guarded by connMtx
+	pastPeers map[string]pastPeer
+	// peers we had before we restarted and until when we keep slots free for them, guarded by connMtx
+	resuming    []net.Addr
+	resumeUntil time.Time
+	// pieces we don't download, ones we were told not to and ones only in skipped files, guarded by connMtx
+	mask *bittorrent.Bitfield
+	// pieces of high priority files and pieces of only low priority files, guarded by connMtx
+	highPieces *bittorrent.Bitfield
+	lowPieces  *bittorrent.Bitfield
+	// pieces a recheck found bad that we download before any others, guarded by connMtx
+	refetch *bittorrent.Bitfield
+	// when we want pieces by while streaming, guarded by connMtx
+	deadlines         map[uint32]time.Time
+	defaultOpts       extensions.Message
+	closing           bool
+	started           bool
+	MaxRequests       int
+	MaxPeers          uint
+	IdleUploadTimeout time.Duration
+	// how many peers with the best rates we unchoke besides the optimistic unchoke
+	UploadSlots int
+	// how long a peer keeps an upload slot while we seed and others wait for one, 0 or less keeps it as long as it is fastest
+	SeedSlotTurn time.Duration
+	// how long a peer may sit on our requests before we snub it and ask other peers, 0 or less never snubs
+	SnubTimeout time.Duration
+	// how often we announce to trackers, 0 for as often as each tracker asks, never more often than a tracker allows
+	AnnounceInterval time.Duration
+	// when we last picked who to unchoke, only used by rechoke
+	lastChoke time.Time
+	// the optimistic unchoke and when it got the slot, picked by rechoke and cleared when it closes
+	optimistic   *PeerConn
+	optimisticAt time.Time
+	chokeMtx     sync.Mutex
+	// how hard we try to connect to peers we learn about
+	Retry RetryPolicy
+	// how long we wait on peers when connecting and talking to them
+	Timeouts Timeouts
+	// when we go dormant and what we do while we are
+	Tiering TierPolicy
+	// 1 while we are dormant, accessed atomically
+	dormant int32
+	// 1 while no peer has the pieces we need, accessed atomically
+	unavailable int32
+	// when a peer last had pieces we need and how much we had downloaded then, only used by runTiering
+	availableAt time.Time
+	availDone   uint64
+	// writes events to the audit log, nothing if nil
+	audit func(audit.Event)
+	// how many bytes we ask peers for at once if they tell us they take requests that big
+	RequestBlockSize int
+	// flush pieces front to back on disk when downloading sequentially
+	SequentialFlush  bool
+	sequential       bool
 	pexState         PEXSwarmState
 	xdht             *dht.XDHT
+	remotes          *remotePeers
 	statsTracker     *stats.Tracker
 	tx               uint64
 	rx               uint64
@@ -67,14 +149,80 @@ type Torrent struct {
 	pendingInfoBF    *bittorrent.Bitfield
 	requestingInfoBF *bittorrent.Bitfield
 	puttingMetaInfo  bool
-	addedAt          time.Time
-	peersPool        sync.Pool
-	lastPEX          time.Time
-	pexInterval      time.Duration
+	// unix nanoseconds of when we last moved any data, accessed atomically
+	lastActive int64
+	// when we last wrote down lastActive, only used by the rate ticker
+	activeSaved   time.Time
+	peersPool     sync.Pool
+	lastPEX       time.Time
+	pexInterval   time.Duration
+	dials         *dialLimiter
+	announceDelay func() time.Duration
+	// what we uploaded and downloaded before this run
+	prevTX uint64
+	prevRX uint64
+	// the share ratio limit of the torrent, nil for DefaultRatioLimit, guarded by connMtx
+	ratioLimit *RatioLimit
+	// 1 once we acted on reaching the share ratio limit, accessed atomically
+	ratioReached int32
+	// share ratio limit of torrents without one of their own
+	DefaultRatioLimit RatioLimit
+	// announces running or waiting to run
+	announcing int32
+	// dht lookup running, accessed atomically
+	dhtAnnouncing int32
+	// when we next look up peers on the dht, guarded by announceMtx
+	nextDHTAnnounce time.Time
+	// pieces that failed verification since we started
+	verifyFailures uint64
+	// makes announcers for trackers peers tell us about, nil if it can't use the url
+	trackerFromURL func(string) tracker.Announcer
+	// trackers we added from lt_tex, guarded by announceMtx
+	learnedTrackers int
+	// add trackers we learn from peers to the stored metainfo
+	PersistLearnedTrackers bool
+	// peers that told us about peers over pex, keyed by ut_holepunch address
+	holepunchRelays sync.Map
+	// addresses of peers our trackers gave us, the only peers we dial for a private torrent
+	trackerPeers sync.Map
+	// what we do with the data once it is complete, nothing if nil
+	Unpack    *unpack.Config
+	unpack    unpack.Status
+	unpackMtx sync.Mutex
+	// wraps peer connections we dial, plain connections if nil
+	peerTransport transport.Transport
+	// when we encrypt connections to peers
+	encryption mse.Policy
+	// 1 while we reveal pieces to peers one at a time when seeding, accessed atomically
+	superseed int32
+	// the kind of network and the swarm we may only run on, empty and -1 for any
+	pinNetwork string
+	pinSwarm   int
+	// makes the http transports we reach web seeds with, no web seeds if nil
+	webSeedTransport func(*url.URL) (*http.Transport, error)
+	// download rate from peers in bytes per second below which we also use web seeds
+	WebSeedMinRate uint64
+	// nil until runWebSeeds loads them, only runWebSeeds sets it
+	webSeeds    []*webSeed
+	webSeedsMtx sync.Mutex
+	// where we wait for our turn to verify data, verifies right away if nil
+	verifier *verifyQueue
+	// 1 while we wait for our turn to verify, accessed atomically
+	verifyWaiting int32
+	// 1 while a recheck runs, accessed atomically
+	rechecking int32
+	// how many times we were stopped, waiting to verify gives up when it changes, accessed atomically
+	stops uint32
+	// wakes runUploads when a peer made a request or took a block
+	uploadWake chan struct{}
+	// 1 while runUploads serves requests, accessed atomically
+	uploading int32
+	// limits how fast we move piece data, unlimited if nil
+	bw *Bandwidth
 }
 
 func (t *Torrent) ShouldAcceptNewPeer() bool {
-	state := t.GetStatus().State
+	state := t.state()
 	return state == Downloading || state == Seeding
 }
 
@@ -87,10 +235,6 @@ func (t *Torrent) DownloadDir() string {
 	return t.st.DownloadDir()
 }
 
-func (t *Torrent) AddedAt() time.Time {
-	return t.addedAt
-}
-
 func (t *Torrent) Ready() bool {
 	return t.st.MetaInfo() != nil
 }
@@ -102,10 +246,13 @@ func (t *Torrent) Close() error {
 	}
 	t.closing = true
 	t.started = false
+	atomic.AddUint32(&t.stops, 1)
 	t.VisitPeers(func(c *PeerConn) {
 		c.Close()
 	})
 	t.saveStats()
+	// verified pieces still in memory would be lost
+	t.pt.flushHeld(true)
 	return t.st.Flush()
 }
 
@@ -138,23 +285,70 @@ func (t *Torrent) nextAnnounceFor(name string) (tm time.Time) {
 	return tm
 }
 
+// get the names of all our trackers, peers may add more while we announce
+func (t *Torrent) trackerNames() (names []string) {
+	t.announceMtx.Lock()
+	for name := range t.Trackers {
+		names = append(names, name)
+	}
+	t.announceMtx.Unlock()
+	return
+}
+
+// the extended handshake we send for a torrent whose info section is sz bytes, 0 if we don't have it
+func ourExtensions(sz uint32) extensions.Message {
+	opts := extensions.NewOur(sz)
+	// set default pex dialect supported
+	opts.SetSupported(DefaultPEXDialect)
+	// set ut_metadata supported
+	opts.SetSupported(extensions.UTMetaData)
+	opts.SetSupported(extensions.LTDontHave)
+	opts.SetSupported(extensions.LTTrackerExchange)
+	opts.SetSupported(extensions.UTHolepunch)
+	maxRequest := uint32(MaxBlockSize)
+	opts.MaxRequest = &maxRequest
+	reqq := uint32(PeerSendQueueSize)
+	opts.ReqQ = &reqq
+	return opts
+}
+
 var tIDCounter = int64(0)
 
 func newTorrent(st storage.Torrent, getNet func() network.Network) *Torrent {
 	t := &Torrent{
-		TID:          tIDCounter,
-		Trackers:     make(map[string]tracker.Announcer),
-		announcers:   make(map[string]*torrentAnnounce),
-		st:           st,
-		Network:      getNet,
-		ibconns:      make(map[string]*PeerConn),
-		obconns:      make(map[string]*PeerConn),
-		MaxRequests:  DefaultMaxParallelRequests,
-		MaxPeers:     DefaultMaxSwarmPeers,
-		statsTracker: stats.NewTracker(),
-		addedAt:      time.Now(),
-		lastPEX:      time.Now(),
-		pexInterval:  time.Minute * 2,
+		TID:               tIDCounter,
+		Trackers:          make(map[string]tracker.Announcer),
+		announcers:        make(map[string]*torrentAnnounce),
+		st:                st,
+		Network:           getNet,
+		ibconns:           make(map[string]*PeerConn),
+		obconns:           make(map[string]*PeerConn),
+		MaxRequests:       DefaultMaxParallelRequests,
+		MaxPeers:          DefaultMaxSwarmPeers,
+		IdleUploadTimeout: DefaultIdleUploadTimeout,
+		UploadSlots:       DefaultUploadSlots,
+		SeedSlotTurn:      DefaultSeedSlotTurn,
+		SnubTimeout:       DefaultSnubTimeout,
+		Retry:             DefaultRetryPolicy,
+		Timeouts:          DefaultTimeouts,
+		RequestBlockSize:  BlockSize,
+		WebSeedMinRate:    DefaultWebSeedMinRate,
+		statsTracker:      stats.NewTracker(),
+		lastPEX:           time.Now(),
+		pexInterval:       time.Minute * 2,
+		uploadWake:        make(chan struct{}, 1),
+	}
+	t.pinNetwork, t.pinSwarm = st.NetworkPin()
+	if p := TorrentPriority(st.Priority()); p.Valid() {
+		t.priority = int32(p)
+	}
+	if st.Forced() {
+		t.forced = 1
+	}
+	t.prevTX, t.prevRX = st.Totals()
+	t.ratioLimit = loadRatioLimit(st.RatioLimit())
+	if on, set := st.Superseed(); set && on {
+		t.superseed = 1
 	}
 	t.peersPool.New = func() interface{} { return &PeerConn{} }
 	tIDCounter++
@@ -165,35 +359,107 @@ func newTorrent(st storage.Torrent, getNet func() network.Network) *Torrent {
 		buff := new(bytes.Buffer)
 		info := t.st.MetaInfo().Info
 		bencode.NewEncoder(buff).Encode(&info)
-		t.defaultOpts = extensions.NewOur(uint32(buff.Len()))
+		t.defaultOpts = ourExtensions(uint32(buff.Len()))
 		t.metaInfo = buff.Bytes()
+		t.avail = bittorrent.NewAvailability(info.NumPieces())
+		t.mask = st.UnwantedPieces()
+		t.highPieces, t.lowPieces = st.PiecePriorities()
+		t.enforcePrivate()
 	} else {
-		t.defaultOpts = extensions.NewOur(0)
+		t.defaultOpts = ourExtensions(0)
 	}
-	// set default pex dialect supported
-	t.defaultOpts.SetSupported(DefaultPEXDialect)
-	// set ut_metadata supported
-	t.defaultOpts.SetSupported(extensions.UTMetaData)
 	t.pt = createPieceTracker(st, t.getRarestPiece)
 	t.pt.have = t.broadcastHave
+	t.pt.bad = t.onBadPiece
+	t.pt.blame = t.onBlame
+	t.pt.unwanted = t.unwantedPieces
 	return t
 }
 
+// SetSequential sets if we download pieces in order instead of rarest first
+func (t *Torrent) SetSequential(on bool) {
+	t.sequential = on
+	t.pt.setOrdered(on && t.SequentialFlush)
+}
+
+// Sequential returns true if we download pieces in order
+func (t *Torrent) Sequential() bool {
+	return t.sequential
+}
+
 func (t *Torrent) getRarestPiece(remote *bittorrent.Bitfield, exclude []uint32) (idx uint32, has bool) {
+	excluded := t.pieceExcluder(exclude)
+	idx, has = t.pickRefetch(remote, excluded)
+	if has {
+		return
+	}
+	idx, has = t.pickDeadlinePiece(remote, excluded)
+	if has {
+		return
+	}
+	return t.pickByPriority(remote, excluded)
+}
+
+// pick a piece of a high priority file first and a piece of only low priority files last, only called by getRarestPiece
+func (t *Torrent) pickByPriority(remote *bittorrent.Bitfield, excluded func(uint32) bool) (idx uint32, has bool) {
+	high, low := t.piecePriorities()
+	if high != nil {
+		idx, has = t.pickPiece(remote, func(i uint32) bool {
+			return !high.Has(i) || excluded(i)
+		})
+		if has {
+			return
+		}
+	}
+	if low != nil {
+		idx, has = t.pickPiece(remote, func(i uint32) bool {
+			return low.Has(i) || excluded(i)
+		})
+		if has {
+			return
+		}
+	}
+	return t.pickPiece(remote, excluded)
+}
+
+// pick a piece to get from remote in order or rarest first, only called by pickByPriority
+func (t *Torrent) pickPiece(remote *bittorrent.Bitfield, excluded func(uint32) bool) (idx uint32, has bool) {
+	if t.sequential {
+		return remote.FindFirst(excluded)
+	}
+	avail := t.availability()
+	if avail != nil {
+		idx, has = remote.FindRarestIn(avail, excluded)
+		return
+	}
 	var swarm []*bittorrent.Bitfield
 	t.VisitPeers(func(c *PeerConn) {
-		if c.bf != nil {
+		if c.bf != nil && !c.Distrusted() {
 			swarm = append(swarm, c.bf)
 		}
 	})
+	idx, has = remote.FindRarest(swarm, excluded)
+	return
+}
+
+// make a function that returns true for pieces we should not pick: ones we have, ones in exclude and masked ones
+func (t *Torrent) pieceExcluder(exclude []uint32) func(uint32) bool {
 	m := make(map[uint32]bool)
 	for idx := range exclude {
 		m[exclude[idx]] = true
 	}
 	bt := t.st.Bitfield()
-	idx, has = remote.FindRarest(swarm, func(idx uint32) bool {
-		return bt.Has(idx) || m[idx]
-	})
+	mask := t.unwantedPieces()
+	return func(idx uint32) bool {
+		return bt.Has(idx) || m[idx] || (mask != nil && mask.Has(idx))
+	}
+}
+
+// get piece availability among connected peers, nil if we don't have metadata yet
+func (t *Torrent) availability() (avail *bittorrent.Availability) {
+	t.connMtx.Lock()
+	avail = t.avail
+	t.connMtx.Unlock()
 	return
 }
 
@@ -239,6 +505,43 @@ func (t *Torrent) TX() (tx int64) {
 	return
 }
 
+// get the state this torrent is in
+func (t *Torrent) state() TorrentState {
+	if t.st.Checking() || t.waitingToVerify() {
+		return Checking
+	}
+	if t.QueuePosition() > 0 {
+		return Queued
+	}
+	if !t.Ready() {
+		return Downloading
+	}
+	if t.Done() {
+		return Seeding
+	} else if t.closing || !t.started {
+		return Stopped
+	}
+	return Downloading
+}
+
+// GetSummary gets the status of this torrent without any per peer or per file detail
+func (t *Torrent) GetSummary() (s TorrentSummary) {
+	s.Infohash = t.Infohash().Hex()
+	s.Name = t.Name()
+	s.State = t.state()
+	s.Dormant = t.Dormant()
+	s.Unavailable = t.Unavailable()
+	if t.Ready() {
+		s.Progress = t.progress()
+	}
+	t.VisitPeers(func(c *PeerConn) {
+		s.TX += c.tx.Mean()
+		s.RX += c.rx.Mean()
+		s.Peers++
+	})
+	return
+}
+
 func (t *Torrent) GetStatus() TorrentStatus {
 
 	var addr string
@@ -250,18 +553,26 @@ func (t *Torrent) GetStatus() TorrentStatus {
 	t.VisitPeers(func(c *PeerConn) {
 		peers = append(peers, c.Stats())
 	})
-	state := Downloading
-	if t.st.Checking() {
-		state = Checking
-	}
+	state := t.state()
+	uploaded, downloaded := t.Totals()
+	ratioLimit, defaultRatio := t.RatioLimit()
 	if !t.Ready() {
 		return TorrentStatus{
-			Peers:    peers,
-			Name:     name,
-			State:    state,
-			Infohash: t.st.Infohash().Hex(),
-			TX:       t.tx,
-			RX:       t.rx,
+			Peers:         peers,
+			Name:          name,
+			State:         state,
+			Infohash:      t.st.Infohash().Hex(),
+			TX:            t.tx,
+			RX:            t.rx,
+			Trackers:      t.trackerStatus(),
+			AddedAt:       t.AddedAt(),
+			Owner:         t.Owner(),
+			PinnedNetwork: t.pinNetwork,
+			PinnedSwarm:   t.pinSwarm,
+			Uploaded:      uploaded,
+			Downloaded:    downloaded,
+			RatioLimit:    ratioLimit,
+			OwnRatio:      !defaultRatio,
 			Us: PeerConnStats{
 				TX:     float64(t.TX()),
 				RX:     float64(t.RX()),
@@ -271,68 +582,67 @@ func (t *Torrent) GetStatus() TorrentStatus {
 			},
 		}
 	}
-	if t.Done() {
-		state = Seeding
-	} else if t.closing || !t.started {
-		state = Stopped
-	}
-	if t.st.Checking() {
-		state = Checking
-	}
-
 	bf := t.Bitfield()
 	var files []TorrentFileInfo
-	nfo := t.st.MetaInfo().Info
-	var idx uint64
-	f := nfo.GetFiles()
-	if len(f) == 1 {
-		b := bittorrent.Bitfield{
-			Data:   bf.Data,
-			Length: bf.Length,
+	meta := t.st.MetaInfo()
+	var off uint64
+	skipped := make(map[int]bool)
+	for _, idx := range t.SkippedFiles() {
+		skipped[idx] = true
+	}
+	prios := t.FilePriorities()
+	for idx, file := range meta.Info.GetFiles() {
+		progress := 1.0
+		if file.Length > 0 {
+			progress = math.Min(float64(meta.BytesIn(bf.Has, off, file.Length))/float64(file.Length), 1)
 		}
 		files = append(files, TorrentFileInfo{
-			FileInfo: f[0],
-			Progress: b.Progress(),
+			FileInfo: file,
+			Progress: progress,
+			Skipped:  skipped[idx],
+			Priority: prios[idx].String(),
 		})
-	} else {
-		for _, file := range f {
-			l := file.Length / uint64(nfo.PieceLength)
-			// XXX: this below here is wrong because how the bits are packed in the bitfield
-			l /= 8
-			plen := l
-			var data []byte
-			if l == 0 {
-				data = []byte{bf.Data[idx]}
-				plen = 1
-			} else if idx+l < uint64(len(bf.Data)) {
-				data = bf.Data[idx : idx+l]
-			} else {
-				data = bf.Data[idx:]
-			}
-			b := bittorrent.Bitfield{
-				Data:   data,
-				Length: uint32(plen),
-			}
-			files = append(files, TorrentFileInfo{
-				FileInfo: file,
-				Progress: b.Progress(),
-			})
-			idx += l
-		}
+		off += file.Length
 	}
-	b := bittorrent.Bitfield{
-		Data:   bf.Data,
-		Length: bf.Length,
+	wanted := t.st.WantedSize()
+	var done uint64
+	if remaining := t.st.DownloadRemaining(); remaining < wanted {
+		done = wanted - remaining
 	}
+	progress := t.progress()
 	return TorrentStatus{
-		Peers:    peers,
-		Name:     name,
-		State:    state,
-		Infohash: t.MetaInfo().Infohash().Hex(),
-		Progress: b.Progress(),
-		Files:    files,
-		TX:       t.tx,
-		RX:       t.rx,
+		Peers:          peers,
+		Name:           name,
+		State:          state,
+		Infohash:       t.MetaInfo().Infohash().Hex(),
+		Progress:       progress,
+		Files:          files,
+		TX:             t.tx,
+		RX:             t.rx,
+		Trackers:       t.trackerStatus(),
+		Wanted:         wanted,
+		Done:           done,
+		Remaining:      wanted - done,
+		VerifyFailures: t.VerifyFailures(),
+		Unpack:         t.UnpackStatus(),
+		AddedAt:        t.AddedAt(),
+		CompletedAt:    t.CompletedAt(),
+		LastActive:     t.LastActive(),
+		Owner:          t.Owner(),
+		PinnedNetwork:  t.pinNetwork,
+		PinnedSwarm:    t.pinSwarm,
+		Dormant:        t.Dormant(),
+		Superseed:      t.Superseed(),
+		Priority:       t.Priority().String(),
+		QueuePosition:  t.QueuePosition(),
+		Forced:         t.Forced(),
+		Uploaded:       uploaded,
+		Downloaded:     downloaded,
+		ShareRatio:     t.ShareRatio(),
+		RatioLimit:     ratioLimit,
+		OwnRatio:       !defaultRatio,
+		WebSeeds:       t.WebSeeds(),
+		Unavailable:    t.Unavailable(),
 		Us: PeerConnStats{
 			TX:     float64(t.TX()),
 			RX:     float64(t.RX()),
@@ -343,24 +653,74 @@ func (t *Torrent) GetStatus() TorrentStatus {
 	}
 }
 
+// get what we know from each of our trackers, trackers we never announced to only have what their scrape said
+func (t *Torrent) trackerStatus() (trackers []TrackerStatus) {
+	var announcers []*torrentAnnounce
+	var names []string
+	scrapes := make(map[string]scrapeResult)
+	t.announceMtx.Lock()
+	for name := range t.Trackers {
+		if a, ok := t.announcers[name]; ok {
+			announcers = append(announcers, a)
+		} else {
+			names = append(names, name)
+		}
+	}
+	for name, sc := range t.scrapes {
+		scrapes[name] = sc
+	}
+	t.announceMtx.Unlock()
+	for _, a := range announcers {
+		trackers = append(trackers, a.status())
+	}
+	for _, name := range names {
+		trackers = append(trackers, TrackerStatus{
+			Name:      name,
+			Seeders:   -1,
+			Leechers:  -1,
+			Completed: -1,
+		})
+	}
+	for idx := range trackers {
+		if sc, ok := scrapes[trackers[idx].Name]; ok {
+			trackers[idx].addScrape(sc)
+		}
+	}
+	return
+}
+
 func (t *Torrent) Bitfield() *bittorrent.Bitfield {
 	return t.st.Bitfield()
 }
 
-// manually announce as seed to all trackers
+// manually announce as seed to all trackers, they hear we completed even before they let us announce again
 // blocks until done
 func (t *Torrent) AnnounceSeed() {
 	var wg sync.WaitGroup
-	for name := range t.Trackers {
+	for _, name := range t.trackerNames() {
 		wg.Add(1)
-		go func() {
-			t.announce(name, tracker.Completed)
+		go func(name string) {
+			t.announceCompleted(name)
 			wg.Add(-1)
-		}()
+		}(name)
 	}
 	wg.Wait()
 }
 
+// tell a tracker we completed the torrent right away
+func (t *Torrent) announceCompleted(name string) {
+	t.announceMtx.Lock()
+	a := t.announcers[name]
+	t.announceMtx.Unlock()
+	if a == nil {
+		return
+	}
+	a.access.Lock()
+	a.next = time.Time{}
+	a.access.Unlock()
+	t.announce(name, tracker.Completed)
+}
+
 // start annoucing on all trackers
 func (t *Torrent) StartAnnouncing() {
 	// wait for network
@@ -369,7 +729,7 @@ func (t *Torrent) StartAnnouncing() {
 	if t.Done() {
 		ev = tracker.Completed
 	}
-	for name := range t.Trackers {
+	for _, name := range t.trackerNames() {
 		t.nextAnnounceFor(name)
 		go t.announce(name, ev)
 	}
@@ -387,7 +747,7 @@ func (t *Torrent) StopAnnouncing(announce bool) {
 	}
 	if announce {
 		var wg sync.WaitGroup
-		for n := range t.Trackers {
+		for _, n := range t.trackerNames() {
 			wg.Add(1)
 			go func(name string) {
 				log.Debugf("%s stopping", name)
@@ -412,15 +772,21 @@ func (t *Torrent) pollAnnounce() {
 		if t.Done() {
 			ev = tracker.Completed
 		}
-		for name := range t.Trackers {
+		for _, name := range t.trackerNames() {
 			if t.shouldAnnounce(name) {
 				t.announce(name, ev)
 			}
 		}
+		if t.dhtAnnounceDue() {
+			go t.announceDHT()
+		}
 	}
 }
 
 func (t *Torrent) announce(name string, ev tracker.Event) {
+	if !t.onPinnedNetwork() {
+		return
+	}
 	t.announceMtx.Lock()
 	a := t.announcers[name]
 	t.announceMtx.Unlock()
@@ -436,47 +802,114 @@ func (t *Torrent) announce(name string, ev tracker.Event) {
 }
 
 // add peers to torrent
+// peers are dialed highest canonical priority first
 func (t *Torrent) addPeers(peers []common.Peer) {
+	t.dialPeers(peers, false)
+}
+
+// dial peers, fromTracker is set if our trackers gave them to us
+func (t *Torrent) dialPeers(peers []common.Peer, fromTracker bool) {
+	if t.Dormant() {
+		// dormant torrents wait for peers to come to them
+		return
+	}
+	reserved := t.resumeReserved(time.Now())
+	full := func() bool {
+		return !t.NeedsPeers() || t.NumPeers()+reserved > t.MaxPeers
+	}
+	if full() {
+		// no more peers needed, don't bother resolving any
+		return
+	}
+	n := t.Network()
+	var addrs []net.Addr
+	// peers we have to ask the network about, only looked up while we still need peers
+	var lookups []common.Peer
+	ids := make(map[string]common.PeerID)
 	for _, p := range peers {
-		if !t.NeedsPeers() {
-			// no more peers needed
+		if p.NeedsLookup(n) {
+			lookups = append(lookups, p)
+			continue
+		}
+		if a, ok := t.resolvePeer(&p, fromTracker); ok {
+			addrs = append(addrs, a)
+			ids[a.String()] = p.ID
+		}
+	}
+	t.sortByPriority(addrs)
+	for _, a := range addrs {
+		if full() {
 			return
 		}
-		a, e := p.Resolve(t.Network())
-		if e == nil {
-			if a.String() == t.Network().Addr().String() {
-				// don't connect to self or a duplicate
-				continue
-			}
-			if t.HasOBConn(a) {
-				continue
-			}
-			// no error resolving
-			go t.PersistPeer(a, p.ID)
-		} else {
-			log.Warnf("failed to resolve peer %s", e.Error())
+		go t.PersistPeer(a, ids[a.String()])
+	}
+	for idx := range lookups {
+		if full() {
+			return
+		}
+		if a, ok := t.resolvePeer(&lookups[idx], fromTracker); ok {
+			go t.PersistPeer(a, lookups[idx].ID)
 		}
 	}
 }
 
+// resolve a peer to dial, false if it failed or it is us or a peer we are connected to already
+func (t *Torrent) resolvePeer(p *common.Peer, fromTracker bool) (net.Addr, bool) {
+	a, err := p.Resolve(t.Network())
+	if err != nil {
+		log.Warnf("failed to resolve peer %s", err.Error())
+		return nil, false
+	}
+	if fromTracker && t.Private() {
+		t.rememberTrackerPeer(a, time.Now())
+	}
+	if a.String() == t.Network().Addr().String() || t.HasOBConn(a) {
+		return nil, false
+	}
+	return a, true
+}
+
 // persit a connection to a peer
+// destinations that keep failing are dialed less often, see RetryPolicy
 func (t *Torrent) PersistPeer(a net.Addr, id common.PeerID) {
 
-	triesLeft := 10
-	for !t.closing {
+	triesLeft := t.Retry.Tries
+	punched := false
+	// wait out failures from earlier attempts before the first dial
+	wait := t.Retry.delay(t.remotes.dialFailures(a))
+	for !t.closing && !t.Dormant() {
+		if t.remotes.banned(a.String()) || !t.mayDial(a) {
+			return
+		}
+		if wait > 0 {
+			wait -= time.Second
+			time.Sleep(time.Second)
+			continue
+		}
 		if t.HasIBConn(a) {
 			return
 		}
 		if !t.HasOBConn(a) {
 			err := t.DialPeer(a, id)
+			if err == ErrPinned {
+				// won't change until we switch networks
+				return
+			}
+			fails := t.remotes.dialed(a, t.Infohash(), err)
 			if err == nil {
 				return
 			} else {
 				triesLeft--
 			}
+			if !punched {
+				// they may not take inbound connections, have them connect to us
+				punched = t.rendezvous(a)
+			}
 			if triesLeft <= 0 {
+				log.Debugf("giving up on %s after %d failures in a row", a, fails)
 				return
 			}
+			wait = t.Retry.delay(fails)
 		} else {
 			time.Sleep(time.Second)
 		}
@@ -497,12 +930,17 @@ func (t *Torrent) HasOBConn(a net.Addr) (has bool) {
 	return
 }
 
-func (t *Torrent) addOBPeer(c *PeerConn) {
+// add outbound peer, returns false if that remote is already connected to us for this torrent
+func (t *Torrent) addOBPeer(c *PeerConn) bool {
+	if !t.remotes.claim(c) {
+		return false
+	}
 	addr := c.c.RemoteAddr()
 	t.connMtx.Lock()
 	t.obconns[addr.String()] = c
 	t.connMtx.Unlock()
-	t.pexState.onNewPeer(addr)
+	t.pexState.onNewPeer(addr, c.pexFlags())
+	return true
 }
 
 func (t *Torrent) removeOBConn(c *PeerConn) {
@@ -510,16 +948,23 @@ func (t *Torrent) removeOBConn(c *PeerConn) {
 	t.connMtx.Lock()
 	delete(t.obconns, addr.String())
 	t.connMtx.Unlock()
+	t.rememberPeer(addr, c.id, time.Now())
+	t.remotes.release(c)
 	t.pexState.onPeerDisconnected(addr)
 }
 
-func (t *Torrent) addIBPeer(c *PeerConn) {
+// add inbound peer, returns false if that remote is already connected to us for this torrent
+func (t *Torrent) addIBPeer(c *PeerConn) bool {
+	c.inbound = true
+	if !t.remotes.claim(c) {
+		return false
+	}
 	addr := c.c.RemoteAddr()
 	t.connMtx.Lock()
 	t.ibconns[addr.String()] = c
 	t.connMtx.Unlock()
-	c.inbound = true
-	t.pexState.onNewPeer(addr)
+	t.pexState.onNewPeer(addr, c.pexFlags())
+	return true
 }
 
 func (t *Torrent) removeIBConn(c *PeerConn) {
@@ -527,6 +972,7 @@ func (t *Torrent) removeIBConn(c *PeerConn) {
 	t.connMtx.Lock()
 	delete(t.ibconns, addr.String())
 	t.connMtx.Unlock()
+	t.remotes.release(c)
 	t.pexState.onPeerDisconnected(addr)
 }
 
@@ -553,11 +999,8 @@ func (t *Torrent) resetPendingInfo() {
 
 func (t *Torrent) askAllMetadata() {
 	t.VisitPeers(func(c *PeerConn) {
-		if c.theirOpts.MetaData() {
-			id, ok := c.theirOpts.Extensions[extensions.UTMetaData.String()]
-			if ok {
-				c.askNextMetadata(uint8(id))
-			}
+		if id, ok := c.theirExt.ID(extensions.UTMetaData); ok {
+			c.askNextMetadata(id)
 		}
 	})
 }
@@ -584,9 +1027,16 @@ func (t *Torrent) putInfoSlice(idx uint32, data []byte) {
 				// reset
 				sz := uint32(len(t.metaInfo))
 				t.defaultOpts.MetainfoSize = &sz
+				t.enforcePrivate()
+				t.connMtx.Lock()
+				t.avail = bittorrent.NewAvailability(info.NumPieces())
+				t.connMtx.Unlock()
 				t.VisitPeers(func(p *PeerConn) {
 					p.Close()
 				})
+				if t.GotMetaInfo != nil {
+					t.GotMetaInfo()
+				}
 			} else {
 				t.puttingMetaInfo = false
 				log.Errorf("failed to get meta info %s", err.Error())
@@ -624,15 +1074,34 @@ func (t *Torrent) DialPeer(a net.Addr, id common.PeerID) error {
 	if t.HasOBConn(a) {
 		return nil
 	}
+	if !t.onPinnedNetwork() {
+		return ErrPinned
+	}
+	if !t.mayDial(a) {
+		return ErrNotTrackerPeer
+	}
 	ih := t.st.Infohash()
 	log.Debugf("%s %s ", a.String(), a.Network())
-	c, err := t.Network().Dial(a.Network(), a.String())
+	if t.dials != nil {
+		t.dials.acquire()
+	}
+	encrypt := t.encryption.Encrypts() && mseApplies(a)
+	c, err := t.dialConn(a, encrypt)
+	if _, ok := err.(encryptionError); ok && t.encryption.AllowsPlaintext() {
+		log.Debugf("%s didn't encrypt: %s, trying plaintext", a, err)
+		c, err = t.dialConn(a, false)
+	}
+	if t.dials != nil {
+		t.dials.release()
+	}
 	if err == nil {
 		// connected
+		setDeadline(c, t.Timeouts.Handshake)
 		// build handshake
 		var h bittorrent.Handshake
 		// enable bittorrent extensions
 		h.Reserved.Set(bittorrent.Extension)
+		h.Reserved.Set(bittorrent.FastExtension)
 		copy(h.Infohash[:], ih[:])
 		copy(h.PeerID[:], t.id[:])
 		// send handshake
@@ -643,15 +1112,23 @@ func (t *Torrent) DialPeer(a net.Addr, id common.PeerID) error {
 			if err == nil {
 				if bytes.Equal(ih[:], h.Infohash[:]) {
 					// infohashes match
+					setDeadline(c, 0)
 					var opts extensions.Message
 					if h.Reserved.Has(bittorrent.Extension) {
 						opts = t.defaultOpts.Copy()
 					}
 					pc := makePeerConn(c, t, h.PeerID, opts)
-					t.addOBPeer(pc)
+					pc.fast = h.Reserved.Has(bittorrent.FastExtension)
+					pc.pickAllowedFast()
+					if !t.addOBPeer(pc) {
+						log.Debugf("%s already connected for %s", a, t.Name())
+						c.Close()
+						return nil
+					}
 					pc.start()
 					if t.Ready() {
-						pc.Send(t.Bitfield().ToWireMessage())
+						pc.sendBitfield()
+						pc.sendAllowedFast()
 					}
 					return nil
 				} else {
@@ -670,6 +1147,8 @@ func (t *Torrent) DialPeer(a net.Addr, id common.PeerID) error {
 func (t *Torrent) broadcastHave(idx uint32) {
 	msg := common.NewHave(idx)
 	log.Debugf("%s got piece %d", t.Name(), idx)
+	t.markVerified(idx, time.Now())
+	t.refetched(idx)
 	conns := make(map[string]*PeerConn)
 	t.VisitPeers(func(c *PeerConn) {
 		conns[c.c.RemoteAddr().String()] = c
@@ -679,6 +1158,37 @@ func (t *Torrent) broadcastHave(idx uint32) {
 	}
 }
 
+// count a piece that failed verification against the torrent and every peer that sent us some of it
+func (t *Torrent) onBadPiece(idx uint32, from []string) {
+	n := atomic.AddUint64(&t.verifyFailures, 1)
+	log.Warnf("piece %d of %s failed verification, %d failures so far, sent by %v", idx, t.Name(), n, from)
+	senders := make(map[string]bool)
+	for _, addr := range from {
+		senders[addr] = true
+	}
+	t.VisitPeers(func(c *PeerConn) {
+		if senders[c.c.RemoteAddr().String()] {
+			atomic.AddUint32(&c.badPieces, 1)
+		}
+	})
+	for _, addr := range from {
+		t.remotes.badPiece(addr)
+	}
+}
+
+// VerifyFailures returns how many pieces failed verification since we started
+func (t *Torrent) VerifyFailures() uint64 {
+	return atomic.LoadUint64(&t.verifyFailures)
+}
+
+// tell peers we no longer have a piece, peers without lt_donthave keep thinking we have it
+func (t *Torrent) broadcastDontHave(idx uint32) {
+	log.Debugf("%s lost piece %d", t.Name(), idx)
+	t.VisitPeers(func(c *PeerConn) {
+		c.sendDontHave(idx)
+	})
+}
+
 // get metainfo for this torrent
 func (t *Torrent) MetaInfo() *metainfo.TorrentFile {
 	return t.st.MetaInfo()
@@ -691,6 +1201,11 @@ func (t *Torrent) Name() string {
 	return t.Infohash().Hex()
 }
 
+// Owner gets the rpc user that added this torrent, empty if nobody did
+func (t *Torrent) Owner() string {
+	return t.st.Owner()
+}
+
 // return false if we reached max peers for this torrent
 func (t *Torrent) NeedsPeers() bool {
 	return t.NumPeers() <= t.MaxPeers
@@ -704,11 +1219,17 @@ func (t *Torrent) onNewPeer(c *PeerConn) {
 		c.Close()
 		return
 	}
-	if t.NeedsPeers() && t.Ready() {
+	if t.Ready() && (t.NeedsPeers() || t.evictFor(a)) {
 		log.Debugf("New peer (%s) for %s", c.id.String(), t.st.Infohash().Hex())
-		t.addIBPeer(c)
+		if !t.addIBPeer(c) {
+			log.Debugf("duplicate peer from %s", a)
+			c.c.Close()
+			return
+		}
+		c.pickAllowedFast()
 		c.start()
-		c.Send(t.Bitfield().ToWireMessage())
+		c.sendBitfield()
+		c.sendAllowedFast()
 	} else {
 		c.Close()
 	}
@@ -720,10 +1241,12 @@ func (t *Torrent) Infohash() common.Infohash {
 
 func (t *Torrent) run() {
 	if t.Started != nil {
-		go t.Started()
+		t.Started()
 	}
 	t.started = true
 	go t.runRateTicker()
+	go t.runWebSeeds()
+	go t.runUploads()
 	counter := 0
 	for !t.closing {
 		if !t.Ready() {
@@ -740,13 +1263,13 @@ func (t *Torrent) run() {
 			continue
 		}
 		if t.Done() {
-			if t.seeding {
-				break
-			} else {
+			// keep going once seeding so we seed again if a recheck made us download pieces again
+			if !t.seeding {
 				var err error
-				t.seeding, err = t.st.Seed()
+				t.seeding, err = t.seed()
 				if t.seeding {
 					log.Infof("%s is seeding", t.Name())
+					t.startUnpack()
 					t.AnnounceSeed()
 				} else if err != nil {
 					log.Errorf("failed to begin seeding: %s", err.Error())
@@ -778,16 +1301,20 @@ func (t *Torrent) tick() {
 		if now.Sub(t.lastPEX) > t.pexInterval {
 			la := t.Network().Addr()
 			if la.Network() == "i2p" {
-				connected, disconnected := t.pexState.PopDestHashLists()
+				// peers may have become seeds since they connected
+				t.VisitPeers(func(p *PeerConn) {
+					t.pexState.setFlags(p.c.RemoteAddr(), p.pexFlags())
+				})
+				connected, flags, disconnected := t.pexState.PopDestHashLists(extensions.MaxPEXPeers)
 				t.VisitPeers(func(p *PeerConn) {
 					if p.SupportsI2PPEX() {
-						p.sendI2PPEX(connected, disconnected)
+						p.sendI2PPEX(connected, flags, disconnected)
 					}
 				})
 			} else {
 				var connected []common.Peer
 				t.VisitPeers(func(p *PeerConn) {
-					if len(connected) < 15 {
+					if len(connected) < extensions.MaxPEXPeers {
 						connected = append(connected, p.btPeer())
 					}
 				})
@@ -797,10 +1324,17 @@ func (t *Torrent) tick() {
 					}
 				})
 			}
+			trackers := t.texTrackers()
+			t.VisitPeers(func(p *PeerConn) {
+				p.sendTEX(trackers)
+			})
 			t.lastPEX = now
 		}
 	}
 
+	t.unchokeAsking()
+	t.rechoke(time.Now())
+
 	if t.Done() {
 		return
 	}
@@ -820,37 +1354,60 @@ func (t *Torrent) tick() {
 
 func (t *Torrent) handlePieceRequest(c *PeerConn, r *common.PieceRequest) {
 
+	if c.Chocking() && !c.servesWhileChoked(r.Index) {
+		log.Debugf("%s asked for piece %d while choked", c.id.String(), r.Index)
+		// peers without the fast extension know requests made while choked are dropped
+		if c.fast {
+			c.Send(r.Reject())
+		}
+		return
+	}
+
 	if r.Length > 0 {
-		var pc common.PieceData
 		log.Debugf("%s asked for piece %d %d-%d", c.id.String(), r.Index, r.Begin, r.Begin+r.Length)
-		if r.Length <= uint32(cap(c.sendPieceBuff)) {
-			pc.Data = c.sendPieceBuff[:r.Length]
-			err := t.st.GetPiece(*r, &pc)
-			if err == nil {
-				// have the piece, send it
-				c.Send(pc.ToWireMessage())
-				log.Debugf("%s queued piece %d %d-%d", c.id.String(), r.Index, r.Begin, r.Begin+r.Length)
+		if r.Length <= MaxBlockSize {
+			// served in turn with the requests of other peers by runUploads
+			if c.queueUpload(*r) {
+				t.wakeUploads()
 			} else {
-				c.Close()
+				log.Debugf("%s asked for more than %d blocks at once", c.id.String(), MaxUploadQueue)
+				c.rejectRequest(r)
 			}
 		} else {
 			log.Infof("%s asked for oversized piece bytes=%d", c.id.String(), r.Length)
-			c.Close()
+			c.rejectRequest(r)
 		}
 	} else {
 		log.Infof("%s asked for a zero length piece", c.id.String())
-		// TODO: should we close here?
-		c.Close()
+		c.rejectRequest(r)
 	}
 
 }
 
+// Done returns true if we have every piece we want
 func (t *Torrent) Done() bool {
 	bf := t.Bitfield()
 	if bf == nil {
 		return false
 	}
-	return bf.Completed()
+	if bf.Completed() {
+		return true
+	}
+	mask := t.unwantedPieces()
+	return mask != nil && bf.OR(mask).Completed()
+}
+
+// how much of what we want we have, from 0 to 1
+func (t *Torrent) progress() float64 {
+	wanted := t.st.WantedSize()
+	remaining := t.st.DownloadRemaining()
+	if wanted == 0 || remaining == 0 {
+		return 1.0
+	}
+	if remaining > wanted {
+		return 0
+	}
+	return float64(wanted-remaining) / float64(wanted)
 }
 
 var ErrAlreadyStopped = errors.New("torrent already stopped")
@@ -859,13 +1416,28 @@ var ErrAlreadyStarted = errors.New("torrent already started")
 func (t *Torrent) runRateTicker() {
 	for t.started {
 		time.Sleep(time.Second)
-		t.tx += t.statsTracker.Rate(RateUpload).Current()
-		t.rx += t.statsTracker.Rate(RateDownload).Current()
+		tx := t.statsTracker.Rate(RateUpload).Current()
+		rx := t.statsTracker.Rate(RateDownload).Current()
+		t.tx += tx
+		t.rx += rx
 		t.statsTracker.Tick()
+		t.tickActivity(time.Now(), tx, rx)
+		t.checkRatio()
 	}
+	t.saveActivity()
 }
 
+// Stop stops the torrent and keeps it stopped across restarts
 func (t *Torrent) Stop() error {
+	err := t.stop()
+	if err == nil {
+		err = t.st.SetPaused(true)
+	}
+	return err
+}
+
+// stop the torrent without remembering it, used when shutting down
+func (t *Torrent) stop() error {
 	if t.closing {
 		return ErrAlreadyStopped
 	}
@@ -877,7 +1449,6 @@ func (t *Torrent) Stop() error {
 	if t.Stopped != nil {
 		t.Stopped()
 	}
-	t.RemoveSelf()
 	log.Info("stopped")
 	return err
 }
@@ -893,19 +1464,42 @@ func (t *Torrent) Delete() error {
 }
 
 func (t *Torrent) Remove() error {
-	err := t.Stop()
-	if err != nil {
+	err := t.stop()
+	if err != nil && err != ErrAlreadyStopped {
 		return err
 	}
 	t.RemoveSelf()
 	return nil
 }
 
+// Start starts the torrent once it gets a turn in the active queue, right away if it is forced, and keeps it started across restarts
 func (t *Torrent) Start() error {
+	if t.started || !atomic.CompareAndSwapInt32(&t.starting, 0, 1) {
+		return ErrAlreadyStarted
+	}
+	err := t.st.SetPaused(false)
+	if err != nil {
+		atomic.StoreInt32(&t.starting, 0)
+		return err
+	}
+	// stopping it again while it waits gives up its place
+	t.closing = false
+	go func() {
+		defer atomic.StoreInt32(&t.starting, 0)
+		if t.waitTurn == nil || t.waitTurn() {
+			t.start()
+		}
+	}()
+	return nil
+}
+
+func (t *Torrent) start() error {
 	if t.started {
 		return ErrAlreadyStarted
 	}
 	t.closing = false
+	t.loadDialHistory()
+	go t.resumePeers()
 	t.StartAnnouncing()
 	go t.run()
 	return nil
@@ -0,0 +1,60 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+)
+
+// what kind of traffic a message we send to a peer is, the lower the sooner it goes out
+type trafficClass int
+
+// keep-alives, chokes, interest, haves, requests, cancels and rejects, small messages that time out the connection or stall the swarm when they are late
+const trafficControl = trafficClass(0)
+
+// extension messages like the extension handshake, pex, tex and metadata, they may be late but must not wait behind every block we upload
+const trafficGossip = trafficClass(1)
+
+// blocks we upload, they fill the link so everything else goes before them
+const trafficData = trafficClass(2)
+
+// how many kinds of traffic we queue apart
+const numTrafficClasses = 3
+
+// tell what kind of traffic a message is
+func classifyTraffic(msg common.WireMessage) trafficClass {
+	if msg.KeepAlive() {
+		return trafficControl
+	}
+	switch msg.MessageID() {
+	case common.Piece:
+		return trafficData
+	case common.Extended:
+		return trafficGossip
+	default:
+		return trafficControl
+	}
+}
+
+// write everything we queued that is more urgent than class, called by run before it writes a message of that class
+func (c *PeerConn) sendUrgent(class trafficClass) bool {
+	for _, q := range c.sendq[:class] {
+		for more := true; more; {
+			select {
+			case msg := <-q:
+				if !c.write(msg) {
+					return false
+				}
+			default:
+				more = false
+			}
+		}
+	}
+	return true
+}
+
+// how many messages of every kind wait to be written to the peer
+func (c *PeerConn) queued() (n int) {
+	for _, q := range c.sendq {
+		n += len(q)
+	}
+	return
+}
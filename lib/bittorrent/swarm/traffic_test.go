@@ -0,0 +1,55 @@
+package swarm
+
+import (
+	"bytes"
+	"github.com/majestrate/XD/lib/common"
+	"testing"
+)
+
+func testSendQueues(n int) (q [numTrafficClasses]chan common.WireMessage) {
+	for idx := range q {
+		q[idx] = make(chan common.WireMessage, n)
+	}
+	return
+}
+
+func TestClassifyTraffic(t *testing.T) {
+	for _, c := range []struct {
+		msg   common.WireMessage
+		class trafficClass
+	}{
+		{common.KeepAlive, trafficControl},
+		{common.NewWireMessage(common.Choke, nil), trafficControl},
+		{common.NewHave(1), trafficControl},
+		{common.NewCancel(1, 0, BlockSize), trafficControl},
+		{common.NewWireMessage(common.Extended, []byte{1}), trafficGossip},
+		{common.PieceData{Data: make([]byte, BlockSize)}.ToWireMessage(), trafficData},
+	} {
+		if class := classifyTraffic(c.msg); class != c.class {
+			t.Errorf("%s is traffic class %d not %d", c.msg.MessageID(), class, c.class)
+		}
+	}
+}
+
+func TestSendUrgentFirst(t *testing.T) {
+	c := &PeerConn{sendq: testSendQueues(4)}
+	pex := common.NewWireMessage(common.Extended, []byte{1, 'd', 'e'})
+	choke := common.NewWireMessage(common.Choke, nil)
+	c.Send(pex)
+	c.Send(choke)
+	if !c.sendUrgent(trafficGossip) {
+		t.Fatal("write failed")
+	}
+	if c.queued() != 1 || !bytes.Equal(c.writeBuff.Bytes(), choke) {
+		t.Fatal("control traffic was not written on its own")
+	}
+	c.writeBuff.Reset()
+	c.Send(common.NewHave(3))
+	if !c.sendUrgent(trafficData) {
+		t.Fatal("write failed")
+	}
+	expect := append(append([]byte{}, common.NewHave(3)...), pex...)
+	if c.queued() != 0 || !bytes.Equal(c.writeBuff.Bytes(), expect) {
+		t.Fatal("control and gossip traffic did not go out in order of urgency")
+	}
+}
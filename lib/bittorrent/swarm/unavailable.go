@@ -0,0 +1,94 @@
+package swarm
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/audit"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+	"time"
+)
+
+// Unavailable returns true if no peer had any piece we still need for a long time
+// unavailable torrents announce rarely and keep no peers that have nothing for us until someone has the pieces again
+func (t *Torrent) Unavailable() bool {
+	return atomic.LoadInt32(&t.unavailable) == 1
+}
+
+// returns true if we still want pieces and have the metainfo to know which
+func (t *Torrent) wantsPieces() bool {
+	return t.started && !t.closing && t.Ready() && !t.Done() && t.st.DownloadRemaining() > 0
+}
+
+// returns true if bf has a piece we still need
+func (t *Torrent) hasNeeded(bf *bittorrent.Bitfield) bool {
+	if bf == nil {
+		return false
+	}
+	_, has := bf.FindFirst(t.pieceExcluder(nil))
+	return has
+}
+
+// returns true if a connected peer has a piece we still need
+func (t *Torrent) peersHaveNeeded() bool {
+	has := false
+	t.VisitPeers(func(c *PeerConn) {
+		if !has && t.hasNeeded(c.Bitfield()) {
+			has = true
+		}
+	})
+	return has
+}
+
+// mark the torrent unavailable if nobody had what we need for Tiering.UnavailableAfter, only called by runTiering
+func (t *Torrent) checkAvailable(now time.Time) {
+	if !t.wantsPieces() {
+		t.availableAt = time.Time{}
+		t.available("stopped needing pieces")
+		return
+	}
+	// web seeds don't show up as peers but they move data
+	done := t.st.DownloadedSize()
+	if done != t.availDone || t.peersHaveNeeded() {
+		t.availDone = done
+		t.availableAt = now
+		t.available("has peers with the pieces it needs again")
+		return
+	}
+	if t.availableAt.IsZero() {
+		t.availableAt = now
+	}
+	if t.Tiering.UnavailableAfter <= 0 || now.Sub(t.availableAt) < t.Tiering.UnavailableAfter || !atomic.CompareAndSwapInt32(&t.unavailable, 0, 1) {
+		return
+	}
+	log.Warnf("%s: no peer has had the pieces we need since %s, marking it unavailable", t.Name(), t.availableAt)
+	if t.audit != nil {
+		t.audit(audit.Event{
+			Action:   audit.ActionUnavailable,
+			Infohash: t.Infohash().Hex(),
+			Detail:   fmt.Sprintf("no peer has had the pieces it needs since %s", t.availableAt.Format(time.RFC3339)),
+		})
+	}
+	// peers that have nothing for us and want nothing from us only cost us
+	t.VisitPeers(func(c *PeerConn) {
+		if !c.peerInterested && !t.hasNeeded(c.Bitfield()) {
+			c.Close()
+		}
+	})
+}
+
+// a peer told us it has pieces in bf, leave the unavailable state if we need one of them
+func (t *Torrent) checkPeerAvailable(bf *bittorrent.Bitfield) {
+	if t.Unavailable() && t.hasNeeded(bf) {
+		t.available("found a peer with the pieces it needs")
+	}
+}
+
+// take the torrent out of the unavailable state, why says what brought it back
+func (t *Torrent) available(why string) {
+	if !atomic.CompareAndSwapInt32(&t.unavailable, 1, 0) {
+		return
+	}
+	log.Infof("%s %s, no longer unavailable", t.Name(), why)
+	go t.announceNow()
+}
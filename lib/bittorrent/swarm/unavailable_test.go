@@ -0,0 +1,80 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/bittorrent"
+	"testing"
+	"time"
+)
+
+// storage of a torrent we still need pieces of
+type availStorage struct {
+	recheckStorage
+}
+
+func (s *availStorage) DownloadRemaining() uint64 {
+	return 16
+}
+
+func (s *availStorage) DownloadedSize() uint64 {
+	return 48
+}
+
+func TestCheckAvailable(t *testing.T) {
+	tr := recheckTestTorrent()
+	st := &availStorage{*tr.st.(*recheckStorage)}
+	st.bf.Unset(3)
+	tr.st = st
+	tr.started = true
+	tr.Tiering.UnavailableAfter = time.Minute
+	// wants pieces from us so we keep it
+	seeder := &PeerConn{t: tr, peerInterested: true, bf: bittorrent.NewBitfield(4, nil)}
+	idle := &PeerConn{t: tr, bf: bittorrent.NewBitfield(4, nil), close: make(chan bool, 1)}
+	tr.obconns["seeder"] = seeder
+	tr.obconns["idle"] = idle
+	now := time.Now()
+	tr.checkAvailable(now)
+	tr.checkAvailable(now.Add(time.Second * 30))
+	if tr.Unavailable() {
+		t.Fatal("unavailable before UnavailableAfter")
+	}
+	// the bitfield changes while tiering looks at it
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	running := make(chan struct{})
+	go func() {
+		defer close(done)
+		close(running)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			seeder.bfMtx.Lock()
+			seeder.bf.Set(0)
+			seeder.bfMtx.Unlock()
+		}
+	}()
+	<-running
+	for idx := 0; idx < 100; idx++ {
+		if tr.peersHaveNeeded() {
+			t.Fatal("peer has a piece we need")
+		}
+	}
+	tr.checkAvailable(now.Add(time.Minute))
+	close(stop)
+	<-done
+	if !tr.Unavailable() {
+		t.Fatal("still available after nobody had what we need for UnavailableAfter")
+	}
+	if !idle.closing || seeder.closing {
+		t.Fatal("didn't close just the peer that has nothing for us and wants nothing from us")
+	}
+	seeder.bfMtx.Lock()
+	seeder.bf.Set(3)
+	seeder.bfMtx.Unlock()
+	tr.checkAvailable(now.Add(time.Minute * 2))
+	if tr.Unavailable() {
+		t.Fatal("still unavailable with a peer that has what we need")
+	}
+}
@@ -0,0 +1,96 @@
+package swarm
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/unpack"
+	"path/filepath"
+)
+
+// ErrNoUnpack is returned when unpacking a torrent we are not told to unpack
+var ErrNoUnpack = errors.New("unpacking is not enabled for this torrent's directory")
+
+// ErrUnpackNotDone is returned when unpacking a torrent that is not done downloading
+var ErrUnpackNotDone = errors.New("torrent is not done downloading")
+
+// absolute paths of the torrent's data files
+func (t *Torrent) dataFiles() (files []string) {
+	info := t.MetaInfo()
+	if info == nil {
+		return
+	}
+	root := t.DownloadDir()
+	if !info.IsSingleFile() {
+		root = filepath.Join(root, info.Info.Path)
+	}
+	for _, f := range info.Info.GetFiles() {
+		if f.IsPadding() {
+			continue
+		}
+		files = append(files, f.Path.FilePath(root))
+	}
+	return
+}
+
+// unpack the completed torrent if we are told to and it never finished unpacking before
+func (t *Torrent) startUnpack() {
+	if !t.Unpack.Applies(t.DownloadDir()) {
+		return
+	}
+	state, _ := t.st.UnpackState()
+	if unpack.State(state) != unpack.None {
+		// failures stay failed until someone asks us to try again
+		return
+	}
+	t.runUnpack()
+}
+
+func (t *Torrent) runUnpack() {
+	t.unpackMtx.Lock()
+	if t.unpack.State == unpack.Running {
+		t.unpackMtx.Unlock()
+		return
+	}
+	t.unpack = unpack.Status{State: unpack.Running}
+	t.unpackMtx.Unlock()
+	go func() {
+		st := unpack.Status{State: unpack.Done}
+		err := t.Unpack.Run(t.DownloadDir(), t.dataFiles())
+		if err == nil {
+			log.Infof("unpacked %s", t.Name())
+		} else {
+			st.State = unpack.Failed
+			st.Error = err.Error()
+			log.Errorf("failed to unpack %s: %s", t.Name(), st.Error)
+		}
+		t.unpackMtx.Lock()
+		t.unpack = st
+		t.unpackMtx.Unlock()
+		t.st.SetUnpackState(string(st.State), st.Error)
+	}()
+}
+
+// RetryUnpack unpacks the completed torrent again
+func (t *Torrent) RetryUnpack() error {
+	if !t.Unpack.Applies(t.DownloadDir()) {
+		return ErrNoUnpack
+	}
+	if !t.Done() {
+		return ErrUnpackNotDone
+	}
+	t.runUnpack()
+	return nil
+}
+
+// UnpackStatus gets how far unpacking the completed torrent got
+func (t *Torrent) UnpackStatus() (st unpack.Status) {
+	t.unpackMtx.Lock()
+	st = t.unpack
+	t.unpackMtx.Unlock()
+	if st.State == unpack.None {
+		state, reason := t.st.UnpackState()
+		st.State = unpack.State(state)
+		st.Error = reason
+	}
+	return
+}
@@ -0,0 +1,218 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"sync/atomic"
+	"time"
+)
+
+// MaxUploadQueue is how many requests of a peer we keep waiting to be served, we tell peers in reqq that we take that many
+const MaxUploadQueue = PeerSendQueueSize
+
+// MaxBlocksInFlight is how many blocks we keep queued to write to a peer, a peer that takes them slowly doesn't hold up the others
+const MaxBlocksInFlight = 4
+
+// how long the uploader sleeps when it was not woken, in case a wake up got lost
+const uploadRecheckInterval = time.Second
+
+// queue a request the peer made to be served in turn with the requests of other peers, returns false if its queue is full
+func (c *PeerConn) queueUpload(r common.PieceRequest) bool {
+	c.uploadMtx.Lock()
+	defer c.uploadMtx.Unlock()
+	if len(c.uploadQueue) >= MaxUploadQueue {
+		return false
+	}
+	c.uploadQueue = append(c.uploadQueue, r)
+	return true
+}
+
+// take the next request of the peer to serve, has is false if there is none or it has enough blocks on the way already
+func (c *PeerConn) nextUpload() (r common.PieceRequest, has bool) {
+	if atomic.LoadInt32(&c.blocksInFlight) >= MaxBlocksInFlight || len(c.sendq[trafficData]) > cap(c.sendq[trafficData])/2 {
+		return
+	}
+	c.uploadMtx.Lock()
+	if len(c.uploadQueue) > 0 {
+		r, has = c.uploadQueue[0], true
+		c.uploadQueue = c.uploadQueue[1:]
+	}
+	c.uploadMtx.Unlock()
+	return
+}
+
+// put a request we took back in front of the peer's queue, we couldn't serve it yet
+func (c *PeerConn) requeueUpload(r common.PieceRequest) {
+	c.uploadMtx.Lock()
+	if !c.closing {
+		c.uploadQueue = append([]common.PieceRequest{r}, c.uploadQueue...)
+	}
+	c.uploadMtx.Unlock()
+}
+
+// the peer doesn't want a block it asked for anymore
+func (c *PeerConn) cancelUpload(r common.PieceRequest) {
+	c.uploadMtx.Lock()
+	for idx := range c.uploadQueue {
+		if c.uploadQueue[idx].Equals(&r) {
+			c.uploadQueue = append(c.uploadQueue[:idx], c.uploadQueue[idx+1:]...)
+			break
+		}
+	}
+	c.uploadMtx.Unlock()
+}
+
+// drop the queued requests we won't serve now that we choked the peer, peers with the fast extension are told
+func (c *PeerConn) dropChokedUploads() {
+	var rejected []common.PieceRequest
+	c.uploadMtx.Lock()
+	var queue []common.PieceRequest
+	for _, r := range c.uploadQueue {
+		if c.servesWhileChoked(r.Index) {
+			queue = append(queue, r)
+		} else {
+			rejected = append(rejected, r)
+		}
+	}
+	c.uploadQueue = queue
+	c.uploadMtx.Unlock()
+	if c.fast {
+		for _, r := range rejected {
+			c.Send(r.Reject())
+		}
+	}
+}
+
+// number of requests the peer made that wait for us to serve them
+func (c *PeerConn) numUploads() (n int) {
+	c.uploadMtx.Lock()
+	n = len(c.uploadQueue)
+	c.uploadMtx.Unlock()
+	return
+}
+
+// queue a block to send without waiting, returns false if the peer's queue of blocks is full or it closed
+func (c *PeerConn) sendBlock(msg common.WireMessage) bool {
+	q := c.sendq[trafficData]
+	if q == nil {
+		return false
+	}
+	atomic.AddInt32(&c.blocksInFlight, 1)
+	select {
+	case q <- msg:
+		return true
+	default:
+		atomic.AddInt32(&c.blocksInFlight, -1)
+		return false
+	}
+}
+
+// a block we queued went out to the peer or was dropped
+func (c *PeerConn) sentBlock() {
+	atomic.AddInt32(&c.blocksInFlight, -1)
+	c.t.wakeUploads()
+}
+
+// a message we queued never got to processWrite, count it out if it was a block
+func (c *PeerConn) dropped(msg common.WireMessage) {
+	if !msg.KeepAlive() && msg.MessageID() == common.Piece {
+		c.sentBlock()
+	}
+}
+
+// drop the blocks still queued to send to a peer we are closing
+func (c *PeerConn) dropBlocks() {
+	q := c.sendq[trafficData]
+	for {
+		select {
+		case <-q:
+			atomic.AddInt32(&c.blocksInFlight, -1)
+		default:
+			return
+		}
+	}
+}
+
+// wake the uploader up after a peer made a request or took a block
+func (t *Torrent) wakeUploads() {
+	if t.uploadWake == nil {
+		return
+	}
+	select {
+	case t.uploadWake <- struct{}{}:
+	default:
+	}
+}
+
+// take one request from each peer that has one to serve, a peer asking for hundreds of blocks gets no more turns than a peer asking for one
+func uploadRound(peers []*PeerConn) (conns []*PeerConn, reqs []common.PieceRequest) {
+	for _, c := range peers {
+		if r, has := c.nextUpload(); has {
+			conns = append(conns, c)
+			reqs = append(reqs, r)
+		}
+	}
+	return
+}
+
+// serve one round of requests, returns false if there was nothing to serve
+func (t *Torrent) serveUploads() bool {
+	var peers []*PeerConn
+	t.VisitPeers(func(c *PeerConn) {
+		peers = append(peers, c)
+	})
+	conns, reqs := uploadRound(peers)
+	for idx, c := range conns {
+		t.serveUpload(c, &reqs[idx])
+	}
+	return len(conns) > 0
+}
+
+// read a block a peer asked for and queue it to send
+func (t *Torrent) serveUpload(c *PeerConn, r *common.PieceRequest) {
+	if c.Chocking() && !c.servesWhileChoked(r.Index) {
+		// choked after it asked, the choke dropped it
+		return
+	}
+	if !t.checkUpload(r.Index) {
+		c.rejectRequest(r)
+		return
+	}
+	var pc common.PieceData
+	if r.Length <= uint32(cap(c.sendPieceBuff)) {
+		pc.Data = c.sendPieceBuff[:r.Length]
+	} else {
+		pc.Data = make([]byte, r.Length)
+	}
+	err := t.st.GetPiece(*r, &pc)
+	if err == nil {
+		if !c.sendBlock(pc.ToWireMessage()) {
+			// a peer that takes its blocks slowly never holds up the others, it gets this one on a later round
+			c.requeueUpload(*r)
+			return
+		}
+		log.Debugf("%s queued piece %d %d-%d", c.id.String(), r.Index, r.Begin, r.Begin+r.Length)
+	} else {
+		log.Debugf("%s asked for piece %d we can't serve: %s", c.id.String(), r.Index, err.Error())
+		c.rejectRequest(r)
+	}
+}
+
+// serve the requests of our peers in turns until the torrent stops
+// a torrent started again before the uploader of its last run noticed it stopped keeps that one
+func (t *Torrent) runUploads() {
+	for atomic.CompareAndSwapInt32(&t.uploading, 0, 1) {
+		for t.started {
+			if !t.serveUploads() {
+				select {
+				case <-t.uploadWake:
+				case <-time.After(uploadRecheckInterval):
+				}
+			}
+		}
+		atomic.StoreInt32(&t.uploading, 0)
+		if !t.started {
+			return
+		}
+	}
+}
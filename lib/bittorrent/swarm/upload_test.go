@@ -0,0 +1,82 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"testing"
+	"time"
+)
+
+func TestUploadRound(t *testing.T) {
+	greedy := &PeerConn{sendq: testSendQueues(16)}
+	modest := &PeerConn{sendq: testSendQueues(16)}
+	for idx := uint32(0); idx < MaxUploadQueue; idx++ {
+		if !greedy.queueUpload(common.PieceRequest{Index: idx, Length: BlockSize}) {
+			t.Fatalf("request %d refused", idx)
+		}
+	}
+	if greedy.queueUpload(common.PieceRequest{Index: MaxUploadQueue, Length: BlockSize}) {
+		t.Fatal("queued more requests than we take")
+	}
+	modest.queueUpload(common.PieceRequest{Index: 7, Length: BlockSize})
+	conns, reqs := uploadRound([]*PeerConn{greedy, modest})
+	if len(conns) != 2 || conns[0] != greedy || conns[1] != modest || reqs[0].Index != 0 || reqs[1].Index != 7 {
+		t.Fatalf("round served %d requests instead of one of each peer", len(conns))
+	}
+	conns, _ = uploadRound([]*PeerConn{greedy, modest})
+	if len(conns) != 1 || conns[0] != greedy {
+		t.Fatal("second round did not serve just the greedy peer")
+	}
+	greedy.blocksInFlight = MaxBlocksInFlight
+	conns, _ = uploadRound([]*PeerConn{greedy, modest})
+	if len(conns) != 0 {
+		t.Fatal("served a peer that has enough blocks on the way")
+	}
+	greedy.blocksInFlight = 0
+	greedy.cancelUpload(common.PieceRequest{Index: 2, Length: BlockSize})
+	_, reqs = uploadRound([]*PeerConn{greedy})
+	if reqs[0].Index != 3 || greedy.numUploads() != MaxUploadQueue-4 {
+		t.Fatalf("served piece %d with %d queued after a cancel", reqs[0].Index, greedy.numUploads())
+	}
+}
+
+func TestSendBlock(t *testing.T) {
+	c := &PeerConn{t: &Torrent{}, sendq: testSendQueues(1)}
+	block := common.NewWireMessage(common.Piece, make([]byte, 8))
+	if !c.sendBlock(block) || c.blocksInFlight != 1 {
+		t.Fatalf("queued a block with %d in flight", c.blocksInFlight)
+	}
+	// a full queue doesn't make us wait
+	if c.sendBlock(block) || c.blocksInFlight != 1 {
+		t.Fatalf("queued a block to a full queue with %d in flight", c.blocksInFlight)
+	}
+	c.requeueUpload(common.PieceRequest{Index: 5, Length: BlockSize})
+	if c.numUploads() != 1 {
+		t.Fatal("request we could not serve was not queued again")
+	}
+	// blocks that never get written don't count as in flight
+	c.dropBlocks()
+	if c.blocksInFlight != 0 || len(c.sendq[trafficData]) != 0 {
+		t.Fatalf("%d blocks in flight after dropping them", c.blocksInFlight)
+	}
+	c.sendBlock(block)
+	// the writer took it but the connection broke
+	c.dropped(<-c.sendq[trafficData])
+	if c.blocksInFlight != 0 {
+		t.Fatalf("%d blocks in flight after one was dropped", c.blocksInFlight)
+	}
+}
+
+func TestOneUploader(t *testing.T) {
+	tr := &Torrent{started: true, uploading: 1}
+	done := make(chan struct{})
+	go func() {
+		tr.runUploads()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		tr.started = false
+		t.Fatal("started a second uploader")
+	}
+}
@@ -0,0 +1,158 @@
+package swarm
+
+import (
+	"github.com/majestrate/XD/lib/sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultVerifyWorkers is how many torrents we verify at once by default
+const DefaultVerifyWorkers = 2
+
+// VerifyOrder is which waiting torrent we verify next
+type VerifyOrder string
+
+// VerifySmallestFirst verifies the torrents with the least data first
+const VerifySmallestFirst = VerifyOrder("smallest")
+
+// VerifyRecentFirst verifies the torrents that were active most recently first
+const VerifyRecentFirst = VerifyOrder("recent")
+
+// a torrent waiting for its turn to verify
+type verifyJob struct {
+	size   uint64
+	active time.Time
+	ready  chan struct{}
+}
+
+// verifyQueue limits how many torrents verify their data at once
+// so starting up with many torrents does not read all of them at the same time
+type verifyQueue struct {
+	access  sync.Mutex
+	workers int
+	order   VerifyOrder
+	running int
+	waiting []*verifyJob
+}
+
+func newVerifyQueue(workers int, order VerifyOrder) *verifyQueue {
+	if workers <= 0 {
+		workers = DefaultVerifyWorkers
+	}
+	return &verifyQueue{
+		workers: workers,
+		order:   order,
+	}
+}
+
+// returns true if a goes before b
+func (q *verifyQueue) before(a, b *verifyJob) bool {
+	if q.order == VerifyRecentFirst {
+		return a.active.After(b.active)
+	}
+	return a.size < b.size
+}
+
+// start waiting jobs while there are free workers, access must be held
+func (q *verifyQueue) dispatch() {
+	for q.running < q.workers && len(q.waiting) > 0 {
+		next := 0
+		for idx := range q.waiting {
+			if q.before(q.waiting[idx], q.waiting[next]) {
+				next = idx
+			}
+		}
+		job := q.waiting[next]
+		q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+		q.running++
+		close(job.ready)
+	}
+}
+
+// how often a torrent waiting to verify checks if it should give up its place
+const verifyCancelPoll = time.Millisecond * 100
+
+// wait for a turn to verify a torrent, call release when done verifying
+// ok is false if cancelled returned true before we got a turn, nil cancelled waits for as long as it takes
+func (q *verifyQueue) acquire(size uint64, active time.Time, cancelled func() bool) (release func(), ok bool) {
+	job := &verifyJob{
+		size:   size,
+		active: active,
+		ready:  make(chan struct{}),
+	}
+	q.access.Lock()
+	q.waiting = append(q.waiting, job)
+	q.dispatch()
+	q.access.Unlock()
+	release = func() {
+		q.access.Lock()
+		q.running--
+		q.dispatch()
+		q.access.Unlock()
+	}
+	ticker := time.NewTicker(verifyCancelPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-job.ready:
+			return release, true
+		case <-ticker.C:
+			if cancelled == nil || !cancelled() {
+				continue
+			}
+			q.access.Lock()
+			for idx := range q.waiting {
+				if q.waiting[idx] == job {
+					q.waiting = append(q.waiting[:idx], q.waiting[idx+1:]...)
+					q.access.Unlock()
+					return nil, false
+				}
+			}
+			q.access.Unlock()
+			// got our turn just now, give it to the next one
+			release()
+			return nil, false
+		}
+	}
+}
+
+// how many torrents are waiting to verify
+func (q *verifyQueue) numWaiting() (n int) {
+	q.access.Lock()
+	n = len(q.waiting)
+	q.access.Unlock()
+	return
+}
+
+// wait for our turn to verify data if other torrents are verifying, call release when done verifying
+// ok is false if the torrent was stopped while it waited
+func (t *Torrent) waitVerify(active time.Time) (release func(), ok bool) {
+	if t.verifier == nil {
+		return func() {}, true
+	}
+	stops := atomic.LoadUint32(&t.stops)
+	atomic.StoreInt32(&t.verifyWaiting, 1)
+	defer atomic.StoreInt32(&t.verifyWaiting, 0)
+	return t.verifier.acquire(t.st.WantedSize(), active, func() bool {
+		return atomic.LoadUint32(&t.stops) != stops
+	})
+}
+
+// returns true while we wait for our turn to verify data
+func (t *Torrent) waitingToVerify() bool {
+	return atomic.LoadInt32(&t.verifyWaiting) == 1
+}
+
+// verify the torrent and start seeding it, waiting for our turn if other torrents are verifying
+func (t *Torrent) seed() (seeding bool, err error) {
+	release, ok := t.waitVerify(t.st.LastActive())
+	if !ok {
+		// stopped while we waited
+		return
+	}
+	defer release()
+	if t.closing {
+		return
+	}
+	return t.st.Seed()
+}
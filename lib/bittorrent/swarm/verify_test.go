@@ -0,0 +1,71 @@
+package swarm
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerifyQueueOrder(t *testing.T) {
+	for _, order := range []VerifyOrder{VerifySmallestFirst, VerifyRecentFirst} {
+		q := newVerifyQueue(1, order)
+		release, _ := q.acquire(0, time.Now(), nil)
+		now := time.Now()
+		got := make(chan uint64, 3)
+		for _, sz := range []uint64{30, 10, 20} {
+			go func(sz uint64) {
+				// bigger ones were active more recently
+				done, _ := q.acquire(sz, now.Add(time.Duration(sz)*time.Second), nil)
+				got <- sz
+				done()
+			}(sz)
+		}
+		for q.numWaiting() < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		release()
+		expect := []uint64{10, 20, 30}
+		if order == VerifyRecentFirst {
+			expect = []uint64{30, 20, 10}
+		}
+		for _, sz := range expect {
+			if v := <-got; v != sz {
+				t.Fatalf("%s order verified %d before %d", order, v, sz)
+			}
+		}
+	}
+}
+
+func TestVerifyQueueCancel(t *testing.T) {
+	q := newVerifyQueue(1, VerifySmallestFirst)
+	release, _ := q.acquire(0, time.Now(), nil)
+	var stopped int32
+	done := make(chan bool)
+	go func() {
+		_, ok := q.acquire(10, time.Now(), func() bool {
+			return atomic.LoadInt32(&stopped) == 1
+		})
+		done <- ok
+	}()
+	for q.numWaiting() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&stopped, 1)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("got a turn after being stopped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stopping didn't end the wait")
+	}
+	if q.numWaiting() != 0 {
+		t.Fatal("stopped torrent still waits")
+	}
+	release()
+	if next, ok := q.acquire(0, time.Now(), nil); !ok {
+		t.Fatal("no turn after the queue emptied")
+	} else {
+		next()
+	}
+}
@@ -0,0 +1,402 @@
+package swarm
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/sync"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWebSeedMinRate is the download rate from peers in bytes per second below which we also fetch pieces from web seeds
+const DefaultWebSeedMinRate = 32 * 1024
+
+// WebSeedInterval is how often we check if we should use web seeds
+const WebSeedInterval = time.Second * 5
+
+// WebSeedTimeout is how long we wait on a web seed for one piece
+const WebSeedTimeout = time.Minute * 2
+
+// WebSeedRetry is how long we wait before using a web seed again after it failed, grows with each failure
+const WebSeedRetry = time.Minute
+
+// WebSeedMaxRetry is the longest we wait before using a failing web seed again
+const WebSeedMaxRetry = time.Minute * 30
+
+// WebSeedMaxFailures is how many times in a row a web seed may fail before we stop using it until the torrent is restarted
+const WebSeedMaxFailures = 10
+
+// WebSeedStatus is how a web seed of a torrent is doing
+type WebSeedStatus struct {
+	URL string
+	// bytes per second it sent us pieces at lately, 0 if it didn't send any yet
+	Rate float64
+	// times in a row it failed
+	Failures int
+	// we stopped using it because it failed too often, doesn't have the torrent or doesn't serve byte ranges
+	Blacklisted bool
+	// unix timestamp of when we use it again after it failed, 0 if we don't wait on it
+	RetryAt int64
+}
+
+// a bep 19 web seed or bep 17 http seed we fetch pieces from over http
+type webSeed struct {
+	url string
+	// a bep 17 http seed that serves pieces by index instead of files
+	httpSeed bool
+	// made when we first use it and again after it fails in case our network changed
+	client *http.Client
+	// every piece, web seeds have all of them
+	bf *bittorrent.Bitfield
+	// a goroutine fetches pieces from it, guarded by the torrent's webSeedsMtx
+	running bool
+	// guards what follows
+	mtx         sync.Mutex
+	failures    int
+	retryAt     time.Time
+	rate        float64
+	blacklisted bool
+	// it answered a range request with the whole file, every piece would cost us the file up to it
+	noRanges bool
+}
+
+// wait longer before using the web seed again, stop using it if it failed too often or doesn't have the torrent
+func (ws *webSeed) failed(err error) {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	ws.failures++
+	ws.client = nil
+	if _, gone := err.(webSeedGone); gone || ws.failures >= WebSeedMaxFailures {
+		ws.blacklisted = true
+		log.Warnf("web seed %s failed %d times, not using it anymore: %s", ws.url, ws.failures, err.Error())
+		return
+	}
+	wait := WebSeedRetry * time.Duration(ws.failures)
+	if wait > WebSeedMaxRetry {
+		wait = WebSeedMaxRetry
+	}
+	ws.retryAt = time.Now().Add(wait)
+	log.Warnf("web seed %s failed %d times, waiting %s: %s", ws.url, ws.failures, wait, err.Error())
+}
+
+// stop using a bep 19 web seed that doesn't serve ranges once the piece that showed it is in
+func (ws *webSeed) checkRanges() {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	if ws.noRanges && !ws.blacklisted {
+		ws.blacklisted = true
+		log.Warnf("web seed %s does not serve byte ranges, not using it anymore", ws.url)
+	}
+}
+
+// the web seed sent n bytes in dlt
+func (ws *webSeed) succeeded(n int, dlt time.Duration) {
+	if dlt <= 0 {
+		dlt = time.Millisecond
+	}
+	rate := float64(n) / dlt.Seconds()
+	ws.mtx.Lock()
+	ws.failures = 0
+	if ws.rate == 0 {
+		ws.rate = rate
+	} else {
+		ws.rate = ws.rate*0.75 + rate*0.25
+	}
+	ws.mtx.Unlock()
+}
+
+// wait before using the web seed again without counting it as a failure
+func (ws *webSeed) retryIn(dlt time.Duration) {
+	ws.mtx.Lock()
+	ws.retryAt = time.Now().Add(dlt)
+	ws.mtx.Unlock()
+}
+
+// returns true if we may fetch from the web seed at now
+func (ws *webSeed) usable(now time.Time) bool {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	return !ws.blacklisted && !now.Before(ws.retryAt)
+}
+
+func (ws *webSeed) status() (st WebSeedStatus) {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+	st.URL = ws.url
+	st.Rate = ws.rate
+	st.Failures = ws.failures
+	st.Blacklisted = ws.blacklisted
+	if !ws.blacklisted && ws.retryAt.After(time.Now()) {
+		st.RetryAt = ws.retryAt.Unix()
+	}
+	return
+}
+
+// a web seed told us it doesn't have what we asked for
+type webSeedGone string
+
+func (g webSeedGone) Error() string {
+	return string(g)
+}
+
+// the error for a response we can't use, webSeedGone if the server doesn't have it
+func webSeedStatusError(u string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return webSeedGone(fmt.Sprintf("%s: %s", u, resp.Status))
+	}
+	return fmt.Errorf("%s: %s", u, resp.Status)
+}
+
+// a bep 17 http seed told us to come back later
+type httpSeedBusy time.Duration
+
+func (b httpSeedBusy) Error() string {
+	return fmt.Sprintf("http seed busy, retry in %s", time.Duration(b))
+}
+
+// fetch a whole piece from a bep 17 http seed
+func (ws *webSeed) fetchPiece(ih common.Infohash, idx uint32, buf []byte) (err error) {
+	var u *url.URL
+	u, err = url.Parse(ws.url)
+	if err != nil {
+		return
+	}
+	q := u.Query()
+	q.Set("info_hash", string(ih.Bytes()))
+	q.Set("piece", fmt.Sprintf("%d", idx))
+	u.RawQuery = q.Encode()
+	var resp *http.Response
+	resp, err = ws.client.Get(u.String())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, err = io.ReadFull(resp.Body, buf)
+	case http.StatusServiceUnavailable:
+		// the body is how many seconds to wait
+		var body []byte
+		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, 32))
+		if err == nil {
+			secs, e := strconv.Atoi(strings.TrimSpace(string(body)))
+			if e != nil || secs <= 0 {
+				secs = int(WebSeedRetry / time.Second)
+			}
+			err = httpSeedBusy(time.Duration(secs) * time.Second)
+		}
+	default:
+		err = webSeedStatusError(ws.url, resp)
+	}
+	return
+}
+
+// fetch len(buf) bytes at offset of the file at u
+func (ws *webSeed) fetch(u string, offset uint64, buf []byte) (err error) {
+	var req *http.Request
+	req, err = http.NewRequest("GET", u, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+uint64(len(buf))-1))
+	var resp *http.Response
+	resp, err = ws.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server does not do ranges and sent the whole file, we take what we asked for from it this once
+		if offset > 0 || resp.ContentLength != int64(len(buf)) {
+			ws.mtx.Lock()
+			ws.noRanges = true
+			ws.mtx.Unlock()
+		}
+		_, err = io.CopyN(ioutil.Discard, resp.Body, int64(offset))
+	default:
+		err = webSeedStatusError(u, resp)
+	}
+	if err == nil {
+		_, err = io.ReadFull(resp.Body, buf)
+	}
+	return
+}
+
+// make the web seeds of the torrent once we have its metainfo, only used by runWebSeeds
+func (t *Torrent) loadWebSeeds() {
+	meta := t.MetaInfo()
+	all := bittorrent.NewBitfield(meta.Info.NumPieces(), nil).Inverted()
+	webSeeds := []*webSeed{}
+	for _, u := range meta.WebSeeds() {
+		webSeeds = append(webSeeds, &webSeed{
+			url: u,
+			bf:  all,
+		})
+	}
+	for _, u := range meta.GetHTTPSeeds() {
+		webSeeds = append(webSeeds, &webSeed{
+			url:      u,
+			httpSeed: true,
+			bf:       all,
+		})
+	}
+	if len(webSeeds) > 0 {
+		log.Infof("%s has %d web seeds", t.Name(), len(webSeeds))
+	}
+	t.webSeedsMtx.Lock()
+	t.webSeeds = webSeeds
+	t.webSeedsMtx.Unlock()
+}
+
+// WebSeeds gets how the web seeds of the torrent are doing, nil until we use them
+func (t *Torrent) WebSeeds() (st []WebSeedStatus) {
+	t.webSeedsMtx.Lock()
+	defer t.webSeedsMtx.Unlock()
+	for _, ws := range t.webSeeds {
+		st = append(st, ws.status())
+	}
+	return
+}
+
+// how fast peers send us data in bytes per second, leaving out web seeds
+func (t *Torrent) peerRX() (rx float64) {
+	t.VisitPeers(func(c *PeerConn) {
+		rx += c.rx.Mean()
+	})
+	return
+}
+
+// returns true if we should fetch pieces from web seeds, because peers are too slow or none has what we need
+func (t *Torrent) wantWebSeeds() bool {
+	if t.closing || !t.started || t.webSeedTransport == nil || !t.Ready() || t.Done() || !t.onPinnedNetwork() {
+		return false
+	}
+	return t.peerRX() < float64(t.WebSeedMinRate) || !t.peersHaveNeeded()
+}
+
+// fetch pieces from every web seed at once while peers are too slow, so a torrent completes from web seeds alone if it must
+func (t *Torrent) runWebSeeds() {
+	for t.started {
+		if t.wantWebSeeds() {
+			if t.webSeeds == nil {
+				t.loadWebSeeds()
+			}
+			now := time.Now()
+			t.webSeedsMtx.Lock()
+			for _, ws := range t.webSeeds {
+				if !ws.running && ws.usable(now) {
+					ws.running = true
+					go t.runWebSeed(ws)
+				}
+			}
+			t.webSeedsMtx.Unlock()
+		}
+		time.Sleep(WebSeedInterval)
+	}
+}
+
+// fetch pieces from one web seed until we don't want web seeds or it fails
+func (t *Torrent) runWebSeed(ws *webSeed) {
+	for t.wantWebSeeds() && ws.usable(time.Now()) && t.fetchFromWebSeed(ws) {
+	}
+	t.webSeedsMtx.Lock()
+	ws.running = false
+	t.webSeedsMtx.Unlock()
+}
+
+// fetch the rarest piece nobody else is getting from a web seed, returns true if we got it
+func (t *Torrent) fetchFromWebSeed(ws *webSeed) bool {
+	idx, has := t.getRarestPiece(ws.bf, t.pt.PendingPieces())
+	if !has || !t.pt.claimPiece(idx) {
+		return false
+	}
+	started := time.Now()
+	n, err := t.fetchWebSeedPiece(ws, idx)
+	defer ws.checkRanges()
+	if err == nil && !t.pt.verified(idx) {
+		err = fmt.Errorf("%w: piece %d", common.ErrPieceHashMismatch, idx)
+	}
+	if err == nil {
+		ws.succeeded(n, time.Since(started))
+		return true
+	}
+	t.pt.removePiece(idx)
+	if busy, ok := err.(httpSeedBusy); ok {
+		ws.retryIn(time.Duration(busy))
+		log.Debugf("%s: %s", ws.url, busy.Error())
+	} else {
+		ws.failed(err)
+	}
+	return false
+}
+
+// fetch piece idx from a web seed or http seed, returns how many bytes it sent
+func (t *Torrent) fetchWebSeedPiece(ws *webSeed, idx uint32) (n int, err error) {
+	if ws.client == nil {
+		var u *url.URL
+		u, err = url.Parse(ws.url)
+		if err != nil {
+			return
+		}
+		var tr *http.Transport
+		tr, err = t.webSeedTransport(u)
+		if err != nil {
+			return
+		}
+		ws.client = &http.Client{
+			Transport: tr,
+			Timeout:   WebSeedTimeout,
+		}
+	}
+	data := make([]byte, t.MetaInfo().LengthOfPiece(idx))
+	if ws.httpSeed {
+		err = ws.fetchPiece(t.Infohash(), idx, data)
+	} else {
+		err = t.fetchWebSeedFiles(ws, idx, data)
+	}
+	if err != nil {
+		return
+	}
+	log.Debugf("got piece %d of %s from web seed %s", idx, t.Name(), ws.url)
+	t.statsTracker.AddSample(RateDownload, uint64(len(data)))
+	t.pt.handlePieceData(&common.PieceData{Index: idx, Data: data}, ws.url)
+	n = len(data)
+	return
+}
+
+// fetch piece idx from a bep 19 web seed into data, one range request per file the piece is in
+func (t *Torrent) fetchWebSeedFiles(ws *webSeed, idx uint32, data []byte) (err error) {
+	meta := t.MetaInfo()
+	begin := uint64(idx) * uint64(meta.Info.PieceLength)
+	end := begin + uint64(len(data))
+	var off uint64
+	for _, f := range meta.Info.GetFiles() {
+		fbegin := off
+		off += f.Length
+		if off <= begin || fbegin >= end || f.IsPadding() || f.IsSymlink() {
+			continue
+		}
+		// the part of the piece in this file
+		from, to := begin, end
+		if fbegin > from {
+			from = fbegin
+		}
+		if off < to {
+			to = off
+		}
+		err = ws.fetch(meta.WebSeedFileURL(ws.url, f), from-fbegin, data[from-begin:to-begin])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package swarm
+
+import (
+	"bytes"
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebSeedFetch(t *testing.T) {
+	data := []byte("0123456789abcdef")
+	ranges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ranges.Close()
+	whole := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer whole.Close()
+	for _, u := range []string{ranges.URL, whole.URL} {
+		ws := &webSeed{url: u, client: http.DefaultClient}
+		buf := make([]byte, 4)
+		if err := ws.fetch(u, 10, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "abcd" {
+			t.Fatalf("%s: got %q", u, buf)
+		}
+		ws.checkRanges()
+		if ws.usable(time.Now()) != (u == ranges.URL) {
+			t.Fatalf("%s: web seed usable is %v", u, ws.usable(time.Now()))
+		}
+	}
+	gone := httptest.NewServer(http.NotFoundHandler())
+	defer gone.Close()
+	ws := &webSeed{url: gone.URL, client: http.DefaultClient}
+	err := ws.fetch(gone.URL, 0, make([]byte, 4))
+	if _, ok := err.(webSeedGone); !ok {
+		t.Fatalf("fetching from a web seed that does not have the file gave %v", err)
+	}
+	ws.failed(err)
+	if ws.usable(time.Now()) || !ws.status().Blacklisted {
+		t.Fatal("web seed that does not have the file is still used")
+	}
+}
+
+func TestWebSeedFailures(t *testing.T) {
+	ws := &webSeed{url: "http://seed.i2p/"}
+	ws.succeeded(1000, time.Second)
+	ws.succeeded(3000, time.Second)
+	if st := ws.status(); st.Rate != 1500 {
+		t.Fatalf("rate is %f", st.Rate)
+	}
+	for n := 1; n < WebSeedMaxFailures; n++ {
+		ws.failed(errors.New("timeout"))
+		if ws.usable(time.Now()) || !ws.usable(time.Now().Add(WebSeedMaxRetry)) {
+			t.Fatalf("failure %d: web seed isn't waited on", n)
+		}
+	}
+	ws.failed(errors.New("timeout"))
+	if ws.usable(time.Now().Add(WebSeedMaxRetry)) {
+		t.Fatal("web seed that failed too often is still used")
+	}
+}
+
+func TestHTTPSeedFetch(t *testing.T) {
+	var ih common.Infohash
+	ih[0] = 1
+	busy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("info_hash") != string(ih.Bytes()) || r.URL.Query().Get("piece") != "3" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if busy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("42"))
+			return
+		}
+		w.Write([]byte("piece"))
+	}))
+	defer srv.Close()
+	ws := &webSeed{url: srv.URL + "/seed.php", httpSeed: true, client: http.DefaultClient}
+	buf := make([]byte, 5)
+	err := ws.fetchPiece(ih, 3, buf)
+	if b, ok := err.(httpSeedBusy); !ok || time.Duration(b) != 42*time.Second {
+		t.Fatalf("busy http seed gave %v", err)
+	}
+	busy = false
+	if err = ws.fetchPiece(ih, 3, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "piece" {
+		t.Fatalf("got %q", buf)
+	}
+	if ws.fetchPiece(ih, 4, buf) == nil {
+		t.Fatal("fetched a piece the http seed does not have")
+	}
+}
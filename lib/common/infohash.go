@@ -39,3 +39,22 @@ func DecodeInfohash(hexstr string) (ih Infohash, err error) {
 func (ih Infohash) Bytes() []byte {
 	return ih[:]
 }
+
+// InfohashV2 is a bittorrent v2 infohash buffer, the sha256 of the info section
+type InfohashV2 [32]byte
+
+// Hex gets hex representation of v2 infohash
+func (ih InfohashV2) Hex() string {
+	return hex.EncodeToString(ih.Bytes())
+}
+
+// Bytes gets underlying byteslice of v2 infohash buffer
+func (ih InfohashV2) Bytes() []byte {
+	return ih[:]
+}
+
+// Truncated gets the v2 infohash cut down to the size used on the wire
+func (ih InfohashV2) Truncated() (t Infohash) {
+	copy(t[:], ih[:])
+	return
+}
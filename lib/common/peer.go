@@ -44,6 +44,11 @@ type Peer struct {
 	ID      PeerID         `bencode:"peer id"`
 }
 
+// NeedsLookup returns true if resolving the peer on n asks the network, peers we only know the compact address of on i2p
+func (p *Peer) NeedsLookup(n network.Network) bool {
+	return n.Addr().Network() == "i2p" && len(p.IP) == 0
+}
+
 // Resolve resolves network address of peer
 func (p *Peer) Resolve(n network.Network) (a net.Addr, err error) {
 	la := n.Addr()
@@ -33,8 +33,18 @@ func (pc PieceRequest) Cancel() WireMessage {
 	return NewCancel(pc.Index, pc.Begin, pc.Length)
 }
 
-// ErrInvalidPiece is an error for when a piece has invalid sha1sum
-var ErrInvalidPiece = errors.New("invalid piece")
+// Reject makes a reject request message for this piece request
+func (pc PieceRequest) Reject() WireMessage {
+	return NewRejectRequest(pc.Index, pc.Begin, pc.Length)
+}
+
+// ErrPieceHashMismatch is wrapped by errors for pieces whose data doesn't match their hash
+var ErrPieceHashMismatch = errors.New("piece hash mismatch")
+
+// ErrInvalidPiece is the old name of ErrPieceHashMismatch
+//
+// Deprecated: use ErrPieceHashMismatch
+var ErrInvalidPiece = ErrPieceHashMismatch
 
 // return true if piecedata matches this piece request
 func (r *PieceRequest) Matches(d *PieceData) bool {
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/majestrate/XD/lib/log"
-	"github.com/majestrate/XD/lib/util"
 	"io"
 )
 
@@ -39,6 +38,18 @@ const Piece = WireMessageType(7)
 // Cancel is messageid for a Cancel message, used to cancel a pending request
 const Cancel = WireMessageType(8)
 
+// HaveAll is messageid for fast extension have all message, sent instead of a full bitfield
+const HaveAll = WireMessageType(14)
+
+// HaveNone is messageid for fast extension have none message, sent instead of an empty bitfield
+const HaveNone = WireMessageType(15)
+
+// RejectRequest is messageid for fast extension reject request message
+const RejectRequest = WireMessageType(16)
+
+// AllowedFast is messageid for fast extension allowed fast message, the piece may be requested while choked
+const AllowedFast = WireMessageType(17)
+
 // Extended is messageid for ExtendedOptions message
 const Extended = WireMessageType(20)
 
@@ -70,6 +81,14 @@ func (t WireMessageType) String() string {
 		return "Piece"
 	case Cancel:
 		return "Cancel"
+	case HaveAll:
+		return "HaveAll"
+	case HaveNone:
+		return "HaveNone"
+	case RejectRequest:
+		return "RejectRequest"
+	case AllowedFast:
+		return "AllowedFast"
 	case Extended:
 		return "Extended"
 	case Invalid:
@@ -112,27 +131,32 @@ func NewWireMessage(id WireMessageType, bodyParts ...[]byte) (msg WireMessage) {
 	return
 }
 
+// MaxWireMessageSize is the biggest wire message any torrent takes, torrents with big bitfields or blocks take bigger ones
 const MaxWireMessageSize = 32 * 1024
 
 // read wire messages from reader and call a function on each it gets
-// reads until reader is done
-func ReadWireMessages(r io.Reader, f func(WireMessage) error, msg []byte) (err error) {
+// reads until reader is done, msg grows as needed for messages of up to max() bytes and bigger ones fail with ErrToBig
+func ReadWireMessages(r io.Reader, f func(WireMessage) error, msg []byte, max func() uint32) (err error) {
 	for err == nil {
 		hdr := msg[:4]
 		_, err = io.ReadFull(r, hdr)
 		l := binary.BigEndian.Uint32(hdr)
-		if l > 0 {
-			if l > MaxWireMessageSize {
-				log.Warnf("message too big, discarding %d bytes", l)
-				_, err = io.CopyN(util.Discard, r, int64(l))
-			} else {
-				body := msg[4 : 4+l]
-				log.Debugf("read message of size %d bytes", l)
-				_, err = io.ReadFull(r, body)
-				if err == nil {
-					err = f(msg[:4+l])
-				}
-			}
+		if err != nil || l == 0 {
+			continue
+		}
+		if limit := max(); l > limit {
+			return fmt.Errorf("%w: %d bytes, at most %d", ErrToBig, l, limit)
+		}
+		if int(l)+4 > len(msg) {
+			grown := make([]byte, int(l)+4)
+			copy(grown, hdr)
+			msg = grown
+		}
+		body := msg[4 : 4+l]
+		log.Debugf("read message of size %d bytes", l)
+		_, err = io.ReadFull(r, body)
+		if err == nil {
+			err = f(msg[:4+l])
 		}
 	}
 	return
@@ -165,6 +189,7 @@ func (msg WireMessage) MessageID() WireMessageType {
 	return Invalid
 }
 
+// ErrToBig is returned when a peer sends a wire message bigger than its torrent takes
 var ErrToBig = errors.New("message too big")
 
 // ToWireMessage serialize to BitTorrent wire message
@@ -203,13 +228,26 @@ func (msg WireMessage) VisitPieceData(v func(*PieceData)) {
 // GetPieceRequest gets piece request from wire message
 func (msg WireMessage) GetPieceRequest() (req *PieceRequest) {
 	if msg.MessageID() == Request {
-		data := msg.Payload()
-		if len(data) == 12 {
-			req = new(PieceRequest)
-			req.Index = binary.BigEndian.Uint32(data[:])
-			req.Begin = binary.BigEndian.Uint32(data[4:])
-			req.Length = binary.BigEndian.Uint32(data[8:])
-		}
+		req = msg.getRequestBody()
+	}
+	return
+}
+
+// GetRejectRequest gets the rejected piece request from a reject request message
+func (msg WireMessage) GetRejectRequest() (req *PieceRequest) {
+	if msg.MessageID() == RejectRequest {
+		req = msg.getRequestBody()
+	}
+	return
+}
+
+func (msg WireMessage) getRequestBody() (req *PieceRequest) {
+	data := msg.Payload()
+	if len(data) == 12 {
+		req = new(PieceRequest)
+		req.Index = binary.BigEndian.Uint32(data[:])
+		req.Begin = binary.BigEndian.Uint32(data[4:])
+		req.Length = binary.BigEndian.Uint32(data[8:])
 	}
 	return
 }
@@ -225,6 +263,24 @@ func (msg WireMessage) GetHave() (h uint32) {
 	return
 }
 
+// GetAllowedFast gets the piece index of an allowed fast message
+func (msg WireMessage) GetAllowedFast() (idx uint32) {
+	if msg.MessageID() == AllowedFast {
+		data := msg.Payload()
+		if len(data) == 4 {
+			idx = binary.BigEndian.Uint32(data[:])
+		}
+	}
+	return
+}
+
+// NewAllowedFast creates a new allowed fast message
+func NewAllowedFast(idx uint32) WireMessage {
+	var body [4]byte
+	binary.BigEndian.PutUint32(body[:], idx)
+	return NewWireMessage(AllowedFast, body[:])
+}
+
 // NewHave creates a new have message
 func NewHave(idx uint32) WireMessage {
 	var body [4]byte
@@ -249,3 +305,12 @@ func NewCancel(idx, offset, length uint32) WireMessage {
 	binary.BigEndian.PutUint32(body[8:], length)
 	return NewWireMessage(Cancel, body[:])
 }
+
+// NewRejectRequest creates a new reject request message
+func NewRejectRequest(idx, offset, length uint32) WireMessage {
+	var body [12]byte
+	binary.BigEndian.PutUint32(body[:], idx)
+	binary.BigEndian.PutUint32(body[4:], offset)
+	binary.BigEndian.PutUint32(body[8:], length)
+	return NewWireMessage(RejectRequest, body[:])
+}
@@ -2,13 +2,16 @@ package config
 
 import (
 	"fmt"
+	"github.com/majestrate/XD/lib/bittorrent/mse"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
 	"github.com/majestrate/XD/lib/configparser"
 	"github.com/majestrate/XD/lib/gnutella"
 	"github.com/majestrate/XD/lib/storage"
+	"github.com/majestrate/XD/lib/tracker"
 	"github.com/majestrate/XD/lib/util"
 	"os"
 	"strconv"
+	"time"
 )
 
 const DefaultTorrentQueueSize = 0
@@ -71,6 +74,88 @@ type BittorrentConfig struct {
 	PieceWindowSize  int
 	Swarms           int
 	TorrentQueueSize int
+	TrackerProxy     TrackerProxyConfig
+	Unpack           UnpackConfig
+	// seconds an unchoked peer may go without requesting before we choke it, 0 disables
+	IdleUploadTimeout int
+	// how many peers with the best rates we unchoke besides the optimistic unchoke
+	UploadSlots int
+	// seconds a peer keeps an upload slot of a torrent we seed while others wait for one, 0 keeps it as long as it is fastest
+	SeedSlotTurn int
+	// seconds a peer may sit on our requests before we snub it and ask other peers, 0 never snubs
+	SnubTimeout int
+	// seconds since we last checked a piece against its hash before we check it again to serve it, 0 never checks
+	VerifyUploads int
+	// flush pieces front to back on disk for torrents downloading sequentially
+	SequentialFlush bool
+	// reveal pieces to peers one at a time while seeding, for seeding new torrents from little bandwidth
+	Superseed bool
+	// refuse inbound peers for torrents we don't have metadata for yet
+	StrictInbound bool
+	// let other XDs run network tests against us
+	AnswerNetTests bool
+	// how many times we dial a peer before giving up on it
+	DialRetries int
+	// seconds to wait after a failed dial, doubled for each failure in a row
+	DialBackoff int
+	// the most seconds we wait between dials to a peer
+	DialMaxBackoff int
+	// seconds we wait to connect to a peer, for it to finish the handshake, to send us anything and to take what we send, 0 waits forever
+	DialTimeout      int
+	HandshakeTimeout int
+	PeerReadTimeout  int
+	PeerWriteTimeout int
+	// seconds a peer may send us nothing, not even keep-alives, before we drop it, 0 keeps it forever
+	PeerIdleTimeout int
+	// seconds an announce waits for announces of other torrents to the same tracker to go out with it, 0 announces each torrent on its own
+	AnnounceBatchWindow int
+	// seconds between announces to each tracker, 0 for the tracker's interval, trackers' min intervals always win
+	AnnounceInterval int
+	// when we encrypt connections to clearnet peers with mse, plaintext, prefer or require
+	Encryption string
+	// bytes we ask peers for at once, only peers that say they take big requests get more than 16KiB
+	BlockSize int
+	// add trackers peers tell us about to the stored metainfo
+	PersistLearnedTrackers bool
+	// how many torrents verify their data at once and which go first, smallest or recent
+	VerifyWorkers int
+	VerifyOrder   string
+	// seconds a seeding torrent moves no data before it goes dormant, 0 disables
+	DormantAfter int
+	// seconds since completion before a torrent may go dormant
+	DormantMinAge int
+	// seconds between announces of dormant torrents
+	DormantAnnounceInterval int
+	// seconds no peer has a piece a downloading torrent needs before it is marked unavailable, 0 disables
+	UnavailableAfter int
+	// KiB a second we upload and download piece data at most, 0 is unlimited
+	UploadLimit   int
+	DownloadLimit int
+	// the limits that apply instead during the alternative schedule, like "mon-fri 08:00-18:00, sat-sun 10:00-14:00"
+	AltUploadLimit   int
+	AltDownloadLimit int
+	AltSchedule      string
+	// share ratio seeding torrents stop at, 0 never stops, and whether they stop or are removed then
+	RatioLimit  float64
+	RatioAction string
+	// shared by the swarms we create
+	bandwidth *swarm.Bandwidth
+}
+
+// get the bandwidth limits from the config
+func (c *BittorrentConfig) bandwidthSettings() swarm.BandwidthSettings {
+	return swarm.BandwidthSettings{
+		Normal: swarm.BandwidthLimits{
+			Upload:   int64(c.UploadLimit) * 1024,
+			Download: int64(c.DownloadLimit) * 1024,
+		},
+		Alt: swarm.BandwidthLimits{
+			Upload:   int64(c.AltUploadLimit) * 1024,
+			Download: int64(c.AltDownloadLimit) * 1024,
+		},
+		Schedule: c.AltSchedule,
+		AltMode:  swarm.AltAuto,
+	}
 }
 
 func (c *BittorrentConfig) Load(s *configparser.Section) error {
@@ -79,6 +164,28 @@ func (c *BittorrentConfig) Load(s *configparser.Section) error {
 	c.TorrentQueueSize = DefaultTorrentQueueSize
 	c.PEX = true
 	c.Swarms = 1
+	c.IdleUploadTimeout = int(swarm.DefaultIdleUploadTimeout / time.Second)
+	c.UploadSlots = swarm.DefaultUploadSlots
+	c.SeedSlotTurn = int(swarm.DefaultSeedSlotTurn / time.Second)
+	c.SnubTimeout = int(swarm.DefaultSnubTimeout / time.Second)
+	c.SequentialFlush = true
+	c.DialRetries = swarm.DefaultRetryPolicy.Tries
+	c.DialBackoff = int(swarm.DefaultRetryPolicy.Backoff / time.Second)
+	c.DialMaxBackoff = int(swarm.DefaultRetryPolicy.MaxBackoff / time.Second)
+	c.DialTimeout = int(swarm.DefaultTimeouts.Dial / time.Second)
+	c.HandshakeTimeout = int(swarm.DefaultTimeouts.Handshake / time.Second)
+	c.PeerReadTimeout = int(swarm.DefaultTimeouts.Read / time.Second)
+	c.PeerWriteTimeout = int(swarm.DefaultTimeouts.Write / time.Second)
+	c.PeerIdleTimeout = int(swarm.DefaultTimeouts.Idle / time.Second)
+	c.AnnounceBatchWindow = int(tracker.DefaultBatchWindow / time.Second)
+	c.Encryption = string(mse.Plaintext)
+	c.BlockSize = swarm.BlockSize
+	c.VerifyWorkers = swarm.DefaultVerifyWorkers
+	c.VerifyOrder = string(swarm.VerifySmallestFirst)
+	c.DormantMinAge = int(swarm.DefaultTierPolicy.MinAge / time.Second)
+	c.DormantAnnounceInterval = int(swarm.DefaultTierPolicy.AnnounceInterval / time.Second)
+	c.UnavailableAfter = int(swarm.DefaultTierPolicy.UnavailableAfter / time.Second)
+	c.RatioAction = string(swarm.RatioStop)
 	if s != nil {
 		c.DHT = s.Get("dht", "0") == "1"
 		c.PEX = s.Get("pex", "1") == "1"
@@ -96,6 +203,59 @@ func (c *BittorrentConfig) Load(s *configparser.Section) error {
 		if e != nil {
 			return e
 		}
+		c.IdleUploadTimeout = s.GetInt("idle-upload-timeout", c.IdleUploadTimeout)
+		c.UploadSlots = s.GetInt("upload-slots", c.UploadSlots)
+		c.SeedSlotTurn = s.GetInt("seed-slot-turn", c.SeedSlotTurn)
+		c.SnubTimeout = s.GetInt("snub-timeout", c.SnubTimeout)
+		c.VerifyUploads = s.GetInt("verify-uploads", c.VerifyUploads)
+		c.SequentialFlush = s.Get("sequential-flush", "1") == "1"
+		c.Superseed = s.Get("superseed", "0") == "1"
+		c.StrictInbound = s.Get("strict-inbound", "0") == "1"
+		c.AnswerNetTests = s.Get("answer-nettests", "0") == "1"
+		c.DialRetries = s.GetInt("dial-retries", c.DialRetries)
+		c.DialBackoff = s.GetInt("dial-backoff", c.DialBackoff)
+		c.DialMaxBackoff = s.GetInt("dial-max-backoff", c.DialMaxBackoff)
+		c.DialTimeout = s.GetInt("dial-timeout", c.DialTimeout)
+		c.HandshakeTimeout = s.GetInt("handshake-timeout", c.HandshakeTimeout)
+		c.PeerReadTimeout = s.GetInt("peer-read-timeout", c.PeerReadTimeout)
+		c.PeerWriteTimeout = s.GetInt("peer-write-timeout", c.PeerWriteTimeout)
+		c.PeerIdleTimeout = s.GetInt("peer-idle-timeout", c.PeerIdleTimeout)
+		c.AnnounceBatchWindow = s.GetInt("announce-batch-window", c.AnnounceBatchWindow)
+		c.AnnounceInterval = s.GetInt("announce-interval", c.AnnounceInterval)
+		c.BlockSize = s.GetInt("block-size", c.BlockSize)
+		c.PersistLearnedTrackers = s.Get("persist-learned-trackers", "0") == "1"
+		c.VerifyWorkers = s.GetInt("verify-workers", c.VerifyWorkers)
+		c.VerifyOrder = s.Get("verify-order", c.VerifyOrder)
+		c.DormantAfter = s.GetInt("dormant-after", c.DormantAfter)
+		c.DormantMinAge = s.GetInt("dormant-min-age", c.DormantMinAge)
+		c.DormantAnnounceInterval = s.GetInt("dormant-announce-interval", c.DormantAnnounceInterval)
+		c.UnavailableAfter = s.GetInt("unavailable-after", c.UnavailableAfter)
+		c.UploadLimit = s.GetInt("upload-limit", c.UploadLimit)
+		c.DownloadLimit = s.GetInt("download-limit", c.DownloadLimit)
+		c.AltUploadLimit = s.GetInt("alt-upload-limit", c.AltUploadLimit)
+		c.AltDownloadLimit = s.GetInt("alt-download-limit", c.AltDownloadLimit)
+		c.AltSchedule = s.Get("alt-schedule", c.AltSchedule)
+		if _, e := swarm.ParseSchedule(c.AltSchedule); e != nil {
+			return e
+		}
+		// an empty value keeps the default like Validate does
+		if ratio := s.Get("ratio-limit", ""); ratio != "" {
+			c.RatioLimit, e = strconv.ParseFloat(ratio, 64)
+			if e != nil || c.RatioLimit < 0 {
+				return fmt.Errorf("ratio-limit must be a non-negative number")
+			}
+		}
+		c.RatioAction = s.Get("ratio-action", c.RatioAction)
+		if _, e := swarm.ParseRatioAction(c.RatioAction); e != nil {
+			return fmt.Errorf("ratio-action must be %s or %s", swarm.RatioStop, swarm.RatioRemove)
+		}
+		c.Encryption = s.Get("encryption", c.Encryption)
+		if _, e := mse.ParsePolicy(c.Encryption); e != nil {
+			return e
+		}
+		if c.VerifyOrder != string(swarm.VerifySmallestFirst) && c.VerifyOrder != string(swarm.VerifyRecentFirst) {
+			return fmt.Errorf("verify-order must be %s or %s", swarm.VerifySmallestFirst, swarm.VerifyRecentFirst)
+		}
 	}
 	return c.OpenTrackers.Load()
 }
@@ -119,6 +279,71 @@ func (c *BittorrentConfig) Save(s *configparser.Section) error {
 
 	s.Add("max-torrents", fmt.Sprintf("%d", c.TorrentQueueSize))
 
+	s.Add("idle-upload-timeout", fmt.Sprintf("%d", c.IdleUploadTimeout))
+	s.Add("upload-slots", fmt.Sprintf("%d", c.UploadSlots))
+	s.Add("seed-slot-turn", fmt.Sprintf("%d", c.SeedSlotTurn))
+	s.Add("snub-timeout", fmt.Sprintf("%d", c.SnubTimeout))
+	s.Add("verify-uploads", fmt.Sprintf("%d", c.VerifyUploads))
+
+	if c.SequentialFlush {
+		s.Add("sequential-flush", "1")
+	} else {
+		s.Add("sequential-flush", "0")
+	}
+
+	if c.Superseed {
+		s.Add("superseed", "1")
+	} else {
+		s.Add("superseed", "0")
+	}
+
+	if c.StrictInbound {
+		s.Add("strict-inbound", "1")
+	} else {
+		s.Add("strict-inbound", "0")
+	}
+
+	if c.AnswerNetTests {
+		s.Add("answer-nettests", "1")
+	} else {
+		s.Add("answer-nettests", "0")
+	}
+
+	s.Add("dial-retries", fmt.Sprintf("%d", c.DialRetries))
+	s.Add("dial-backoff", fmt.Sprintf("%d", c.DialBackoff))
+	s.Add("dial-max-backoff", fmt.Sprintf("%d", c.DialMaxBackoff))
+	s.Add("dial-timeout", fmt.Sprintf("%d", c.DialTimeout))
+	s.Add("handshake-timeout", fmt.Sprintf("%d", c.HandshakeTimeout))
+	s.Add("peer-read-timeout", fmt.Sprintf("%d", c.PeerReadTimeout))
+	s.Add("peer-write-timeout", fmt.Sprintf("%d", c.PeerWriteTimeout))
+	s.Add("peer-idle-timeout", fmt.Sprintf("%d", c.PeerIdleTimeout))
+	s.Add("announce-batch-window", fmt.Sprintf("%d", c.AnnounceBatchWindow))
+	s.Add("announce-interval", fmt.Sprintf("%d", c.AnnounceInterval))
+	s.Add("encryption", c.Encryption)
+	s.Add("block-size", fmt.Sprintf("%d", c.BlockSize))
+
+	if c.PersistLearnedTrackers {
+		s.Add("persist-learned-trackers", "1")
+	} else {
+		s.Add("persist-learned-trackers", "0")
+	}
+
+	s.Add("verify-workers", fmt.Sprintf("%d", c.VerifyWorkers))
+	s.Add("verify-order", c.VerifyOrder)
+	s.Add("dormant-after", fmt.Sprintf("%d", c.DormantAfter))
+	s.Add("dormant-min-age", fmt.Sprintf("%d", c.DormantMinAge))
+	s.Add("dormant-announce-interval", fmt.Sprintf("%d", c.DormantAnnounceInterval))
+	s.Add("unavailable-after", fmt.Sprintf("%d", c.UnavailableAfter))
+	s.Add("upload-limit", fmt.Sprintf("%d", c.UploadLimit))
+	s.Add("download-limit", fmt.Sprintf("%d", c.DownloadLimit))
+	s.Add("alt-upload-limit", fmt.Sprintf("%d", c.AltUploadLimit))
+	s.Add("alt-download-limit", fmt.Sprintf("%d", c.AltDownloadLimit))
+	if c.AltSchedule != "" {
+		s.Add("alt-schedule", c.AltSchedule)
+	}
+	s.Add("ratio-limit", strconv.FormatFloat(c.RatioLimit, 'f', -1, 64))
+	s.Add("ratio-action", c.RatioAction)
+
 	return c.OpenTrackers.Save()
 }
 
@@ -135,10 +360,67 @@ func (cfg *BittorrentConfig) LoadEnv() {
 
 func (c *BittorrentConfig) CreateSwarm(st storage.Storage, gnutella *gnutella.Swarm) *swarm.Swarm {
 	sw := swarm.NewSwarm(st, gnutella)
+	sw.SetTrackerProxy(c.TrackerProxy.Settings())
+	sw.SetAnnounceBatchWindow(time.Duration(c.AnnounceBatchWindow) * time.Second)
 	for name := range c.OpenTrackers.Trackers {
 		sw.AddOpenTracker(c.OpenTrackers.Trackers[name])
 	}
+	if c.bandwidth == nil {
+		c.bandwidth = swarm.NewBandwidth()
+		c.bandwidth.Configure(c.bandwidthSettings())
+	}
+	sw.Torrents.Bandwidth = c.bandwidth
 	sw.Torrents.MaxReq = c.PieceWindowSize
 	sw.Torrents.QueueSize = c.TorrentQueueSize
+	sw.Torrents.IdleUploadTimeout = time.Duration(c.IdleUploadTimeout) * time.Second
+	sw.Torrents.UploadSlots = c.UploadSlots
+	// 0 turns these off here but means the default to the holder
+	sw.Torrents.SeedSlotTurn = offIfZero(c.SeedSlotTurn)
+	sw.Torrents.SnubTimeout = offIfZero(c.SnubTimeout)
+	sw.Torrents.VerifyUploads = time.Duration(c.VerifyUploads) * time.Second
+	sw.Torrents.AnnounceInterval = time.Duration(c.AnnounceInterval) * time.Second
+	sw.Torrents.SequentialFlush = c.SequentialFlush
+	sw.Torrents.Superseed = c.Superseed
+	sw.Torrents.RatioLimit = swarm.RatioLimit{
+		Ratio:  c.RatioLimit,
+		Action: swarm.RatioAction(c.RatioAction),
+	}
+	sw.StrictInbound = c.StrictInbound
+	sw.AnswerNetTests = c.AnswerNetTests
+	sw.Encryption, _ = mse.ParsePolicy(c.Encryption)
+	sw.Torrents.RequestBlockSize = c.BlockSize
+	sw.Torrents.PersistLearnedTrackers = c.PersistLearnedTrackers
+	sw.Torrents.Unpack = c.Unpack.Settings()
+	sw.Torrents.VerifyWorkers = c.VerifyWorkers
+	sw.Torrents.VerifyOrder = swarm.VerifyOrder(c.VerifyOrder)
+	sw.Torrents.Retry = swarm.RetryPolicy{
+		Tries:      c.DialRetries,
+		Backoff:    time.Duration(c.DialBackoff) * time.Second,
+		MaxBackoff: time.Duration(c.DialMaxBackoff) * time.Second,
+	}
+	sw.Torrents.Timeouts = swarm.Timeouts{
+		Dial:      time.Duration(c.DialTimeout) * time.Second,
+		Handshake: time.Duration(c.HandshakeTimeout) * time.Second,
+		Read:      time.Duration(c.PeerReadTimeout) * time.Second,
+		Write:     time.Duration(c.PeerWriteTimeout) * time.Second,
+		Idle:      time.Duration(c.PeerIdleTimeout) * time.Second,
+	}
+	sw.Torrents.Tiering = swarm.TierPolicy{
+		DormantAfter:     time.Duration(c.DormantAfter) * time.Second,
+		MinAge:           time.Duration(c.DormantMinAge) * time.Second,
+		AnnounceInterval: time.Duration(c.DormantAnnounceInterval) * time.Second,
+		UnavailableAfter: time.Duration(c.UnavailableAfter) * time.Second,
+	}
+	if c.DHT {
+		sw.EnableDHT()
+	}
 	return sw
 }
+
+// seconds as a duration, 0 as a negative one for settings of the holder where 0 is the default
+func offIfZero(seconds int) time.Duration {
+	if seconds == 0 {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -2,16 +2,19 @@ package config
 
 import (
 	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/log"
 )
 
 type Config struct {
 	LokiNet    LokiNetConfig
+	Loopback   LoopbackConfig
 	I2P        I2PConfig
 	Storage    StorageConfig
 	RPC        RPCConfig
 	Log        LogConfig
 	Bittorrent BittorrentConfig
 	Gnutella   G2Config
+	Update     UpdateConfig
 }
 
 // Configurable interface for entity serializable to/from config parser section
@@ -21,19 +24,37 @@ type Configurable interface {
 	LoadEnv()
 }
 
-// Load loads a config from file by filename
+// Load loads a config from file by filename, the format is picked by file extension
 func (cfg *Config) Load(fname string) (err error) {
 	sects := map[string]Configurable{
-		"lokinet":    &cfg.LokiNet,
-		"i2p":        &cfg.I2P,
-		"storage":    &cfg.Storage,
-		"rpc":        &cfg.RPC,
-		"log":        &cfg.Log,
-		"bittorrent": &cfg.Bittorrent,
-		"gnutella":   &cfg.Gnutella,
+		"lokinet":       &cfg.LokiNet,
+		"loopback":      &cfg.Loopback,
+		"i2p":           &cfg.I2P,
+		"storage":       &cfg.Storage,
+		"rpc":           &cfg.RPC,
+		"rpc-users":     &cfg.RPC.Users,
+		"log":           &cfg.Log,
+		"bittorrent":    &cfg.Bittorrent,
+		"tracker-proxy": &cfg.Bittorrent.TrackerProxy,
+		"unpack":        &cfg.Bittorrent.Unpack,
+		"gnutella":      &cfg.Gnutella,
+		"update":        &cfg.Update,
 	}
 	var c *configparser.Configuration
-	c, err = configparser.Read(fname)
+	c, err = configparser.ReadFile(fname)
+	if _, ok := err.(*configparser.ParseError); ok {
+		return
+	}
+	if c != nil {
+		for _, e := range Validate(c) {
+			if e.Unknown {
+				log.Warnf("config: %s", e.Error())
+			} else {
+				err = e
+				return
+			}
+		}
+	}
 	for sect, conf := range sects {
 		if c == nil {
 			err = conf.Load(nil)
@@ -49,16 +70,21 @@ func (cfg *Config) Load(fname string) (err error) {
 	return
 }
 
-// Save saves a loaded config to file by filename
+// Save saves a loaded config to file by filename, the format is picked by file extension
 func (cfg *Config) Save(fname string) (err error) {
 	sects := map[string]Configurable{
-		"lokinet":    &cfg.LokiNet,
-		"i2p":        &cfg.I2P,
-		"storage":    &cfg.Storage,
-		"rpc":        &cfg.RPC,
-		"log":        &cfg.Log,
-		"bittorrent": &cfg.Bittorrent,
-		"gnutella":   &cfg.Gnutella,
+		"lokinet":       &cfg.LokiNet,
+		"loopback":      &cfg.Loopback,
+		"i2p":           &cfg.I2P,
+		"storage":       &cfg.Storage,
+		"rpc":           &cfg.RPC,
+		"rpc-users":     &cfg.RPC.Users,
+		"log":           &cfg.Log,
+		"bittorrent":    &cfg.Bittorrent,
+		"tracker-proxy": &cfg.Bittorrent.TrackerProxy,
+		"unpack":        &cfg.Bittorrent.Unpack,
+		"gnutella":      &cfg.Gnutella,
+		"update":        &cfg.Update,
 	}
 	c := configparser.NewConfiguration()
 	for sect, conf := range sects {
@@ -68,6 +94,6 @@ func (cfg *Config) Save(fname string) (err error) {
 			return
 		}
 	}
-	err = configparser.Save(c, fname)
+	err = configparser.SaveFile(c, fname)
 	return
 }
@@ -1,11 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"github.com/majestrate/XD/lib/configparser"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/network/i2p"
 	"github.com/majestrate/XD/lib/util"
 	"os"
+	"time"
 )
 
 type I2PConfig struct {
@@ -15,8 +17,22 @@ type I2PConfig struct {
 	nameWasProvided bool
 	I2CPOptions     map[string]string
 	Disabled        bool
+	// file to persist naming lookups to, empty means don't persist
+	NameCache string
+	// how long cached naming lookups are good for
+	NameCacheTTL time.Duration
+	names        *i2p.NameCache
+	// url of the router's i2pcontrol api for health checks, empty disables
+	ControlURL      string
+	ControlPassword string
+	// how long the router has to connect our dials to peers, 0 waits forever
+	DialTimeout time.Duration
+	PeerTransportConfig
 }
 
+// DefaultI2PNameCache is the default file i2p naming lookups are cached in
+const DefaultI2PNameCache = "i2p-names.txt"
+
 func (cfg *I2PConfig) Load(section *configparser.Section) error {
 	cfg.I2CPOptions = make(map[string]string)
 	if section == nil {
@@ -24,6 +40,10 @@ func (cfg *I2PConfig) Load(section *configparser.Section) error {
 		cfg.Keyfile = ""
 		cfg.Name = util.RandStr(5)
 		cfg.Disabled = DisableI2PByDefault
+		cfg.NameCache = DefaultI2PNameCache
+		cfg.NameCacheTTL = i2p.DefaultNameCacheTTL
+		cfg.ControlPassword = i2p.DefaultControlPassword
+		cfg.DialTimeout = i2p.DefaultDialTimeout
 	} else {
 		cfg.Disabled = section.Get("disabled", "") == "1"
 		cfg.Addr = section.Get("address", i2p.DEFAULT_ADDRESS)
@@ -31,14 +51,21 @@ func (cfg *I2PConfig) Load(section *configparser.Section) error {
 		gen := util.RandStr(5)
 		cfg.Name = section.Get("session", gen)
 		cfg.nameWasProvided = cfg.Name != gen
+		cfg.NameCache = section.Get("namecache", DefaultI2PNameCache)
+		cfg.NameCacheTTL = time.Duration(section.GetInt("namecache_ttl", int(i2p.DefaultNameCacheTTL/time.Second))) * time.Second
+		cfg.ControlURL = section.Get("i2pcontrol", "")
+		cfg.ControlPassword = section.Get("i2pcontrol_password", i2p.DefaultControlPassword)
+		cfg.DialTimeout = time.Duration(section.GetInt("dial_timeout", int(i2p.DefaultDialTimeout/time.Second))) * time.Second
 		opts := section.Options()
 		for k, v := range opts {
-			if k == "address" || k == "keyfile" || k == "session" || k == "disabled" {
+			if k == "address" || k == "keyfile" || k == "session" || k == "disabled" || k == "namecache" || k == "namecache_ttl" || k == "i2pcontrol" || k == "i2pcontrol_password" || k == "dial_timeout" || isPeerTransportKey(k) {
 				continue
 			}
 			cfg.I2CPOptions[k] = v
 		}
 	}
+	cfg.loadTransport(section)
+	cfg.names = i2p.NewNameCache(cfg.NameCache, cfg.NameCacheTTL)
 	return nil
 }
 
@@ -56,11 +83,19 @@ func (cfg *I2PConfig) Save(s *configparser.Section) error {
 	if cfg.nameWasProvided {
 		opts["session"] = cfg.Name
 	}
+	opts["namecache"] = cfg.NameCache
+	opts["namecache_ttl"] = fmt.Sprintf("%d", int(cfg.NameCacheTTL/time.Second))
+	opts["dial_timeout"] = fmt.Sprintf("%d", int(cfg.DialTimeout/time.Second))
+	if cfg.ControlURL != "" {
+		opts["i2pcontrol"] = cfg.ControlURL
+		opts["i2pcontrol_password"] = cfg.ControlPassword
+	}
 	if cfg.Disabled {
 		opts["disabled"] = "1"
 	} else {
 		opts["disabled"] = "0"
 	}
+	cfg.saveTransport(opts)
 	for k := range opts {
 		s.Add(k, opts[k])
 	}
@@ -70,7 +105,16 @@ func (cfg *I2PConfig) Save(s *configparser.Section) error {
 // create an i2p session from this config
 func (cfg *I2PConfig) CreateSession() i2p.Session {
 	log.Infof("create new i2p session with %s", cfg.Addr)
-	return i2p.NewSession(util.RandStr(5), cfg.Addr, cfg.Keyfile, cfg.I2CPOptions)
+	var control *i2p.ControlSettings
+	if cfg.ControlURL != "" {
+		control = &i2p.ControlSettings{
+			URL:      cfg.ControlURL,
+			Password: cfg.ControlPassword,
+		}
+	}
+	s := i2p.NewSession(util.RandStr(5), cfg.Addr, cfg.Keyfile, cfg.I2CPOptions, cfg.names, control)
+	s.SetDialTimeout(cfg.DialTimeout)
+	return s
 }
 
 // EnvI2PAddress is the name of the environmental variable to set the i2p address for XD
@@ -11,6 +11,7 @@ type LokiNetConfig struct {
 	DNSAddr  string
 	Port     string
 	Disabled bool
+	PeerTransportConfig
 }
 
 func (cfg *LokiNetConfig) Load(section *configparser.Section) error {
@@ -23,6 +24,7 @@ func (cfg *LokiNetConfig) Load(section *configparser.Section) error {
 		cfg.DNSAddr = section.Get("dns", inet.DefaultDNSAddr)
 		cfg.Port = section.Get("port", inet.DefaultPort)
 	}
+	cfg.loadTransport(section)
 	return nil
 }
 
@@ -32,6 +34,7 @@ func (cfg *LokiNetConfig) Save(s *configparser.Section) error {
 	if cfg.Disabled {
 		opts["disabled"] = "1"
 	}
+	cfg.saveTransport(opts)
 	for k := range opts {
 		s.Add(k, opts[k])
 	}
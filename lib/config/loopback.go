@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network/loopback"
+	"os"
+)
+
+// LoopbackConfig runs the swarms on an in process network instead of i2p or lokinet, for tests and demos
+type LoopbackConfig struct {
+	Enabled bool
+}
+
+func (cfg *LoopbackConfig) Load(section *configparser.Section) error {
+	cfg.Enabled = false
+	if section != nil {
+		cfg.Enabled = section.Get("enabled", "") == "1"
+	}
+	return nil
+}
+
+func (cfg *LoopbackConfig) Save(s *configparser.Section) error {
+	if cfg.Enabled {
+		s.Add("enabled", "1")
+	}
+	return nil
+}
+
+// create the session of swarm number idx on hub
+func (cfg *LoopbackConfig) CreateSession(hub *loopback.Hub, idx int) *loopback.Session {
+	log.Infof("create new session on loopback network")
+	return hub.NewSession(fmt.Sprintf("swarm-%d", idx))
+}
+
+// EnvLoopback is the name of the environmental variable that turns on the loopback network when set to 1
+const EnvLoopback = "XD_LOOPBACK"
+
+func (cfg *LoopbackConfig) LoadEnv() {
+	if os.Getenv(EnvLoopback) == "1" {
+		cfg.Enabled = true
+	}
+}
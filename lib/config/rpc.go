@@ -12,6 +12,10 @@ type RPCConfig struct {
 	Auth         bool
 	Username     string
 	Password     string
+	// serve pprof on the rpc server, needs auth
+	Pprof bool
+	// more users who may log in, see RPCUsersConfig
+	Users RPCUsersConfig
 }
 
 const DefaultRPCAddr = "127.0.0.1:1776"
@@ -26,6 +30,7 @@ func (cfg *RPCConfig) Load(s *configparser.Section) error {
 		cfg.Auth = s.Get("auth", DefaultRPCAuth) == "1"
 		cfg.Username = s.Get("username", "")
 		cfg.Password = s.Get("password", "")
+		cfg.Pprof = s.Get("pprof", "0") == "1"
 	}
 	if cfg.Bind == "" {
 		cfg.Bind = DefaultRPCAddr
@@ -56,6 +61,9 @@ func (cfg *RPCConfig) Save(s *configparser.Section) error {
 		opts["auth"] = "1"
 		opts["username"] = cfg.Username
 		opts["password"] = cfg.Password
+		if cfg.Pprof {
+			opts["pprof"] = "1"
+		}
 	}
 
 	for k := range opts {
@@ -65,6 +73,26 @@ func (cfg *RPCConfig) Save(s *configparser.Section) error {
 	return nil
 }
 
+// Accounts gets everyone who may log in to the rpc, the auth username is an admin
+// an empty list means the rpc needs no login
+func (cfg *RPCConfig) Accounts() (users []RPCUser) {
+	if cfg.Auth && cfg.Username != "" && cfg.Password != "" {
+		users = append(users, RPCUser{
+			Name:     cfg.Username,
+			Password: cfg.Password,
+			Admin:    true,
+		})
+	}
+	for _, u := range cfg.Users.Users {
+		if u.Name == cfg.Username && cfg.Auth {
+			// the auth user stays an admin
+			continue
+		}
+		users = append(users, u)
+	}
+	return
+}
+
 const EnvRPCAddr = "XD_RPC_ADDRESS"
 const EnvRPCHost = "XD_RPC_HOST"
 
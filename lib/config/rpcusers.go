@@ -0,0 +1,67 @@
+package config
+
+import (
+	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/log"
+	"strings"
+)
+
+// RPCUser is someone who may log in to the rpc
+type RPCUser struct {
+	Name     string
+	Password string
+	// admins see and control every torrent and the swarm itself, other users only their own torrents
+	Admin bool
+}
+
+// roles of rpc users
+const (
+	RPCRoleAdmin = "admin"
+	RPCRoleUser  = "user"
+)
+
+// RPCUsersConfig turns on multi-user rpc when it has any users
+// every option is a user of the form: username=role:password where role is admin or user
+type RPCUsersConfig struct {
+	Users []RPCUser
+}
+
+func (cfg *RPCUsersConfig) Load(s *configparser.Section) error {
+	cfg.Users = nil
+	if s == nil {
+		return nil
+	}
+	for _, name := range s.OptionNames() {
+		v := s.ValueOf(name)
+		idx := strings.Index(v, ":")
+		if idx == -1 || idx == len(v)-1 {
+			log.Warnf("ignoring rpc user %s, expected role:password", name)
+			continue
+		}
+		role := strings.ToLower(strings.TrimSpace(v[:idx]))
+		if role != RPCRoleAdmin && role != RPCRoleUser {
+			log.Warnf("ignoring rpc user %s, invalid role: %s", name, role)
+			continue
+		}
+		cfg.Users = append(cfg.Users, RPCUser{
+			Name:     name,
+			Password: v[idx+1:],
+			Admin:    role == RPCRoleAdmin,
+		})
+	}
+	return nil
+}
+
+func (cfg *RPCUsersConfig) Save(s *configparser.Section) error {
+	for _, u := range cfg.Users {
+		role := RPCRoleUser
+		if u.Admin {
+			role = RPCRoleAdmin
+		}
+		s.Add(u.Name, role+":"+u.Password)
+	}
+	return nil
+}
+
+func (cfg *RPCUsersConfig) LoadEnv() {
+}
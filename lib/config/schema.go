@@ -0,0 +1,285 @@
+package config
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/configparser"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kind of value a config key holds
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindUint
+	kindBool
+	kindFloat
+)
+
+func (k valueKind) check(value string) string {
+	switch k {
+	case kindUint:
+		if i, err := strconv.Atoi(value); err != nil || i < 0 {
+			return "expected a non-negative integer"
+		}
+	case kindBool:
+		if value != "0" && value != "1" {
+			return "expected 1 or 0"
+		}
+	case kindFloat:
+		if f, err := strconv.ParseFloat(value, 64); err != nil || f < 0 {
+			return "expected a non-negative number"
+		}
+	}
+	return ""
+}
+
+type sectionSchema struct {
+	keys map[string]valueKind
+	// unknown keys are allowed and passed through, like i2cp options
+	freeform bool
+}
+
+var schema = map[string]sectionSchema{
+	"lokinet": {keys: map[string]valueKind{
+		"disabled": kindBool,
+		"dns":      kindString,
+		"port":     kindString,
+		// peer connection transport, see lib/network/transport
+		"transport":             kindString,
+		"transport_secret":      kindString,
+		"transport_cert":        kindString,
+		"transport_key":         kindString,
+		"transport_fingerprint": kindString,
+	}},
+	"loopback": {keys: map[string]valueKind{
+		"enabled": kindBool,
+	}},
+	"i2p": {freeform: true, keys: map[string]valueKind{
+		"disabled":            kindBool,
+		"address":             kindString,
+		"keyfile":             kindString,
+		"session":             kindString,
+		"namecache":           kindString,
+		"namecache_ttl":       kindUint,
+		"i2pcontrol":          kindString,
+		"i2pcontrol_password": kindString,
+		"dial_timeout":        kindUint,
+		// peer connection transport, see lib/network/transport
+		"transport":             kindString,
+		"transport_secret":      kindString,
+		"transport_cert":        kindString,
+		"transport_key":         kindString,
+		"transport_fingerprint": kindString,
+	}},
+	"storage": {keys: map[string]valueKind{
+		"rootdir":                  kindString,
+		"metadata":                 kindString,
+		"downloads":                kindString,
+		"completed":                kindString,
+		"workers":                  kindUint,
+		"iop_buffer_size":          kindUint,
+		"journal_size":             kindUint,
+		"trash":                    kindString,
+		"trash_purge_hours":        kindUint,
+		"snapshot_retention_hours": kindUint,
+		"audit_log_size":           kindUint,
+		"start_paused":             kindBool,
+		"library":                  kindString,
+		"max_files":                kindUint,
+		"max_path_depth":           kindUint,
+		"max_pieces":               kindUint,
+		"file_mode":                kindString,
+		"dir_mode":                 kindString,
+		"owner":                    kindString,
+		"sftp":                     kindBool,
+		"sftp_user":                kindString,
+		"sftp_host":                kindString,
+		"sftp_keyfile":             kindString,
+		"sftp_remotekey":           kindString,
+		"sftp_port":                kindUint,
+	}},
+	"rpc": {keys: map[string]valueKind{
+		"enabled":  kindBool,
+		"bind":     kindString,
+		"host":     kindString,
+		"auth":     kindBool,
+		"username": kindString,
+		"password": kindString,
+		"pprof":    kindBool,
+	}},
+	// every key is a user
+	"rpc-users": {freeform: true, keys: map[string]valueKind{}},
+	"log": {keys: map[string]valueKind{
+		"level": kindString,
+		"pprof": kindBool,
+	}},
+	"bittorrent": {keys: map[string]valueKind{
+		"dht":                       kindBool,
+		"pex":                       kindBool,
+		"tracker-config":            kindString,
+		"piece-window":              kindUint,
+		"swarms":                    kindUint,
+		"dial-timeout":              kindUint,
+		"handshake-timeout":         kindUint,
+		"peer-read-timeout":         kindUint,
+		"peer-write-timeout":        kindUint,
+		"peer-idle-timeout":         kindUint,
+		"encryption":                kindString,
+		"max-torrents":              kindUint,
+		"idle-upload-timeout":       kindUint,
+		"upload-slots":              kindUint,
+		"seed-slot-turn":            kindUint,
+		"snub-timeout":              kindUint,
+		"verify-uploads":            kindUint,
+		"sequential-flush":          kindBool,
+		"superseed":                 kindBool,
+		"strict-inbound":            kindBool,
+		"answer-nettests":           kindBool,
+		"dial-retries":              kindUint,
+		"dial-backoff":              kindUint,
+		"announce-interval":         kindUint,
+		"announce-batch-window":     kindUint,
+		"dial-max-backoff":          kindUint,
+		"block-size":                kindUint,
+		"persist-learned-trackers":  kindBool,
+		"verify-workers":            kindUint,
+		"verify-order":              kindString,
+		"dormant-after":             kindUint,
+		"dormant-min-age":           kindUint,
+		"dormant-announce-interval": kindUint,
+		"unavailable-after":         kindUint,
+		"upload-limit":              kindUint,
+		"download-limit":            kindUint,
+		"alt-upload-limit":          kindUint,
+		"alt-download-limit":        kindUint,
+		"alt-schedule":              kindString,
+		"ratio-limit":               kindFloat,
+		"ratio-action":              kindString,
+	}},
+	// the rest of the keys are tracker proxy rules
+	"tracker-proxy": {freeform: true, keys: map[string]valueKind{
+		"enabled":  kindBool,
+		"outproxy": kindString,
+		"socks":    kindString,
+	}},
+	"unpack": {keys: map[string]valueKind{
+		"zip":         kindBool,
+		"rar":         kindBool,
+		"rar-command": kindString,
+		"command":     kindString,
+		"dirs":        kindString,
+	}},
+	"gnutella": {keys: map[string]valueKind{
+		"enabled": kindBool,
+	}},
+	"update": {keys: map[string]valueKind{
+		"feed":     kindString,
+		"interval": kindUint,
+	}},
+}
+
+// ValidationError is a config value that does not match the schema
+type ValidationError struct {
+	File    string
+	Line    int
+	Section string
+	Key     string
+	Msg     string
+	// true if the section or key is not known, we only warn about those when loading
+	Unknown bool
+}
+
+func (e *ValidationError) Error() string {
+	where := fmt.Sprintf("[%s]", e.Section)
+	if e.Key != "" {
+		where += " " + e.Key
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, where, e.Msg)
+}
+
+// Validate checks a parsed config against the schema
+func Validate(c *configparser.Configuration) (errs []*ValidationError) {
+	sects, _ := c.AllSections()
+	for _, s := range sects {
+		name := s.Name()
+		if name == "global" {
+			for _, k := range s.OptionNames() {
+				errs = append(errs, &ValidationError{
+					File:    c.FilePath(),
+					Line:    s.LineOf(k),
+					Section: name,
+					Key:     k,
+					Msg:     "option outside of a section",
+					Unknown: true,
+				})
+			}
+			continue
+		}
+		sch, ok := schema[name]
+		if !ok {
+			errs = append(errs, &ValidationError{
+				File:    c.FilePath(),
+				Line:    s.Line(),
+				Section: name,
+				Msg:     "unknown section",
+				Unknown: true,
+			})
+			continue
+		}
+		for _, k := range s.OptionNames() {
+			kind, ok := sch.keys[k]
+			if !ok {
+				if !sch.freeform {
+					errs = append(errs, &ValidationError{
+						File:    c.FilePath(),
+						Line:    s.LineOf(k),
+						Section: name,
+						Key:     k,
+						Msg:     "unknown key, expected one of " + sch.keyNames(),
+						Unknown: true,
+					})
+				}
+				continue
+			}
+			v := s.ValueOf(k)
+			if v == "" {
+				// empty means use the default
+				continue
+			}
+			if msg := kind.check(v); msg != "" {
+				errs = append(errs, &ValidationError{
+					File:    c.FilePath(),
+					Line:    s.LineOf(k),
+					Section: name,
+					Key:     k,
+					Msg:     fmt.Sprintf("%s, got %q", msg, v),
+				})
+			}
+		}
+	}
+	return
+}
+
+func (sch sectionSchema) keyNames() string {
+	var keys []string
+	for k := range sch.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// Check parses a config file and validates it against the schema
+func Check(fname string) (errs []error) {
+	c, err := configparser.ReadFile(fname)
+	if err != nil {
+		return []error{err}
+	}
+	for _, e := range Validate(c) {
+		errs = append(errs, e)
+	}
+	return
+}
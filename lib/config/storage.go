@@ -2,11 +2,19 @@ package config
 
 import (
 	"fmt"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/configparser"
 	"github.com/majestrate/XD/lib/fs"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/stats"
 	"github.com/majestrate/XD/lib/storage"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // EnvRootDir is the name of the environmental variable to set the root storage directory at runtime
@@ -55,6 +63,31 @@ type StorageConfig struct {
 	Workers int
 	// number of buffered iops when using pooled io
 	IOPBufferSize int
+	// number of verified pieces to journal before flushing bitfields, 0 flushes every piece
+	JournalSize int
+	// directory deleted torrents are moved to, deletes right away if empty
+	Trash string
+	// hours deleted torrents stay in the trash
+	TrashPurgeHours int
+	// hours swarm statistics snapshots are kept for
+	SnapshotRetentionHours int
+	// how many audit log events are kept
+	AuditLogSize int
+	// add new torrents stopped
+	StartPaused bool
+	// directory completed data is hardlinked into, disabled if empty
+	Library string
+	// biggest torrents we accept
+	MaxFiles     int
+	MaxPathDepth int
+	MaxPieces    int
+	// octal mode bits of the data files and directories we create, left as created if empty
+	FileMode string
+	DirMode  string
+	// user or user:group, by name or number, to give the data files and directories we create to, needs root
+	Owner string
+	// what FileMode, DirMode and Owner say, made by Load
+	perms storage.Permissions
 	// sftp config
 	SFTP SFTPConfig
 }
@@ -71,6 +104,27 @@ func (cfg *StorageConfig) Load(s *configparser.Section) error {
 	if s != nil {
 		cfg.Workers = s.GetInt("workers", 0)
 		cfg.IOPBufferSize = s.GetInt("iop_buffer_size", 256)
+		cfg.JournalSize = s.GetInt("journal_size", storage.DefaultJournalSize)
+		cfg.Trash = s.Get("trash", "")
+		cfg.TrashPurgeHours = s.GetInt("trash_purge_hours", int(storage.DefaultTrashPurgeAfter/time.Hour))
+		cfg.SnapshotRetentionHours = s.GetInt("snapshot_retention_hours", int(stats.DefaultSnapshotRetention/time.Hour))
+		cfg.AuditLogSize = s.GetInt("audit_log_size", audit.DefaultLogSize)
+		cfg.StartPaused = s.Get("start_paused", "0") == "1"
+		cfg.Library = s.Get("library", "")
+		cfg.MaxFiles = s.GetInt("max_files", metainfo.DefaultLimits.MaxFiles)
+		cfg.MaxPathDepth = s.GetInt("max_path_depth", metainfo.DefaultLimits.MaxPathDepth)
+		cfg.MaxPieces = s.GetInt("max_pieces", int(metainfo.DefaultLimits.MaxPieces))
+		cfg.FileMode = s.Get("file_mode", "")
+		cfg.DirMode = s.Get("dir_mode", "")
+		cfg.Owner = s.Get("owner", "")
+	} else {
+		cfg.MaxFiles = metainfo.DefaultLimits.MaxFiles
+		cfg.MaxPathDepth = metainfo.DefaultLimits.MaxPathDepth
+		cfg.MaxPieces = int(metainfo.DefaultLimits.MaxPieces)
+		cfg.JournalSize = storage.DefaultJournalSize
+		cfg.TrashPurgeHours = int(storage.DefaultTrashPurgeAfter / time.Hour)
+		cfg.SnapshotRetentionHours = int(stats.DefaultSnapshotRetention / time.Hour)
+		cfg.AuditLogSize = audit.DefaultLogSize
 	}
 
 	cfg.setSubpaths(s)
@@ -78,6 +132,11 @@ func (cfg *StorageConfig) Load(s *configparser.Section) error {
 	if s != nil {
 		cfg.SFTP.Enabled = s.Get("sftp", "0") == "1"
 	}
+	var err error
+	cfg.perms, err = cfg.Permissions()
+	if err != nil {
+		return err
+	}
 	if cfg.SFTP.Enabled {
 		return cfg.SFTP.Load(s)
 	}
@@ -85,6 +144,12 @@ func (cfg *StorageConfig) Load(s *configparser.Section) error {
 
 }
 
+// SetRoot sets the root storage directory and puts the metadata, downloads and seeding directories under it
+func (cfg *StorageConfig) SetRoot(root string) {
+	cfg.Root = root
+	cfg.setSubpaths(nil)
+}
+
 func (cfg *StorageConfig) setSubpaths(s *configparser.Section) {
 	cfg.Meta = filepath.Join(cfg.Root, "metadata")
 
@@ -105,9 +170,102 @@ func (cfg *StorageConfig) Save(s *configparser.Section) error {
 	s.Add("completed", cfg.Completed)
 	s.Add("workers", fmt.Sprintf("%d", cfg.Workers))
 	s.Add("iop_buffer_size", fmt.Sprintf("%d", cfg.IOPBufferSize))
+	s.Add("journal_size", fmt.Sprintf("%d", cfg.JournalSize))
+	if cfg.Trash != "" {
+		s.Add("trash", cfg.Trash)
+	}
+	s.Add("trash_purge_hours", fmt.Sprintf("%d", cfg.TrashPurgeHours))
+	s.Add("snapshot_retention_hours", fmt.Sprintf("%d", cfg.SnapshotRetentionHours))
+	s.Add("audit_log_size", fmt.Sprintf("%d", cfg.AuditLogSize))
+	if cfg.StartPaused {
+		s.Add("start_paused", "1")
+	} else {
+		s.Add("start_paused", "0")
+	}
+	if cfg.Library != "" {
+		s.Add("library", cfg.Library)
+	}
+	s.Add("max_files", fmt.Sprintf("%d", cfg.MaxFiles))
+	s.Add("max_path_depth", fmt.Sprintf("%d", cfg.MaxPathDepth))
+	s.Add("max_pieces", fmt.Sprintf("%d", cfg.MaxPieces))
+	if cfg.FileMode != "" {
+		s.Add("file_mode", cfg.FileMode)
+	}
+	if cfg.DirMode != "" {
+		s.Add("dir_mode", cfg.DirMode)
+	}
+	if cfg.Owner != "" {
+		s.Add("owner", cfg.Owner)
+	}
 	return nil
 }
 
+// parse octal mode bits, 0 if empty
+func parseMode(key, str string) (os.FileMode, error) {
+	if str == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("bad %s %q, expected octal mode bits like 0644", key, str)
+	}
+	return os.FileMode(mode), nil
+}
+
+// look up a user or group id by name or number
+func lookupID(str string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(str); err == nil {
+		return id, nil
+	}
+	id, err := lookup(str)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// Permissions gets the mode and owner data files and directories get
+// chowning is left out with a warning when we are not root
+func (cfg *StorageConfig) Permissions() (p storage.Permissions, err error) {
+	p.FileMode, err = parseMode("file_mode", cfg.FileMode)
+	if err == nil {
+		p.DirMode, err = parseMode("dir_mode", cfg.DirMode)
+	}
+	if err != nil || cfg.Owner == "" {
+		return
+	}
+	p.UID, p.GID = -1, -1
+	owner := strings.SplitN(cfg.Owner, ":", 2)
+	if owner[0] != "" {
+		p.UID, err = lookupID(owner[0], func(name string) (string, error) {
+			u, e := user.Lookup(name)
+			if e != nil {
+				return "", e
+			}
+			return u.Uid, nil
+		})
+	}
+	if err == nil && len(owner) == 2 && owner[1] != "" {
+		p.GID, err = lookupID(owner[1], func(name string) (string, error) {
+			g, e := user.LookupGroup(name)
+			if e != nil {
+				return "", e
+			}
+			return g.Gid, nil
+		})
+	}
+	if err != nil {
+		err = fmt.Errorf("bad owner %q: %s", cfg.Owner, err.Error())
+		return
+	}
+	if os.Geteuid() != 0 && !cfg.SFTP.Enabled {
+		log.Warnf("not running as root, not giving data files to %s", cfg.Owner)
+		return
+	}
+	p.Chown = true
+	return
+}
+
 func (cfg *StorageConfig) LoadEnv() {
 	dir := os.Getenv(EnvRootDir)
 	if dir != "" {
@@ -119,12 +277,25 @@ func (cfg *StorageConfig) LoadEnv() {
 func (cfg *StorageConfig) CreateStorage() storage.Storage {
 
 	st := &storage.FsStorage{
-		SeedingDir:    cfg.Completed,
-		DataDir:       cfg.Downloads,
-		MetaDir:       cfg.Meta,
-		FS:            fs.STD,
-		IOPBufferSize: cfg.IOPBufferSize,
-		Workers:       cfg.Workers,
+		SeedingDir:        cfg.Completed,
+		DataDir:           cfg.Downloads,
+		MetaDir:           cfg.Meta,
+		FS:                fs.STD,
+		IOPBufferSize:     cfg.IOPBufferSize,
+		Workers:           cfg.Workers,
+		JournalSize:       cfg.JournalSize,
+		TrashDir:          cfg.Trash,
+		TrashPurgeAfter:   time.Duration(cfg.TrashPurgeHours) * time.Hour,
+		SnapshotRetention: time.Duration(cfg.SnapshotRetentionHours) * time.Hour,
+		AuditLogSize:      cfg.AuditLogSize,
+		StartPaused:       cfg.StartPaused,
+		LibraryDir:        cfg.Library,
+		Limits: metainfo.Limits{
+			MaxFiles:     cfg.MaxFiles,
+			MaxPathDepth: cfg.MaxPathDepth,
+			MaxPieces:    uint32(cfg.MaxPieces),
+		},
+		Perms: cfg.perms,
 	}
 	if cfg.SFTP.Enabled {
 		st.FS = cfg.SFTP.ToFS()
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/tracker"
+	"os"
+)
+
+// TrackerProxyConfig configures announcing to trackers that are not on our network
+// any option that is not a setting is a routing rule of the form: pattern=route
+type TrackerProxyConfig struct {
+	Enabled  bool
+	Outproxy string
+	SOCKS    string
+	Rules    []tracker.ProxyRule
+}
+
+func (cfg *TrackerProxyConfig) Load(s *configparser.Section) error {
+	cfg.Rules = nil
+	if s == nil {
+		cfg.Enabled = false
+		cfg.Outproxy = ""
+		cfg.SOCKS = ""
+		return nil
+	}
+	cfg.Enabled = s.Get("enabled", "0") == "1"
+	cfg.Outproxy = s.Get("outproxy", "")
+	cfg.SOCKS = s.Get("socks", "")
+	for _, k := range s.OptionNames() {
+		if k == "enabled" || k == "outproxy" || k == "socks" {
+			continue
+		}
+		route, ok := tracker.ParseRoute(s.ValueOf(k))
+		if !ok {
+			log.Warnf("ignoring tracker proxy rule for %s, invalid route: %s", k, s.ValueOf(k))
+			continue
+		}
+		cfg.Rules = append(cfg.Rules, tracker.ProxyRule{
+			Pattern: k,
+			Route:   route,
+		})
+	}
+	return nil
+}
+
+func (cfg *TrackerProxyConfig) Save(s *configparser.Section) error {
+	if cfg.Enabled {
+		s.Add("enabled", "1")
+	} else {
+		s.Add("enabled", "0")
+	}
+	if cfg.Outproxy != "" {
+		s.Add("outproxy", cfg.Outproxy)
+	}
+	if cfg.SOCKS != "" {
+		s.Add("socks", cfg.SOCKS)
+	}
+	for _, rule := range cfg.Rules {
+		s.Add(rule.Pattern, rule.Route.String())
+	}
+	return nil
+}
+
+// EnvTrackerSOCKS is the name of the environmental variable to set a socks proxy for clearnet trackers
+const EnvTrackerSOCKS = "XD_TRACKER_SOCKS"
+
+func (cfg *TrackerProxyConfig) LoadEnv() {
+	socks := os.Getenv(EnvTrackerSOCKS)
+	if socks != "" {
+		cfg.SOCKS = socks
+		cfg.Enabled = true
+	}
+}
+
+// Settings gets the tracker proxy settings for a swarm
+func (cfg *TrackerProxyConfig) Settings() *tracker.ProxySettings {
+	return &tracker.ProxySettings{
+		Enabled:  cfg.Enabled,
+		Outproxy: cfg.Outproxy,
+		SOCKS:    cfg.SOCKS,
+		Rules:    cfg.Rules,
+	}
+}
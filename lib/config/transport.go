@@ -0,0 +1,53 @@
+package config
+
+import (
+	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/network/transport"
+	"strings"
+)
+
+// PeerTransportConfig is the transport that wraps peer connections on one network
+type PeerTransportConfig struct {
+	// name of the transport that wraps peer connections, plain connections if empty
+	Transport string
+	// options for the transport from the keys starting with transport_
+	TransportOpts map[string]string
+}
+
+// option keys for the peer transport, without the transport_ prefix
+var peerTransportOpts = []string{"secret", "cert", "key", "fingerprint"}
+
+// returns true if k is one of the keys of a network section that configure its peer transport
+func isPeerTransportKey(k string) bool {
+	return k == "transport" || strings.HasPrefix(k, "transport_")
+}
+
+func (cfg *PeerTransportConfig) loadTransport(section *configparser.Section) {
+	cfg.TransportOpts = make(map[string]string)
+	if section == nil {
+		return
+	}
+	cfg.Transport = section.Get("transport", "")
+	for _, k := range peerTransportOpts {
+		if v := section.Get("transport_"+k, ""); v != "" {
+			cfg.TransportOpts[k] = v
+		}
+	}
+}
+
+func (cfg *PeerTransportConfig) saveTransport(opts map[string]string) {
+	if cfg.Transport != "" {
+		opts["transport"] = cfg.Transport
+		for k, v := range cfg.TransportOpts {
+			opts["transport_"+k] = v
+		}
+	}
+}
+
+// PeerTransport makes the transport that wraps peer connections, nil if we use plain connections
+func (cfg *PeerTransportConfig) PeerTransport() (transport.Transport, error) {
+	if cfg.Transport == "" {
+		return nil, nil
+	}
+	return transport.New(cfg.Transport, cfg.TransportOpts)
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/unpack"
+	"strings"
+)
+
+// UnpackConfig configures what we do with downloads once they complete
+type UnpackConfig struct {
+	Zip        bool
+	Rar        bool
+	RarCommand string
+	Command    string
+	// download directories to unpack in, all of them if empty
+	Dirs []string
+}
+
+func (cfg *UnpackConfig) Load(s *configparser.Section) error {
+	cfg.Zip = false
+	cfg.Rar = false
+	cfg.RarCommand = unpack.DefaultRarCommand
+	cfg.Command = ""
+	cfg.Dirs = nil
+	if s == nil {
+		return nil
+	}
+	cfg.Zip = s.Get("zip", "0") == "1"
+	cfg.Rar = s.Get("rar", "0") == "1"
+	cfg.RarCommand = s.Get("rar-command", cfg.RarCommand)
+	cfg.Command = s.Get("command", "")
+	for _, d := range strings.Split(s.Get("dirs", ""), ",") {
+		d = strings.TrimSpace(d)
+		if d != "" {
+			cfg.Dirs = append(cfg.Dirs, d)
+		}
+	}
+	return nil
+}
+
+func (cfg *UnpackConfig) Save(s *configparser.Section) error {
+	if cfg.Zip {
+		s.Add("zip", "1")
+	} else {
+		s.Add("zip", "0")
+	}
+	if cfg.Rar {
+		s.Add("rar", "1")
+	} else {
+		s.Add("rar", "0")
+	}
+	s.Add("rar-command", cfg.RarCommand)
+	if cfg.Command != "" {
+		s.Add("command", cfg.Command)
+	}
+	if len(cfg.Dirs) > 0 {
+		s.Add("dirs", strings.Join(cfg.Dirs, ","))
+	}
+	return nil
+}
+
+func (cfg *UnpackConfig) LoadEnv() {
+
+}
+
+// Settings gets what torrents do with completed downloads, nil if they do nothing
+func (cfg *UnpackConfig) Settings() *unpack.Config {
+	c := &unpack.Config{
+		Zip:        cfg.Zip,
+		Rar:        cfg.Rar,
+		RarCommand: cfg.RarCommand,
+		Command:    cfg.Command,
+		Dirs:       cfg.Dirs,
+	}
+	if !c.Enabled() {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/configparser"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/update"
+	"time"
+)
+
+// UpdateConfig configures checking for new releases
+type UpdateConfig struct {
+	// url of an i2p hosted release feed, we don't check for updates if empty
+	Feed string
+	// how often to check the feed in seconds
+	Interval int
+}
+
+func (cfg *UpdateConfig) Load(s *configparser.Section) error {
+	cfg.Feed = ""
+	cfg.Interval = int(update.DefaultInterval / time.Second)
+	if s == nil {
+		return nil
+	}
+	cfg.Feed = s.Get("feed", cfg.Feed)
+	cfg.Interval = s.GetInt("interval", cfg.Interval)
+	if cfg.Feed != "" {
+		if err := update.CheckFeed(cfg.Feed); err != nil {
+			return fmt.Errorf("bad update feed %q: %s", cfg.Feed, err.Error())
+		}
+	}
+	return nil
+}
+
+func (cfg *UpdateConfig) Save(s *configparser.Section) error {
+	if cfg.Feed != "" {
+		s.Add("feed", cfg.Feed)
+	}
+	s.Add("interval", fmt.Sprintf("%d", cfg.Interval))
+	return nil
+}
+
+func (cfg *UpdateConfig) LoadEnv() {
+
+}
+
+// CreateChecker makes the update checker, nil if no release feed is configured
+func (cfg *UpdateConfig) CreateChecker(getNet func() network.Network) *update.Checker {
+	if cfg.Feed == "" {
+		return nil
+	}
+	c, _ := update.NewChecker(cfg.Feed, time.Duration(cfg.Interval)*time.Second, getNet)
+	return c
+}
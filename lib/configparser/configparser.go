@@ -63,7 +63,9 @@ type Configuration struct {
 type Section struct {
 	fqn            string
 	options        map[string]string
-	orderedOptions []string // track the order of the options as they are parsed
+	orderedOptions []string       // track the order of the options as they are parsed
+	lines          map[string]int // line each option was parsed from
+	line           int            // line the section header was parsed from
 	mutex          sync.RWMutex
 }
 
@@ -86,15 +88,19 @@ func Read(filePath string) (*Configuration, error) {
 	activeSection := config.addSection("global")
 
 	scanner := bufio.NewScanner(bufio.NewReader(file))
+	lineNo := 0
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNo++
 		if !(strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")) && len(line) > 0 {
 			if isSection(line) {
 				fqn := strings.Trim(line, " []")
 				activeSection = config.addSection(fqn)
+				activeSection.line = lineNo
 				continue
 			} else {
-				addOption(activeSection, line)
+				opt := addOption(activeSection, line)
+				activeSection.lines[opt] = lineNo
 			}
 		}
 	}
@@ -348,6 +354,18 @@ func (s *Section) GetInt(option string, fallback int) int {
 	}
 }
 
+// Line returns the line the section header was parsed from, 0 if unknown.
+func (s *Section) Line() int {
+	return s.line
+}
+
+// LineOf returns the line the specified option was parsed from, 0 if unknown.
+func (s *Section) LineOf(option string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lines[option]
+}
+
 // ValueOf returns the value of specified option.
 func (s *Section) ValueOf(option string) string {
 	s.mutex.Lock()
@@ -447,7 +465,7 @@ func isSection(section string) bool {
 	return strings.HasPrefix(section, "[")
 }
 
-func addOption(s *Section, option string) {
+func addOption(s *Section, option string) string {
 	var opt, value string
 	if opt, value = parseOption(option); value != "" {
 		s.options[opt] = value
@@ -457,6 +475,7 @@ func addOption(s *Section, option string) {
 	}
 
 	s.orderedOptions = append(s.orderedOptions, opt)
+	return opt
 }
 
 func parseOption(option string) (opt, value string) {
@@ -479,7 +498,7 @@ func parseOption(option string) (opt, value string) {
 }
 
 func (c *Configuration) addSection(fqn string) *Section {
-	section := &Section{fqn: fqn, options: make(map[string]string)}
+	section := &Section{fqn: fqn, options: make(map[string]string), lines: make(map[string]int)}
 
 	var lst *list.List
 	if lst = c.sections[fqn]; lst == nil {
@@ -0,0 +1,147 @@
+package configparser
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseError is a syntax error at a line in a configuration file
+type ParseError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Format is a configuration file format
+type Format int
+
+const (
+	// FormatINI is the default ini format
+	FormatINI Format = iota
+	// FormatTOML is a subset of TOML, one level of tables holding scalar values
+	FormatTOML
+	// FormatYAML is a subset of YAML, one level of mappings holding scalar values
+	FormatYAML
+)
+
+// FormatOf returns the format of a configuration file by its extension
+func FormatOf(filePath string) Format {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".toml":
+		return FormatTOML
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return FormatINI
+	}
+}
+
+// ReadFile parses a configuration file in the format given by its extension
+func ReadFile(filePath string) (*Configuration, error) {
+	switch FormatOf(filePath) {
+	case FormatTOML:
+		return ReadTOML(filePath)
+	case FormatYAML:
+		return ReadYAML(filePath)
+	default:
+		return Read(filePath)
+	}
+}
+
+// SaveFile saves a Configuration in the format given by the file's extension
+func SaveFile(c *Configuration, filePath string) error {
+	switch FormatOf(filePath) {
+	case FormatTOML:
+		return saveWith(c, filePath, writeTOML)
+	case FormatYAML:
+		return saveWith(c, filePath, writeYAML)
+	default:
+		return Save(c, filePath)
+	}
+}
+
+// save a Configuration using a writer for a format, keeping a backup (.bak) like Save
+func saveWith(c *Configuration, filePath string, write func(*bufio.Writer, []*Section)) (err error) {
+	sections, err := c.AllSections()
+	if err != nil {
+		return
+	}
+	err = os.Rename(filePath, filePath+".bak")
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	var f *os.File
+	f, err = os.Create(filePath)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	write(w, sections)
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	return
+}
+
+// parse a scalar value shared by toml and yaml, booleans become 1 or 0 like in ini files
+func parseScalar(value string) (string, bool) {
+	switch value {
+	case "true":
+		return "1", true
+	case "false":
+		return "0", true
+	}
+	if strings.HasPrefix(value, "\"") {
+		s, err := strconv.Unquote(value)
+		return s, err == nil
+	}
+	if strings.HasPrefix(value, "'") {
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", false
+		}
+		return value[1 : len(value)-1], true
+	}
+	return "", false
+}
+
+// format a value for writing, numbers stay bare and everything else is quoted
+func formatScalar(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// strip a trailing comment that is not inside quotes, a # only starts one at the start of the line or after whitespace
+// so urls with fragments and passwords with a # in them keep it
+func stripComment(line string) string {
+	var quote byte
+	for idx := 0; idx < len(line); idx++ {
+		ch := line[idx]
+		switch {
+		case quote == '"' && ch == '\\':
+			// skip escaped character
+			idx++
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#' && (idx == 0 || line[idx-1] == ' ' || line[idx-1] == '\t'):
+			return line[:idx]
+		}
+	}
+	return line
+}
@@ -0,0 +1,139 @@
+package configparser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTOML = `# daemon config
+[rpc]
+enabled = true
+bind = "127.0.0.1:1776" # trailing comment
+[tracker-proxy]
+"*.i2p" = 'i2p'
+[storage]
+workers = 1_000
+`
+
+const testYAML = `---
+rpc:
+  enabled: yes
+  bind: "127.0.0.1:1776" # trailing comment
+tracker-proxy:
+  "*.i2p": i2p
+storage:
+  workers: 4
+`
+
+func writeTestFile(t *testing.T, name, data string) string {
+	dir, err := ioutil.TempDir("", "configparser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, name)
+	err = ioutil.WriteFile(fname, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func checkParsed(t *testing.T, c *Configuration, workers string) {
+	s, err := c.Section("rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ValueOf("enabled") != "1" {
+		t.Errorf("expected enabled=1 got %q", s.ValueOf("enabled"))
+	}
+	if s.ValueOf("bind") != "127.0.0.1:1776" {
+		t.Errorf("bad bind %q", s.ValueOf("bind"))
+	}
+	if s.LineOf("bind") != 4 {
+		t.Errorf("bind on line %d, expected 4", s.LineOf("bind"))
+	}
+	s, err = c.Section("tracker-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ValueOf("*.i2p") != "i2p" {
+		t.Errorf("bad tracker proxy rule %q", s.ValueOf("*.i2p"))
+	}
+	s, err = c.Section("storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ValueOf("workers") != workers {
+		t.Errorf("expected workers=%s got %q", workers, s.ValueOf("workers"))
+	}
+}
+
+func TestReadTOML(t *testing.T) {
+	fname := writeTestFile(t, "test.toml", testTOML)
+	defer os.RemoveAll(filepath.Dir(fname))
+	c, err := ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkParsed(t, c, "1000")
+	// round trip
+	err = SaveFile(c, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadYAML(t *testing.T) {
+	fname := writeTestFile(t, "test.yaml", testYAML)
+	defer os.RemoveAll(filepath.Dir(fname))
+	c, err := ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkParsed(t, c, "4")
+	err = SaveFile(c, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.Section("tracker-proxy")
+	if s == nil || s.ValueOf("*.i2p") != "i2p" {
+		t.Error("yaml round trip lost quoted key")
+	}
+}
+
+func TestParseErrorLine(t *testing.T) {
+	fname := writeTestFile(t, "bad.toml", "[rpc]\nenabled = true\nbind = 127.0.0.1\n")
+	defer os.RemoveAll(filepath.Dir(fname))
+	_, err := ReadFile(fname)
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected parse error got %v", err)
+	}
+	if perr.Line != 3 {
+		t.Errorf("expected error on line 3 got %d", perr.Line)
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	for line, want := range map[string]string{
+		"# comment":                         "",
+		"bind = \"x\" # comment":            "bind = \"x\" ",
+		"url: http://tracker/announce#frag": "url: http://tracker/announce#frag",
+		"password: se#cret\t# comment":      "password: se#cret\t",
+		"name: \"a # b\"":                   "name: \"a # b\"",
+	} {
+		if got := stripComment(line); got != want {
+			t.Errorf("%q stripped to %q not %q", line, got, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package configparser
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReadTOML parses a TOML configuration file and returns a Configuration instance.
+// Only tables of scalar values are supported, which is all the daemon config needs.
+func ReadTOML(filePath string) (*Configuration, error) {
+	filePath = path.Clean(filePath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := newConfiguration(filePath)
+	activeSection := config.addSection("global")
+	seen := make(map[string]bool)
+
+	fail := func(lineNo int, msg string) (*Configuration, error) {
+		return nil, &ParseError{File: filePath, Line: lineNo, Msg: msg}
+	}
+
+	scanner := bufio.NewScanner(bufio.NewReader(file))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[[") {
+			return fail(lineNo, "arrays of tables are not supported")
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fail(lineNo, "unterminated table header")
+			}
+			fqn := strings.TrimSpace(line[1 : len(line)-1])
+			if fqn == "" {
+				return fail(lineNo, "empty table name")
+			}
+			if seen[fqn] {
+				return fail(lineNo, "table ["+fqn+"] defined twice")
+			}
+			seen[fqn] = true
+			activeSection = config.addSection(fqn)
+			activeSection.line = lineNo
+			continue
+		}
+		key, value, ok := splitTOMLKey(line)
+		if !ok {
+			return fail(lineNo, "expected key = value")
+		}
+		if activeSection.Exists(key) {
+			return fail(lineNo, "key "+key+" defined twice")
+		}
+		v, ok := parseTOMLValue(value)
+		if !ok {
+			return fail(lineNo, key+": invalid value "+value)
+		}
+		activeSection.Add(key, v)
+		activeSection.lines[key] = lineNo
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// split a toml line into key and raw value, keys are bare or double quoted
+func splitTOMLKey(line string) (key, value string, ok bool) {
+	var rest string
+	if strings.HasPrefix(line, "\"") {
+		idx := 1
+		for idx < len(line) && line[idx] != '"' {
+			if line[idx] == '\\' {
+				idx++
+			}
+			idx++
+		}
+		if idx >= len(line) {
+			return
+		}
+		var err error
+		key, err = strconv.Unquote(line[:idx+1])
+		if err != nil {
+			return
+		}
+		rest = strings.TrimSpace(line[idx+1:])
+		if !strings.HasPrefix(rest, "=") {
+			return
+		}
+		rest = rest[1:]
+	} else {
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			return
+		}
+		key = strings.TrimSpace(line[:idx])
+		for _, ch := range key {
+			if !(ch == '_' || ch == '-' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')) {
+				return
+			}
+		}
+		rest = line[idx+1:]
+	}
+	value = strings.TrimSpace(rest)
+	ok = key != "" && value != ""
+	return
+}
+
+func parseTOMLValue(value string) (string, bool) {
+	if v, ok := parseScalar(value); ok {
+		return v, true
+	}
+	num := strings.Replace(value, "_", "", -1)
+	if _, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return strings.TrimPrefix(num, "+"), true
+	}
+	if _, err := strconv.ParseFloat(num, 64); err == nil {
+		return num, true
+	}
+	return "", false
+}
+
+func writeTOML(w *bufio.Writer, sections []*Section) {
+	for _, s := range sections {
+		if s.fqn != "global" {
+			w.WriteString("[" + s.fqn + "]\n")
+		}
+		for _, opt := range s.orderedOptions {
+			key := opt
+			if _, _, ok := splitTOMLKey(opt + " = 0"); !ok {
+				key = strconv.Quote(opt)
+			}
+			w.WriteString(key + " = " + formatScalar(s.options[opt]) + "\n")
+		}
+		w.WriteString("\n")
+	}
+}
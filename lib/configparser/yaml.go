@@ -0,0 +1,179 @@
+package configparser
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReadYAML parses a YAML configuration file and returns a Configuration instance.
+// Only top level mappings of scalar values are supported, which is all the daemon config needs.
+func ReadYAML(filePath string) (*Configuration, error) {
+	filePath = path.Clean(filePath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := newConfiguration(filePath)
+	global := config.addSection("global")
+	var activeSection *Section
+	indent := 0
+
+	fail := func(lineNo int, msg string) (*Configuration, error) {
+		return nil, &ParseError{File: filePath, Line: lineNo, Msg: msg}
+	}
+
+	scanner := bufio.NewScanner(bufio.NewReader(file))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := strings.TrimRight(stripComment(scanner.Text()), " \t\r")
+		line := strings.TrimLeft(raw, " ")
+		if line == "" || (lineNo == 1 && line == "---") {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return fail(lineNo, "tabs are not allowed for indentation")
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			return fail(lineNo, "lists are not supported")
+		}
+		depth := len(raw) - len(line)
+		key, value, ok := splitYAMLKey(line)
+		if !ok {
+			return fail(lineNo, "expected key: value")
+		}
+		if depth == 0 {
+			activeSection = nil
+			if value == "" {
+				if _, err := config.Section(key); err == nil {
+					return fail(lineNo, "section "+key+" defined twice")
+				}
+				activeSection = config.addSection(key)
+				activeSection.line = lineNo
+				indent = 0
+				continue
+			}
+			if global.Exists(key) {
+				return fail(lineNo, "key "+key+" defined twice")
+			}
+			v, ok := parseYAMLValue(value)
+			if !ok {
+				return fail(lineNo, key+": invalid value "+value)
+			}
+			global.Add(key, v)
+			global.lines[key] = lineNo
+			continue
+		}
+		if activeSection == nil {
+			return fail(lineNo, "unexpected indentation")
+		}
+		if indent == 0 {
+			indent = depth
+		} else if depth != indent {
+			return fail(lineNo, "inconsistent indentation")
+		}
+		if value == "" {
+			return fail(lineNo, key+": nested mappings are not supported")
+		}
+		if activeSection.Exists(key) {
+			return fail(lineNo, "key "+key+" defined twice")
+		}
+		v, ok := parseYAMLValue(value)
+		if !ok {
+			return fail(lineNo, key+": invalid value "+value)
+		}
+		activeSection.Add(key, v)
+		activeSection.lines[key] = lineNo
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// split a yaml line into key and raw value, keys are plain or double quoted
+func splitYAMLKey(line string) (key, value string, ok bool) {
+	var rest string
+	if strings.HasPrefix(line, "\"") {
+		idx := 1
+		for idx < len(line) && line[idx] != '"' {
+			if line[idx] == '\\' {
+				idx++
+			}
+			idx++
+		}
+		if idx >= len(line) {
+			return
+		}
+		var err error
+		key, err = strconv.Unquote(line[:idx+1])
+		if err != nil {
+			return
+		}
+		rest = line[idx+1:]
+		if !strings.HasPrefix(rest, ":") {
+			return
+		}
+		rest = rest[1:]
+	} else {
+		idx := strings.Index(line, ": ")
+		if idx == -1 {
+			if !strings.HasSuffix(line, ":") {
+				return
+			}
+			idx = len(line) - 1
+		}
+		key = strings.TrimSpace(line[:idx])
+		rest = line[idx+1:]
+	}
+	value = strings.TrimSpace(rest)
+	ok = key != ""
+	return
+}
+
+func parseYAMLValue(value string) (string, bool) {
+	if v, ok := parseScalar(value); ok {
+		return v, true
+	}
+	switch strings.ToLower(value) {
+	case "yes", "on", "true":
+		return "1", true
+	case "no", "off", "false":
+		return "0", true
+	case "~", "null":
+		return "", true
+	}
+	if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		return "", false
+	}
+	if strings.HasPrefix(value, "\"") || strings.HasPrefix(value, "'") {
+		return "", false
+	}
+	return value, true
+}
+
+func writeYAML(w *bufio.Writer, sections []*Section) {
+	for _, s := range sections {
+		prefix := "  "
+		if s.fqn == "global" {
+			prefix = ""
+		} else {
+			w.WriteString(s.fqn + ":\n")
+		}
+		for _, opt := range s.orderedOptions {
+			key := opt
+			if strings.ContainsAny(opt, ":#\"' ") || strings.HasPrefix(opt, "-") {
+				key = strconv.Quote(opt)
+			}
+			w.WriteString(prefix + key + ": " + formatScalar(s.options[opt]) + "\n")
+		}
+	}
+}
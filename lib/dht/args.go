@@ -1 +1,19 @@
 package dht
+
+// Args holds the arguments of a query or the values of a reply, all strings are raw bytes
+type Args struct {
+	// sender's node id
+	ID string `bencode:"id"`
+	// node id find_node looks for
+	Target string `bencode:"target,omitempty"`
+	// torrent get_peers and announce_peer are about
+	Infohash string `bencode:"info_hash,omitempty"`
+	// token from get_peers we have to give back with announce_peer
+	Token string `bencode:"token,omitempty"`
+	// compact node infos of the closest nodes we know
+	Nodes string `bencode:"nodes,omitempty"`
+	// destination hashes of peers of the torrent
+	Values []string `bencode:"values,omitempty"`
+	// destination hash of the announced peer for announce_peer, our datagram destination for ping over the wire
+	Dest string `bencode:"dest,omitempty"`
+}
@@ -42,3 +42,8 @@ const ErrCodeGeneric = 201
 const ErrCodeServer = 202
 const ErrCodeProtocol = 203
 const ErrCodeMethod = 204
+
+// Error implements error
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
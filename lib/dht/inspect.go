@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"time"
+)
+
+// ErrNotI2P is returned when pinging something that is not an i2p destination
+var ErrNotI2P = errors.New("dht nodes are i2p destinations")
+
+// Hex gets the node id as hex
+func (id NodeID) Hex() string {
+	return hex.EncodeToString(id[:])
+}
+
+// NodeInfo is what we know about a node in our routing table
+type NodeInfo struct {
+	ID   string
+	Addr string
+	// how many leading bits its id shares with ours
+	Bucket int
+	// unix timestamp of when it last answered us or asked us something
+	LastSeen int64
+	// queries it did not answer since it last did
+	Fails int
+}
+
+// Status is the state of the dht and its routing table
+type Status struct {
+	Running bool
+	// our node id and datagram destination, empty until we first ran
+	ID   string
+	Addr string
+	// torrents other nodes announced to us and how many peers they have
+	Torrents int
+	Peers    int
+	Nodes    []NodeInfo
+}
+
+// Status gets the state of the dht with every node in the routing table, closest to us first
+func (dht *XDHT) Status() (st Status) {
+	dht.mtx.Lock()
+	st.Running = dht.n != nil
+	if dht.table.us != (NodeID{}) {
+		st.ID = dht.table.us.Hex()
+		st.Addr = dht.us.String()
+	}
+	dht.mtx.Unlock()
+	st.Nodes = []NodeInfo{}
+	dht.table.mtx.Lock()
+	for idx := len(dht.table.buckets) - 1; idx >= 0; idx-- {
+		for _, n := range dht.table.buckets[idx] {
+			st.Nodes = append(st.Nodes, NodeInfo{
+				ID:       n.id.Hex(),
+				Addr:     n.hash.String(),
+				Bucket:   idx,
+				LastSeen: n.seen.Unix(),
+				Fails:    n.fails,
+			})
+		}
+	}
+	dht.table.mtx.Unlock()
+	dht.peers.mtx.Lock()
+	st.Torrents = len(dht.peers.peers)
+	for _, p := range dht.peers.peers {
+		st.Peers += len(p)
+	}
+	dht.peers.mtx.Unlock()
+	return
+}
+
+// Ping asks the node at the i2p address addr if it is there, it goes in our routing table if it answers
+// returns its node id and how long it took to answer
+func (dht *XDHT) Ping(addr string) (id NodeID, rtt time.Duration, err error) {
+	n := dht.network()
+	if n == nil {
+		err = ErrNotRunning
+		return
+	}
+	x := new(node)
+	x.addr, err = n.Lookup(addr, "")
+	if err != nil {
+		return
+	}
+	a, ok := x.addr.(i2p.Addr)
+	if !ok {
+		err = ErrNotI2P
+		return
+	}
+	x.hash = a.Base32Addr()
+	started := time.Now()
+	var r *Args
+	r, err = dht.query(n, x, NewPingRequest(dht.nextTID(), dht.id(), ""))
+	if err == nil {
+		rtt = time.Since(started)
+		copy(id[:], r.ID)
+	}
+	return
+}
+
+// GetPeers looks up peers of ih without announcing us, returns their destination hashes and how many nodes answered
+func (dht *XDHT) GetPeers(ih common.Infohash) (peers []i2p.Base32Addr, nodes int, err error) {
+	n := dht.network()
+	if n == nil {
+		err = ErrNotRunning
+		return
+	}
+	var results []lookupResult
+	peers, results = dht.getPeers(n, ih, i2p.Base32Addr{})
+	nodes = len(results)
+	if nodes == 0 {
+		err = ErrNoNodes
+	}
+	return
+}
@@ -1,5 +1,6 @@
 package dht
 
+const mPing = "ping"
 const mFindNode = "find_node"
 const mGetPeers = "get_peers"
 const mAnnouncePeer = "announce_peer"
@@ -8,22 +9,24 @@ const kQuery = "q"
 const kResponse = "r"
 const kError = "e"
 
-const vID = "id"
-const vTarget = "target"
-const vNodes = "nodes"
-
 type Message struct {
-	Query string                 `bencode:"q",omitempty`
-	TID   string                 `bencode:"t"`
-	Reply string                 `bencode:"y"`
-	Err   *Error                 `bencode:"e",omitempty`
-	Args  map[string]interface{} `bencode:"a",omitempty`
+	Query    string `bencode:"q,omitempty"`
+	TID      string `bencode:"t"`
+	Reply    string `bencode:"y"`
+	Err      *Error `bencode:"e,omitempty"`
+	Args     *Args  `bencode:"a,omitempty"`
+	Response *Args  `bencode:"r,omitempty"`
 }
 
 func (m *Message) IsError() bool {
 	return m.Reply == kError
 }
 
+// IsQuery returns true if this message asks us for something
+func (m *Message) IsQuery() bool {
+	return m.Reply == kQuery
+}
+
 // NewError generates a new error reply message
 func NewError(txid string, code int, errMsg string) *Message {
 	return &Message{
@@ -36,14 +39,53 @@ func NewError(txid string, code int, errMsg string) *Message {
 	}
 }
 
-func NewFindNodeRequest(txid, id, target string) *Message {
+// NewResponse generates a new reply to the query with transaction id txid
+func NewResponse(txid string, r *Args) *Message {
+	return &Message{
+		TID:      txid,
+		Reply:    kResponse,
+		Response: r,
+	}
+}
+
+func newQuery(txid, method string, args *Args) *Message {
 	return &Message{
 		TID:   txid,
 		Reply: kQuery,
-		Query: mFindNode,
-		Args: map[string]interface{}{
-			vID:     id,
-			vTarget: target,
-		},
+		Query: method,
+		Args:  args,
 	}
 }
+
+// NewPingRequest generates a ping, dest is the datagram destination hash of the sender when it is not the one the query comes from
+func NewPingRequest(txid, id, dest string) *Message {
+	return newQuery(txid, mPing, &Args{
+		ID:   id,
+		Dest: dest,
+	})
+}
+
+func NewFindNodeRequest(txid, id, target string) *Message {
+	return newQuery(txid, mFindNode, &Args{
+		ID:     id,
+		Target: target,
+	})
+}
+
+// NewGetPeersRequest generates a query for peers of infohash
+func NewGetPeersRequest(txid, id, infohash string) *Message {
+	return newQuery(txid, mGetPeers, &Args{
+		ID:       id,
+		Infohash: infohash,
+	})
+}
+
+// NewAnnouncePeerRequest generates an announce that dest is a peer of infohash, token comes from the get_peers reply of the node we send it to
+func NewAnnouncePeerRequest(txid, id, infohash, token, dest string) *Message {
+	return newQuery(txid, mAnnouncePeer, &Args{
+		ID:       id,
+		Infohash: infohash,
+		Token:    token,
+		Dest:     dest,
+	})
+}
@@ -0,0 +1,119 @@
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"sync"
+	"time"
+)
+
+// PeerLifetime is how long we hand out a peer after it announced
+const PeerLifetime = time.Minute * 45
+
+// most peers we give out in one get_peers reply
+const maxValues = 50
+
+// most peers we hold per torrent
+const maxPeersPerTorrent = 500
+
+// tokens are good until the secret after the next one
+const tokenRotate = time.Minute * 5
+
+// peers that announced to us
+type peerStore struct {
+	mtx   sync.Mutex
+	peers map[common.Infohash]map[i2p.Base32Addr]time.Time
+}
+
+func (s *peerStore) put(ih common.Infohash, dest i2p.Base32Addr) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.peers == nil {
+		s.peers = make(map[common.Infohash]map[i2p.Base32Addr]time.Time)
+	}
+	p, ok := s.peers[ih]
+	if !ok {
+		p = make(map[i2p.Base32Addr]time.Time)
+		s.peers[ih] = p
+	}
+	if _, has := p[dest]; !has && len(p) >= maxPeersPerTorrent {
+		return
+	}
+	p[dest] = time.Now()
+}
+
+// get peers of ih that have not expired
+func (s *peerStore) get(ih common.Infohash) (dests []i2p.Base32Addr) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for dest, at := range s.peers[ih] {
+		if len(dests) >= maxValues {
+			return
+		}
+		if time.Since(at) < PeerLifetime {
+			dests = append(dests, dest)
+		}
+	}
+	return
+}
+
+// forget peers that expired
+func (s *peerStore) expire() {
+	s.mtx.Lock()
+	for ih, p := range s.peers {
+		for dest, at := range p {
+			if time.Since(at) >= PeerLifetime {
+				delete(p, dest)
+			}
+		}
+		if len(p) == 0 {
+			delete(s.peers, ih)
+		}
+	}
+	s.mtx.Unlock()
+}
+
+// makes the tokens we hand out in get_peers replies so only nodes that asked us can announce
+type tokenSecrets struct {
+	mtx     sync.Mutex
+	current [20]byte
+	last    [20]byte
+	rotated time.Time
+}
+
+func (s *tokenSecrets) rotate() {
+	if time.Since(s.rotated) < tokenRotate {
+		return
+	}
+	s.last = s.current
+	rand.Read(s.current[:])
+	if s.rotated.IsZero() {
+		s.last = s.current
+	}
+	s.rotated = time.Now()
+}
+
+func makeToken(secret [20]byte, from i2p.Base32Addr) string {
+	h := sha1.New()
+	h.Write(secret[:])
+	h.Write(from[:])
+	return string(h.Sum(nil)[:8])
+}
+
+// get the token for the node at from
+func (s *tokenSecrets) token(from i2p.Base32Addr) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rotate()
+	return makeToken(s.current, from)
+}
+
+// returns true if we gave token to the node at from not too long ago
+func (s *tokenSecrets) valid(token string, from i2p.Base32Addr) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rotate()
+	return token == makeToken(s.current, from) || token == makeToken(s.last, from)
+}
@@ -0,0 +1,217 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// K is how many nodes we keep in each bucket and how many closest nodes lookups look for
+const K = 8
+
+// how many nodes we query at once during lookups
+const alpha = 3
+
+// a node gets replaced by newer nodes once it failed to answer this many times in a row
+const maxNodeFails = 2
+
+// size of compact node info, node id then datagram destination hash
+const compactNodeSize = 20 + 32
+
+// NodeID identifies a dht node, nodes closer to an infohash by xor hold its peers
+type NodeID [20]byte
+
+// GenerateNodeID makes a random node id
+func GenerateNodeID() (id NodeID) {
+	rand.Read(id[:])
+	return
+}
+
+// returns true if a is closer to target than b
+func closer(target, a, b NodeID) bool {
+	for idx := range target {
+		da := a[idx] ^ target[idx]
+		db := b[idx] ^ target[idx]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// how many leading bits a and b share
+func commonPrefix(a, b NodeID) int {
+	for idx := range a {
+		x := a[idx] ^ b[idx]
+		if x == 0 {
+			continue
+		}
+		n := 0
+		for x&0x80 == 0 {
+			x <<= 1
+			n++
+		}
+		return idx*8 + n
+	}
+	return len(a) * 8
+}
+
+// a dht node we know
+type node struct {
+	id NodeID
+	// datagram destination hash
+	hash i2p.Base32Addr
+	// where we send to, nil until we resolve hash
+	addr net.Addr
+	// when it last answered us or asked us something
+	seen time.Time
+	// queries it did not answer since it last did
+	fails int
+}
+
+// compact node info for n
+func (n *node) compact() []byte {
+	return append(append([]byte(nil), n.id[:]...), n.hash[:]...)
+}
+
+// parse compact node infos
+func parseCompactNodes(data string) (nodes []*node) {
+	for len(data) >= compactNodeSize {
+		n := new(node)
+		copy(n.id[:], data)
+		copy(n.hash[:], data[20:])
+		nodes = append(nodes, n)
+		data = data[compactNodeSize:]
+	}
+	return
+}
+
+// compact node infos for nodes
+func compactNodes(nodes []*node) string {
+	var b bytes.Buffer
+	for _, n := range nodes {
+		b.Write(n.compact())
+	}
+	return b.String()
+}
+
+// sort nodes closest to target first
+func sortNodes(target NodeID, nodes []*node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return closer(target, nodes[i].id, nodes[j].id)
+	})
+}
+
+// kademlia routing table, bucket n holds nodes that share n leading bits with us
+type routingTable struct {
+	mtx     sync.Mutex
+	us      NodeID
+	buckets [160][]*node
+}
+
+func (t *routingTable) bucket(id NodeID) int {
+	idx := commonPrefix(t.us, id)
+	if idx >= len(t.buckets) {
+		idx = len(t.buckets) - 1
+	}
+	return idx
+}
+
+// note that a node talked to us, it gets added if its bucket has room or holds a node that stopped answering
+func (t *routingTable) seen(id NodeID, addr net.Addr, hash i2p.Base32Addr) {
+	if id == t.us {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	idx := t.bucket(id)
+	b := t.buckets[idx]
+	for _, n := range b {
+		if n.id == id {
+			n.addr = addr
+			n.hash = hash
+			n.seen = time.Now()
+			n.fails = 0
+			return
+		}
+	}
+	n := &node{
+		id:   id,
+		hash: hash,
+		addr: addr,
+		seen: time.Now(),
+	}
+	if len(b) < K {
+		t.buckets[idx] = append(b, n)
+		return
+	}
+	for i, old := range b {
+		if old.fails >= maxNodeFails {
+			b[i] = n
+			return
+		}
+	}
+}
+
+// note that a node did not answer
+func (t *routingTable) failed(id NodeID) {
+	t.mtx.Lock()
+	for _, n := range t.buckets[t.bucket(id)] {
+		if n.id == id {
+			n.fails++
+		}
+	}
+	t.mtx.Unlock()
+}
+
+// get up to count nodes closest to target that still answer
+func (t *routingTable) closest(target NodeID, count int) (nodes []*node) {
+	t.mtx.Lock()
+	for _, b := range t.buckets {
+		for _, n := range b {
+			if n.fails < maxNodeFails {
+				c := *n
+				nodes = append(nodes, &c)
+			}
+		}
+	}
+	t.mtx.Unlock()
+	sortNodes(target, nodes)
+	if len(nodes) > count {
+		nodes = nodes[:count]
+	}
+	return
+}
+
+// how many nodes we know
+func (t *routingTable) size() (n int) {
+	t.mtx.Lock()
+	for _, b := range t.buckets {
+		n += len(b)
+	}
+	t.mtx.Unlock()
+	return
+}
+
+// returns true if we know a node with id
+func (t *routingTable) has(id NodeID) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, n := range t.buckets[t.bucket(id)] {
+		if n.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sort lookup results closest to target first
+func sortResults(target NodeID, results []lookupResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return closer(target, results[i].n.id, results[j].n.id)
+	})
+}
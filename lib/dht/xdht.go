@@ -2,16 +2,445 @@ package dht
 
 import (
 	"bytes"
+	"errors"
 	"github.com/majestrate/XD/lib/bittorrent/extensions"
 	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/i2p"
 	"github.com/zeebo/bencode"
+	"net"
+	"sync"
+	"time"
 )
 
+// QueryTimeout is how long we wait for a node to answer, datagrams over i2p take a while
+const QueryTimeout = time.Second * 20
+
+// RefreshInterval is how often we look up our own id to learn about the nodes close to us
+const RefreshInterval = time.Minute * 15
+
+// ErrNotRunning is returned when using the dht before it got a network
+var ErrNotRunning = errors.New("dht is not running")
+
+// ErrNoNodes is returned when a lookup found no node that answered
+var ErrNoNodes = errors.New("no dht nodes answered")
+
+// ErrTimeout is returned when a node did not answer a query
+var ErrTimeout = errors.New("dht node did not answer")
+
+// a reply without a node id
+var errBadReply = errors.New("bad dht reply")
+
+// Network is what the dht exchanges datagrams over, network.Network is one
+type Network interface {
+	ReadFrom([]byte) (int, net.Addr, error)
+	WriteTo([]byte, net.Addr) (int, error)
+	Lookup(name, port string) (net.Addr, error)
+}
+
+// a query we wait for the reply to
+type pendingQuery struct {
+	// datagram destination hash of the node we asked
+	to    i2p.Base32Addr
+	reply chan *Message
+}
+
+// XDHT is a kademlia dht over i2p datagrams, the zero value does nothing until enabled and run
 type XDHT struct {
+	enabled bool
+	mtx     sync.Mutex
+	// network we run on, nil when we don't
+	n Network
+	// our datagram destination hash
+	us      i2p.Base32Addr
+	table   routingTable
+	peers   peerStore
+	tokens  tokenSecrets
+	pending map[string]*pendingQuery
+	txid    uint16
 }
 
-func (dht *XDHT) HandleError(err *Error) {
+// Enable turns on the dht, it runs once it gets a network
+func (dht *XDHT) Enable() {
+	dht.enabled = true
+}
+
+// Enabled returns true if we use the dht
+func (dht *XDHT) Enabled() bool {
+	return dht.enabled
+}
+
+// get the network we run on, nil if we don't
+func (dht *XDHT) network() Network {
+	dht.mtx.Lock()
+	defer dht.mtx.Unlock()
+	return dht.n
+}
+
+// our node id as sent in messages
+func (dht *XDHT) id() string {
+	return string(dht.table.us[:])
+}
+
+func (dht *XDHT) nextTID() string {
+	dht.mtx.Lock()
+	dht.txid++
+	tid := string([]byte{byte(dht.txid >> 8), byte(dht.txid)})
+	dht.mtx.Unlock()
+	return tid
+}
 
+// Run answers queries and gets replies to ours over n until reading from it fails
+// n has to send its datagrams from an i2p destination, see network.PacketAddresser
+func (dht *XDHT) Run(n Network) error {
+	var addr net.Addr
+	if pa, ok := n.(network.PacketAddresser); ok {
+		addr = pa.PacketAddr()
+	}
+	us, ok := addr.(i2p.Addr)
+	if !ok {
+		return network.ErrNoDatagrams
+	}
+	dht.mtx.Lock()
+	if dht.table.us == (NodeID{}) {
+		dht.table.us = GenerateNodeID()
+	}
+	dht.n = n
+	dht.us = us.Base32Addr()
+	if dht.pending == nil {
+		dht.pending = make(map[string]*pendingQuery)
+	}
+	dht.mtx.Unlock()
+	defer func() {
+		dht.mtx.Lock()
+		if dht.n == n {
+			dht.n = nil
+		}
+		dht.mtx.Unlock()
+	}()
+	log.Infof("dht running at %s", dht.us.String())
+	go dht.maintain(n)
+	buf := make([]byte, 65536)
+	for {
+		sz, from, err := n.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		var msg Message
+		if bencode.DecodeBytes(buf[:sz], &msg) == nil {
+			dht.handle(n, &msg, from)
+		}
+	}
+}
+
+// look for nodes close to us and forget old peers while we run on n
+func (dht *XDHT) maintain(n Network) {
+	var refreshed time.Time
+	for dht.network() == n {
+		if time.Since(refreshed) >= RefreshInterval && dht.table.size() > 0 {
+			dht.refresh(n)
+			refreshed = time.Now()
+		}
+		dht.peers.expire()
+		time.Sleep(time.Minute)
+	}
+}
+
+// look up our own id so the nodes close to us learn about us and we about them
+func (dht *XDHT) refresh(n Network) {
+	dht.lookup(n, dht.table.us, func(x *node) (*Args, error) {
+		return dht.query(n, x, NewFindNodeRequest(dht.nextTID(), dht.id(), dht.id()))
+	})
+}
+
+func (dht *XDHT) send(n Network, msg *Message, to net.Addr) error {
+	data, err := bencode.EncodeBytes(msg)
+	if err == nil {
+		_, err = n.WriteTo(data, to)
+	}
+	return err
+}
+
+// handle a message from the node at from
+func (dht *XDHT) handle(n Network, msg *Message, from net.Addr) {
+	a, ok := from.(i2p.Addr)
+	if !ok {
+		return
+	}
+	hash := a.Base32Addr()
+	if !msg.IsQuery() {
+		dht.mtx.Lock()
+		pq, ok := dht.pending[msg.TID]
+		if ok && pq.to == hash {
+			delete(dht.pending, msg.TID)
+		}
+		dht.mtx.Unlock()
+		if ok && pq.to == hash {
+			pq.reply <- msg
+		}
+		return
+	}
+	args := msg.Args
+	if args == nil || len(args.ID) != len(NodeID{}) {
+		dht.send(n, NewError(msg.TID, ErrCodeProtocol, "bad node id"), from)
+		return
+	}
+	var id NodeID
+	copy(id[:], args.ID)
+	dht.table.seen(id, from, hash)
+	r := &Args{
+		ID: dht.id(),
+	}
+	var ih common.Infohash
+	if msg.Query == mGetPeers || msg.Query == mAnnouncePeer {
+		if len(args.Infohash) != len(ih) {
+			dht.send(n, NewError(msg.TID, ErrCodeProtocol, "bad infohash"), from)
+			return
+		}
+		copy(ih[:], args.Infohash)
+	}
+	switch msg.Query {
+	case mPing:
+	case mFindNode:
+		var target NodeID
+		if len(args.Target) != len(target) {
+			dht.send(n, NewError(msg.TID, ErrCodeProtocol, "bad target"), from)
+			return
+		}
+		copy(target[:], args.Target)
+		r.Nodes = compactNodes(dht.table.closest(target, K))
+	case mGetPeers:
+		r.Token = dht.tokens.token(hash)
+		r.Nodes = compactNodes(dht.table.closest(NodeID(ih), K))
+		for _, dest := range dht.peers.get(ih) {
+			r.Values = append(r.Values, string(dest[:]))
+		}
+	case mAnnouncePeer:
+		var dest i2p.Base32Addr
+		if len(args.Dest) != len(dest) {
+			dht.send(n, NewError(msg.TID, ErrCodeProtocol, "bad destination"), from)
+			return
+		}
+		if !dht.tokens.valid(args.Token, hash) {
+			dht.send(n, NewError(msg.TID, ErrCodeProtocol, "bad token"), from)
+			return
+		}
+		copy(dest[:], args.Dest)
+		dht.peers.put(ih, dest)
+	default:
+		dht.send(n, NewError(msg.TID, ErrCodeMethod, "unknown method"), from)
+		return
+	}
+	dht.send(n, NewResponse(msg.TID, r), from)
+}
+
+// send msg to x and wait for the reply
+func (dht *XDHT) query(n Network, x *node, msg *Message) (r *Args, err error) {
+	if x.addr == nil {
+		x.addr, err = n.Lookup(x.hash.String(), "")
+		if err != nil {
+			return
+		}
+	}
+	pq := &pendingQuery{
+		to:    x.hash,
+		reply: make(chan *Message, 1),
+	}
+	dht.mtx.Lock()
+	dht.pending[msg.TID] = pq
+	dht.mtx.Unlock()
+	err = dht.send(n, msg, x.addr)
+	if err == nil {
+		select {
+		case reply := <-pq.reply:
+			if reply.IsError() && reply.Err != nil {
+				err = reply.Err
+			} else if reply.Response == nil || len(reply.Response.ID) != len(NodeID{}) {
+				err = errBadReply
+			} else {
+				r = reply.Response
+				var id NodeID
+				copy(id[:], r.ID)
+				dht.table.seen(id, x.addr, x.hash)
+				return
+			}
+		case <-time.After(QueryTimeout):
+			err = ErrTimeout
+		}
+	}
+	dht.mtx.Lock()
+	delete(dht.pending, msg.TID)
+	dht.mtx.Unlock()
+	dht.table.failed(x.id)
+	return
+}
+
+// a node that answered during a lookup
+type lookupResult struct {
+	n *node
+	r *Args
+}
+
+// ask the nodes closest to target, closer ones they tell us about and so on until nobody knows closer nodes
+// returns every node that answered closest first
+func (dht *XDHT) lookup(n Network, target NodeID, ask func(*node) (*Args, error)) (results []lookupResult) {
+	shortlist := dht.table.closest(target, K)
+	known := make(map[NodeID]bool)
+	asked := make(map[NodeID]bool)
+	answered := make(map[NodeID]bool)
+	for _, x := range shortlist {
+		known[x.id] = true
+	}
+	known[dht.table.us] = true
+	for {
+		var batch []*node
+		for idx, x := range shortlist {
+			if idx >= K || len(batch) >= alpha {
+				break
+			}
+			if !asked[x.id] {
+				batch = append(batch, x)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		replies := make(chan lookupResult, len(batch))
+		for _, x := range batch {
+			asked[x.id] = true
+			go func(x *node) {
+				r, err := ask(x)
+				if err != nil {
+					log.Debugf("dht node %s did not answer: %s", x.hash.String(), err.Error())
+				}
+				replies <- lookupResult{x, r}
+			}(x)
+		}
+		for range batch {
+			res := <-replies
+			if res.r == nil {
+				continue
+			}
+			answered[res.n.id] = true
+			results = append(results, res)
+			for _, x := range parseCompactNodes(res.r.Nodes) {
+				if !known[x.id] {
+					known[x.id] = true
+					shortlist = append(shortlist, x)
+				}
+			}
+		}
+		// forget nodes that did not answer so the next closest get their turn
+		nodes := shortlist[:0]
+		for _, x := range shortlist {
+			if !asked[x.id] || answered[x.id] {
+				nodes = append(nodes, x)
+			}
+		}
+		shortlist = nodes
+		sortNodes(target, shortlist)
+	}
+	sortResults(target, results)
+	return
+}
+
+// look up peers of ih, skips the one at dest
+// returns the peers and every node that answered closest first
+func (dht *XDHT) getPeers(n Network, ih common.Infohash, dest i2p.Base32Addr) (peers []i2p.Base32Addr, results []lookupResult) {
+	results = dht.lookup(n, NodeID(ih), func(x *node) (*Args, error) {
+		return dht.query(n, x, NewGetPeersRequest(dht.nextTID(), dht.id(), string(ih[:])))
+	})
+	found := make(map[i2p.Base32Addr]bool)
+	found[dest] = true
+	for _, p := range dht.peers.get(ih) {
+		if !found[p] {
+			found[p] = true
+			peers = append(peers, p)
+		}
+	}
+	for _, res := range results {
+		for _, v := range res.r.Values {
+			var p i2p.Base32Addr
+			if len(v) != len(p) {
+				continue
+			}
+			copy(p[:], v)
+			if !found[p] {
+				found[p] = true
+				peers = append(peers, p)
+			}
+		}
+	}
+	return
+}
+
+// Announce looks up peers of ih on the dht and tells the nodes closest to it that dest is one of them
+// returns the destination hashes of the peers we found
+func (dht *XDHT) Announce(ih common.Infohash, dest i2p.Base32Addr) (peers []i2p.Base32Addr, err error) {
+	n := dht.network()
+	if n == nil {
+		err = ErrNotRunning
+		return
+	}
+	var results []lookupResult
+	peers, results = dht.getPeers(n, ih, dest)
+	if len(results) == 0 {
+		err = ErrNoNodes
+		return
+	}
+	if len(results) > K {
+		results = results[:K]
+	}
+	var wg sync.WaitGroup
+	for _, res := range results {
+		if res.r.Token == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(res lookupResult) {
+			_, e := dht.query(n, res.n, NewAnnouncePeerRequest(dht.nextTID(), dht.id(), string(ih[:]), res.r.Token, string(dest[:])))
+			if e != nil {
+				log.Debugf("failed to announce %s to dht node %s: %s", ih.Hex(), res.n.hash.String(), e.Error())
+			}
+			wg.Done()
+		}(res)
+	}
+	wg.Wait()
+	return
+}
+
+// PingMessage gets what we send peers over the xdht extension so their dht learns about ours, nil if we don't run
+func (dht *XDHT) PingMessage() *Message {
+	dht.mtx.Lock()
+	defer dht.mtx.Unlock()
+	if dht.n == nil {
+		return nil
+	}
+	return NewPingRequest("", dht.id(), string(dht.us[:]))
+}
+
+// ping a node a peer told us about, we learn about the nodes close to us from it if we know few
+func (dht *XDHT) bootstrap(n Network, args *Args) {
+	x := new(node)
+	if args == nil || len(args.ID) != len(x.id) || len(args.Dest) != len(x.hash) {
+		return
+	}
+	copy(x.id[:], args.ID)
+	copy(x.hash[:], args.Dest)
+	if x.hash == dht.us || dht.table.has(x.id) {
+		return
+	}
+	_, err := dht.query(n, x, NewPingRequest(dht.nextTID(), dht.id(), ""))
+	if err == nil && dht.table.size() < K {
+		dht.refresh(n)
+	}
+}
+
+func (dht *XDHT) HandleError(err *Error) {
+	if err != nil {
+		log.Debugf("got xdht error: %s", err.Error())
+	}
 }
 
 func (dht *XDHT) HandleMessage(msg extensions.Message, src common.PeerID) (err error) {
@@ -21,6 +450,11 @@ func (dht *XDHT) HandleMessage(msg extensions.Message, src common.PeerID) (err e
 	if err == nil {
 		if dhtmsg.IsError() {
 			dht.HandleError(dhtmsg.Err)
+		} else if dhtmsg.IsQuery() && dhtmsg.Query == mPing {
+			// a peer telling us about its dht node
+			if n := dht.network(); n != nil {
+				go dht.bootstrap(n, dhtmsg.Args)
+			}
 		}
 	}
 	return
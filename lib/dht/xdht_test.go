@@ -0,0 +1,169 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/bittorrent/extensions"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"github.com/zeebo/bencode"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// datagrams between in memory test networks
+type testHub struct {
+	mtx  sync.Mutex
+	nets map[string]*testNet
+}
+
+type testPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// network that exchanges datagrams with the other networks on its hub
+type testNet struct {
+	hub    *testHub
+	addr   i2p.Addr
+	in     chan testPacket
+	closed chan struct{}
+}
+
+func (h *testHub) newNet(name string) *testNet {
+	n := &testNet{
+		hub:    h,
+		addr:   i2p.I2PAddr(name),
+		in:     make(chan testPacket, 64),
+		closed: make(chan struct{}),
+	}
+	h.mtx.Lock()
+	h.nets[n.addr.Base32Addr().String()] = n
+	h.mtx.Unlock()
+	return n
+}
+
+func (n *testNet) ReadFrom(d []byte) (int, net.Addr, error) {
+	select {
+	case p := <-n.in:
+		return copy(d, p.data), p.from, nil
+	case <-n.closed:
+		return 0, nil, errors.New("closed")
+	}
+}
+
+func (n *testNet) WriteTo(d []byte, to net.Addr) (int, error) {
+	a, ok := to.(i2p.Addr)
+	if !ok {
+		return 0, errors.New("not an i2p address")
+	}
+	n.hub.mtx.Lock()
+	dst := n.hub.nets[a.Base32Addr().String()]
+	n.hub.mtx.Unlock()
+	if dst != nil {
+		dst.in <- testPacket{append([]byte(nil), d...), n.addr}
+	}
+	return len(d), nil
+}
+
+func (n *testNet) Lookup(name, port string) (net.Addr, error) {
+	n.hub.mtx.Lock()
+	defer n.hub.mtx.Unlock()
+	dst, ok := n.hub.nets[name]
+	if !ok {
+		return nil, errors.New("no such destination")
+	}
+	return dst.addr, nil
+}
+
+func (n *testNet) PacketAddr() net.Addr {
+	return n.addr
+}
+
+func TestXDHT(t *testing.T) {
+	hub := &testHub{
+		nets: make(map[string]*testNet),
+	}
+	nodes := make([]*XDHT, 12)
+	for idx := range nodes {
+		n := hub.newNet(fmt.Sprintf("node%04d", idx))
+		defer close(n.closed)
+		nodes[idx] = new(XDHT)
+		nodes[idx].Enable()
+		go nodes[idx].Run(n)
+	}
+	if _, err := nodes[0].Announce(common.Infohash{}, i2p.Base32Addr{}); err != ErrNotRunning && err != ErrNoNodes {
+		t.Fatalf("announce without nodes gave %v", err)
+	}
+	var ping *Message
+	for ping == nil {
+		time.Sleep(time.Millisecond * 10)
+		ping = nodes[0].PingMessage()
+	}
+	payload, err := bencode.EncodeBytes(ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// everyone learns about the first node from a peer connection
+	for _, d := range nodes[1:] {
+		for d.PingMessage() == nil {
+			time.Sleep(time.Millisecond * 10)
+		}
+		err = d.HandleMessage(extensions.Message{PayloadRaw: payload}, common.PeerID{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 10)
+	for _, d := range nodes {
+		for d.table.size() < 2 {
+			if time.Now().After(deadline) {
+				t.Fatal("nodes did not learn about each other")
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	var ih common.Infohash
+	ih[0] = 0x55
+	var seeder, leecher i2p.Base32Addr
+	seeder[0] = 1
+	leecher[0] = 2
+	peers, err := nodes[3].Announce(ih, seeder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("first announce found peers %v", peers)
+	}
+	peers, err = nodes[9].Announce(ih, leecher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0] != seeder {
+		t.Fatalf("expected to find the seeder got %v", peers)
+	}
+
+	peers, answered, err := nodes[5].GetPeers(ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || answered == 0 {
+		t.Fatalf("get_peers found %v from %d nodes", peers, answered)
+	}
+
+	target := nodes[4].Status()
+	id, _, err := nodes[2].Ping(target.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Hex() != target.ID {
+		t.Fatalf("ping got node %s instead of %s", id.Hex(), target.ID)
+	}
+	st := nodes[2].Status()
+	if !st.Running || len(st.Nodes) == 0 {
+		t.Fatalf("bad status %+v", st)
+	}
+}
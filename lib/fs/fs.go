@@ -5,6 +5,36 @@ import (
 	"os"
 )
 
+// copy a file for drivers that can't hardlink it, the copy is moved into place once it is whole
+func copyFile(d Driver, oldPath, newPath string) (err error) {
+	var r ReadFile
+	r, err = d.OpenFileReadOnly(oldPath)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	tmp := newPath + ".part"
+	// left over from a copy that did not finish
+	d.Remove(tmp)
+	var w WriteFile
+	w, err = d.OpenFileWriteOnly(tmp)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+	w.Close()
+	if err == nil {
+		err = d.Move(tmp, newPath)
+	}
+	if err != nil {
+		d.Remove(tmp)
+	}
+	return
+}
+
 type ReadFile interface {
 	io.ReadCloser
 	io.ReaderAt
@@ -40,6 +70,14 @@ type Driver interface {
 	Join(parts ...string) string
 	// move file
 	Move(oldPath, newPath string) error
+	// hardlink file, copies it when it can't be linked, newPath must not exist
+	Link(oldPath, newPath string) error
+	// make a symlink at newPath pointing to target, a symlink already pointing there is left alone
+	Symlink(target, newPath string) error
+	// set file mode bits
+	Chmod(fpath string, mode os.FileMode) error
+	// set the numeric owner and group of a file, -1 leaves one as it is
+	Chown(fpath string, uid, gid int) error
 	// split path into dirname, basename
 	Split(path string) (string, string)
 	// call stat()
@@ -296,6 +296,68 @@ func (fs *sftpFS) Move(oldpath, newpath string) (err error) {
 	return
 }
 
+func (fs *sftpFS) Link(oldpath, newpath string) (err error) {
+	dir, _ := fs.Split(newpath)
+	err = fs.EnsureDir(dir)
+	if err == nil {
+		err = fs.ensureConn(func(c *sftp.Client) error {
+			return c.Link(oldpath, newpath)
+		})
+		if err != nil {
+			// server without the hardlink extension
+			err = copyFile(fs, oldpath, newpath)
+		}
+	}
+	return
+}
+
+func (fs *sftpFS) Symlink(target, newpath string) (err error) {
+	dir, _ := fs.Split(newpath)
+	err = fs.EnsureDir(dir)
+	if err == nil {
+		err = fs.ensureConn(func(c *sftp.Client) error {
+			if old, e := c.ReadLink(newpath); e == nil {
+				if old == target {
+					return nil
+				}
+				c.Remove(newpath)
+			} else if fi, e := c.Lstat(newpath); e == nil && fi.Mode().IsRegular() && fi.Size() == 0 {
+				// allocated as an empty file before we knew about symlinks
+				c.Remove(newpath)
+			}
+			return c.Symlink(target, newpath)
+		})
+	}
+	return
+}
+
+func (fs *sftpFS) Chmod(fpath string, mode os.FileMode) error {
+	return fs.ensureConn(func(c *sftp.Client) error {
+		return c.Chmod(fpath, mode)
+	})
+}
+
+func (fs *sftpFS) Chown(fpath string, uid, gid int) error {
+	return fs.ensureConn(func(c *sftp.Client) error {
+		if uid < 0 || gid < 0 {
+			// sftp always sets both
+			st, err := c.Stat(fpath)
+			if err != nil {
+				return err
+			}
+			if sys, ok := st.Sys().(*sftp.FileStat); ok {
+				if uid < 0 {
+					uid = int(sys.UID)
+				}
+				if gid < 0 {
+					gid = int(sys.GID)
+				}
+			}
+		}
+		return c.Chown(fpath, uid, gid)
+	})
+}
+
 func (fs *sftpFS) Split(path string) (base, file string) {
 	base, file = sftp.Split(path)
 	return
@@ -63,6 +63,46 @@ func (f stdFs) Move(oldpath, newpath string) (err error) {
 	return
 }
 
+func (f stdFs) Link(oldpath, newpath string) (err error) {
+	dir, _ := f.Split(newpath)
+	err = f.EnsureDir(dir)
+	if err == nil {
+		err = os.Link(oldpath, newpath)
+		if err != nil {
+			// other device or no hardlinks on this filesystem
+			err = copyFile(f, oldpath, newpath)
+		}
+	}
+	return
+}
+
+func (f stdFs) Symlink(target, newpath string) (err error) {
+	dir, _ := f.Split(newpath)
+	err = f.EnsureDir(dir)
+	if err != nil {
+		return
+	}
+	if old, e := os.Readlink(newpath); e == nil {
+		if old == target {
+			return
+		}
+		os.Remove(newpath)
+	} else if fi, e := os.Lstat(newpath); e == nil && fi.Mode().IsRegular() && fi.Size() == 0 {
+		// allocated as an empty file before we knew about symlinks
+		os.Remove(newpath)
+	}
+	err = os.Symlink(target, newpath)
+	return
+}
+
+func (f stdFs) Chmod(fname string, mode os.FileMode) error {
+	return os.Chmod(fname, mode)
+}
+
+func (f stdFs) Chown(fname string, uid, gid int) error {
+	return os.Chown(fname, uid, gid)
+}
+
 func (f stdFs) Split(path string) (base, file string) {
 	base, file = filepath.Split(path)
 	return
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// DiskUsage gets how many bytes a file really takes on disk, sparse files only count the blocks that were written
+// falls back to the file's size if the driver can't tell us
+func DiskUsage(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Blocks) * 512
+	}
+	return uint64(fi.Size())
+}
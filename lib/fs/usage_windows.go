@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package fs
+
+import (
+	"os"
+)
+
+// DiskUsage gets how many bytes a file really takes on disk, this is just its size on windows
+func DiskUsage(fi os.FileInfo) uint64 {
+	return uint64(fi.Size())
+}
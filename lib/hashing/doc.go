@@ -0,0 +1,2 @@
+// piece hashing with throughput accounting
+package hashing
@@ -0,0 +1,124 @@
+package hashing
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"golang.org/x/sys/cpu"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// the go runtime already picks sha1/sha256 implementations using hardware
+// instructions when the cpu has them (SHA-NI on amd64, the ARMv8 crypto
+// extensions on arm64) so we go through the standard library and only keep
+// track of how fast it is
+
+// Stats is how much we hashed and how long it took
+type Stats struct {
+	SHA1Bytes    uint64        `json:"sha1_bytes"`
+	SHA1Time     time.Duration `json:"sha1_time"`
+	SHA256Bytes  uint64        `json:"sha256_bytes"`
+	SHA256Time   time.Duration `json:"sha256_time"`
+	Acceleration string        `json:"acceleration"`
+}
+
+// SHA1Throughput gets sha1 throughput in bytes per second
+func (s Stats) SHA1Throughput() float64 {
+	return throughput(s.SHA1Bytes, s.SHA1Time)
+}
+
+// SHA256Throughput gets sha256 throughput in bytes per second
+func (s Stats) SHA256Throughput() float64 {
+	return throughput(s.SHA256Bytes, s.SHA256Time)
+}
+
+func throughput(n uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+var sha1Bytes, sha1Nanos, sha256Bytes, sha256Nanos uint64
+
+// SHA1 hashes data and records throughput
+func SHA1(data []byte) (h [sha1.Size]byte) {
+	started := time.Now()
+	h = sha1.Sum(data)
+	atomic.AddUint64(&sha1Nanos, uint64(time.Since(started)))
+	atomic.AddUint64(&sha1Bytes, uint64(len(data)))
+	return
+}
+
+// SHA256 hashes data and records throughput
+func SHA256(data []byte) (h [sha256.Size]byte) {
+	started := time.Now()
+	h = sha256.Sum256(data)
+	atomic.AddUint64(&sha256Nanos, uint64(time.Since(started)))
+	atomic.AddUint64(&sha256Bytes, uint64(len(data)))
+	return
+}
+
+// Acceleration lists the hardware hashing instructions this cpu has that the runtime uses, "none" if it has none
+func Acceleration() string {
+	var found []string
+	switch runtime.GOARCH {
+	case "amd64":
+		// the runtime only takes the avx2 path with bmi1 and bmi2, x/sys/cpu doesn't tell us about SHA-NI
+		if cpu.X86.HasAVX2 && cpu.X86.HasBMI1 && cpu.X86.HasBMI2 {
+			found = append(found, "AVX2")
+		}
+	case "arm64":
+		if cpu.ARM64.HasSHA1 {
+			found = append(found, "ARMv8 SHA1")
+		}
+		if cpu.ARM64.HasSHA2 {
+			found = append(found, "ARMv8 SHA2")
+		}
+	case "s390x":
+		if cpu.S390X.HasSHA1 {
+			found = append(found, "CPACF SHA-1")
+		}
+		if cpu.S390X.HasSHA256 {
+			found = append(found, "CPACF SHA-256")
+		}
+	}
+	if len(found) == 0 {
+		return "none"
+	}
+	return strings.Join(found, ", ")
+}
+
+// GetStats gets hashing stats since startup
+func GetStats() Stats {
+	return Stats{
+		SHA1Bytes:    atomic.LoadUint64(&sha1Bytes),
+		SHA1Time:     time.Duration(atomic.LoadUint64(&sha1Nanos)),
+		SHA256Bytes:  atomic.LoadUint64(&sha256Bytes),
+		SHA256Time:   time.Duration(atomic.LoadUint64(&sha256Nanos)),
+		Acceleration: Acceleration(),
+	}
+}
+
+// Benchmark hashes blocks of size bytes with sha1 and sha256 for about d each
+// returns throughput in bytes per second, does not count towards GetStats
+func Benchmark(size int, d time.Duration) (sha1Rate, sha256Rate float64) {
+	data := make([]byte, size)
+	var n uint64
+	started := time.Now()
+	for time.Since(started) < d {
+		sha1.Sum(data)
+		n += uint64(size)
+	}
+	sha1Rate = throughput(n, time.Since(started))
+	n = 0
+	started = time.Now()
+	for time.Since(started) < d {
+		sha256.Sum256(data)
+		n += uint64(size)
+	}
+	sha256Rate = throughput(n, time.Since(started))
+	return
+}
@@ -0,0 +1,41 @@
+package hashing
+
+import (
+	"crypto/sha1"
+	"strings"
+	"testing"
+)
+
+func TestSHA1Stats(t *testing.T) {
+	data := []byte("XD")
+	before := GetStats()
+	if SHA1(data) != sha1.Sum(data) {
+		t.Error("sha1 digest missmatch")
+	}
+	after := GetStats()
+	if after.SHA1Bytes-before.SHA1Bytes != uint64(len(data)) {
+		t.Errorf("hashed %d bytes but counted %d", len(data), after.SHA1Bytes-before.SHA1Bytes)
+	}
+}
+
+func BenchmarkSHA1Piece(b *testing.B) {
+	data := make([]byte, 256*1024)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		SHA1(data)
+	}
+}
+
+func BenchmarkSHA256Piece(b *testing.B) {
+	data := make([]byte, 256*1024)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		SHA256(data)
+	}
+}
+
+func TestAcceleration(t *testing.T) {
+	if a := Acceleration(); a == "" || strings.HasSuffix(a, ", ") {
+		t.Errorf("bad acceleration %q", a)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 	//t "github.com/majestrate/XD/lib/translate"
 )
@@ -45,24 +46,66 @@ func (l logLevel) Name() string {
 
 }
 
-var level = info
+// current logLevel, atomic so log calls don't wait on mtx to check it, only changed with mtx held
+var level = int32(info)
+
+func currentLevel() logLevel {
+	return logLevel(atomic.LoadInt32(&level))
+}
+
+// mtx must be held
+func setCurrentLevel(l logLevel) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+func (l logLevel) String() string {
+	switch l {
+	case debug:
+		return "debug"
+	case info:
+		return "info"
+	case warn:
+		return "warn"
+	case err:
+		return "err"
+	case fatal:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+func parseLevel(l string) (logLevel, bool) {
+	switch strings.ToLower(l) {
+	case "debug":
+		return debug, true
+	case "info":
+		return info, true
+	case "warn":
+		return warn, true
+	case "err":
+		return err, true
+	case "fatal":
+		return fatal, true
+	default:
+		return info, false
+	}
+}
 
 // SetLevel sets global logger level
 func SetLevel(l string) {
-	l = strings.ToLower(l)
-	if l == "debug" {
-		level = debug
-	} else if l == "info" {
-		level = info
-	} else if l == "warn" {
-		level = warn
-	} else if l == "err" {
-		level = err
-	} else if l == "fatal" {
-		level = fatal
-	} else {
+	lvl, ok := parseLevel(l)
+	if !ok {
 		panic(fmt.Sprintf("invalid log level: '%s'", l))
 	}
+	mtx.Lock()
+	if boostTimer == nil {
+		setCurrentLevel(lvl)
+	} else {
+		// raised for a while, go to this level when that is over
+		boostRestore = lvl
+	}
+	mtx.Unlock()
 }
 
 var out io.Writer = os.Stdout
@@ -73,7 +116,7 @@ func SetOutput(w io.Writer) {
 }
 
 func accept(lvl logLevel) bool {
-	return lvl.Int() >= level.Int()
+	return lvl.Int() >= currentLevel().Int()
 }
 
 func log(lvl logLevel, f string, args ...interface{}) {
@@ -83,6 +126,7 @@ func log(lvl logLevel, f string, args ...interface{}) {
 		mtx.Lock()
 		fmt.Fprintf(out, "%s[%s] %s\t%s%s", lvl.Color(), lvl.Name(), t, m, colorReset)
 		fmt.Fprintln(out)
+		recent.put(fmt.Sprintf("[%s] %s\t%s", lvl.Name(), t, m))
 		mtx.Unlock()
 		if lvl == fatal {
 			panic(m)
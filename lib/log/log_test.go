@@ -0,0 +1,69 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLevel(t *testing.T) {
+	defer SetOutput(out)
+	defer SetLevel(Level())
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetLevel("warn")
+	if Level() != "warn" {
+		t.Fatalf("level is %s", Level())
+	}
+	Info("quiet")
+	Warn("loud")
+	if strings.Contains(buf.String(), "quiet") || !strings.Contains(buf.String(), "loud") {
+		t.Fatalf("logged %q at warn", buf.String())
+	}
+}
+
+func TestBoost(t *testing.T) {
+	defer SetLevel(Level())
+	SetLevel("err")
+	if err := Boost("debug", time.Millisecond*50); err != nil {
+		t.Fatal(err)
+	}
+	if Level() != "debug" {
+		t.Fatalf("level is %s while boosted", Level())
+	}
+	// set while boosted, applies once it is over
+	SetLevel("warn")
+	if Level() != "debug" {
+		t.Fatalf("level is %s while boosted", Level())
+	}
+	time.Sleep(time.Millisecond * 200)
+	if Level() != "warn" {
+		t.Fatalf("level is %s after the boost", Level())
+	}
+	if Boost("loud", time.Second) == nil {
+		t.Fatal("boosted to a level that doesn't exist")
+	}
+}
+
+func TestLevelRace(t *testing.T) {
+	defer SetOutput(out)
+	defer SetLevel(Level())
+	SetOutput(new(bytes.Buffer))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		for idx := 0; idx < 100; idx++ {
+			Debugf("%d", idx)
+		}
+		wg.Done()
+	}()
+	go func() {
+		for idx := 0; idx < 100; idx++ {
+			SetLevel([]string{"debug", "info"}[idx%2])
+		}
+		wg.Done()
+	}()
+	wg.Wait()
+}
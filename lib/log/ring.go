@@ -0,0 +1,98 @@
+package log
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultRingSize is how many log lines we keep in memory by default
+const DefaultRingSize = 1000
+
+// ring buffer of recent log lines, guarded by mtx
+type ring struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func (r *ring) put(line string) {
+	if len(r.lines) == 0 {
+		return
+	}
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+func (r *ring) tail(n int) (lines []string) {
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	for idx := count - n; idx < count; idx++ {
+		lines = append(lines, r.lines[(r.next-count+idx+len(r.lines))%len(r.lines)])
+	}
+	return
+}
+
+var recent = &ring{lines: make([]string, DefaultRingSize)}
+
+// SetRingSize sets how many recent log lines are kept in memory, dropping the ones kept so far
+func SetRingSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	mtx.Lock()
+	recent = &ring{lines: make([]string, n)}
+	mtx.Unlock()
+}
+
+// Tail returns the last n log lines kept in memory, oldest first, all of them if n <= 0
+func Tail(n int) []string {
+	mtx.Lock()
+	defer mtx.Unlock()
+	return recent.tail(n)
+}
+
+// level to go back to and timer to do it when verbosity is raised for a while
+var boostRestore logLevel
+var boostTimer *time.Timer
+
+// Boost sets the log level to l for duration d then goes back to the level that was set before
+func Boost(l string, d time.Duration) error {
+	lvl, ok := parseLevel(l)
+	if !ok {
+		return fmt.Errorf("invalid log level: '%s'", l)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if boostTimer == nil {
+		boostRestore = currentLevel()
+	} else {
+		boostTimer.Stop()
+	}
+	setCurrentLevel(lvl)
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		mtx.Lock()
+		// a newer boost took over if it fired while that one stopped it
+		if boostTimer == timer {
+			setCurrentLevel(boostRestore)
+			boostTimer = nil
+		}
+		mtx.Unlock()
+	})
+	boostTimer = timer
+	return nil
+}
+
+// Level returns the name of the current log level
+func Level() string {
+	return currentLevel().String()
+}
@@ -0,0 +1,117 @@
+package metainfo
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"net/url"
+	"strings"
+)
+
+// multihash prefix of a sha2-256 digest, what btmh magnets carry
+const sha256Multihash = "1220"
+
+// ErrV2OnlyMagnet is returned when adding a magnet with only a v2 infohash, peers don't send the piece layers we need to check its pieces
+var ErrV2OnlyMagnet = errors.New("magnets with only a v2 infohash are not supported, add the torrent file instead")
+
+// Magnet is a parsed magnet uri, it has a v1 infohash, a v2 infohash or both
+type Magnet struct {
+	V1       *common.Infohash
+	V2       *common.InfohashV2
+	Name     string
+	Trackers []string
+}
+
+// ParseMagnet parses a magnet uri with urn:btih: and/or urn:btmh: exact topics
+func ParseMagnet(uri string) (m Magnet, err error) {
+	var u *url.URL
+	u, err = url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if strings.ToLower(u.Scheme) != "magnet" {
+		err = common.ErrBadMagnetURI
+		return
+	}
+	q := u.Query()
+	for k, vals := range q {
+		// there can be more than one exact topic as xt.1, xt.2 ...
+		if k != "xt" && !strings.HasPrefix(k, "xt.") {
+			continue
+		}
+		for _, xt := range vals {
+			xt = strings.ToLower(xt)
+			if strings.HasPrefix(xt, "urn:btih:") {
+				var ih common.Infohash
+				ih, err = decodeBTIH(xt[9:])
+				if err != nil {
+					return
+				}
+				m.V1 = &ih
+			} else if strings.HasPrefix(xt, "urn:btmh:") {
+				var ih common.InfohashV2
+				ih, err = decodeBTMH(xt[9:])
+				if err != nil {
+					return
+				}
+				m.V2 = &ih
+			}
+		}
+	}
+	if m.V1 == nil && m.V2 == nil {
+		err = common.ErrBadMagnetURI
+		return
+	}
+	m.Name = q.Get("dn")
+	m.Trackers = q["tr"]
+	return
+}
+
+// Infohash gets the infohash to find the torrent by, the v1 one if there is one as hybrid torrents are known by it
+func (m Magnet) Infohash() common.Infohash {
+	if m.V1 != nil {
+		return *m.V1
+	}
+	return m.V2.Truncated()
+}
+
+// IsV2Only returns true if the magnet only has a v2 infohash
+func (m Magnet) IsV2Only() bool {
+	return m.V1 == nil && m.V2 != nil
+}
+
+// btih infohashes are 40 hex digits or 32 base32 ones
+func decodeBTIH(s string) (ih common.Infohash, err error) {
+	if len(s) == 32 {
+		var dec []byte
+		dec, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		if err == nil && len(dec) == 20 {
+			copy(ih[:], dec)
+		} else {
+			err = common.ErrBadMagnetURI
+		}
+		return
+	}
+	ih, err = common.DecodeInfohash(s)
+	if err != nil {
+		err = common.ErrBadMagnetURI
+	}
+	return
+}
+
+// btmh infohashes are hex multihashes, only sha2-256 ones are v2 infohashes
+func decodeBTMH(s string) (ih common.InfohashV2, err error) {
+	if !strings.HasPrefix(s, sha256Multihash) {
+		err = common.ErrBadMagnetURI
+		return
+	}
+	var dec []byte
+	dec, err = hex.DecodeString(s[len(sha256Multihash):])
+	if err == nil && len(dec) == 32 {
+		copy(ih[:], dec)
+	} else {
+		err = common.ErrBadMagnetURI
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package metainfo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	v1 := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	v2 := strings.Repeat("ab", 32)
+
+	m, err := ParseMagnet("magnet:?xt=urn:btmh:1220" + v2 + "&dn=test&tr=http%3A%2F%2Ftracker.i2p%2Fa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsV2Only() || m.V2.Hex() != v2 {
+		t.Fatalf("v2 infohash %v", m.V2)
+	}
+	if m.Infohash() != m.V2.Truncated() {
+		t.Fatal("v2 only magnet not known by its truncated v2 infohash")
+	}
+	if m.Name != "test" || len(m.Trackers) != 1 || m.Trackers[0] != "http://tracker.i2p/a" {
+		t.Fatalf("name %q trackers %v", m.Name, m.Trackers)
+	}
+
+	m, err = ParseMagnet("magnet:?xt.1=urn:btih:" + strings.ToUpper(v1) + "&xt.2=urn:btmh:1220" + v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IsV2Only() || m.Infohash().Hex() != v1 {
+		t.Fatalf("hybrid magnet known by %s", m.Infohash().Hex())
+	}
+
+	m, err = ParseMagnet("magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Infohash().Hex() != v1 {
+		t.Fatalf("base32 infohash decoded to %s", m.Infohash().Hex())
+	}
+
+	for _, bad := range []string{
+		"magnet:?dn=test",
+		"http://example.i2p/?xt=urn:btih:" + v1,
+		"magnet:?xt=urn:btih:" + v1[2:],
+		// sha1 multihash
+		"magnet:?xt=urn:btmh:1114" + v1,
+		"magnet:?xt=urn:btmh:1220" + v2[2:],
+	} {
+		if _, err := ParseMagnet(bad); err == nil {
+			t.Errorf("parsed %s", bad)
+		}
+	}
+}
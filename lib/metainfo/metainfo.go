@@ -5,11 +5,13 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/hashing"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/util"
 	"github.com/zeebo/bencode"
 	"io"
 	"path/filepath"
+	"strings"
 )
 
 type FilePath []string
@@ -31,6 +33,25 @@ type FileInfo struct {
 	Path FilePath `bencode:"path"`
 	// md5sum
 	Sum []byte `bencode:"md5sum,omitempty"`
+	// bep 47 attributes
+	Attr string `bencode:"attr,omitempty"`
+	// where a symlink points, relative to the torrent's directory
+	SymlinkPath FilePath `bencode:"symlink path,omitempty"`
+}
+
+// IsPadding returns true if this file is padding between files that is never stored
+func (f FileInfo) IsPadding() bool {
+	return strings.Contains(f.Attr, "p")
+}
+
+// IsExecutable returns true if this file should be executable
+func (f FileInfo) IsExecutable() bool {
+	return strings.Contains(f.Attr, "x")
+}
+
+// IsSymlink returns true if this file is a symlink to SymlinkPath, symlinks have no data of their own
+func (f FileInfo) IsSymlink() bool {
+	return strings.Contains(f.Attr, "l")
 }
 
 // info section of torrent file
@@ -38,17 +59,25 @@ type Info struct {
 	// length of pices in bytes
 	PieceLength uint32 `bencode:"piece length"`
 	// piece data
-	Pieces []byte `bencode:"pieces"`
+	Pieces []byte `bencode:"pieces,omitempty"`
 	// name of root file
 	Path string `bencode:"name"`
 	// file metadata
 	Files []FileInfo `bencode:"files,omitempty"`
 	// private torrent
 	Private *uint64 `bencode:"private,omitempty"`
+	// tag that makes the infohash differ from torrents of the same files made for other trackers
+	Source string `bencode:"source,omitempty"`
 	// length of file in signle file mode
 	Length uint64 `bencode:"length,omitempty"`
 	// md5sum
 	Sum []byte `bencode:"md5sum,omitempty"`
+	// bep 47 attributes of the file in signle file mode
+	Attr string `bencode:"attr,omitempty"`
+	// 2 for v2 and hybrid torrents
+	MetaVersion uint64 `bencode:"meta version,omitempty"`
+	// v2 file tree
+	FileTree *FileTree `bencode:"file tree,omitempty"`
 }
 
 func (i Info) Bytes() []byte {
@@ -59,11 +88,14 @@ func (i Info) Bytes() []byte {
 
 // get fileinfos from this info section
 func (i Info) GetFiles() (infos []FileInfo) {
-	if i.Length > 0 {
+	if i.IsV2Only() {
+		infos = i.v2FileInfos()
+	} else if i.Length > 0 {
 		infos = append(infos, FileInfo{
 			Length: i.Length,
 			Path:   FilePath([]string{i.Path}),
 			Sum:    i.Sum,
+			Attr:   i.Attr,
 		})
 	} else {
 		infos = append(infos, i.Files...)
@@ -75,7 +107,7 @@ func (i Info) GetFiles() (infos []FileInfo) {
 func (i Info) CheckPiece(p *common.PieceData) bool {
 	idx := p.Index * 20
 	if i.NumPieces() > p.Index {
-		h := sha1.Sum(p.Data)
+		h := hashing.SHA1(p.Data)
 		expected := i.Pieces[idx : idx+20]
 		if bytes.Equal(h[:], expected) {
 			return true
@@ -88,6 +120,9 @@ func (i Info) CheckPiece(p *common.PieceData) bool {
 }
 
 func (i Info) NumPieces() uint32 {
+	if i.IsV2Only() {
+		return i.numPiecesV2()
+	}
 	return uint32(len(i.Pieces) / 20)
 }
 
@@ -96,17 +131,23 @@ type TorrentFile struct {
 	Info         Info       `bencode:"info"`
 	Announce     string     `bencode:"announce"`
 	AnnounceList [][]string `bencode:"announce-list"`
-	Created      int64      `bencode:"created"`
+	Created      int64      `bencode:"creation date,omitempty"`
 	Comment      []byte     `bencode:"comment"`
 	CreatedBy    []byte     `bencode:"created by"`
 	Encoding     []byte     `bencode:"encoding"`
+	// v2 piece hashes of files bigger than a piece keyed by their pieces root
+	PieceLayers map[string][]byte `bencode:"piece layers,omitempty"`
+	// bep 19 web seeds
+	URLList URLList `bencode:"url-list,omitempty"`
+	// bep 17 http seeds
+	HTTPSeeds []string `bencode:"httpseeds,omitempty"`
 }
 
 func (tf *TorrentFile) LengthOfPiece(idx uint32) (l uint32) {
 	i := tf.Info
 	np := i.NumPieces()
 	if np == idx+1 {
-		sz := tf.TotalSize()
+		sz := tf.dataSize()
 		l64 := uint64(i.PieceLength) - ((uint64(np) * uint64(i.PieceLength)) - sz)
 		l = uint32(l64)
 	} else {
@@ -115,13 +156,63 @@ func (tf *TorrentFile) LengthOfPiece(idx uint32) (l uint32) {
 	return
 }
 
-// get total size of files from torrent info section
+// BytesIn returns how many bytes in the range [off, off+length) of the torrent's data
+// fall in pieces for which has returns true
+func (tf *TorrentFile) BytesIn(has func(idx uint32) bool, off, length uint64) (n uint64) {
+	pl := uint64(tf.Info.PieceLength)
+	if length == 0 || pl == 0 {
+		return
+	}
+	end := off + length
+	for p := off / pl; p*pl < end; p++ {
+		if has(uint32(p)) {
+			start := p * pl
+			if start < off {
+				start = off
+			}
+			stop := (p + 1) * pl
+			if stop > end {
+				stop = end
+			}
+			n += stop - start
+		}
+	}
+	return
+}
+
+// StoredBytesIn returns how many bytes of the files we store fall in pieces for which has returns true, padding files don't count
+func (tf *TorrentFile) StoredBytesIn(has func(idx uint32) bool) (n uint64) {
+	var off uint64
+	for _, f := range tf.Info.GetFiles() {
+		if !f.IsPadding() {
+			n += tf.BytesIn(has, off, f.Length)
+		}
+		off += f.Length
+	}
+	return
+}
+
+// get total size of files from torrent info section, padding files are never stored so they don't count
 func (tf *TorrentFile) TotalSize() uint64 {
-	if tf.IsSingleFile() {
+	if tf.Info.Length > 0 {
 		return tf.Info.Length
 	}
 	total := uint64(0)
-	for _, f := range tf.Info.Files {
+	for _, f := range tf.Info.GetFiles() {
+		if !f.IsPadding() {
+			total += f.Length
+		}
+	}
+	return total
+}
+
+// size of the data the pieces cover, padding included
+func (tf *TorrentFile) dataSize() uint64 {
+	if tf.Info.Length > 0 {
+		return tf.Info.Length
+	}
+	total := uint64(0)
+	for _, f := range tf.Info.GetFiles() {
 		total += f.Length
 	}
 	return total
@@ -145,8 +236,11 @@ func (tf *TorrentFile) TorrentName() string {
 	return tf.Info.Path
 }
 
-// calculate infohash
+// calculate infohash, v2 only torrents use their truncated v2 infohash
 func (tf *TorrentFile) Infohash() (ih common.Infohash) {
+	if tf.Info.IsV2Only() {
+		return tf.InfohashV2().Truncated()
+	}
 	s := sha1.New()
 	enc := bencode.NewEncoder(s)
 	enc.Encode(&tf.Info)
@@ -157,7 +251,7 @@ func (tf *TorrentFile) Infohash() (ih common.Infohash) {
 
 // return true if this torrent is for a single file
 func (tf *TorrentFile) IsSingleFile() bool {
-	return tf.Info.Length > 0
+	return tf.Info.Length > 0 || tf.Info.isV2SingleFile()
 }
 
 // bencode this file via an io.Writer
@@ -178,3 +272,38 @@ func (tf *TorrentFile) BDecode(r io.Reader) (err error) {
 func (tf *TorrentFile) IsPrivate() bool {
 	return tf.Info.Private != nil && *tf.Info.Private > 0
 }
+
+// SetPrivate sets if this torrent is private, this changes the infohash
+func (tf *TorrentFile) SetPrivate(private bool) {
+	if private {
+		one := uint64(1)
+		tf.Info.Private = &one
+	} else {
+		tf.Info.Private = nil
+	}
+}
+
+// SetTrackers replaces the trackers with tiers of tracker urls, the first tracker of the first tier goes in announce
+func (tf *TorrentFile) SetTrackers(tiers [][]string) {
+	tf.Announce = ""
+	tf.AnnounceList = nil
+	for _, tier := range tiers {
+		var urls []string
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		if tf.Announce == "" {
+			tf.Announce = urls[0]
+		}
+		tf.AnnounceList = append(tf.AnnounceList, urls)
+	}
+	if len(tf.AnnounceList) == 1 && len(tf.AnnounceList[0]) == 1 {
+		// announce says it all
+		tf.AnnounceList = nil
+	}
+}
@@ -25,3 +25,71 @@ func TestLoadTorrent(t *testing.T) {
 	}
 	// TODO: check members
 }
+
+func TestBytesIn(t *testing.T) {
+	tf := &TorrentFile{
+		Info: Info{
+			PieceLength: 10,
+			Files: []FileInfo{
+				{Length: 15},
+				{Length: 12},
+			},
+		},
+	}
+	// pieces 0 and 2 of 0..2, the last one is 7 bytes long
+	has := func(idx uint32) bool { return idx == 0 || idx == 2 }
+	if n := tf.BytesIn(has, 0, 27); n != 17 {
+		t.Fatalf("whole torrent has %d bytes, wanted 17", n)
+	}
+	if n := tf.BytesIn(has, 0, 15); n != 10 {
+		t.Fatalf("first file has %d bytes, wanted 10", n)
+	}
+	if n := tf.BytesIn(has, 15, 12); n != 7 {
+		t.Fatalf("second file has %d bytes, wanted 7", n)
+	}
+	if n := tf.BytesIn(has, 27, 0); n != 0 {
+		t.Fatalf("empty file has %d bytes", n)
+	}
+}
+
+func TestWebSeeds(t *testing.T) {
+	for _, raw := range []string{
+		"d8:url-list21:http://seed.i2p/data/e",
+		"d8:url-listl21:http://seed.i2p/data/11:ftp://nope/ee",
+	} {
+		tf := new(TorrentFile)
+		if err := bencode.DecodeString(raw, tf); err != nil {
+			t.Fatal(err)
+		}
+		seeds := tf.WebSeeds()
+		if len(seeds) != 1 || seeds[0] != "http://seed.i2p/data/" {
+			t.Fatalf("%s: web seeds %v", raw, seeds)
+		}
+	}
+
+	hs := new(TorrentFile)
+	if err := bencode.DecodeString("d9:httpseedsl24:http://seed.i2p/seed.php11:ftp://nope/ee", hs); err != nil {
+		t.Fatal(err)
+	}
+	if seeds := hs.GetHTTPSeeds(); len(seeds) != 1 || seeds[0] != "http://seed.i2p/seed.php" {
+		t.Fatalf("http seeds %v", seeds)
+	}
+
+	tf := &TorrentFile{
+		Info: Info{
+			Path:  "my torrent",
+			Files: []FileInfo{{Path: FilePath{"dir", "a b.txt"}, Length: 1}},
+		},
+	}
+	if u := tf.WebSeedFileURL("http://seed.i2p/data", tf.Info.Files[0]); u != "http://seed.i2p/data/my%20torrent/dir/a%20b.txt" {
+		t.Fatalf("multi file url %s", u)
+	}
+	tf.Info.Files = nil
+	tf.Info.Length = 1
+	if u := tf.WebSeedFileURL("http://seed.i2p/data/", FileInfo{}); u != "http://seed.i2p/data/my%20torrent" {
+		t.Fatalf("single file url %s", u)
+	}
+	if u := tf.WebSeedFileURL("http://seed.i2p/file.bin", FileInfo{}); u != "http://seed.i2p/file.bin" {
+		t.Fatalf("single file url %s", u)
+	}
+}
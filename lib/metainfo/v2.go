@@ -0,0 +1,464 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/hashing"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/zeebo/bencode"
+	"sort"
+)
+
+// V2BlockSize is the size of the blocks v2 torrents hash files in
+const V2BlockSize = 16384
+
+// ErrBadFileTree is returned when a v2 file tree is malformed
+var ErrBadFileTree = errors.New("bad v2 file tree")
+
+// ErrBadV2PieceLength is returned when a v2 torrent's piece length is not a power of 2 of at least a block
+var ErrBadV2PieceLength = errors.New("v2 piece length must be a power of 2 and at least 16KiB")
+
+// ErrNoPieceLayer is returned when a file bigger than a piece has no piece layer
+var ErrNoPieceLayer = errors.New("missing piece layer")
+
+// ErrBadPieceLayer is returned when a piece layer does not hash to its file's pieces root
+var ErrBadPieceLayer = errors.New("piece layer does not match pieces root")
+
+// ErrHybridMismatch is returned when a hybrid torrent's v1 and v2 files are not the same
+var ErrHybridMismatch = errors.New("hybrid torrent has different v1 and v2 files")
+
+// V2File is a file in a v2 file tree
+type V2File struct {
+	Path   FilePath
+	Length uint64
+	// merkle root of the file's blocks, empty for empty files
+	PiecesRoot []byte
+}
+
+// FileTree is the file tree of a v2 torrent, kept exactly as it was encoded so the infohash does not change
+type FileTree struct {
+	raw   []byte
+	files []V2File
+}
+
+// NewFileTree makes a file tree holding files
+func NewFileTree(files []V2File) (ft *FileTree, err error) {
+	tree := make(map[string]interface{})
+	for _, f := range files {
+		if len(f.Path) == 0 {
+			return nil, ErrBadFileTree
+		}
+		dir := tree
+		for _, name := range f.Path[:len(f.Path)-1] {
+			sub, ok := dir[name].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				dir[name] = sub
+			}
+			dir = sub
+		}
+		leaf := map[string]interface{}{
+			"length": int64(f.Length),
+		}
+		if f.Length > 0 {
+			leaf["pieces root"] = string(f.PiecesRoot)
+		}
+		dir[f.Path[len(f.Path)-1]] = map[string]interface{}{"": leaf}
+	}
+	var raw []byte
+	raw, err = bencode.EncodeBytes(tree)
+	if err == nil {
+		ft = new(FileTree)
+		err = ft.UnmarshalBencode(raw)
+	}
+	return
+}
+
+func (ft *FileTree) MarshalBencode() ([]byte, error) {
+	return ft.raw, nil
+}
+
+func (ft *FileTree) UnmarshalBencode(raw []byte) (err error) {
+	var tree map[string]interface{}
+	err = bencode.DecodeBytes(raw, &tree)
+	if err != nil {
+		return
+	}
+	var files []V2File
+	files, err = walkFileTree(tree, nil, files)
+	if err == nil {
+		ft.raw = append([]byte(nil), raw...)
+		ft.files = files
+	}
+	return
+}
+
+// Files gets the files in the tree in the order their pieces are in
+func (ft *FileTree) Files() []V2File {
+	if ft == nil {
+		return nil
+	}
+	return ft.files
+}
+
+// collect files in a file tree ordered by path
+func walkFileTree(dir map[string]interface{}, path FilePath, files []V2File) ([]V2File, error) {
+	names := make([]string, 0, len(dir))
+	for name := range dir {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			if len(path) == 0 {
+				return nil, ErrBadFileTree
+			}
+			leaf, ok := dir[name].(map[string]interface{})
+			if !ok {
+				return nil, ErrBadFileTree
+			}
+			length, ok := leaf["length"].(int64)
+			if !ok || length < 0 {
+				return nil, ErrBadFileTree
+			}
+			f := V2File{
+				Path:   append(FilePath(nil), path...),
+				Length: uint64(length),
+			}
+			if length > 0 {
+				root, _ := leaf["pieces root"].(string)
+				if len(root) != sha256.Size {
+					return nil, ErrBadFileTree
+				}
+				f.PiecesRoot = []byte(root)
+			}
+			files = append(files, f)
+			continue
+		}
+		sub, ok := dir[name].(map[string]interface{})
+		if !ok {
+			return nil, ErrBadFileTree
+		}
+		var err error
+		files, err = walkFileTree(sub, append(path, name), files)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// IsV2 returns true if this is a v2 or hybrid torrent
+func (i Info) IsV2() bool {
+	return i.MetaVersion == 2 && i.FileTree != nil
+}
+
+// IsV2Only returns true if this is a v2 torrent without v1 pieces
+func (i Info) IsV2Only() bool {
+	return i.IsV2() && len(i.Pieces) == 0
+}
+
+// IsHybrid returns true if this torrent has both v1 pieces and a v2 file tree
+func (i Info) IsHybrid() bool {
+	return i.IsV2() && len(i.Pieces) > 0
+}
+
+// V2Files gets the files in the v2 file tree
+func (i Info) V2Files() []V2File {
+	return i.FileTree.Files()
+}
+
+// a v2 only torrent with just one file named after the torrent
+func (i Info) isV2SingleFile() bool {
+	if !i.IsV2Only() {
+		return false
+	}
+	files := i.V2Files()
+	return len(files) == 1 && len(files[0].Path) == 1 && files[0].Path[0] == i.Path
+}
+
+// v2 pieces never span files, so files are laid out with padding up to the next piece between them
+func (i Info) v2FileInfos() (infos []FileInfo) {
+	pl := uint64(i.PieceLength)
+	files := i.V2Files()
+	for idx, f := range files {
+		infos = append(infos, FileInfo{
+			Length: f.Length,
+			Path:   f.Path,
+		})
+		if pl > 0 && idx+1 < len(files) && f.Length%pl != 0 {
+			pad := pl - f.Length%pl
+			infos = append(infos, FileInfo{
+				Length: pad,
+				Path:   FilePath{".pad", fmt.Sprintf("%d", pad)},
+				Attr:   "p",
+			})
+		}
+	}
+	return
+}
+
+// number of pieces a file of length l has in a v2 torrent
+func (i Info) v2PiecesIn(l uint64) uint32 {
+	pl := uint64(i.PieceLength)
+	if pl == 0 {
+		return 0
+	}
+	return uint32((l + pl - 1) / pl)
+}
+
+func (i Info) numPiecesV2() (n uint32) {
+	for _, f := range i.V2Files() {
+		n += i.v2PiecesIn(f.Length)
+	}
+	return
+}
+
+// find the file holding a v2 piece and the index of the piece in that file
+func (i Info) v2Piece(idx uint32) (f V2File, n uint32, ok bool) {
+	for _, f = range i.V2Files() {
+		np := i.v2PiecesIn(f.Length)
+		if idx < np {
+			return f, idx, true
+		}
+		idx -= np
+	}
+	return
+}
+
+// InfohashV2 calculates the v2 infohash
+func (tf *TorrentFile) InfohashV2() (ih common.InfohashV2) {
+	s := sha256.New()
+	enc := bencode.NewEncoder(s)
+	enc.Encode(&tf.Info)
+	copy(ih[:], s.Sum(nil))
+	return
+}
+
+// CheckPiece checks a piece against the v1 pieces and the v2 merkle trees, whichever the torrent has
+func (tf *TorrentFile) CheckPiece(p *common.PieceData) bool {
+	if tf.Info.IsV2Only() {
+		return tf.checkPieceV2(p, true)
+	}
+	if !tf.Info.CheckPiece(p) {
+		return false
+	}
+	if tf.Info.IsHybrid() {
+		// hybrid torrents we got from magnets have no piece layers, the v1 pieces have to do then
+		return tf.checkPieceV2(p, false)
+	}
+	return true
+}
+
+// check a piece against the v2 merkle trees, pieces of files without a piece layer only check if needLayer is false
+func (tf *TorrentFile) checkPieceV2(p *common.PieceData, needLayer bool) bool {
+	f, n, ok := tf.Info.v2Piece(p.Index)
+	if !ok {
+		log.Error("piece index out of bounds")
+		return false
+	}
+	pl := uint64(tf.Info.PieceLength)
+	// the rest of the last piece of a file is padding
+	l := f.Length - uint64(n)*pl
+	if l > pl {
+		l = pl
+	}
+	if uint64(len(p.Data)) < l {
+		return false
+	}
+	leaves := blockHashes(p.Data[:l])
+	var h [sha256.Size]byte
+	var expected []byte
+	if f.Length <= pl {
+		// the only piece of a small file is its whole tree
+		h = merkleRoot(leaves, nextPow2(len(leaves)), [sha256.Size]byte{})
+		expected = f.PiecesRoot
+	} else {
+		layer := tf.PieceLayers[string(f.PiecesRoot)]
+		if len(layer) < int(n+1)*sha256.Size {
+			if !needLayer {
+				return true
+			}
+			log.Warnf("no piece layer for %s", f.Path.FilePath(""))
+			return false
+		}
+		h = merkleRoot(leaves, int(pl/V2BlockSize), [sha256.Size]byte{})
+		expected = layer[n*sha256.Size : (n+1)*sha256.Size]
+	}
+	if bytes.Equal(h[:], expected) {
+		return true
+	}
+	log.Warnf("piece missmatch: %s != %s", hex.EncodeToString(h[:]), hex.EncodeToString(expected))
+	return false
+}
+
+// CheckV2 checks the v2 parts of a v2 or hybrid torrent agree with each other and with the v1 parts
+func (tf *TorrentFile) CheckV2() error {
+	if tf.Info.IsHybrid() {
+		var files []FileInfo
+		for _, f := range tf.Info.GetFiles() {
+			if !f.IsPadding() {
+				files = append(files, f)
+			}
+		}
+		v2 := tf.Info.V2Files()
+		if len(files) != len(v2) {
+			return ErrHybridMismatch
+		}
+		for idx := range v2 {
+			if files[idx].Length != v2[idx].Length || files[idx].Path.FilePath("") != v2[idx].Path.FilePath("") {
+				return ErrHybridMismatch
+			}
+		}
+	}
+	if tf.Info.IsHybrid() && len(tf.PieceLayers) == 0 {
+		// we got it from a magnet, the v1 pieces check its data
+		return nil
+	}
+	// v2 only torrents have nothing but the piece layers to check pieces of big files against
+	return tf.CheckPieceLayers()
+}
+
+// CheckPieceLayers checks that every file bigger than a piece has a piece layer that hashes to its pieces root
+func (tf *TorrentFile) CheckPieceLayers() error {
+	pl := uint64(tf.Info.PieceLength)
+	if pl < V2BlockSize || pl&(pl-1) != 0 {
+		return ErrBadV2PieceLength
+	}
+	pad := merkleRoot(nil, int(pl/V2BlockSize), [sha256.Size]byte{})
+	for _, f := range tf.Info.V2Files() {
+		if f.Length <= pl {
+			continue
+		}
+		layer := tf.PieceLayers[string(f.PiecesRoot)]
+		np := int(tf.Info.v2PiecesIn(f.Length))
+		if len(layer) != np*sha256.Size {
+			return fmt.Errorf("%s: %s", f.Path.FilePath(""), ErrNoPieceLayer.Error())
+		}
+		hashes := make([][sha256.Size]byte, np)
+		for idx := range hashes {
+			copy(hashes[idx][:], layer[idx*sha256.Size:])
+		}
+		root := merkleRoot(hashes, nextPow2(np), pad)
+		if !bytes.Equal(root[:], f.PiecesRoot) {
+			return fmt.Errorf("%s: %s", f.Path.FilePath(""), ErrBadPieceLayer.Error())
+		}
+	}
+	return nil
+}
+
+// MakePieceLayers computes the piece layers of a v2 or hybrid torrent from the data of its pieces, for hybrid torrents we got from magnets
+func (tf *TorrentFile) MakePieceLayers(getPiece func(idx uint32) ([]byte, error)) (layers map[string][]byte, err error) {
+	pl := uint64(tf.Info.PieceLength)
+	layers = make(map[string][]byte)
+	var idx uint32
+	for _, f := range tf.Info.V2Files() {
+		np := tf.Info.v2PiecesIn(f.Length)
+		if f.Length <= pl {
+			// small files have no piece layer
+			idx += np
+			continue
+		}
+		layer := make([]byte, 0, int(np)*sha256.Size)
+		for n := uint32(0); n < np; n++ {
+			var data []byte
+			data, err = getPiece(idx + n)
+			if err != nil {
+				return nil, err
+			}
+			l := f.Length - uint64(n)*pl
+			if l > pl {
+				l = pl
+			}
+			if uint64(len(data)) < l {
+				return nil, fmt.Errorf("%s: %s", f.Path.FilePath(""), ErrBadPieceLayer.Error())
+			}
+			h := merkleRoot(blockHashes(data[:l]), int(pl/V2BlockSize), [sha256.Size]byte{})
+			layer = append(layer, h[:]...)
+		}
+		layers[string(f.PiecesRoot)] = layer
+		idx += np
+	}
+	return
+}
+
+// HashV2 computes the pieces root of a file's data and its piece layer, the layer is empty if the file fits in one piece
+func HashV2(data []byte, pieceLength uint32) (root, layer []byte) {
+	if len(data) == 0 {
+		return
+	}
+	pl := int(pieceLength)
+	leaves := blockHashes(data)
+	var h [sha256.Size]byte
+	if len(data) <= pl {
+		h = merkleRoot(leaves, nextPow2(len(leaves)), [sha256.Size]byte{})
+		return h[:], nil
+	}
+	width := pl / V2BlockSize
+	var hashes [][sha256.Size]byte
+	for len(leaves) > 0 {
+		n := width
+		if n > len(leaves) {
+			n = len(leaves)
+		}
+		h = merkleRoot(leaves[:n], width, [sha256.Size]byte{})
+		hashes = append(hashes, h)
+		layer = append(layer, h[:]...)
+		leaves = leaves[n:]
+	}
+	h = merkleRoot(hashes, nextPow2(len(hashes)), merkleRoot(nil, width, [sha256.Size]byte{}))
+	return h[:], layer
+}
+
+// sha256 of each block of data, the last block may be short
+func blockHashes(data []byte) (leaves [][sha256.Size]byte) {
+	for len(data) > 0 {
+		n := V2BlockSize
+		if n > len(data) {
+			n = len(data)
+		}
+		leaves = append(leaves, hashing.SHA256(data[:n]))
+		data = data[n:]
+	}
+	return
+}
+
+// root of a merkle tree with width leaves, leaves past the given hashes are pad
+func merkleRoot(hashes [][sha256.Size]byte, width int, pad [sha256.Size]byte) [sha256.Size]byte {
+	layer := hashes
+	for ; width > 1; width /= 2 {
+		next := make([][sha256.Size]byte, (len(layer)+1)/2)
+		for idx := range next {
+			right := pad
+			if 2*idx+1 < len(layer) {
+				right = layer[2*idx+1]
+			}
+			next[idx] = hashPair(layer[2*idx], right)
+		}
+		pad = hashPair(pad, pad)
+		layer = next
+	}
+	if len(layer) == 0 {
+		return pad
+	}
+	return layer[0]
+}
+
+func hashPair(left, right [sha256.Size]byte) [sha256.Size]byte {
+	var buf [2 * sha256.Size]byte
+	copy(buf[:], left[:])
+	copy(buf[sha256.Size:], right[:])
+	return hashing.SHA256(buf[:])
+}
+
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
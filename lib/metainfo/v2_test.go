@@ -0,0 +1,193 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"github.com/majestrate/XD/lib/common"
+	"testing"
+)
+
+const testV2PieceLength = 2 * V2BlockSize
+
+func makeV2Torrent(t *testing.T, big, small []byte) *TorrentFile {
+	bigRoot, bigLayer := HashV2(big, testV2PieceLength)
+	smallRoot, _ := HashV2(small, testV2PieceLength)
+	ft, err := NewFileTree([]V2File{
+		{Path: FilePath{"a", "big.bin"}, Length: uint64(len(big)), PiecesRoot: bigRoot},
+		{Path: FilePath{"small.txt"}, Length: uint64(len(small)), PiecesRoot: smallRoot},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TorrentFile{
+		Info: Info{
+			PieceLength: testV2PieceLength,
+			Path:        "v2",
+			MetaVersion: 2,
+			FileTree:    ft,
+		},
+		PieceLayers: map[string][]byte{string(bigRoot): bigLayer},
+	}
+}
+
+func TestV2Torrent(t *testing.T) {
+	// 2.5 pieces then less than a block
+	big := bytes.Repeat([]byte{1, 2, 3}, (5*V2BlockSize)/3)
+	small := []byte("small file")
+	tf := makeV2Torrent(t, big, small)
+	if !tf.Info.IsV2Only() {
+		t.Fatal("not a v2 only torrent")
+	}
+	if err := tf.CheckPieceLayers(); err != nil {
+		t.Fatal(err)
+	}
+	layers := tf.PieceLayers
+	tf.PieceLayers = nil
+	if tf.CheckV2() == nil {
+		t.Fatal("v2 only torrent without piece layers checks")
+	}
+	tf.PieceLayers = layers
+	if n := tf.Info.NumPieces(); n != 4 {
+		t.Fatalf("%d pieces, wanted 4", n)
+	}
+
+	// the small file starts on a piece boundary after padding
+	files := tf.Info.GetFiles()
+	if len(files) != 3 || !files[1].IsPadding() {
+		t.Fatalf("bad file layout: %v", files)
+	}
+	var data []byte
+	data = append(data, big...)
+	data = append(data, make([]byte, files[1].Length)...)
+	data = append(data, small...)
+	if n := uint64(len(big) + len(small)); n != tf.TotalSize() {
+		t.Fatalf("total size %d, wanted %d without padding", tf.TotalSize(), n)
+	}
+	all := func(uint32) bool { return true }
+	if n := tf.StoredBytesIn(all); n != tf.TotalSize() {
+		t.Fatalf("%d bytes stored in every piece, wanted %d", n, tf.TotalSize())
+	}
+	if n := tf.LengthOfPiece(tf.Info.NumPieces() - 1); n != uint32(len(small)) {
+		t.Fatalf("last piece is %d bytes, wanted %d", n, len(small))
+	}
+	for idx := uint32(0); idx < tf.Info.NumPieces(); idx++ {
+		off := idx * testV2PieceLength
+		pc := &common.PieceData{Index: idx, Data: data[off : off+tf.LengthOfPiece(idx)]}
+		if !tf.CheckPiece(pc) {
+			t.Fatalf("piece %d does not check", idx)
+		}
+		pc.Data = append([]byte(nil), pc.Data...)
+		pc.Data[0] ^= 0xff
+		if tf.CheckPiece(pc) {
+			t.Fatalf("corrupt piece %d checks", idx)
+		}
+	}
+
+	bad := makeV2Torrent(t, big, small)
+	for root, layer := range bad.PieceLayers {
+		layer[0] ^= 0xff
+		bad.PieceLayers[root] = layer
+	}
+	if err := bad.CheckPieceLayers(); err == nil {
+		t.Fatal("corrupt piece layer checks")
+	}
+}
+
+func TestInfohashV2(t *testing.T) {
+	tf := makeV2Torrent(t, bytes.Repeat([]byte{7}, 3*V2BlockSize), []byte("x"))
+	ih := tf.InfohashV2()
+	if ih != sha256.Sum256(tf.Info.Bytes()) {
+		t.Fatal("v2 infohash is not the sha256 of the info section")
+	}
+	if tf.Infohash() != ih.Truncated() {
+		t.Fatal("v2 only torrent does not use its truncated v2 infohash")
+	}
+
+	var buf bytes.Buffer
+	if err := tf.BEncode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(TorrentFile)
+	if err := loaded.BDecode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.InfohashV2() != ih {
+		t.Fatal("v2 infohash changed after loading")
+	}
+	if err := loaded.CheckPieceLayers(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Info.V2Files()) != 2 {
+		t.Fatalf("loaded %d files", len(loaded.Info.V2Files()))
+	}
+}
+
+func TestHybridTorrent(t *testing.T) {
+	big := bytes.Repeat([]byte{4, 5, 6}, (5*V2BlockSize)/3)
+	small := []byte("small file")
+	tf := makeV2Torrent(t, big, small)
+	tf.Info.Files = tf.Info.GetFiles()
+	// the v1 pieces are over the same padded layout
+	var data []byte
+	data = append(data, big...)
+	data = append(data, make([]byte, tf.Info.Files[1].Length)...)
+	data = append(data, small...)
+	for off := 0; off < len(data); off += testV2PieceLength {
+		end := off + testV2PieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha1.Sum(data[off:end])
+		tf.Info.Pieces = append(tf.Info.Pieces, h[:]...)
+	}
+	if !tf.Info.IsHybrid() {
+		t.Fatal("not a hybrid torrent")
+	}
+	if err := tf.CheckV2(); err != nil {
+		t.Fatal(err)
+	}
+	if tf.Infohash() == tf.InfohashV2().Truncated() {
+		t.Fatal("hybrid torrent uses its v2 infohash as its infohash")
+	}
+	for idx := uint32(0); idx < tf.Info.NumPieces(); idx++ {
+		off := idx * testV2PieceLength
+		pc := &common.PieceData{Index: idx, Data: data[off : off+tf.LengthOfPiece(idx)]}
+		if !tf.CheckPiece(pc) {
+			t.Fatalf("piece %d does not check", idx)
+		}
+	}
+
+	// a hybrid from a magnet gets its piece layers back from its data
+	layers := tf.PieceLayers
+	tf.PieceLayers = nil
+	made, err := tf.MakePieceLayers(func(idx uint32) ([]byte, error) {
+		off := idx * testV2PieceLength
+		return data[off : off+tf.LengthOfPiece(idx)], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(made) != len(layers) {
+		t.Fatalf("made %d piece layers, wanted %d", len(made), len(layers))
+	}
+	tf.PieceLayers = made
+	if err = tf.CheckPieceLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	// pieces have to match both the v1 and v2 hashes
+	for root, layer := range tf.PieceLayers {
+		layer = append([]byte(nil), layer...)
+		layer[0] ^= 0xff
+		tf.PieceLayers[root] = layer
+	}
+	if tf.CheckPiece(&common.PieceData{Index: 0, Data: data[:testV2PieceLength]}) {
+		t.Fatal("piece checks against a bad piece layer")
+	}
+
+	tf.Info.Files[0].Length--
+	if err := tf.CheckV2(); err != ErrHybridMismatch {
+		t.Fatalf("mismatched files: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package metainfo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits are the biggest torrents we accept
+type Limits struct {
+	// most files in a torrent
+	MaxFiles int
+	// most directories and file name a file path has
+	MaxPathDepth int
+	// most pieces in a torrent
+	MaxPieces uint32
+	// biggest piece length
+	MaxPieceLength uint32
+}
+
+// DefaultLimits are the limits used when none are set
+var DefaultLimits = Limits{
+	MaxFiles:       100000,
+	MaxPathDepth:   32,
+	MaxPieces:      1 << 22,
+	MaxPieceLength: 1 << 27,
+}
+
+// orDefault fills in limits that are not set with the default ones
+func (l Limits) orDefault() Limits {
+	if l.MaxFiles <= 0 {
+		l.MaxFiles = DefaultLimits.MaxFiles
+	}
+	if l.MaxPathDepth <= 0 {
+		l.MaxPathDepth = DefaultLimits.MaxPathDepth
+	}
+	if l.MaxPieces == 0 {
+		l.MaxPieces = DefaultLimits.MaxPieces
+	}
+	if l.MaxPieceLength == 0 {
+		l.MaxPieceLength = DefaultLimits.MaxPieceLength
+	}
+	return l
+}
+
+// why we refused a torrent
+const (
+	ReasonTooManyFiles  = "too_many_files"
+	ReasonPathTooDeep   = "path_too_deep"
+	ReasonTooManyPieces = "too_many_pieces"
+	ReasonBadPath       = "bad_path"
+	ReasonPieceLength   = "bad_piece_length"
+	ReasonPieceHashes   = "bad_piece_hashes"
+)
+
+// ValidationError is returned when a torrent is refused by Validate
+type ValidationError struct {
+	// one of the Reason constants
+	Reason string
+	// the offending file path, empty if it is not about a file
+	Path string
+	// what it has and the limit it went over, both 0 for bad paths
+	Value uint64
+	Limit uint64
+}
+
+func (e *ValidationError) Error() string {
+	switch e.Reason {
+	case ReasonTooManyFiles:
+		return fmt.Sprintf("torrent has %d files, at most %d are allowed", e.Value, e.Limit)
+	case ReasonPathTooDeep:
+		return fmt.Sprintf("%s is %d deep, at most %d is allowed", e.Path, e.Value, e.Limit)
+	case ReasonTooManyPieces:
+		return fmt.Sprintf("torrent has %d pieces, at most %d are allowed", e.Value, e.Limit)
+	case ReasonPieceLength:
+		return fmt.Sprintf("piece length %d is not between 1 and %d", e.Value, e.Limit)
+	case ReasonPieceHashes:
+		return fmt.Sprintf("piece hashes are %d bytes, not a multiple of 20", e.Value)
+	case ReasonBadPath:
+		return fmt.Sprintf("bad file path %q", e.Path)
+	}
+	return e.Reason
+}
+
+// returns true if name is safe to use as one part of a path on any platform we run on
+func safePathElement(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	if strings.ContainsAny(name, "/\\\x00") {
+		return false
+	}
+	// windows drive letters like c:
+	if len(name) >= 2 && name[1] == ':' {
+		return false
+	}
+	return true
+}
+
+// Validate checks the info section is within limits and has no file paths that would land outside of the torrent's directory
+func (i Info) Validate(l Limits) error {
+	l = l.orDefault()
+	if !safePathElement(i.Path) {
+		return &ValidationError{Reason: ReasonBadPath, Path: i.Path}
+	}
+	if i.PieceLength == 0 || i.PieceLength > l.MaxPieceLength {
+		return &ValidationError{Reason: ReasonPieceLength, Value: uint64(i.PieceLength), Limit: uint64(l.MaxPieceLength)}
+	}
+	if len(i.Pieces)%20 != 0 {
+		return &ValidationError{Reason: ReasonPieceHashes, Value: uint64(len(i.Pieces))}
+	}
+	if n := i.NumPieces(); n > l.MaxPieces {
+		return &ValidationError{Reason: ReasonTooManyPieces, Value: uint64(n), Limit: uint64(l.MaxPieces)}
+	}
+	files := i.GetFiles()
+	if len(files) > l.MaxFiles {
+		return &ValidationError{Reason: ReasonTooManyFiles, Value: uint64(len(files)), Limit: uint64(l.MaxFiles)}
+	}
+	for _, f := range files {
+		p := strings.Join(f.Path, "/")
+		if len(f.Path) == 0 {
+			return &ValidationError{Reason: ReasonBadPath, Path: p}
+		}
+		if len(f.Path) > l.MaxPathDepth {
+			return &ValidationError{Reason: ReasonPathTooDeep, Path: p, Value: uint64(len(f.Path)), Limit: uint64(l.MaxPathDepth)}
+		}
+		for _, name := range f.Path {
+			if !safePathElement(name) {
+				return &ValidationError{Reason: ReasonBadPath, Path: p}
+			}
+		}
+		if f.IsSymlink() {
+			// symlinks may only point inside of the torrent
+			if len(f.SymlinkPath) == 0 {
+				return &ValidationError{Reason: ReasonBadPath, Path: p}
+			}
+			for _, name := range f.SymlinkPath {
+				if !safePathElement(name) {
+					return &ValidationError{Reason: ReasonBadPath, Path: strings.Join(f.SymlinkPath, "/")}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the torrent is within limits and has no file paths that would land outside of the torrent's directory
+func (tf *TorrentFile) Validate(l Limits) error {
+	return tf.Info.Validate(l)
+}
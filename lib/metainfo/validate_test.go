@@ -0,0 +1,84 @@
+package metainfo
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	info := Info{
+		PieceLength: 16384,
+		Pieces:      make([]byte, 20*4),
+		Path:        "release",
+		Files: []FileInfo{
+			{Length: 1, Path: FilePath{"a", "b.txt"}},
+			{Length: 1, Path: FilePath{"c.txt"}},
+		},
+	}
+	if err := info.Validate(Limits{}); err != nil {
+		t.Fatal(err)
+	}
+	reason := func(info Info, l Limits) string {
+		err := info.Validate(l)
+		if err == nil {
+			return ""
+		}
+		return err.(*ValidationError).Reason
+	}
+	if r := reason(info, Limits{MaxFiles: 1}); r != ReasonTooManyFiles {
+		t.Errorf("too many files refused for %q", r)
+	}
+	if r := reason(info, Limits{MaxPathDepth: 1}); r != ReasonPathTooDeep {
+		t.Errorf("too deep path refused for %q", r)
+	}
+	if r := reason(info, Limits{MaxPieces: 3}); r != ReasonTooManyPieces {
+		t.Errorf("too many pieces refused for %q", r)
+	}
+	if r := reason(info, Limits{MaxPieceLength: 8192}); r != ReasonPieceLength {
+		t.Errorf("too long pieces refused for %q", r)
+	}
+	bad := info
+	bad.PieceLength = 0
+	if r := reason(bad, Limits{}); r != ReasonPieceLength {
+		t.Errorf("empty pieces refused for %q", r)
+	}
+	bad = info
+	bad.Pieces = make([]byte, 20*4+1)
+	if r := reason(bad, Limits{}); r != ReasonPieceHashes {
+		t.Errorf("torn piece hash refused for %q", r)
+	}
+	for _, bad := range []FilePath{
+		{"..", "etc", "passwd"},
+		{"a", "..", "..", "b"},
+		{"/etc/passwd"},
+		{"a\\..\\b"},
+		{"C:", "windows"},
+		{"a", ""},
+		{},
+	} {
+		info.Files[1].Path = bad
+		if r := reason(info, Limits{}); r != ReasonBadPath {
+			t.Errorf("%q refused for %q", bad, r)
+		}
+	}
+	info.Files[1].Path = FilePath{"c.txt"}
+	link := info
+	link.Files = []FileInfo{info.Files[0], {Path: FilePath{"link"}, Attr: "l", SymlinkPath: FilePath{"a", "b.txt"}}}
+	if err := link.Validate(Limits{}); err != nil {
+		t.Fatalf("symlink inside the torrent refused: %s", err)
+	}
+	for _, bad := range []FilePath{
+		{"..", "etc", "passwd"},
+		{"a", ""},
+		{},
+		nil,
+	} {
+		link.Files[1].SymlinkPath = bad
+		if r := reason(link, Limits{}); r != ReasonBadPath {
+			t.Errorf("symlink to %q refused for %q", bad, r)
+		}
+	}
+	info.Path = ".."
+	if r := reason(info, Limits{}); r != ReasonBadPath {
+		t.Errorf("torrent named .. refused for %q", r)
+	}
+}
@@ -0,0 +1,70 @@
+package metainfo
+
+import (
+	"github.com/zeebo/bencode"
+	"net/url"
+	"strings"
+)
+
+// URLList is a torrent's bep 19 web seed urls, torrents have it as either one string or a list of them
+type URLList []string
+
+func (l *URLList) UnmarshalBencode(raw []byte) (err error) {
+	var urls []string
+	err = bencode.DecodeBytes(raw, &urls)
+	if err != nil {
+		var u string
+		err = bencode.DecodeBytes(raw, &u)
+		if err == nil && u != "" {
+			urls = []string{u}
+		}
+	}
+	if err == nil {
+		*l = urls
+	}
+	return
+}
+
+// returns true if u is an http or https url
+func isHTTPURL(u string) bool {
+	lower := strings.ToLower(u)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// WebSeeds gets the http and https bep 19 web seed urls of this torrent
+func (tf *TorrentFile) WebSeeds() (urls []string) {
+	for _, u := range tf.URLList {
+		if isHTTPURL(u) {
+			urls = append(urls, u)
+		}
+	}
+	return
+}
+
+// GetHTTPSeeds gets the http and https bep 17 http seed urls of this torrent
+func (tf *TorrentFile) GetHTTPSeeds() (urls []string) {
+	for _, u := range tf.HTTPSeeds {
+		if isHTTPURL(u) {
+			urls = append(urls, u)
+		}
+	}
+	return
+}
+
+// WebSeedFileURL gets the url a web seed serves a file of this torrent at
+func (tf *TorrentFile) WebSeedFileURL(seed string, f FileInfo) string {
+	if tf.IsSingleFile() {
+		if strings.HasSuffix(seed, "/") {
+			return seed + url.PathEscape(tf.Info.Path)
+		}
+		return seed
+	}
+	if !strings.HasSuffix(seed, "/") {
+		seed += "/"
+	}
+	parts := []string{url.PathEscape(tf.Info.Path)}
+	for _, name := range f.Path {
+		parts = append(parts, url.PathEscape(name))
+	}
+	return seed + strings.Join(parts, "/")
+}
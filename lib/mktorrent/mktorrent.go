@@ -1,12 +1,13 @@
 package mktorrent
 
 import (
-	"crypto/sha1"
 	"errors"
 	"github.com/majestrate/XD/lib/fs"
+	"github.com/majestrate/XD/lib/hashing"
 	"github.com/majestrate/XD/lib/metainfo"
 	"io"
 	"path/filepath"
+	"time"
 )
 
 func mkTorrentSingle(f fs.Driver, fpath string, pieceLength uint32) (*metainfo.TorrentFile, error) {
@@ -22,14 +23,17 @@ func mkTorrentSingle(f fs.Driver, fpath string, pieceLength uint32) (*metainfo.T
 	buff := make([]byte, info.PieceLength)
 	for {
 		n, err := io.ReadFull(r, buff)
-		if err == io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			// the file ended on a piece boundary
+			break
+		} else if err == io.ErrUnexpectedEOF {
 			err = nil
-			d := sha1.Sum(buff[0:n])
+			d := hashing.SHA1(buff[0:n])
 			info.Pieces = append(info.Pieces, d[:]...)
 			info.Length += uint64(n)
 			break
 		} else if err == nil {
-			d := sha1.Sum(buff)
+			d := hashing.SHA1(buff)
 			info.Pieces = append(info.Pieces, d[:]...)
 			info.Length += uint64(n)
 		} else {
@@ -47,12 +51,22 @@ func mkTorrentDir(f fs.Driver, fpath string, pieceLength uint32) (*metainfo.Torr
 }
 
 func MakeTorrent(f fs.Driver, fpath string, pieceLength uint32) (*metainfo.TorrentFile, error) {
+	return MakeTorrentWith(f, fpath, Options{PieceLength: pieceLength})
+}
+
+// MakeTorrentWith makes a torrent of the file at fpath with the metainfo in opts
+func MakeTorrentWith(f fs.Driver, fpath string, opts Options) (tf *metainfo.TorrentFile, err error) {
 	st, err := f.Stat(fpath)
 	if err != nil {
 		return nil, err
 	}
 	if st.IsDir() {
-		return mkTorrentDir(f, fpath, pieceLength)
+		tf, err = mkTorrentDir(f, fpath, opts.PieceLength)
+	} else {
+		tf, err = mkTorrentSingle(f, fpath, opts.PieceLength)
+	}
+	if err == nil {
+		opts.apply(tf, time.Now())
 	}
-	return mkTorrentSingle(f, fpath, pieceLength)
+	return
 }
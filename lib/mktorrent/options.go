@@ -0,0 +1,141 @@
+package mktorrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/version"
+	"github.com/zeebo/bencode"
+	"time"
+)
+
+// Options are what goes in a torrent we make besides its files
+type Options struct {
+	PieceLength uint32
+	// tiers of tracker urls
+	Trackers [][]string
+	Comment  string
+	// defaults to our version
+	CreatedBy string
+	// defaults to now, NoCreationDate leaves it out
+	CreationDate   time.Time
+	NoCreationDate bool
+	// source tag, the same files made with another source get another infohash so they can be cross seeded
+	Source  string
+	Private bool
+}
+
+// put the options in a torrent we made at now
+func (opts Options) apply(tf *metainfo.TorrentFile, now time.Time) {
+	tf.SetTrackers(opts.Trackers)
+	tf.Comment = []byte(opts.Comment)
+	tf.CreatedBy = []byte(opts.CreatedBy)
+	if opts.CreatedBy == "" {
+		tf.CreatedBy = []byte(version.Version())
+	}
+	tf.Created = 0
+	if !opts.NoCreationDate {
+		if opts.CreationDate.IsZero() {
+			opts.CreationDate = now
+		}
+		tf.Created = opts.CreationDate.Unix()
+	}
+	tf.Info.Source = opts.Source
+	tf.SetPrivate(opts.Private)
+}
+
+// Edit is a change to the metainfo of an existing torrent, nil fields are left as they are
+type Edit struct {
+	// tiers of tracker urls that replace the trackers
+	Trackers     [][]string
+	Comment      *string
+	CreatedBy    *string
+	CreationDate *time.Time
+	Source       *string
+	Private      *bool
+}
+
+// ErrNoInfo is returned when editing a torrent file without an info dictionary
+var ErrNoInfo = errors.New("torrent file has no info dictionary")
+
+// set key of a bencoded dictionary to v
+func putRaw(d map[string]bencode.RawMessage, key string, v interface{}) error {
+	raw, err := bencode.EncodeBytes(v)
+	if err == nil {
+		d[key] = raw
+	}
+	return err
+}
+
+// Apply makes the edit to the bencoded torrent file data, gets the edited torrent file and its infohash, rehashed is true if the infohash changed
+// the info dictionary is kept byte for byte unless the source or private flag changes, so keys we don't know don't change the infohash
+// changing the source or private flag makes it a new torrent that has to be seeded anew
+func (e Edit) Apply(torrent []byte) (edited []byte, ih common.Infohash, rehashed bool, err error) {
+	var top map[string]bencode.RawMessage
+	if err = bencode.DecodeBytes(torrent, &top); err != nil {
+		return
+	}
+	rawInfo, ok := top["info"]
+	if !ok {
+		err = ErrNoInfo
+		return
+	}
+	old := sha1.Sum(rawInfo)
+	if e.Source != nil || e.Private != nil {
+		var info map[string]bencode.RawMessage
+		if err = bencode.DecodeBytes(rawInfo, &info); err != nil {
+			return
+		}
+		if e.Source != nil {
+			delete(info, "source")
+			if *e.Source != "" {
+				err = putRaw(info, "source", *e.Source)
+			}
+		}
+		if e.Private != nil {
+			delete(info, "private")
+			if *e.Private && err == nil {
+				err = putRaw(info, "private", 1)
+			}
+		}
+		if err == nil {
+			rawInfo, err = bencode.EncodeBytes(info)
+		}
+		if err != nil {
+			return
+		}
+		top["info"] = rawInfo
+	}
+	if e.Trackers != nil {
+		var tf metainfo.TorrentFile
+		tf.SetTrackers(e.Trackers)
+		delete(top, "announce")
+		delete(top, "announce-list")
+		if tf.Announce != "" {
+			err = putRaw(top, "announce", tf.Announce)
+		}
+		if tf.AnnounceList != nil && err == nil {
+			err = putRaw(top, "announce-list", tf.AnnounceList)
+		}
+	}
+	if e.Comment != nil && err == nil {
+		err = putRaw(top, "comment", *e.Comment)
+	}
+	if e.CreatedBy != nil && err == nil {
+		err = putRaw(top, "created by", *e.CreatedBy)
+	}
+	if e.CreationDate != nil && err == nil {
+		delete(top, "creation date")
+		if !e.CreationDate.IsZero() {
+			err = putRaw(top, "creation date", e.CreationDate.Unix())
+		}
+	}
+	if err != nil {
+		return
+	}
+	edited, err = bencode.EncodeBytes(top)
+	ih = common.Infohash(sha1.Sum(rawInfo))
+	rehashed = ih != common.Infohash(old)
+	return
+}
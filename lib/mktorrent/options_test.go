@@ -0,0 +1,78 @@
+package mktorrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/metainfo"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	var tf metainfo.TorrentFile
+	now := time.Unix(1500000000, 0)
+	Options{
+		Trackers: [][]string{{"http://a.i2p/a", "http://b.i2p/a"}, {"http://c.i2p/a"}},
+		Comment:  "hi",
+		Source:   "XYZ",
+		Private:  true,
+	}.apply(&tf, now)
+	if tf.Announce != "http://a.i2p/a" || len(tf.AnnounceList) != 2 {
+		t.Fatalf("trackers %q %v", tf.Announce, tf.AnnounceList)
+	}
+	if string(tf.Comment) != "hi" || len(tf.CreatedBy) == 0 || tf.Created != now.Unix() {
+		t.Fatalf("comment %q created by %q at %d", tf.Comment, tf.CreatedBy, tf.Created)
+	}
+	if tf.Info.Source != "XYZ" || !tf.IsPrivate() {
+		t.Fatal("source or private flag not set")
+	}
+	Options{NoCreationDate: true}.apply(&tf, now)
+	if tf.Created != 0 || tf.Announce != "" || tf.AnnounceList != nil {
+		t.Fatal("kept a creation date or trackers we didn't ask for")
+	}
+}
+
+func TestEditRehashes(t *testing.T) {
+	// an info key we don't know must not change the infohash when we edit the rest
+	torrent := []byte("d8:announce14:http://a.i2p/a4:infod6:lengthi1e4:name4:test12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaa7:unknown3:fooee")
+	orig := sha1.Sum([]byte("d6:lengthi1e4:name4:test12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaa7:unknown3:fooe"))
+	comment := "retagged"
+	edited, ih, rehashed, err := (Edit{Comment: &comment, Trackers: [][]string{{"http://b.i2p/a"}}}).Apply(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rehashed || ih != common.Infohash(orig) {
+		t.Fatal("changing the comment and trackers changed the infohash")
+	}
+	var tf metainfo.TorrentFile
+	if err = tf.BDecode(bytes.NewReader(edited)); err != nil {
+		t.Fatal(err)
+	}
+	if string(tf.Comment) != comment || tf.Announce != "http://b.i2p/a" {
+		t.Fatal("edit not applied")
+	}
+	source := "XYZ"
+	edited, _, rehashed, err = (Edit{Source: &source}).Apply(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rehashed {
+		t.Fatal("changing the source kept the infohash")
+	}
+	if !bytes.Contains(edited, []byte("7:unknown3:foo")) {
+		t.Fatal("lost an info key we don't know")
+	}
+	private := true
+	edited, _, rehashed, err = (Edit{Private: &private}).Apply(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf = metainfo.TorrentFile{}
+	if err = tf.BDecode(bytes.NewReader(edited)); err != nil {
+		t.Fatal(err)
+	}
+	if !rehashed || !tf.IsPrivate() || tf.Info.Source != source {
+		t.Fatal("making it private kept the infohash")
+	}
+}
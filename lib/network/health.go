@@ -0,0 +1,34 @@
+package network
+
+import (
+	"time"
+)
+
+// SessionStats is how healthy a network session and the router behind it are
+type SessionStats struct {
+	// when these stats were last updated
+	Checked time.Time
+	// outbound connections attempted and failed since the previous check
+	Dials        uint64
+	DialFailures uint64
+	// round trip time to the router's control interface, 0 if it was unreachable
+	ControlLatency time.Duration
+	// router status string, empty if unknown
+	RouterStatus string
+	// fraction of tunnel builds that succeed between 0 and 1, negative if unknown
+	TunnelBuildSuccess float64
+	// number of tunnels the router participates in, negative if unknown
+	ParticipatingTunnels int
+	// router bandwidth in bytes per second, negative if unknown
+	InboundBandwidth  float64
+	OutboundBandwidth float64
+	// the router is struggling, we should back off
+	Struggling bool
+	// why we think the router is struggling
+	Reason string
+}
+
+// HealthReporter is a Network that can tell us how healthy it is
+type HealthReporter interface {
+	Stats() SessionStats
+}
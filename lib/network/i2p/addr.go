@@ -1,6 +1,7 @@
 package i2p
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"net"
 	"strings"
@@ -33,6 +34,20 @@ func I2PAddr(addr string) Addr {
 	}
 }
 
+// RandomAddr makes up a destination that no router has the keys of, for networks that only look like i2p
+func RandomAddr() Addr {
+	var dest [387]byte
+	rand.Read(dest[:])
+	return Addr{
+		addr: i2pB64enc.EncodeToString(dest[:]),
+	}
+}
+
+// Dest returns the full base64 destination without the port
+func (addr Addr) Dest() string {
+	return addr.addr
+}
+
 // compute base32 address
 func (addr Addr) Base32Addr() (b32 Base32Addr) {
 	a := []byte(addr.addr)
@@ -0,0 +1,213 @@
+package i2p
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHealthInterval is how often we check on the router
+const DefaultHealthInterval = time.Second * 30
+
+// DefaultControlPassword is the password i2p routers ship i2pcontrol with
+const DefaultControlPassword = "itoopie"
+
+// the router is struggling if the SAM bridge takes longer than this to answer
+const strugglingLatency = time.Second * 5
+
+// the router is struggling if more than 3 in 4 of at least this many dials fail
+const strugglingMinDials = 8
+
+// the router is struggling if fewer tunnel builds than this succeed
+const strugglingTunnelSuccess = 0.1
+
+// ControlSettings says how to reach a router's i2pcontrol json-rpc api
+type ControlSettings struct {
+	// url of the json-rpc endpoint, like https://127.0.0.1:7650/jsonrpc
+	URL      string
+	Password string
+}
+
+type controlClient struct {
+	settings ControlSettings
+	http     *http.Client
+	token    string
+	id       int
+}
+
+func newControlClient(settings ControlSettings) *controlClient {
+	return &controlClient{
+		settings: settings,
+		http: &http.Client{
+			Timeout: time.Second * 10,
+			Transport: &http.Transport{
+				// routers use a self signed certificate for i2pcontrol
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+func (c *controlClient) call(method string, params map[string]interface{}) (result map[string]interface{}, err error) {
+	c.id++
+	var body bytes.Buffer
+	err = json.NewEncoder(&body).Encode(map[string]interface{}{
+		"id":      c.id,
+		"method":  method,
+		"params":  params,
+		"jsonrpc": "2.0",
+	})
+	if err != nil {
+		return
+	}
+	var resp *http.Response
+	resp, err = c.http.Post(c.settings.URL, "application/json", &body)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		Result map[string]interface{} `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	if err == nil {
+		if reply.Error != nil {
+			err = fmt.Errorf("i2pcontrol %s: %s (%d)", method, reply.Error.Message, reply.Error.Code)
+		} else {
+			result = reply.Result
+		}
+	}
+	return
+}
+
+func (c *controlClient) authenticate() (err error) {
+	var result map[string]interface{}
+	result, err = c.call("Authenticate", map[string]interface{}{
+		"API":      1,
+		"Password": c.settings.Password,
+	})
+	if err == nil {
+		token, ok := result["Token"].(string)
+		if ok {
+			c.token = token
+		} else {
+			err = errors.New("i2pcontrol gave no token")
+		}
+	}
+	return
+}
+
+// fill in what the router tells us about itself
+func (c *controlClient) routerInfo(st *network.SessionStats) (err error) {
+	if c.token == "" {
+		err = c.authenticate()
+		if err != nil {
+			return
+		}
+	}
+	var result map[string]interface{}
+	result, err = c.call("RouterInfo", map[string]interface{}{
+		"Token":                                c.token,
+		"i2p.router.status":                    nil,
+		"i2p.router.net.bw.inbound.1s":         nil,
+		"i2p.router.net.bw.outbound.1s":        nil,
+		"i2p.router.net.tunnels.participating": nil,
+		"i2p.router.net.tunnels.successrate":   nil,
+	})
+	if err != nil {
+		// token may have expired, get a new one next time
+		c.token = ""
+		return
+	}
+	if status, ok := result["i2p.router.status"].(string); ok {
+		st.RouterStatus = status
+	}
+	if bw, ok := result["i2p.router.net.bw.inbound.1s"].(float64); ok {
+		st.InboundBandwidth = bw
+	}
+	if bw, ok := result["i2p.router.net.bw.outbound.1s"].(float64); ok {
+		st.OutboundBandwidth = bw
+	}
+	if n, ok := result["i2p.router.net.tunnels.participating"].(float64); ok {
+		st.ParticipatingTunnels = int(n)
+	}
+	// only i2pd reports this, as a percentage
+	if rate, ok := result["i2p.router.net.tunnels.successrate"].(float64); ok {
+		st.TunnelBuildSuccess = rate / 100
+	}
+	return
+}
+
+// Stats returns how healthy the router was at the last check
+func (s *samSession) Stats() (st network.SessionStats) {
+	s.statsMtx.Lock()
+	st = s.stats
+	s.statsMtx.Unlock()
+	return
+}
+
+// check on the router every DefaultHealthInterval until the session closes
+func (s *samSession) runHealthProbe() {
+	var control *controlClient
+	if s.control != nil && s.control.URL != "" {
+		control = newControlClient(*s.control)
+	}
+	for s.c != nil {
+		st := s.probe(control)
+		s.statsMtx.Lock()
+		was := s.stats.Struggling
+		s.stats = st
+		s.statsMtx.Unlock()
+		if st.Struggling && !was {
+			log.Warnf("i2p router is struggling: %s", st.Reason)
+		} else if was && !st.Struggling {
+			log.Info("i2p router recovered")
+		}
+		time.Sleep(DefaultHealthInterval)
+	}
+}
+
+func (s *samSession) probe(control *controlClient) (st network.SessionStats) {
+	st.Checked = time.Now()
+	st.TunnelBuildSuccess = -1
+	st.ParticipatingTunnels = -1
+	st.InboundBandwidth = -1
+	st.OutboundBandwidth = -1
+	st.Dials = atomic.SwapUint64(&s.dials, 0)
+	st.DialFailures = atomic.SwapUint64(&s.dialFails, 0)
+	started := time.Now()
+	_, err := Ping(s.addr, strugglingLatency*2)
+	if err == nil {
+		st.ControlLatency = time.Since(started)
+	}
+	if control != nil {
+		if e := control.routerInfo(&st); e != nil {
+			log.Debugf("i2pcontrol query failed: %s", e.Error())
+		}
+	}
+	if err != nil {
+		st.Struggling = true
+		st.Reason = fmt.Sprintf("SAM bridge unreachable: %s", err.Error())
+	} else if st.ControlLatency > strugglingLatency {
+		st.Struggling = true
+		st.Reason = fmt.Sprintf("SAM bridge took %s to answer", st.ControlLatency)
+	} else if st.Dials >= strugglingMinDials && st.DialFailures*4 > st.Dials*3 {
+		st.Struggling = true
+		st.Reason = fmt.Sprintf("%d of %d dials failed", st.DialFailures, st.Dials)
+	} else if st.TunnelBuildSuccess >= 0 && st.TunnelBuildSuccess < strugglingTunnelSuccess {
+		st.Struggling = true
+		st.Reason = fmt.Sprintf("only %.0f%% of tunnel builds succeed", st.TunnelBuildSuccess*100)
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package i2p
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/majestrate/XD/lib/sync"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNameCacheTTL is how long a cached name lookup is good for by default
+const DefaultNameCacheTTL = time.Hour * 24
+
+type nameCacheEntry struct {
+	dest    string
+	expires time.Time
+}
+
+// NameCache caches naming lookups so we don't ask the router for the same
+// destination every announce
+type NameCache struct {
+	fname   string
+	ttl     time.Duration
+	access  sync.Mutex
+	entries map[string]nameCacheEntry
+	loaded  bool
+}
+
+// NewNameCache creates a name cache that persists to fname, if fname is empty it is only kept in memory
+func NewNameCache(fname string, ttl time.Duration) *NameCache {
+	if ttl <= 0 {
+		ttl = DefaultNameCacheTTL
+	}
+	return &NameCache{
+		fname:   fname,
+		ttl:     ttl,
+		entries: make(map[string]nameCacheEntry),
+	}
+}
+
+// returns true if we should cache lookups of this name
+func cacheableName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".i2p")
+}
+
+// Get gets a cached destination for name if it is not expired
+func (c *NameCache) Get(name string) (a Addr, ok bool) {
+	if c == nil || !cacheableName(name) {
+		return
+	}
+	name = strings.ToLower(name)
+	c.access.Lock()
+	e, has := c.entries[name]
+	if has {
+		if time.Now().Before(e.expires) {
+			a = I2PAddr(e.dest)
+			ok = true
+		} else {
+			delete(c.entries, name)
+		}
+	}
+	c.access.Unlock()
+	return
+}
+
+// Put caches a destination for name
+func (c *NameCache) Put(name string, a Addr) {
+	if c == nil || !cacheableName(name) || a.addr == "" {
+		return
+	}
+	c.access.Lock()
+	c.entries[strings.ToLower(name)] = nameCacheEntry{
+		dest:    a.addr,
+		expires: time.Now().Add(c.ttl),
+	}
+	c.access.Unlock()
+}
+
+// Load loads unexpired entries from the filesystem, only does anything the first time it is called
+func (c *NameCache) Load() (err error) {
+	if c == nil || len(c.fname) == 0 {
+		return
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	var f *os.File
+	f, err = os.Open(c.fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		now := time.Now()
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 4096), 1024*64)
+		for sc.Scan() {
+			parts := strings.Fields(sc.Text())
+			if len(parts) != 3 {
+				continue
+			}
+			ts, e := strconv.ParseInt(parts[2], 10, 64)
+			if e != nil {
+				continue
+			}
+			expires := time.Unix(ts, 0)
+			if now.Before(expires) {
+				c.entries[parts[0]] = nameCacheEntry{
+					dest:    parts[1],
+					expires: expires,
+				}
+			}
+		}
+		err = sc.Err()
+		f.Close()
+	}
+	return
+}
+
+// Store saves unexpired entries to the filesystem
+func (c *NameCache) Store() (err error) {
+	if c == nil || len(c.fname) == 0 {
+		return
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	tmp := c.fname + ".tmp"
+	var f *os.File
+	f, err = os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err == nil {
+		now := time.Now()
+		w := bufio.NewWriter(f)
+		for name, e := range c.entries {
+			if now.Before(e.expires) {
+				fmt.Fprintf(w, "%s %s %d\n", name, e.dest, e.expires.Unix())
+			}
+		}
+		err = w.Flush()
+		f.Close()
+		if err == nil {
+			err = os.Rename(tmp, c.fname)
+		}
+	}
+	return
+}
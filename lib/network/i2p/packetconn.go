@@ -2,6 +2,7 @@ package i2p
 
 import (
 	"bytes"
+	"github.com/majestrate/XD/lib/network"
 	"net"
 	"time"
 )
@@ -17,10 +18,16 @@ type I2PPacketConn struct {
 	samaddr net.Addr
 	// sam version
 	version string
+	// id of the sam session we send with
+	id string
 }
 
 // implements net.PacketConn
 func (c *I2PPacketConn) ReadFrom(d []byte) (n int, from net.Addr, err error) {
+	if c.c == nil {
+		err = network.ErrNoDatagrams
+		return
+	}
 	var buff [65336]byte
 	for err == nil {
 		n, from, err = c.c.ReadFrom(buff[:])
@@ -34,14 +41,13 @@ func (c *I2PPacketConn) ReadFrom(d []byte) (n int, from net.Addr, err error) {
 				// drop silent because invalid format
 				continue
 			}
-			parts := bytes.SplitN(buff[:idx-1], []byte{' '}, 2)
-			if len(parts) < 2 {
+			// sender's destination then options we don't use
+			parts := bytes.Fields(buff[:idx])
+			if len(parts) == 0 {
 				// drop silent because invalid format
 				continue
 			}
-			parts = bytes.Split(parts[1], []byte{' '})
-
-			from = I2PAddr(string(parts[len(parts)-1]))
+			from = I2PAddr(string(parts[0]))
 			data := buff[idx+1 : n]
 			n -= 1 + idx
 			if len(d) < n {
@@ -58,12 +64,21 @@ func (c *I2PPacketConn) ReadFrom(d []byte) (n int, from net.Addr, err error) {
 
 // implements net.PacketConn
 func (c *I2PPacketConn) WriteTo(d []byte, to net.Addr) (n int, err error) {
-	tostr := c.version + " " + to.String()
+	if c.c == nil {
+		err = network.ErrNoDatagrams
+		return
+	}
+	dest := to.String()
+	if a, ok := to.(Addr); ok {
+		// sam wants the destination without a port
+		dest = a.addr
+	}
+	tostr := c.version + " " + c.id + " " + dest
 	tolen := len(tostr)
 	buff := make([]byte, len(d)+tolen+1)
 	copy(buff, tostr)
 	buff[tolen] = '\n'
-	copy(buff[:tolen+1], d)
+	copy(buff[tolen+1:], d)
 	n, err = c.c.WriteTo(buff, c.samaddr)
 	if err == nil {
 		n = len(d)
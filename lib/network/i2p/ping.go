@@ -0,0 +1,43 @@
+package i2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Ping connects to the SAM bridge at addr and does the HELLO handshake to check that the router is reachable.
+// returns the SAM version the router replied with.
+func Ping(addr string, timeout time.Duration) (version string, err error) {
+	var n net.Conn
+	n, err = net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return
+	}
+	defer n.Close()
+	n.SetDeadline(time.Now().Add(timeout))
+	_, err = fmt.Fprintf(n, "HELLO VERSION MIN=3.0 MAX=3.0\n")
+	if err != nil {
+		return
+	}
+	var line string
+	line, err = readLine(n, make([]byte, 1))
+	if err != nil {
+		return
+	}
+	line = strings.TrimSpace(line)
+	ok := false
+	for _, word := range strings.Fields(line) {
+		if strings.ToUpper(word) == "RESULT=OK" {
+			ok = true
+		} else if strings.HasPrefix(strings.ToUpper(word), "VERSION=") {
+			version = word[8:]
+		}
+	}
+	if !ok {
+		err = errors.New(line)
+	}
+	return
+}
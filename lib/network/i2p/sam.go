@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/sync"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,7 +33,18 @@ type samSession struct {
 	c          net.Conn
 	readbuf    [1]byte
 	lookup     chan *lookupReq
+	names      *NameCache
 	pktconn    I2PPacketConn
+	// control socket of the datagram session, nil if we have none
+	dgc     net.Conn
+	control *ControlSettings
+	// how long the router has to connect a stream, 0 waits forever
+	dialTimeout time.Duration
+	// dials attempted and failed since the last health probe, atomic
+	dials     uint64
+	dialFails uint64
+	statsMtx  sync.Mutex
+	stats     network.SessionStats
 }
 
 func (s *samSession) ReadFrom(d []byte) (n int, from net.Addr, err error) {
@@ -51,7 +66,14 @@ func (s *samSession) Close() error {
 		return nil
 	}
 	s.lookup <- nil
+	if err := s.names.Store(); err != nil {
+		log.Warnf("failed to store i2p name cache: %s", err.Error())
+	}
 	err := s.c.Close()
+	if s.dgc != nil {
+		s.dgc.Close()
+		s.dgc = nil
+	}
 	s.pktconn.Close()
 	s.c = nil
 	return err
@@ -109,10 +131,19 @@ func (s *samSession) OpenControlSocket() (n net.Conn, err error) {
 }
 
 func (s *samSession) DialI2P(addr Addr) (c net.Conn, err error) {
+	atomic.AddUint64(&s.dials, 1)
+	defer func() {
+		if err != nil {
+			atomic.AddUint64(&s.dialFails, 1)
+		}
+	}()
 	readbuf := make([]byte, 1)
 	var nc net.Conn
 	nc, err = s.OpenControlSocket()
 	if err == nil {
+		if s.dialTimeout > 0 {
+			nc.SetDeadline(time.Now().Add(s.dialTimeout))
+		}
 		// send connect
 		port := ""
 		if len(addr.port) > 0 {
@@ -142,6 +173,7 @@ func (s *samSession) DialI2P(addr Addr) (c net.Conn, err error) {
 				}
 				if upper == "RESULT=OK" {
 					// we are connected
+					nc.SetDeadline(time.Time{})
 					nc.(*net.TCPConn).SetNoDelay(false)
 					nc.(*net.TCPConn).SetWriteBuffer(2400)
 					nc.(*net.TCPConn).SetLinger(0)
@@ -160,6 +192,10 @@ func (s *samSession) DialI2P(addr Addr) (c net.Conn, err error) {
 	return
 }
 
+func (s *samSession) SetDialTimeout(timeout time.Duration) {
+	s.dialTimeout = timeout
+}
+
 func (s *samSession) Dial(n, a string) (c net.Conn, err error) {
 	var addr Addr
 	addr, err = s.LookupI2P(a)
@@ -175,6 +211,12 @@ func (s *samSession) LookupI2P(name string) (a Addr, err error) {
 	if err == nil {
 		name = n
 	}
+	var cached bool
+	a, cached = s.names.Get(name)
+	if cached {
+		a.port = port
+		return a, nil
+	}
 	req := lookupReq{
 		replyChnl: make(chan lookupResp),
 		name:      name,
@@ -183,6 +225,7 @@ func (s *samSession) LookupI2P(name string) (a Addr, err error) {
 	repl := <-req.replyChnl
 	a, err = repl.addr, repl.err
 	if err == nil {
+		s.names.Put(name, a)
 		a.port = port
 	}
 	return
@@ -200,40 +243,46 @@ func (s *samSession) runLookups() {
 		if c == nil {
 			return
 		}
-		_, err = fmt.Fprintf(c, "NAMING LOOKUP NAME=%s\n", req.name)
-		var line string
-		line, err = readLine(c, s.readbuf[:])
-		if err == nil {
-			// okay
-			sc := bufio.NewScanner(strings.NewReader(line))
-			sc.Split(bufio.ScanWords)
-			for sc.Scan() {
-				txt := sc.Text()
-				upper := strings.ToUpper(txt)
-				if upper == "NAMING" {
-					continue
-				}
-				if upper == "REPLY" {
-					continue
-				}
-				if upper == "RESULT=OK" {
-					continue
-				}
-				if strings.HasPrefix(upper, "NAME=") {
-					continue
-				}
-				if strings.HasPrefix(txt, "VALUE=") {
-					// we got it
-					resp.addr = I2PAddr(txt[6:])
-					break
-				}
-				resp.err = errors.New(line)
+		resp.addr, resp.err, err = namingLookup(c, s.readbuf[:], req.name)
+		req.replyChnl <- resp
+	}
+	return
+}
+
+// ask the router on control socket c what name is, err is set if c broke and lookupErr if the router didn't know
+func namingLookup(c net.Conn, readbuf []byte, name string) (a Addr, lookupErr, err error) {
+	_, err = fmt.Fprintf(c, "NAMING LOOKUP NAME=%s\n", name)
+	var line string
+	line, err = readLine(c, readbuf)
+	if err == nil {
+		// okay
+		sc := bufio.NewScanner(strings.NewReader(line))
+		sc.Split(bufio.ScanWords)
+		for sc.Scan() {
+			txt := sc.Text()
+			upper := strings.ToUpper(txt)
+			if upper == "NAMING" {
+				continue
+			}
+			if upper == "REPLY" {
+				continue
+			}
+			if upper == "RESULT=OK" {
+				continue
+			}
+			if strings.HasPrefix(upper, "NAME=") {
+				continue
+			}
+			if strings.HasPrefix(txt, "VALUE=") {
+				// we got it
+				a = I2PAddr(txt[6:])
 				break
 			}
-		} else {
-			resp.err = err
+			lookupErr = errors.New(line)
+			break
 		}
-		req.replyChnl <- resp
+	} else {
+		lookupErr = err
 	}
 	return
 }
@@ -277,7 +326,8 @@ func (s *samSession) udpAddr() (string, string, error) {
 	return "", "", errors.New("unroutable address: " + host)
 }
 
-func (s *samSession) createSession(style string) (err error) {
+// create a sam session on control socket c, datagrams get forwarded to pktconn
+func (s *samSession) createSession(c net.Conn, style, id, dest string) (err error) {
 	// try opening if this session isn't already open
 	optsstr := " inbound.name=XD"
 	if s.opts != nil {
@@ -307,13 +357,15 @@ func (s *samSession) createSession(style string) (err error) {
 			return err
 		}
 		optsstr += fmt.Sprintf(" HOST=%s PORT=%s", host, port)
+		s.pktconn.id = id
+		s.pktconn.version = s.minversion
 	}
 
-	_, err = fmt.Fprintf(s.c, "SESSION CREATE STYLE=%s ID=%s SIGNATURE_TYPE=%d DESTINATION=%s%s\n", style, s.Name(), SigType, s.keys.privkey, optsstr)
+	_, err = fmt.Fprintf(c, "SESSION CREATE STYLE=%s ID=%s SIGNATURE_TYPE=%d DESTINATION=%s%s\n", style, id, SigType, dest, optsstr)
 	if err == nil {
 		// read response line
 		var line string
-		line, err = readLine(s.c, s.readbuf[:])
+		line, err = readLine(c, make([]byte, 1))
 		if err == nil {
 			// parse response line
 			sc := bufio.NewScanner(strings.NewReader(line))
@@ -339,19 +391,23 @@ func (s *samSession) createSession(style string) (err error) {
 }
 
 func (s *samSession) Open() (err error) {
+	if e := s.names.Load(); e != nil {
+		log.Warnf("failed to load i2p name cache: %s", e.Error())
+	}
 	s.c, err = s.OpenControlSocket()
 	if err == nil {
 		err = s.keys.ensure(s.c)
 	}
 	if err == nil {
-		err = s.createSession("STREAM")
+		err = s.createSession(s.c, "STREAM", s.Name(), s.keys.privkey)
 		if err == nil {
 			go s.runLookups()
+			go s.runHealthProbe()
 			var a Addr
 			a, err = s.LookupI2P("ME")
 			if err == nil {
 				s.keys.pubkey = a.String()
-				s.pktconn.laddr = a
+				s.openDatagrams()
 			}
 		}
 	}
@@ -361,6 +417,37 @@ func (s *samSession) Open() (err error) {
 	return
 }
 
+// open a datagram session on a destination of its own for the dht
+// streams work without it so we only log when it fails
+func (s *samSession) openDatagrams() {
+	c, err := s.OpenControlSocket()
+	if err == nil {
+		err = s.createSession(c, "DATAGRAM", s.Name()+"-dht", "TRANSIENT")
+		if err == nil {
+			var lookupErr error
+			s.pktconn.laddr, lookupErr, err = namingLookup(c, make([]byte, 1), "ME")
+			if err == nil {
+				err = lookupErr
+			}
+		}
+	}
+	if err != nil {
+		log.Warnf("failed to open i2p datagram session: %s", err.Error())
+		if c != nil {
+			c.Close()
+		}
+		s.pktconn.Close()
+		s.pktconn.c = nil
+		return
+	}
+	s.dgc = c
+}
+
+// PacketAddr implements network.PacketAddresser, datagrams come from their own destination
+func (s *samSession) PacketAddr() net.Addr {
+	return s.pktconn.laddr
+}
+
 func (s *samSession) Accept() (c net.Conn, err error) {
 	l := &i2pListener{
 		session: s,
@@ -1,9 +1,14 @@
 package i2p
 
 import (
+	"github.com/majestrate/XD/lib/network"
 	"net"
+	"time"
 )
 
+// DefaultDialTimeout is how long the router has to connect a stream to a destination
+const DefaultDialTimeout = time.Minute * 2
+
 // i2p network session
 type Session interface {
 
@@ -43,22 +48,32 @@ type Session interface {
 	// dial out to a remote destination
 	DialI2P(a Addr) (net.Conn, error)
 
+	// set how long the router has to connect our dials, 0 waits forever
+	SetDialTimeout(timeout time.Duration)
+
 	// open the session, generate keys, start up destination etc
 	Open() error
 
 	// close the session
 	Close() error
+
+	// implements network.HealthReporter
+	Stats() network.SessionStats
 }
 
-// create a new i2p session
-func NewSession(name, addr, keyfile string, opts map[string]string) Session {
+// create a new i2p session, names caches naming lookups and may be nil
+// control says how to ask the router about its health and may be nil
+func NewSession(name, addr, keyfile string, opts map[string]string, names *NameCache, control *ControlSettings) Session {
 	return &samSession{
-		name:       name,
-		addr:       addr,
-		minversion: "3.0",
-		maxversion: "3.0",
-		keys:       NewKeyfile(keyfile),
-		opts:       opts,
-		lookup:     make(chan *lookupReq, 18),
+		name:        name,
+		addr:        addr,
+		minversion:  "3.0",
+		maxversion:  "3.0",
+		keys:        NewKeyfile(keyfile),
+		dialTimeout: DefaultDialTimeout,
+		opts:        opts,
+		lookup:      make(chan *lookupReq, 18),
+		names:       names,
+		control:     control,
 	}
 }
@@ -3,6 +3,7 @@ package inet
 import (
 	"context"
 	"fmt"
+	"github.com/majestrate/XD/lib/network"
 	"net"
 	"strings"
 	"time"
@@ -80,6 +81,41 @@ func (s *Session) Dial(_, a string) (net.Conn, error) {
 	return s.wrapConn(c)
 }
 
+// DialPacket opens a udp socket to a host over the ip version we are bound to, implements network.PacketDialer
+func (s *Session) DialPacket(a string) (net.Conn, error) {
+	h, p, err := net.SplitHostPort(a)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if s.localIP.To4() == nil {
+		network = "udp6"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	ips, err := s.resolver.LookupIPAddr(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.LookupPort(network, p)
+	if err != nil {
+		return nil, err
+	}
+	laddr := &net.UDPAddr{
+		IP: s.localIP,
+	}
+	for _, ip := range ips {
+		if (ip.IP.To4() == nil) == (network == "udp6") {
+			raddr := &net.UDPAddr{
+				IP:   ip.IP,
+				Port: port,
+			}
+			return net.DialUDP(network, laddr, raddr)
+		}
+	}
+	return nil, fmt.Errorf("cannot resolve %s to an %s address", a, network)
+}
+
 func (s *Session) wrapConn(c net.Conn) (*Conn, error) {
 	raddr := c.RemoteAddr()
 	h, port, err := net.SplitHostPort(raddr.String())
@@ -219,11 +255,15 @@ func (s *Session) Open() error {
 	return nil
 }
 
+// ReadFrom implements network.Network, we don't exchange datagrams outside of DialPacket
 func (s *Session) ReadFrom(d []byte) (n int, from net.Addr, err error) {
+	err = network.ErrNoDatagrams
 	return
 }
 
+// WriteTo implements network.Network, we don't exchange datagrams outside of DialPacket
 func (s *Session) WriteTo(d []byte, to net.Addr) (n int, err error) {
+	err = network.ErrNoDatagrams
 	return
 }
 
@@ -0,0 +1,4 @@
+// in process network driver that connects swarms of the same process without a router
+//
+// sessions look like i2p destinations to the swarm so compact peers, pex and the dht work on it too
+package loopback
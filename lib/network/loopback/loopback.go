@@ -0,0 +1,266 @@
+package loopback
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"net"
+	"strings"
+	"sync"
+)
+
+// how many datagrams a session holds before we drop the ones sent to it
+const packetBacklog = 128
+
+// ErrClosed is returned by sessions that are not open
+var ErrClosed = errors.New("loopback session closed")
+
+// Hub connects the sessions made on it
+type Hub struct {
+	mtx      sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewHub makes a hub with no sessions on it
+func NewHub() *Hub {
+	return &Hub{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// NewSession makes a session on the hub called name, it gets a made up i2p destination
+func (h *Hub) NewSession(name string) *Session {
+	return &Session{
+		hub:  h,
+		name: name,
+		addr: i2p.RandomAddr(),
+	}
+}
+
+// Sessions gets the sessions that are open on the hub
+func (h *Hub) Sessions() (sessions []*Session) {
+	h.mtx.Lock()
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mtx.Unlock()
+	return
+}
+
+// find an open session by name, destination or b32 address, a port is ignored
+func (h *Hub) find(name string) *Session {
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if s, ok := h.sessions[name]; ok {
+		return s
+	}
+	for _, s := range h.sessions {
+		if name == s.dest() || strings.EqualFold(name, s.addr.Base32Addr().String()) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (h *Hub) open(s *Session) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if _, ok := h.sessions[s.name]; ok {
+		return fmt.Errorf("loopback session %s is already open", s.name)
+	}
+	h.sessions[s.name] = s
+	return nil
+}
+
+func (h *Hub) close(s *Session) {
+	h.mtx.Lock()
+	if h.sessions[s.name] == s {
+		delete(h.sessions, s.name)
+	}
+	h.mtx.Unlock()
+}
+
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+// Session is a network session on a hub
+// implements network.Network and network.PacketAddresser
+type Session struct {
+	hub  *Hub
+	name string
+	addr i2p.Addr
+
+	mtx     sync.Mutex
+	conns   chan net.Conn
+	packets chan packet
+	closed  chan struct{}
+}
+
+// Name gets the name the session has on the hub
+func (s *Session) Name() string {
+	return s.name
+}
+
+// our destination without a port
+func (s *Session) dest() string {
+	host, _, _ := net.SplitHostPort(s.addr.String())
+	return host
+}
+
+// get the channels of the open session, nil if it is not open
+func (s *Session) channels() (conns chan net.Conn, packets chan packet, closed chan struct{}) {
+	s.mtx.Lock()
+	conns, packets, closed = s.conns, s.packets, s.closed
+	s.mtx.Unlock()
+	return
+}
+
+// Open puts the session on the hub
+func (s *Session) Open() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed != nil {
+		return nil
+	}
+	if err := s.hub.open(s); err != nil {
+		return err
+	}
+	s.conns = make(chan net.Conn)
+	s.packets = make(chan packet, packetBacklog)
+	s.closed = make(chan struct{})
+	return nil
+}
+
+// Close takes the session off the hub, Accept and ReadFrom fail after it, connections made stay up
+func (s *Session) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed == nil {
+		return nil
+	}
+	s.hub.close(s)
+	close(s.closed)
+	s.conns = nil
+	s.packets = nil
+	s.closed = nil
+	return nil
+}
+
+// Addr gets our destination
+func (s *Session) Addr() net.Addr {
+	return s.addr
+}
+
+// PacketAddr gets where our datagrams come from, the same as Addr
+func (s *Session) PacketAddr() net.Addr {
+	return s.addr
+}
+
+// Lookup resolves the name, destination or b32 address of an open session on the hub
+func (s *Session) Lookup(name, port string) (net.Addr, error) {
+	other := s.hub.find(name)
+	if other == nil {
+		return nil, fmt.Errorf("no loopback session at %s", name)
+	}
+	if port == "" {
+		return other.addr, nil
+	}
+	return i2p.I2PAddr(net.JoinHostPort(other.dest(), port)), nil
+}
+
+// Dial connects to the session at address a over an in memory pipe
+func (s *Session) Dial(n, a string) (net.Conn, error) {
+	_, _, closed := s.channels()
+	if closed == nil {
+		return nil, ErrClosed
+	}
+	other := s.hub.find(a)
+	if other == nil {
+		return nil, fmt.Errorf("no loopback session at %s", a)
+	}
+	accept, _, otherClosed := other.channels()
+	if accept == nil {
+		return nil, fmt.Errorf("loopback session %s closed", other.name)
+	}
+	ours, theirs := net.Pipe()
+	select {
+	case accept <- &conn{Conn: theirs, laddr: other.addr, raddr: s.addr}:
+		return &conn{Conn: ours, laddr: s.addr, raddr: other.addr}, nil
+	case <-otherClosed:
+		ours.Close()
+		theirs.Close()
+		return nil, fmt.Errorf("loopback session %s closed", other.name)
+	}
+}
+
+// Accept waits for another session to dial us
+func (s *Session) Accept() (net.Conn, error) {
+	conns, _, closed := s.channels()
+	if closed == nil {
+		return nil, ErrClosed
+	}
+	select {
+	case c := <-conns:
+		return c, nil
+	case <-closed:
+		return nil, ErrClosed
+	}
+}
+
+// ReadFrom waits for a datagram another session sent us
+func (s *Session) ReadFrom(d []byte) (int, net.Addr, error) {
+	_, packets, closed := s.channels()
+	if closed == nil {
+		return 0, nil, ErrClosed
+	}
+	select {
+	case p := <-packets:
+		return copy(d, p.data), p.from, nil
+	case <-closed:
+		return 0, nil, ErrClosed
+	}
+}
+
+// WriteTo sends a datagram to the session at to, it is dropped if that session has too many waiting
+func (s *Session) WriteTo(d []byte, to net.Addr) (int, error) {
+	_, _, closed := s.channels()
+	if closed == nil {
+		return 0, ErrClosed
+	}
+	other := s.hub.find(to.String())
+	if other == nil {
+		return 0, fmt.Errorf("no loopback session at %s", to)
+	}
+	_, packets, _ := other.channels()
+	if packets != nil {
+		p := packet{
+			data: append([]byte(nil), d...),
+			from: s.addr,
+		}
+		select {
+		case packets <- p:
+		default:
+		}
+	}
+	return len(d), nil
+}
+
+// a pipe end that has the addresses of the sessions on it
+type conn struct {
+	net.Conn
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
@@ -0,0 +1,108 @@
+package loopback
+
+import (
+	"github.com/majestrate/XD/lib/network/i2p"
+	"io"
+	"testing"
+)
+
+func TestLoopbackConn(t *testing.T) {
+	hub := NewHub()
+	a := hub.NewSession("a")
+	b := hub.NewSession("b")
+	for _, s := range []*Session{a, b} {
+		if err := s.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+	}
+	if err := hub.NewSession("a").Open(); err == nil {
+		t.Fatal("opened a second session called a")
+	}
+	addr, err := a.Lookup(b.Addr().(i2p.Addr).Base32Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != b.Addr().String() {
+		t.Fatalf("b32 lookup gave %s not %s", addr, b.Addr())
+	}
+	done := make(chan error, 1)
+	go func() {
+		c, err := b.Accept()
+		if err == nil {
+			if c.RemoteAddr().String() != a.Addr().String() {
+				t.Errorf("inbound connection is from %s not %s", c.RemoteAddr(), a.Addr())
+			}
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(c, buf)
+			if err == nil {
+				_, err = c.Write(buf)
+			}
+			c.Close()
+		}
+		done <- err
+	}()
+	c, err := a.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("got %q back", buf)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if _, err = a.Dial("i2p", "b"); err == nil {
+		t.Fatal("dialed a closed session")
+	}
+	if _, err = b.Accept(); err != ErrClosed {
+		t.Fatalf("accept on a closed session gave %v", err)
+	}
+}
+
+func TestLoopbackDatagrams(t *testing.T) {
+	hub := NewHub()
+	a := hub.NewSession("a")
+	b := hub.NewSession("b")
+	if _, err := a.WriteTo([]byte("x"), b.Addr()); err != ErrClosed {
+		t.Fatalf("write before open gave %v", err)
+	}
+	a.Open()
+	defer a.Close()
+	b.Open()
+	defer b.Close()
+	msg := []byte("ping")
+	if _, err := a.WriteTo(msg, b.PacketAddr()); err != nil {
+		t.Fatal(err)
+	}
+	msg[0] = 'x'
+	buf := make([]byte, 16)
+	n, from, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("got %q", buf[:n])
+	}
+	if from.String() != a.Addr().String() {
+		t.Fatalf("datagram is from %s not %s", from, a.Addr())
+	}
+	// a full backlog drops datagrams instead of blocking
+	for i := 0; i < packetBacklog*2; i++ {
+		if _, err = a.WriteTo(msg, b.Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.packets) != packetBacklog {
+		t.Fatalf("%d datagrams waiting not %d", len(b.packets), packetBacklog)
+	}
+}
@@ -0,0 +1,21 @@
+package network
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrNoDatagrams is returned by ReadFrom and WriteTo on sessions that cannot exchange datagrams
+var ErrNoDatagrams = errors.New("network session cannot exchange datagrams")
+
+// PacketDialer is a Network that can exchange udp datagrams with hosts
+type PacketDialer interface {
+	// DialPacket opens a datagram socket to host:port, each read and write is one datagram
+	DialPacket(addr string) (net.Conn, error)
+}
+
+// PacketAddresser is a Network whose ReadFrom and WriteTo use another address than Addr
+type PacketAddresser interface {
+	// PacketAddr gets the address the datagrams we send come from
+	PacketAddr() net.Addr
+}
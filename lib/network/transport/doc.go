@@ -0,0 +1,2 @@
+// pluggable transports that wrap peer connections so they don't look like bittorrent on the wire
+package transport
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"net"
+)
+
+// obfs encrypts connections with a secret both sides know so nothing on the wire is plain,
+// it hides what we talk but does not authenticate the other side
+type obfs struct {
+	// keys for what clients and servers send
+	clientKey []byte
+	serverKey []byte
+}
+
+func newObfs(opts map[string]string) (Transport, error) {
+	secret, err := required(opts, "secret")
+	if err != nil {
+		return nil, err
+	}
+	client := sha256.Sum256([]byte("XD obfs client " + secret))
+	server := sha256.Sum256([]byte("XD obfs server " + secret))
+	return &obfs{
+		clientKey: client[:],
+		serverKey: server[:],
+	}, nil
+}
+
+// a connection encrypted with aes-ctr each way
+type obfsConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+func (c *obfsConn) Read(d []byte) (int, error) {
+	return c.r.Read(d)
+}
+
+func (c *obfsConn) Write(d []byte) (int, error) {
+	return c.w.Write(d)
+}
+
+// swap ivs with the other side, clients send theirs first,
+// each side encrypts what it sends with its own key and iv
+func (o *obfs) wrap(c net.Conn, sendKey, recvKey []byte, client bool) (net.Conn, error) {
+	ours := make([]byte, aes.BlockSize)
+	theirs := make([]byte, aes.BlockSize)
+	_, err := io.ReadFull(rand.Reader, ours)
+	if err == nil && client {
+		_, err = c.Write(ours)
+	}
+	if err == nil {
+		_, err = io.ReadFull(c, theirs)
+	}
+	if err == nil && !client {
+		_, err = c.Write(ours)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	send, _ := aes.NewCipher(sendKey)
+	recv, _ := aes.NewCipher(recvKey)
+	return &obfsConn{
+		Conn: c,
+		r:    &cipher.StreamReader{S: cipher.NewCTR(recv, theirs), R: c},
+		w:    &cipher.StreamWriter{S: cipher.NewCTR(send, ours), W: c},
+	}, nil
+}
+
+func (o *obfs) Client(c net.Conn) (net.Conn, error) {
+	return o.wrap(c, o.clientKey, o.serverKey, true)
+}
+
+func (o *obfs) Server(c net.Conn) (net.Conn, error) {
+	return o.wrap(c, o.serverKey, o.clientKey, false)
+}
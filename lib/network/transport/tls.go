@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrBadFingerprint is returned when the other side's certificate is not the one we pinned
+var ErrBadFingerprint = errors.New("peer certificate does not match the pinned fingerprint")
+
+// wraps connections in tls with our certificate, certificates are checked against a pinned
+// sha256 fingerprint if one is set instead of certificate authorities
+type tlsTransport struct {
+	cert tls.Certificate
+	// sha256 of the certificate the other side must have, nil to take any
+	fingerprint []byte
+}
+
+func newTLS(opts map[string]string) (Transport, error) {
+	certFile, err := required(opts, "cert")
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := required(opts, "key")
+	if err != nil {
+		return nil, err
+	}
+	t := new(tlsTransport)
+	t.cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if fp := opts["fingerprint"]; fp != "" {
+		t.fingerprint, err = hex.DecodeString(strings.Replace(fp, ":", "", -1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// check the other side's certificate is the pinned one
+func (t *tlsTransport) verify(raw [][]byte, _ [][]*x509.Certificate) error {
+	if t.fingerprint == nil {
+		return nil
+	}
+	if len(raw) == 0 {
+		return ErrBadFingerprint
+	}
+	h := sha256.Sum256(raw[0])
+	if !bytes.Equal(h[:], t.fingerprint) {
+		return ErrBadFingerprint
+	}
+	return nil
+}
+
+func (t *tlsTransport) config() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{t.cert},
+		// we pin fingerprints instead of trusting certificate authorities
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: t.verify,
+		ClientAuth:            tls.RequireAnyClientCert,
+		MinVersion:            tls.VersionTLS12,
+	}
+}
+
+func (t *tlsTransport) Client(c net.Conn) (net.Conn, error) {
+	tc := tls.Client(c, t.config())
+	err := tc.Handshake()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (t *tlsTransport) Server(c net.Conn) (net.Conn, error) {
+	tc := tls.Server(c, t.config())
+	err := tc.Handshake()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
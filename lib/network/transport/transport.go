@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/sync"
+	"net"
+	"sort"
+)
+
+// Transport wraps peer connections on both ends, both sides have to use the same transport with the same options
+type Transport interface {
+	// wrap a connection we dialed
+	Client(c net.Conn) (net.Conn, error)
+	// wrap a connection we accepted
+	Server(c net.Conn) (net.Conn, error)
+}
+
+// Factory makes a transport from its options
+type Factory func(opts map[string]string) (Transport, error)
+
+// ErrMissingOption is returned when a transport is missing an option it needs
+var ErrMissingOption = errors.New("transport option not set")
+
+var factories = struct {
+	access sync.Mutex
+	byName map[string]Factory
+}{
+	byName: make(map[string]Factory),
+}
+
+// Register adds a transport by name, replacing any transport with the same name
+func Register(name string, f Factory) {
+	factories.access.Lock()
+	factories.byName[name] = f
+	factories.access.Unlock()
+}
+
+// Names gets the names of all transports we have
+func Names() (names []string) {
+	factories.access.Lock()
+	for name := range factories.byName {
+		names = append(names, name)
+	}
+	factories.access.Unlock()
+	sort.Strings(names)
+	return
+}
+
+// New makes the transport called name with options
+func New(name string, opts map[string]string) (Transport, error) {
+	factories.access.Lock()
+	f, ok := factories.byName[name]
+	factories.access.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no such transport %q, we have %v", name, Names())
+	}
+	return f(opts)
+}
+
+// get an option that must be set
+func required(opts map[string]string, name string) (string, error) {
+	v := opts[name]
+	if v == "" {
+		return "", fmt.Errorf("%s: %s", ErrMissingOption, name)
+	}
+	return v, nil
+}
+
+func init() {
+	Register("obfs", newObfs)
+	Register("tls", newTLS)
+}
@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// connect over loopback tcp, net.Pipe has no buffering so both sides writing during a failed
+// tls handshake would block forever
+func loopback(t *testing.T) (a, b net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a, err = net.Dial("tcp", l.Addr().String())
+	if err == nil {
+		b, err = l.Accept()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// run a client and server over a connection and send a message each way
+func exchange(t *testing.T, client, server Transport) (got string, err error) {
+	a, b := loopback(t)
+	done := make(chan error, 1)
+	go func() {
+		c, e := server.Server(b)
+		if e == nil {
+			buf := make([]byte, 5)
+			_, e = io.ReadFull(c, buf)
+			if e == nil {
+				_, e = c.Write(buf)
+			}
+		}
+		b.Close()
+		done <- e
+	}()
+	c, err := client.Client(a)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	_, err = c.Write([]byte("hello"))
+	if err == nil {
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(c, buf)
+		got = string(buf)
+	}
+	if e := <-done; err == nil {
+		err = e
+	}
+	return
+}
+
+func TestObfs(t *testing.T) {
+	if _, err := New("obfs", nil); err == nil {
+		t.Fatal("made obfs without a secret")
+	}
+	if _, err := New("nope", nil); err == nil {
+		t.Fatal("made a transport that does not exist")
+	}
+	tr, err := New("obfs", map[string]string{"secret": "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := exchange(t, tr, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	other, _ := New("obfs", map[string]string{"secret": "other"})
+	got, _ = exchange(t, tr, other)
+	if got == "hello" {
+		t.Fatal("different secrets understood each other")
+	}
+}
+
+// make a self signed certificate and key in dir, gives their paths and the certificate's fingerprint
+func makeCert(t *testing.T, dir, name string) (certFile, keyFile, fingerprint string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(der)
+	fingerprint = hex.EncodeToString(h[:])
+	return
+}
+
+func newTestTLS(t *testing.T, cert, key, fingerprint string) Transport {
+	tr, err := New("tls", map[string]string{"cert": cert, "key": key, "fingerprint": fingerprint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New("tls", nil); err == nil {
+		t.Fatal("made tls without a certificate")
+	}
+	aCert, aKey, aFP := makeCert(t, dir, "a")
+	bCert, bKey, bFP := makeCert(t, dir, "b")
+	client := newTestTLS(t, aCert, aKey, bFP)
+	server := newTestTLS(t, bCert, bKey, aFP)
+	got, err := exchange(t, client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	// no pin takes any certificate
+	got, err = exchange(t, newTestTLS(t, aCert, aKey, ""), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestTLSFingerprintMismatch(t *testing.T) {
+	dir := t.TempDir()
+	aCert, aKey, aFP := makeCert(t, dir, "a")
+	bCert, bKey, _ := makeCert(t, dir, "b")
+	_, _, otherFP := makeCert(t, dir, "other")
+	// the client pins a certificate the server does not have
+	client := newTestTLS(t, aCert, aKey, otherFP)
+	server := newTestTLS(t, bCert, bKey, aFP)
+	_, err := exchange(t, client, server)
+	if !errors.Is(err, ErrBadFingerprint) {
+		t.Fatalf("client took the wrong certificate: %v", err)
+	}
+	// the server pins a certificate the client does not have
+	client = newTestTLS(t, aCert, aKey, "")
+	server = newTestTLS(t, bCert, bKey, otherFP)
+	_, err = exchange(t, client, server)
+	if err == nil {
+		t.Fatal("server took the wrong certificate")
+	}
+}
@@ -4,12 +4,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/dht"
+	"github.com/majestrate/XD/lib/hashing"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/stats"
+	"github.com/majestrate/XD/lib/storage"
 	t "github.com/majestrate/XD/lib/translate"
+	"github.com/majestrate/XD/lib/update"
+	"github.com/majestrate/XD/lib/version"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type Client struct {
@@ -24,6 +34,14 @@ func NewClient(url string, swarmno int) *Client {
 	}
 }
 
+// NewAutoClient makes a client that lets the server pick the swarm,
+// requests about a torrent go to the swarm that has it
+func NewAutoClient(url string) *Client {
+	return &Client{
+		url: url,
+	}
+}
+
 func (cl *Client) doRPC(r interface{}, h func(r io.Reader) error) (err error) {
 	var buf bytes.Buffer
 	err = json.NewEncoder(&buf).Encode(r)
@@ -46,15 +64,39 @@ func (cl *Client) doRPC(r interface{}, h func(r io.Reader) error) (err error) {
 		}
 		resp, err = httpcl.Post(reqURL, RPCContentType, &buf)
 		if err == nil {
-			err = h(resp.Body)
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
 			resp.Body.Close()
+			if err == nil {
+				err = responseError(body, h)
+			}
 		}
 	}
 	return
 }
 
+// give a failed response to callers as an *Error, h still gets it for any detail it wants
+func responseError(body []byte, h func(r io.Reader) error) error {
+	var response struct {
+		Error *string `json:"error"`
+		Code  string  `json:"code"`
+	}
+	if json.Unmarshal(body, &response) != nil || response.Error == nil {
+		return h(bytes.NewReader(body))
+	}
+	e := &Error{
+		Code:    response.Code,
+		Message: t.T(*response.Error),
+	}
+	if e.Code == "" {
+		e.Code = ErrCodeInternal
+	}
+	e.detail = h(bytes.NewReader(body))
+	return e
+}
+
 func (cl *Client) torrentAction(ih, action string) (err error) {
-	err = cl.doRPC(&ChangeTorrentRequest{BaseRequest{cl.swarmno}, ih, action}, func(r io.Reader) error {
+	err = cl.doRPC(&ChangeTorrentRequest{BaseRequest{Swarm: cl.swarmno}, ih, action}, func(r io.Reader) error {
 		var response map[string]interface{}
 		e := json.NewDecoder(r).Decode(&response)
 		if e == nil {
@@ -86,22 +128,527 @@ func (cl *Client) DeleteTorrent(ih string) error {
 	return cl.torrentAction(ih, TorrentChangeDelete)
 }
 
+func (cl *Client) SetSequential(ih string, on bool) error {
+	if on {
+		return cl.torrentAction(ih, TorrentChangeSequential)
+	}
+	return cl.torrentAction(ih, TorrentChangeRarestFirst)
+}
+
+// SetSuperseed sets if a torrent reveals pieces to peers one at a time while seeding
+func (cl *Client) SetSuperseed(ih string, on bool) error {
+	if on {
+		return cl.torrentAction(ih, TorrentChangeSuperseed)
+	}
+	return cl.torrentAction(ih, TorrentChangeNormalSeed)
+}
+
+// SetPriority sets how soon a torrent gets a turn to run and how much of the bandwidth limits it gets
+func (cl *Client) SetPriority(ih string, p swarm.TorrentPriority) error {
+	switch p {
+	case swarm.PriorityHigh:
+		return cl.torrentAction(ih, TorrentChangePriorityHigh)
+	case swarm.PriorityLow:
+		return cl.torrentAction(ih, TorrentChangePriorityLow)
+	case swarm.PriorityNormal:
+		return cl.torrentAction(ih, TorrentChangePriorityNormal)
+	}
+	return swarm.ErrBadPriority
+}
+
+// MoveInQueue moves a torrent waiting for a turn to run, where is one of the TorrentChangeQueue actions
+func (cl *Client) MoveInQueue(ih, where string) error {
+	switch where {
+	case TorrentChangeQueueUp, TorrentChangeQueueDown, TorrentChangeQueueTop, TorrentChangeQueueBottom:
+		return cl.torrentAction(ih, where)
+	}
+	return ErrInvalidAction
+}
+
+// SetForced sets if a torrent runs without waiting for a turn and without counting against the active torrents, forcing a stopped torrent starts it
+func (cl *Client) SetForced(ih string, on bool) error {
+	if on {
+		return cl.torrentAction(ih, TorrentChangeForceStart)
+	}
+	return cl.torrentAction(ih, TorrentChangeUnforce)
+}
+
+// Unpack unpacks a completed torrent again
+func (cl *Client) Unpack(ih string) error {
+	return cl.torrentAction(ih, TorrentChangeUnpack)
+}
+
+func (cl *Client) RestoreTorrent(ih string) (err error) {
+	err = cl.doRPC(&RestoreTorrentRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
+		var response map[string]interface{}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			emsg, has := response["error"]
+			if has && emsg != nil {
+				return fmt.Errorf("%s", t.T(fmt.Sprintf("%s", emsg)))
+			}
+		}
+		return e
+	})
+	return
+}
+
+// FindTorrent returns the numbers of all swarms that have a torrent
+func (cl *Client) FindTorrent(ih string) (swarms []int, err error) {
+	err = cl.doRPC(&FindTorrentRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
+		var response struct {
+			Error  *string `json:"error"`
+			Swarms []int   `json:"swarms"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			swarms = response.Swarms
+		}
+		return e
+	})
+	return
+}
+
+// IntegrityReport checks a torrent's data on disk and reports what is wrong with it without fixing anything
+func (cl *Client) IntegrityReport(ih string) (report storage.IntegrityReport, err error) {
+	err = cl.doRPC(&IntegrityReportRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
+		var response struct {
+			Error  *string                 `json:"error"`
+			Report storage.IntegrityReport `json:"report"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			report = response.Report
+		}
+		return e
+	})
+	return
+}
+
+// RecheckTorrent starts verifying a torrent's data, the bad pieces it finds are downloaded again
+func (cl *Client) RecheckTorrent(ih string) (err error) {
+	err = cl.doRPC(&RecheckTorrentRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil && response.Error != nil {
+			return fmt.Errorf("%s", t.T(*response.Error))
+		}
+		return e
+	})
+	return
+}
+
+// AuditLog gets the last n events of the audit log oldest first, all of them if n is 0
+func (cl *Client) AuditLog(n int) (events []audit.Event, err error) {
+	err = cl.doRPC(&AuditLogRequest{BaseRequest{Swarm: cl.swarmno}, n}, func(r io.Reader) error {
+		var response struct {
+			Error  *string       `json:"error"`
+			Events []audit.Event `json:"events"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			events = response.Events
+		}
+		return e
+	})
+	return
+}
+
+// DiskUsage gets how much disk each torrent takes, biggest first, and the totals per download directory
+func (cl *Client) DiskUsage() (torrents []swarm.TorrentDiskUsage, dirs []swarm.DirDiskUsage, err error) {
+	err = cl.doRPC(&DiskUsageRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error    *string                  `json:"error"`
+			Torrents []swarm.TorrentDiskUsage `json:"torrents"`
+			Dirs     []swarm.DirDiskUsage     `json:"dirs"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			torrents = response.Torrents
+			dirs = response.Dirs
+		}
+		return e
+	})
+	return
+}
+
+// DHTStatus gets the state of the dht and every node in its routing table
+func (cl *Client) DHTStatus() (st dht.Status, err error) {
+	err = cl.doRPC(&DHTStatusRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error *string    `json:"error"`
+			DHT   dht.Status `json:"dht"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			st = response.DHT
+		}
+		return e
+	})
+	return
+}
+
+// DHTPing asks the dht node at the i2p address addr if it is there, returns its node id and round trip time
+func (cl *Client) DHTPing(addr string) (id string, rtt time.Duration, err error) {
+	err = cl.doRPC(&DHTPingRequest{BaseRequest{Swarm: cl.swarmno}, addr}, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+			ID    string  `json:"id"`
+			RTT   int64   `json:"rtt"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			id = response.ID
+			rtt = time.Duration(response.RTT) * time.Millisecond
+		}
+		return e
+	})
+	return
+}
+
+// NetTest moves data to and from the XD at the i2p address addr for d each way, returns how fast it went
+func (cl *Client) NetTest(addr string, d time.Duration) (result swarm.NetTestResult, err error) {
+	err = cl.doRPC(&NetTestRequest{BaseRequest{Swarm: cl.swarmno}, addr, int(d / time.Second)}, func(r io.Reader) error {
+		var response struct {
+			Error   *string             `json:"error"`
+			NetTest swarm.NetTestResult `json:"nettest"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			result = response.NetTest
+		}
+		return e
+	})
+	return
+}
+
+// DHTGetPeers looks up peers of a torrent on the dht, returns their addresses and how many nodes answered
+func (cl *Client) DHTGetPeers(ih string) (peers []string, nodes int, err error) {
+	err = cl.doRPC(&DHTGetPeersRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
+		var response struct {
+			Error *string  `json:"error"`
+			Peers []string `json:"peers"`
+			Nodes int      `json:"nodes"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			peers = response.Peers
+			nodes = response.Nodes
+		}
+		return e
+	})
+	return
+}
+
+// PieceMask gets the piece ranges of a torrent we do not download
+func (cl *Client) PieceMask(ih string) (pieces string, err error) {
+	return cl.pieceMask(&PieceMaskRequest{BaseRequest{Swarm: cl.swarmno}, ih, nil})
+}
+
+// SetPieceMask sets the piece ranges like "0-9,20" of a torrent we do not download, empty clears it
+func (cl *Client) SetPieceMask(ih, pieces string) (string, error) {
+	return cl.pieceMask(&PieceMaskRequest{BaseRequest{Swarm: cl.swarmno}, ih, &pieces})
+}
+
+func (cl *Client) pieceMask(req *PieceMaskRequest) (pieces string, err error) {
+	err = cl.doRPC(req, func(r io.Reader) error {
+		var response struct {
+			Error  *string `json:"error"`
+			Pieces string  `json:"pieces"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			pieces = response.Pieces
+		}
+		return e
+	})
+	return
+}
+
+// SkippedFiles gets the file index ranges of a torrent we do not download
+func (cl *Client) SkippedFiles(ih string) (files string, err error) {
+	return cl.skipFiles(&SkipFilesRequest{BaseRequest{Swarm: cl.swarmno}, ih, nil})
+}
+
+// SkipFiles sets the file index ranges like "0-2,5" of a torrent we do not download, empty downloads every file
+func (cl *Client) SkipFiles(ih, files string) (string, error) {
+	return cl.skipFiles(&SkipFilesRequest{BaseRequest{Swarm: cl.swarmno}, ih, &files})
+}
+
+func (cl *Client) skipFiles(req *SkipFilesRequest) (files string, err error) {
+	err = cl.doRPC(req, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+			Files string  `json:"files"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			files = response.Files
+		}
+		return e
+	})
+	return
+}
+
+// FilePriorities gets the file index ranges of a torrent that are high and low priority
+func (cl *Client) FilePriorities(ih string) (high, low string, err error) {
+	return cl.filePriority(&FilePriorityRequest{BaseRequest{Swarm: cl.swarmno}, ih, nil, ""})
+}
+
+// SetFilePriority sets the priority of the file index ranges like "0-2,5" of a torrent, gets the ranges that are high and low priority after
+func (cl *Client) SetFilePriority(ih, files string, p swarm.TorrentPriority) (high, low string, err error) {
+	return cl.filePriority(&FilePriorityRequest{BaseRequest{Swarm: cl.swarmno}, ih, &files, p.String()})
+}
+
+func (cl *Client) filePriority(req *FilePriorityRequest) (high, low string, err error) {
+	err = cl.doRPC(req, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+			High  string  `json:"high"`
+			Low   string  `json:"low"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			high, low = response.High, response.Low
+		}
+		return e
+	})
+	return
+}
+
+// RatioLimit gets the share ratio limit of a torrent, isDefault is true if it has none of its own
+func (cl *Client) RatioLimit(ih string) (l swarm.RatioLimit, isDefault bool, err error) {
+	return cl.ratioLimit(&RatioLimitRequest{BaseRequest{Swarm: cl.swarmno}, ih, nil, ""})
+}
+
+// SetRatioLimit sets the share ratio a torrent stops or is removed at when seeding, nil goes back to the default
+func (cl *Client) SetRatioLimit(ih string, l *swarm.RatioLimit) (err error) {
+	ratio := float64(-1)
+	var action string
+	if l != nil {
+		ratio, action = l.Ratio, string(l.Action)
+	}
+	_, _, err = cl.ratioLimit(&RatioLimitRequest{BaseRequest{Swarm: cl.swarmno}, ih, &ratio, action})
+	return
+}
+
+func (cl *Client) ratioLimit(req *RatioLimitRequest) (l swarm.RatioLimit, isDefault bool, err error) {
+	err = cl.doRPC(req, func(r io.Reader) error {
+		var response struct {
+			Error   *string          `json:"error"`
+			Ratio   swarm.RatioLimit `json:"ratio"`
+			Default bool             `json:"default"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			l, isDefault = response.Ratio, response.Default
+		}
+		return e
+	})
+	return
+}
+
+// SetPieceDeadline asks for the piece ranges like "0-9,20" of a torrent within d so they are downloaded first, d <= 0 clears their deadline and empty pieces clears every deadline
+// returns how many pieces still have a deadline
+func (cl *Client) SetPieceDeadline(ih, pieces string, d time.Duration) (pending int, err error) {
+	req := &PieceDeadlineRequest{BaseRequest{Swarm: cl.swarmno}, ih, pieces, int64(d / time.Millisecond)}
+	err = cl.doRPC(req, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+			N     int     `json:"n"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			pending = response.N
+		}
+		return e
+	})
+	return
+}
+
+func (cl *Client) HashingStats() (st hashing.Stats, err error) {
+	err = cl.doRPC(&HashingStatsRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+			hashing.Stats
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			st = response.Stats
+		}
+		return e
+	})
+	return
+}
+
+func (cl *Client) StatsHistory(from, to time.Time) (snaps []stats.Snapshot, err error) {
+	err = cl.doRPC(&StatsHistoryRequest{BaseRequest{Swarm: cl.swarmno}, from.Unix(), to.Unix()}, func(r io.Reader) error {
+		var response struct {
+			Error     *string          `json:"error"`
+			Snapshots []stats.Snapshot `json:"snapshots"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			snaps = response.Snapshots
+		}
+		return e
+	})
+	return
+}
+
+func (cl *Client) GetLogs(n int) (logs Logs, err error) {
+	err = cl.doRPC(&GetLogsRequest{BaseRequest{Swarm: cl.swarmno}, n}, func(r io.Reader) error {
+		var response struct {
+			Error *string `json:"error"`
+			Logs
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			logs = response.Logs
+		}
+		return e
+	})
+	return
+}
+
+func (cl *Client) BoostLogLevel(level string, seconds int) (err error) {
+	err = cl.doRPC(&BoostLogLevelRequest{BaseRequest{Swarm: cl.swarmno}, level, seconds}, func(r io.Reader) error {
+		var response map[string]interface{}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			emsg, has := response["error"]
+			if has && emsg != nil {
+				return fmt.Errorf("%s", t.T(fmt.Sprintf("%s", emsg)))
+			}
+		}
+		return e
+	})
+	return
+}
+
 func (cl *Client) ListTorrents() (torrents swarm.TorrentsList, err error) {
-	err = cl.doRPC(&ListTorrentsRequest{BaseRequest{cl.swarmno}}, func(r io.Reader) error {
+	err = cl.doRPC(&ListTorrentsRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
 		return json.NewDecoder(r).Decode(&torrents)
 	})
 	return
 }
 
+// GetSwarmSummary gets a short status of every torrent, sorted by name
+func (cl *Client) GetSwarmSummary() (torrents []swarm.TorrentSummary, err error) {
+	err = cl.doRPC(&SwarmSummaryRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error    *string                `json:"error"`
+			Torrents []swarm.TorrentSummary `json:"torrents"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			torrents = response.Torrents
+		}
+		return e
+	})
+	return
+}
+
+// SwarmDebug gets internal counters of the swarm
+func (cl *Client) SwarmDebug() (st swarm.DebugStats, err error) {
+	err = cl.doRPC(&SwarmDebugRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error *string          `json:"error"`
+			Debug swarm.DebugStats `json:"debug"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			st = response.Debug
+		}
+		return e
+	})
+	return
+}
+
+// SwarmIdentity gets our peer id, address and what we advertise to peers
+func (cl *Client) SwarmIdentity() (id swarm.Identity, err error) {
+	err = cl.doRPC(&SwarmIdentityRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error    *string        `json:"error"`
+			Identity swarm.Identity `json:"identity"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			id = response.Identity
+		}
+		return e
+	})
+	return
+}
+
 func (cl *Client) GetSwarmStatus() (status swarm.SwarmStatus, err error) {
-	err = cl.doRPC(&ListTorrentStatusRequest{BaseRequest{cl.swarmno}}, func(r io.Reader) error {
+	err = cl.doRPC(&ListTorrentStatusRequest{BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
 		return json.NewDecoder(r).Decode(&status)
 	})
 	return
 }
 
 func (cl *Client) SetPieceWindow(n int) (err error) {
-	err = cl.doRPC(&SetPieceWindowRequest{BaseRequest{cl.swarmno}, n}, func(r io.Reader) error {
+	err = cl.doRPC(&SetPieceWindowRequest{BaseRequest{Swarm: cl.swarmno}, n}, func(r io.Reader) error {
 		var response interface{}
 		return json.NewDecoder(r).Decode(&response)
 	})
@@ -109,16 +656,113 @@ func (cl *Client) SetPieceWindow(n int) (err error) {
 }
 
 func (cl *Client) AddTorrent(url string) (err error) {
-	err = cl.doRPC(&AddTorrentRequest{BaseRequest{cl.swarmno}, url}, func(r io.Reader) error {
-		var response interface{}
-		return json.NewDecoder(r).Decode(&response)
+	return cl.AddTorrentTo(url, "")
+}
+
+// AddTorrentTo adds a torrent that downloads into dir
+func (cl *Client) AddTorrentTo(url, dir string) (err error) {
+	return cl.AddPinnedTorrent(url, dir, "", false)
+}
+
+// AddPinnedTorrent adds a torrent that downloads into dir and only runs on the network kind, any if empty,
+// and only in the swarm it was added to if pin is true
+func (cl *Client) AddPinnedTorrent(url, dir, network string, pin bool) (err error) {
+	err = cl.doRPC(&AddTorrentRequest{BaseRequest{Swarm: cl.swarmno}, url, dir, network, pin}, func(r io.Reader) error {
+		var response struct {
+			Error  *string `json:"error"`
+			Reason string  `json:"reason"`
+			Path   string  `json:"path"`
+			Value  uint64  `json:"value"`
+			Limit  uint64  `json:"limit"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil && response.Error != nil {
+			if response.Reason != "" {
+				return &metainfo.ValidationError{
+					Reason: response.Reason,
+					Path:   response.Path,
+					Value:  response.Value,
+					Limit:  response.Limit,
+				}
+			}
+			return fmt.Errorf("%s", *response.Error)
+		}
+		return e
 	})
 	return
 }
 
 func (cl *Client) SwarmStatus(ih string) (st swarm.TorrentStatus, err error) {
-	err = cl.doRPC(&TorrentStatusRequest{BaseRequest{cl.swarmno}, ih}, func(r io.Reader) error {
+	err = cl.doRPC(&TorrentStatusRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
 		return json.NewDecoder(r).Decode(&st)
 	})
 	return
 }
+
+// Version gets what build of XD the daemon runs and what it knows about updates
+func (cl *Client) Version() (info version.Info, st update.Status, err error) {
+	err = cl.doRPC(&VersionRequest{BaseRequest: BaseRequest{Swarm: cl.swarmno}}, func(r io.Reader) error {
+		var response struct {
+			Error   *string       `json:"error"`
+			Version version.Info  `json:"version"`
+			Update  update.Status `json:"update"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			info = response.Version
+			st = response.Update
+		}
+		return e
+	})
+	return
+}
+
+// ConnStats gets the scheduling state of the connections of the torrent with infohash ih, of every torrent if ih is empty
+func (cl *Client) ConnStats(ih string) (states []swarm.ConnState, err error) {
+	err = cl.doRPC(&ConnStatsRequest{BaseRequest{Swarm: cl.swarmno}, ih}, func(r io.Reader) error {
+		var response struct {
+			Error *string           `json:"error"`
+			Conns []swarm.ConnState `json:"conns"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			states = response.Conns
+		}
+		return e
+	})
+	return
+}
+
+// Bandwidth gets the bandwidth limits and when the alternative ones apply
+func (cl *Client) Bandwidth() (s swarm.BandwidthSettings, err error) {
+	return cl.bandwidth(&BandwidthRequest{BaseRequest: BaseRequest{Swarm: cl.swarmno}})
+}
+
+// SetBandwidth sets the bandwidth limits of every swarm and when the alternative ones apply, gets them as they apply now
+func (cl *Client) SetBandwidth(settings swarm.BandwidthSettings) (swarm.BandwidthSettings, error) {
+	return cl.bandwidth(&BandwidthRequest{BaseRequest{Swarm: cl.swarmno}, &settings})
+}
+
+func (cl *Client) bandwidth(req *BandwidthRequest) (s swarm.BandwidthSettings, err error) {
+	err = cl.doRPC(req, func(r io.Reader) error {
+		var response struct {
+			Error     *string                 `json:"error"`
+			Bandwidth swarm.BandwidthSettings `json:"bandwidth"`
+		}
+		e := json.NewDecoder(r).Decode(&response)
+		if e == nil {
+			if response.Error != nil {
+				return fmt.Errorf("%s", t.T(*response.Error))
+			}
+			s = response.Bandwidth
+		}
+		return e
+	})
+	return
+}
@@ -5,3 +5,34 @@ const ParamURL = "url"
 const ParamN = "n"
 const ParamAction = "action"
 const ParamSwarms = "swarms"
+const ParamDir = "dir"
+const ParamLevel = "level"
+const ParamDuration = "duration"
+const ParamFrom = "from"
+const ParamTo = "to"
+const ParamPieces = "pieces"
+const ParamEvents = "events"
+const ParamTorrents = "torrents"
+const ParamDirs = "dirs"
+const ParamDHT = "dht"
+const ParamAddr = "addr"
+const ParamID = "id"
+const ParamRTT = "rtt"
+const ParamPeers = "peers"
+const ParamNodes = "nodes"
+const ParamNetwork = "network"
+const ParamPin = "pin"
+const ParamIdentity = "identity"
+const ParamCode = "code"
+const ParamVersion = "version"
+const ParamUpdate = "update"
+const ParamConns = "conns"
+const ParamBandwidth = "bandwidth"
+const ParamReport = "report"
+const ParamFiles = "files"
+const ParamPriority = "priority"
+const ParamHigh = "high"
+const ParamLow = "low"
+const ParamNetTest = "nettest"
+const ParamRatio = "ratio"
+const ParamDefault = "default"
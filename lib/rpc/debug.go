@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// DebugPath is where pprof is served when enabled
+const DebugPath = "/debug/pprof/"
+
+// EnablePprof serves pprof under DebugPath to requests with these basic auth credentials
+// pprof stays off if either is empty
+func (r *Server) EnablePprof(username, password string) {
+	if username == "" || password == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DebugPath, pprof.Index)
+	mux.HandleFunc(DebugPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(DebugPath+"profile", pprof.Profile)
+	mux.HandleFunc(DebugPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(DebugPath+"trace", pprof.Trace)
+	r.pprof = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		u, p, ok := req.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="XD"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// returns true if this request is for pprof
+func isDebugRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, DebugPath)
+}
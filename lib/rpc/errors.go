@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/metainfo"
+	"github.com/majestrate/XD/lib/storage"
+	"github.com/majestrate/XD/lib/tracker"
+)
+
+// codes sent as ParamCode next to the message of a failed request,
+// clients should switch on these instead of matching the message which may change or be translated
+
+// ErrCodeInternal is for errors that have no code of their own
+const ErrCodeInternal = "internal"
+
+// ErrCodeBadRequest is for requests with missing or invalid parameters
+const ErrCodeBadRequest = "bad_request"
+
+// ErrCodeNoMethod is for requests for a method we don't have
+const ErrCodeNoMethod = "no_method"
+
+// ErrCodeNoSwarm is for requests for a swarm we don't have
+const ErrCodeNoSwarm = "no_swarm"
+
+// ErrCodeOffline is for requests to a swarm that has no network right now
+const ErrCodeOffline = "offline"
+
+// ErrCodePermissionDenied is for requests the user may not make
+const ErrCodePermissionDenied = "permission_denied"
+
+// ErrCodeNoTorrent is for requests about a torrent we don't have
+const ErrCodeNoTorrent = "no_torrent"
+
+// ErrCodeNoMetaInfo is for requests that need the metainfo of a torrent we don't have yet
+const ErrCodeNoMetaInfo = "no_metainfo"
+
+// ErrCodeInvalidTorrent is for torrents we refused, the response says why in "reason"
+const ErrCodeInvalidTorrent = "invalid_torrent"
+
+// ErrCodeDiskFull is for writes that failed because the disk ran out of space
+const ErrCodeDiskFull = "disk_full"
+
+// ErrCodeTrackerTimeout is for trackers that never replied
+const ErrCodeTrackerTimeout = "tracker_timeout"
+
+// ErrCodeBadHandshake is for peers that sent a bad bittorrent handshake
+const ErrCodeBadHandshake = "bad_handshake"
+
+// ErrCodePieceHashMismatch is for piece data that doesn't match its hash
+const ErrCodePieceHashMismatch = "piece_hash_mismatch"
+
+// the code of each error we know, wrapped errors get the code of what they wrap
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrNoTorrent, ErrCodeNoTorrent},
+	{ErrPermissionDenied, ErrCodePermissionDenied},
+	{ErrInvalidAction, ErrCodeBadRequest},
+	{common.ErrBadInfoHashLen, ErrCodeBadRequest},
+	{common.ErrBadMagnetURI, ErrCodeBadRequest},
+	{bittorrent.ErrBadPieceRange, ErrCodeBadRequest},
+	{swarm.ErrBadSortKey, ErrCodeBadRequest},
+	{swarm.ErrNoMetaInfo, ErrCodeNoMetaInfo},
+	{storage.ErrNoMetaInfo, ErrCodeNoMetaInfo},
+	{storage.ErrDiskFull, ErrCodeDiskFull},
+	{tracker.ErrTrackerTimeout, ErrCodeTrackerTimeout},
+	{bittorrent.ErrBadHandshake, ErrCodeBadHandshake},
+	{common.ErrPieceHashMismatch, ErrCodePieceHashMismatch},
+}
+
+// ErrorCode gets the code we send for err
+func ErrorCode(err error) string {
+	var verr *metainfo.ValidationError
+	if errors.As(err, &verr) {
+		return ErrCodeInvalidTorrent
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ErrCodeInternal
+}
+
+// Error is a request the server refused or failed
+type Error struct {
+	// one of the ErrCode constants, ErrCodeInternal if the server didn't send one
+	Code string
+	// what went wrong, translated
+	Message string
+	// anything more the method got out of the response, like a *metainfo.ValidationError for refused torrents
+	detail error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.detail
+}
@@ -11,3 +11,28 @@ const RPCDelTorrent = RPCName + ".DelTorrent"
 const RPCSetPieceWindow = RPCName + ".SetPieceWindow"
 const RPCChangeTorrent = RPCName + ".ChangeTorrent"
 const RPCSwarmCount = RPCName + ".SwarmCount"
+const RPCRestoreTorrent = RPCName + ".RestoreTorrent"
+const RPCHashingStats = RPCName + ".HashingStats"
+const RPCGetLogs = RPCName + ".GetLogs"
+const RPCBoostLogLevel = RPCName + ".BoostLogLevel"
+const RPCStatsHistory = RPCName + ".StatsHistory"
+const RPCFindTorrent = RPCName + ".FindTorrent"
+const RPCSwarmSummary = RPCName + ".SwarmSummary"
+const RPCSwarmDebug = RPCName + ".SwarmDebug"
+const RPCSwarmIdentity = RPCName + ".SwarmIdentity"
+const RPCPieceMask = RPCName + ".PieceMask"
+const RPCPieceDeadline = RPCName + ".PieceDeadline"
+const RPCRecheckTorrent = RPCName + ".RecheckTorrent"
+const RPCAuditLog = RPCName + ".AuditLog"
+const RPCDiskUsage = RPCName + ".DiskUsage"
+const RPCDHTStatus = RPCName + ".DHTStatus"
+const RPCDHTPing = RPCName + ".DHTPing"
+const RPCDHTGetPeers = RPCName + ".DHTGetPeers"
+const RPCVersion = RPCName + ".Version"
+const RPCConnStats = RPCName + ".ConnStats"
+const RPCBandwidth = RPCName + ".Bandwidth"
+const RPCIntegrityReport = RPCName + ".IntegrityReport"
+const RPCSkipFiles = RPCName + ".SkipFiles"
+const RPCFilePriority = RPCName + ".FilePriority"
+const RPCNetTest = RPCName + ".NetTest"
+const RPCRatioLimit = RPCName + ".RatioLimit"
@@ -6,6 +6,8 @@ import (
 
 type BaseRequest struct {
 	Swarm string `json:"-"`
+	// who the request logged in as, nil if the rpc needs no login
+	user *User
 }
 
 type Request interface {
@@ -7,19 +7,32 @@ import (
 
 type ResponseWriter struct {
 	w http.ResponseWriter
+	// set once we sent an error
+	failed bool
 }
 
 func (rw *ResponseWriter) SendJSON(obj interface{}) {
 	json.NewEncoder(rw.w).Encode(obj)
 }
 
-func (rw *ResponseWriter) SendError(msg string) {
+// SendError sends a failure with its message and one of the ErrCode constants
+func (rw *ResponseWriter) SendError(msg, code string) {
+	rw.failed = true
 	rw.SendJSON(map[string]string{
-		"error": msg,
+		"error":   msg,
+		ParamCode: code,
 	})
 }
 
+// ReturnError sends err as a failure with the code for it
+func (rw *ResponseWriter) ReturnError(err error) {
+	rw.SendError(err.Error(), ErrorCode(err))
+}
+
 func (rw *ResponseWriter) Return(obj interface{}) {
+	if m, ok := obj.(map[string]interface{}); ok && m["error"] != nil {
+		rw.failed = true
+	}
 	rw.SendJSON(obj)
 	/*
 		rw.SendJSON(map[string]interface{}{
@@ -2,28 +2,55 @@ package rpc
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/metainfo"
 )
 
 type AddTorrentRequest struct {
 	BaseRequest
 	URL string `json:"url"`
+	// download directory, uses the default if empty
+	Dir string `json:"dir,omitempty"`
+	// kind of network the torrent may only run on, any if empty
+	Network string `json:"network,omitempty"`
+	// only ever run the torrent in the swarm it was added to
+	Pin bool `json:"pin,omitempty"`
 }
 
 func (atr *AddTorrentRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
-	err := sw.AddRemoteTorrent(atr.URL)
+	err := sw.AddRemoteTorrentWith(atr.URL, swarm.AddOptions{
+		Dir:      atr.Dir,
+		Owner:    atr.userName(),
+		Network:  atr.Network,
+		PinSwarm: atr.Pin,
+	})
+	var verr *metainfo.ValidationError
 	if err == nil {
 		w.Return(map[string]interface{}{"error": nil})
+	} else if errors.As(err, &verr) {
+		// say why we refused the torrent so clients don't have to parse the message
+		w.Return(map[string]interface{}{
+			"error":   err.Error(),
+			ParamCode: ErrCodeInvalidTorrent,
+			"reason":  verr.Reason,
+			"path":    verr.Path,
+			"value":   verr.Value,
+			"limit":   verr.Limit,
+		})
 	} else {
-		w.Return(map[string]interface{}{"error": err.Error()})
+		w.ReturnError(err)
 	}
 }
 
 func (atr *AddTorrentRequest) MarshalJSON() (data []byte, err error) {
 	data, err = json.Marshal(map[string]interface{}{
-		ParamSwarm:  atr.Swarm,
-		ParamURL:    atr.URL,
-		ParamMethod: RPCAddTorrent,
+		ParamSwarm:   atr.Swarm,
+		ParamURL:     atr.URL,
+		ParamDir:     atr.Dir,
+		ParamNetwork: atr.Network,
+		ParamPin:     atr.Pin,
+		ParamMethod:  RPCAddTorrent,
 	})
 	return
 }
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/majestrate/XD/lib/audit"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+)
+
+// a request that can change something worth writing down in the audit log once it succeeds
+type auditedRequest interface {
+	// get the event to write down, false if this request did not change anything
+	auditEvent() (audit.Event, bool)
+}
+
+func (atr *AddTorrentRequest) auditEvent() (audit.Event, bool) {
+	return audit.Event{Action: audit.ActionAdded, Detail: atr.URL}, true
+}
+
+func (r *ChangeTorrentRequest) auditEvent() (audit.Event, bool) {
+	e := audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: r.Action}
+	switch r.Action {
+	case TorrentChangeRemove:
+		e.Action = audit.ActionRemoved
+		e.Detail = ""
+	case TorrentChangeDelete:
+		e.Action = audit.ActionDeleted
+		e.Detail = ""
+	}
+	return e, true
+}
+
+func (r *RestoreTorrentRequest) auditEvent() (audit.Event, bool) {
+	return audit.Event{Action: audit.ActionRestored, Infohash: r.Infohash}, true
+}
+
+func (r *RecheckTorrentRequest) auditEvent() (audit.Event, bool) {
+	return audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: "recheck"}, true
+}
+
+func (r *SkipFilesRequest) auditEvent() (audit.Event, bool) {
+	if r.Files == nil {
+		// only looked at the skipped files
+		return audit.Event{}, false
+	}
+	return audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: "skip files " + *r.Files}, true
+}
+
+func (r *FilePriorityRequest) auditEvent() (audit.Event, bool) {
+	if r.Files == nil {
+		// only looked at the priorities
+		return audit.Event{}, false
+	}
+	return audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: r.Priority + " priority files " + *r.Files}, true
+}
+
+func (r *RatioLimitRequest) auditEvent() (audit.Event, bool) {
+	if r.Ratio == nil {
+		// only looked at the limit
+		return audit.Event{}, false
+	}
+	if *r.Ratio < 0 {
+		return audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: "default share ratio limit"}, true
+	}
+	return audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: fmt.Sprintf("share ratio limit %g %s", *r.Ratio, r.Action)}, true
+}
+
+func (r *PieceMaskRequest) auditEvent() (audit.Event, bool) {
+	if r.Pieces == nil {
+		// only looked at the mask
+		return audit.Event{}, false
+	}
+	return audit.Event{Action: audit.ActionChanged, Infohash: r.Infohash, Detail: "mask " + *r.Pieces}, true
+}
+
+func (r *SetPieceWindowRequest) auditEvent() (audit.Event, bool) {
+	return audit.Event{Action: audit.ActionConfig, Detail: fmt.Sprintf("piece-window %d", r.N)}, true
+}
+
+func (r *BandwidthRequest) auditEvent() (audit.Event, bool) {
+	if r.Settings == nil {
+		// only looked at the limits
+		return audit.Event{}, false
+	}
+	s := r.Settings
+	return audit.Event{Action: audit.ActionConfig, Detail: fmt.Sprintf("bandwidth %s, alternative %s during %q, %s", s.Normal, s.Alt, s.Schedule, s.AltMode)}, true
+}
+
+func (r *BoostLogLevelRequest) auditEvent() (audit.Event, bool) {
+	return audit.Event{Action: audit.ActionConfig, Detail: fmt.Sprintf("log-level %s for %ds", r.Level, r.Duration)}, true
+}
+
+// AuditLogRequest gets the last events of the audit log
+type AuditLogRequest struct {
+	BaseRequest
+	// how many events, all of them if 0
+	N int `json:"n"`
+}
+
+func (r *AuditLogRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	events, err := sw.AuditLog(r.N)
+	if err == nil {
+		if events == nil {
+			events = []audit.Event{}
+		}
+		w.Return(map[string]interface{}{"error": nil, ParamEvents: events})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *AuditLogRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamMethod: RPCAuditLog,
+		ParamN:      r.N,
+	})
+	return
+}
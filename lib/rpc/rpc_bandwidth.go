@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+)
+
+// BandwidthRequest gets the bandwidth limits and when the alternative ones apply, and sets them if Settings is not nil
+// the limits are shared by every swarm
+type BandwidthRequest struct {
+	BaseRequest
+	Settings *swarm.BandwidthSettings `json:"bandwidth,omitempty"`
+}
+
+func (r *BandwidthRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	bw := sw.Torrents.Bandwidth
+	if r.Settings != nil {
+		if err := bw.Configure(*r.Settings); err != nil {
+			w.SendError(err.Error(), ErrCodeBadRequest)
+			return
+		}
+	}
+	w.Return(map[string]interface{}{
+		"error":        nil,
+		ParamBandwidth: bw.Settings(),
+	})
+}
+
+func (r *BandwidthRequest) MarshalJSON() (data []byte, err error) {
+	req := map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamMethod: RPCBandwidth,
+	}
+	if r.Settings != nil {
+		req[ParamBandwidth] = r.Settings
+	}
+	data, err = json.Marshal(req)
+	return
+}
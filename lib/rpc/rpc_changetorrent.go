@@ -5,12 +5,27 @@ import (
 	"errors"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
 	"github.com/majestrate/XD/lib/common"
+	"math"
 )
 
 const TorrentChangeStart = "start"
 const TorrentChangeStop = "stop"
 const TorrentChangeRemove = "remove"
 const TorrentChangeDelete = "delete"
+const TorrentChangeSequential = "sequential"
+const TorrentChangeRarestFirst = "rarest-first"
+const TorrentChangeUnpack = "unpack"
+const TorrentChangeSuperseed = "superseed"
+const TorrentChangeNormalSeed = "normal-seed"
+const TorrentChangePriorityHigh = "priority-high"
+const TorrentChangePriorityNormal = "priority-normal"
+const TorrentChangePriorityLow = "priority-low"
+const TorrentChangeQueueUp = "queue-up"
+const TorrentChangeQueueDown = "queue-down"
+const TorrentChangeQueueTop = "queue-top"
+const TorrentChangeQueueBottom = "queue-bottom"
+const TorrentChangeForceStart = "force-start"
+const TorrentChangeUnforce = "unforce"
 
 var ErrInvalidAction = errors.New("invalid torrent action")
 
@@ -38,6 +53,34 @@ func (r *ChangeTorrentRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter
 					err = t.Remove()
 				case TorrentChangeDelete:
 					err = t.Delete()
+				case TorrentChangeSequential:
+					t.SetSequential(true)
+				case TorrentChangeRarestFirst:
+					t.SetSequential(false)
+				case TorrentChangeSuperseed:
+					err = t.SetSuperseed(true)
+				case TorrentChangeNormalSeed:
+					err = t.SetSuperseed(false)
+				case TorrentChangePriorityHigh:
+					err = t.SetPriority(swarm.PriorityHigh)
+				case TorrentChangePriorityNormal:
+					err = t.SetPriority(swarm.PriorityNormal)
+				case TorrentChangePriorityLow:
+					err = t.SetPriority(swarm.PriorityLow)
+				case TorrentChangeQueueUp:
+					err = t.MoveInQueue(-1)
+				case TorrentChangeQueueDown:
+					err = t.MoveInQueue(1)
+				case TorrentChangeQueueTop:
+					err = t.MoveInQueue(math.MinInt32)
+				case TorrentChangeQueueBottom:
+					err = t.MoveInQueue(math.MaxInt32)
+				case TorrentChangeForceStart:
+					err = t.SetForced(true)
+				case TorrentChangeUnforce:
+					err = t.SetForced(false)
+				case TorrentChangeUnpack:
+					err = t.RetryUnpack()
 				default:
 					err = ErrInvalidAction
 				}
@@ -47,7 +90,7 @@ func (r *ChangeTorrentRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter
 	if err == nil {
 		w.Return(map[string]interface{}{"error": nil})
 	} else {
-		w.Return(map[string]interface{}{"error": err.Error()})
+		w.ReturnError(err)
 	}
 }
 
@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// ConnStatsRequest gets the scheduling state of the connections of one torrent, or of every torrent if Infohash is empty
+type ConnStatsRequest struct {
+	BaseRequest
+	Infohash string
+}
+
+func (r *ConnStatsRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	if r.Infohash == "" {
+		w.Return(map[string]interface{}{"error": nil, ParamConns: sw.ConnStates()})
+		return
+	}
+	var states []swarm.ConnState
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+			} else {
+				states = t.ConnStates()
+			}
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamConns: states})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *ConnStatsRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCConnStats,
+		ParamInfohash: r.Infohash,
+	})
+	return
+}
@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"time"
+)
+
+// DHTStatusRequest gets the state of the dht and every node in its routing table
+type DHTStatusRequest struct {
+	BaseRequest
+}
+
+func (r *DHTStatusRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	w.Return(map[string]interface{}{"error": nil, ParamDHT: sw.DHT().Status()})
+}
+
+func (r *DHTStatusRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamMethod: RPCDHTStatus,
+	})
+	return
+}
+
+// DHTPingRequest asks a dht node by its i2p address if it is there
+type DHTPingRequest struct {
+	BaseRequest
+	Addr string `json:"addr"`
+}
+
+func (r *DHTPingRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	id, rtt, err := sw.DHT().Ping(r.Addr)
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamID: id.Hex(), ParamRTT: rtt / time.Millisecond})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *DHTPingRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamAddr:   r.Addr,
+		ParamMethod: RPCDHTPing,
+	})
+	return
+}
+
+// DHTGetPeersRequest looks up peers of a torrent on the dht without announcing us
+type DHTGetPeersRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+}
+
+func (r *DHTGetPeersRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	var dests []i2p.Base32Addr
+	var nodes int
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		dests, nodes, err = sw.DHT().GetPeers(ih)
+	}
+	if err != nil {
+		w.ReturnError(err)
+		return
+	}
+	peers := []string{}
+	for _, dest := range dests {
+		peers = append(peers, dest.String())
+	}
+	w.Return(map[string]interface{}{"error": nil, ParamPeers: peers, ParamNodes: nodes})
+}
+
+func (r *DHTGetPeersRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamInfohash: r.Infohash,
+		ParamMethod:   RPCDHTGetPeers,
+	})
+	return
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"sort"
+)
+
+// DiskUsageRequest gets how much disk every torrent takes and the totals per download directory
+type DiskUsageRequest struct {
+	BaseRequest
+}
+
+func (r *DiskUsageRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	torrents := []swarm.TorrentDiskUsage{}
+	sw.Torrents.ForEachTorrent(func(t *swarm.Torrent) {
+		if r.canSee(t) {
+			torrents = append(torrents, t.DiskUsage())
+		}
+	})
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].Used > torrents[j].Used
+	})
+	dirs := swarm.SumDiskUsage(torrents)
+	if dirs == nil {
+		dirs = []swarm.DirDiskUsage{}
+	}
+	w.Return(map[string]interface{}{"error": nil, ParamTorrents: torrents, ParamDirs: dirs})
+}
+
+func (r *DiskUsageRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamMethod: RPCDiskUsage,
+	})
+	return
+}
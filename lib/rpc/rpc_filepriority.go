@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// FilePriorityRequest gets or sets how soon the files of a torrent are downloaded next to each other
+type FilePriorityRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+	// file index ranges like "0-2,5" to set the priority of, nil to only get the priorities
+	Files *string `json:"files,omitempty"`
+	// high, normal or low
+	Priority string `json:"priority,omitempty"`
+}
+
+func (r *FilePriorityRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	var high, low string
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+				return
+			}
+			if !t.Ready() {
+				err = swarm.ErrNoMetaInfo
+				return
+			}
+			n := uint32(len(t.MetaInfo().Info.GetFiles()))
+			if r.Files != nil {
+				var p swarm.TorrentPriority
+				p, err = swarm.ParsePriority(r.Priority)
+				if err != nil {
+					return
+				}
+				var files *bittorrent.Bitfield
+				files, err = bittorrent.ParsePieceRanges(*r.Files, n)
+				if err != nil {
+					return
+				}
+				var idxs []int
+				files.ForEachSet(func(idx uint32) {
+					idxs = append(idxs, int(idx))
+				})
+				if err = t.SetFilePriority(idxs, p); err != nil {
+					return
+				}
+			}
+			highFiles := bittorrent.NewBitfield(n, nil)
+			lowFiles := bittorrent.NewBitfield(n, nil)
+			for idx, p := range t.FilePriorities() {
+				if idx >= int(n) {
+					continue
+				}
+				if p == swarm.PriorityHigh {
+					highFiles.Set(uint32(idx))
+				} else if p == swarm.PriorityLow {
+					lowFiles.Set(uint32(idx))
+				}
+			}
+			high, low = highFiles.Ranges(), lowFiles.Ranges()
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamHigh: high, ParamLow: low})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *FilePriorityRequest) MarshalJSON() (data []byte, err error) {
+	m := map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCFilePriority,
+		ParamInfohash: r.Infohash,
+	}
+	if r.Files != nil {
+		m[ParamFiles] = *r.Files
+		m[ParamPriority] = r.Priority
+	}
+	data, err = json.Marshal(m)
+	return
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// FindTorrentRequest looks up which swarms have a torrent
+type FindTorrentRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+}
+
+func (r *FindTorrentRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		swarms := []int{}
+		if idx := sw.Index(); idx != nil {
+			swarms = append(swarms, idx.Lookup(ih)...)
+		} else if sw.Torrents.GetTorrent(ih) != nil {
+			swarms = append(swarms, sw.Number())
+		}
+		w.Return(map[string]interface{}{"error": nil, ParamSwarms: swarms})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *FindTorrentRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamInfohash: r.Infohash,
+		ParamMethod:   RPCFindTorrent,
+	})
+	return
+}
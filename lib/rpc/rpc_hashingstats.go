@@ -0,0 +1,23 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/hashing"
+)
+
+type HashingStatsRequest struct {
+	BaseRequest
+}
+
+func (r *HashingStatsRequest) ProcessRequest(_ *swarm.Swarm, w *ResponseWriter) {
+	w.Return(hashing.GetStats())
+}
+
+func (r *HashingStatsRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamMethod: RPCHashingStats,
+	})
+	return
+}
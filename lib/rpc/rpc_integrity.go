@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/storage"
+)
+
+// IntegrityReportRequest checks a torrent's data on disk and reports what is wrong with it without fixing anything
+type IntegrityReportRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+}
+
+func (r *IntegrityReportRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	var report storage.IntegrityReport
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+			} else {
+				report, err = t.IntegrityReport()
+			}
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamReport: report})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *IntegrityReportRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCIntegrityReport,
+		ParamInfohash: r.Infohash,
+	})
+	return
+}
@@ -12,6 +12,9 @@ type ListTorrentsRequest struct {
 func (ltr *ListTorrentsRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
 	var swarms swarm.TorrentsList
 	sw.Torrents.ForEachTorrent(func(t *swarm.Torrent) {
+		if !ltr.canSee(t) {
+			return
+		}
 		swarms.Infohashes = append(swarms.Infohashes, t.MetaInfo().Infohash().Hex())
 	})
 	w.Return(swarms)
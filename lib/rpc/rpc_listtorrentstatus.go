@@ -12,6 +12,9 @@ type ListTorrentStatusRequest struct {
 func (req *ListTorrentStatusRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
 	status := make(swarm.SwarmStatus)
 	sw.Torrents.ForEachTorrent(func(t *swarm.Torrent) {
+		if !req.canSee(t) {
+			return
+		}
 		status[t.Infohash().Hex()] = t.GetStatus()
 	})
 	w.Return(status)
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/log"
+	"time"
+)
+
+// MaxLogBoost is the longest we let the log level be raised for over rpc
+const MaxLogBoost = time.Hour
+
+// Logs is the recent log lines and the current log level
+type Logs struct {
+	Level string   `json:"level"`
+	Lines []string `json:"lines"`
+}
+
+type GetLogsRequest struct {
+	BaseRequest
+	// number of lines, all kept lines if <= 0
+	N int `json:"n"`
+}
+
+func (r *GetLogsRequest) ProcessRequest(_ *swarm.Swarm, w *ResponseWriter) {
+	w.Return(Logs{
+		Level: log.Level(),
+		Lines: log.Tail(r.N),
+	})
+}
+
+func (r *GetLogsRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamN:      r.N,
+		ParamMethod: RPCGetLogs,
+	})
+	return
+}
+
+type BoostLogLevelRequest struct {
+	BaseRequest
+	Level string `json:"level"`
+	// seconds to keep the level raised for
+	Duration int `json:"duration"`
+}
+
+func (r *BoostLogLevelRequest) ProcessRequest(_ *swarm.Swarm, w *ResponseWriter) {
+	d := time.Duration(r.Duration) * time.Second
+	if d <= 0 || d > MaxLogBoost {
+		d = MaxLogBoost
+	}
+	err := log.Boost(r.Level, d)
+	if err == nil {
+		log.Infof("log level raised to %s for %s over rpc", r.Level, d)
+		w.Return(map[string]interface{}{"error": nil})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *BoostLogLevelRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamLevel:    r.Level,
+		ParamDuration: r.Duration,
+		ParamMethod:   RPCBoostLogLevel,
+	})
+	return
+}
@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"time"
+)
+
+// NetTestRequest tests the network between us and another XD that agreed to it
+type NetTestRequest struct {
+	BaseRequest
+	Dest string `json:"addr"`
+	// seconds to move data each way, 0 for the default
+	Seconds int `json:"n"`
+}
+
+func (r *NetTestRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	result, err := sw.NetTest(r.Dest, time.Duration(r.Seconds)*time.Second)
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamNetTest: result})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *NetTestRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamAddr:   r.Dest,
+		ParamN:      r.Seconds,
+		ParamMethod: RPCNetTest,
+	})
+	return
+}
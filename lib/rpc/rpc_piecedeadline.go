@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+	"time"
+)
+
+// PieceDeadlineRequest asks for pieces of a torrent within a time so they are downloaded before any others, for streaming
+type PieceDeadlineRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+	// piece ranges like "0-9,20" to set the deadline of, empty clears every deadline
+	Pieces string `json:"pieces"`
+	// milliseconds from now we want the pieces by, 0 clears their deadline
+	Millis int64 `json:"n"`
+}
+
+func (r *PieceDeadlineRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	pending := 0
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+				return
+			}
+			if !t.Ready() {
+				err = swarm.ErrNoMetaInfo
+				return
+			}
+			if r.Pieces == "" {
+				t.ClearPieceDeadlines()
+				return
+			}
+			var pieces *bittorrent.Bitfield
+			pieces, err = bittorrent.ParsePieceRanges(r.Pieces, t.MetaInfo().Info.NumPieces())
+			if err != nil {
+				return
+			}
+			d := time.Duration(r.Millis) * time.Millisecond
+			pieces.ForEachSet(func(idx uint32) {
+				if err == nil {
+					err = t.SetPieceDeadline(idx, d)
+				}
+			})
+			pending = len(t.PieceDeadlines())
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamN: pending})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *PieceDeadlineRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCPieceDeadline,
+		ParamInfohash: r.Infohash,
+		ParamPieces:   r.Pieces,
+		ParamN:        r.Millis,
+	})
+	return
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// PieceMaskRequest gets or sets the pieces of a torrent we do not download
+type PieceMaskRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+	// piece ranges like "0-9,20" to mask, nil to only get the mask, empty to clear it
+	Pieces *string `json:"pieces,omitempty"`
+}
+
+func (r *PieceMaskRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	var pieces string
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+				return
+			}
+			if r.Pieces != nil {
+				if !t.Ready() {
+					err = swarm.ErrNoMetaInfo
+					return
+				}
+				var mask *bittorrent.Bitfield
+				mask, err = bittorrent.ParsePieceRanges(*r.Pieces, t.MetaInfo().Info.NumPieces())
+				if err == nil {
+					err = t.SetPieceMask(mask)
+				}
+			}
+			if mask := t.PieceMask(); mask != nil {
+				pieces = mask.Ranges()
+			}
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamPieces: pieces})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *PieceMaskRequest) MarshalJSON() (data []byte, err error) {
+	m := map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCPieceMask,
+		ParamInfohash: r.Infohash,
+	}
+	if r.Pieces != nil {
+		m[ParamPieces] = *r.Pieces
+	}
+	data, err = json.Marshal(m)
+	return
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// RatioLimitRequest gets or sets the share ratio a seeding torrent stops or is removed at
+type RatioLimitRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+	// share ratio to stop at, 0 never stops, negative goes back to the default, nil to only get the limit
+	Ratio *float64 `json:"ratio,omitempty"`
+	// stop or remove
+	Action string `json:"action,omitempty"`
+}
+
+func (r *RatioLimitRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	var limit swarm.RatioLimit
+	var isDefault bool
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+				return
+			}
+			if r.Ratio != nil {
+				if *r.Ratio < 0 {
+					err = t.SetRatioLimit(nil)
+				} else {
+					err = t.SetRatioLimit(&swarm.RatioLimit{Ratio: *r.Ratio, Action: swarm.RatioAction(r.Action)})
+				}
+				if err != nil {
+					return
+				}
+			}
+			limit, isDefault = t.RatioLimit()
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamRatio: limit, ParamDefault: isDefault})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *RatioLimitRequest) MarshalJSON() (data []byte, err error) {
+	m := map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCRatioLimit,
+		ParamInfohash: r.Infohash,
+	}
+	if r.Ratio != nil {
+		m[ParamRatio] = *r.Ratio
+		m[ParamAction] = r.Action
+	}
+	data, err = json.Marshal(m)
+	return
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// RecheckTorrentRequest starts verifying a torrent's data in the background, the bad pieces it finds are downloaded again
+type RecheckTorrentRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+}
+
+func (r *RecheckTorrentRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+			} else {
+				err = t.StartRecheck()
+			}
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *RecheckTorrentRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCRecheckTorrent,
+		ParamInfohash: r.Infohash,
+	})
+	return
+}
@@ -0,0 +1,33 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+type RestoreTorrentRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+}
+
+func (r *RestoreTorrentRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		err = sw.RestoreTorrent(ih)
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *RestoreTorrentRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamInfohash: r.Infohash,
+		ParamMethod:   RPCRestoreTorrent,
+	})
+	return
+}
@@ -7,15 +7,22 @@ import (
 
 type rpcError struct {
 	message string
+	code    string
+}
+
+// a request that fails with err
+func errorRequest(err error) *rpcError {
+	return &rpcError{message: err.Error(), code: ErrorCode(err)}
 }
 
 func (e *rpcError) MarshalJSON() (data []byte, err error) {
 	data, err = json.Marshal(map[string]string{
-		"error": e.message,
+		"error":   e.message,
+		ParamCode: e.code,
 	})
 	return
 }
 
 func (e *rpcError) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
-	w.SendError(e.message)
+	w.SendError(e.message, e.code)
 }
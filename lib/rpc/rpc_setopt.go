@@ -18,7 +18,7 @@ func (r *SetPieceWindowRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWrite
 		})
 		w.Return(map[string]interface{}{"error": nil})
 	} else {
-		w.SendError("N must be greater than zero")
+		w.SendError("N must be greater than zero", ErrCodeBadRequest)
 	}
 }
 
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+)
+
+// SkipFilesRequest gets or sets the files of a torrent we do not download
+type SkipFilesRequest struct {
+	BaseRequest
+	Infohash string `json:"infohash"`
+	// file index ranges like "0-2,5" to skip, nil to only get them, empty to download every file
+	Files *string `json:"files,omitempty"`
+}
+
+func (r *SkipFilesRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	var files string
+	ih, err := common.DecodeInfohash(r.Infohash)
+	if err == nil {
+		sw.Torrents.VisitTorrent(ih, func(t *swarm.Torrent) {
+			if t == nil {
+				err = ErrNoTorrent
+				return
+			}
+			if !t.Ready() {
+				err = swarm.ErrNoMetaInfo
+				return
+			}
+			n := uint32(len(t.MetaInfo().Info.GetFiles()))
+			if r.Files != nil {
+				var skip *bittorrent.Bitfield
+				skip, err = bittorrent.ParsePieceRanges(*r.Files, n)
+				if err != nil {
+					return
+				}
+				var idxs []int
+				skip.ForEachSet(func(idx uint32) {
+					idxs = append(idxs, int(idx))
+				})
+				if err = t.SetSkippedFiles(idxs); err != nil {
+					return
+				}
+			}
+			skipped := bittorrent.NewBitfield(n, nil)
+			for _, idx := range t.SkippedFiles() {
+				skipped.Set(uint32(idx))
+			}
+			files = skipped.Ranges()
+		})
+	}
+	if err == nil {
+		w.Return(map[string]interface{}{"error": nil, ParamFiles: files})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *SkipFilesRequest) MarshalJSON() (data []byte, err error) {
+	m := map[string]interface{}{
+		ParamSwarm:    r.Swarm,
+		ParamMethod:   RPCSkipFiles,
+		ParamInfohash: r.Infohash,
+	}
+	if r.Files != nil {
+		m[ParamFiles] = *r.Files
+	}
+	data, err = json.Marshal(m)
+	return
+}
@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/stats"
+	"time"
+)
+
+type StatsHistoryRequest struct {
+	BaseRequest
+	// unix timestamps in seconds, 0 for from means the last 24 hours and 0 for to means now
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+func (r *StatsHistoryRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	to := time.Now()
+	if r.To > 0 {
+		to = time.Unix(r.To, 0)
+	}
+	from := to.Add(-time.Hour * 24)
+	if r.From > 0 {
+		from = time.Unix(r.From, 0)
+	}
+	snaps, err := sw.StatsHistory(from, to)
+	if err == nil {
+		if snaps == nil {
+			snaps = []stats.Snapshot{}
+		}
+		w.Return(map[string]interface{}{"error": nil, "snapshots": snaps})
+	} else {
+		w.ReturnError(err)
+	}
+}
+
+func (r *StatsHistoryRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  r.Swarm,
+		ParamFrom:   r.From,
+		ParamTo:     r.To,
+		ParamMethod: RPCStatsHistory,
+	})
+	return
+}
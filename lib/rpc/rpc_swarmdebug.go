@@ -0,0 +1,23 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+)
+
+// SwarmDebugRequest gets internal queue depths and counters of a swarm for diagnosing stalls
+type SwarmDebugRequest struct {
+	BaseRequest
+}
+
+func (req *SwarmDebugRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	w.Return(map[string]interface{}{"error": nil, "debug": sw.DebugStats()})
+}
+
+func (req *SwarmDebugRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  req.Swarm,
+		ParamMethod: RPCSwarmDebug,
+	})
+	return
+}
@@ -0,0 +1,23 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+)
+
+// SwarmIdentityRequest gets our peer id, address and what we advertise to peers
+type SwarmIdentityRequest struct {
+	BaseRequest
+}
+
+func (req *SwarmIdentityRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	w.Return(map[string]interface{}{"error": nil, ParamIdentity: sw.Identity()})
+}
+
+func (req *SwarmIdentityRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  req.Swarm,
+		ParamMethod: RPCSwarmIdentity,
+	})
+	return
+}
@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/util"
+	"sort"
+)
+
+// SwarmSummaryRequest gets a short status of every torrent for rendering big lists
+// use TorrentStatusRequest for peer and file detail
+type SwarmSummaryRequest struct {
+	BaseRequest
+}
+
+func (req *SwarmSummaryRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter) {
+	torrents := []swarm.TorrentSummary{}
+	sw.Torrents.ForEachTorrent(func(t *swarm.Torrent) {
+		if !req.canSee(t) {
+			return
+		}
+		torrents = append(torrents, t.GetSummary())
+	})
+	sort.Slice(torrents, func(i, j int) bool {
+		return util.StringCompare(torrents[i].Name, torrents[j].Name) < 0
+	})
+	w.Return(map[string]interface{}{"error": nil, "torrents": torrents})
+}
+
+func (req *SwarmSummaryRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  req.Swarm,
+		ParamMethod: RPCSwarmSummary,
+	})
+	return
+}
@@ -28,7 +28,7 @@ func (r *TorrentStatusRequest) ProcessRequest(sw *swarm.Swarm, w *ResponseWriter
 	if err == nil {
 		w.Return(status)
 	} else {
-		w.SendError(err.Error())
+		w.ReturnError(err)
 	}
 }
 
@@ -0,0 +1,30 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/update"
+	"github.com/majestrate/XD/lib/version"
+)
+
+// VersionRequest gets what build of XD runs and if there is a newer release
+type VersionRequest struct {
+	BaseRequest
+	checker *update.Checker
+}
+
+func (req *VersionRequest) ProcessRequest(_ *swarm.Swarm, w *ResponseWriter) {
+	w.Return(map[string]interface{}{
+		"error":      nil,
+		ParamVersion: version.GetInfo(),
+		ParamUpdate:  req.checker.Status(),
+	})
+}
+
+func (req *VersionRequest) MarshalJSON() (data []byte, err error) {
+	data, err = json.Marshal(map[string]interface{}{
+		ParamSwarm:  req.Swarm,
+		ParamMethod: RPCVersion,
+	})
+	return
+}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/rpc/assets"
 	"github.com/majestrate/XD/lib/rpc/transmission"
+	"github.com/majestrate/XD/lib/update"
 	"net"
 	"net/http"
 	"strconv"
@@ -22,23 +25,33 @@ const RPCContentType = "text/json; encoding=UTF-8"
 // Bittorrent Swarm RPC Handler
 type Server struct {
 	sw           []*swarm.Swarm
+	index        *swarm.Index
 	fileserver   http.Handler
 	expectedHost string
 	trpc         http.Handler
+	// pprof handler, nil unless enabled
+	pprof http.Handler
+	// users who may log in by name, nil unless in multi-user mode
+	users map[string]User
+	// checks for new releases, nil if we don't
+	updates *update.Checker
 }
 
-func NewServer(sw []*swarm.Swarm, host string) *Server {
+func NewServer(index *swarm.Index, host string) *Server {
 	fs := assets.GetAssets()
+	sw := index.Swarms()
 	trpc := transmission.NewHandler(sw[0])
 	if fs == nil {
 		return &Server{
 			sw:           sw,
+			index:        index,
 			expectedHost: host,
 			trpc:         trpc,
 		}
 	} else {
 		return &Server{
 			sw:           sw,
+			index:        index,
 			expectedHost: host,
 			fileserver:   http.FileServer(fs),
 			trpc:         trpc,
@@ -46,6 +59,27 @@ func NewServer(sw []*swarm.Swarm, host string) *Server {
 	}
 }
 
+// SetUpdateChecker reports what c finds about new releases in the version rpc
+func (r *Server) SetUpdateChecker(c *update.Checker) {
+	r.updates = c
+}
+
+// pick the swarm for a request that didn't name one
+// requests about a torrent go to the swarm that has it, everything else goes to the first swarm
+func (r *Server) routeRequest(body map[string]interface{}) int {
+	str, ok := body[ParamInfohash].(string)
+	if ok {
+		ih, err := common.DecodeInfohash(str)
+		if err == nil {
+			_, num := r.index.Find(ih)
+			if num >= 0 {
+				return num
+			}
+		}
+	}
+	return 0
+}
+
 func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	if r.expectedHost != "" {
@@ -63,7 +97,23 @@ func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	if req.Method == "GET" && r.fileserver != nil {
+	var user *User
+	if r.users != nil && !isDebugRequest(req) {
+		user = r.authenticate(req)
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="XD"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if isDebugRequest(req) {
+		if r.pprof == nil {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			r.pprof.ServeHTTP(w, req)
+		}
+	} else if req.Method == "GET" && r.fileserver != nil {
 		r.fileserver.ServeHTTP(w, req)
 	} else if req.Method == "POST" {
 		if req.URL.Path == RPCPath {
@@ -79,8 +129,10 @@ func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 				method := body[ParamMethod]
 				swarmno, ok := body[ParamSwarm]
 				swarmidx := 0
-				if ok {
+				if ok && swarmno != "" {
 					swarmidx, err = strconv.Atoi(fmt.Sprintf("%s", swarmno))
+				} else {
+					swarmidx = r.routeRequest(body)
 				}
 				if err == nil {
 					switch method {
@@ -93,15 +145,49 @@ func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
 							Action:   fmt.Sprintf("%s", body[ParamAction]),
 						}
+					case RPCRestoreTorrent:
+						rr = &RestoreTorrentRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+					case RPCHashingStats:
+						rr = &HashingStatsRequest{}
+					case RPCStatsHistory:
+						from, _ := body[ParamFrom].(float64)
+						to, _ := body[ParamTo].(float64)
+						rr = &StatsHistoryRequest{
+							From: int64(from),
+							To:   int64(to),
+						}
+					case RPCGetLogs:
+						n, _ := body[ParamN].(float64)
+						rr = &GetLogsRequest{
+							N: int(n),
+						}
+					case RPCBoostLogLevel:
+						d, _ := body[ParamDuration].(float64)
+						rr = &BoostLogLevelRequest{
+							Level:    fmt.Sprintf("%s", body[ParamLevel]),
+							Duration: int(d),
+						}
 					case RPCListTorrents:
 						rr = &ListTorrentsRequest{}
+					case RPCFindTorrent:
+						rr = &FindTorrentRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
 					case RPCTorrentStatus:
 						rr = &TorrentStatusRequest{
 							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
 						}
 					case RPCAddTorrent:
+						dir, _ := body[ParamDir].(string)
+						network, _ := body[ParamNetwork].(string)
+						pin, _ := body[ParamPin].(bool)
 						rr = &AddTorrentRequest{
-							URL: fmt.Sprintf("%s", body[ParamURL]),
+							URL:     fmt.Sprintf("%s", body[ParamURL]),
+							Dir:     dir,
+							Network: network,
+							Pin:     pin,
 						}
 					case RPCSetPieceWindow:
 						n, ok := body[ParamN].(float64)
@@ -112,32 +198,156 @@ func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 						} else {
 							rr = &rpcError{
 								message: fmt.Sprintf("invalid value: %s", body[ParamN]),
+								code:    ErrCodeBadRequest,
 							}
 						}
 					case RPCListTorrentStatus:
 						rr = &ListTorrentStatusRequest{}
+					case RPCAuditLog:
+						n, _ := body[ParamN].(float64)
+						rr = &AuditLogRequest{
+							N: int(n),
+						}
+					case RPCRecheckTorrent:
+						rr = &RecheckTorrentRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+					case RPCIntegrityReport:
+						rr = &IntegrityReportRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+					case RPCPieceMask:
+						req := &PieceMaskRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+						if pieces, ok := body[ParamPieces].(string); ok {
+							req.Pieces = &pieces
+						}
+						rr = req
+					case RPCSkipFiles:
+						req := &SkipFilesRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+						if files, ok := body[ParamFiles].(string); ok {
+							req.Files = &files
+						}
+						rr = req
+					case RPCFilePriority:
+						req := &FilePriorityRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+						if files, ok := body[ParamFiles].(string); ok {
+							req.Files = &files
+							req.Priority, _ = body[ParamPriority].(string)
+						}
+						rr = req
+					case RPCRatioLimit:
+						req := &RatioLimitRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+						if ratio, ok := body[ParamRatio].(float64); ok {
+							req.Ratio = &ratio
+							req.Action, _ = body[ParamAction].(string)
+						}
+						rr = req
+					case RPCPieceDeadline:
+						pieces, _ := body[ParamPieces].(string)
+						millis, _ := body[ParamN].(float64)
+						rr = &PieceDeadlineRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+							Pieces:   pieces,
+							Millis:   int64(millis),
+						}
+					case RPCSwarmSummary:
+						rr = &SwarmSummaryRequest{}
+					case RPCDiskUsage:
+						rr = &DiskUsageRequest{}
+					case RPCDHTStatus:
+						rr = &DHTStatusRequest{}
+					case RPCDHTPing:
+						rr = &DHTPingRequest{
+							Addr: fmt.Sprintf("%s", body[ParamAddr]),
+						}
+					case RPCNetTest:
+						seconds, _ := body[ParamN].(float64)
+						rr = &NetTestRequest{
+							Dest:    fmt.Sprintf("%s", body[ParamAddr]),
+							Seconds: int(seconds),
+						}
+					case RPCDHTGetPeers:
+						rr = &DHTGetPeersRequest{
+							Infohash: fmt.Sprintf("%s", body[ParamInfohash]),
+						}
+					case RPCSwarmDebug:
+						rr = &SwarmDebugRequest{}
+					case RPCSwarmIdentity:
+						rr = &SwarmIdentityRequest{}
+					case RPCConnStats:
+						ih, _ := body[ParamInfohash].(string)
+						rr = &ConnStatsRequest{
+							Infohash: ih,
+						}
+					case RPCBandwidth:
+						req := &BandwidthRequest{}
+						if settings, ok := body[ParamBandwidth]; ok {
+							// decode it again into the settings it was encoded from
+							var data []byte
+							data, err = json.Marshal(settings)
+							if err == nil {
+								req.Settings = new(swarm.BandwidthSettings)
+								err = json.Unmarshal(data, req.Settings)
+							}
+						}
+						if err == nil {
+							rr = req
+						} else {
+							rr = &rpcError{
+								message: err.Error(),
+								code:    ErrCodeBadRequest,
+							}
+						}
+					case RPCVersion:
+						rr = &VersionRequest{
+							checker: r.updates,
+						}
 					default:
 						rr = &rpcError{
 							message: fmt.Sprintf("no such method %s", method),
+							code:    ErrCodeNoMethod,
 						}
 					}
 				} else {
 					rr = &rpcError{
 						message: err.Error(),
+						code:    ErrCodeBadRequest,
+					}
+				}
+				if user != nil {
+					rr = r.restrictRequest(user, fmt.Sprintf("%s", method), rr)
+					if ur, ok := rr.(interface{ setUser(*User) }); ok {
+						ur.setUser(user)
 					}
 				}
 				if swarmidx < len(r.sw) {
 					if r.sw[swarmidx].IsOnline() {
 						rr.ProcessRequest(r.sw[swarmidx], rw)
+						if ar, ok := rr.(auditedRequest); ok && !rw.failed {
+							if e, changed := ar.auditEvent(); changed {
+								e.Principal = audit.Principal(req)
+								r.sw[swarmidx].Audit(e)
+							}
+						}
 					} else {
 						rr = &rpcError{
 							message: "swarm offline",
+							code:    ErrCodeOffline,
 						}
 						rr.ProcessRequest(nil, rw)
 					}
 				} else {
 					rr = &rpcError{
 						message: "no such swarm",
+						code:    ErrCodeNoSwarm,
 					}
 					rr.ProcessRequest(nil, rw)
 				}
@@ -146,6 +356,11 @@ func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 		} else if req.URL.Path == transmission.RPCPath && r.trpc != nil {
+			if user != nil && !user.Admin {
+				// it shows every torrent
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
 			r.trpc.ServeHTTP(w, req)
 		} else {
 			w.WriteHeader(http.StatusNotFound)
@@ -3,6 +3,7 @@ package transmission
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/bittorrent/swarm"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/sync"
@@ -61,6 +62,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			resp = h(s.sw, req.Args)
 			if resp.Result != Success {
 				log.Warnf("trpc handler non success: %s", resp.Result)
+			} else if e, changed := auditEvent(req); changed {
+				e.Principal = audit.Principal(r)
+				s.sw.Audit(e)
 			}
 		}
 		resp.Tag = req.Tag
@@ -107,3 +111,41 @@ func NewHandler(sw *swarm.Swarm) http.Handler {
 		},
 	}
 }
+
+// what a request that changes something does, for the audit log
+var auditActions = map[string]string{
+	"torrent-start":        audit.ActionChanged,
+	"torrent-start-now":    audit.ActionChanged,
+	"torrent-stop":         audit.ActionChanged,
+	"torrent-verify":       audit.ActionChanged,
+	"torrent-reannounce":   audit.ActionChanged,
+	"torrent-set":          audit.ActionChanged,
+	"torrent-add":          audit.ActionAdded,
+	"torrent-remove":       audit.ActionRemoved,
+	"torrent-set-location": audit.ActionChanged,
+	"torrent-rename-path":  audit.ActionChanged,
+	"session-set":          audit.ActionConfig,
+	"blocklist-update":     audit.ActionConfig,
+	"queue-move-top":       audit.ActionChanged,
+	"queue-move-up":        audit.ActionChanged,
+	"queue-move-down":      audit.ActionChanged,
+	"queue-move-bottom":    audit.ActionChanged,
+}
+
+// get the audit log event of a request that succeeded, false if it did not change anything
+func auditEvent(req Request) (e audit.Event, changed bool) {
+	e.Action, changed = auditActions[req.Method]
+	if !changed {
+		return
+	}
+	if e.Action == audit.ActionRemoved {
+		if del, _ := req.Args["delete-local-data"].(bool); del {
+			e.Action = audit.ActionDeleted
+		}
+	}
+	e.Detail = "transmission " + req.Method
+	if ids, ok := req.Args["ids"]; ok {
+		e.Detail += fmt.Sprintf(" %v", ids)
+	}
+	return
+}
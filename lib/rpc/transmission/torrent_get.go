@@ -48,6 +48,8 @@ func tgStatus(f string, t *swarm.Torrent, resp *tgResp) (err error) {
 		trStatus = tr_Status_Seed
 	case swarm.Checking:
 		trStatus = tr_Status_Check
+	case swarm.Queued:
+		trStatus = tr_Status_DownloadWait
 	}
 	resp.Set(f, trStatus)
 	return
@@ -73,14 +75,26 @@ func tgZeroStr(f string, t *swarm.Torrent, resp *tgResp) (err error) {
 	return
 }
 
+// transmission uses 0 for things that never happened
+func unixTime(tm time.Time) int64 {
+	if tm.IsZero() {
+		return 0
+	}
+	return tm.Unix()
+}
+
 func tgActivityDate(f string, t *swarm.Torrent, resp *tgResp) (err error) {
-	// TODO: implement
-	resp.Set(f, time.Now().Unix())
+	resp.Set(f, unixTime(t.LastActive()))
 	return
 }
 
 func tgAddedDate(f string, t *swarm.Torrent, resp *tgResp) (err error) {
-	resp.Set(f, t.AddedAt().Unix())
+	resp.Set(f, unixTime(t.AddedAt()))
+	return
+}
+
+func tgDoneDate(f string, t *swarm.Torrent, resp *tgResp) (err error) {
+	resp.Set(f, unixTime(t.CompletedAt()))
 	return
 }
 
@@ -223,7 +237,7 @@ var tgFieldHandlers = map[string]tgFieldHandler{
 	"desiredAvailable":  tgBytesAvail,
 	"dowwloadLimit":     tgZeroInt, // TODO
 	"downloadLimited":   tgFalse,   // TODO
-	"doneDate":          tgZeroInt, // TODO
+	"doneDate":          tgDoneDate,
 	"downloadedEver":    tgZeroInt, // TODO
 	"eta":               tgZeroInt, // TODO
 	"etaIdle":           tgZeroInt, // TODO
@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/majestrate/XD/lib/bittorrent/swarm"
+	"github.com/majestrate/XD/lib/common"
+	"net/http"
+)
+
+// ErrPermissionDenied is sent when a user asks for something only admins may do
+var ErrPermissionDenied = errors.New("permission denied")
+
+// User is someone who may log in to the rpc in multi-user mode
+type User struct {
+	Name     string
+	Password string
+	// admins see and control every torrent and the swarm itself, other users only the torrents they added
+	Admin bool
+}
+
+// methods users that are not admins may call, anything else changes or shows the whole swarm
+var userMethods = map[string]bool{
+	RPCSwarmCount:        true,
+	RPCListTorrents:      true,
+	RPCListTorrentStatus: true,
+	RPCSwarmSummary:      true,
+	RPCDiskUsage:         true,
+	RPCTorrentStatus:     true,
+	RPCFindTorrent:       true,
+	RPCAddTorrent:        true,
+	RPCChangeTorrent:     true,
+	RPCRecheckTorrent:    true,
+	RPCIntegrityReport:   true,
+	RPCPieceMask:         true,
+	RPCSkipFiles:         true,
+	RPCFilePriority:      true,
+	RPCRatioLimit:        true,
+	RPCPieceDeadline:     true,
+	RPCVersion:           true,
+}
+
+// EnableUsers turns on multi-user mode, every request has to log in with basic auth as one of users
+// does nothing if users is empty
+func (r *Server) EnableUsers(users []User) {
+	if len(users) == 0 {
+		return
+	}
+	r.users = make(map[string]User)
+	for _, u := range users {
+		r.users[u.Name] = u
+	}
+}
+
+// get who a request logged in as, nil if it didn't
+func (r *Server) authenticate(req *http.Request) *User {
+	name, password, ok := req.BasicAuth()
+	if !ok {
+		return nil
+	}
+	u, ok := r.users[name]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(u.Password)) != 1 {
+		return nil
+	}
+	return &u
+}
+
+// a request about one torrent
+type torrentRequest interface {
+	torrentInfohash() string
+}
+
+func (r *ChangeTorrentRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *TorrentStatusRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *FindTorrentRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *RecheckTorrentRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *IntegrityReportRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *PieceMaskRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *SkipFilesRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *FilePriorityRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *RatioLimitRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+func (r *PieceDeadlineRequest) torrentInfohash() string {
+	return r.Infohash
+}
+
+// replace rr with an error if user may not do it, leaves it be for admins
+func (r *Server) restrictRequest(user *User, method string, rr Request) Request {
+	if user.Admin {
+		return rr
+	}
+	if !userMethods[method] {
+		return errorRequest(ErrPermissionDenied)
+	}
+	if atr, ok := rr.(*AddTorrentRequest); ok && atr.Dir != "" {
+		// users don't get to write wherever we can
+		return errorRequest(ErrPermissionDenied)
+	}
+	if tr, ok := rr.(torrentRequest); ok {
+		// don't tell users about torrents they don't own
+		ih, err := common.DecodeInfohash(tr.torrentInfohash())
+		if err != nil {
+			return errorRequest(err)
+		}
+		var t *swarm.Torrent
+		if sw, _ := r.index.Find(ih); sw != nil {
+			t = sw.Torrents.GetTorrent(ih)
+		}
+		if t == nil || t.Owner() != user.Name {
+			return errorRequest(ErrNoTorrent)
+		}
+	}
+	return rr
+}
+
+// set the user a request logged in as
+func (r *BaseRequest) setUser(u *User) {
+	r.user = u
+}
+
+// get the name of the user a request logged in as, empty if there was no login
+func (r *BaseRequest) userName() string {
+	if r.user == nil {
+		return ""
+	}
+	return r.user.Name
+}
+
+// returns true if the user this request logged in as may see t
+func (r *BaseRequest) canSee(t *swarm.Torrent) bool {
+	return r.user == nil || r.user.Admin || t.Owner() == r.user.Name
+}
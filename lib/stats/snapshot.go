@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// DefaultSnapshotInterval is how often we take a swarm statistics snapshot
+const DefaultSnapshotInterval = time.Minute
+
+// DefaultSnapshotRetention is how long swarm statistics snapshots are kept by default
+const DefaultSnapshotRetention = time.Hour * 24 * 7
+
+// SnapshotSize is the size of an encoded Snapshot
+const SnapshotSize = 8 + 8 + 8 + 4 + 4
+
+// Snapshot is aggregate swarm statistics at a point in time
+type Snapshot struct {
+	// unix timestamp in seconds
+	Time int64 `json:"time"`
+	// upload rate in bytes per second
+	TX uint64 `json:"tx"`
+	// download rate in bytes per second
+	RX uint64 `json:"rx"`
+	// number of connected peers
+	Peers uint32 `json:"peers"`
+	// number of torrents
+	Torrents uint32 `json:"torrents"`
+}
+
+// At returns the time the snapshot was taken at
+func (s Snapshot) At() time.Time {
+	return time.Unix(s.Time, 0)
+}
+
+// Encode writes the snapshot into buf which must be at least SnapshotSize bytes
+func (s Snapshot) Encode(buf []byte) {
+	binary.BigEndian.PutUint64(buf, uint64(s.Time))
+	binary.BigEndian.PutUint64(buf[8:], s.TX)
+	binary.BigEndian.PutUint64(buf[16:], s.RX)
+	binary.BigEndian.PutUint32(buf[24:], s.Peers)
+	binary.BigEndian.PutUint32(buf[28:], s.Torrents)
+}
+
+// Decode reads the snapshot from buf which must be at least SnapshotSize bytes
+func (s *Snapshot) Decode(buf []byte) {
+	s.Time = int64(binary.BigEndian.Uint64(buf))
+	s.TX = binary.BigEndian.Uint64(buf[8:])
+	s.RX = binary.BigEndian.Uint64(buf[16:])
+	s.Peers = binary.BigEndian.Uint32(buf[24:])
+	s.Torrents = binary.BigEndian.Uint32(buf[28:])
+}
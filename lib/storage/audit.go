@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/majestrate/XD/lib/audit"
+	"github.com/majestrate/XD/lib/fs"
+	"io/ioutil"
+)
+
+func (st *FsStorage) auditFilename() string {
+	return st.FS.Join(st.MetaDir, "swarm.audit")
+}
+
+func (st *FsStorage) auditLogSize() int {
+	if st.AuditLogSize <= 0 {
+		return audit.DefaultLogSize
+	}
+	return st.AuditLogSize
+}
+
+// PutAuditEvent appends an event to the audit log, dropping the oldest ones past AuditLogSize
+func (st *FsStorage) PutAuditEvent(e audit.Event) (err error) {
+	st.auditMtx.Lock()
+	defer st.auditMtx.Unlock()
+	var line []byte
+	line, err = json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	fname := st.auditFilename()
+	if !st.auditCounted {
+		// count the events once, after that we keep count as we go
+		var events []audit.Event
+		events, err = st.readAuditLog()
+		if err != nil {
+			return
+		}
+		st.auditEvents = len(events)
+		st.auditCounted = true
+	}
+	size := st.auditLogSize()
+	if st.auditEvents < size+(size/8) {
+		// only rewrite once well past the size so we don't rewrite on every put
+		var off int64
+		if fi, e := st.FS.Stat(fname); e == nil {
+			off = fi.Size()
+		}
+		var f fs.WriteFile
+		f, err = st.FS.OpenFileWriteOnly(fname)
+		if err == nil {
+			_, err = f.WriteAt(line, off)
+			f.Close()
+		}
+		if err == nil {
+			st.auditEvents++
+		}
+		return
+	}
+	var events []audit.Event
+	events, err = st.readAuditLog()
+	if err != nil {
+		return
+	}
+	if len(events) < size {
+		// the log changed under us
+		size = len(events) + 1
+	}
+	var buf bytes.Buffer
+	for _, old := range events[len(events)-size+1:] {
+		var l []byte
+		l, err = json.Marshal(old)
+		if err != nil {
+			return
+		}
+		buf.Write(l)
+		buf.WriteByte('\n')
+	}
+	buf.Write(line)
+	tmp := fname + ".tmp"
+	if st.FS.FileExists(tmp) {
+		st.FS.Remove(tmp)
+	}
+	var f fs.WriteFile
+	f, err = st.FS.OpenFileWriteOnly(tmp)
+	if err == nil {
+		_, err = f.WriteAt(buf.Bytes(), 0)
+		f.Close()
+		if err == nil {
+			err = st.FS.Move(tmp, fname)
+		}
+		if err == nil {
+			st.auditEvents = size
+		} else {
+			st.FS.Remove(tmp)
+		}
+	}
+	return
+}
+
+// read every event in the audit log, skipping lines that don't parse like one cut short by a crash
+func (st *FsStorage) readAuditLog() (events []audit.Event, err error) {
+	fname := st.auditFilename()
+	if !st.FS.FileExists(fname) {
+		return
+	}
+	var f fs.ReadFile
+	f, err = st.FS.OpenFileReadOnly(fname)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = ioutil.ReadAll(f)
+	f.Close()
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		var e audit.Event
+		if json.Unmarshal(s.Bytes(), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// AuditEvents gets the last n events in the audit log, oldest first, all of them if n is 0
+func (st *FsStorage) AuditEvents(n int) (events []audit.Event, err error) {
+	st.auditMtx.Lock()
+	defer st.auditMtx.Unlock()
+	events, err = st.readAuditLog()
+	if size := st.auditLogSize(); len(events) > size {
+		events = events[len(events)-size:]
+	}
+	if n > 0 && len(events) > n {
+		events = events[len(events)-n:]
+	}
+	return
+}
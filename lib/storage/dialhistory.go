@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DialHistory is how dialing a destination has gone for us
+type DialHistory struct {
+	// failures since the last successful dial
+	Fails int
+	// all failures and successes
+	TotalFails int
+	Successes  int
+	LastSeen   time.Time
+}
+
+func (t *fsTorrent) DialHistory() map[string]DialHistory {
+	s := t.st.getSettings(t.ih)
+	history := make(map[string]DialHistory)
+	// addr,fails,total fails,successes,unix time separated by spaces
+	for _, entry := range strings.Fields(s.Get("dial_history", "")) {
+		var h DialHistory
+		var seen int64
+		idx := strings.LastIndex(entry, ",")
+		for n := 0; n < 3 && idx > 0; n++ {
+			idx = strings.LastIndex(entry[:idx], ",")
+		}
+		if idx <= 0 {
+			continue
+		}
+		_, err := fmt.Sscanf(entry[idx+1:], "%d,%d,%d,%d", &h.Fails, &h.TotalFails, &h.Successes, &seen)
+		if err != nil || seen <= 0 {
+			continue
+		}
+		h.LastSeen = time.Unix(seen, 0)
+		history[entry[:idx]] = h
+	}
+	return history
+}
+
+func (t *fsTorrent) SetDialHistory(history map[string]DialHistory) error {
+	var entries []string
+	for addr, h := range history {
+		entries = append(entries, fmt.Sprintf("%s,%d,%d,%d,%d", addr, h.Fails, h.TotalFails, h.Successes, h.LastSeen.Unix()))
+	}
+	s := t.st.getSettings(t.ih)
+	s.Put("dial_history", strings.Join(entries, " "))
+	t.st.putSettings(t.ih, s)
+	return nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/metainfo"
+	"sort"
+)
+
+// where a file sits in the torrent's byte stream
+type fileExtent struct {
+	file   metainfo.FileInfo
+	offset int64
+	length int64
+}
+
+func (e fileExtent) end() int64 {
+	return e.offset + e.length
+}
+
+// file extents ordered by offset, lets us find the file holding a byte without walking every file
+type extentIndex []fileExtent
+
+func newExtentIndex(files []metainfo.FileInfo) (idx extentIndex) {
+	var off int64
+	idx = make(extentIndex, 0, len(files))
+	for _, fi := range files {
+		idx = append(idx, fileExtent{
+			file:   fi,
+			offset: off,
+			length: int64(fi.Length),
+		})
+		off += int64(fi.Length)
+	}
+	return
+}
+
+// find the index of the first non empty extent holding the byte at off, -1 if it is past the end
+func (idx extentIndex) find(off int64) int {
+	if off < 0 {
+		return -1
+	}
+	i := sort.Search(len(idx), func(i int) bool {
+		return idx[i].end() > off
+	})
+	if i == len(idx) {
+		return -1
+	}
+	return i
+}
+
+// get the extents a range of bytes spans, skips empty files
+func (idx extentIndex) spanning(off, length int64) (spans []fileExtent) {
+	i := idx.find(off)
+	if i < 0 {
+		return
+	}
+	end := off + length
+	for _, e := range idx[i:] {
+		if e.offset >= end {
+			break
+		}
+		if e.length > 0 {
+			spans = append(spans, e)
+		}
+	}
+	return
+}
+
+// get the extent index for this torrent, built on first use
+func (t *fsTorrent) fileExtents() extentIndex {
+	t.extentAccess.Lock()
+	defer t.extentAccess.Unlock()
+	if t.extents == nil && t.meta != nil {
+		t.extents = newExtentIndex(t.meta.Info.GetFiles())
+	}
+	return t.extents
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/metainfo"
+	"testing"
+)
+
+func TestExtentIndex(t *testing.T) {
+	idx := newExtentIndex([]metainfo.FileInfo{
+		{Length: 10},
+		{Length: 0},
+		{Length: 5},
+		{Length: 20},
+	})
+	for off, want := range map[int64]int{0: 0, 9: 0, 10: 2, 14: 2, 15: 3, 34: 3, 35: -1, -1: -1} {
+		if got := idx.find(off); got != want {
+			t.Fatalf("byte %d is in extent %d, wanted %d", off, got, want)
+		}
+	}
+	spans := idx.spanning(8, 10)
+	if len(spans) != 3 || spans[0].offset != 0 || spans[1].offset != 10 || spans[2].offset != 15 {
+		t.Fatalf("spans %v", spans)
+	}
+	if len(idx.spanning(35, 1)) != 0 {
+		t.Fatal("span past the end")
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/fs"
@@ -10,6 +11,8 @@ import (
 	"github.com/majestrate/XD/lib/stats"
 	"github.com/majestrate/XD/lib/sync"
 	"io"
+	"strconv"
+	"time"
 )
 
 // filesystem based storrent storage session
@@ -34,6 +37,18 @@ type fsTorrent struct {
 	seeding bool
 	// seeding mutex
 	seedAccess sync.Mutex
+	// number of pieces journaled since the last flush
+	journaled int
+	// journal access mutex
+	journalAccess sync.Mutex
+	// files written since the last flush, synced before the bitfield says we have their pieces
+	unsynced map[string]bool
+	// unsynced files mutex
+	unsyncedAccess sync.Mutex
+	// where each file sits in the torrent
+	extents extentIndex
+	// extent index mutex
+	extentAccess sync.Mutex
 }
 
 func (t *fsTorrent) DownloadDir() string {
@@ -41,9 +56,16 @@ func (t *fsTorrent) DownloadDir() string {
 }
 
 func (t *fsTorrent) Delete() (err error) {
+	if t.st.trashEnabled() {
+		err = t.moveToTrash()
+		return
+	}
 	err = t.st.FS.RemoveAll(t.st.metainfoFilename(t.ih))
 	if err == nil {
 		err = t.st.FS.RemoveAll(t.st.bitfieldFilename(t.ih))
+		if err == nil {
+			err = t.st.clearJournal(t.ih)
+		}
 		if err == nil {
 			err = t.st.FS.RemoveAll(t.FilePath())
 		}
@@ -53,11 +75,18 @@ func (t *fsTorrent) Delete() (err error) {
 
 func (t *fsTorrent) MoveTo(other string) (err error) {
 	t.access.Lock()
-	err = t.st.FS.EnsureDir(other)
+	// synced files we move need no syncing where they end up
+	err = t.syncFiles()
+	if err == nil {
+		err = t.st.FS.EnsureDir(other)
+	}
 	if err == nil {
 		multifile := !t.MetaInfo().IsSingleFile()
 		files := t.MetaInfo().Info.GetFiles()
 		for _, file := range files {
+			if file.IsPadding() {
+				continue
+			}
 			root := ""
 			if multifile {
 				root = t.MetaInfo().Info.Path
@@ -66,31 +95,128 @@ func (t *fsTorrent) MoveTo(other string) (err error) {
 			newpath := file.Path.FilePath(t.st.FS.Join(other, root))
 			log.Debugf("move %s -> %s", oldpath, newpath)
 			err = t.st.FS.Move(oldpath, newpath)
+			if err == nil {
+				// directories the move made
+				err = t.st.applyPerms(newpath, t.fileDirs(other, file), file.IsExecutable(), file.IsSymlink())
+			}
 			if err != nil {
 				break
 			}
 		}
 	}
-	s := t.st.getSettings(t.ih)
-	s.Put("dir", other)
-	t.st.putSettings(t.ih, s)
-	t.dir = other
+	if err == nil {
+		// a torrent some of whose files didn't move stays where it was
+		s := t.st.getSettings(t.ih)
+		s.Put("dir", other)
+		t.st.putSettings(t.ih, s)
+		t.dir = other
+	}
 	t.access.Unlock()
 	return
 }
 
+func (t *fsTorrent) Paused() bool {
+	s := t.st.getSettings(t.ih)
+	return s.Get("paused", "0") == "1"
+}
+
+func (t *fsTorrent) SetPaused(paused bool) error {
+	s := t.st.getSettings(t.ih)
+	if paused {
+		s.Put("paused", "1")
+	} else {
+		s.Put("paused", "0")
+	}
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+func (t *fsTorrent) Owner() string {
+	s := t.st.getSettings(t.ih)
+	return s.Get("owner", "")
+}
+
+func (t *fsTorrent) SetOwner(owner string) error {
+	s := t.st.getSettings(t.ih)
+	s.Put("owner", owner)
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+func (t *fsTorrent) NetworkPin() (network string, swarm int) {
+	s := t.st.getSettings(t.ih)
+	swarm, err := strconv.Atoi(s.Get("pin_swarm", ""))
+	if err != nil {
+		swarm = -1
+	}
+	return s.Get("pin_network", ""), swarm
+}
+
+func (t *fsTorrent) SetNetworkPin(network string, swarm int) error {
+	s := t.st.getSettings(t.ih)
+	s.Put("pin_network", network)
+	s.Put("pin_swarm", strconv.Itoa(swarm))
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+func (t *fsTorrent) UnpackState() (state, reason string) {
+	s := t.st.getSettings(t.ih)
+	return s.Get("unpack", ""), s.Get("unpack_error", "")
+}
+
+func (t *fsTorrent) SetUnpackState(state, reason string) error {
+	s := t.st.getSettings(t.ih)
+	s.Put("unpack", state)
+	s.Put("unpack_error", reason)
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
 func (t *fsTorrent) AllocateFile(f metainfo.FileInfo) (err error) {
 	fname := t.st.FS.Join(t.FilePath(), f.Path.FilePath(""))
+	if f.IsSymlink() {
+		return t.st.FS.Symlink(t.symlinkTarget(f), fname)
+	}
 	err = t.st.FS.EnsureFile(fname, f.Length)
+	if err == nil && f.IsExecutable() {
+		err = t.st.FS.Chmod(fname, 0755)
+	}
+	if err == nil {
+		err = t.st.applyPerms(fname, t.fileDirs(t.dir, f), f.IsExecutable(), false)
+	}
+	err = diskError(err)
 	return
 }
 
+// where a symlink in the torrent points, relative to the symlink so it survives moving the torrent
+func (t *fsTorrent) symlinkTarget(f metainfo.FileInfo) string {
+	var parts []string
+	for range f.Path[1:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, f.SymlinkPath...)
+	return t.st.FS.Join(parts...)
+}
+
 func (t *fsTorrent) Allocate() (err error) {
 	if t.meta.IsSingleFile() {
-		log.Debugf("file is %d bytes", t.meta.Info.Length)
-		err = t.st.FS.EnsureFile(t.FilePath(), t.meta.Info.Length)
+		log.Debugf("file is %d bytes", t.meta.TotalSize())
+		executable := t.meta.Info.GetFiles()[0].IsExecutable()
+		err = t.st.FS.EnsureFile(t.FilePath(), t.meta.TotalSize())
+		if err == nil && executable {
+			err = t.st.FS.Chmod(t.FilePath(), 0755)
+		}
+		if err == nil {
+			err = t.st.applyPerms(t.FilePath(), nil, executable, false)
+		}
+		err = diskError(err)
 	} else {
-		for _, f := range t.meta.Info.Files {
+		skip := t.skippedFiles()
+		for idx, f := range t.meta.Info.GetFiles() {
+			if f.IsPadding() || skip[idx] {
+				continue
+			}
 			err = t.AllocateFile(f)
 			if err != nil {
 				break
@@ -100,38 +226,69 @@ func (t *fsTorrent) Allocate() (err error) {
 	return
 }
 
-func (t *fsTorrent) openfileRead(i metainfo.FileInfo) (f fs.ReadFile, err error) {
-	var fname string
+// get the path of a file in the torrent
+func (t *fsTorrent) fileName(i metainfo.FileInfo) string {
 	if t.meta.IsSingleFile() {
-		fname = t.FilePath()
-	} else {
-		fname = t.st.FS.Join(t.FilePath(), i.Path.FilePath(""))
+		return t.FilePath()
 	}
-	f, err = t.st.FS.OpenFileReadOnly(fname)
+	return t.st.FS.Join(t.FilePath(), i.Path.FilePath(""))
+}
+
+func (t *fsTorrent) openfileRead(i metainfo.FileInfo) (f fs.ReadFile, err error) {
+	f, err = t.st.FS.OpenFileReadOnly(t.fileName(i))
 	return
 }
 
 func (t *fsTorrent) openfileWrite(i metainfo.FileInfo) (f fs.WriteFile, err error) {
-	var fname string
-	if t.meta.IsSingleFile() {
-		fname = t.FilePath()
-	} else {
-		fname = t.st.FS.Join(t.FilePath(), i.Path.FilePath(""))
-	}
+	fname := t.fileName(i)
 	f, err = t.st.FS.OpenFileWriteOnly(fname)
+	if err != nil && !t.st.FS.FileExists(fname) {
+		// skipped files are not allocated, pieces they share with files we want still go in them
+		dir, _ := t.st.FS.Split(fname)
+		if t.st.FS.EnsureDir(dir) == nil {
+			f, err = t.st.FS.OpenFileWriteOnly(fname)
+		}
+	}
+	return
+}
+
+func (t *fsTorrent) DiskUsage() (size, used uint64) {
+	if t.meta == nil {
+		return
+	}
+	for _, f := range t.meta.Info.GetFiles() {
+		if f.IsPadding() || f.IsSymlink() {
+			continue
+		}
+		fi, err := t.st.FS.Stat(t.fileName(f))
+		if err != nil {
+			// not allocated yet
+			continue
+		}
+		size += uint64(fi.Size())
+		used += fs.DiskUsage(fi)
+	}
 	return
 }
 
 func (t *fsTorrent) readFileAt(fi metainfo.FileInfo, b []byte, off int64) (n int, err error) {
 
 	// from github.com/anacrolix/torrent
-	var f fs.ReadFile
-	f, err = t.openfileRead(fi)
 	fil := int64(fi.Length)
 	// Limit the read to within the expected bounds of this file.
 	if int64(len(b)) > fil-off {
 		b = b[:fil-off]
 	}
+	if fi.IsPadding() || fi.IsSymlink() {
+		// padding is all zeros and never stored, symlinks have no data and we never read what they point at
+		for idx := range b {
+			b[idx] = 0
+		}
+		n = len(b)
+		return
+	}
+	var f fs.ReadFile
+	f, err = t.openfileRead(fi)
 	for off < fil && len(b) != 0 {
 		n1, err1 := f.ReadAt(b, off)
 		b = b[n1:]
@@ -150,12 +307,10 @@ func (t *fsTorrent) readFileAt(fi metainfo.FileInfo, b []byte, off int64) (n int
 
 func (t *fsTorrent) ReadAt(b []byte, off int64) (n int, err error) {
 
-	files := t.meta.Info.GetFiles()
 	// from github.com/anacrolix/torrent
-	for _, fi := range files {
-		fil := int64(fi.Length)
-		for off < fil {
-			n1, err1 := t.readFileAt(fi, b, off)
+	for _, e := range t.fileExtents().spanning(off, int64(len(b))) {
+		for off < e.end() {
+			n1, err1 := t.readFileAt(e.file, b, off-e.offset)
 			n += n1
 			off += int64(n1)
 			b = b[n1:]
@@ -169,13 +324,12 @@ func (t *fsTorrent) ReadAt(b []byte, off int64) (n int, err error) {
 				continue
 			}
 			err = err1
-			if err == io.EOF {
+			if err == nil || err == io.EOF {
 				// Lies.
 				err = io.ErrUnexpectedEOF
 			}
 			return
 		}
-		off -= fil
 	}
 	err = io.EOF
 	return
@@ -184,24 +338,29 @@ func (t *fsTorrent) ReadAt(b []byte, off int64) (n int, err error) {
 func (t *fsTorrent) WriteAt(p []byte, off int64) (n int, err error) {
 
 	// from github.com/anacrolix/torrent
-	for _, fi := range t.meta.Info.GetFiles() {
-		fil := int64(fi.Length)
-		if off >= fil {
-			off -= fil
-			continue
-		}
+	for _, e := range t.fileExtents().spanning(off, int64(len(p))) {
+		local := off - e.offset
 		n1 := len(p)
-		if int64(n1) > fil-off {
-			n1 = int(fil - off)
+		if int64(n1) > e.length-local {
+			n1 = int(e.length - local)
+		}
+		if e.file.IsPadding() || e.file.IsSymlink() {
+			n += n1
+			off += int64(n1)
+			p = p[n1:]
+			if len(p) == 0 {
+				break
+			}
+			continue
 		}
 		var f fs.WriteFile
-		f, err = t.openfileWrite(fi)
+		f, err = t.openfileWrite(e.file)
 		if err != nil {
 			return
 		}
-		n1, err = f.WriteAt(p[:n1], off)
-		f.Sync()
+		n1, err = f.WriteAt(p[:n1], local)
 		f.Close()
+		t.markUnsynced(t.fileName(e.file))
 		if err == io.ErrUnexpectedEOF {
 			err = nil
 		}
@@ -209,7 +368,7 @@ func (t *fsTorrent) WriteAt(p []byte, off int64) (n int, err error) {
 			return
 		}
 		n += n1
-		off = 0
+		off += int64(n1)
 		p = p[n1:]
 		if len(p) == 0 {
 			break
@@ -242,23 +401,58 @@ func (t *fsTorrent) DownloadedSize() (r uint64) {
 	if t.meta == nil {
 		return
 	}
-	bf := t.Bitfield()
-	r = uint64(bf.CountSet()) * uint64(t.meta.Info.PieceLength)
-	return
+	return t.meta.StoredBytesIn(t.Bitfield().Has)
+}
+
+func (t *fsTorrent) WantedSize() uint64 {
+	if t.meta == nil {
+		return 0
+	}
+	// we want every file
+	wanted := t.meta.TotalSize()
+	if mask := t.UnwantedPieces(); mask != nil {
+		wanted -= t.meta.StoredBytesIn(mask.Has)
+	}
+	return wanted
 }
 
 func (t *fsTorrent) DownloadRemaining() (r uint64) {
 	if t.meta == nil {
 		return
 	}
-	bf := t.Bitfield()
-	have := uint64(bf.CountSet()) * uint64(t.meta.Info.PieceLength)
-	if have > t.meta.TotalSize() {
-		r = 0
+	missing := t.Bitfield().Inverted()
+	if mask := t.UnwantedPieces(); mask != nil {
+		// only count the pieces we still want
+		missing = missing.AND(mask.Inverted())
+	}
+	return t.meta.StoredBytesIn(missing.Has)
+}
+
+func (t *fsTorrent) PieceMask() *bittorrent.Bitfield {
+	if t.meta == nil {
+		return nil
+	}
+	s := t.st.getSettings(t.ih)
+	mask, err := bittorrent.ParsePieceRanges(s.Get("piece_mask", ""), t.meta.Info.NumPieces())
+	if err != nil {
+		log.Warnf("bad piece mask for %s: %s", t.Name(), err.Error())
+		return nil
+	}
+	if mask.CountSet() == 0 {
+		return nil
+	}
+	return mask
+}
+
+func (t *fsTorrent) SetPieceMask(mask *bittorrent.Bitfield) error {
+	s := t.st.getSettings(t.ih)
+	if mask == nil {
+		s.Put("piece_mask", "")
 	} else {
-		r = t.meta.TotalSize() - have
+		s.Put("piece_mask", mask.Ranges())
 	}
-	return
+	t.st.putSettings(t.ih, s)
+	return nil
 }
 
 func (t *fsTorrent) MetaInfo() *metainfo.TorrentFile {
@@ -287,6 +481,11 @@ func (t *fsTorrent) FilePath() string {
 
 func (t *fsTorrent) PutInfo(info metainfo.Info) (err error) {
 	if t.meta == nil {
+		// peers can send us anything, check it before it touches the disk
+		err = info.Validate(t.st.Limits)
+		if err != nil {
+			return
+		}
 		meta := &metainfo.TorrentFile{
 			Info: info,
 		}
@@ -313,6 +512,65 @@ func (t *fsTorrent) PutInfo(info metainfo.Info) (err error) {
 	return
 }
 
+// hybrid torrents we got from magnets have no piece layers, make them from the data we have so the torrent file we keep is whole
+func (t *fsTorrent) fillPieceLayers() {
+	if t.meta == nil || !t.meta.Info.IsHybrid() || len(t.meta.PieceLayers) > 0 {
+		return
+	}
+	layers, err := t.meta.MakePieceLayers(func(idx uint32) ([]byte, error) {
+		pc := common.PieceData{Index: idx}
+		err := t.GetPiece(common.PieceRequest{Index: idx, Length: t.meta.LengthOfPiece(idx)}, &pc)
+		return pc.Data, err
+	})
+	if err != nil {
+		log.Warnf("failed to make piece layers of %s: %s", t.Name(), err.Error())
+		return
+	}
+	meta := *t.meta
+	meta.PieceLayers = layers
+	if err = meta.CheckPieceLayers(); err != nil {
+		log.Warnf("piece layers of %s don't match: %s", t.Name(), err.Error())
+		return
+	}
+	t.access.Lock()
+	defer t.access.Unlock()
+	t.meta.PieceLayers = layers
+	if err = t.st.writeMetainfo(t.st.metainfoFilename(t.ih), t.meta); err != nil {
+		log.Warnf("failed to save piece layers of %s: %s", t.Name(), err.Error())
+	}
+}
+
+func (t *fsTorrent) AddTrackers(urls []string) (err error) {
+	t.access.Lock()
+	defer t.access.Unlock()
+	if t.meta == nil {
+		return
+	}
+	have := make(map[string]bool)
+	for _, u := range t.meta.GetAllAnnounceURLS() {
+		have[u] = true
+	}
+	var added bool
+	for _, u := range urls {
+		if have[u] {
+			continue
+		}
+		if len(t.meta.AnnounceList) == 0 && t.meta.Announce != "" {
+			// clients ignore announce once there is an announce-list
+			t.meta.AnnounceList = append(t.meta.AnnounceList, []string{t.meta.Announce})
+		}
+		// one tier each, we know nothing about how they relate
+		t.meta.AnnounceList = append(t.meta.AnnounceList, []string{u})
+		have[u] = true
+		added = true
+	}
+	if !added {
+		return
+	}
+	err = t.st.writeMetainfo(t.st.metainfoFilename(t.ih), t.meta)
+	return
+}
+
 func (t *fsTorrent) GetPiece(r common.PieceRequest, pc *common.PieceData) (err error) {
 	t.access.Lock()
 	sz := t.meta.Info.PieceLength
@@ -351,16 +609,72 @@ func (t *fsTorrent) VerifyPiece(idx uint32) (err error) {
 	pc.Index = idx
 	err = t.GetPiece(r, &pc)
 	if err == nil {
-		if t.meta.Info.CheckPiece(&pc) {
+		if t.meta.CheckPiece(&pc) {
 			t.bf.Set(idx)
+			if !t.checking {
+				err = t.journalPiece(idx)
+			}
 		} else {
 			t.bf.Unset(idx)
-			err = common.ErrInvalidPiece
+			err = fmt.Errorf("%w: piece %d", common.ErrPieceHashMismatch, idx)
 		}
 	}
 	return
 }
 
+// journal a piece we just verified, flushes the bitfield once the journal is full
+func (t *fsTorrent) journalPiece(idx uint32) (err error) {
+	if t.st.JournalSize <= 0 {
+		return t.Flush()
+	}
+	full := false
+	t.journalAccess.Lock()
+	err = t.st.appendJournal(t.ih, journalRecord{Index: idx})
+	if err == nil {
+		t.journaled++
+		full = t.journaled >= t.st.JournalSize
+	}
+	t.journalAccess.Unlock()
+	if full {
+		err = t.Flush()
+	}
+	return
+}
+
+// re-verify pieces that were journaled but not flushed to the bitfield when we last stopped
+func (t *fsTorrent) recoverJournal() (err error) {
+	var records []journalRecord
+	records, err = t.st.readJournal(t.ih)
+	if err != nil || len(records) == 0 {
+		return
+	}
+	log.Infof("recovering %d journaled pieces for %s", len(records), t.Name())
+	t.bfmtx.Lock()
+	t.checking = true
+	t.ensureBitfield()
+	sz := t.meta.Info.NumPieces()
+	for _, r := range records {
+		if r.Index >= sz {
+			continue
+		}
+		l := t.meta.LengthOfPiece(r.Index)
+		var pc common.PieceData
+		pc.Data = make([]byte, l)
+		pc.Index = r.Index
+		err = t.GetPiece(common.PieceRequest{Index: r.Index, Length: l}, &pc)
+		if err == nil && t.meta.CheckPiece(&pc) {
+			t.bf.Set(r.Index)
+		} else {
+			log.Warnf("journaled piece %d of %s is bad, will redownload", r.Index, t.Name())
+			t.bf.Unset(r.Index)
+		}
+	}
+	t.checking = false
+	t.bfmtx.Unlock()
+	err = t.Flush()
+	return
+}
+
 func (t *fsTorrent) VerifyAll() (err error) {
 	if t.meta == nil {
 		err = ErrNoMetaInfo
@@ -375,7 +689,7 @@ func (t *fsTorrent) VerifyAll() (err error) {
 	idx := uint32(0)
 	for idx < sz {
 		err = t.VerifyPiece(uint32(idx))
-		if err == common.ErrInvalidPiece {
+		if errors.Is(err, common.ErrPieceHashMismatch) {
 			err = nil
 		} else if err != nil {
 			log.Errorf("failed to check piece %d: %s", idx, err.Error())
@@ -418,16 +732,64 @@ func (t *fsTorrent) putChunk(idx, offset uint32, data []byte) (err error) {
 		_, err = t.WriteAt(data, off)
 	}
 	t.access.Unlock()
+	err = diskError(err)
 	return
 }
 
-func (t *fsTorrent) Flush() error {
+func (t *fsTorrent) Flush() (err error) {
 	if t.meta == nil {
 		return ErrNoMetaInfo
 	}
 	log.Debugf("flush bitfield for %s", t.ih.Hex())
 	bf := t.Bitfield()
-	return t.st.flushBitfield(t.ih, bf)
+	t.journalAccess.Lock()
+	// the pieces have to be on disk before the bitfield says we have them
+	err = t.syncFiles()
+	if err == nil {
+		err = t.st.flushBitfield(t.ih, bf)
+	}
+	if err == nil {
+		// everything journaled is now in the bitfield
+		err = t.st.clearJournal(t.ih)
+		t.journaled = 0
+	}
+	t.journalAccess.Unlock()
+	err = diskError(err)
+	return
+}
+
+// remember a file we wrote to so the next flush syncs it
+func (t *fsTorrent) markUnsynced(fname string) {
+	t.unsyncedAccess.Lock()
+	if t.unsynced == nil {
+		t.unsynced = make(map[string]bool)
+	}
+	t.unsynced[fname] = true
+	t.unsyncedAccess.Unlock()
+}
+
+// sync every file written since the last flush, all at once instead of on every write
+func (t *fsTorrent) syncFiles() (err error) {
+	t.unsyncedAccess.Lock()
+	defer t.unsyncedAccess.Unlock()
+	for fname := range t.unsynced {
+		if !t.st.FS.FileExists(fname) {
+			// moved or deleted since
+			delete(t.unsynced, fname)
+			continue
+		}
+		var f fs.WriteFile
+		f, err = t.st.FS.OpenFileWriteOnly(fname)
+		if err == nil {
+			err = f.Sync()
+			f.Close()
+		}
+		if err != nil {
+			return
+		}
+		delete(t.unsynced, fname)
+	}
+	return
 }
 
 func (t *fsTorrent) Close() error {
@@ -468,12 +830,21 @@ func (t *fsTorrent) doSeed() (err error) {
 	}
 	err = t.VerifyAll()
 	if err == nil {
-		if t.dir != t.st.SeedingDir {
+		// torrents with their own download directory seed from where they are
+		if t.dir == t.st.DataDir {
 			log.Infof("Moving downloaded data to %s", t.st.SeedingDir)
 			err = t.MoveTo(t.st.SeedingDir)
 		}
 		t.seeding = err == nil
-	} else if err == common.ErrInvalidPiece {
+		if t.seeding {
+			t.markCompleted()
+			t.fillPieceLayers()
+			// a library we can't fill should not stop us seeding
+			if lerr := t.linkToLibrary(); lerr != nil {
+				log.Errorf("failed to put %s in the library: %s", t.Name(), lerr.Error())
+			}
+		}
+	} else if errors.Is(err, common.ErrPieceHashMismatch) {
 		log.Error("invalid pieces will redownload")
 		err = nil
 		t.seeding = false
@@ -528,11 +899,37 @@ type FsStorage struct {
 	Workers int
 	// IOP channel buffer size
 	IOPBufferSize int
+	// number of verified pieces to journal before flushing a bitfield, 0 flushes every piece
+	JournalSize int
+	// directory deleted torrents are moved to, deletes right away if empty
+	TrashDir string
+	// how long deleted torrents are kept in the trash
+	TrashPurgeAfter time.Duration
+	// how long swarm statistics snapshots are kept
+	SnapshotRetention time.Duration
+	snapshotMtx       sync.Mutex
+	// how many audit log events are kept
+	AuditLogSize int
+	auditMtx     sync.Mutex
+	// events in the audit log once counted, guarded by auditMtx
+	auditEvents  int
+	auditCounted bool
+	// new torrents are added stopped
+	StartPaused bool
+	// completed data is hardlinked or copied here while we keep seeding the originals, disabled if empty
+	LibraryDir string
+	// biggest torrents we accept, metainfo.DefaultLimits for any that are not set
+	Limits metainfo.Limits
+	// mode and owner of the data files and directories we create
+	Perms Permissions
 	// buffered io channel
 	ioChan chan IOP
 }
 
 func (st *FsStorage) Run() {
+	if st.trashEnabled() {
+		go st.runTrashPurge()
+	}
 	n := st.Workers
 	if n <= 0 {
 		st.ioChan = nil
@@ -602,6 +999,9 @@ func (st *FsStorage) Init() (err error) {
 	if err == nil {
 		err = st.FS.EnsureDir(st.SeedingDir)
 	}
+	if err == nil && st.trashEnabled() {
+		err = st.FS.EnsureDir(st.TrashDir)
+	}
 	return
 }
 
@@ -660,6 +1060,7 @@ func (st *FsStorage) saveStatsForTorrent(ih common.Infohash, s *stats.Tracker) (
 }
 
 func (st *FsStorage) EmptyTorrent(ih common.Infohash) (t Torrent) {
+	st.ensureAdded(ih)
 	t = &fsTorrent{
 		dir: st.DataDir,
 		st:  st,
@@ -673,7 +1074,49 @@ func (st *FsStorage) OpenTorrent(info *metainfo.TorrentFile) (t Torrent, err err
 	return
 }
 
+func (st *FsStorage) EmptyTorrentIn(ih common.Infohash, dir string) (t Torrent) {
+	if dir == "" {
+		dir = st.DataDir
+	}
+	st.putDir(ih, dir)
+	st.ensureAdded(ih)
+	t = &fsTorrent{
+		dir: dir,
+		st:  st,
+		ih:  ih,
+	}
+	return
+}
+
+func (st *FsStorage) OpenTorrentIn(info *metainfo.TorrentFile, dir string) (t Torrent, err error) {
+	if dir == "" {
+		dir = st.DataDir
+	}
+	err = info.Validate(st.Limits)
+	if err != nil {
+		return
+	}
+	err = st.FS.EnsureDir(dir)
+	if err == nil {
+		st.putDir(info.Infohash(), dir)
+		t, err = st.openTorrent(info, dir)
+	}
+	return
+}
+
 func (st *FsStorage) openTorrent(info *metainfo.TorrentFile, rootpath string) (t Torrent, err error) {
+	// a malicious torrent could write outside of rootpath
+	err = info.Validate(st.Limits)
+	if err != nil {
+		return
+	}
+	if info.Info.IsV2() {
+		// v2 pieces are checked against the piece layers so they have to be right
+		err = info.CheckV2()
+		if err != nil {
+			return
+		}
+	}
 	basepath := st.FS.Join(rootpath, info.TorrentName())
 	if !info.IsSingleFile() {
 		// create directory
@@ -683,16 +1126,11 @@ func (st *FsStorage) openTorrent(info *metainfo.TorrentFile, rootpath string) (t
 	ih := info.Infohash()
 	metapath := st.metainfoFilename(ih)
 	if !st.FS.FileExists(metapath) {
-		// put meta info down onto filesystem
-		var f fs.WriteFile
-		f, err = st.FS.OpenFileWriteOnly(metapath)
-		if err == nil {
-			info.BEncode(f)
-			f.Close()
-		}
+		err = st.writeMetainfo(metapath, info)
 	}
 
 	if err == nil {
+		st.ensureAdded(ih)
 		ft := &fsTorrent{
 			dir:  rootpath,
 			st:   st,
@@ -701,6 +1139,9 @@ func (st *FsStorage) openTorrent(info *metainfo.TorrentFile, rootpath string) (t
 		}
 		log.Debugf("allocate space for %s", ft.Name())
 		err = ft.Allocate()
+		if err == nil {
+			err = ft.recoverJournal()
+		}
 		if err != nil {
 			t = nil
 			return
@@ -711,14 +1152,45 @@ func (st *FsStorage) openTorrent(info *metainfo.TorrentFile, rootpath string) (t
 	return
 }
 
+// put meta info down onto filesystem, written next to it then renamed so nobody reads half of it
+func (st *FsStorage) writeMetainfo(metapath string, info *metainfo.TorrentFile) (err error) {
+	tmp := metapath + ".tmp"
+	// left over from a crash, we don't truncate
+	st.FS.Remove(tmp)
+	var f fs.WriteFile
+	f, err = st.FS.OpenFileWriteOnly(tmp)
+	if err == nil {
+		err = info.BEncode(f)
+		f.Close()
+	}
+	if err == nil {
+		err = st.FS.Move(tmp, metapath)
+		if err != nil && st.FS.FileExists(metapath) {
+			// written by someone else in the meantime, some filesystems won't rename over it
+			err = nil
+		}
+	}
+	// gone unless we failed
+	st.FS.Remove(tmp)
+	return
+}
+
 // return true if we are using pooled io
 func (st *FsStorage) pooledIO() bool {
 	return st.ioChan != nil
 }
 
+// QueuedIO gets how many disk reads and writes wait for an io worker, always 0 without io workers
+func (st *FsStorage) QueuedIO() int {
+	return len(st.ioChan)
+}
+
 func (st *FsStorage) initSettings(i common.Infohash) {
 	s := createSettings()
 	s.Put("dir", st.DataDir)
+	if st.StartPaused {
+		s.Put("paused", "1")
+	}
 	st.putSettings(i, s)
 }
 
@@ -730,6 +1202,13 @@ func (st *FsStorage) putSettings(i common.Infohash, s fsSettings) {
 	}
 }
 
+// remember the download directory for a torrent
+func (st *FsStorage) putDir(i common.Infohash, dir string) {
+	s := st.getSettings(i)
+	s.Put("dir", dir)
+	st.putSettings(i, s)
+}
+
 func (st *FsStorage) getSettings(i common.Infohash) (s fsSettings) {
 	s = createSettings()
 	if !st.FS.FileExists(st.settingsFilename(i)) {
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"time"
+)
+
+// FileIntegrity is a file of a torrent that isn't the size the torrent says it is
+type FileIntegrity struct {
+	Path string `json:"path"`
+	// size the torrent says the file has
+	Expected uint64 `json:"expected"`
+	// size of the file on disk
+	Size uint64 `json:"size"`
+}
+
+// IntegrityReport is what we found checking a torrent's data on disk against its metainfo
+type IntegrityReport struct {
+	Infohash string `json:"infohash"`
+	Name     string `json:"name"`
+	Pieces   uint32 `json:"pieces"`
+	// pieces whose data matches their hash
+	Verified uint32 `json:"verified"`
+	// pieces whose data doesn't match their hash or that we could not read
+	Failed uint32 `json:"failed"`
+	// pieces we think we have that failed, the data changed under us
+	Regressed []uint32 `json:"regressed"`
+	// pieces that matched which we don't think we have yet
+	Unclaimed    uint32          `json:"unclaimed"`
+	MissingFiles []string        `json:"missing_files"`
+	SizeMismatch []FileIntegrity `json:"size_mismatch"`
+	Checked      time.Time       `json:"checked"`
+}
+
+// Ok returns true if every piece and file checked out
+func (r *IntegrityReport) Ok() bool {
+	return r.Failed == 0 && len(r.MissingFiles) == 0 && len(r.SizeMismatch) == 0
+}
+
+func (t *fsTorrent) IntegrityReport() (r IntegrityReport, err error) {
+	if t.meta == nil {
+		err = ErrNoMetaInfo
+		return
+	}
+	r.Infohash = t.ih.Hex()
+	r.Name = t.Name()
+	info := t.meta.Info
+	for _, f := range info.GetFiles() {
+		if f.IsPadding() {
+			continue
+		}
+		fname := t.fileName(f)
+		if f.IsSymlink() {
+			if !t.st.FS.FileExists(fname) {
+				r.MissingFiles = append(r.MissingFiles, fname)
+			}
+			continue
+		}
+		st, e := t.st.FS.Stat(fname)
+		if e != nil {
+			r.MissingFiles = append(r.MissingFiles, fname)
+		} else if uint64(st.Size()) != f.Length {
+			r.SizeMismatch = append(r.SizeMismatch, FileIntegrity{
+				Path:     fname,
+				Expected: f.Length,
+				Size:     uint64(st.Size()),
+			})
+		}
+	}
+	// a copy so we never touch what we think we have
+	have := t.Bitfield().Copy()
+	r.Pieces = info.NumPieces()
+	for idx := uint32(0); idx < r.Pieces; idx++ {
+		l := t.meta.LengthOfPiece(idx)
+		pc := common.PieceData{Index: idx}
+		e := t.GetPiece(common.PieceRequest{Index: idx, Length: l}, &pc)
+		if e == nil && t.meta.CheckPiece(&pc) {
+			r.Verified++
+			if !have.Has(idx) {
+				r.Unclaimed++
+			}
+			continue
+		}
+		r.Failed++
+		if have.Has(idx) {
+			r.Regressed = append(r.Regressed, idx)
+		}
+	}
+	r.Checked = time.Now()
+	log.Infof("integrity of %s: %d of %d pieces verified, %d failed, %d missing files, %d files of the wrong size", r.Name, r.Verified, r.Pieces, r.Failed, len(r.MissingFiles), len(r.SizeMismatch))
+	return
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/binary"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/fs"
+	"io/ioutil"
+)
+
+// DefaultJournalSize is how many verified pieces we journal before flushing the bitfield
+const DefaultJournalSize = 64
+
+// size of one journal record, the piece index
+// recovery checks the piece against the metainfo so we keep no hash of our own
+const journalRecordSize = 4
+
+// a piece we verified and wrote since the last bitfield flush
+type journalRecord struct {
+	Index uint32
+}
+
+func (r *journalRecord) encode(buf []byte) {
+	binary.BigEndian.PutUint32(buf, r.Index)
+}
+
+func (r *journalRecord) decode(buf []byte) {
+	r.Index = binary.BigEndian.Uint32(buf)
+}
+
+func (st *FsStorage) journalFilename(ih common.Infohash) string {
+	return st.FS.Join(st.MetaDir, ih.Hex()+".journal")
+}
+
+// append a record to the journal for a torrent
+// not synced, a record we lose only makes us download its piece again
+func (st *FsStorage) appendJournal(ih common.Infohash, r journalRecord) (err error) {
+	fname := st.journalFilename(ih)
+	var off int64
+	if st.FS.FileExists(fname) {
+		fi, e := st.FS.Stat(fname)
+		if e == nil {
+			// drop any partially written record
+			off = fi.Size() - (fi.Size() % journalRecordSize)
+		}
+	}
+	var buf [journalRecordSize]byte
+	r.encode(buf[:])
+	var f fs.WriteFile
+	f, err = st.FS.OpenFileWriteOnly(fname)
+	if err == nil {
+		_, err = f.WriteAt(buf[:], off)
+		f.Close()
+	}
+	return
+}
+
+// read all complete records from the journal for a torrent
+func (st *FsStorage) readJournal(ih common.Infohash) (records []journalRecord, err error) {
+	fname := st.journalFilename(ih)
+	if !st.FS.FileExists(fname) {
+		return
+	}
+	var f fs.ReadFile
+	f, err = st.FS.OpenFileReadOnly(fname)
+	if err == nil {
+		var data []byte
+		data, err = ioutil.ReadAll(f)
+		f.Close()
+		for len(data) >= journalRecordSize {
+			var r journalRecord
+			r.decode(data)
+			records = append(records, r)
+			data = data[journalRecordSize:]
+		}
+	}
+	return
+}
+
+// clear the journal for a torrent after the bitfield is flushed
+func (st *FsStorage) clearJournal(ih common.Infohash) (err error) {
+	fname := st.journalFilename(ih)
+	if st.FS.FileExists(fname) {
+		err = st.FS.Remove(fname)
+	}
+	return
+}
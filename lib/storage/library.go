@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/log"
+)
+
+func (st *FsStorage) libraryEnabled() bool {
+	return st.LibraryDir != ""
+}
+
+// returns true if we already put this torrent's files in the library
+func (t *fsTorrent) inLibrary() bool {
+	s := t.st.getSettings(t.ih)
+	return s.Get("library", "") == t.st.LibraryDir
+}
+
+// hardlink completed data files into the library directory, we keep seeding from the originals
+// files that are already in the library are left alone
+func (t *fsTorrent) linkToLibrary() (err error) {
+	if !t.st.libraryEnabled() || t.meta == nil || t.inLibrary() {
+		return
+	}
+	st := t.st
+	err = st.FS.EnsureDir(st.LibraryDir)
+	if err != nil {
+		return
+	}
+	t.access.Lock()
+	multifile := !t.meta.IsSingleFile()
+	root := ""
+	if multifile {
+		root = t.meta.Info.Path
+	}
+	for _, file := range t.meta.Info.GetFiles() {
+		if file.IsPadding() {
+			continue
+		}
+		oldpath := file.Path.FilePath(st.FS.Join(t.dir, root))
+		newpath := file.Path.FilePath(st.FS.Join(st.LibraryDir, root))
+		if st.FS.FileExists(newpath) {
+			continue
+		}
+		if file.IsSymlink() {
+			// symlinks point inside of the torrent so they work in the library as they are
+			err = st.FS.Symlink(t.symlinkTarget(file), newpath)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		log.Debugf("link %s -> %s", oldpath, newpath)
+		err = st.FS.Link(oldpath, newpath)
+		if err != nil {
+			break
+		}
+	}
+	t.access.Unlock()
+	if err == nil {
+		s := st.getSettings(t.ih)
+		s.Put("library", st.LibraryDir)
+		st.putSettings(t.ih, s)
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/metainfo"
+	"os"
+)
+
+// Permissions says what mode and owner the data files and directories we create get
+type Permissions struct {
+	// mode of data files, executable files also get x where they get r, left as created if 0
+	FileMode os.FileMode
+	// mode of data directories, left as created if 0
+	DirMode os.FileMode
+	// give data files and directories to UID and GID, -1 leaves one as it is
+	Chown bool
+	UID   int
+	GID   int
+}
+
+// mode an executable file gets
+func executableMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// give a data file we created and the directories above it the configured mode and owner
+// symlinks keep theirs, changing them would change the file they point at
+func (st *FsStorage) applyPerms(fname string, dirs []string, executable, symlink bool) (err error) {
+	p := st.Perms
+	if p.FileMode != 0 && !symlink {
+		mode := p.FileMode
+		if executable {
+			mode = executableMode(mode)
+		}
+		err = st.FS.Chmod(fname, mode)
+	}
+	if err == nil && p.Chown && !symlink {
+		err = st.FS.Chown(fname, p.UID, p.GID)
+	}
+	for _, dir := range dirs {
+		if err == nil && p.DirMode != 0 {
+			err = st.FS.Chmod(dir, p.DirMode)
+		}
+		if err == nil && p.Chown {
+			err = st.FS.Chown(dir, p.UID, p.GID)
+		}
+	}
+	return
+}
+
+// the directories from the top directory of the torrent in dir down to the one f is in, none for single file torrents
+func (t *fsTorrent) fileDirs(dir string, f metainfo.FileInfo) (dirs []string) {
+	if t.meta.IsSingleFile() {
+		return
+	}
+	parts := []string{dir, t.meta.Info.Path}
+	for idx := 0; idx < len(f.Path); idx++ {
+		dirs = append(dirs, t.st.FS.Join(parts...))
+		parts = append(parts, f.Path[idx])
+	}
+	return
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"strconv"
+)
+
+func (t *fsTorrent) Priority() int {
+	s := t.st.getSettings(t.ih)
+	p, _ := strconv.Atoi(s.Get("priority", "0"))
+	return p
+}
+
+func (t *fsTorrent) SetPriority(p int) error {
+	s := t.st.getSettings(t.ih)
+	s.Put("priority", strconv.Itoa(p))
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+func (t *fsTorrent) Forced() bool {
+	s := t.st.getSettings(t.ih)
+	return s.Get("forced", "0") == "1"
+}
+
+func (t *fsTorrent) SetForced(on bool) error {
+	s := t.st.getSettings(t.ih)
+	if on {
+		s.Put("forced", "1")
+	} else {
+		s.Put("forced", "0")
+	}
+	t.st.putSettings(t.ih, s)
+	return nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"strconv"
+)
+
+func (t *fsTorrent) Totals() (uploaded, downloaded uint64) {
+	s := t.st.getSettings(t.ih)
+	uploaded, _ = strconv.ParseUint(s.Get("uploaded", "0"), 10, 64)
+	downloaded, _ = strconv.ParseUint(s.Get("downloaded", "0"), 10, 64)
+	return
+}
+
+func (t *fsTorrent) SetTotals(uploaded, downloaded uint64) error {
+	s := t.st.getSettings(t.ih)
+	s.Put("uploaded", strconv.FormatUint(uploaded, 10))
+	s.Put("downloaded", strconv.FormatUint(downloaded, 10))
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+func (t *fsTorrent) RatioLimit() (limit float64, action string) {
+	s := t.st.getSettings(t.ih)
+	limit, err := strconv.ParseFloat(s.Get("ratio_limit", ""), 64)
+	if err != nil || limit < 0 {
+		return -1, ""
+	}
+	return limit, s.Get("ratio_action", "")
+}
+
+func (t *fsTorrent) SetRatioLimit(limit float64, action string) error {
+	s := t.st.getSettings(t.ih)
+	if limit < 0 {
+		s.Put("ratio_limit", "")
+		s.Put("ratio_action", "")
+	} else {
+		s.Put("ratio_limit", strconv.FormatFloat(limit, 'f', -1, 64))
+		s.Put("ratio_action", action)
+	}
+	t.st.putSettings(t.ih, s)
+	return nil
+}
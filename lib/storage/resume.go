@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+func (t *fsTorrent) ResumePeers() (addrs []string, at time.Time) {
+	s := t.st.getSettings(t.ih)
+	addrs = strings.Fields(s.Get("resume_peers", ""))
+	at = s.getTime("resume_at")
+	return
+}
+
+func (t *fsTorrent) SetResumePeers(addrs []string) error {
+	s := t.st.getSettings(t.ih)
+	s.Put("resume_peers", strings.Join(addrs, " "))
+	s.putTime("resume_at", time.Now())
+	t.st.putSettings(t.ih, s)
+	return nil
+}
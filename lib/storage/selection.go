@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/bittorrent"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoSuchFile is returned when selecting a file a torrent doesn't have
+var ErrNoSuchFile = errors.New("no such file in torrent")
+
+// ErrBadFilePriority is returned when setting a file priority that isn't -1, 0 or 1
+var ErrBadFilePriority = errors.New("file priority must be -1, 0 or 1")
+
+// ErrSingleFile is returned when skipping the file of a torrent with just one file
+var ErrSingleFile = errors.New("can't skip the only file of a torrent")
+
+func (t *fsTorrent) SkippedFiles() (files []int) {
+	s := t.st.getSettings(t.ih)
+	for _, part := range strings.Split(s.Get("skip_files", ""), ",") {
+		idx, err := strconv.Atoi(part)
+		if err == nil {
+			files = append(files, idx)
+		}
+	}
+	return
+}
+
+func (t *fsTorrent) SetSkippedFiles(files []int) error {
+	if t.meta == nil {
+		return ErrNoMetaInfo
+	}
+	if len(files) > 0 && t.meta.IsSingleFile() {
+		return ErrSingleFile
+	}
+	all := t.meta.Info.GetFiles()
+	skip := make(map[int]bool)
+	var parts []string
+	for _, idx := range files {
+		if idx < 0 || idx >= len(all) {
+			return ErrNoSuchFile
+		}
+		if !skip[idx] {
+			skip[idx] = true
+			parts = append(parts, strconv.Itoa(idx))
+		}
+	}
+	sort.Strings(parts)
+	// files we want again get allocated now
+	for _, idx := range t.SkippedFiles() {
+		if idx < len(all) && !skip[idx] && !all[idx].IsPadding() {
+			if err := t.AllocateFile(all[idx]); err != nil {
+				return err
+			}
+		}
+	}
+	s := t.st.getSettings(t.ih)
+	s.Put("skip_files", strings.Join(parts, ","))
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+// get the files we don't download by their index
+func (t *fsTorrent) skippedFiles() (skip map[int]bool) {
+	for _, idx := range t.SkippedFiles() {
+		if skip == nil {
+			skip = make(map[int]bool)
+		}
+		skip[idx] = true
+	}
+	return
+}
+
+// get the pieces that only hold data of skipped files, nil if we download every file
+func (t *fsTorrent) fileMask() *bittorrent.Bitfield {
+	skip := t.skippedFiles()
+	if t.meta == nil || len(skip) == 0 {
+		return nil
+	}
+	pl := int64(t.meta.Info.PieceLength)
+	wanted := bittorrent.NewBitfield(t.meta.Info.NumPieces(), nil)
+	for idx, e := range t.fileExtents() {
+		if skip[idx] || e.file.IsPadding() || e.length == 0 {
+			continue
+		}
+		for p := e.offset / pl; p <= (e.end()-1)/pl; p++ {
+			wanted.Set(uint32(p))
+		}
+	}
+	return wanted.Inverted()
+}
+
+func (t *fsTorrent) UnwantedPieces() *bittorrent.Bitfield {
+	mask := t.PieceMask()
+	files := t.fileMask()
+	if mask == nil {
+		mask = files
+	} else if files != nil {
+		mask = mask.OR(files)
+	}
+	if mask != nil && mask.CountSet() == 0 {
+		return nil
+	}
+	return mask
+}
+
+func (t *fsTorrent) FilePriorities() (prios map[int]int) {
+	s := t.st.getSettings(t.ih)
+	for _, part := range strings.Split(s.Get("file_priority", ""), ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		idx, err := strconv.Atoi(kv[0])
+		if err != nil {
+			continue
+		}
+		p, err := strconv.Atoi(kv[1])
+		if err != nil || p == 0 {
+			continue
+		}
+		if prios == nil {
+			prios = make(map[int]int)
+		}
+		prios[idx] = p
+	}
+	return
+}
+
+func (t *fsTorrent) SetFilePriority(files []int, p int) error {
+	if t.meta == nil {
+		return ErrNoMetaInfo
+	}
+	if p < -1 || p > 1 {
+		return ErrBadFilePriority
+	}
+	n := len(t.meta.Info.GetFiles())
+	for _, idx := range files {
+		if idx < 0 || idx >= n {
+			return ErrNoSuchFile
+		}
+	}
+	prios := t.FilePriorities()
+	if prios == nil {
+		prios = make(map[int]int)
+	}
+	for _, idx := range files {
+		if p == 0 {
+			delete(prios, idx)
+		} else {
+			prios[idx] = p
+		}
+	}
+	var idxs []int
+	for idx := range prios {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	var parts []string
+	for _, idx := range idxs {
+		parts = append(parts, strconv.Itoa(idx)+":"+strconv.Itoa(prios[idx]))
+	}
+	s := t.st.getSettings(t.ih)
+	s.Put("file_priority", strings.Join(parts, ","))
+	t.st.putSettings(t.ih, s)
+	return nil
+}
+
+func (t *fsTorrent) PiecePriorities() (high, low *bittorrent.Bitfield) {
+	prios := t.FilePriorities()
+	if t.meta == nil || len(prios) == 0 {
+		return
+	}
+	skip := t.skippedFiles()
+	pl := int64(t.meta.Info.PieceLength)
+	n := t.meta.Info.NumPieces()
+	high = bittorrent.NewBitfield(n, nil)
+	low = bittorrent.NewBitfield(n, nil)
+	notLow := bittorrent.NewBitfield(n, nil)
+	for idx, e := range t.fileExtents() {
+		if skip[idx] || e.file.IsPadding() || e.length == 0 {
+			continue
+		}
+		for p := e.offset / pl; p <= (e.end()-1)/pl; p++ {
+			switch prios[idx] {
+			case 1:
+				high.Set(uint32(p))
+				notLow.Set(uint32(p))
+			case -1:
+				low.Set(uint32(p))
+			default:
+				notLow.Set(uint32(p))
+			}
+		}
+	}
+	// a piece is only low priority if every file we want in it is
+	low = low.AND(notLow.Inverted())
+	if high.CountSet() == 0 {
+		high = nil
+	}
+	if low.CountSet() == 0 {
+		low = nil
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/fs"
+	"github.com/majestrate/XD/lib/stats"
+	"io/ioutil"
+	"time"
+)
+
+func (st *FsStorage) snapshotsFilename() string {
+	return st.FS.Join(st.MetaDir, "swarm.snapshots")
+}
+
+func (st *FsStorage) snapshotRetention() time.Duration {
+	if st.SnapshotRetention <= 0 {
+		return stats.DefaultSnapshotRetention
+	}
+	return st.SnapshotRetention
+}
+
+// PutSnapshot appends a swarm statistics snapshot, dropping ones older than SnapshotRetention
+func (st *FsStorage) PutSnapshot(s stats.Snapshot) (err error) {
+	st.snapshotMtx.Lock()
+	defer st.snapshotMtx.Unlock()
+	fname := st.snapshotsFilename()
+	var off int64
+	if st.FS.FileExists(fname) {
+		fi, e := st.FS.Stat(fname)
+		if e == nil {
+			// drop any partially written snapshot
+			off = fi.Size() - (fi.Size() % stats.SnapshotSize)
+		}
+	}
+	var buf [stats.SnapshotSize]byte
+	s.Encode(buf[:])
+	var f fs.WriteFile
+	f, err = st.FS.OpenFileWriteOnly(fname)
+	if err == nil {
+		_, err = f.WriteAt(buf[:], off)
+		f.Close()
+	}
+	if err == nil {
+		err = st.expireSnapshots(s.At())
+	}
+	return
+}
+
+// rewrite the snapshots file without expired snapshots
+// only done once the oldest is well past retention so we don't rewrite on every put
+func (st *FsStorage) expireSnapshots(now time.Time) (err error) {
+	retention := st.snapshotRetention()
+	fname := st.snapshotsFilename()
+	var oldest stats.Snapshot
+	var rf fs.ReadFile
+	rf, err = st.FS.OpenFileReadOnly(fname)
+	if err != nil {
+		return
+	}
+	var first [stats.SnapshotSize]byte
+	_, e := rf.ReadAt(first[:], 0)
+	rf.Close()
+	if e != nil {
+		return
+	}
+	oldest.Decode(first[:])
+	if now.Sub(oldest.At()) < retention+(retention/8) {
+		return
+	}
+	var snaps []stats.Snapshot
+	snaps, err = st.readSnapshots()
+	if err != nil {
+		return
+	}
+	cutoff := now.Add(-retention).Unix()
+	var data []byte
+	for _, s := range snaps {
+		if s.Time >= cutoff {
+			var buf [stats.SnapshotSize]byte
+			s.Encode(buf[:])
+			data = append(data, buf[:]...)
+		}
+	}
+	tmp := fname + ".tmp"
+	if st.FS.FileExists(tmp) {
+		st.FS.Remove(tmp)
+	}
+	var f fs.WriteFile
+	f, err = st.FS.OpenFileWriteOnly(tmp)
+	if err == nil {
+		_, err = f.WriteAt(data, 0)
+		f.Close()
+		if err == nil {
+			err = st.FS.Move(tmp, fname)
+		} else {
+			st.FS.Remove(tmp)
+		}
+	}
+	return
+}
+
+func (st *FsStorage) readSnapshots() (snaps []stats.Snapshot, err error) {
+	fname := st.snapshotsFilename()
+	if !st.FS.FileExists(fname) {
+		return
+	}
+	var f fs.ReadFile
+	f, err = st.FS.OpenFileReadOnly(fname)
+	if err == nil {
+		var data []byte
+		data, err = ioutil.ReadAll(f)
+		f.Close()
+		for len(data) >= stats.SnapshotSize {
+			var s stats.Snapshot
+			s.Decode(data)
+			snaps = append(snaps, s)
+			data = data[stats.SnapshotSize:]
+		}
+	}
+	return
+}
+
+// Snapshots returns the swarm statistics snapshots taken between from and to, oldest first
+func (st *FsStorage) Snapshots(from, to time.Time) (snaps []stats.Snapshot, err error) {
+	st.snapshotMtx.Lock()
+	defer st.snapshotMtx.Unlock()
+	var all []stats.Snapshot
+	all, err = st.readSnapshots()
+	for _, s := range all {
+		if s.Time >= from.Unix() && s.Time <= to.Unix() {
+			snaps = append(snaps, s)
+		}
+	}
+	return
+}
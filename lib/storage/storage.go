@@ -2,15 +2,46 @@ package storage
 
 import (
 	"errors"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/bittorrent"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/metainfo"
 	"github.com/majestrate/XD/lib/stats"
+	"syscall"
+	"time"
 )
 
 var ErrNoMetaInfo = errors.New("no torrent file")
 var ErrMetaInfoMissmatch = errors.New("torrent infohash does not match")
 
+// ErrDiskFull is wrapped by errors for writes that failed because the disk ran out of space
+var ErrDiskFull = errors.New("disk full")
+
+// a write that failed because the disk ran out of space, it is ErrDiskFull and still unwraps to what the disk said
+type diskFullError struct {
+	err error
+}
+
+func (e *diskFullError) Error() string {
+	return ErrDiskFull.Error() + ": " + e.err.Error()
+}
+
+func (e *diskFullError) Is(target error) bool {
+	return target == ErrDiskFull
+}
+
+func (e *diskFullError) Unwrap() error {
+	return e.err
+}
+
+// wrap err with ErrDiskFull if the disk ran out of space
+func diskError(err error) error {
+	if err != nil && errors.Is(err, syscall.ENOSPC) {
+		return &diskFullError{err: err}
+	}
+	return err
+}
+
 // storage session for 1 torrent
 type Torrent interface {
 
@@ -30,6 +61,7 @@ type Torrent interface {
 	GetPiece(r common.PieceRequest, pc *common.PieceData) error
 
 	// verify a piece by index
+	// pieces verified outside of a deep check are journaled until the next flush
 	VerifyPiece(idx uint32) error
 
 	// get metainfo
@@ -41,24 +73,131 @@ type Torrent interface {
 	// get bitfield, if cached return cache otherwise compute and cache
 	Bitfield() *bittorrent.Bitfield
 
-	// get number of bytes we already downloaded
+	// get number of bytes we already downloaded and verified, counting the short last piece
 	DownloadedSize() uint64
 
-	// get number of bytes remaining we need to download
+	// returns true if the torrent was stopped and should stay stopped when we start up
+	Paused() bool
+
+	// remember if the torrent is stopped across restarts
+	SetPaused(paused bool) error
+
+	// get the rpc user that added the torrent, empty if nobody did
+	Owner() string
+
+	// remember which rpc user added the torrent
+	SetOwner(owner string) error
+
+	// get the kind of network the torrent may only run on and the number of the swarm it may only run in
+	// empty and -1 if it is not pinned to any
+	NetworkPin() (network string, swarm int)
+
+	// remember the network and swarm the torrent is pinned to, empty and -1 pin it to none
+	SetNetworkPin(network string, swarm int) error
+
+	// get how far unpacking the completed torrent got and why it failed, empty if it never finished
+	UnpackState() (state, reason string)
+
+	// remember how unpacking the completed torrent went across restarts
+	SetUnpackState(state, reason string) error
+
+	// get how soon the torrent gets a turn to run and how much bandwidth it gets, higher goes first, 0 is normal
+	Priority() int
+
+	// remember the priority of the torrent across restarts
+	SetPriority(p int) error
+
+	// get if the torrent runs without waiting for a turn
+	Forced() bool
+
+	// remember if the torrent runs without waiting for a turn across restarts
+	SetForced(on bool) error
+
+	// get if we super seed the torrent, set is false if it was never turned on or off for it
+	Superseed() (on, set bool)
+
+	// remember if we super seed the torrent across restarts
+	SetSuperseed(on bool) error
+
+	// get the pieces we were told not to download, nil if there are none
+	PieceMask() *bittorrent.Bitfield
+
+	// remember the pieces we should not download across restarts, nil clears it
+	SetPieceMask(mask *bittorrent.Bitfield) error
+
+	// get the indexes of the files we don't download, pieces they share with files we want are still downloaded
+	SkippedFiles() []int
+
+	// remember which files we don't download across restarts, allocates the files we want again
+	SetSkippedFiles(files []int) error
+
+	// get the priority of the files of the torrent by their index, -1 for low and 1 for high, files that aren't in it are normal priority
+	FilePriorities() map[int]int
+
+	// remember the priority of files across restarts, -1 for low, 0 for normal and 1 for high
+	SetFilePriority(files []int, p int) error
+
+	// get the pieces with a high priority file in them and the pieces where every file we want is low priority, nil if there are none
+	PiecePriorities() (high, low *bittorrent.Bitfield)
+
+	// get the pieces we don't download, masked ones and the ones only in skipped files, nil if we want every piece
+	UnwantedPieces() *bittorrent.Bitfield
+
+	// get number of bytes of the files we want to download, leaving out masked pieces and pieces only in skipped files
+	WantedSize() uint64
+
+	// get number of bytes remaining we need to download, never more than WantedSize
 	DownloadRemaining() uint64
 
-	// flush bitfield to disk
+	// flush bitfield to disk and clear the journal
 	Flush() error
 
 	// get name of this torrent
 	Name() string
 
 	// delete all files and metadata for this torrent
+	// moves them to the trash instead if the storage has one
 	Delete() error
 
 	// save torrent stats
 	SaveStats(s *stats.Tracker) error
 
+	// get when we first added the torrent
+	AddedAt() time.Time
+
+	// get when we first completed the torrent, zero if we never did
+	CompletedAt() time.Time
+
+	// get when we last uploaded or downloaded anything for the torrent, zero if we never did
+	LastActive() time.Time
+
+	// remember when we last uploaded or downloaded anything for the torrent across restarts
+	SetLastActive(tm time.Time) error
+
+	// get how many bytes of piece data we uploaded and downloaded for the torrent since we added it
+	Totals() (uploaded, downloaded uint64)
+
+	// remember how many bytes of piece data we uploaded and downloaded across restarts
+	SetTotals(uploaded, downloaded uint64) error
+
+	// get the share ratio the torrent stops at and what it does then, a negative limit if it uses the default
+	RatioLimit() (limit float64, action string)
+
+	// remember the share ratio limit of the torrent across restarts, a negative limit goes back to the default
+	SetRatioLimit(limit float64, action string) error
+
+	// get the addresses of the peers we were connected to when we last shut down and when that was
+	ResumePeers() (addrs []string, at time.Time)
+
+	// remember the peers we are connected to so we dial them first when we start again, nil forgets them
+	SetResumePeers(addrs []string) error
+
+	// get how dialing the destinations of this torrent went by address, kept across restarts
+	DialHistory() map[string]DialHistory
+
+	// remember how dialing the destinations of this torrent went, nil forgets it
+	SetDialHistory(history map[string]DialHistory) error
+
 	// get a list of files for this torrent
 	// returns absolute path of all downloaded files
 	FileList() []string
@@ -72,8 +211,17 @@ type Torrent interface {
 	// set metainfo for empty torrent
 	PutInfo(info metainfo.Info) error
 
+	// add tracker announce urls to the stored metainfo, urls it already has are skipped
+	AddTrackers(urls []string) error
+
 	// get directory for data files
 	DownloadDir() string
+
+	// get how big our files are and how many bytes they really take on disk, sparse files only count the blocks we wrote
+	DiskUsage() (size, used uint64)
+
+	// check every piece and file on disk against the metainfo without changing what we think we have
+	IntegrityReport() (IntegrityReport, error)
 }
 
 // torrent storage driver
@@ -85,10 +233,23 @@ type Storage interface {
 	// create a torrent with no meta info
 	EmptyTorrent(ih common.Infohash) Torrent
 
+	// create a torrent with no meta info that downloads into dir
+	// uses the default download directory if dir is empty
+	EmptyTorrentIn(ih common.Infohash, dir string) Torrent
+
 	// open a storage session for a torrent
 	// does not verify any piece data
 	OpenTorrent(info *metainfo.TorrentFile) (Torrent, error)
 
+	// open a storage session for a torrent that downloads into dir
+	// uses the default download directory if dir is empty
+	// does not verify any piece data
+	OpenTorrentIn(info *metainfo.TorrentFile, dir string) (Torrent, error)
+
+	// move a deleted torrent back out of the trash and open it
+	// does not verify any piece data
+	RestoreTorrent(ih common.Infohash) (Torrent, error)
+
 	// open all torrents tracked by this storage
 	// does not verify any piece data
 	OpenAllTorrents() ([]Torrent, error)
@@ -100,6 +261,21 @@ type Storage interface {
 	// returns next new torrents added to storage
 	PollNewTorrents() []Torrent
 
+	// append a swarm statistics snapshot, older snapshots past retention are dropped
+	PutSnapshot(s stats.Snapshot) error
+
+	// get swarm statistics snapshots taken between from and to, oldest first
+	Snapshots(from, to time.Time) ([]stats.Snapshot, error)
+
+	// append an event to the audit log, the oldest events past its size are dropped
+	PutAuditEvent(e audit.Event) error
+
+	// get the last n events in the audit log oldest first, all of them if n is 0
+	AuditEvents(n int) ([]audit.Event, error)
+
+	// get how many disk reads and writes wait for an io worker
+	QueuedIO() int
+
 	// run mainloop
 	Run()
 }
@@ -2,13 +2,19 @@ package storage
 
 import (
 	"crypto/rand"
+	"errors"
+	"github.com/majestrate/XD/lib/audit"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/fs"
 	"github.com/majestrate/XD/lib/log"
 	"github.com/majestrate/XD/lib/metainfo"
 	"github.com/majestrate/XD/lib/mktorrent"
+	"github.com/majestrate/XD/lib/stats"
 	"io"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 )
 
 const testPieceLen = 65536
@@ -92,4 +98,1071 @@ func TestStorage(t *testing.T) {
 		return
 	}
 
+	size, used := torrent.DiskUsage()
+	if size != meta.TotalSize() || used == 0 {
+		t.Logf("disk usage size=%d used=%d for %d byte torrent", size, used, meta.TotalSize())
+		t.Fail()
+	}
+}
+
+func TestStorageJournal(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:     "storage",
+		DataDir:     "data",
+		SeedingDir:  "seeding",
+		FS:          fs.STD,
+		JournalSize: 4,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	fname := st.FS.Join(st.DataDir, "journal.bin")
+	meta, err := createRandomTorrent(fname)
+	if err != nil {
+		t.Logf("failed to make torrent: %s", err.Error())
+		t.Fail()
+		return
+	}
+	ih := meta.Infohash()
+	st.CreateNewBitfield(ih, meta.Info.NumPieces())
+
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Log("failed to open torrent")
+		t.Fail()
+		return
+	}
+	torrent.Bitfield()
+	for _, idx := range []uint32{0, 2} {
+		err = torrent.VerifyPiece(idx)
+		if err != nil {
+			t.Log(err.Error())
+			t.Fail()
+			return
+		}
+	}
+	if fi, err := st.FS.Stat(st.journalFilename(ih)); err != nil || fi.Size() != 2*journalRecordSize {
+		t.Fatalf("journal of 2 pieces is %v, %v", fi, err)
+	}
+	// journaled but never flushed, like a crash
+	st.CreateNewBitfield(ih, meta.Info.NumPieces())
+	torrent, err = st.OpenTorrent(meta)
+	if err != nil {
+		t.Log("failed to reopen torrent")
+		t.Fail()
+		return
+	}
+	bf := torrent.Bitfield()
+	if !bf.Has(0) || !bf.Has(2) || bf.Has(1) {
+		t.Log("journaled pieces were not recovered")
+		t.Fail()
+	}
+	if st.FS.FileExists(st.journalFilename(ih)) {
+		t.Log("journal not cleared after recovery")
+		t.Fail()
+	}
+	torrent.Delete()
+}
+
+func TestStorageSyncOnFlush(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := st.FS.Join(st.DataDir, "sync.bin")
+	meta, err := createRandomTorrent(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer torrent.Delete()
+	ft := torrent.(*fsTorrent)
+	var pc common.PieceData
+	if err = torrent.GetPiece(common.PieceRequest{Index: 1, Length: 16384}, &pc); err != nil {
+		t.Fatal(err)
+	}
+	for idx := 0; idx < 4; idx++ {
+		if err = torrent.PutChunk(&pc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ft.unsynced) != 1 || !ft.unsynced[fname] {
+		t.Fatalf("unsynced files are %v after writing to %s", ft.unsynced, fname)
+	}
+	if err = torrent.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ft.unsynced) != 0 {
+		t.Fatalf("%v still unsynced after flushing", ft.unsynced)
+	}
+}
+
+func TestStorageSnapshots(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:           "storage",
+		DataDir:           "data",
+		SeedingDir:        "seeding",
+		FS:                fs.STD,
+		SnapshotRetention: time.Hour,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	st.FS.Remove(st.snapshotsFilename())
+	defer st.FS.Remove(st.snapshotsFilename())
+	now := time.Now()
+	// two hours of snapshots every 10 minutes
+	for idx := 12; idx >= 0; idx-- {
+		err = st.PutSnapshot(stats.Snapshot{
+			Time:  now.Add(-time.Duration(idx) * time.Minute * 10).Unix(),
+			Peers: uint32(idx),
+		})
+		if err != nil {
+			t.Log(err.Error())
+			t.Fail()
+			return
+		}
+	}
+	snaps, err := st.Snapshots(now.Add(-time.Hour*24), now)
+	if err != nil {
+		t.Log(err.Error())
+		t.Fail()
+		return
+	}
+	for _, s := range snaps {
+		if now.Sub(s.At()) > time.Hour+time.Hour/8 {
+			t.Logf("snapshot from %s was not expired", s.At())
+			t.Fail()
+		}
+	}
+	if len(snaps) == 0 || snaps[len(snaps)-1].Peers != 0 {
+		t.Log("latest snapshot missing")
+		t.Fail()
+	}
+	snaps, _ = st.Snapshots(now.Add(-time.Minute*25), now)
+	if len(snaps) != 3 {
+		t.Logf("expected 3 snapshots in range got %d", len(snaps))
+		t.Fail()
+	}
+}
+
+func TestStorageAuditLog(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:      "storage",
+		DataDir:      "data",
+		SeedingDir:   "seeding",
+		FS:           fs.STD,
+		AuditLogSize: 8,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	st.FS.Remove(st.auditFilename())
+	defer st.FS.Remove(st.auditFilename())
+	for idx := 0; idx < 20; idx++ {
+		err = st.PutAuditEvent(audit.Event{
+			Time:      int64(idx),
+			Principal: audit.PrincipalLocal,
+			Action:    audit.ActionAdded,
+		})
+		if err != nil {
+			t.Log(err.Error())
+			t.Fail()
+			return
+		}
+	}
+	events, err := st.AuditEvents(0)
+	if err != nil {
+		t.Log(err.Error())
+		t.Fail()
+		return
+	}
+	if len(events) != 8 {
+		t.Logf("expected 8 events got %d", len(events))
+		t.Fail()
+		return
+	}
+	for idx, e := range events {
+		if e.Time != int64(12+idx) {
+			t.Logf("event %d is from %d", idx, e.Time)
+			t.Fail()
+		}
+	}
+	events, _ = st.AuditEvents(3)
+	if len(events) != 3 || events[2].Time != 19 {
+		t.Log("did not get the last 3 events")
+		t.Fail()
+	}
+	onDisk, _ := st.readAuditLog()
+	if len(onDisk) != st.auditEvents || len(onDisk) >= 8+8/8 {
+		t.Fatalf("%d events on disk, counted %d", len(onDisk), st.auditEvents)
+	}
+	// a restart counts what is on disk before appending
+	again := &FsStorage{MetaDir: st.MetaDir, FS: st.FS, AuditLogSize: 8}
+	if err = again.PutAuditEvent(audit.Event{Time: 20, Action: audit.ActionAdded}); err != nil {
+		t.Fatal(err)
+	}
+	if again.auditEvents != len(onDisk)+1 {
+		t.Fatalf("counted %d events after a restart, wanted %d", again.auditEvents, len(onDisk)+1)
+	}
+}
+
+func TestStoragePaused(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:     "storage",
+		DataDir:     "data",
+		SeedingDir:  "seeding",
+		FS:          fs.STD,
+		StartPaused: true,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	var ih common.Infohash
+	rand.Read(ih[:])
+	defer st.FS.Remove(st.settingsFilename(ih))
+	torrent := st.EmptyTorrent(ih)
+	if !torrent.Paused() {
+		t.Log("new torrent was not paused")
+		t.Fail()
+		return
+	}
+	torrent.SetPaused(false)
+	if st.EmptyTorrent(ih).Paused() {
+		t.Log("torrent still paused after starting")
+		t.Fail()
+	}
+}
+
+func TestStorageNetworkPin(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ih common.Infohash
+	rand.Read(ih[:])
+	defer st.FS.Remove(st.settingsFilename(ih))
+	torrent := st.EmptyTorrent(ih)
+	if network, swarm := torrent.NetworkPin(); network != "" || swarm != -1 {
+		t.Fatalf("new torrent is pinned to %q and swarm %d", network, swarm)
+	}
+	torrent.SetNetworkPin("lokinet", 1)
+	if network, swarm := st.EmptyTorrent(ih).NetworkPin(); network != "lokinet" || swarm != 1 {
+		t.Fatalf("torrent is pinned to %q and swarm %d after pinning", network, swarm)
+	}
+}
+
+func TestStorageLibrary(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		LibraryDir: "library",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	fname := st.FS.Join(st.DataDir, "library.bin")
+	meta, err := createRandomTorrent(fname)
+	if err != nil {
+		t.Logf("failed to make torrent: %s", err.Error())
+		t.Fail()
+		return
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Log("failed to open torrent")
+		t.Fail()
+		return
+	}
+	defer st.FS.RemoveAll(st.LibraryDir)
+	seeding, err := torrent.Seed()
+	if err != nil || !seeding {
+		t.Logf("not seeding: %v", err)
+		t.Fail()
+		return
+	}
+	seeded, err := st.FS.Stat(st.FS.Join(st.SeedingDir, "library.bin"))
+	if err != nil {
+		t.Log("seeding data was not kept")
+		t.Fail()
+		return
+	}
+	linked, err := st.FS.Stat(st.FS.Join(st.LibraryDir, "library.bin"))
+	if err != nil {
+		t.Log("data was not put in the library")
+		t.Fail()
+		return
+	}
+	if linked.Size() != seeded.Size() {
+		t.Logf("library file is %d bytes not %d", linked.Size(), seeded.Size())
+		t.Fail()
+	}
+}
+
+func TestStorageTimes(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	var ih common.Infohash
+	rand.Read(ih[:])
+	defer st.FS.Remove(st.settingsFilename(ih))
+	torrent := st.EmptyTorrent(ih)
+	added := torrent.AddedAt()
+	if added.IsZero() {
+		t.Log("new torrent has no added time")
+		t.Fail()
+		return
+	}
+	if !torrent.CompletedAt().IsZero() || !torrent.LastActive().IsZero() {
+		t.Log("new torrent was completed or active")
+		t.Fail()
+		return
+	}
+	active := time.Unix(1500000000, 0)
+	torrent.SetLastActive(active)
+	torrent = st.EmptyTorrent(ih)
+	if !torrent.AddedAt().Equal(added) {
+		t.Log("added time changed after reopening")
+		t.Fail()
+	}
+	if !torrent.LastActive().Equal(active) {
+		t.Logf("last active %s not %s", torrent.LastActive(), active)
+		t.Fail()
+	}
+}
+
+func TestStorageFileAttrs(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20),
+			Path:        "attrs",
+			Files: []metainfo.FileInfo{
+				{Length: 10, Path: metainfo.FilePath{"bin", "run"}, Attr: "x"},
+				{Path: metainfo.FilePath{"bin", "link"}, Attr: "l", SymlinkPath: metainfo.FilePath{"bin", "run"}},
+			},
+		},
+	}
+	_, err = st.OpenTorrent(meta)
+	if err != nil {
+		t.Logf("failed to open torrent: %s", err)
+		t.Fail()
+		return
+	}
+	root := st.FS.Join(st.DataDir, "attrs")
+	defer st.FS.RemoveAll(root)
+	fi, err := os.Stat(st.FS.Join(root, "bin", "run"))
+	if err != nil || fi.Mode()&0100 == 0 {
+		t.Logf("executable file not executable: %v", err)
+		t.Fail()
+	}
+	target, err := os.Readlink(st.FS.Join(root, "bin", "link"))
+	if err != nil || target != st.FS.Join("..", "bin", "run") {
+		t.Logf("symlink points at %q: %v", target, err)
+		t.Fail()
+	}
+}
+
+func TestStoragePerms(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+		Perms: Permissions{
+			FileMode: 0640,
+			DirMode:  0750,
+		},
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Log("failed to init storage")
+		t.Fail()
+		return
+	}
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20),
+			Path:        "perms",
+			Files: []metainfo.FileInfo{
+				{Length: 10, Path: metainfo.FilePath{"sub", "data"}},
+				{Length: 10, Path: metainfo.FilePath{"sub", "run"}, Attr: "x"},
+				{Path: metainfo.FilePath{"sub", "link"}, Attr: "l", SymlinkPath: metainfo.FilePath{"sub", "run"}},
+			},
+		},
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Logf("failed to open torrent: %s", err)
+		t.Fail()
+		return
+	}
+	checkModes := func(root string) {
+		modes := map[string]os.FileMode{
+			root:                            0750,
+			st.FS.Join(root, "sub"):         0750,
+			st.FS.Join(root, "sub", "data"): 0640,
+			st.FS.Join(root, "sub", "run"):  0750,
+		}
+		for fname, mode := range modes {
+			fi, err := os.Stat(fname)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+			} else if fi.Mode().Perm() != mode {
+				t.Logf("%s has mode %v not %v", fname, fi.Mode().Perm(), mode)
+				t.Fail()
+			}
+		}
+	}
+	root := st.FS.Join(st.DataDir, "perms")
+	defer st.FS.RemoveAll(root)
+	checkModes(root)
+	// moving the symlink leaves the mode of the file it points at alone
+	moved := st.FS.Join(st.DataDir, "moved")
+	defer st.FS.RemoveAll(moved)
+	if err = torrent.MoveTo(moved); err != nil {
+		t.Fatal(err)
+	}
+	checkModes(st.FS.Join(moved, "perms"))
+	// a move that fails keeps the torrent where it was
+	blocked := st.FS.Join(st.DataDir, "blocked")
+	defer st.FS.RemoveAll(blocked)
+	if err = st.FS.EnsureDir(st.FS.Join(blocked, "perms", "sub", "data", "in", "the", "way")); err != nil {
+		t.Fatal(err)
+	}
+	if torrent.MoveTo(blocked) == nil || torrent.DownloadDir() != moved {
+		t.Fatalf("failed move left the torrent in %s", torrent.DownloadDir())
+	}
+}
+
+func TestDiskError(t *testing.T) {
+	full := &os.PathError{Op: "write", Path: "data", Err: syscall.ENOSPC}
+	if err := diskError(full); !errors.Is(err, ErrDiskFull) || !errors.Is(err, syscall.ENOSPC) {
+		t.Logf("%s is not %s and %s", err, ErrDiskFull, syscall.ENOSPC)
+		t.Fail()
+	}
+	denied := &os.PathError{Op: "write", Path: "data", Err: syscall.EACCES}
+	if err := diskError(denied); err != denied {
+		t.Logf("%s was wrapped", err)
+		t.Fail()
+	}
+	if err := diskError(nil); err != nil {
+		t.Logf("nil became %s", err)
+		t.Fail()
+	}
+}
+
+func TestStorageIntegrityReport(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := st.FS.Join(st.DataDir, "integrity.bin")
+	meta, err := createRandomTorrent(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer torrent.Delete()
+	if err = torrent.VerifyAll(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := torrent.IntegrityReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Ok() || r.Verified != meta.Info.NumPieces() {
+		t.Fatalf("intact torrent reported %+v", r)
+	}
+	// scribble over piece 2 behind the storage's back
+	f, err := os.OpenFile(fname, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(make([]byte, 128), testPieceLen*2)
+	f.Close()
+	r, err = torrent.IntegrityReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Failed != 1 || len(r.Regressed) != 1 || r.Regressed[0] != 2 {
+		t.Fatalf("corrupt piece not reported: %+v", r)
+	}
+	if !torrent.Bitfield().Has(2) {
+		t.Fatal("integrity report changed the bitfield")
+	}
+	if err = os.Truncate(fname, testPieceLen*4); err != nil {
+		t.Fatal(err)
+	}
+	r, err = torrent.IntegrityReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.SizeMismatch) != 1 || r.SizeMismatch[0].Size != testPieceLen*4 || r.SizeMismatch[0].Expected != meta.TotalSize() {
+		t.Fatalf("truncated file not reported: %+v", r)
+	}
+	// pieces 0, 1 and 3 are still whole
+	if r.Verified != 3 {
+		t.Fatalf("%d pieces verified in a truncated file, expected 3", r.Verified)
+	}
+	os.Remove(fname)
+	r, err = torrent.IntegrityReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.MissingFiles) != 1 || r.Verified != 0 {
+		t.Fatalf("missing file not reported: %+v", r)
+	}
+}
+
+func TestStorageSkipFiles(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is in pieces 0 and 1, b in 1 and 2 and c in 2 and 3
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20*4),
+			Path:        "skip",
+			Files: []metainfo.FileInfo{
+				{Length: testPieceLen * 3 / 2, Path: metainfo.FilePath{"a"}},
+				{Length: testPieceLen, Path: metainfo.FilePath{"b"}},
+				{Length: testPieceLen * 3 / 2, Path: metainfo.FilePath{"dir", "c"}},
+			},
+		},
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := st.FS.Join(st.DataDir, "skip")
+	defer st.FS.RemoveAll(root)
+	defer st.FS.Remove(st.settingsFilename(meta.Infohash()))
+	if torrent.SetSkippedFiles([]int{3}) != ErrNoSuchFile {
+		t.Fatal("skipped a file the torrent doesn't have")
+	}
+	if err = torrent.SetSkippedFiles([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if mask := torrent.UnwantedPieces(); mask != nil {
+		t.Fatalf("skipping a file sharing all of its pieces masked %s", mask.Ranges())
+	}
+	if err = torrent.SetSkippedFiles([]int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	mask := torrent.UnwantedPieces()
+	if mask == nil || mask.Ranges() != "2-3" {
+		t.Fatal("pieces only in skipped files are wanted")
+	}
+	if wanted := torrent.WantedSize(); wanted != testPieceLen*2 {
+		t.Fatalf("want %d bytes, expected %d", wanted, testPieceLen*2)
+	}
+	if remaining := torrent.DownloadRemaining(); remaining != testPieceLen*2 {
+		t.Fatalf("%d bytes remaining, expected %d", remaining, testPieceLen*2)
+	}
+	cname := st.FS.Join(root, "dir", "c")
+	os.RemoveAll(st.FS.Join(root, "dir"))
+	if err = torrent.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if st.FS.FileExists(cname) {
+		t.Fatal("allocated a skipped file")
+	}
+	if err = torrent.SetSkippedFiles(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !st.FS.FileExists(cname) {
+		t.Fatal("file we want again is not allocated")
+	}
+	if torrent.UnwantedPieces() != nil || torrent.WantedSize() != meta.TotalSize() {
+		t.Fatal("still skipping files")
+	}
+}
+
+func TestStorageFilePriority(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is in pieces 0 and 1, b in 1 and 2 and c in 2 and 3
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20*4),
+			Path:        "prio",
+			Files: []metainfo.FileInfo{
+				{Length: testPieceLen * 3 / 2, Path: metainfo.FilePath{"a"}},
+				{Length: testPieceLen, Path: metainfo.FilePath{"b"}},
+				{Length: testPieceLen * 3 / 2, Path: metainfo.FilePath{"c"}},
+			},
+		},
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.FS.RemoveAll(st.FS.Join(st.DataDir, "prio"))
+	defer st.FS.Remove(st.settingsFilename(meta.Infohash()))
+	if high, low := torrent.PiecePriorities(); high != nil || low != nil {
+		t.Fatal("pieces have a priority before any file does")
+	}
+	if torrent.SetFilePriority([]int{0}, 2) != ErrBadFilePriority {
+		t.Fatal("set a bad file priority")
+	}
+	if torrent.SetFilePriority([]int{3}, 1) != ErrNoSuchFile {
+		t.Fatal("set the priority of a file the torrent doesn't have")
+	}
+	if err = torrent.SetFilePriority([]int{0}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = torrent.SetFilePriority([]int{1, 2}, -1); err != nil {
+		t.Fatal(err)
+	}
+	prios := torrent.FilePriorities()
+	if len(prios) != 3 || prios[0] != 1 || prios[1] != -1 || prios[2] != -1 {
+		t.Fatalf("file priorities are %v", prios)
+	}
+	high, low := torrent.PiecePriorities()
+	if high == nil || high.Ranges() != "0-1" {
+		t.Fatal("pieces of the high priority file are not high priority")
+	}
+	// piece 1 holds a high priority file too
+	if low == nil || low.Ranges() != "2-3" {
+		t.Fatal("pieces of only low priority files are not low priority")
+	}
+	// skipped files don't make their pieces any priority
+	if err = torrent.SetSkippedFiles([]int{2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, low = torrent.PiecePriorities(); low == nil || low.Ranges() != "2" {
+		t.Fatal("pieces of a skipped file are low priority")
+	}
+	if err = torrent.SetFilePriority([]int{0, 1, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(torrent.FilePriorities()) != 0 {
+		t.Fatal("files still have a priority")
+	}
+}
+
+func TestStorageTotals(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20),
+			Path:        "totals",
+			Length:      testPieceLen,
+		},
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.FS.RemoveAll(st.FS.Join(st.DataDir, "totals"))
+	defer st.FS.Remove(st.settingsFilename(meta.Infohash()))
+	if up, down := torrent.Totals(); up != 0 || down != 0 {
+		t.Fatalf("new torrent has totals %d %d", up, down)
+	}
+	if err = torrent.SetTotals(3000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if up, down := torrent.Totals(); up != 3000 || down != 1000 {
+		t.Fatalf("totals are %d %d", up, down)
+	}
+	if limit, _ := torrent.RatioLimit(); limit >= 0 {
+		t.Fatal("new torrent has a share ratio limit of its own")
+	}
+	if err = torrent.SetRatioLimit(1.5, "remove"); err != nil {
+		t.Fatal(err)
+	}
+	if limit, action := torrent.RatioLimit(); limit != 1.5 || action != "remove" {
+		t.Fatalf("share ratio limit is %v %s", limit, action)
+	}
+	// 0 is a limit of its own that never stops
+	if err = torrent.SetRatioLimit(0, "stop"); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := torrent.RatioLimit(); limit != 0 {
+		t.Fatalf("share ratio limit is %v", limit)
+	}
+	if err = torrent.SetRatioLimit(-1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := torrent.RatioLimit(); limit >= 0 {
+		t.Fatal("share ratio limit not cleared")
+	}
+	if _, set := torrent.Superseed(); set {
+		t.Fatal("new torrent has super seeding set")
+	}
+	// turned off is kept too so it doesn't fall back to the default
+	if err = torrent.SetSuperseed(false); err != nil {
+		t.Fatal(err)
+	}
+	if on, set := torrent.Superseed(); on || !set {
+		t.Fatalf("super seeding is %v %v", on, set)
+	}
+}
+
+func TestStorageQueuedIO(t *testing.T) {
+	st := &FsStorage{}
+	if st.QueuedIO() != 0 {
+		t.Fatal("storage without io workers has queued io")
+	}
+	st.ioChan = make(chan IOP, 4)
+	st.ioChan <- &readIOP{}
+	st.ioChan <- &writeIOP{}
+	if n := st.QueuedIO(); n != 2 {
+		t.Fatalf("%d queued io not 2", n)
+	}
+}
+
+func TestStorageDialHistory(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ih common.Infohash
+	rand.Read(ih[:])
+	defer st.FS.Remove(st.settingsFilename(ih))
+	torrent := st.EmptyTorrent(ih)
+	if len(torrent.DialHistory()) != 0 {
+		t.Fatal("new torrent has dial history")
+	}
+	seen := time.Unix(time.Now().Unix(), 0)
+	history := map[string]DialHistory{
+		"[::1]:6881":        {Fails: 2, TotalFails: 5, Successes: 1, LastSeen: seen},
+		"somewhere.b32.i2p": {Fails: 1, TotalFails: 1, LastSeen: seen},
+	}
+	if err = torrent.SetDialHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	kept := st.EmptyTorrent(ih).DialHistory()
+	if len(kept) != len(history) {
+		t.Fatalf("kept %v", kept)
+	}
+	for addr, h := range history {
+		if kept[addr] != h {
+			t.Errorf("kept %v for %s not %v", kept[addr], addr, h)
+		}
+	}
+}
+
+func TestStorageTrash(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:         "storage",
+		DataDir:         "data",
+		SeedingDir:      "seeding",
+		TrashDir:        "trash",
+		TrashPurgeAfter: time.Hour,
+		FS:              fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := st.FS.Join(st.DataDir, "trash.bin")
+	meta, err := createRandomTorrent(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.FS.Remove(fname)
+	ih := meta.Infohash()
+	defer st.FS.RemoveAll(st.trashDirFor(ih))
+	defer st.FS.Remove(st.settingsFilename(ih))
+	defer st.FS.Remove(st.bitfieldFilename(ih))
+	defer st.FS.Remove(st.metainfoFilename(ih))
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = torrent.VerifyAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.RestoreTorrent(ih); err != ErrNotInTrash {
+		t.Fatalf("restoring a torrent we didn't delete gave %v", err)
+	}
+	if err = torrent.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	trashed := st.trashDirFor(ih)
+	if st.FS.FileExists(fname) || st.FS.FileExists(st.metainfoFilename(ih)) {
+		t.Fatal("deleted torrent left its data or metainfo behind")
+	}
+	if !st.FS.FileExists(st.FS.Join(trashed, "trash.bin")) || !st.FS.FileExists(st.FS.Join(trashed, ih.Hex()+".torrent")) {
+		t.Fatal("deleted torrent is not in the trash")
+	}
+	// not expired yet
+	if err = st.PurgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := st.RestoreTorrent(ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.FS.FileExists(trashed) || !st.FS.FileExists(fname) || !st.FS.FileExists(st.metainfoFilename(ih)) {
+		t.Fatal("restored torrent is still in the trash")
+	}
+	if err = restored.VerifyAll(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Bitfield().CountSet() != int(meta.Info.NumPieces()) {
+		t.Fatal("restored torrent lost pieces")
+	}
+	// deleted long ago
+	if err = restored.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := st.trashSettings(ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("deleted", "0")
+	f, err := st.FS.OpenFileWriteOnly(st.FS.Join(trashed, ih.Hex()+".settings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.BEncode(f)
+	f.Close()
+	if err = st.PurgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if st.FS.FileExists(trashed) {
+		t.Fatal("expired torrent is still in the trash")
+	}
+	if _, err = st.RestoreTorrent(ih); err != ErrNotInTrash {
+		t.Fatalf("restoring a purged torrent gave %v", err)
+	}
+	st.TrashDir = ""
+	if _, err = st.RestoreTorrent(ih); err != ErrNoTrash {
+		t.Fatalf("restoring without a trash gave %v", err)
+	}
+}
+
+func TestStorageWriteMetainfo(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &metainfo.TorrentFile{
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20),
+			Path:        "metainfo",
+			Length:      testPieceLen,
+		},
+	}
+	ih := meta.Infohash()
+	metapath := st.metainfoFilename(ih)
+	defer st.FS.Remove(metapath)
+	// a longer one left behind by a crash
+	f, err := st.FS.OpenFileWriteOnly(metapath + ".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, 4096))
+	f.Close()
+	if err = st.writeMetainfo(metapath, meta); err != nil {
+		t.Fatal(err)
+	}
+	if st.FS.FileExists(metapath + ".tmp") {
+		t.Fatal("temporary metainfo left behind")
+	}
+	var written metainfo.TorrentFile
+	r, err := st.FS.OpenFileReadOnly(metapath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = written.BDecode(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.Infohash() != ih {
+		t.Fatal("wrote different metainfo")
+	}
+}
+
+func TestStorageAddTrackers(t *testing.T) {
+
+	st := &FsStorage{
+		MetaDir:    "storage",
+		DataDir:    "data",
+		SeedingDir: "seeding",
+		FS:         fs.STD,
+	}
+
+	err := st.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := &metainfo.TorrentFile{
+		Announce: "http://tracker.i2p/a",
+		Info: metainfo.Info{
+			PieceLength: testPieceLen,
+			Pieces:      make([]byte, 20),
+			Path:        "trackers",
+			Length:      testPieceLen,
+		},
+	}
+	torrent, err := st.OpenTorrent(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ih := meta.Infohash()
+	defer st.FS.RemoveAll(st.FS.Join(st.DataDir, "trackers"))
+	defer st.FS.Remove(st.settingsFilename(ih))
+	defer st.FS.Remove(st.metainfoFilename(ih))
+	if err = torrent.AddTrackers([]string{"http://tracker.i2p/a", "http://other.i2p/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if st.FS.FileExists(st.metainfoFilename(ih) + ".tmp") {
+		t.Fatal("temporary metainfo left behind")
+	}
+	var stored metainfo.TorrentFile
+	f, err := st.FS.OpenFileReadOnly(st.metainfoFilename(ih))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stored.BDecode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.AnnounceList) != 2 || stored.AnnounceList[1][0] != "http://other.i2p/a" {
+		t.Fatalf("stored trackers are %v", stored.AnnounceList)
+	}
 }
@@ -0,0 +1,23 @@
+package storage
+
+func (t *fsTorrent) Superseed() (on, set bool) {
+	s := t.st.getSettings(t.ih)
+	switch s.Get("superseed", "") {
+	case "1":
+		return true, true
+	case "0":
+		return false, true
+	}
+	return false, false
+}
+
+func (t *fsTorrent) SetSuperseed(on bool) error {
+	s := t.st.getSettings(t.ih)
+	if on {
+		s.Put("superseed", "1")
+	} else {
+		s.Put("superseed", "0")
+	}
+	t.st.putSettings(t.ih, s)
+	return nil
+}
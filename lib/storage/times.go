@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"strconv"
+	"time"
+)
+
+// get a unix time from a torrent's settings, zero if it is not set
+func (s *fsSettings) getTime(key string) (tm time.Time) {
+	sec, err := strconv.ParseInt(s.Get(key, ""), 10, 64)
+	if err == nil && sec > 0 {
+		tm = time.Unix(sec, 0)
+	}
+	return
+}
+
+func (s *fsSettings) putTime(key string, tm time.Time) {
+	s.Put(key, strconv.FormatInt(tm.Unix(), 10))
+}
+
+// remember when we first saw a torrent
+// torrents from before we kept track were added when their metainfo was written
+func (st *FsStorage) ensureAdded(ih common.Infohash) {
+	s := st.getSettings(ih)
+	if !s.getTime("added").IsZero() {
+		return
+	}
+	tm := time.Now()
+	fi, err := st.FS.Stat(st.metainfoFilename(ih))
+	if err == nil {
+		tm = fi.ModTime()
+	}
+	s.putTime("added", tm)
+	st.putSettings(ih, s)
+}
+
+func (t *fsTorrent) AddedAt() time.Time {
+	s := t.st.getSettings(t.ih)
+	return s.getTime("added")
+}
+
+func (t *fsTorrent) CompletedAt() time.Time {
+	s := t.st.getSettings(t.ih)
+	return s.getTime("completed")
+}
+
+// remember when we first completed the torrent
+func (t *fsTorrent) markCompleted() {
+	s := t.st.getSettings(t.ih)
+	if s.getTime("completed").IsZero() {
+		s.putTime("completed", time.Now())
+		t.st.putSettings(t.ih, s)
+	}
+}
+
+func (t *fsTorrent) LastActive() (tm time.Time) {
+	s := t.st.getSettings(t.ih)
+	tm = s.getTime("active")
+	if tm.IsZero() {
+		// we used to only know when we last saved stats
+		fi, err := t.st.FS.Stat(t.st.statsFilename(t.ih))
+		if err == nil {
+			tm = fi.ModTime()
+		}
+	}
+	return
+}
+
+func (t *fsTorrent) SetLastActive(tm time.Time) error {
+	s := t.st.getSettings(t.ih)
+	s.putTime("active", tm)
+	t.st.putSettings(t.ih, s)
+	return nil
+}
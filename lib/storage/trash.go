@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/fs"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/metainfo"
+	"strconv"
+	"time"
+)
+
+// DefaultTrashPurgeAfter is how long deleted torrents stay in the trash by default
+const DefaultTrashPurgeAfter = time.Hour * 24 * 7
+
+// ErrNotInTrash is returned when restoring a torrent that is not in the trash
+var ErrNotInTrash = errors.New("torrent is not in the trash")
+
+// ErrNoTrash is returned when restoring a torrent while the trash is disabled
+var ErrNoTrash = errors.New("trash is disabled")
+
+// how often we look for expired torrents in the trash
+const trashPurgeInterval = time.Minute * 10
+
+func (st *FsStorage) trashEnabled() bool {
+	return st.TrashDir != ""
+}
+
+// directory a deleted torrent is kept in
+func (st *FsStorage) trashDirFor(ih common.Infohash) string {
+	return st.FS.Join(st.TrashDir, ih.Hex())
+}
+
+// names of the metadata files we keep for a torrent
+func (st *FsStorage) metadataFilenames(ih common.Infohash) []string {
+	return []string{
+		ih.Hex() + ".torrent",
+		ih.Hex() + ".bitfield",
+		ih.Hex() + ".settings",
+		ih.Hex() + ".stats",
+		ih.Hex() + ".journal",
+	}
+}
+
+// move a torrent's data and metadata into the trash
+func (t *fsTorrent) moveToTrash() (err error) {
+	st := t.st
+	dir := st.trashDirFor(t.ih)
+	err = st.FS.EnsureDir(dir)
+	if err != nil {
+		return
+	}
+	s := st.getSettings(t.ih)
+	s.Put("dir", t.dir)
+	s.Put("deleted", fmt.Sprintf("%d", time.Now().Unix()))
+	st.putSettings(t.ih, s)
+	if t.meta != nil && st.FS.FileExists(t.FilePath()) {
+		err = st.FS.Move(t.FilePath(), st.FS.Join(dir, t.meta.Info.Path))
+	}
+	for _, name := range st.metadataFilenames(t.ih) {
+		if err != nil {
+			break
+		}
+		fpath := st.FS.Join(st.MetaDir, name)
+		if st.FS.FileExists(fpath) {
+			err = st.FS.Move(fpath, st.FS.Join(dir, name))
+		}
+	}
+	if err == nil {
+		log.Infof("moved %s to trash", t.Name())
+	}
+	return
+}
+
+// RestoreTorrent moves a deleted torrent back out of the trash and opens it
+func (st *FsStorage) RestoreTorrent(ih common.Infohash) (t Torrent, err error) {
+	if !st.trashEnabled() {
+		err = ErrNoTrash
+		return
+	}
+	dir := st.trashDirFor(ih)
+	if !st.FS.FileExists(st.FS.Join(dir, ih.Hex()+".torrent")) {
+		err = ErrNotInTrash
+		return
+	}
+	var s fsSettings
+	s, err = st.trashSettings(ih)
+	if err != nil {
+		return
+	}
+	info := new(metainfo.TorrentFile)
+	var f fs.ReadFile
+	f, err = st.FS.OpenFileReadOnly(st.FS.Join(dir, ih.Hex()+".torrent"))
+	if err == nil {
+		err = info.BDecode(f)
+		f.Close()
+	}
+	if err != nil {
+		return
+	}
+	datadir := s.Get("dir", st.DataDir)
+	data := st.FS.Join(dir, info.Info.Path)
+	if st.FS.FileExists(data) {
+		err = st.FS.Move(data, st.FS.Join(datadir, info.Info.Path))
+	}
+	for _, name := range st.metadataFilenames(ih) {
+		if err != nil {
+			return
+		}
+		fpath := st.FS.Join(dir, name)
+		if st.FS.FileExists(fpath) {
+			err = st.FS.Move(fpath, st.FS.Join(st.MetaDir, name))
+		}
+	}
+	if err == nil {
+		err = st.FS.RemoveAll(dir)
+	}
+	if err == nil {
+		t, err = st.openTorrent(info, datadir)
+	}
+	return
+}
+
+// read the settings of a torrent in the trash
+func (st *FsStorage) trashSettings(ih common.Infohash) (s fsSettings, err error) {
+	s = createSettings()
+	fpath := st.FS.Join(st.trashDirFor(ih), ih.Hex()+".settings")
+	if !st.FS.FileExists(fpath) {
+		return
+	}
+	var f fs.ReadFile
+	f, err = st.FS.OpenFileReadOnly(fpath)
+	if err == nil {
+		err = s.BDecode(f)
+		f.Close()
+	}
+	return
+}
+
+// PurgeTrash removes torrents that have been in the trash for longer than TrashPurgeAfter
+func (st *FsStorage) PurgeTrash() (err error) {
+	if !st.trashEnabled() {
+		return
+	}
+	purgeAfter := st.TrashPurgeAfter
+	if purgeAfter <= 0 {
+		purgeAfter = DefaultTrashPurgeAfter
+	}
+	var matches []string
+	matches, err = st.FS.Glob(st.FS.Join(st.TrashDir, "*", "*.settings"))
+	now := time.Now()
+	for _, m := range matches {
+		_, fname := st.FS.Split(m)
+		ih, e := common.DecodeInfohash(fname[:len(fname)-len(".settings")])
+		if e != nil {
+			continue
+		}
+		s, e := st.trashSettings(ih)
+		if e != nil {
+			continue
+		}
+		deleted, e := strconv.ParseInt(s.Get("deleted", "0"), 10, 64)
+		if e != nil {
+			continue
+		}
+		if now.Sub(time.Unix(deleted, 0)) > purgeAfter {
+			log.Infof("purging %s from trash", ih.Hex())
+			e = st.FS.RemoveAll(st.trashDirFor(ih))
+			if e != nil {
+				log.Errorf("failed to purge %s from trash: %s", ih.Hex(), e.Error())
+			}
+		}
+	}
+	return
+}
+
+func (st *FsStorage) runTrashPurge() {
+	for {
+		err := st.PurgeTrash()
+		if err != nil {
+			log.Warnf("trash purge: %s", err.Error())
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
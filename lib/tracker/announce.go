@@ -1,8 +1,11 @@
 package tracker
 
 import (
+	"errors"
+	"fmt"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/network"
+	"net"
 	"net/url"
 	"time"
 )
@@ -32,27 +35,92 @@ type Request struct {
 }
 
 type Response struct {
-	Interval     int           `bencode:"interval"`
-	Peers        []common.Peer `bencode:"peers"`
-	Error        string        `bencode:"failure reason"`
-	NextAnnounce time.Time     `bencode:"-"`
+	// seconds the tracker wants between announces, 0 if it did not say
+	Interval int `bencode:"interval"`
+	// shortest interval we may announce at, 0 if the tracker did not say
+	MinInterval int           `bencode:"min interval"`
+	Peers       []common.Peer `bencode:"peers"`
+	Error       string        `bencode:"failure reason"`
+	// non fatal message from the tracker
+	Warning string `bencode:"warning message"`
+	// number of seeders, -1 if the tracker did not say
+	Complete int `bencode:"complete"`
+	// number of leechers, -1 if the tracker did not say
+	Incomplete   int       `bencode:"incomplete"`
+	NextAnnounce time.Time `bencode:"-"`
+}
+
+// DefaultInterval is how often we announce to a tracker that didn't say how often it wants us to
+const DefaultInterval = time.Minute * 30
+
+// RetryInterval is how long we wait to announce again to a tracker that failed
+const RetryInterval = time.Minute
+
+// set when to announce next after an announce, when the tracker asks us to if it worked
+func (resp *Response) setNextAnnounce(err error) {
+	d := DefaultInterval
+	if err != nil {
+		d = RetryInterval
+	} else if resp.Interval > 0 {
+		d = time.Duration(resp.Interval) * time.Second
+	}
+	resp.NextAnnounce = time.Now().Add(d)
+}
+
+// ErrScrapeNotSupported is returned when a tracker has no scrape endpoint
+var ErrScrapeNotSupported = errors.New("tracker does not support scrape")
+
+// ErrTrackerTimeout is wrapped by errors for trackers that never replied
+var ErrTrackerTimeout = errors.New("tracker did not reply")
+
+// wrap err with ErrTrackerTimeout if it is a network timeout
+func timeoutError(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return fmt.Errorf("%w: %s", ErrTrackerTimeout, err)
+	}
+	return err
+}
+
+type ScrapeRequest struct {
+	Infohashes []common.Infohash
+	GetNetwork func() network.Network
+}
+
+// ScrapeStats is what a tracker knows about one torrent
+type ScrapeStats struct {
+	// number of seeders
+	Complete int `bencode:"complete"`
+	// number of times the torrent was downloaded
+	Downloaded int `bencode:"downloaded"`
+	// number of leechers
+	Incomplete int `bencode:"incomplete"`
+}
+
+type ScrapeResponse struct {
+	Files map[common.Infohash]ScrapeStats
 }
 
 // bittorrent announcer, gets peers and announces presence in swarm
 type Announcer interface {
 	// announce and get peers
 	Announce(req *Request) (*Response, error)
+	// get swarm stats for torrents without announcing
+	// returns ErrScrapeNotSupported if the tracker cannot do that
+	Scrape(req *ScrapeRequest) (*ScrapeResponse, error)
 	// name of this tracker
 	Name() string
 }
 
-// get announcer from url
+// get announcer from url, trackers off our network are reached as the proxy settings say
 // returns nil if invalid url
-func FromURL(str string) Announcer {
+func FromURL(str string, proxy *ProxySettings) Announcer {
 	u, err := url.Parse(str)
 	if err == nil {
 		if u.Scheme == "http" {
-			return NewHttpTracker(u)
+			return NewHttpTracker(u, proxy)
+		}
+		if u.Scheme == "udp" && checkUDPURL(u) == nil {
+			return NewUDPTracker(u, proxy)
 		}
 	}
 	return nil
@@ -0,0 +1,122 @@
+package tracker
+
+import (
+	"github.com/majestrate/XD/lib/sync"
+	"time"
+)
+
+// DefaultBatchWindow is how long an announce waits for announces of other torrents to the same tracker to go with it
+const DefaultBatchWindow = time.Second * 2
+
+// MaxBatch is the most announces we send to a tracker at once
+const MaxBatch = 32
+
+// BatchAnnouncer is an Announcer that can send announces of many torrents in fewer round trips
+type BatchAnnouncer interface {
+	Announcer
+	// announce every request, the responses and errors line up with reqs
+	// a response is never nil, like with Announce
+	AnnounceBatch(reqs []*Request) ([]*Response, []error)
+}
+
+// Batcher collects announces to the same tracker made around the same time and sends them together
+type Batcher struct {
+	access sync.Mutex
+	// how long announces wait for others, announces go out one at a time if 0
+	window time.Duration
+	// batches waiting to go out by tracker name
+	pending map[string]*pendingBatch
+}
+
+type batchResult struct {
+	resp *Response
+	err  error
+}
+
+type pendingBatch struct {
+	tr      BatchAnnouncer
+	reqs    []*Request
+	waiters []chan batchResult
+}
+
+// NewBatcher makes a Batcher where announces wait up to window for others
+func NewBatcher(window time.Duration) *Batcher {
+	return &Batcher{
+		window:  window,
+		pending: make(map[string]*pendingBatch),
+	}
+}
+
+// SetWindow sets how long announces wait for others, 0 sends them one at a time
+func (b *Batcher) SetWindow(window time.Duration) {
+	b.access.Lock()
+	b.window = window
+	b.access.Unlock()
+}
+
+// Wrap gets an Announcer that batches announces to a, or a itself if a can't take batches and announces one torrent at a time
+func (b *Batcher) Wrap(a Announcer) Announcer {
+	if b == nil {
+		return a
+	}
+	if ba, ok := a.(BatchAnnouncer); ok {
+		return &batchedAnnouncer{BatchAnnouncer: ba, b: b}
+	}
+	return a
+}
+
+type batchedAnnouncer struct {
+	BatchAnnouncer
+	b *Batcher
+}
+
+// Announce waits for other announces to the tracker and sends them all together
+// stopped announces go right away, we are likely shutting down
+func (a *batchedAnnouncer) Announce(req *Request) (*Response, error) {
+	if req.Event == Stopped {
+		return a.BatchAnnouncer.Announce(req)
+	}
+	return a.b.announce(a.BatchAnnouncer, req)
+}
+
+func (b *Batcher) announce(tr BatchAnnouncer, req *Request) (*Response, error) {
+	name := tr.Name()
+	b.access.Lock()
+	if b.window <= 0 {
+		b.access.Unlock()
+		return tr.Announce(req)
+	}
+	p, ok := b.pending[name]
+	if !ok {
+		p = &pendingBatch{tr: tr}
+		b.pending[name] = p
+		time.AfterFunc(b.window, func() {
+			b.flush(name, p)
+		})
+	}
+	result := make(chan batchResult, 1)
+	p.reqs = append(p.reqs, req)
+	p.waiters = append(p.waiters, result)
+	full := len(p.reqs) >= MaxBatch
+	b.access.Unlock()
+	if full {
+		go b.flush(name, p)
+	}
+	r := <-result
+	return r.resp, r.err
+}
+
+// send the batch p to tracker name if it didn't go out yet
+func (b *Batcher) flush(name string, p *pendingBatch) {
+	b.access.Lock()
+	if b.pending[name] != p {
+		b.access.Unlock()
+		return
+	}
+	delete(b.pending, name)
+	b.access.Unlock()
+	resps, errs := p.tr.AnnounceBatch(p.reqs)
+	for idx := range p.reqs {
+		p.waiters[idx] <- batchResult{resps[idx], errs[idx]}
+	}
+}
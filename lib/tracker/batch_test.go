@@ -0,0 +1,70 @@
+package tracker
+
+import (
+	"github.com/majestrate/XD/lib/common"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tracker that remembers how many announces came in each batch
+type batchTestTracker struct {
+	mtx     sync.Mutex
+	batches []int
+	singles int
+}
+
+func (t *batchTestTracker) Name() string {
+	return "batch"
+}
+
+func (t *batchTestTracker) Announce(req *Request) (*Response, error) {
+	t.mtx.Lock()
+	t.singles++
+	t.mtx.Unlock()
+	return &Response{Interval: 60}, nil
+}
+
+func (t *batchTestTracker) AnnounceBatch(reqs []*Request) (resps []*Response, errs []error) {
+	t.mtx.Lock()
+	t.batches = append(t.batches, len(reqs))
+	t.mtx.Unlock()
+	for _, req := range reqs {
+		resps = append(resps, &Response{Interval: int(req.Infohash[0])})
+		errs = append(errs, nil)
+	}
+	return
+}
+
+func (t *batchTestTracker) Scrape(req *ScrapeRequest) (*ScrapeResponse, error) {
+	return nil, ErrScrapeNotSupported
+}
+
+func TestBatcher(t *testing.T) {
+	tr := &batchTestTracker{}
+	b := NewBatcher(time.Millisecond * 100)
+	a := b.Wrap(tr)
+	var wg sync.WaitGroup
+	for idx := 1; idx <= 3; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			var ih common.Infohash
+			ih[0] = byte(idx)
+			resp, err := a.Announce(&Request{Infohash: ih})
+			if err != nil || resp.Interval != idx {
+				t.Errorf("announce %d got %+v %v", idx, resp, err)
+			}
+		}(idx)
+	}
+	wg.Wait()
+	if len(tr.batches) != 1 || tr.batches[0] != 3 {
+		t.Fatalf("announces went in batches of %v", tr.batches)
+	}
+	a.Announce(&Request{Event: Stopped})
+	b.SetWindow(0)
+	a.Announce(&Request{})
+	if tr.singles != 2 || len(tr.batches) != 1 {
+		t.Fatalf("stopped and unbatched announces were batched")
+	}
+}
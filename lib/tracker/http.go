@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"github.com/majestrate/XD/lib/common"
 	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
 	"github.com/majestrate/XD/lib/sync"
 	"github.com/zeebo/bencode"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -25,79 +28,136 @@ type HttpTracker struct {
 	resolveInterval time.Duration
 	// currently resolving the address ?
 	resolving sync.Mutex
+	// how we reach this tracker
+	route Route
+	// proxy settings used when not reaching the tracker over our network
+	proxy *ProxySettings
+	// the tracker refused a scrape of many infohashes so we scrape one at a time, guarded by access
+	singleScrape bool
+	access       sync.Mutex
 }
 
+// most infohashes we scrape in one request, trackers refuse longer urls
+const httpMaxScrape = 50
+
+// the tracker refused a scrape of many infohashes as too big or malformed
+var errScrapeTooMany = errors.New("tracker refused a scrape of many torrents")
+
 // create new http tracker from url
-func NewHttpTracker(u *url.URL) *HttpTracker {
+func NewHttpTracker(u *url.URL, proxy *ProxySettings) *HttpTracker {
 	t := &HttpTracker{
 		u:               u,
 		resolveInterval: time.Hour,
 		lastResolved:    time.Unix(0, 0),
+		route:           proxy.RouteFor(u),
+		proxy:           proxy,
 	}
 
 	return t
 }
 
+// Route returns how we reach this tracker
+func (t *HttpTracker) Route() Route {
+	return t.route
+}
+
 func (t *HttpTracker) shouldResolve() bool {
 	return t.lastResolved.Add(t.resolveInterval).Before(time.Now())
 }
 
 // http compact response
 type compactHttpAnnounceResponse struct {
-	Peers    interface{} `bencode:"peers"`
-	Interval int         `bencode:"interval"`
-	Error    string      `bencode:"failure reason"`
+	Peers       interface{} `bencode:"peers"`
+	Interval    int         `bencode:"interval"`
+	MinInterval int         `bencode:"min interval"`
+	Error       string      `bencode:"failure reason"`
+	Warning     string      `bencode:"warning message"`
+	Complete    int         `bencode:"complete"`
+	Incomplete  int         `bencode:"incomplete"`
+}
+
+// http scrape response
+type httpScrapeResponse struct {
+	Files map[string]ScrapeStats `bencode:"files"`
+	Error string                 `bencode:"failure reason"`
 }
 
 func (t *HttpTracker) Name() string {
 	return t.u.String()
 }
 
+// dial the tracker over our network, resolving and caching its address
+func (t *HttpTracker) dialNetwork(n network.Network) func(string, string) (net.Conn, error) {
+	return func(_, _ string) (c net.Conn, e error) {
+		var a net.Addr
+		t.resolving.Lock()
+		if t.shouldResolve() {
+			var h, p string
+			// XXX: hack
+			if strings.Index(t.u.Host, ":") == -1 {
+				t.u.Host += ":80"
+			}
+			h, p, e = net.SplitHostPort(t.u.Host)
+			if e == nil {
+				a, e = n.Lookup(h, p)
+				if e == nil {
+					t.addr = a
+					t.lastResolved = time.Now()
+				}
+			}
+		} else {
+			a = t.addr
+		}
+		t.resolving.Unlock()
+		if e == nil {
+			c, e = n.Dial(a.Network(), a.String())
+		}
+		return
+	}
+}
+
+// get the pooled http transport used to reach this tracker
+func (t *HttpTracker) transport(n network.Network) (*http.Transport, error) {
+	host := t.u.Host
+	if strings.Index(host, ":") == -1 {
+		host += ":80"
+	}
+	return transports.get(t.route, host, n, func() (*http.Transport, error) {
+		return t.newTransport(n)
+	})
+}
+
+// make a new http transport to reach this tracker
+func (t *HttpTracker) newTransport(n network.Network) (tr *http.Transport, err error) {
+	if t.route == RouteNetwork {
+		tr = &http.Transport{
+			Dial: t.dialNetwork(n),
+		}
+		return
+	}
+	return t.proxy.proxyTransport(t.route, n)
+}
+
 // send announce via http request
 func (t *HttpTracker) Announce(req *Request) (resp *Response, err error) {
 	//if req == nil {
 	//	return
 	//}
-	// http client
-	var client http.Client
-
-	client.Transport = &http.Transport{
-		Dial: func(_, _ string) (c net.Conn, e error) {
-			var a net.Addr
-			t.resolving.Lock()
-			if t.shouldResolve() {
-				var h, p string
-				// XXX: hack
-				if strings.Index(t.u.Host, ":") == -1 {
-					t.u.Host += ":80"
-				}
-				h, p, e = net.SplitHostPort(t.u.Host)
-				if e == nil {
-					a, e = req.GetNetwork().Lookup(h, p)
-					if e == nil {
-						t.addr = a
-						t.lastResolved = time.Now()
-					}
-				}
-			} else {
-				a = t.addr
-			}
-			t.resolving.Unlock()
-			if e == nil {
-				c, e = req.GetNetwork().Dial(a.Network(), a.String())
-			}
-			return
-		},
+	resp = &Response{
+		Complete:   -1,
+		Incomplete: -1,
 	}
-
-	resp = new(Response)
-	interval := 30
+	n := req.GetNetwork()
+	// http client, reuses connections to the tracker host
+	var client http.Client
+	client.Transport, err = t.transport(n)
 	// build query
 	var u *url.URL
-	u, err = url.Parse(t.u.String())
+	if err == nil {
+		u, err = url.Parse(t.u.String())
+	}
 	if err == nil {
 		v := u.Query()
-		n := req.GetNetwork()
 		a := n.Addr()
 		host, _, _ := net.SplitHostPort(a.String())
 		if a.Network() == "i2p" {
@@ -125,14 +185,26 @@ func (t *HttpTracker) Announce(req *Request) (resp *Response, err error) {
 		var r *http.Response
 		log.Debugf("%s announcing", t.Name())
 		r, err = client.Get(u.String())
+		err = timeoutError(err)
 		if err == nil {
-			defer r.Body.Close()
+			defer func() {
+				// drain the body so the connection can be reused
+				io.Copy(ioutil.Discard, r.Body)
+				r.Body.Close()
+			}()
 			dec := bencode.NewDecoder(r.Body)
 			if req.Compact {
-				cresp := new(compactHttpAnnounceResponse)
+				cresp := &compactHttpAnnounceResponse{
+					Complete:   -1,
+					Incomplete: -1,
+				}
 				err = dec.Decode(cresp)
 				if err == nil {
-					interval = cresp.Interval
+					resp.Interval = cresp.Interval
+					resp.MinInterval = cresp.MinInterval
+					resp.Warning = cresp.Warning
+					resp.Complete = cresp.Complete
+					resp.Incomplete = cresp.Incomplete
 					var cpeers string
 
 					_, ok := cresp.Peers.(string)
@@ -167,13 +239,13 @@ func (t *HttpTracker) Announce(req *Request) (resp *Response, err error) {
 					}
 
 					if len(cresp.Error) > 0 {
+						resp.Error = cresp.Error
 						err = errors.New(cresp.Error)
 					}
 				}
 			} else {
 				// decode non compact response
 				err = dec.Decode(resp)
-				interval = resp.Interval
 				if len(resp.Error) > 0 {
 					err = errors.New(resp.Error)
 				}
@@ -183,12 +255,161 @@ func (t *HttpTracker) Announce(req *Request) (resp *Response, err error) {
 
 	if err == nil {
 		log.Infof("%s got %d peers for %s", t.Name(), len(resp.Peers), req.Infohash.Hex())
+		if resp.Warning != "" {
+			log.Warnf("%s warning for %s: %s", t.Name(), req.Infohash.Hex(), resp.Warning)
+		}
 	} else {
 		log.Warnf("%s got error while announcing: %s", t.Name(), err)
 	}
-	if interval == 0 {
-		interval = 60
+	resp.setNextAnnounce(err)
+	return
+}
+
+// AnnounceBatch sends the announces over the connections we keep to the tracker host, as many at a time as we keep connections
+// http can't announce many torrents in one request but they all share the connections we set up once
+func (t *HttpTracker) AnnounceBatch(reqs []*Request) (resps []*Response, errs []error) {
+	resps = make([]*Response, len(reqs))
+	errs = make([]error, len(reqs))
+	log.Debugf("%s announcing %d torrents at once", t.Name(), len(reqs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < DefaultMaxConnsPerHost && w < len(reqs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				resps[idx], errs[idx] = t.Announce(reqs[idx])
+			}
+		}()
+	}
+	for idx := range reqs {
+		next <- idx
+	}
+	close(next)
+	wg.Wait()
+	return
+}
+
+// scrapeURL returns the scrape url for this tracker, nil if it has none
+// the last path element must start with "announce" (or be "a" like opentracker's short form)
+func (t *HttpTracker) scrapeURL() *url.URL {
+	u, err := url.Parse(t.u.String())
+	if err != nil {
+		return nil
+	}
+	idx := strings.LastIndex(u.Path, "/")
+	if idx == -1 {
+		return nil
+	}
+	last := u.Path[idx+1:]
+	if strings.HasPrefix(last, "announce") {
+		u.Path = u.Path[:idx+1] + "scrape" + last[len("announce"):]
+	} else if last == "a" {
+		u.Path = u.Path[:idx+1] + "s"
+	} else {
+		return nil
+	}
+	return u
+}
+
+// Scrape gets swarm stats for torrents from the tracker's scrape endpoint
+// many infohashes go in a few requests, one at a time if the tracker can't do more
+func (t *HttpTracker) Scrape(req *ScrapeRequest) (resp *ScrapeResponse, err error) {
+	u := t.scrapeURL()
+	if u == nil {
+		err = ErrScrapeNotSupported
+		return
+	}
+	var client http.Client
+	client.Transport, err = t.transport(req.GetNetwork())
+	if err != nil {
+		return
+	}
+	resp = &ScrapeResponse{
+		Files: make(map[common.Infohash]ScrapeStats),
+	}
+	ihs := req.Infohashes
+	for len(ihs) > 0 {
+		chunk := ihs
+		t.access.Lock()
+		single := t.singleScrape
+		t.access.Unlock()
+		if single {
+			chunk = chunk[:1]
+		} else if len(chunk) > httpMaxScrape {
+			chunk = chunk[:httpMaxScrape]
+		}
+		var files map[common.Infohash]ScrapeStats
+		files, err = t.scrapeOnce(&client, u, chunk)
+		if err == errScrapeTooMany && len(chunk) > 1 {
+			// try this chunk again one at a time
+			log.Infof("%s refused to scrape %d torrents at once, scraping one at a time", t.Name(), len(chunk))
+			t.access.Lock()
+			t.singleScrape = true
+			t.access.Unlock()
+			continue
+		}
+		if err != nil {
+			resp = nil
+			return
+		}
+		for ih, st := range files {
+			resp.Files[ih] = st
+		}
+		ihs = ihs[len(chunk):]
+	}
+	return
+}
+
+// scrape infohashes in one request to the scrape endpoint u
+func (t *HttpTracker) scrapeOnce(client *http.Client, u *url.URL, infohashes []common.Infohash) (files map[common.Infohash]ScrapeStats, err error) {
+	u, err = url.Parse(u.String())
+	if err != nil {
+		return
+	}
+	v := u.Query()
+	for _, ih := range infohashes {
+		v.Add("info_hash", string(ih.Bytes()))
+	}
+	u.RawQuery = v.Encode()
+	var r *http.Response
+	log.Debugf("%s scraping %d torrents", t.Name(), len(infohashes))
+	r, err = client.Get(u.String())
+	if err != nil {
+		err = timeoutError(err)
+		return
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, r.Body)
+		r.Body.Close()
+	}()
+	switch r.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusRequestURITooLong:
+		if len(infohashes) > 1 {
+			err = errScrapeTooMany
+			return
+		}
+		fallthrough
+	default:
+		err = fmt.Errorf("scrape failed: %s", r.Status)
+		return
+	}
+	sresp := new(httpScrapeResponse)
+	err = bencode.NewDecoder(r.Body).Decode(sresp)
+	if err == nil && len(sresp.Error) > 0 {
+		err = errors.New(sresp.Error)
+	}
+	if err == nil {
+		files = make(map[common.Infohash]ScrapeStats)
+		for k, st := range sresp.Files {
+			if len(k) != 20 {
+				continue
+			}
+			var ih common.Infohash
+			copy(ih[:], k)
+			files[ih] = st
+		}
 	}
-	resp.NextAnnounce = time.Now().Add(time.Second * time.Duration(interval))
 	return
 }
@@ -0,0 +1,134 @@
+package tracker
+
+import (
+	"fmt"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// network that dials tcp on loopback
+type httpTestNetwork struct {
+	network.Network
+}
+
+func (n httpTestNetwork) Dial(network, addr string) (net.Conn, error) {
+	return net.Dial(network, addr)
+}
+
+func (n httpTestNetwork) Lookup(host, port string) (net.Addr, error) {
+	return net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+}
+
+func (n httpTestNetwork) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881}
+}
+
+// run an http tracker that refuses scrapes of many torrents with status
+func runHTTPTestTracker(status int) (srv *httptest.Server, tr *HttpTracker, announces *int) {
+	var mtx sync.Mutex
+	announces = new(int)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ihs := r.URL.Query()["info_hash"]
+		switch r.URL.Path {
+		case "/announce":
+			mtx.Lock()
+			*announces++
+			mtx.Unlock()
+			fmt.Fprint(w, "d8:intervali60e5:peers0:e")
+		case "/scrape":
+			if len(ihs) > 1 {
+				w.WriteHeader(status)
+				return
+			}
+			fmt.Fprintf(w, "d5:filesd20:%sd8:completei1e10:downloadedi2e10:incompletei3eeee", ihs[0])
+		}
+	}))
+	u, _ := url.Parse(srv.URL + "/announce")
+	tr = NewHttpTracker(u, &ProxySettings{Rules: []ProxyRule{{Pattern: "127.0.0.1", Route: RouteNetwork}}})
+	return
+}
+
+func TestHTTPScrapeFallback(t *testing.T) {
+	getNetwork := func() network.Network {
+		return httpTestNetwork{}
+	}
+	var ihs []common.Infohash
+	for idx := 0; idx < 3; idx++ {
+		var ih common.Infohash
+		ih[0] = byte(idx + 1)
+		ihs = append(ihs, ih)
+	}
+	srv, tr, _ := runHTTPTestTracker(http.StatusRequestURITooLong)
+	defer srv.Close()
+	resp, err := tr.Scrape(&ScrapeRequest{Infohashes: ihs, GetNetwork: getNetwork})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Files) != len(ihs) || resp.Files[ihs[2]].Incomplete != 3 || !tr.singleScrape {
+		t.Fatalf("scraped %+v one at a time %v", resp.Files, tr.singleScrape)
+	}
+	// a tracker failing for another reason doesn't mean it can't take many
+	srv, tr, _ = runHTTPTestTracker(http.StatusInternalServerError)
+	defer srv.Close()
+	_, err = tr.Scrape(&ScrapeRequest{Infohashes: ihs, GetNetwork: getNetwork})
+	if err == nil || tr.singleScrape {
+		t.Fatalf("failed scrape got %v, one at a time %v", err, tr.singleScrape)
+	}
+}
+
+func TestHTTPAnnounceBatch(t *testing.T) {
+	srv, tr, announces := runHTTPTestTracker(http.StatusOK)
+	defer srv.Close()
+	var reqs []*Request
+	for idx := 0; idx < 5; idx++ {
+		reqs = append(reqs, &Request{
+			Port: 6881,
+			GetNetwork: func() network.Network {
+				return httpTestNetwork{}
+			},
+		})
+	}
+	b := NewBatcher(0)
+	if _, ok := b.Wrap(tr).(*batchedAnnouncer); !ok {
+		t.Fatal("http tracker isn't batched")
+	}
+	resps, errs := tr.AnnounceBatch(reqs)
+	for idx := range reqs {
+		if errs[idx] != nil || resps[idx].Interval != 60 {
+			t.Fatalf("announce %d got %+v %v", idx, resps[idx], errs[idx])
+		}
+	}
+	if *announces != len(reqs) {
+		t.Fatalf("tracker got %d announces", *announces)
+	}
+}
+
+func TestScrapeURL(t *testing.T) {
+	cases := map[string]string{
+		"http://tracker.i2p/announce":         "http://tracker.i2p/scrape",
+		"http://tracker.i2p/x/announce.php":   "http://tracker.i2p/x/scrape.php",
+		"http://tracker.i2p/a":                "http://tracker.i2p/s",
+		"http://tracker.i2p/announce?pk=1234": "http://tracker.i2p/scrape?pk=1234",
+		"http://tracker.i2p/x/ann":            "",
+	}
+	for announce, scrape := range cases {
+		u, _ := url.Parse(announce)
+		tr := NewHttpTracker(u, nil)
+		s := tr.scrapeURL()
+		if scrape == "" {
+			if s != nil {
+				t.Errorf("%s should not have a scrape url but got %s", announce, s)
+			}
+			continue
+		}
+		if s == nil || s.String() != scrape {
+			t.Errorf("%s: expected scrape url %s got %v", announce, scrape, s)
+		}
+	}
+}
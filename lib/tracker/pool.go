@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/sync"
+	"net/http"
+	"time"
+)
+
+// DefaultMaxConnsPerHost is how many connections we keep open to one tracker host
+const DefaultMaxConnsPerHost = 2
+
+// DefaultIdleConnTimeout is how long an idle tracker connection stays open for reuse
+const DefaultIdleConnTimeout = time.Minute * 5
+
+// http transport shared by all trackers on one host so announces reuse connections,
+// setting up a new i2p stream dominates announce latency
+type hostTransport struct {
+	// network the transport dials over, we make a new transport when it changes
+	n  network.Network
+	tr *http.Transport
+	// when a tracker last asked for it
+	used time.Time
+}
+
+type transportPool struct {
+	access sync.Mutex
+	hosts  map[string]*hostTransport
+}
+
+var transports = &transportPool{
+	hosts: make(map[string]*hostTransport),
+}
+
+// key of the transport for a host reached by route over network n, the same for every session of the network
+func poolKey(route Route, host string, n network.Network) string {
+	return n.Addr().Network() + "|" + route.String() + "|" + host
+}
+
+// get the pooled transport for a host reached by route over network n, making it with mk if needed
+func (p *transportPool) get(route Route, host string, n network.Network, mk func() (*http.Transport, error)) (tr *http.Transport, err error) {
+	key := poolKey(route, host, n)
+	now := time.Now()
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.expire(now)
+	ht, ok := p.hosts[key]
+	if ok && ht.n == n {
+		ht.used = now
+		tr = ht.tr
+		return
+	}
+	if ok {
+		// new session of the network, the old connections are dead
+		ht.tr.CloseIdleConnections()
+		delete(p.hosts, key)
+	}
+	tr, err = mk()
+	if err == nil {
+		tr.MaxIdleConnsPerHost = DefaultMaxConnsPerHost
+		tr.MaxConnsPerHost = DefaultMaxConnsPerHost
+		tr.IdleConnTimeout = DefaultIdleConnTimeout
+		p.hosts[key] = &hostTransport{
+			n:    n,
+			tr:   tr,
+			used: now,
+		}
+	}
+	return
+}
+
+// drop transports no tracker asked for since DefaultIdleConnTimeout before now, their connections are closed by then
+// p.access must be held
+func (p *transportPool) expire(now time.Time) {
+	for key, ht := range p.hosts {
+		if now.Sub(ht.used) > DefaultIdleConnTimeout {
+			ht.tr.CloseIdleConnections()
+			delete(p.hosts, key)
+		}
+	}
+}
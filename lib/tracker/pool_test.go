@@ -0,0 +1,37 @@
+package tracker
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransportPool(t *testing.T) {
+	p := &transportPool{hosts: make(map[string]*hostTransport)}
+	made := 0
+	mk := func() (*http.Transport, error) {
+		made++
+		return &http.Transport{}, nil
+	}
+	n := httpTestNetwork{}
+	first, _ := p.get(RouteNetwork, "tracker:80", n, mk)
+	again, _ := p.get(RouteNetwork, "tracker:80", n, mk)
+	if made != 1 || first != again {
+		t.Fatalf("made %d transports for one host", made)
+	}
+	p.get(RouteNetwork, "other:80", n, mk)
+	if made != 2 || len(p.hosts) != 2 {
+		t.Fatalf("made %d transports for two hosts", made)
+	}
+	// nobody asked for the first host in a while
+	p.hosts[poolKey(RouteNetwork, "tracker:80", n)].used = time.Now().Add(-DefaultIdleConnTimeout * 2)
+	p.access.Lock()
+	p.expire(time.Now())
+	p.access.Unlock()
+	if len(p.hosts) != 1 {
+		t.Fatalf("%d transports after expiring an idle one", len(p.hosts))
+	}
+	if tr, _ := p.get(RouteNetwork, "tracker:80", n, mk); tr == first || made != 3 {
+		t.Fatal("reused an expired transport")
+	}
+}
@@ -0,0 +1,171 @@
+package tracker
+
+import (
+	"errors"
+	"github.com/majestrate/XD/lib/network"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Route says how we reach a tracker
+type Route string
+
+// RouteNetwork dials the tracker over the swarm's network
+const RouteNetwork = Route("network")
+
+// RouteOutproxy sends the announce through an http outproxy reached over the swarm's network
+const RouteOutproxy = Route("outproxy")
+
+// RouteSOCKS sends the announce through a local socks5 proxy
+const RouteSOCKS = Route("socks")
+
+// RouteBlock never announces to the tracker, only a rule routes a tracker here
+const RouteBlock = Route("block")
+
+func (r Route) String() string {
+	return string(r)
+}
+
+// ParseRoute parses a route name, returns false if it is not a valid route
+func ParseRoute(str string) (r Route, ok bool) {
+	r = Route(strings.ToLower(strings.TrimSpace(str)))
+	switch r {
+	case RouteNetwork, RouteOutproxy, RouteSOCKS, RouteBlock:
+		ok = true
+	}
+	return
+}
+
+// ErrTrackerBlocked is returned when we refuse to announce to a tracker
+var ErrTrackerBlocked = errors.New("tracker is blocked by a tracker proxy rule or its proxy is not configured")
+
+// ProxyRule routes announces for trackers matching Pattern
+type ProxyRule struct {
+	// glob matched against the tracker's hostname, or hostname + path if it contains a '/'
+	Pattern string
+	Route   Route
+}
+
+// Matches returns true if this rule applies to a tracker url
+func (r ProxyRule) Matches(u *url.URL) bool {
+	subject := strings.ToLower(u.Hostname())
+	if strings.Index(r.Pattern, "/") != -1 {
+		subject += u.EscapedPath()
+	}
+	matched, _ := path.Match(strings.ToLower(r.Pattern), subject)
+	return matched
+}
+
+// ProxySettings holds how we announce to trackers that are not on our network
+type ProxySettings struct {
+	// send announces to clearnet trackers through a proxy, they go over our network like any other if not
+	Enabled bool
+	// host:port of an http outproxy on our network
+	Outproxy string
+	// host:port of a local socks5 proxy
+	SOCKS string
+	// per tracker routing rules, first match wins
+	Rules []ProxyRule
+}
+
+// returns true if this host lives on one of the overlay networks
+func isOverlayHost(host string) bool {
+	host = strings.ToLower(host)
+	return strings.HasSuffix(host, ".i2p") || strings.HasSuffix(host, ".loki")
+}
+
+// RouteFor determines how to reach the tracker at url u
+func (p *ProxySettings) RouteFor(u *url.URL) Route {
+	if p != nil {
+		for _, rule := range p.Rules {
+			if rule.Matches(u) {
+				return rule.Route
+			}
+		}
+	}
+	if isOverlayHost(u.Hostname()) || p == nil || !p.Enabled {
+		return RouteNetwork
+	}
+	if p.SOCKS != "" {
+		return RouteSOCKS
+	}
+	if p.Outproxy != "" {
+		return RouteOutproxy
+	}
+	// no proxy to send it through
+	return RouteNetwork
+}
+
+// proxyFunc returns the http.Transport proxy function for a route
+func (p *ProxySettings) proxyFunc(r Route) func(*http.Request) (*url.URL, error) {
+	var u *url.URL
+	switch r {
+	case RouteOutproxy:
+		if p != nil && p.Outproxy != "" {
+			u = &url.URL{Scheme: "http", Host: p.Outproxy}
+		}
+	case RouteSOCKS:
+		if p != nil && p.SOCKS != "" {
+			u = &url.URL{Scheme: "socks5", Host: p.SOCKS}
+		}
+	}
+	if u == nil {
+		return nil
+	}
+	return http.ProxyURL(u)
+}
+
+// make an http transport that goes through the proxy for route
+func (p *ProxySettings) proxyTransport(r Route, n network.Network) (tr *http.Transport, err error) {
+	proxy := p.proxyFunc(r)
+	if proxy == nil {
+		err = ErrTrackerBlocked
+		return
+	}
+	switch r {
+	case RouteOutproxy:
+		// the outproxy lives on our network
+		tr = &http.Transport{
+			Proxy: proxy,
+			Dial: func(_, addr string) (net.Conn, error) {
+				return n.Dial("tcp", addr)
+			},
+		}
+	case RouteSOCKS:
+		// the socks proxy is local
+		tr = &http.Transport{
+			Proxy: proxy,
+		}
+	default:
+		err = ErrTrackerBlocked
+	}
+	return
+}
+
+// HTTPTransport makes an http transport that reaches u the way we would reach a tracker there,
+// for fetching things that are not announces like web seeds
+func (p *ProxySettings) HTTPTransport(u *url.URL, n network.Network) (tr *http.Transport, err error) {
+	r := p.RouteFor(u)
+	if r != RouteNetwork {
+		return p.proxyTransport(r, n)
+	}
+	tr = &http.Transport{
+		Dial: func(_, addr string) (c net.Conn, err error) {
+			var host, port string
+			host, port, err = net.SplitHostPort(addr)
+			if err != nil {
+				return
+			}
+			var a net.Addr
+			a, err = n.Lookup(host, port)
+			if err == nil {
+				c, err = n.Dial(a.Network(), a.String())
+			}
+			return
+		},
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package tracker
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestProxyRouteFor(t *testing.T) {
+	p := &ProxySettings{
+		Enabled: true,
+		SOCKS:   "127.0.0.1:9050",
+		Rules: []ProxyRule{
+			{Pattern: "*.example.org", Route: RouteOutproxy},
+			{Pattern: "bad.example.com/announce*", Route: RouteBlock},
+		},
+	}
+	tests := map[string]Route{
+		"http://tracker.i2p/a":                 RouteNetwork,
+		"http://tracker.example.org/announce":  RouteOutproxy,
+		"http://bad.example.com/announce":      RouteBlock,
+		"http://bad.example.com/other":         RouteSOCKS,
+		"http://opentracker.example.net:80/an": RouteSOCKS,
+	}
+	for str, expected := range tests {
+		u, _ := url.Parse(str)
+		if r := p.RouteFor(u); r != expected {
+			t.Errorf("%s routed via %s not %s", str, r, expected)
+		}
+	}
+	// without a proxy trackers go over our network like they did before we had proxies
+	u, _ := url.Parse("http://tracker.example.org/announce")
+	for _, other := range []*ProxySettings{nil, {}, {Enabled: true}, {SOCKS: "127.0.0.1:9050"}} {
+		if r := other.RouteFor(u); r != RouteNetwork {
+			t.Errorf("clearnet tracker routed via %s with proxy settings %v", r, other)
+		}
+	}
+	blocked := &ProxySettings{Rules: []ProxyRule{{Pattern: "*.example.org", Route: RouteBlock}}}
+	if r := blocked.RouteFor(u); r != RouteBlock {
+		t.Errorf("tracker a rule blocks routed via %s", r)
+	}
+}
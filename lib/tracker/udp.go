@@ -0,0 +1,500 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// bep 15 magic sent with connect requests
+const udpProtocolID = 0x41727101980
+
+// bep 15 actions
+const (
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+)
+
+// bep 15 announce events
+const (
+	udpEventNone      = 0
+	udpEventCompleted = 1
+	udpEventStarted   = 2
+	udpEventStopped   = 3
+)
+
+// DefaultUDPTimeout is how long we wait for the first reply from a udp tracker, it doubles every retransmit
+const DefaultUDPTimeout = time.Second * 15
+
+// DefaultUDPRetries is how many times we retransmit to a udp tracker before giving up
+const DefaultUDPRetries = 8
+
+// DefaultUDPMaxWait is the longest one exchange with a udp tracker takes before we give up.
+// bep 15 retransmits for about an hour which would hold up announcing the torrent as long
+const DefaultUDPMaxWait = time.Minute * 2
+
+// a connection id is good for this long after we got it
+const udpConnectionLifetime = time.Minute
+
+// most infohashes we scrape in one request, more don't fit in a safe datagram size
+const udpMaxScrape = 74
+
+// biggest reply we read
+const udpMaxPacket = 2048
+
+// ErrNoDatagrams is returned when announcing to a udp tracker over a network that can't send datagrams
+var ErrNoDatagrams = errors.New("network cannot send datagrams to udp trackers")
+
+// ErrUDPProxied is returned when a udp tracker would have to be reached through a proxy
+var ErrUDPProxied = errors.New("udp trackers cannot be reached through a proxy")
+
+// ErrUDPTimeout is returned when a udp tracker never replied, it wraps ErrTrackerTimeout
+var ErrUDPTimeout = fmt.Errorf("udp %w", ErrTrackerTimeout)
+
+// ErrUDPShortReply is returned when a udp tracker's reply is too short for what it says it is
+var ErrUDPShortReply = errors.New("short reply from udp tracker")
+
+// our connection id expired while waiting for a reply, we need a new one
+var errUDPConnExpired = errors.New("udp tracker connection id expired")
+
+// bep 15 udp tracker
+type UDPTracker struct {
+	u *url.URL
+	// how we reach this tracker
+	route Route
+	// how long we wait for the first reply
+	timeout time.Duration
+	// retransmits before giving up
+	retries int
+	// the longest an exchange takes, retransmits or not
+	maxWait time.Duration
+	// random key that lets the tracker know it's us if our address changes
+	key uint32
+}
+
+// create new udp tracker from url
+func NewUDPTracker(u *url.URL, proxy *ProxySettings) *UDPTracker {
+	t := &UDPTracker{
+		u:       u,
+		route:   proxy.RouteFor(u),
+		timeout: DefaultUDPTimeout,
+		retries: DefaultUDPRetries,
+		maxWait: DefaultUDPMaxWait,
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	t.key = binary.BigEndian.Uint32(key[:])
+	return t
+}
+
+// Route returns how we reach this tracker
+func (t *UDPTracker) Route() Route {
+	return t.route
+}
+
+func (t *UDPTracker) Name() string {
+	return t.u.String()
+}
+
+// open a datagram socket to the tracker over n
+func (t *UDPTracker) dial(n network.Network) (*udpSession, error) {
+	switch t.route {
+	case RouteNetwork:
+	case RouteBlock:
+		return nil, ErrTrackerBlocked
+	default:
+		return nil, ErrUDPProxied
+	}
+	pd, ok := n.(network.PacketDialer)
+	if !ok {
+		return nil, ErrNoDatagrams
+	}
+	c, err := pd.DialPacket(t.u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSession{
+		c:        c,
+		timeout:  t.timeout,
+		retries:  t.retries,
+		deadline: time.Now().Add(t.maxWait),
+	}, nil
+}
+
+// one exchange of requests with a udp tracker, the retransmit backoff carries over between requests
+type udpSession struct {
+	c net.Conn
+	// how long we wait for the next reply
+	timeout time.Duration
+	// retransmits left
+	retries int
+	// when we give up no matter how many retransmits are left
+	deadline time.Time
+	// connection id and when we got it
+	connID    uint64
+	connected time.Time
+}
+
+func udpTxID() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// send req and wait for the reply to it, retransmitting with backoff
+func (s *udpSession) roundTrip(req []byte) (resp []byte, err error) {
+	resps, errs, err := s.roundTripAll([][]byte{req})
+	if err == nil {
+		resp, err = resps[0], errs[0]
+	}
+	return
+}
+
+// send reqs of one action and wait for the replies to them, retransmitting the unanswered ones with backoff
+// errs holds what the tracker said to requests it refused, err is set when we gave up on the ones it didn't answer
+func (s *udpSession) roundTripAll(reqs [][]byte) (resps [][]byte, errs []error, err error) {
+	resps = make([][]byte, len(reqs))
+	errs = make([]error, len(reqs))
+	if len(reqs) == 0 {
+		return
+	}
+	action := binary.BigEndian.Uint32(reqs[0][8:])
+	// unanswered requests by transaction id
+	waiting := make(map[uint32]int)
+	for idx, req := range reqs {
+		waiting[binary.BigEndian.Uint32(req[12:])] = idx
+	}
+	for {
+		for _, idx := range waiting {
+			_, err = s.c.Write(reqs[idx])
+			if err != nil {
+				return
+			}
+		}
+		wait := s.timeout
+		if left := time.Until(s.deadline); left < wait {
+			wait = left
+		}
+		s.c.SetReadDeadline(time.Now().Add(wait))
+		for len(waiting) > 0 {
+			buf := make([]byte, udpMaxPacket)
+			var n int
+			n, err = s.c.Read(buf)
+			if err != nil {
+				break
+			}
+			if n < 8 {
+				continue
+			}
+			idx, ok := waiting[binary.BigEndian.Uint32(buf[4:])]
+			if !ok {
+				// stray reply to an earlier request
+				continue
+			}
+			switch binary.BigEndian.Uint32(buf) {
+			case action:
+				resps[idx] = buf[:n]
+			case udpActionError:
+				errs[idx] = errors.New(string(buf[8:n]))
+			default:
+				continue
+			}
+			delete(waiting, binary.BigEndian.Uint32(buf[4:]))
+		}
+		if len(waiting) == 0 {
+			err = nil
+			return
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return
+		}
+		if s.retries <= 0 || !time.Now().Before(s.deadline) {
+			err = ErrUDPTimeout
+			return
+		}
+		s.retries--
+		s.timeout *= 2
+		if action != udpActionConnect && time.Since(s.connected) > udpConnectionLifetime {
+			err = errUDPConnExpired
+			return
+		}
+	}
+}
+
+// get a connection id
+func (s *udpSession) connect() (err error) {
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req, udpProtocolID)
+	binary.BigEndian.PutUint32(req[8:], udpActionConnect)
+	binary.BigEndian.PutUint32(req[12:], udpTxID())
+	var resp []byte
+	resp, err = s.roundTrip(req)
+	if err == nil {
+		if len(resp) < 16 {
+			err = ErrUDPShortReply
+			return
+		}
+		s.connID = binary.BigEndian.Uint64(resp[8:])
+		s.connected = time.Now()
+	}
+	return
+}
+
+// send a request with a connection id that is still good, connecting again when needed
+func (s *udpSession) request(action uint32, body []byte) (resp []byte, err error) {
+	resps, errs := s.requestAll(action, [][]byte{body})
+	return resps[0], errs[0]
+}
+
+// send requests of one action together with a connection id that is still good, connecting again when needed
+// the replies and errors line up with bodies
+func (s *udpSession) requestAll(action uint32, bodies [][]byte) (resps [][]byte, errs []error) {
+	resps = make([][]byte, len(bodies))
+	errs = make([]error, len(bodies))
+	// the requests that didn't get a reply yet
+	pending := make([]int, len(bodies))
+	for idx := range pending {
+		pending[idx] = idx
+	}
+	for len(pending) > 0 {
+		var err error
+		if s.connected.IsZero() || time.Since(s.connected) > udpConnectionLifetime {
+			err = s.connect()
+		}
+		var got [][]byte
+		var refused []error
+		if err == nil {
+			reqs := make([][]byte, len(pending))
+			for i, idx := range pending {
+				req := make([]byte, 16, 16+len(bodies[idx]))
+				binary.BigEndian.PutUint64(req, s.connID)
+				binary.BigEndian.PutUint32(req[8:], action)
+				binary.BigEndian.PutUint32(req[12:], udpTxID())
+				reqs[i] = append(req, bodies[idx]...)
+			}
+			got, refused, err = s.roundTripAll(reqs)
+		}
+		var left []int
+		for i, idx := range pending {
+			switch {
+			case got != nil && got[i] != nil:
+				resps[idx] = got[i]
+			case refused != nil && refused[i] != nil:
+				errs[idx] = refused[i]
+			case err == errUDPConnExpired:
+				left = append(left, idx)
+			default:
+				errs[idx] = err
+			}
+		}
+		pending = left
+	}
+	return
+}
+
+// returns true if the tracker is talking to us over ipv6 so peers are 18 bytes
+func (s *udpSession) ipv6() bool {
+	host, _, err := net.SplitHostPort(s.c.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+func udpEvent(ev Event) uint32 {
+	switch ev {
+	case Completed:
+		return udpEventCompleted
+	case Started:
+		return udpEventStarted
+	case Stopped:
+		return udpEventStopped
+	}
+	return udpEventNone
+}
+
+// parse compact ip peers
+func parseUDPPeers(data []byte, ipLen int) (peers []common.Peer) {
+	sz := ipLen + 2
+	for len(data) >= sz {
+		ip := net.IP(append([]byte(nil), data[:ipLen]...))
+		peers = append(peers, common.Peer{
+			IP:   ip.String(),
+			Port: int(binary.BigEndian.Uint16(data[ipLen:])),
+		})
+		data = data[sz:]
+	}
+	return
+}
+
+// send announce via udp
+func (t *UDPTracker) Announce(req *Request) (resp *Response, err error) {
+	var s *udpSession
+	s, err = t.dial(req.GetNetwork())
+	if err == nil {
+		defer s.c.Close()
+		resp, err = t.announceOn(s, req)
+	} else {
+		resp, err = t.announced(req, nil, err)
+	}
+	return
+}
+
+// AnnounceBatch sends every announce over one session so they share a connection id
+// they go out together and we wait out retransmits once for all of them
+func (t *UDPTracker) AnnounceBatch(reqs []*Request) (resps []*Response, errs []error) {
+	resps = make([]*Response, len(reqs))
+	errs = make([]error, len(reqs))
+	if len(reqs) == 0 {
+		return
+	}
+	s, err := t.dial(reqs[0].GetNetwork())
+	if err != nil {
+		for idx, req := range reqs {
+			resps[idx], errs[idx] = t.announced(req, nil, err)
+		}
+		return
+	}
+	defer s.c.Close()
+	log.Debugf("%s announcing %d torrents at once", t.Name(), len(reqs))
+	bodies := make([][]byte, len(reqs))
+	for idx, req := range reqs {
+		bodies[idx] = t.announceBody(req)
+	}
+	replies, replyErrs := s.requestAll(udpActionAnnounce, bodies)
+	for idx, req := range reqs {
+		resps[idx], errs[idx] = t.announceReply(s, req, replies[idx], replyErrs[idx])
+	}
+	return
+}
+
+// announce req over s
+func (t *UDPTracker) announceOn(s *udpSession, req *Request) (*Response, error) {
+	log.Debugf("%s announcing", t.Name())
+	r, err := s.request(udpActionAnnounce, t.announceBody(req))
+	return t.announceReply(s, req, r, err)
+}
+
+// the body of an announce request for req
+func (t *UDPTracker) announceBody(req *Request) []byte {
+	body := make([]byte, 82)
+	copy(body, req.Infohash.Bytes())
+	copy(body[20:], req.PeerID.Bytes())
+	binary.BigEndian.PutUint64(body[40:], req.Downloaded)
+	binary.BigEndian.PutUint64(body[48:], req.Left)
+	binary.BigEndian.PutUint64(body[56:], req.Uploaded)
+	binary.BigEndian.PutUint32(body[64:], udpEvent(req.Event))
+	// ip 0 means use the one the datagram came from
+	binary.BigEndian.PutUint32(body[72:], t.key)
+	numwant := int32(req.NumWant)
+	if numwant <= 0 {
+		numwant = -1
+	}
+	binary.BigEndian.PutUint32(body[76:], uint32(numwant))
+	binary.BigEndian.PutUint16(body[80:], uint16(req.Port))
+	return body
+}
+
+// read the reply r to announcing req over s
+func (t *UDPTracker) announceReply(s *udpSession, req *Request, r []byte, err error) (*Response, error) {
+	if err == nil && len(r) < 20 {
+		err = ErrUDPShortReply
+	}
+	if err != nil {
+		return t.announced(req, nil, err)
+	}
+	ipLen := net.IPv4len
+	if s.ipv6() {
+		ipLen = net.IPv6len
+	}
+	return t.announced(req, &Response{
+		Interval:   int(binary.BigEndian.Uint32(r[8:])),
+		Incomplete: int(binary.BigEndian.Uint32(r[12:])),
+		Complete:   int(binary.BigEndian.Uint32(r[16:])),
+		Peers:      parseUDPPeers(r[20:], ipLen),
+	}, nil)
+}
+
+// log how announcing req went and say when to announce next, resp is nil if it failed
+func (t *UDPTracker) announced(req *Request, resp *Response, err error) (*Response, error) {
+	if resp == nil {
+		resp = &Response{
+			Complete:   -1,
+			Incomplete: -1,
+		}
+	}
+	if err == nil {
+		log.Infof("%s got %d peers for %s", t.Name(), len(resp.Peers), req.Infohash.Hex())
+	} else {
+		log.Warnf("%s got error while announcing: %s", t.Name(), err)
+	}
+	resp.setNextAnnounce(err)
+	return resp, err
+}
+
+// Scrape gets swarm stats for torrents from the udp tracker
+func (t *UDPTracker) Scrape(req *ScrapeRequest) (resp *ScrapeResponse, err error) {
+	var s *udpSession
+	s, err = t.dial(req.GetNetwork())
+	if err != nil {
+		return
+	}
+	defer s.c.Close()
+	log.Debugf("%s scraping %d torrents", t.Name(), len(req.Infohashes))
+	resp = &ScrapeResponse{
+		Files: make(map[common.Infohash]ScrapeStats),
+	}
+	ihs := req.Infohashes
+	for len(ihs) > 0 {
+		chunk := ihs
+		if len(chunk) > udpMaxScrape {
+			chunk = chunk[:udpMaxScrape]
+		}
+		ihs = ihs[len(chunk):]
+		body := make([]byte, 0, 20*len(chunk))
+		for _, ih := range chunk {
+			body = append(body, ih.Bytes()...)
+		}
+		var r []byte
+		r, err = s.request(udpActionScrape, body)
+		if err == nil && len(r) < 8+12*len(chunk) {
+			err = ErrUDPShortReply
+		}
+		if err != nil {
+			resp = nil
+			return
+		}
+		for idx, ih := range chunk {
+			st := r[8+12*idx:]
+			resp.Files[ih] = ScrapeStats{
+				Complete:   int(binary.BigEndian.Uint32(st)),
+				Downloaded: int(binary.BigEndian.Uint32(st[4:])),
+				Incomplete: int(binary.BigEndian.Uint32(st[8:])),
+			}
+		}
+	}
+	return
+}
+
+// udp tracker urls must have a port, there is no default one
+func checkUDPURL(u *url.URL) error {
+	_, port, err := net.SplitHostPort(u.Host)
+	if err == nil {
+		_, err = strconv.ParseUint(port, 10, 16)
+	}
+	if err != nil {
+		return fmt.Errorf("bad udp tracker %s: %s", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/majestrate/XD/lib/common"
+	"github.com/majestrate/XD/lib/network"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// network that only dials udp on loopback
+type udpTestNetwork struct {
+	network.Network
+}
+
+func (n udpTestNetwork) DialPacket(addr string) (net.Conn, error) {
+	return net.Dial("udp", addr)
+}
+
+// run a bep 15 tracker that drops the first datagram so we have to retransmit
+func runUDPTestTracker(t *testing.T, pc net.PacketConn) {
+	buf := make([]byte, udpMaxPacket)
+	dropped := false
+	const connID = 0x1234567890
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !dropped {
+			dropped = true
+			continue
+		}
+		if n < 16 {
+			t.Errorf("short request %d bytes", n)
+			continue
+		}
+		action := binary.BigEndian.Uint32(buf[8:])
+		reply := make([]byte, 8)
+		binary.BigEndian.PutUint32(reply, action)
+		copy(reply[4:], buf[12:16])
+		switch action {
+		case udpActionConnect:
+			if binary.BigEndian.Uint64(buf) != udpProtocolID {
+				t.Error("bad protocol id")
+			}
+			reply = append(reply, make([]byte, 8)...)
+			binary.BigEndian.PutUint64(reply[8:], connID)
+		case udpActionAnnounce:
+			if buf[16] == 0xff {
+				// a torrent the tracker never answers for
+				continue
+			}
+			if binary.BigEndian.Uint64(buf) != connID {
+				t.Error("bad connection id")
+			}
+			if n != 98 || binary.BigEndian.Uint32(buf[80:]) != udpEventStarted || binary.BigEndian.Uint16(buf[96:]) != 6881 {
+				t.Error("bad announce")
+			}
+			// interval, leechers, seeders then 2 peers
+			reply = append(reply, 0, 0, 0x07, 0x08, 0, 0, 0, 3, 0, 0, 0, 5)
+			reply = append(reply, 10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2)
+		case udpActionScrape:
+			if binary.BigEndian.Uint32(buf[16:]) == 0xffffffff {
+				reply[3] = udpActionError
+				reply = append(reply, "unknown torrent"...)
+				break
+			}
+			for idx := 16; idx+20 <= n; idx += 20 {
+				reply = append(reply, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3)
+			}
+		}
+		pc.WriteTo(reply, from)
+	}
+}
+
+func TestUDPTracker(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go runUDPTestTracker(t, pc)
+
+	u, _ := url.Parse("udp://" + pc.LocalAddr().String() + "/announce")
+	tr, ok := FromURL(u.String(), &ProxySettings{Rules: []ProxyRule{{Pattern: "127.0.0.1", Route: RouteNetwork}}}).(*UDPTracker)
+	if !ok {
+		t.Fatal("udp url did not make a udp tracker")
+	}
+	tr.timeout = time.Millisecond * 100
+	tr.retries = 2
+	getNetwork := func() network.Network {
+		return udpTestNetwork{}
+	}
+	var ih common.Infohash
+	ih[0] = 1
+	resp, err := tr.Announce(&Request{
+		Infohash:   ih,
+		Port:       6881,
+		Event:      Started,
+		GetNetwork: getNetwork,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Interval != 0x708 || resp.Incomplete != 3 || resp.Complete != 5 {
+		t.Fatalf("bad announce response %+v", resp)
+	}
+	if len(resp.Peers) != 2 || resp.Peers[0].IP != "10.0.0.1" || resp.Peers[0].Port != 6881 || resp.Peers[1].Port != 6882 {
+		t.Fatalf("bad peers %+v", resp.Peers)
+	}
+
+	resps, errs := tr.AnnounceBatch([]*Request{
+		{Infohash: ih, Port: 6881, Event: Started, GetNetwork: getNetwork},
+		{Infohash: ih, Port: 6881, Event: Started, GetNetwork: getNetwork},
+	})
+	for idx := range resps {
+		if errs[idx] != nil {
+			t.Fatal(errs[idx])
+		}
+		if len(resps[idx].Peers) != 2 {
+			t.Fatalf("batched announce %d got peers %+v", idx, resps[idx].Peers)
+		}
+	}
+
+	// an announce that gets no reply doesn't hold up the others
+	var silent common.Infohash
+	silent[0] = 0xff
+	started := time.Now()
+	resps, errs = tr.AnnounceBatch([]*Request{
+		{Infohash: silent, Port: 6881, Event: Started, GetNetwork: getNetwork},
+		{Infohash: ih, Port: 6881, Event: Started, GetNetwork: getNetwork},
+	})
+	if errs[0] != ErrUDPTimeout || errs[1] != nil || len(resps[1].Peers) != 2 {
+		t.Fatalf("batch with a silent torrent got %v %v", errs, resps[1])
+	}
+	// 100ms then 200ms then 400ms, once for the whole batch
+	if d := time.Since(started); d > time.Second {
+		t.Fatalf("batch took %s", d)
+	}
+
+	sresp, err := tr.Scrape(&ScrapeRequest{
+		Infohashes: []common.Infohash{ih},
+		GetNetwork: getNetwork,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := sresp.Files[ih]; st.Complete != 1 || st.Downloaded != 2 || st.Incomplete != 3 {
+		t.Fatalf("bad scrape stats %+v", st)
+	}
+	var unknown common.Infohash
+	for idx := range unknown {
+		unknown[idx] = 0xff
+	}
+	_, err = tr.Scrape(&ScrapeRequest{
+		Infohashes: []common.Infohash{unknown},
+		GetNetwork: getNetwork,
+	})
+	if err == nil || err.Error() != "unknown torrent" {
+		t.Fatalf("expected tracker error got %v", err)
+	}
+
+	// nothing listens here
+	u, _ = url.Parse("udp://127.0.0.1:1/announce")
+	tr = NewUDPTracker(u, &ProxySettings{Rules: []ProxyRule{{Pattern: "127.0.0.1", Route: RouteBlock}}})
+	_, err = tr.Announce(&Request{GetNetwork: getNetwork})
+	if err != ErrTrackerBlocked {
+		t.Fatalf("blocked udp tracker was not blocked: %v", err)
+	}
+}
+
+func TestUDPTrackerGivesUp(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reads everything and never replies
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, udpMaxPacket)
+		for {
+			if _, _, err := pc.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	u, _ := url.Parse("udp://" + pc.LocalAddr().String() + "/announce")
+	tr := NewUDPTracker(u, &ProxySettings{Rules: []ProxyRule{{Pattern: "127.0.0.1", Route: RouteNetwork}}})
+	tr.timeout = time.Millisecond * 100
+	tr.maxWait = time.Millisecond * 300
+	started := time.Now()
+	_, err = tr.Announce(&Request{Port: 6881, GetNetwork: func() network.Network {
+		return udpTestNetwork{}
+	}})
+	if !errors.Is(err, ErrUDPTimeout) {
+		t.Fatalf("silent tracker gave %v", err)
+	}
+	if d := time.Since(started); d > time.Second {
+		t.Fatalf("gave up after %s not the most we wait", d)
+	}
+}
+
+func TestUDPTrackerIPv6(t *testing.T) {
+	pc, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6 loopback")
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, udpMaxPacket)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 16 {
+				continue
+			}
+			action := binary.BigEndian.Uint32(buf[8:])
+			reply := make([]byte, 8)
+			binary.BigEndian.PutUint32(reply, action)
+			copy(reply[4:], buf[12:16])
+			if action == udpActionConnect {
+				reply = append(reply, make([]byte, 8)...)
+			} else {
+				// interval, leechers, seeders then one peer at [fd00::1]:6881
+				reply = append(reply, 0, 0, 0x07, 0x08, 0, 0, 0, 0, 0, 0, 0, 1)
+				peer := net.ParseIP("fd00::1")
+				reply = append(reply, peer...)
+				reply = append(reply, 0x1a, 0xe1)
+			}
+			pc.WriteTo(reply, from)
+		}
+	}()
+	u, _ := url.Parse("udp://" + pc.LocalAddr().String() + "/announce")
+	tr := NewUDPTracker(u, &ProxySettings{Rules: []ProxyRule{{Pattern: "::1", Route: RouteNetwork}}})
+	tr.timeout = time.Millisecond * 100
+	resp, err := tr.Announce(&Request{Port: 6881, GetNetwork: func() network.Network {
+		return udpTestNetwork{}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0].IP != "fd00::1" || resp.Peers[0].Port != 6881 {
+		t.Fatalf("bad ipv6 peers %+v", resp.Peers)
+	}
+}
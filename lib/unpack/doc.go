@@ -0,0 +1,2 @@
+// unpacking archives in completed downloads
+package unpack
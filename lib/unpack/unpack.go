@@ -0,0 +1,190 @@
+package unpack
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/util"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultRarCommand is the program rar archives are extracted with
+const DefaultRarCommand = "unrar"
+
+// ErrBadArchivePath is returned when an archive has files that would land outside of its directory
+var ErrBadArchivePath = errors.New("archive has a file outside of its directory")
+
+// State is how far unpacking a torrent got
+type State string
+
+// None means we never unpacked the torrent
+const None = State("")
+
+// Running means we are unpacking the torrent
+const Running = State("running")
+
+// Done means we unpacked the torrent
+const Done = State("done")
+
+// Failed means unpacking the torrent failed
+const Failed = State("failed")
+
+// Status is the unpacking status of one torrent
+type Status struct {
+	State State
+	// why it failed
+	Error string
+}
+
+// Config is what we do with downloads once they complete
+type Config struct {
+	// extract zip archives
+	Zip bool
+	// extract rar archives with RarCommand
+	Rar        bool
+	RarCommand string
+	// run after extracting with the download's directory as its only argument
+	Command string
+	// only unpack downloads in these directories, all of them if empty
+	Dirs []string
+}
+
+// Enabled returns true if there is anything to do with completed downloads
+func (c *Config) Enabled() bool {
+	return c != nil && (c.Zip || c.Rar || c.Command != "")
+}
+
+// Applies returns true if downloads in dir get unpacked
+func (c *Config) Applies(dir string) bool {
+	if !c.Enabled() {
+		return false
+	}
+	if len(c.Dirs) == 0 {
+		return true
+	}
+	dir = filepath.Clean(dir)
+	for _, d := range c.Dirs {
+		if filepath.Clean(d) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Run extracts the archives among files next to them then runs the command on dir
+func (c *Config) Run(dir string, files []string) (err error) {
+	for _, f := range files {
+		if c.Zip && isZip(f) {
+			log.Infof("unzipping %s", f)
+			err = unzip(f)
+		} else if c.Rar && isFirstRarVolume(f) {
+			log.Infof("unraring %s", f)
+			err = c.unrar(f)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", filepath.Base(f), err.Error())
+		}
+	}
+	if c.Command != "" {
+		log.Infof("running %s on %s", c.Command, dir)
+		err = run(exec.Command(c.Command, dir))
+	}
+	return
+}
+
+func isZip(fname string) bool {
+	return strings.HasSuffix(strings.ToLower(fname), ".zip")
+}
+
+var rarVolume = regexp.MustCompile(`\.part0*([0-9]+)\.rar$`)
+
+// multi volume rars are extracted from the first volume only
+func isFirstRarVolume(fname string) bool {
+	fname = strings.ToLower(fname)
+	if !strings.HasSuffix(fname, ".rar") {
+		return false
+	}
+	m := rarVolume.FindStringSubmatch(fname)
+	return m == nil || m[1] == "1"
+}
+
+func (c *Config) unrar(fname string) error {
+	cmd := c.RarCommand
+	if cmd == "" {
+		cmd = DefaultRarCommand
+	}
+	// extract with paths, never overwrite
+	return run(exec.Command(cmd, "x", "-o-", "-y", fname, filepath.Dir(fname)+string(os.PathSeparator)))
+}
+
+// run a command, the last line it printed is the error if it fails
+func run(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+			err = fmt.Errorf("%s: %s", err.Error(), last)
+		}
+	}
+	return err
+}
+
+// extract a zip next to it, files that are already there are left alone
+func unzip(fname string) (err error) {
+	var r *zip.ReadCloser
+	r, err = zip.OpenReader(fname)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	dest := filepath.Dir(fname)
+	for _, f := range r.File {
+		p := filepath.Join(dest, f.Name)
+		if !strings.HasPrefix(p, dest+string(os.PathSeparator)) {
+			return ErrBadArchivePath
+		}
+		if f.FileInfo().IsDir() {
+			err = util.EnsureDir(p)
+		} else if !util.CheckFile(p) {
+			err = unzipFile(f, p)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func unzipFile(f *zip.File, p string) (err error) {
+	err = util.EnsureDir(filepath.Dir(p))
+	if err != nil {
+		return
+	}
+	var r io.ReadCloser
+	r, err = f.Open()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	tmp := p + ".part"
+	var w *os.File
+	w, err = os.Create(tmp)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(w, r)
+	w.Close()
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package unpack
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeZip(t *testing.T, fname string, files map[string]string) {
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, body := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(body))
+	}
+	w.Close()
+	f.Close()
+}
+
+func TestUnzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xd-unpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "release.zip")
+	writeZip(t, fname, map[string]string{"sub/file.txt": "data"})
+	c := &Config{Zip: true}
+	err = c.Run(dir, []string{fname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "sub", "file.txt"))
+	if err != nil || string(data) != "data" {
+		t.Fatalf("extracted %q %v", data, err)
+	}
+
+	bad := filepath.Join(dir, "bad.zip")
+	writeZip(t, bad, map[string]string{"../escape.txt": "data"})
+	if err = c.Run(dir, []string{bad}); err == nil {
+		t.Fatal("extracted a file outside of the directory")
+	}
+}
+
+func TestFirstRarVolume(t *testing.T) {
+	for name, first := range map[string]bool{
+		"release.rar":         true,
+		"release.part1.rar":   true,
+		"release.part01.rar":  true,
+		"release.part2.rar":   false,
+		"release.part10.rar":  false,
+		"release.r00":         false,
+		"release.nfo":         false,
+		"Release.Part001.RAR": true,
+	} {
+		if isFirstRarVolume(name) != first {
+			t.Errorf("%s first volume is not %v", name, first)
+		}
+	}
+}
+
+func TestApplies(t *testing.T) {
+	var c *Config
+	if c.Applies("/downloads") {
+		t.Fatal("nil config applies")
+	}
+	c = &Config{Zip: true, Dirs: []string{"/downloads/scene/"}}
+	if !c.Applies("/downloads/scene") {
+		t.Fatal("does not apply to its directory")
+	}
+	if c.Applies("/downloads") {
+		t.Fatal("applies to other directories")
+	}
+}
@@ -0,0 +1,2 @@
+// checking an i2p hosted release feed for new versions of XD
+package update
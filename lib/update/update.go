@@ -0,0 +1,175 @@
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/majestrate/XD/lib/log"
+	"github.com/majestrate/XD/lib/network"
+	"github.com/majestrate/XD/lib/network/i2p"
+	"github.com/majestrate/XD/lib/sync"
+	"github.com/majestrate/XD/lib/version"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultInterval is how often we check the release feed
+const DefaultInterval = time.Hour * 24
+
+// how much of a release feed we read at most
+const maxFeedSize = 64 * 1024
+
+// ErrNotI2P is returned for a release feed not hosted on i2p, we never check for updates over the clearnet
+var ErrNotI2P = errors.New("release feed is not an i2p site")
+
+// ErrNoI2P is returned when we have no i2p session to check the release feed over
+var ErrNoI2P = errors.New("no i2p session to check for updates over")
+
+// Release is the latest release a release feed tells of, served as json
+type Release struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	Notes   string `json:"notes"`
+}
+
+// Status is what we know about updates
+type Status struct {
+	// false if no release feed is configured
+	Enabled bool
+	// unix time of the last check, 0 if we never checked
+	Checked int64
+	Latest  Release
+	// true if the latest release is newer than us
+	Available bool
+	// why the last check failed, empty if it didn't
+	Error string
+}
+
+// CheckFeed returns an error if feed may not be used as a release feed
+func CheckFeed(feed string) error {
+	u, err := url.Parse(feed)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" {
+		return fmt.Errorf("release feed must be an http url, not %q", feed)
+	}
+	if !strings.HasSuffix(u.Hostname(), ".i2p") {
+		return ErrNotI2P
+	}
+	return nil
+}
+
+// Checker checks a release feed for a newer release now and then
+// it only ever fetches the feed over i2p and sends nothing that tells who we are or what we run
+type Checker struct {
+	Feed     string
+	Interval time.Duration
+	// gets the network to fetch the feed over, blocks until there is one
+	Network func() network.Network
+	access  sync.Mutex
+	status  Status
+}
+
+// NewChecker makes a checker for a release feed that fetches it over getNet's network
+func NewChecker(feed string, interval time.Duration, getNet func() network.Network) (*Checker, error) {
+	err := CheckFeed(feed)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Checker{
+		Feed:     feed,
+		Interval: interval,
+		Network:  getNet,
+		status: Status{
+			Enabled: true,
+		},
+	}, nil
+}
+
+// Status gets what the last check found, a nil checker is disabled
+func (c *Checker) Status() (st Status) {
+	if c == nil {
+		return
+	}
+	c.access.Lock()
+	st = c.status
+	c.access.Unlock()
+	return
+}
+
+// fetch the release feed over n
+func (c *Checker) fetch(n network.Network) (r Release, err error) {
+	if _, ok := n.(i2p.Session); !ok {
+		err = ErrNoI2P
+		return
+	}
+	cl := &http.Client{
+		Transport: &http.Transport{
+			Dial:              n.Dial,
+			DisableKeepAlives: true,
+		},
+		Timeout: time.Minute * 2,
+	}
+	var req *http.Request
+	req, err = http.NewRequest(http.MethodGet, c.Feed, nil)
+	if err != nil {
+		return
+	}
+	// every XD looks the same to the feed
+	req.Header.Set("User-Agent", version.Name)
+	var resp *http.Response
+	resp, err = cl.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("release feed answered %s", resp.Status)
+		return
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxFeedSize)).Decode(&r)
+	return
+}
+
+// Check fetches the release feed now
+func (c *Checker) Check() error {
+	r, err := c.fetch(c.Network())
+	c.access.Lock()
+	c.status.Checked = time.Now().Unix()
+	if err == nil {
+		c.status.Latest = r
+		c.status.Available = version.Newer(r.Version)
+		c.status.Error = ""
+	} else {
+		c.status.Error = err.Error()
+	}
+	c.access.Unlock()
+	return err
+}
+
+// Run checks the release feed every interval while running returns true
+// the first check waits a random part of the interval so the feed can't tell when we started
+func (c *Checker) Run(running func() bool) {
+	wait := time.Duration(rand.Int63n(int64(c.Interval)))
+	for running() {
+		time.Sleep(wait)
+		if !running() {
+			return
+		}
+		err := c.Check()
+		if err != nil {
+			log.Warnf("failed to check for updates: %s", err.Error())
+		} else if st := c.Status(); st.Available {
+			log.Infof("update available: %s %s", st.Latest.Version, st.Latest.URL)
+		}
+		wait = c.Interval
+	}
+}
@@ -21,3 +21,14 @@ func FormatRate(rate float64) (str string) {
 	str = fmt.Sprintf("%.2f%s/sec", rate, rateUnits[rateIdx])
 	return
 }
+
+// FormatBytes formats a number of bytes as string with closest unit
+func FormatBytes(n uint64) string {
+	size := float64(n)
+	var idx int
+	for size > 1024.0 && idx < len(rateUnits)-1 {
+		size /= 1024.0
+		idx++
+	}
+	return fmt.Sprintf("%.2f%s", size, rateUnits[idx])
+}
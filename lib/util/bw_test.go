@@ -7,3 +7,12 @@ func TestFormatRate(t *testing.T) {
 	rate := float64(1000000.5)
 	t.Logf("rate %f %s", rate, FormatRate(rate))
 }
+
+func TestFormatBytes(t *testing.T) {
+	if s := FormatBytes(1536); s != "1.50KB" {
+		t.Fatalf("1536 bytes formatted as %s", s)
+	}
+	if s := FormatBytes(0); s != "0.00B" {
+		t.Fatalf("0 bytes formatted as %s", s)
+	}
+}
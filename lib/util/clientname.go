@@ -1,7 +1,125 @@
 package util
 
-func ClientNameFromID(id []byte) (name string) {
-	// TODO: implement
-	name = "idklol"
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// names of clients by the two letters they put in azureus style peer ids like -qB4520-
+var azureusClients = map[string]string{
+	"XD": "XD",
+	"AZ": "Vuze",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"qB": "qBittorrent",
+	"TR": "Transmission",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+	"AG": "Ares",
+	"TL": "Tribler",
+	"PI": "PicoTorrent",
+}
+
+// MaxClientNameLen is how long a client name we keep, longer ones are cut
+const MaxClientNameLen = 64
+
+// version digit in a peer id, letters count on from 10 like some clients use them
+func versionDigit(b byte) (n int, ok bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return int(b - '0'), true
+	case b >= 'A' && b <= 'Z':
+		return int(b-'A') + 10, true
+	case b >= 'a' && b <= 'z':
+		return int(b-'a') + 10, true
+	}
 	return
 }
+
+// name and version of an azureus style peer id like -qB4520-
+func azureusName(id []byte) (name string, ok bool) {
+	if len(id) < 8 || id[0] != '-' || id[7] != '-' {
+		return
+	}
+	code := string(id[1:3])
+	for _, c := range code {
+		if c > unicode.MaxASCII || !unicode.IsLetter(c) {
+			return
+		}
+	}
+	var nums []string
+	for _, b := range id[3:7] {
+		n, isDigit := versionDigit(b)
+		if !isDigit {
+			return
+		}
+		nums = append(nums, fmt.Sprintf("%d", n))
+	}
+	// most clients leave the last number at 0
+	if nums[3] == "0" {
+		nums = nums[:3]
+	}
+	name, known := azureusClients[code]
+	if !known {
+		name = code
+	}
+	return name + " " + strings.Join(nums, "."), true
+}
+
+// name and version of a mainline style peer id like M4-3-6--
+func mainlineName(id []byte) (name string, ok bool) {
+	if len(id) < 8 || id[0] != 'M' {
+		return
+	}
+	// the version is padded to 8 bytes with dashes
+	v := string(id[1:8])
+	if !strings.HasSuffix(v, "-") {
+		return
+	}
+	nums := strings.Split(strings.TrimRight(v, "-"), "-")
+	for _, n := range nums {
+		if n == "" {
+			return
+		}
+		for _, b := range []byte(n) {
+			if b < '0' || b > '9' {
+				return
+			}
+		}
+	}
+	return "BitTorrent " + strings.Join(nums, "."), true
+}
+
+// ClientNameFromID gets the name and version of the client that made a peer id, "unknown" if it is in no format we know
+func ClientNameFromID(id []byte) (name string) {
+	var ok bool
+	if name, ok = azureusName(id); ok {
+		return
+	}
+	if name, ok = mainlineName(id); ok {
+		return
+	}
+	return "unknown"
+}
+
+// CleanClientName makes a client name a peer sent us safe to show, dropping control characters and cutting it to MaxClientNameLen
+func CleanClientName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, name)
+	name = strings.TrimSpace(name)
+	if r := []rune(name); len(r) > MaxClientNameLen {
+		name = string(r[:MaxClientNameLen])
+	}
+	return name
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientNameFromID(t *testing.T) {
+	for id, name := range map[string]string{
+		"-XD0420-abcdefghijkl":         "XD 0.4.2",
+		"-qB4521-abcdefghijkl":         "qBittorrent 4.5.2.1",
+		"-ZZ1230-abcdefghijkl":         "ZZ 1.2.3",
+		"-TR30A0-abcdefghijkl":         "Transmission 3.0.10",
+		"M4-3-6--abcdefghijkl":         "BitTorrent 4.3.6",
+		"M7-10-2-abcdefghijkl":         "BitTorrent 7.10.2",
+		"\x00\x00\x00abcdefghijklmnop": "unknown",
+		"-q\x004520-abcdefghijkl":      "unknown",
+		"":                             "unknown",
+	} {
+		if got := ClientNameFromID([]byte(id)); got != name {
+			t.Errorf("client of %q is %q not %q", id, got, name)
+		}
+	}
+}
+
+func TestCleanClientName(t *testing.T) {
+	if n := CleanClientName(" qBittorrent/4.5.2\x1b[31m\n"); n != "qBittorrent/4.5.2[31m" {
+		t.Fatalf("cleaned to %q", n)
+	}
+	if n := CleanClientName(strings.Repeat("x", MaxClientNameLen*2)); len(n) != MaxClientNameLen {
+		t.Fatalf("kept %d characters", len(n))
+	}
+}
@@ -3,6 +3,9 @@ package version
 import (
 	"fmt"
 	"github.com/majestrate/XD/lib/constants"
+	"runtime"
+	"strconv"
+	"strings"
 )
 
 const Name = "XD"
@@ -22,3 +25,60 @@ func Version() string {
 	}
 	return v
 }
+
+// Info is what build of XD is running
+type Info struct {
+	Version   string
+	Git       string
+	GoVersion string
+	OS        string
+	Arch      string
+}
+
+// GetInfo gets the version and build of the running XD
+func GetInfo() Info {
+	return Info{
+		Version:   Version(),
+		Git:       Git,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// parse a version like 0.4.2 or XD-0.4.2-gitrev into its numbers
+func parse(v string) (nums [3]int, ok bool) {
+	v = strings.TrimPrefix(v, Name+"-")
+	v = strings.TrimPrefix(v, "v")
+	if idx := strings.IndexAny(v, "-+ "); idx >= 0 {
+		v = v[:idx]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return
+	}
+	for idx, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return
+		}
+		nums[idx] = n
+	}
+	ok = true
+	return
+}
+
+// Newer returns true if version v is a later release than the running XD, false if v is not a version
+func Newer(v string) bool {
+	theirs, ok := parse(v)
+	if !ok {
+		return false
+	}
+	ours, _ := parse(fmt.Sprintf("%s.%s.%s", Major, Minor, Patch))
+	for idx := range theirs {
+		if theirs[idx] != ours[idx] {
+			return theirs[idx] > ours[idx]
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package version
+
+import (
+	"testing"
+)
+
+func TestNewer(t *testing.T) {
+	Major, Minor, Patch = "0", "4", "2"
+	for v, newer := range map[string]bool{
+		"0.4.3":          true,
+		"0.5.0":          true,
+		"1.0.0":          true,
+		"XD-0.4.10-abcd": true,
+		"v0.4.3":         true,
+		"0.4.2":          false,
+		"0.4.1":          false,
+		"0.3.9":          false,
+		"0.4":            false,
+		"garbage":        false,
+		"":               false,
+	} {
+		if Newer(v) != newer {
+			t.Errorf("Newer(%q) should be %v", v, newer)
+		}
+	}
+}